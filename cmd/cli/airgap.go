@@ -0,0 +1,367 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"my-pki/internal/bundle"
+	"my-pki/internal/inventory"
+	"my-pki/internal/queue"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// bundle-requests
+var bundleRequestsCmd = &cobra.Command{
+	Use:   "bundle-requests [id...]",
+	Short: "Pack pending requests from the queue into a signed bundle to carry to the offline CA.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			return invalid(errors.New("must specify --out for the request bundle"))
+		}
+		signKey, _ := cmd.Flags().GetString("sign-key")
+		if signKey == "" {
+			return invalid(errors.New("must specify --sign-key for the operator key that signs the bundle"))
+		}
+		store := requestQueue(cmd)
+		var reqs []*queue.Request
+		if len(args) == 0 {
+			all, err := store.List()
+			if err != nil {
+				return err
+			}
+			for _, req := range all {
+				if req.Status == queue.StatusPending {
+					reqs = append(reqs, req)
+				}
+			}
+			if len(reqs) == 0 {
+				return fmt.Errorf("no pending requests in queue '%s'", store.Dir)
+			}
+		}
+		for _, id := range args {
+			req, err := store.Get(id)
+			if err != nil {
+				return err
+			}
+			if req.Status != queue.StatusPending {
+				return fmt.Errorf("request '%s' is already %s", req.ID, req.Status)
+			}
+			reqs = append(reqs, req)
+		}
+		key, err := utils.LoadPrivateKeyFromFile(signKey, utils.PromptPassphrase(signKey))
+		if err != nil {
+			return err
+		}
+
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		a := &bundle.Airgap{}
+		a.Kind = bundle.KindRequests
+		a.Created = now.UTC()
+		for _, req := range reqs {
+			csrPEM, err := os.ReadFile(store.CSRPath(req.ID))
+			if err != nil {
+				return fmt.Errorf("unable to read CSR of request '%s': %w", req.ID, err)
+			}
+			a.Requests = append(a.Requests, bundle.AirgapRequest{
+				ID:        req.ID,
+				Requester: req.Requester,
+				Note:      req.Note,
+				Subject:   req.Subject,
+				Names:     req.Names,
+				CSR:       a.Add(req.ID+".csr", csrPEM),
+			})
+		}
+		if err := bundle.WriteAirgap(out, a, key); err != nil {
+			return err
+		}
+		signerFP, err := publicKeySHA256(key.Public())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Request bundle written to %s with %d request(s)\n - Bundle ID: %s\n - Signer key SHA-256: %s\n", out, len(reqs), a.ID, signerFP)
+		return nil
+	},
+}
+
+// sign-bundle
+var signBundleCmd = &cobra.Command{
+	Use:   "sign-bundle",
+	Short: "On the offline CA, verify a request bundle, sign its CSRs in one quorum session and write a signed bundle to carry back.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, _ := cmd.Flags().GetString("in")
+		if in == "" {
+			return invalid(errors.New("must specify --in for the request bundle"))
+		}
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			return invalid(errors.New("must specify --out for the signed bundle"))
+		}
+		outDir, _ := cmd.Flags().GetString("out-dir")
+		if outDir == "" {
+			return invalid(errors.New("must specify --out-dir for the CSRs and certificates kept on this machine"))
+		}
+		if subjectFlagsChanged(cmd) || cmd.Flags().Changed("san") {
+			return errors.New("subject and --san overrides apply to a single request and cannot be used with a bundle")
+		}
+		if _, err := os.Stat(out); err == nil {
+			return fmt.Errorf("'%s' already exists", out)
+		}
+		req, err := bundle.ReadAirgap(in, bundle.KindRequests)
+		if err != nil {
+			return err
+		}
+		if err := checkBundleSigner(cmd, req.SignerKey); err != nil {
+			return err
+		}
+
+		// Keep the requests next to their certificates, so the offline machine has a record of both
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory '%s': %w", outDir, err)
+		}
+		var jobs []*csrJob
+		for _, r := range req.Requests {
+			if r.ID != filepath.Base(r.ID) || strings.ContainsAny(r.ID, `.\/`) {
+				return fmt.Errorf("invalid request ID '%s' in bundle", r.ID)
+			}
+			job := &csrJob{csrIn: filepath.Join(outDir, r.ID+".csr"), certOut: filepath.Join(outDir, r.ID+".crt")}
+			for _, p := range []string{job.csrIn, job.certOut} {
+				if _, err := os.Stat(p); err == nil {
+					return fmt.Errorf("'%s' already exists; was this bundle signed before?", p)
+				}
+			}
+			jobs = append(jobs, job)
+		}
+		var problems []string
+		for i, job := range jobs {
+			if err := os.WriteFile(job.csrIn, req.Files[req.Requests[i].CSR], 0644); err != nil {
+				return fmt.Errorf("failed to write '%s': %w", job.csrIn, err)
+			}
+			if err := job.load(cmd); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", req.Requests[i].ID, err))
+			}
+		}
+		if len(problems) > 0 {
+			for _, job := range jobs {
+				os.Remove(job.csrIn)
+			}
+			return fmt.Errorf("no request was signed:\n%s", strings.Join(problems, "\n"))
+		}
+
+		caKey, issueErr := issueCSRJobs(cmd, jobs)
+		if caKey == nil {
+			return issueErr
+		}
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		caBytes, err := os.ReadFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %w", caPem, err)
+		}
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		approver, _ := cmd.Flags().GetString("approver")
+		signed := &bundle.Airgap{}
+		signed.Kind = bundle.KindSigned
+		signed.Created = now.UTC()
+		signed.Answers = req.ID
+		signed.CA = signed.Add("ca.pem", caBytes)
+		for i, r := range req.Requests {
+			r.CSR = ""
+			r.Approver = operatorName(approver)
+			switch job := jobs[i]; {
+			case job.certPEM != nil:
+				r.Cert = signed.Add(r.ID+".crt", job.certPEM)
+			case job.vetoed != nil:
+				r.Error = job.vetoed.Error()
+			default:
+				r.Error = "not signed"
+			}
+			signed.Requests = append(signed.Requests, r)
+		}
+		if err := bundle.WriteAirgap(out, signed, caKey); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Signed bundle written to %s\n - Answers request bundle: %s\n - Bundle ID: %s\n", out, req.ID, signed.ID)
+		return issueErr
+	},
+}
+
+// import-signed
+var importSignedCmd = &cobra.Command{
+	Use:   "import-signed",
+	Short: "Verify a signed bundle from the offline CA and file its certificates with the queued requests.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, _ := cmd.Flags().GetString("in")
+		if in == "" {
+			return invalid(errors.New("must specify --in for the signed bundle"))
+		}
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the CA expected to have signed the bundle"))
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+		a, err := bundle.ReadAirgap(in, bundle.KindSigned)
+		if err != nil {
+			return err
+		}
+		bundleCA, err := parseCertPEM(a.Files[a.CA])
+		if err != nil {
+			return fmt.Errorf("invalid CA certificate in bundle: %w", err)
+		}
+		if !bundleCA.Equal(caCert) {
+			return fmt.Errorf("bundle was issued by %s, not by the CA in '%s'", bundleCA.Subject, caPem)
+		}
+		if signer, ok := a.SignerKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !signer.Equal(caCert.PublicKey) {
+			return fmt.Errorf("bundle is not signed by the key of %s", caCert.Subject)
+		}
+
+		// Check every certificate before filing any, so a bad bundle leaves the queue untouched
+		store := requestQueue(cmd)
+		type result struct {
+			req  *queue.Request
+			cert *x509.Certificate
+			pem  []byte
+			err  string
+			by   string
+		}
+		var results []result
+		for _, r := range a.Requests {
+			req, err := store.Get(r.ID)
+			if err != nil {
+				return err
+			}
+			if req.Status != queue.StatusPending {
+				return fmt.Errorf("request '%s' is already %s", req.ID, req.Status)
+			}
+			if r.Cert == "" {
+				results = append(results, result{req: req, err: r.Error, by: r.Approver})
+				continue
+			}
+			cert, err := parseCertPEM(a.Files[r.Cert])
+			if err != nil {
+				return fmt.Errorf("invalid certificate for request '%s': %w", r.ID, err)
+			}
+			if err := cert.CheckSignatureFrom(caCert); err != nil {
+				return fmt.Errorf("certificate for request '%s' was not issued by %s: %w", r.ID, caCert.Subject, err)
+			}
+			csr, err := utils.ReadCSRFile(store.CSRPath(r.ID))
+			if err != nil {
+				return err
+			}
+			if pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(csr.PublicKey) {
+				return fmt.Errorf("certificate for request '%s' does not certify the key of its CSR", r.ID)
+			}
+			results = append(results, result{req: req, cert: cert, pem: a.Files[r.Cert], by: r.Approver})
+		}
+
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		issued := 0
+		err = updateInventory(cmd, func(db *inventory.DB) error {
+			db.AddCA(caCert, caPem)
+			for _, res := range results {
+				if res.cert == nil {
+					if err := res.req.Decide(queue.StatusDenied, res.by, res.err, now); err != nil {
+						return err
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), " - %s: not issued (%s)\n", res.req.ID, res.err)
+				} else {
+					if err := utils.WriteCertificateToFile(res.pem, store.CertPath(res.req.ID)); err != nil {
+						return fmt.Errorf("failed to write certificate for request '%s': %w", res.req.ID, err)
+					}
+					if err := res.req.Decide(queue.StatusIssued, res.by, "signed offline, bundle "+a.ID, now); err != nil {
+						return err
+					}
+					res.req.CertSHA256 = inventory.Fingerprint(res.cert)
+					db.AddCertificate(res.cert, caCert)
+					fmt.Fprintf(cmd.OutOrStdout(), " - %s: %s -> %s\n", res.req.ID, res.cert.Subject, store.CertPath(res.req.ID))
+					issued++
+				}
+				if err := store.Save(res.req); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Imported %d certificate(s) from %s (bundle %s, answering %s)\n", issued, in, a.ID, a.Answers)
+		return nil
+	},
+}
+
+// checkBundleSigner verifies the request bundle signer against --trust or --trust-sha256.
+func checkBundleSigner(cmd *cobra.Command, signer crypto.PublicKey) error {
+	trust, _ := cmd.Flags().GetString("trust")
+	trustSHA, _ := cmd.Flags().GetString("trust-sha256")
+	if (trust == "") == (trustSHA == "") {
+		return invalid(errors.New("must specify either --trust or --trust-sha256 for the operator key that signed the bundle"))
+	}
+	fp, err := publicKeySHA256(signer)
+	if err != nil {
+		return err
+	}
+	if trust != "" {
+		pub, err := utils.ParsePublicKeyFile(trust)
+		if err != nil {
+			return err
+		}
+		if trustSHA, err = publicKeySHA256(pub); err != nil {
+			return err
+		}
+	}
+	if !strings.EqualFold(strings.ReplaceAll(trustSHA, ":", ""), fp) {
+		return fmt.Errorf("bundle is signed by key %s, which is not the trusted operator key", fp)
+	}
+	return nil
+}
+
+// publicKeySHA256 returns the hex SHA-256 of the DER-encoded SubjectPublicKeyInfo of pub.
+func publicKeySHA256(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func init() {
+	bundleRequestsCmd.Flags().String("queue", queue.DefaultDir, "Directory holding the request queue")
+	bundleRequestsCmd.Flags().String("sign-key", "", "Operator private key (PEM) that signs the bundle")
+	bundleRequestsCmd.Flags().String("out", "", "File path for the request bundle (.tar.gz)")
+	rootCmd.AddCommand(bundleRequestsCmd)
+
+	addCSRSigningFlags(signBundleCmd)
+	signBundleCmd.Flags().String("in", "", "Request bundle produced by bundle-requests")
+	signBundleCmd.Flags().String("trust", "", "Public key (PEM) of the operator expected to have signed the request bundle")
+	signBundleCmd.Flags().String("trust-sha256", "", "SHA-256 of the operator's public key, as printed by bundle-requests, instead of --trust")
+	signBundleCmd.Flags().String("out", "", "File path for the signed bundle (.tar.gz)")
+	signBundleCmd.Flags().String("out-dir", "", "Directory for the copies of the CSRs and certificates kept on this machine")
+	signBundleCmd.Flags().String("approver", "", "Who approves the requests (default: current user)")
+	rootCmd.AddCommand(signBundleCmd)
+
+	importSignedCmd.Flags().String("in", "", "Signed bundle produced by sign-bundle")
+	importSignedCmd.Flags().String("ca-pem", "", "File path to the CA certificate expected to have signed the bundle (PEM)")
+	importSignedCmd.Flags().String("queue", queue.DefaultDir, "Directory holding the request queue")
+	rootCmd.AddCommand(importSignedCmd)
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/api"
+	"my-pki/internal/audit"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/cmp"
+	"my-pki/internal/dist"
+	"my-pki/internal/est"
+	"my-pki/internal/grpcca"
+	"my-pki/internal/inventory"
+	"my-pki/internal/secmem"
+	"my-pki/internal/utils"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// apiBackend issues and revokes certificates of one CA for the API servers.
+type apiBackend struct {
+	cmd    *cobra.Command
+	caPem  string
+	caCert *x509.Certificate
+	caKey  crypto.Signer
+	chain  []*x509.Certificate
+	// limiter holds the daily quotas, which every front end's issuances count against
+	limiter *api.Limiter
+	// mu serializes the calls: the inventory is one file, and auditOperator names the caller
+	mu sync.Mutex
+}
+
+// refused marks err as a request the CA policy does not allow.
+func refused(err error) error {
+	return api.WithStatus(http.StatusForbidden, err)
+}
+
+// badRequest marks err as a malformed request.
+func badRequest(err error) error {
+	return api.WithStatus(http.StatusBadRequest, err)
+}
+
+// as takes the backend for a call by client, recorded as the operator of the audit entries written
+// until the returned function is called.
+func (b *apiBackend) as(client string) func() {
+	b.mu.Lock()
+	auditOperator = client
+	return func() {
+		auditOperator = ""
+		b.mu.Unlock()
+	}
+}
+
+func (b *apiBackend) Issue(client string, req *api.IssueRequest) (*api.Issued, error) {
+	defer b.as(client)()
+	profile := req.Profile
+	if profile == "" {
+		profile = caconfig.ProfileLeaf
+	}
+	if err := b.limiter.CheckQuota(profile); err != nil {
+		return nil, err
+	}
+	requested, _ := b.cmd.Flags().GetInt("days")
+	explicit := b.cmd.Flags().Changed("days")
+	if req.Days > 0 {
+		requested, explicit = req.Days, true
+	}
+	days, settings, err := resolveProfileDays(b.cmd, b.caPem, profile, requested, explicit)
+	if err != nil {
+		return nil, refused(err)
+	}
+	ku, err := profileKeyUsage(b.cmd, profile, settings)
+	if err != nil {
+		return nil, refused(err)
+	}
+	if ku == 0 {
+		ku = x509.KeyUsageDigitalSignature
+	}
+	opts, err := issuanceOptions(b.cmd, settings, days)
+	if err != nil {
+		return nil, err
+	}
+
+	// With a CSR or a template its key is certified and its names are the default
+	var subject pkix.Name
+	var sans utils.SANs
+	var pub crypto.PublicKey
+	csr := req.Template
+	if req.CSR != "" {
+		if csr, err = utils.DecodeCSRPEM([]byte(req.CSR)); err != nil {
+			return nil, badRequest(err)
+		}
+		if err := csr.CheckSignature(); err != nil {
+			return nil, badRequest(fmt.Errorf("certificate request signature is invalid: %w", err))
+		}
+	}
+	if csr != nil {
+		if err := utils.CheckKeyAlgorithm(csr.PublicKey, settings.KeyAlgorithm); err != nil {
+			return nil, refused(fmt.Errorf("profile '%s': %w", profile, err))
+		}
+		subject, sans, pub = csr.Subject, utils.SANsFromCSR(csr), csr.PublicKey
+	}
+	if req.Subject != nil {
+		subject = req.Subject.Name()
+	}
+	if len(req.SANs) > 0 {
+		if sans, err = utils.ParseSANs(req.SANs); err != nil {
+			return nil, badRequest(err)
+		}
+	}
+	norm := settings.Normalization()
+	subject, sans = norm.Subject(subject), norm.SANs(sans)
+	if err := settings.CheckSANs(sans); err != nil {
+		return nil, refused(fmt.Errorf("profile '%s': %w", profile, err))
+	}
+	hookReq, err := newHookRequest(b.cmd, b.caPem, profile, subject, &x509.Certificate{
+		DNSNames: sans.DNSNames, IPAddresses: sans.IPAddresses, EmailAddresses: sans.EmailAddresses, URIs: sans.URIs,
+	}, days)
+	if err != nil {
+		return nil, err
+	}
+	if err := preIssueHooks(settings, hookReq); err != nil {
+		return nil, refused(err)
+	}
+
+	var key *ecdsa.PrivateKey
+	if pub == nil {
+		if key, err = utils.GenerateKey(settings.KeyAlgorithm); err != nil {
+			return nil, err
+		}
+		defer secmem.WipeKey(key)
+		pub = &key.PublicKey
+	}
+	opts = append(opts, utils.WithSANs(sans))
+	certPEM, err := utils.SignPublicKey(subject, pub, b.caCert, b.caKey, false, days, ku, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	if err := logIssuance(b.cmd, b.caPem, certPEM, b.caKey); err != nil {
+		return nil, err
+	}
+	if err := recordLocation(b.cmd, certPEM, "", profile); err != nil {
+		return nil, err
+	}
+	b.limiter.Issued(profile)
+	postIssueHooks(settings, hookReq, certPEM, "")
+
+	cert, err := parseCertPEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	issued := &api.Issued{
+		Certificate: string(certPEM),
+		Chain:       string(api.EncodeChain(b.chain)),
+		Subject:     cert.Subject.String(),
+		Serial:      hex.EncodeToString(cert.SerialNumber.Bytes()),
+		SHA256:      inventory.Fingerprint(cert),
+		NotBefore:   cert.NotBefore.UTC(),
+		NotAfter:    cert.NotAfter.UTC(),
+		Profile:     profile,
+	}
+	if key != nil {
+		keyPEM, err := utils.MarshalPrivateKeyPEM(key, utils.KeyFormatPKCS8)
+		if err != nil {
+			return nil, err
+		}
+		issued.PrivateKey = string(keyPEM)
+	}
+	return issued, nil
+}
+
+func (b *apiBackend) Revoke(client string, id string, reason int) (*inventory.CertRecord, error) {
+	defer b.as(client)()
+	var rec *inventory.CertRecord
+	var entry audit.Entry
+	err := updateInventory(b.cmd, func(db *inventory.DB) error {
+		var err error
+		if rec, err = apiRecord(db, id); err != nil {
+			return err
+		}
+		if rec.SHA256 == rec.IssuerSHA256 {
+			return refused(fmt.Errorf("%s is a self-signed root and cannot be revoked by a CRL; retire it with compromise or rollover", rec.Subject))
+		}
+		if rec.IssuerSHA256 != inventory.Fingerprint(b.caCert) {
+			return refused(fmt.Errorf("%s was not issued by %s", rec.Subject, b.caCert.Subject))
+		}
+		if rec.Status == inventory.StatusRevoked && (!rec.OnHold() || reason == inventory.ReasonCertificateHold) {
+			return nil
+		}
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		rec.Revoke(now, reason)
+		entry = certAuditEntry(db, audit.EventRevoke, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if entry.Event == "" {
+		return rec, nil
+	}
+	slog.Info("certificate revoked", "subject", rec.Subject, "serial", rec.Serial, "reason", inventory.ReasonName(reason), "client", auditOperator)
+	entry.Params["reason"] = inventory.ReasonName(reason)
+	if err := recordAudit(b.cmd, entry); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (b *apiBackend) Certificates(q inventory.Query) ([]*inventory.CertRecord, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	db, err := openInventory(b.cmd)
+	if err != nil {
+		return nil, err
+	}
+	if q.IssuerSHA256 != "" {
+		ca, err := db.FindCA(q.IssuerSHA256)
+		if err != nil {
+			return nil, badRequest(err)
+		}
+		q.IssuerSHA256 = ca.SHA256
+	}
+	return db.Search(q)
+}
+
+func (b *apiBackend) Certificate(id string) (*inventory.CertRecord, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	db, err := openInventory(b.cmd)
+	if err != nil {
+		return nil, err
+	}
+	return apiRecord(db, id)
+}
+
+func (b *apiBackend) Chain() []*x509.Certificate {
+	return b.chain
+}
+
+// CRL returns the latest CRL that gen-crl wrote where serve-dist publishes it.
+func (b *apiBackend) CRL(delta bool) ([]byte, error) {
+	path := dist.CRLPath(b.caPem)
+	if delta {
+		path = dist.DeltaCRLPath(b.caPem)
+	}
+	der, _, err := dist.ReadCRL(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, api.WithStatus(http.StatusNotFound, fmt.Errorf("no CRL at '%s'; run gen-crl first", path))
+	}
+	return der, err
+}
+
+// apiRecord finds the record of a certificate by its SHA-256 fingerprint or its hex serial number.
+func apiRecord(db *inventory.DB, id string) (*inventory.CertRecord, error) {
+	if len(id) == 64 {
+		if rec := db.Certificate(strings.ToLower(id)); rec != nil {
+			return rec, nil
+		}
+	}
+	recs, err := db.BySerial(id)
+	if err != nil {
+		return nil, badRequest(fmt.Errorf("'%s' is neither a SHA-256 fingerprint nor a serial number", id))
+	}
+	switch len(recs) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", api.ErrNotFound, id)
+	case 1:
+		return recs[0], nil
+	}
+	return nil, api.WithStatus(http.StatusConflict, fmt.Errorf("serial %s was issued by %d CAs; use the SHA-256 fingerprint", id, len(recs)))
+}
+
+// serveCmd runs the HTTPS JSON API of one CA.
+var serveCmd = &cobra.Command{
+	Use:         "serve",
+	Short:       "Serve issuance, CSR signing, revocation, inspection and the inventory of a CA over an HTTPS JSON API with client certificate or API token authentication.",
+	Annotations: map[string]string{annotationServer: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		certFile, _ := cmd.Flags().GetString("tls-cert")
+		keyFile, _ := cmd.Flags().GetString("tls-key")
+		clientCA, _ := cmd.Flags().GetString("client-ca")
+		if certFile == "" || keyFile == "" || clientCA == "" {
+			return invalid(errors.New("must specify --tls-cert, --tls-key and --client-ca"))
+		}
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the signing CA certificate"))
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+		chain := []*x509.Certificate{caCert}
+		if chainFile, _ := cmd.Flags().GetString("chain"); chainFile != "" {
+			certs, err := utils.ParseCertificatesFromFile(chainFile)
+			if err != nil {
+				return err
+			}
+			for _, c := range certs {
+				if !c.Equal(caCert) {
+					chain = append(chain, c)
+				}
+			}
+		}
+		tlsConfig, err := api.ServerTLSConfig(certFile, keyFile, clientCA)
+		if err != nil {
+			return err
+		}
+		var access *api.Config
+		if configFile, _ := cmd.Flags().GetString("config"); configFile != "" {
+			if access, err = api.LoadConfig(configFile); err != nil {
+				return err
+			}
+			// Token holders come without a client certificate
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		// The server runs unattended, so the key is reconstructed once and kept for its lifetime
+		sharesInStr, _ := cmd.Flags().GetString("shares-in")
+		caKeyPath, _ := cmd.Flags().GetString("ca-key")
+		caKey, err := loadCAKey(cmd, caPem, sharesInStr, caKeyPath, "--shares-in", "--ca-key")
+		if err != nil {
+			return fmt.Errorf("failed to load CA private key: %w", err)
+		}
+		var limits api.Limits
+		if access != nil {
+			limits = access.Limits
+		}
+		limiter := api.NewLimiter(limits)
+		backend := &apiBackend{cmd: cmd, caPem: caPem, caCert: caCert, caKey: caKey, chain: chain, limiter: limiter}
+		// Issuances of today so far count against the quotas, also after a restart
+		issued, err := backend.Certificates(inventory.Query{IssuerSHA256: inventory.Fingerprint(caCert)})
+		if err != nil {
+			return err
+		}
+		limiter.Seed(issued)
+		srv := api.NewServer(backend, access, limiter)
+
+		listen, _ := cmd.Flags().GetString("listen")
+		server := &http.Server{Addr: listen, Handler: accessLog(srv), TLSConfig: tlsConfig, ReadHeaderTimeout: 10 * time.Second}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		errCh := make(chan error, 4)
+		go func() { errCh <- server.ListenAndServeTLS("", "") }()
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Serving the API of %s on %s:\n", caCert.Subject, listen)
+		for _, route := range srv.Routes() {
+			fmt.Fprintf(cmd.OutOrStdout(), " - %s\n", route)
+		}
+		servers := []*http.Server{server}
+		if grpcListen, _ := cmd.Flags().GetString("grpc-listen"); grpcListen != "" {
+			grpcSrv := grpcca.NewServer(backend, access, limiter)
+			grpcServer := &http.Server{Addr: grpcListen, Handler: accessLog(grpcSrv), TLSConfig: tlsConfig.Clone(), ReadHeaderTimeout: 10 * time.Second}
+			go func() { errCh <- grpcServer.ListenAndServeTLS("", "") }()
+			servers = append(servers, grpcServer)
+			fmt.Fprintf(cmd.OutOrStdout(), "Serving the gRPC service on %s:\n", grpcListen)
+			for _, method := range grpcSrv.Methods() {
+				fmt.Fprintf(cmd.OutOrStdout(), " - %s\n", method)
+			}
+		}
+		if estListen, _ := cmd.Flags().GetString("est-listen"); estListen != "" {
+			var secrets est.Secrets
+			if secretsFile, _ := cmd.Flags().GetString("est-secrets"); secretsFile != "" {
+				if secrets, err = est.ReadSecrets(secretsFile); err != nil {
+					return err
+				}
+			}
+			// Enrolling clients may come without a certificate, and renewing ones with one from this CA
+			estTLS := tlsConfig.Clone()
+			estTLS.ClientAuth = tls.VerifyClientCertIfGiven
+			estTLS.ClientCAs = tlsConfig.ClientCAs.Clone()
+			estTLS.ClientCAs.AddCert(caCert)
+			estSrv := est.NewServer(backend, access, limiter, tlsConfig.ClientCAs, secrets)
+			estServer := &http.Server{Addr: estListen, Handler: accessLog(estSrv), TLSConfig: estTLS, ReadHeaderTimeout: 10 * time.Second}
+			go func() { errCh <- estServer.ListenAndServeTLS("", "") }()
+			servers = append(servers, estServer)
+			fmt.Fprintf(cmd.OutOrStdout(), "Serving EST on %s:\n", estListen)
+			for _, route := range estSrv.Routes() {
+				fmt.Fprintf(cmd.OutOrStdout(), " - %s\n", route)
+			}
+		}
+		if cmpListen, _ := cmd.Flags().GetString("cmp-listen"); cmpListen != "" {
+			var secrets est.Secrets
+			if secretsFile, _ := cmd.Flags().GetString("cmp-secrets"); secretsFile != "" {
+				if secrets, err = est.ReadSecrets(secretsFile); err != nil {
+					return err
+				}
+			}
+			// CMP messages carry their own protection, so TLS only hides them
+			cmpTLS := tlsConfig.Clone()
+			cmpTLS.ClientAuth, cmpTLS.ClientCAs = tls.NoClientCert, nil
+			cmpSrv := cmp.NewServer(backend, caKey, access, limiter, tlsConfig.ClientCAs, secrets)
+			cmpServer := &http.Server{Addr: cmpListen, Handler: accessLog(cmpSrv), TLSConfig: cmpTLS, ReadHeaderTimeout: 10 * time.Second}
+			go func() { errCh <- cmpServer.ListenAndServeTLS("", "") }()
+			servers = append(servers, cmpServer)
+			fmt.Fprintf(cmd.OutOrStdout(), "Serving CMP on %s:\n", cmpListen)
+			for _, route := range cmpSrv.Routes() {
+				fmt.Fprintf(cmd.OutOrStdout(), " - %s\n", route)
+			}
+		}
+		select {
+		case err := <-errCh:
+			return fmt.Errorf("failed to serve: %w", err)
+		case <-ctx.Done():
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		fmt.Fprintln(cmd.OutOrStdout(), "Stopped")
+		var errs []error
+		for _, s := range servers {
+			errs = append(errs, s.Shutdown(shutdownCtx))
+		}
+		return errors.Join(errs...)
+	},
+}
+
+func init() {
+	serveCmd.Flags().String("listen", ":8443", "Address to listen on")
+	serveCmd.Flags().String("config", "", "Server configuration (YAML) granting roles to API tokens and client certificates (see api-token) and setting rate limits and daily quotas; without it every client certificate is an admin")
+	serveCmd.Flags().String("grpc-listen", "", "Address to also serve the gRPC service of internal/grpcca/ca.proto on, with the same TLS settings")
+	serveCmd.Flags().String("est-listen", "", "Address to also serve EST (RFC 7030) enrollment on, with the same TLS certificate")
+	serveCmd.Flags().String("est-secrets", "", "File of username:secret lines that may enroll over EST without a client certificate")
+	serveCmd.Flags().String("cmp-listen", "", "Address to also serve CMP (RFC 4210) initialization and key update requests on, over TLS")
+	serveCmd.Flags().String("cmp-secrets", "", "File of reference:secret lines whose secrets protect CMP requests with a password-based MAC")
+	serveCmd.Flags().String("tls-cert", "", "Certificate (PEM) of the server, for the names clients use to reach it")
+	serveCmd.Flags().String("tls-key", "", "Private key (PEM) of the server certificate")
+	serveCmd.Flags().String("client-ca", "", "CA certificate(s) (PEM) that issue the client certificates allowed to use the API")
+	serveCmd.Flags().String("ca-pem", "", "File path to the signing CA certificate (PEM)")
+	serveCmd.Flags().String("chain", "", "Certificates (PEM) of the CA's issuers, returned with each issued certificate")
+	serveCmd.Flags().String("shares-in", "", "Comma-separated list of share files for the signing CA's private key")
+	serveCmd.Flags().String("ca-key", "", "File path to the signing CA private key (PEM, SEC1 or PKCS#8, optionally encrypted) instead of shares")
+	serveCmd.Flags().Int("days", 90, "Validity period (in days) of certificates whose request gives none and whose profile sets none")
+	serveCmd.Flags().String("issuance-log", "", "Issuance log of the signing CA (default: <ca-pem without extension>.issuance.log)")
+	addPolicyFlags(serveCmd)
+	addDistributionFlags(serveCmd)
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"my-pki/internal/api"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// loadServerConfig reads the server configuration of --config. With create, a missing file is an
+// empty configuration.
+func loadServerConfig(cmd *cobra.Command, create bool) (*api.Config, string, error) {
+	path, _ := cmd.Flags().GetString("config")
+	if path == "" {
+		return nil, "", invalid(errors.New("must specify --config for the server configuration of serve"))
+	}
+	if _, err := os.Stat(path); create && errors.Is(err, os.ErrNotExist) {
+		return &api.Config{}, path, nil
+	}
+	cfg, err := api.LoadConfig(path)
+	return cfg, path, err
+}
+
+// apiTokenCmd groups the API token subcommands.
+var apiTokenCmd = &cobra.Command{
+	Use:   "api-token",
+	Short: "Create, list and delete the API tokens of serve and the roles they grant.",
+}
+
+// api-token create
+var apiTokenCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create an API token with a role and print it; only its hash is kept in the server configuration.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, path, err := loadServerConfig(cmd, true)
+		if err != nil {
+			return err
+		}
+		name, _ := cmd.Flags().GetString("name")
+		role, _ := cmd.Flags().GetString("role")
+		profiles, _ := cmd.Flags().GetStringSlice("profiles")
+		days, _ := cmd.Flags().GetInt("days")
+		if name == "" || role == "" {
+			return invalid(errors.New("must specify --name and --role"))
+		}
+		if cfg.Token(name) != nil {
+			return invalid(fmt.Errorf("token '%s' already exists; delete it first to replace it", name))
+		}
+		if days < 0 {
+			return invalid(errors.New("--days must not be negative"))
+		}
+		token, sum, err := api.NewToken()
+		if err != nil {
+			return err
+		}
+		t := api.Token{Name: name, Role: role, Profiles: profiles, SHA256: sum}
+		if days > 0 {
+			expires := time.Now().AddDate(0, 0, days).UTC().Truncate(time.Second)
+			t.Expires = &expires
+		}
+		cfg.Tokens = append(cfg.Tokens, t)
+		if err := cfg.Validate(); err != nil {
+			return invalid(err)
+		}
+		if err := cfg.Save(path); err != nil {
+			return err
+		}
+		if resultOut != nil {
+			return emitResult(map[string]string{"name": name, "role": role, "token": token})
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Created token '%s' (%s) in '%s'. It is shown only this once:\n%s\n", name, role, path, token)
+		fmt.Fprintln(cmd.OutOrStdout(), "Send it as 'Authorization: Bearer <token>'; restart serve to load it.")
+		return nil
+	},
+}
+
+// api-token list
+var apiTokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the API tokens of a server configuration with their roles, profiles and expiry.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, path, err := loadServerConfig(cmd, false)
+		if err != nil {
+			return err
+		}
+		if resultOut != nil {
+			return emitResult(cfg.Tokens)
+		}
+		if len(cfg.Tokens) == 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "No tokens in '%s'\n", path)
+			return nil
+		}
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tROLE\tPROFILES\tEXPIRES")
+		for _, t := range cfg.Tokens {
+			expires := "never"
+			if t.Expires != nil {
+				expires = t.Expires.Format(time.DateOnly)
+				if !time.Now().Before(*t.Expires) {
+					expires += " (expired)"
+				}
+			}
+			profiles := strings.Join(t.Profiles, ",")
+			if profiles == "" {
+				profiles = "any"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Name, t.Role, profiles, expires)
+		}
+		return tw.Flush()
+	},
+}
+
+// api-token delete
+var apiTokenDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete an API token, so serve refuses it once restarted.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, path, err := loadServerConfig(cmd, false)
+		if err != nil {
+			return err
+		}
+		n := len(cfg.Tokens)
+		cfg.Tokens = slices.DeleteFunc(cfg.Tokens, func(t api.Token) bool { return t.Name == args[0] })
+		if len(cfg.Tokens) == n {
+			return invalid(fmt.Errorf("no token '%s' in '%s'", args[0], path))
+		}
+		if err := cfg.Save(path); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Deleted token '%s' from '%s'\n", args[0], path)
+		return nil
+	},
+}
+
+func init() {
+	apiTokenCmd.PersistentFlags().String("config", "", "Server configuration (YAML) of serve --config; created by create if missing")
+
+	apiTokenCreateCmd.Flags().String("name", "", "Name of the token, recorded as token:NAME in the audit log")
+	apiTokenCreateCmd.Flags().String("role", "", "Role granted: "+strings.Join(api.Roles(), ", "))
+	apiTokenCreateCmd.Flags().StringSlice("profiles", nil, "Comma-separated profiles the token may request (required for requester; default for other roles: any)")
+	apiTokenCreateCmd.Flags().Int("days", 0, "Days until the token expires (default: never)")
+
+	apiTokenCmd.AddCommand(apiTokenCreateCmd)
+	apiTokenCmd.AddCommand(apiTokenListCmd)
+	apiTokenCmd.AddCommand(apiTokenDeleteCmd)
+	rootCmd.AddCommand(apiTokenCmd)
+}
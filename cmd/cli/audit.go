@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"my-pki/internal/audit"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// auditLogPath returns --audit-log, or the audit log next to the inventory.
+func auditLogPath(cmd *cobra.Command) string {
+	if p, _ := cmd.Flags().GetString("audit-log"); p != "" {
+		return p
+	}
+	dbPath, _ := cmd.Flags().GetString("db")
+	if dbPath == "" {
+		dbPath = inventory.Default()
+	}
+	return audit.PathForInventory(dbPath)
+}
+
+// auditOperator is recorded as the operator of audit entries instead of the local user when set, by
+// servers to the authenticated client of the request being handled.
+var auditOperator string
+
+// recordAudit appends e to the audit log, filling in when, who, where and which command. A CA key
+// is not used without the log recording it, so callers fail when this does.
+func recordAudit(cmd *cobra.Command, e audit.Entry) error {
+	now, err := utils.Now()
+	if err != nil {
+		now = time.Now()
+	}
+	e.Time = now
+	e.Operator = operatorName(auditOperator)
+	e.Host, _ = os.Hostname()
+	e.Command = cmd.CommandPath()
+	if err := audit.Append(auditLogPath(cmd), &e); err != nil {
+		return fmt.Errorf("failed to record %s in the audit log: %w", e.Event, err)
+	}
+	return nil
+}
+
+// auditCA fills the CA of an audit entry from the certificate at caPem, if it can be read.
+func auditCA(e *audit.Entry, caPem string) {
+	caCert, err := utils.ParseCertificateFromFile(caPem)
+	if err != nil {
+		return
+	}
+	e.CA = caCert.Subject.String()
+	e.Fingerprints = append(e.Fingerprints, inventory.Fingerprint(caCert))
+}
+
+// certAuditEntry describes an event concerning the certificate of rec, issued by a CA in db.
+func certAuditEntry(db *inventory.DB, event string, rec *inventory.CertRecord) audit.Entry {
+	e := audit.Entry{Event: event, Params: map[string]string{"subject": rec.Subject, "serial": rec.Serial}, Fingerprints: []string{rec.SHA256}}
+	if issuer := db.Certificate(rec.IssuerSHA256); issuer != nil {
+		e.CA = issuer.Subject
+	}
+	return e
+}
+
+// describeIndexes lists which shares were combined, e.g. "#1,#3,#4", or how many when they carry no index.
+func describeIndexes(shares []*utils.Share) string {
+	var idx []string
+	for _, s := range shares {
+		if !s.HasMetadata() {
+			return fmt.Sprintf("%d", len(shares))
+		}
+		idx = append(idx, fmt.Sprintf("#%d", s.Index))
+	}
+	return strings.Join(idx, ",")
+}
+
+// auditCmd groups the audit log subcommands.
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show and verify the audit log of issuances, revocations, CRLs and share combines and splits.",
+}
+
+// audit show
+var auditShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the audit log, optionally only some events, one CA or a time range.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := auditLogPath(cmd)
+		entries, err := audit.Open(path)
+		if err != nil {
+			return err
+		}
+		event, _ := cmd.Flags().GetString("event")
+		ca, _ := cmd.Flags().GetString("ca")
+		since, err := searchTime(cmd, "since")
+		if err != nil {
+			return err
+		}
+		until, err := searchTime(cmd, "until")
+		if err != nil {
+			return err
+		}
+		shown := []audit.Entry{}
+		for _, e := range entries {
+			if event != "" && e.Event != event {
+				continue
+			}
+			if ca != "" && !strings.Contains(strings.ToLower(e.CA), strings.ToLower(ca)) {
+				continue
+			}
+			if !since.IsZero() && e.Time.Before(since) || !until.IsZero() && !e.Time.Before(until) {
+				continue
+			}
+			shown = append(shown, e)
+		}
+
+		if resultOut != nil {
+			return emitResult(shown)
+		}
+		if len(shown) == 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "No entries in '%s'\n", path)
+			return nil
+		}
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SEQ\tTIME\tEVENT\tOPERATOR\tCOMMAND\tCA\tDETAILS")
+		for _, e := range shown {
+			keys := make([]string, 0, len(e.Params))
+			for k := range e.Params {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			var details []string
+			for _, k := range keys {
+				details = append(details, k+"="+e.Params[k])
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s@%s\t%s\t%s\t%s\n", e.Seq, e.Time.Format(time.RFC3339), e.Event, e.Operator, e.Host,
+				e.Command, e.CA, strings.Join(details, " "))
+		}
+		return tw.Flush()
+	},
+}
+
+// audit verify
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that no entry of the audit log was modified, removed or reordered.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := auditLogPath(cmd)
+		entries, err := audit.Open(path)
+		if err != nil {
+			return err
+		}
+		if err := audit.Verify(entries); err != nil {
+			return fmt.Errorf("audit log '%s' is not intact: %w", path, err)
+		}
+		if len(entries) == 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "Audit log '%s' is empty\n", path)
+			return nil
+		}
+		last := entries[len(entries)-1]
+		fmt.Fprintf(cmd.OutOrStdout(), "Audit log '%s' is intact: %d entries, the last at %s (hash %s)\n", path, len(entries), last.Time.Format(time.RFC3339), last.Hash[:16])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().String("audit-log", "", "Audit log of issuances, revocations, CRLs and share combines and splits (default: next to --db, <db without extension>.audit.log)")
+
+	auditShowCmd.Flags().String("event", "", "Only this event: issue, revoke, unhold, combine, split, reshare, crl or compromise")
+	auditShowCmd.Flags().String("ca", "", "Only entries whose CA subject contains this text")
+	auditShowCmd.Flags().String("since", "", "Only entries from this date on (YYYY-MM-DD or RFC 3339)")
+	auditShowCmd.Flags().String("until", "", "Only entries before this date (YYYY-MM-DD or RFC 3339)")
+	auditShowCmd.Flags().Bool("json", false, "Print the entries as a JSON array (same as --output json)")
+
+	auditCmd.AddCommand(auditShowCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+	rootCmd.AddCommand(auditCmd)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"my-pki/internal/cadir"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"my-pki/internal/workspace"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// backupManifestName is the member of a backup describing it; restore reads it to move paths.
+const backupManifestName = ".gosec-backup.json"
+
+// backupManifest records where a backup was taken, so that restore can rewrite the absolute and
+// working-directory-relative paths kept in the inventory.
+type backupManifest struct {
+	Created   time.Time `json:"created"`
+	Dir       string    `json:"dir"`       // absolute directory backed up
+	Cwd       string    `json:"cwd"`       // working directory relative paths in the inventory were given from
+	Inventory string    `json:"inventory"` // inventory, relative to Dir; empty if it is not inside it
+	Files     int       `json:"files"`
+	Excluded  []string  `json:"excluded,omitempty"` // key material left out, relative to Dir
+}
+
+// backupExclusion returns why the file rel with contents data must stay out of a backup, or "" if it
+// may go in: private keys and key shares in any form never leave the host.
+func backupExclusion(rel string, data []byte, withWrappedKeys bool) string {
+	switch strings.ToLower(filepath.Ext(rel)) {
+	case ".p12", ".pfx":
+		return "PKCS#12 file"
+	}
+	if bytes.Contains(data, []byte("AGE-SECRET-KEY-1")) {
+		return "age identity"
+	}
+	if bytes.HasPrefix(data, []byte("# GoSeC key share #")) {
+		return "key share words"
+	}
+	found := false
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		found = true
+		switch {
+		case strings.Contains(block.Type, "PRIVATE KEY"):
+			return "private key"
+		case block.Type == "GOSEC KEY SHARE":
+			return "key share"
+		case block.Type == "GOSEC WRAPPED KEY" && !withWrappedKeys:
+			return "wrapped key"
+		}
+	}
+	// Shares written before share metadata existed are bare base64 of at least a P-256 scalar
+	if !found {
+		if s, err := utils.ParseShare(data); err == nil && len(s.Data) >= 32 {
+			return "key share"
+		}
+	}
+	return ""
+}
+
+// backupKey returns the key of a backup: --password, --key-file, or a passphrase typed on the terminal.
+func backupKey(cmd *cobra.Command, creating bool) (workspace.Key, error) {
+	if password, _ := cmd.Flags().GetString("password"); password != "" {
+		return workspace.Key{Passphrase: []byte(password)}, nil
+	}
+	keyFile, _ := cmd.Flags().GetString("key-file")
+	return workspaceKey(keyFile, creating)
+}
+
+// backupCmd writes an encrypted copy of a CA host's state without its keys.
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Write an encrypted backup of a CA host's certificates, inventory, CRLs, configurations and logs, leaving out private keys and shares.",
+	Long: `Write an encrypted backup of a CA host's certificates, inventory, CRLs, configurations and logs, leaving out private keys and shares.
+
+Every file under --dir goes into the backup except key material: private keys, PKCS#12 files, key
+shares (also as words), age identities and, unless --wrapped-keys is given, the wrapped keys of
+envelope CAs. A wrapped key is useless without a quorum of shares, so including it lets a restored
+host sign again once the custodians bring their shares. The files are left in place.
+
+The backup is encrypted like a sealed workspace (AES-256-GCM under a key derived from the password
+with scrypt, or from a key file). Restore it with restore.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, _ := cmd.Flags().GetString("dir")
+		if dir == "" {
+			dir = string(caHome)
+		}
+		if dir == "" {
+			dir = "."
+		}
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			return invalid(errors.New("must specify --out for the backup file"))
+		}
+		if force, _ := cmd.Flags().GetBool("force"); !force {
+			if _, err := os.Stat(out); err == nil {
+				return fmt.Errorf("'%s' already exists; use --force to replace it", out)
+			}
+		}
+		withWrappedKeys, _ := cmd.Flags().GetBool("wrapped-keys")
+		keyFile, _ := cmd.Flags().GetString("key-file")
+
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		manifest := backupManifest{Dir: absDir, Cwd: cwd}
+		if manifest.Created, err = utils.Now(); err != nil {
+			return err
+		}
+		dbPath, _ := cmd.Flags().GetString("db")
+		if inside, err := pathsInside(dir, dbPath); err != nil {
+			return err
+		} else if len(inside) > 0 {
+			manifest.Inventory = inside[0]
+		} else {
+			slog.Warn("inventory is outside the backed up directory and not included", "db", dbPath, "dir", dir)
+		}
+
+		// Never back up the backup itself, nor the key that opens it
+		skip, err := pathsInside(dir, out, keyFile)
+		if err != nil {
+			return err
+		}
+		// The manifest goes into the backup, so key material is found before sealing
+		err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if slices.Contains(skip, rel) {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			defer clear(data)
+			if reason := backupExclusion(rel, data, withWrappedKeys); reason != "" {
+				slog.Info("left out of the backup", "file", rel, "kind", reason)
+				manifest.Excluded = append(manifest.Excluded, rel)
+			} else {
+				manifest.Files++
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %w", dir, err)
+		}
+		if manifest.Files == 0 {
+			return fmt.Errorf("no files to back up in '%s'", dir)
+		}
+		manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		key, err := backupKey(cmd, true)
+		if err != nil {
+			return err
+		}
+		skip = append(skip, manifest.Excluded...)
+		sealed, files, err := workspace.SealWith(dir, key, func(rel string) bool { return slices.Contains(skip, rel) },
+			map[string][]byte{backupManifestName: manifestJSON})
+		if err != nil {
+			return err
+		}
+
+		tmp := out + ".tmp"
+		if err := os.WriteFile(tmp, sealed, 0600); err != nil {
+			return fmt.Errorf("failed to write '%s': %w", out, err)
+		}
+		if err := os.Rename(tmp, out); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to write '%s': %w", out, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Backed up %d file(s) from '%s' to %s\n", len(files), dir, out)
+		if len(manifest.Excluded) > 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "Left out %d file(s) holding key material:\n", len(manifest.Excluded))
+			for _, rel := range manifest.Excluded {
+				fmt.Fprintf(cmd.OutOrStdout(), " - %s\n", rel)
+			}
+		}
+		return nil
+	},
+}
+
+// restoreCmd rebuilds a CA host from a backup.
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Rebuild a CA host from a backup into a new or empty directory, moving the paths in its inventory there.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, _ := cmd.Flags().GetString("in")
+		if in == "" {
+			return invalid(errors.New("must specify --in for the backup file"))
+		}
+		dir, _ := cmd.Flags().GetString("dir")
+		if dir == "" {
+			dir = string(caHome)
+		}
+		if dir == "" {
+			return invalid(errors.New("must specify --dir (or --ca-dir) to restore into"))
+		}
+		data, err := os.ReadFile(in)
+		if err != nil {
+			return fmt.Errorf("unable to read '%s': %w", in, err)
+		}
+		key, err := backupKey(cmd, false)
+		if err != nil {
+			return err
+		}
+		// A CA home was created empty by --ca-dir; restoring into it is what it is for
+		if caHome != "" && filepath.Clean(dir) == filepath.Clean(string(caHome)) {
+			if err := removeEmptyLayout(dir); err != nil {
+				return err
+			}
+		}
+		n, err := workspace.Unseal(data, key, dir)
+		if err != nil {
+			return err
+		}
+
+		manifestPath := filepath.Join(dir, backupManifestName)
+		raw, err := os.ReadFile(manifestPath)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a backup (no %s): %w", in, backupManifestName, err)
+		}
+		var manifest backupManifest
+		if err := json.Unmarshal(raw, &manifest); err != nil {
+			return fmt.Errorf("invalid backup manifest: %w", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, cadir.CertsDir)); err == nil {
+			// Recreate the directories left empty, private/ among them
+			if err := cadir.Dir(dir).Init(); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Restored %d file(s) from the backup of '%s' taken %s into '%s'\n", n-1, manifest.Dir,
+			manifest.Created.Format(time.RFC3339), dir)
+
+		if manifest.Inventory != "" {
+			moved, err := moveInventoryPaths(filepath.Join(dir, filepath.FromSlash(manifest.Inventory)), manifest, dir)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Inventory: %s (%d path(s) moved to the new location)\n", filepath.Join(dir, filepath.FromSlash(manifest.Inventory)), moved)
+		}
+		if len(manifest.Excluded) > 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "%d file(s) holding key material were not in the backup; CAs sign again once their custodians bring their shares:\n", len(manifest.Excluded))
+			for _, rel := range manifest.Excluded {
+				fmt.Fprintf(cmd.OutOrStdout(), " - %s\n", rel)
+			}
+		}
+		return nil
+	},
+}
+
+// removeEmptyLayout removes the empty subdirectories of a fresh CA home, so a backup can be restored into it.
+func removeEmptyLayout(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if sub, err := os.ReadDir(path); !e.IsDir() || err != nil || len(sub) > 0 {
+			return fmt.Errorf("'%s' is not empty; restore into a new directory", dir)
+		}
+	}
+	for _, e := range entries {
+		os.Remove(filepath.Join(dir, e.Name()))
+	}
+	return nil
+}
+
+// moveInventoryPaths rewrites the CA and certificate paths in the inventory at dbPath that pointed into
+// the backed up directory, so they point into dir. It returns how many it changed.
+func moveInventoryPaths(dbPath string, manifest backupManifest, dir string) (int, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+	move := func(p string) (string, bool) {
+		if p == "" {
+			return p, false
+		}
+		abs := p
+		if !filepath.IsAbs(abs) {
+			abs = filepath.Join(manifest.Cwd, p)
+		}
+		rel, err := filepath.Rel(manifest.Dir, abs)
+		if err != nil || !filepath.IsLocal(rel) {
+			return p, false
+		}
+		return filepath.Join(absDir, rel), true
+	}
+	moved := 0
+	err = inventory.Update(dbPath, func(db *inventory.DB) error {
+		for _, ca := range db.CAs {
+			if p, ok := move(ca.PemPath); ok {
+				ca.PemPath, moved = p, moved+1
+			}
+		}
+		for _, rec := range db.Certificates {
+			if p, ok := move(rec.Path); ok {
+				rec.Path, moved = p, moved+1
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to update inventory '%s': %w", dbPath, err)
+	}
+	return moved, nil
+}
+
+func init() {
+	backupCmd.Flags().String("dir", "", "Directory to back up (default: --ca-dir, or the current directory)")
+	backupCmd.Flags().String("out", "", "File path for the encrypted backup, e.g. ca-backup.gosec")
+	backupCmd.Flags().String("password", "", "Password of the backup (visible to other local users; omit to be prompted)")
+	backupCmd.Flags().String("key-file", "", "Encrypt with this key file (see workspace keygen) instead of a password")
+	backupCmd.Flags().Bool("wrapped-keys", false, "Include the wrapped keys of envelope CAs, which only a quorum of shares unwraps")
+	backupCmd.Flags().Bool("force", false, "Replace an existing backup at --out")
+	rootCmd.AddCommand(backupCmd)
+
+	restoreCmd.Flags().String("in", "", "File path to the backup")
+	restoreCmd.Flags().String("dir", "", "New or empty directory to restore into (default: --ca-dir)")
+	restoreCmd.Flags().String("password", "", "Password of the backup (visible to other local users; omit to be prompted)")
+	restoreCmd.Flags().String("key-file", "", "Key file the backup was encrypted with (default: prompt for the password)")
+	rootCmd.AddCommand(restoreCmd)
+}
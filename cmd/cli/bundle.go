@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/bundle"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/crl"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// export-issuing-bundle
+var exportIssuingBundleCmd = &cobra.Command{
+	Use:   "export-issuing-bundle",
+	Short: "In the offline root workspace, pack an issuing CA's certificate, chain and a fresh root CRL for the online host.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the issuing CA certificate"))
+		}
+		rootPem, _ := cmd.Flags().GetString("root-pem")
+		if rootPem == "" {
+			return invalid(errors.New("must specify --root-pem for the root CA certificate"))
+		}
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			return invalid(errors.New("must specify --out for the bundle file"))
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse issuing CA certificate from '%s': %w", caPem, err)
+		}
+		rootCert, err := utils.ParseCertificateFromFile(rootPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse root CA certificate from '%s': %w", rootPem, err)
+		}
+		if err := checkIssuingChain(caCert, rootCert); err != nil {
+			return err
+		}
+
+		db, err := openInventory(cmd)
+		if err != nil {
+			return err
+		}
+		if rec := db.Certificate(inventory.Fingerprint(caCert)); rec != nil && rec.Status == inventory.StatusRevoked {
+			return fmt.Errorf("issuing CA '%s' is revoked", caPem)
+		}
+
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		b := &bundle.Bundle{}
+		b.Created = now.UTC()
+		caBytes, err := os.ReadFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %w", caPem, err)
+		}
+		rootBytes, err := os.ReadFile(rootPem)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %w", rootPem, err)
+		}
+		caBase := filepath.Base(caPem)
+		rootBase := filepath.Base(rootPem)
+		if caBase == rootBase {
+			return errors.New("issuing CA and root certificate files must have different names")
+		}
+		b.IssuingCA = b.Add(caBase, caBytes)
+		b.Root = b.Add(rootBase, rootBytes)
+		b.Chain = b.Add(trimExt(caBase)+".chain.pem", append(pemCert(caCert), pemCert(rootCert)...))
+		if cfg, err := os.ReadFile(caconfig.PathForCA(caPem)); err == nil {
+			b.Config = b.Add(filepath.Base(caconfig.PathForCA(caPem)), cfg)
+		}
+
+		// The root CRL lets the online host (and its relying parties) see revoked issuing CAs
+		noCRL, _ := cmd.Flags().GetBool("no-crl")
+		var nextUpdate time.Time
+		var rootKey crypto.Signer
+		if !noCRL {
+			sharesInStr, _ := cmd.Flags().GetString("shares-in")
+			caKeyPath, _ := cmd.Flags().GetString("ca-key")
+			if rootKey, err = loadCAKey(cmd, rootPem, sharesInStr, caKeyPath, "--shares-in", "--ca-key"); err != nil {
+				return fmt.Errorf("failed to load root CA private key (use --no-crl to export without a CRL): %w", err)
+			}
+			crlDays, _ := cmd.Flags().GetInt("crl-days")
+			if crlDays <= 0 {
+				return errors.New("--crl-days must be positive")
+			}
+			nextUpdate = now.AddDate(0, 0, crlDays)
+		}
+
+		var rootRec *inventory.CARecord
+		err = updateInventory(cmd, func(db *inventory.DB) error {
+			rootRec = db.AddCA(rootCert, rootPem)
+			if rootKey != nil {
+				var revoked []*inventory.CertRecord
+				for _, rec := range db.IssuedBy(rootRec.SHA256) {
+					if rec.Status == inventory.StatusRevoked && rec.NotAfter.After(now) {
+						revoked = append(revoked, rec)
+					}
+				}
+				crlPEM, err := crl.Create(rootCert, rootKey, revoked, rootRec.NextCRLNumber(), now, nextUpdate)
+				if err != nil {
+					return err
+				}
+				rootRec.RecordCRL(now, false)
+				b.CRL = b.Add(trimExt(rootBase)+".crl", crlPEM)
+			}
+			return bundle.Write(out, b)
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Issuing bundle written to %s\n - Issuing CA: %s\n - Root: %s (SHA-256 %s)\n", out, caCert.Subject, rootCert.Subject, inventory.Fingerprint(rootCert))
+		if b.CRL != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), " - Root CRL #%d, next update %s\n", rootRec.CRLNumber, nextUpdate.Format(time.RFC3339))
+		}
+		if b.Config != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), " - CA configuration: %s\n", b.Config)
+		}
+		return nil
+	},
+}
+
+// import-issuing-bundle
+var importIssuingBundleCmd = &cobra.Command{
+	Use:   "import-issuing-bundle",
+	Short: "On the online issuing host, verify an issuing bundle and install its certificates, chain and root CRL.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bundlePath, _ := cmd.Flags().GetString("bundle")
+		if bundlePath == "" {
+			return invalid(errors.New("must specify --bundle for the issuing bundle"))
+		}
+		dir, _ := cmd.Flags().GetString("dir")
+		force, _ := cmd.Flags().GetBool("force")
+		b, err := bundle.Read(bundlePath)
+		if err != nil {
+			return err
+		}
+
+		caCert, err := parseCertPEM(b.Files[b.IssuingCA])
+		if err != nil {
+			return fmt.Errorf("invalid issuing CA certificate in bundle: %w", err)
+		}
+		rootCert, err := parseCertPEM(b.Files[b.Root])
+		if err != nil {
+			return fmt.Errorf("invalid root certificate in bundle: %w", err)
+		}
+		if err := checkIssuingChain(caCert, rootCert); err != nil {
+			return err
+		}
+		if !bytes.Equal(b.Files[b.Chain], append(pemCert(caCert), pemCert(rootCert)...)) {
+			return fmt.Errorf("chain '%s' in bundle does not match the issuing CA and root", b.Chain)
+		}
+		rootFP := inventory.Fingerprint(rootCert)
+		expected, _ := cmd.Flags().GetString("root-sha256")
+		if expected != "" && !strings.EqualFold(strings.ReplaceAll(expected, ":", ""), rootFP) {
+			return fmt.Errorf("bundle root has SHA-256 %s, not the expected %s", rootFP, expected)
+		}
+
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		var rl *x509.RevocationList
+		if b.CRL != "" {
+			block, _ := pem.Decode(b.Files[b.CRL])
+			if block == nil {
+				return fmt.Errorf("invalid CRL '%s' in bundle", b.CRL)
+			}
+			if rl, err = x509.ParseRevocationList(block.Bytes); err != nil {
+				return fmt.Errorf("invalid CRL '%s' in bundle: %w", b.CRL, err)
+			}
+			if err := rl.CheckSignatureFrom(rootCert); err != nil {
+				return fmt.Errorf("CRL '%s' is not signed by the bundle root: %w", b.CRL, err)
+			}
+			for _, entry := range rl.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(caCert.SerialNumber) == 0 {
+					return fmt.Errorf("issuing CA %s is revoked by the bundle's root CRL", caCert.Subject)
+				}
+			}
+			if !rl.NextUpdate.IsZero() && rl.NextUpdate.Before(now) {
+				slog.Warn("root CRL expired; export a fresh bundle", "next_update", rl.NextUpdate.Format(time.RFC3339))
+			}
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create '%s': %w", dir, err)
+		}
+		// Check every target before writing any, so a conflict leaves the directory untouched
+		var pending []string
+		for _, name := range []string{b.IssuingCA, b.Chain, b.Root, b.Config, b.CRL} {
+			if name == "" {
+				continue
+			}
+			target := filepath.Join(dir, name)
+			existing, err := os.ReadFile(target)
+			switch {
+			case err == nil && bytes.Equal(existing, b.Files[name]):
+				continue
+			case err == nil && name == b.CRL:
+				if err := checkNewerCRL(existing, rl); err != nil && !force {
+					return fmt.Errorf("'%s': %w (use --force to replace it)", target, err)
+				}
+			case err == nil && !force:
+				return fmt.Errorf("'%s' already exists with different content (use --force to replace it)", target)
+			case err != nil && !errors.Is(err, os.ErrNotExist):
+				return fmt.Errorf("failed to read '%s': %w", target, err)
+			}
+			pending = append(pending, name)
+		}
+		for _, name := range pending {
+			target := filepath.Join(dir, name)
+			if err := os.WriteFile(target, b.Files[name], 0644); err != nil {
+				return fmt.Errorf("failed to write '%s': %w", target, err)
+			}
+		}
+
+		err = updateInventory(cmd, func(db *inventory.DB) error {
+			db.AddCA(rootCert, filepath.Join(dir, b.Root))
+			db.AddCA(caCert, filepath.Join(dir, b.IssuingCA))
+			db.AddCertificate(rootCert, rootCert)
+			db.AddCertificate(caCert, rootCert)
+			if rl != nil {
+				for _, rec := range db.IssuedBy(rootFP) {
+					listed := false
+					for _, entry := range rl.RevokedCertificateEntries {
+						if hex.EncodeToString(entry.SerialNumber.Bytes()) == rec.Serial {
+							rec.Revoke(entry.RevocationTime, entry.ReasonCode)
+							listed = true
+						}
+					}
+					// The root CRL is complete: a hold it no longer lists was released
+					if !listed && rec.OnHold() {
+						rec.Release(rl.ThisUpdate)
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Issuing bundle '%s' imported into %s (%d file(s) updated)\n", bundlePath, dir, len(pending))
+		fmt.Fprintf(cmd.OutOrStdout(), " - Issuing CA: %s\n - Root: %s\n - Root SHA-256: %s\n", caCert.Subject, rootCert.Subject, rootFP)
+		if expected == "" {
+			fmt.Fprintln(cmd.OutOrStdout(), "   Compare this fingerprint with the one recorded at the root ceremony, or pass --root-sha256.")
+		}
+		if rl != nil {
+			fmt.Fprintf(cmd.OutOrStdout(), " - Root CRL #%s, next update %s\n", rl.Number, rl.NextUpdate.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+// checkIssuingChain verifies that caCert is a CA certificate signed by the self-signed root rootCert.
+func checkIssuingChain(caCert, rootCert *x509.Certificate) error {
+	if !rootCert.IsCA || !inventory.IsSelfSigned(rootCert) {
+		return fmt.Errorf("%s is not a self-signed root CA certificate", rootCert.Subject)
+	}
+	if !caCert.IsCA {
+		return fmt.Errorf("%s is not a CA certificate", caCert.Subject)
+	}
+	if err := caCert.CheckSignatureFrom(rootCert); err != nil {
+		return fmt.Errorf("%s was not issued by %s: %w", caCert.Subject, rootCert.Subject, err)
+	}
+	return nil
+}
+
+// checkNewerCRL refuses to replace an installed CRL with one that is not newer.
+func checkNewerCRL(existingPEM []byte, rl *x509.RevocationList) error {
+	block, _ := pem.Decode(existingPEM)
+	if block == nil {
+		return nil
+	}
+	old, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil || old.Number == nil || rl.Number == nil {
+		return nil
+	}
+	if rl.Number.Cmp(old.Number) <= 0 {
+		return fmt.Errorf("installed CRL #%s is not older than the bundle's CRL #%s", old.Number, rl.Number)
+	}
+	return nil
+}
+
+func pemCert(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func trimExt(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func init() {
+	exportIssuingBundleCmd.Flags().String("ca-pem", "", "File path to the issuing (sub) CA certificate (PEM)")
+	exportIssuingBundleCmd.Flags().String("root-pem", "", "File path to the root CA certificate (PEM)")
+	exportIssuingBundleCmd.Flags().String("shares-in", "", "Comma-separated list of share files for the root CA's private key (to sign the CRL)")
+	exportIssuingBundleCmd.Flags().String("ca-key", "", "File path to the root CA private key instead of shares")
+	exportIssuingBundleCmd.Flags().Bool("no-crl", false, "Export without a root CRL (no root key needed)")
+	exportIssuingBundleCmd.Flags().Int("crl-days", 30, "Validity of the root CRL (in days) until its next update")
+	exportIssuingBundleCmd.Flags().String("out", "", "File path for the bundle (.tar.gz)")
+
+	importIssuingBundleCmd.Flags().String("bundle", "", "Issuing bundle produced by export-issuing-bundle")
+	importIssuingBundleCmd.Flags().String("dir", ".", "Directory to install the certificates, chain, CRL and CA configuration into")
+	importIssuingBundleCmd.Flags().String("root-sha256", "", "Expected SHA-256 fingerprint of the root certificate (hex)")
+	importIssuingBundleCmd.Flags().Bool("force", false, "Replace existing files with different content")
+
+	rootCmd.AddCommand(exportIssuingBundleCmd)
+	rootCmd.AddCommand(importIssuingBundleCmd)
+}
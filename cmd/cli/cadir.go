@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/hex"
+	"my-pki/internal/cadir"
+
+	"github.com/spf13/cobra"
+)
+
+// caHome is the managed CA directory given by --ca-dir; empty without one.
+var caHome cadir.Dir
+
+// caNameFlags name CA certificates, which in a CA home may be given by name: --ca-pem issuing
+// stands for <ca-dir>/certs/issuing.pem.
+var caNameFlags = []string{"ca-pem", "parent-pem", "root-pem", "pem-out"}
+
+// configureCADir applies --ca-dir: it creates the layout and points the inventory, CA names and
+// a new CA's --pem-out into it. Flags given explicitly keep their value.
+func configureCADir(cmd *cobra.Command) error {
+	dir, _ := cmd.Flags().GetString("ca-dir")
+	if dir == "" {
+		return nil
+	}
+	caHome = cadir.Dir(dir)
+	if err := caHome.Init(); err != nil {
+		return err
+	}
+	if !cmd.Flags().Changed("db") {
+		if err := cmd.Flags().Set("db", caHome.InventoryPath()); err != nil {
+			return err
+		}
+	}
+	for _, name := range caNameFlags {
+		f := cmd.Flags().Lookup(name)
+		if f == nil || f.Value.Type() != "string" {
+			continue
+		}
+		if cadir.IsName(f.Value.String()) {
+			if err := f.Value.Set(caHome.CAPath(f.Value.String())); err != nil {
+				return err
+			}
+		}
+	}
+	// A new CA is named after its common name
+	if f := cmd.Flags().Lookup("pem-out"); f != nil && f.Value.String() == "" {
+		if cn, _ := cmd.Flags().GetString("cn"); cn != "" {
+			if err := f.Value.Set(caHome.CAPath(cadir.Name(cn))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// issuedSerial returns the hex serial of certPEM, which names its files in a CA home.
+func issuedSerial(certPEM []byte) (string, error) {
+	cert, err := parseCertPEM(certPEM)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(cert.SerialNumber.Bytes()), nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().String("ca-dir", "", "Managed CA directory (certs/, crl/, db/, newcerts/, private/) where CAs are found by name and outputs go by default")
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"my-pki/internal/ceremony"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"my-pki/internal/vss"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// ask prompts for a value, returning def when the answer is empty.
+func ask(prompt, def string) (string, error) {
+	if def != "" {
+		prompt = fmt.Sprintf("%s [%s]", prompt, def)
+	}
+	answer, err := utils.ReadLine(prompt + ": ")
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// askInt prompts for a positive number.
+func askInt(prompt string, def int) (int, error) {
+	answer, err := ask(prompt, strconv.Itoa(def))
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(answer)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("%s: '%s' is not a positive number", prompt, answer)
+	}
+	return v, nil
+}
+
+// fileSHA256 returns the hex SHA-256 of a file's contents.
+func fileSHA256(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read '%s': %w", path, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// rootArgs collects the create-root flags chosen during a ceremony, and the equivalent command line.
+type rootArgs struct {
+	flags   [][2]string
+	cmdline []string
+}
+
+func (a *rootArgs) set(name, value string) {
+	a.flags = append(a.flags, [2]string{name, value})
+	arg := "--" + name + "=" + value
+	if strings.ContainsAny(value, " \t'\"") {
+		arg = "--" + name + "=" + strconv.Quote(value)
+	}
+	a.cmdline = append(a.cmdline, arg)
+}
+
+// runCreateRoot runs create-root in this process, so its prompts share the ceremony's terminal.
+func (a *rootArgs) runCreateRoot() error {
+	// Merges the global flags, already parsed for this command, into create-root's flag set
+	createRootCmd.InheritedFlags()
+	for _, f := range a.flags {
+		if err := createRootCmd.Flags().Set(f[0], f[1]); err != nil {
+			return invalid(fmt.Errorf("invalid --%s: %w", f[0], err))
+		}
+	}
+	return createRootCmd.RunE(createRootCmd, nil)
+}
+
+// signTranscript asks each participant present for their signing key until an empty name is given.
+func signTranscript(t *ceremony.Transcript, path string) error {
+	for {
+		signer, err := utils.ReadLine("Signer name (empty when all present have signed): ")
+		if err != nil || signer == "" {
+			return err
+		}
+		keyPath, err := utils.ReadLine(fmt.Sprintf("Signing key (PEM) of %s: ", signer))
+		if err != nil {
+			return err
+		}
+		if err := signWith(t, signer, keyPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if err := os.WriteFile(path, t.Encode(), 0o644); err != nil {
+			return fmt.Errorf("failed to write transcript '%s': %w", path, err)
+		}
+		fmt.Fprintf(os.Stderr, "Signed by %s\n", signer)
+	}
+}
+
+// signWith appends signer's signature made with the key in keyPath.
+func signWith(t *ceremony.Transcript, signer, keyPath string) error {
+	key, err := utils.LoadPrivateKeyFromFile(keyPath, utils.PromptPassphrase(keyPath))
+	if err != nil {
+		return err
+	}
+	return t.Sign(signer, key, time.Now())
+}
+
+// ceremonyCmd guides the creation of a root CA and records it in a transcript the participants sign.
+var ceremonyCmd = &cobra.Command{
+	Use:   "ceremony",
+	Short: "Walk operators through a root key ceremony step by step, recording every parameter and output in a transcript that each custodian present signs.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		transcriptPath, _ := cmd.Flags().GetString("transcript")
+		if transcriptPath == "" {
+			return invalid(errors.New("must specify --transcript for the ceremony transcript"))
+		}
+		if _, err := os.Stat(transcriptPath); err == nil {
+			return fmt.Errorf("transcript '%s' already exists", transcriptPath)
+		}
+
+		fmt.Fprintln(os.Stderr, "Step 1: the ceremony")
+		name, err := ask("Ceremony name", "Root CA key ceremony")
+		if err != nil {
+			return err
+		}
+		rec := ceremony.NewRecorder(name)
+		rec.Step("Ceremony")
+		for _, q := range []string{"Location", "Operator", "Witnesses present", "Custodians present"} {
+			answer, err := ask(q, "")
+			if err != nil {
+				return err
+			}
+			rec.Record(q, answer)
+		}
+		host, _ := os.Hostname()
+		rec.Record("Host", host)
+		rec.Record("Platform", runtime.GOOS+"/"+runtime.GOARCH+", "+runtime.Version())
+		if exe, err := os.Executable(); err == nil {
+			if sum, err := fileSHA256(exe); err == nil {
+				rec.Record("Tool SHA-256", sum)
+			}
+		}
+		rec.Record("Started at", time.Now().UTC().Format(time.RFC3339))
+
+		fmt.Fprintln(os.Stderr, "\nStep 2: the root CA")
+		var a rootArgs
+		cn, err := ask("Common Name", "")
+		if err != nil {
+			return err
+		}
+		if cn == "" {
+			return errors.New("the root needs a Common Name")
+		}
+		a.set("cn", cn)
+		for _, f := range [][2]string{{"org", "Organization"}, {"country", "Country (2-letter code)"}} {
+			answer, err := ask(f[1], "")
+			if err != nil {
+				return err
+			}
+			if answer != "" {
+				a.set(f[0], answer)
+			}
+		}
+		days, err := askInt("Validity in days", 3650)
+		if err != nil {
+			return err
+		}
+		a.set("days", strconv.Itoa(days))
+
+		fmt.Fprintln(os.Stderr, "\nStep 3: the key shares")
+		n, err := askInt("Number of shares", 3)
+		if err != nil {
+			return err
+		}
+		t, err := askInt("Threshold", 2)
+		if err != nil {
+			return err
+		}
+		if t > n {
+			return fmt.Errorf("threshold %d exceeds the %d shares", t, n)
+		}
+		a.set("n", strconv.Itoa(n))
+		a.set("t", strconv.Itoa(t))
+		var labels, sharePaths []string
+		for i := 1; i <= n; i++ {
+			label, err := ask(fmt.Sprintf("Custodian of share %d", i), "")
+			if err != nil {
+				return err
+			}
+			path, err := ask(fmt.Sprintf("File for share %d", i), fmt.Sprintf("root-share-%d.pem", i))
+			if err != nil {
+				return err
+			}
+			labels = append(labels, label)
+			sharePaths = append(sharePaths, path)
+		}
+		a.set("shares-out", strings.Join(sharePaths, ","))
+		if strings.Join(labels, "") != "" {
+			a.set("custodians", strings.Join(labels, ","))
+		}
+		protection, err := ask("Share protection (none, passphrase, age, fido2)", "passphrase")
+		if err != nil {
+			return err
+		}
+		switch protection {
+		case "none":
+		case "passphrase":
+			a.set("encrypt-shares", "true")
+		case "age":
+			recipients, err := ask("Custodians' age recipients, comma-separated in share order", "")
+			if err != nil {
+				return err
+			}
+			a.set("recipients", recipients)
+		case "fido2":
+			a.set("fido2", "true")
+		default:
+			return fmt.Errorf("unknown share protection '%s'", protection)
+		}
+		verifiable, err := ask("Publish Feldman VSS commitments? (y/N)", "")
+		if err != nil {
+			return err
+		}
+		envelope := ""
+		if strings.EqualFold(verifiable, "y") {
+			a.set("vss", "true")
+		} else if envelope, err = ask("Split only a key-encryption key, with the key wrapped next to the certificate? (y/N)", ""); err != nil {
+			return err
+		} else if strings.EqualFold(envelope, "y") {
+			a.set("envelope", "true")
+		}
+		pemOut, err := ask("Root certificate file", "root.pem")
+		if err != nil {
+			return err
+		}
+		a.set("pem-out", pemOut)
+
+		fmt.Fprintf(os.Stderr, "\nReview:\n  pki create-root %s\n", strings.Join(a.cmdline, " "))
+		answer, err := utils.ReadLine("Proceed? [y/N]: ")
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(answer, "y") {
+			return errors.New("ceremony aborted before the key was generated")
+		}
+		rec.Step("Parameters")
+		for _, f := range a.flags {
+			rec.Record(f[0], f[1])
+		}
+		rec.Record("Share protection", protection)
+		rec.Record("Command", "pki create-root "+strings.Join(a.cmdline, " "))
+
+		fmt.Fprintln(os.Stderr, "\nStep 4: key generation")
+		rec.Step("Key generation")
+		rec.Record("Generation started at", time.Now().UTC().Format(time.RFC3339))
+		if err := a.runCreateRoot(); err != nil {
+			return err
+		}
+		rec.Record("Generation finished at", time.Now().UTC().Format(time.RFC3339))
+
+		rec.Step("Outputs")
+		cert, err := utils.ParseCertificateFromFile(pemOut)
+		if err != nil {
+			return err
+		}
+		keyID, err := utils.PublicKeyID(cert.PublicKey)
+		if err != nil {
+			return err
+		}
+		rec.Record("Certificate", pemOut)
+		rec.Record("Certificate SHA-256", inventory.Fingerprint(cert))
+		rec.Record("Subject", cert.Subject.String())
+		rec.Record("Serial", cert.SerialNumber.Text(16))
+		rec.Record("Not before", cert.NotBefore.UTC().Format(time.RFC3339))
+		rec.Record("Not after", cert.NotAfter.UTC().Format(time.RFC3339))
+		rec.Record("Key ID", keyID)
+		for i, path := range sharePaths {
+			s, err := utils.ReadShareFile(path)
+			if err != nil {
+				return err
+			}
+			sum, err := fileSHA256(path)
+			if err != nil {
+				return err
+			}
+			rec.Record(fmt.Sprintf("Share %d", i+1), fmt.Sprintf("%s custodian=%s split=%s sha256=%s", path, s.Custodian(), s.SplitID, sum))
+			clear(s.Data)
+		}
+		if strings.EqualFold(verifiable, "y") {
+			path := vss.PathForCA(pemOut)
+			c, _, err := vss.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rec.Record("VSS commitments", path+" fingerprint="+c.Fingerprint())
+		}
+		if strings.EqualFold(envelope, "y") {
+			path := utils.WrappedKeyPathForCA(pemOut)
+			sum, err := fileSHA256(path)
+			if err != nil {
+				return err
+			}
+			rec.Record("Wrapped key", path+" sha256="+sum)
+		}
+		rec.Record("Finished at", time.Now().UTC().Format(time.RFC3339))
+
+		transcript := rec.Transcript()
+		if err := os.WriteFile(transcriptPath, transcript.Encode(), 0o644); err != nil {
+			return fmt.Errorf("failed to write transcript '%s': %w", transcriptPath, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "\nStep 5: signatures\nTranscript written to %s (SHA-256 %s); each participant present should check it and sign.\n", transcriptPath, transcript.Digest())
+		if err := signTranscript(transcript, transcriptPath); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Ceremony complete: %s signed by %d participant(s).\n", transcriptPath, len(transcript.Signatures))
+		return nil
+	},
+}
+
+// ceremonySignCmd adds a signature to a transcript after the ceremony, e.g. by a remote witness.
+var ceremonySignCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Countersign a ceremony transcript.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("transcript")
+		keyPath, _ := cmd.Flags().GetString("key")
+		signer, _ := cmd.Flags().GetString("signer")
+		if path == "" || keyPath == "" || signer == "" {
+			return invalid(errors.New("must specify --transcript, --key and --signer"))
+		}
+		t, err := ceremony.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, s := range t.Signatures {
+			if err := s.Verify(t.Body); err != nil {
+				return fmt.Errorf("refusing to countersign: %w", err)
+			}
+		}
+		if err := signWith(t, signer, keyPath); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, t.Encode(), 0o644); err != nil {
+			return fmt.Errorf("failed to write transcript '%s': %w", path, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Transcript %s signed by %s (%d signature(s)).\n", path, signer, len(t.Signatures))
+		return nil
+	},
+}
+
+// ceremonyVerifyCmd checks a transcript's signatures and, optionally, that it describes a certificate.
+var ceremonyVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the signatures of a ceremony transcript and that it records the given CA certificate.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("transcript")
+		if path == "" {
+			return invalid(errors.New("must specify --transcript"))
+		}
+		t, err := ceremony.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Transcript %s (SHA-256 %s)\n", path, t.Digest())
+		invalid := 0
+		for _, s := range t.Signatures {
+			if err := s.Verify(t.Body); err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), " - INVALID signature by %s\n", s.Signer)
+				invalid++
+				continue
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), " - signed by %s at %s, key SHA-256 %s\n", s.Signer, s.SignedAt.Format(time.RFC3339), s.KeyFingerprint())
+		}
+		if len(t.Signatures) == 0 {
+			return errors.New("the transcript is not signed")
+		}
+		if invalid > 0 {
+			return fmt.Errorf("%d of %d signatures are invalid", invalid, len(t.Signatures))
+		}
+		if caPem, _ := cmd.Flags().GetString("ca-pem"); caPem != "" {
+			cert, err := utils.ParseCertificateFromFile(caPem)
+			if err != nil {
+				return err
+			}
+			if recorded := t.Value("Certificate SHA-256"); recorded != inventory.Fingerprint(cert) {
+				return fmt.Errorf("'%s' is not the certificate recorded in the transcript (%s)", caPem, recorded)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "'%s' is the certificate created in this ceremony.\n", caPem)
+		}
+		return nil
+	},
+}
+
+func init() {
+	ceremonyCmd.Flags().String("transcript", "", "File to write the ceremony transcript to (must not exist)")
+	ceremonySignCmd.Flags().String("transcript", "", "Ceremony transcript to sign")
+	ceremonySignCmd.Flags().String("key", "", "Signer's private key (PEM, ECDSA)")
+	ceremonySignCmd.Flags().String("signer", "", "Signer's name as recorded in the transcript")
+	ceremonyVerifyCmd.Flags().String("transcript", "", "Ceremony transcript to verify")
+	ceremonyVerifyCmd.Flags().String("ca-pem", "", "CA certificate the transcript should record (optional)")
+	ceremonyCmd.AddCommand(ceremonySignCmd, ceremonyVerifyCmd)
+	rootCmd.AddCommand(ceremonyCmd)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"my-pki/internal/crl"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Revocation statuses reported by a source.
+const (
+	revGood    = "good"
+	revRevoked = "revoked"
+	revUnknown = "unknown"
+)
+
+// maxFetchSize bounds the responses check-revocation reads, CRLs included.
+const maxFetchSize = 64 << 20
+
+// revocationCheck is the answer of one CRL or OCSP responder about a certificate.
+type revocationCheck struct {
+	status    string
+	revokedAt time.Time
+	reason    int
+}
+
+// checkRevocationCmd verifies the distribution pipeline end to end from a relying party's point of view.
+var checkRevocationCmd = &cobra.Command{
+	Use:   "check-revocation <cert.pem>",
+	Short: "Fetch a certificate's CRLs and OCSP responses from the URLs it names and report its revocation status.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cert, err := utils.ParseCertificateFromFile(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate from '%s': %w", args[0], err)
+		}
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		client := &http.Client{Timeout: timeout}
+
+		issuer, err := revocationIssuer(cmd, client, cert)
+		if err != nil {
+			return err
+		}
+		if err := cert.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("'%s' was not issued by %s: %w", args[0], issuer.Subject, err)
+		}
+		if len(cert.CRLDistributionPoints) == 0 && len(cert.OCSPServer) == 0 {
+			return errors.New("the certificate names no CRL distribution point and no OCSP responder")
+		}
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Certificate: %s (serial %x), issued by %s\n", cert.Subject, cert.SerialNumber, issuer.Subject)
+
+		var answers []revocationCheck
+		for _, u := range cert.CRLDistributionPoints {
+			check, err := checkCRL(cmd.OutOrStdout(), client, u, cert, issuer, now)
+			if err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "CRL %s: error: %v\n", u, err)
+				continue
+			}
+			answers = append(answers, check)
+		}
+		for _, u := range cert.OCSPServer {
+			check, err := checkOCSP(cmd.OutOrStdout(), client, u, cert, issuer, now)
+			if err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "OCSP %s: error: %v\n", u, err)
+				continue
+			}
+			answers = append(answers, check)
+		}
+
+		var revoked *revocationCheck
+		good := 0
+		for i, a := range answers {
+			switch a.status {
+			case revRevoked:
+				revoked = &answers[i]
+			case revGood:
+				good++
+			}
+		}
+		switch {
+		case revoked != nil:
+			if good > 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "Warning: the sources disagree; a CRL or OCSP response is out of date")
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Status: REVOKED at %s (%s)\n", revoked.revokedAt.Format(time.RFC3339), inventory.ReasonName(revoked.reason))
+			return errors.New("the certificate is revoked")
+		case good > 0:
+			fmt.Fprintf(cmd.OutOrStdout(), "Status: not revoked (%d of %d source(s) answered)\n", good, len(cert.CRLDistributionPoints)+len(cert.OCSPServer))
+			return nil
+		}
+		return errors.New("the revocation status could not be determined")
+	},
+}
+
+// revocationIssuer returns the certificate from --issuer, or else the one at the certificate's AIA caIssuers URL.
+func revocationIssuer(cmd *cobra.Command, client *http.Client, cert *x509.Certificate) (*x509.Certificate, error) {
+	if path, _ := cmd.Flags().GetString("issuer"); path != "" {
+		issuer, err := utils.ParseCertificateFromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse issuer certificate from '%s': %w", path, err)
+		}
+		return issuer, nil
+	}
+	if len(cert.IssuingCertificateURL) == 0 {
+		return nil, invalid(errors.New("must specify --issuer: the certificate has no AIA caIssuers URL to fetch it from"))
+	}
+	var errs []error
+	for _, u := range cert.IssuingCertificateURL {
+		data, err := fetch(client, u)
+		if err == nil {
+			var issuer *x509.Certificate
+			if issuer, err = x509.ParseCertificate(derOrPEM(data)); err == nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Issuer fetched from %s\n", u)
+				return issuer, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", u, err))
+	}
+	return nil, fmt.Errorf("failed to fetch the issuer certificate: %w", errors.Join(errs...))
+}
+
+// checkCRL reports to out as it looks cert up in the CRL at u and in the delta CRLs it points to.
+func checkCRL(out io.Writer, client *http.Client, u string, cert, issuer *x509.Certificate, now time.Time) (revocationCheck, error) {
+	rl, err := fetchCRL(client, u, issuer, now)
+	if err != nil {
+		return revocationCheck{}, err
+	}
+	check := lookupCRL(rl, cert)
+	fmt.Fprintf(out, "CRL %s: %s (CRL #%s, next update %s)\n", u, check.status, rl.Number, rl.NextUpdate.Format(time.RFC3339))
+	if check.status == revRevoked && check.reason != inventory.ReasonCertificateHold {
+		return check, nil
+	}
+
+	// Revocations newer than the full CRL are only in its delta CRLs
+	deltaURLs, err := crl.FreshestURLs(rl)
+	if err != nil {
+		fmt.Fprintf(out, "  delta CRLs: error: %v\n", err)
+		return check, nil
+	}
+	for _, du := range deltaURLs {
+		delta, err := fetchDeltaCRL(client, du, rl, issuer, now)
+		if err != nil {
+			fmt.Fprintf(out, "  delta CRL %s: error: %v\n", du, err)
+			continue
+		}
+		deltaCheck := lookupCRL(delta, cert)
+		if deltaCheck.status == revRevoked && deltaCheck.reason == inventory.ReasonRemoveFromCRL {
+			fmt.Fprintf(out, "  delta CRL %s: released from hold (CRL #%s)\n", du, delta.Number)
+			return revocationCheck{status: revGood}, nil
+		}
+		fmt.Fprintf(out, "  delta CRL %s: %s (CRL #%s)\n", du, deltaCheck.status, delta.Number)
+		if deltaCheck.status == revRevoked {
+			return deltaCheck, nil
+		}
+	}
+	return check, nil
+}
+
+// fetchCRL downloads the CRL at u and checks its issuer, signature and freshness.
+func fetchCRL(client *http.Client, u string, issuer *x509.Certificate, now time.Time) (*x509.RevocationList, error) {
+	data, err := fetch(client, u)
+	if err != nil {
+		return nil, err
+	}
+	rl, err := x509.ParseRevocationList(derOrPEM(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRL: %w", err)
+	}
+	if err := rl.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("CRL is not signed by %s: %w", issuer.Subject, err)
+	}
+	if !rl.NextUpdate.IsZero() && now.After(rl.NextUpdate) {
+		return nil, fmt.Errorf("CRL is stale: its next update was due %s", rl.NextUpdate.Format(time.RFC3339))
+	}
+	return rl, nil
+}
+
+// fetchDeltaCRL downloads the delta CRL at u and checks that it applies on top of the full CRL base.
+func fetchDeltaCRL(client *http.Client, u string, base *x509.RevocationList, issuer *x509.Certificate, now time.Time) (*x509.RevocationList, error) {
+	delta, err := fetchCRL(client, u, issuer, now)
+	if err != nil {
+		return nil, err
+	}
+	baseNumber, err := crl.DeltaBase(delta)
+	if err != nil {
+		return nil, err
+	}
+	if baseNumber == nil {
+		return nil, errors.New("not a delta CRL")
+	}
+	if baseNumber.Cmp(base.Number) > 0 {
+		return nil, fmt.Errorf("delta CRL needs base CRL #%s, newer than the CRL #%s served", baseNumber, base.Number)
+	}
+	return delta, nil
+}
+
+// lookupCRL reports whether cert is listed in rl.
+func lookupCRL(rl *x509.RevocationList, cert *x509.Certificate) revocationCheck {
+	for _, entry := range rl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return revocationCheck{status: revRevoked, revokedAt: entry.RevocationTime, reason: entry.ReasonCode}
+		}
+	}
+	return revocationCheck{status: revGood}
+}
+
+// checkOCSP asks the OCSP responder at u about cert and verifies the signed answer.
+func checkOCSP(out io.Writer, client *http.Client, u string, cert, issuer *x509.Certificate, now time.Time) (revocationCheck, error) {
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return revocationCheck{}, fmt.Errorf("failed to build OCSP request: %w", err)
+	}
+	resp, err := client.Post(u, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return revocationCheck{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return revocationCheck{}, fmt.Errorf("responder answered %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchSize))
+	if err != nil {
+		return revocationCheck{}, err
+	}
+	answer, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return revocationCheck{}, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	if !answer.NextUpdate.IsZero() && now.After(answer.NextUpdate) {
+		return revocationCheck{}, fmt.Errorf("OCSP response is stale: its next update was due %s", answer.NextUpdate.Format(time.RFC3339))
+	}
+	check := revocationCheck{status: revUnknown}
+	switch answer.Status {
+	case ocsp.Good:
+		check.status = revGood
+	case ocsp.Revoked:
+		check = revocationCheck{status: revRevoked, revokedAt: answer.RevokedAt, reason: answer.RevocationReason}
+	}
+	fmt.Fprintf(out, "OCSP %s: %s (produced %s)\n", u, check.status, answer.ProducedAt.Format(time.RFC3339))
+	return check, nil
+}
+
+// fetch downloads u over HTTP(S); other schemes such as LDAP are not supported.
+func fetch(client *http.Client, u string) ([]byte, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme '%s'", parsed.Scheme)
+	}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server answered %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxFetchSize))
+}
+
+// derOrPEM returns the DER content of data, decoding it first if it is PEM.
+func derOrPEM(data []byte) []byte {
+	if block, _ := pem.Decode(data); block != nil {
+		return block.Bytes
+	}
+	return data
+}
+
+func init() {
+	checkRevocationCmd.Flags().String("issuer", "", "Issuer certificate (PEM) (default: fetched from the certificate's AIA caIssuers URL)")
+	checkRevocationCmd.Flags().Duration("timeout", 10*time.Second, "Timeout of each HTTP request")
+	rootCmd.AddCommand(checkRevocationCmd)
+}
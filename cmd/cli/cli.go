@@ -1,17 +1,60 @@
 package main
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
+	"log/slog"
+	"math"
+	"my-pki/internal/audit"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/inventory"
+	"my-pki/internal/secmem"
 	"my-pki/internal/utils"
+	"net/url"
 	"os"
+	"time"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "pki",
 	Short: "A simple PKI CLI using Shamir Secret Sharing (no long-lived in-memory state)",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := configureLogging(cmd); err != nil {
+			return err
+		}
+		if err := configureCADir(cmd); err != nil {
+			return err
+		}
+		if err := configureSerials(cmd); err != nil {
+			return err
+		}
+		utils.PEMInfo, _ = cmd.Flags().GetBool("pem-info")
+		utils.WrappedKeyFile, _ = cmd.Flags().GetString("wrapped-key")
+		if lock, _ := cmd.Flags().GetBool("mlock"); lock {
+			if err := secmem.Enable(); err != nil {
+				return err
+			}
+		}
+		if err := configureOutput(cmd); err != nil {
+			return err
+		}
+		if err := configureResultOutput(cmd); err != nil {
+			return err
+		}
+		if err := configureQuiet(cmd); err != nil {
+			return err
+		}
+		configureAgents(cmd)
+		if err := configureClock(cmd); err != nil {
+			return err
+		}
+		commandStarted = true
+		return nil
+	},
 }
 
 // create-root
@@ -25,16 +68,20 @@ var createRootCmd = &cobra.Command{
 		}
 
 		days, _ := cmd.Flags().GetInt("days")
+		days, err = validityDays(cmd, days)
+		if err != nil {
+			return err
+		}
 		n, _ := cmd.Flags().GetInt("n")
 		t, _ := cmd.Flags().GetInt("t")
 		pemOut, _ := cmd.Flags().GetString("pem-out")
 		sharesOutStr, _ := cmd.Flags().GetString("shares-out")
 
 		if pemOut == "" {
-			return errors.New("must specify --pem-out for the root CA certificate")
+			return invalid(errors.New("must specify --pem-out for the root CA certificate"))
 		}
 		if sharesOutStr == "" {
-			return errors.New("must specify --shares-out for storing the key shares")
+			return invalid(errors.New("must specify --shares-out for storing the key shares"))
 		}
 
 		sharePaths := utils.ParseCommaSeparatedPaths(sharesOutStr)
@@ -44,12 +91,40 @@ var createRootCmd = &cobra.Command{
 		if n != len(sharePaths) {
 			return fmt.Errorf("number of share files (%d) does not match n=%d", len(sharePaths), n)
 		}
+		custodians, err := custodiansFromFlags(cmd, n)
+		if err != nil {
+			return err
+		}
+		protection, err := shareProtection(cmd, sharePaths, custodians)
+		if err != nil {
+			return err
+		}
+		passphrases, err := sharePassphrases(cmd, sharePaths, custodians)
+		if err != nil {
+			return err
+		}
+		allowed, _ := cmd.Flags().GetString("allowed-profiles")
+		if _, err := caconfig.ParseProfileList(allowed); err != nil {
+			return err
+		}
+		opts, err := issuanceOptions(cmd, caconfig.ProfileSettings{}, days)
+		if err != nil {
+			return err
+		}
+		// A root has no configuration yet, so it gets the default rules
+		subject = utils.DefaultNormalization.Subject(subject)
 
 		// Generate a self-signed root CA with default usage bits
 		defaultRootKU := x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
-		certPEM, privKey, err := utils.GenerateKeyAndCert(subject, nil, nil, true, days, defaultRootKU)
+		certPEM, privKey, err := utils.GenerateKeyAndCert(subject, nil, nil, true, days, defaultRootKU, opts...)
 		if err != nil {
-			return fmt.Errorf("failed to generate root CA: %w", err)
+			return withExitCode(exitSigning, fmt.Errorf("failed to generate root CA: %w", err))
+		}
+		wipeOnExit(privKey)
+
+		// Record the self-signed certificate as the first entry of the root's issuance log
+		if err := logIssuance(cmd, pemOut, certPEM, privKey); err != nil {
+			return err
 		}
 
 		// Write the certificate
@@ -57,15 +132,28 @@ var createRootCmd = &cobra.Command{
 		if err != nil {
 			return fmt.Errorf("failed to write root CA cert to '%s': %w", pemOut, err)
 		}
+		if err := recordLocation(cmd, certPEM, pemOut, ""); err != nil {
+			return err
+		}
+		if err := writeCAConfig(cmd, pemOut); err != nil {
+			return err
+		}
 
 		// Split the root key
-		err = utils.SplitKeyAndWriteShares(privKey, n, t, sharePaths)
+		err = utils.SplitKeyAndWriteShares(privKey, n, t, sharePaths, custodians, passphrases, splitOptions(cmd, pemOut, protection)...)
 		if err != nil {
 			return fmt.Errorf("failed to split root key: %w", err)
 		}
+		if err := recordCustody(cmd, pemOut, sharePaths); err != nil {
+			return err
+		}
 
-		fmt.Printf("Root CA created!\n - Certificate: %s\n - %d shares written.\n", pemOut, n)
-		return nil
+		slog.Info("root CA created", "cert", pemOut, "shares", n, "threshold", t)
+		fmt.Fprintf(cmd.OutOrStdout(), "Root CA created!\n - Certificate: %s\n - %d shares written.\n", pemOut, n)
+		if err := printCommitments(cmd, pemOut); err != nil {
+			return err
+		}
+		return emitCAResult(pemOut, certPEM, sharePaths, t)
 	},
 }
 
@@ -79,64 +167,112 @@ var createSubCACmd = &cobra.Command{
 			return err
 		}
 		days, _ := cmd.Flags().GetInt("days")
+		days, err = validityDays(cmd, days)
+		if err != nil {
+			return err
+		}
 		isIssuing, _ := cmd.Flags().GetBool("issuing")
 
 		parentPemPath, _ := cmd.Flags().GetString("parent-pem")
 		if parentPemPath == "" {
-			return errors.New("must specify --parent-pem for the parent CA certificate")
+			return invalid(errors.New("must specify --parent-pem for the parent CA certificate"))
 		}
 		parentCert, err := utils.ParseCertificateFromFile(parentPemPath)
 		if err != nil {
 			return fmt.Errorf("failed to parse parent CA certificate: %w", err)
 		}
+		days, settings, err := resolveProfile(cmd, parentPemPath, caconfig.ProfileSubCA, days)
+		if err != nil {
+			return err
+		}
+		opts, err := issuanceOptions(cmd, settings, days)
+		if err != nil {
+			return err
+		}
+		allowed, _ := cmd.Flags().GetString("allowed-profiles")
+		if _, err := caconfig.ParseProfileList(allowed); err != nil {
+			return err
+		}
+		subject = settings.Normalization().Subject(subject)
+		hookReq, err := newHookRequest(cmd, parentPemPath, caconfig.ProfileSubCA, subject, nil, days)
+		if err != nil {
+			return err
+		}
+		if err := preIssueHooks(settings, hookReq); err != nil {
+			return err
+		}
 
-		parentSharesInStr, _ := cmd.Flags().GetString("parent-shares-in")
-		parentSharePaths := utils.ParseCommaSeparatedPaths(parentSharesInStr)
-		if len(parentSharePaths) == 0 {
-			return errors.New("no valid file paths found in --parent-shares-in")
+		n, _ := cmd.Flags().GetInt("n")
+		t, _ := cmd.Flags().GetInt("t")
+		sharesOutStr, _ := cmd.Flags().GetString("shares-out")
+		sharePaths := utils.ParseCommaSeparatedPaths(sharesOutStr)
+		if n != len(sharePaths) {
+			return fmt.Errorf("number of share files (%d) does not match n=%d", len(sharePaths), n)
 		}
-		parentKeyBytes, err := utils.CombineSharesFromFiles(parentSharePaths)
+		custodians, err := custodiansFromFlags(cmd, n)
 		if err != nil {
-			return fmt.Errorf("failed to combine parent CA shares: %w", err)
+			return err
 		}
-		parentKey, err := x509.ParseECPrivateKey(parentKeyBytes)
+		// Asked before signing, so a mistyped passphrase does not leave a certificate without shares
+		protection, err := shareProtection(cmd, sharePaths, custodians)
 		if err != nil {
-			return fmt.Errorf("failed to parse parent CA private key: %w", err)
+			return err
+		}
+		passphrases, err := sharePassphrases(cmd, sharePaths, custodians)
+		if err != nil {
+			return err
+		}
+
+		parentSharesInStr, _ := cmd.Flags().GetString("parent-shares-in")
+		parentKeyPath, _ := cmd.Flags().GetString("parent-key")
+		parentKey, err := loadCAKey(cmd, parentPemPath, parentSharesInStr, parentKeyPath, "--parent-shares-in", "--parent-key")
+		if err != nil {
+			return fmt.Errorf("failed to load parent CA private key: %w", err)
 		}
 
 		// Default KeyUsage for subCA
 		defaultSubCAKU := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
-		subCACertPEM, subCAKey, err := utils.GenerateKeyAndCert(subject, parentCert, parentKey, true, days, defaultSubCAKU)
+		subCACertPEM, subCAKey, err := utils.GenerateKeyAndCert(subject, parentCert, parentKey, true, days, defaultSubCAKU, opts...)
 		if err != nil {
-			return fmt.Errorf("failed to generate subCA: %w", err)
+			return withExitCode(exitSigning, fmt.Errorf("failed to generate subCA: %w", err))
 		}
+		wipeOnExit(subCAKey)
 
 		subCAPemOut, _ := cmd.Flags().GetString("pem-out")
 		if subCAPemOut == "" {
-			return errors.New("must specify --pem-out to store the subCA certificate")
+			return invalid(errors.New("must specify --pem-out to store the subCA certificate"))
+		}
+		if err := logIssuance(cmd, parentPemPath, subCACertPEM, parentKey); err != nil {
+			return err
 		}
 		err = utils.WriteCertificateToFile(subCACertPEM, subCAPemOut)
 		if err != nil {
 			return fmt.Errorf("failed to write subCA certificate to '%s': %w", subCAPemOut, err)
 		}
-
-		n, _ := cmd.Flags().GetInt("n")
-		t, _ := cmd.Flags().GetInt("t")
-		sharesOutStr, _ := cmd.Flags().GetString("shares-out")
-		sharePaths := utils.ParseCommaSeparatedPaths(sharesOutStr)
-		if n != len(sharePaths) {
-			return fmt.Errorf("number of share files (%d) does not match n=%d", len(sharePaths), n)
+		if err := recordLocation(cmd, subCACertPEM, subCAPemOut, caconfig.ProfileSubCA); err != nil {
+			return err
+		}
+		if err := writeCAConfig(cmd, subCAPemOut); err != nil {
+			return err
 		}
 
-		err = utils.SplitKeyAndWriteShares(subCAKey, n, t, sharePaths)
+		err = utils.SplitKeyAndWriteShares(subCAKey, n, t, sharePaths, custodians, passphrases, splitOptions(cmd, subCAPemOut, protection)...)
 		if err != nil {
 			return fmt.Errorf("failed to split subCA key: %w", err)
 		}
+		if err := recordCustody(cmd, subCAPemOut, sharePaths); err != nil {
+			return err
+		}
+		postIssueHooks(settings, hookReq, subCACertPEM, subCAPemOut)
 
-		fmt.Printf("SubCA created!\n - Cert: %s\n - Issuing: %v\n - %d shares written.\n",
+		slog.Info("sub-CA created", "cert", subCAPemOut, "parent", parentPemPath, "issuing", isIssuing, "shares", n, "threshold", t)
+		fmt.Fprintf(cmd.OutOrStdout(), "SubCA created!\n - Cert: %s\n - Issuing: %v\n - %d shares written.\n",
 			subCAPemOut, isIssuing, n,
 		)
-		return nil
+		if err := printCommitments(cmd, subCAPemOut); err != nil {
+			return err
+		}
+		return emitCAResult(subCAPemOut, subCACertPEM, sharePaths, t)
 	},
 }
 
@@ -145,118 +281,588 @@ var signCmd = &cobra.Command{
 	Use:   "sign",
 	Short: "Sign a leaf certificate with a given CA. Requires CA certificate and shares for private key.",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := promptSign(cmd); err != nil {
+			return err
+		}
 		subject, err := utils.BuildSubject(cmd)
 		if err != nil {
 			return err
 		}
 		days, _ := cmd.Flags().GetInt("days")
+		days, err = validityDays(cmd, days)
+		if err != nil {
+			return err
+		}
+
+		keyFormat, _ := cmd.Flags().GetString("key-format")
+		if keyFormat != utils.KeyFormatSEC1 && keyFormat != utils.KeyFormatPKCS8 {
+			return invalid(fmt.Errorf("invalid --key-format '%s' (expected %s or %s)", keyFormat, utils.KeyFormatSEC1, utils.KeyFormatPKCS8))
+		}
+
+		keyOut, _ := cmd.Flags().GetString("key-out")
+		keyPass, err := leafKeyPassphrase(cmd, keyOut)
+		if err != nil {
+			return err
+		}
+
+		// With --pubkey-in the key pair was generated elsewhere; only its public half is certified
+		var externalPub crypto.PublicKey
+		pubkeyIn, _ := cmd.Flags().GetString("pubkey-in")
+		if pubkeyIn != "" {
+			if keyOut != "" {
+				return errors.New("--key-out cannot be used with --pubkey-in (no private key is generated)")
+			}
+			externalPub, err = utils.ParsePublicKeyFile(pubkeyIn)
+			if err != nil {
+				return err
+			}
+		}
 
 		caPem, _ := cmd.Flags().GetString("ca-pem")
 		if caPem == "" {
-			return errors.New("must specify --ca-pem for the signing CA certificate")
+			return invalid(errors.New("must specify --ca-pem for the signing CA certificate (or -i to be prompted)"))
 		}
 		caCert, err := utils.ParseCertificateFromFile(caPem)
 		if err != nil {
 			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
 		}
-
-		sharesInStr, _ := cmd.Flags().GetString("shares-in")
-		sharesInPaths := utils.ParseCommaSeparatedPaths(sharesInStr)
-		if len(sharesInPaths) == 0 {
-			return errors.New("no valid file paths in --shares-in")
-		}
-
-		caKeyBytes, err := utils.CombineSharesFromFiles(sharesInPaths)
+		profile, _ := cmd.Flags().GetString("profile")
+		days, settings, err := resolveProfile(cmd, caPem, profile, days)
 		if err != nil {
-			return fmt.Errorf("failed to combine CA shares: %w", err)
+			return err
 		}
-		caKey, err := x509.ParseECPrivateKey(caKeyBytes)
+		ku, err := profileKeyUsage(cmd, profile, settings)
 		if err != nil {
-			return fmt.Errorf("failed to parse CA private key: %w", err)
+			return err
 		}
-
-		// Gather KeyUsage from boolean flags:
-		var ku x509.KeyUsage
-		digitalSig, _ := cmd.Flags().GetBool("digital-signature")
-		keyEnc, _ := cmd.Flags().GetBool("key-encipherment")
-		dataEnc, _ := cmd.Flags().GetBool("data-encipherment")
-		keyAgree, _ := cmd.Flags().GetBool("key-agreement")
-		crlSign, _ := cmd.Flags().GetBool("crl-sign")
-		encipherOnly, _ := cmd.Flags().GetBool("encipher-only")
-		decipherOnly, _ := cmd.Flags().GetBool("decipher-only")
-
-		if digitalSig {
-			ku |= x509.KeyUsageDigitalSignature
+		opts, err := issuanceOptions(cmd, settings, days)
+		if err != nil {
+			return err
 		}
-		if keyEnc {
-			ku |= x509.KeyUsageKeyEncipherment
+		subject = settings.Normalization().Subject(subject)
+		sanFlags, _ := cmd.Flags().GetStringArray("san")
+		sans, err := utils.ParseSANs(sanFlags)
+		if err != nil {
+			return err
 		}
-		if dataEnc {
-			ku |= x509.KeyUsageDataEncipherment
+		sans = settings.Normalization().SANs(sans)
+		if err := settings.CheckSANs(sans); err != nil {
+			return fmt.Errorf("profile '%s': %w", profile, err)
 		}
-		if keyAgree {
-			ku |= x509.KeyUsageKeyAgreement
+		opts = append(opts, utils.WithSANs(sans))
+		if externalPub != nil {
+			if err := utils.CheckKeyAlgorithm(externalPub, settings.KeyAlgorithm); err != nil {
+				return fmt.Errorf("profile '%s': %w", profile, err)
+			}
 		}
-		if crlSign {
-			ku |= x509.KeyUsageCRLSign
+		hookReq, err := newHookRequest(cmd, caPem, profile, subject, &x509.Certificate{
+			DNSNames: sans.DNSNames, IPAddresses: sans.IPAddresses, EmailAddresses: sans.EmailAddresses, URIs: sans.URIs,
+		}, days)
+		if err != nil {
+			return err
 		}
-		if encipherOnly {
-			ku |= x509.KeyUsageEncipherOnly
+		if err := preIssueHooks(settings, hookReq); err != nil {
+			return err
 		}
-		if decipherOnly {
-			ku |= x509.KeyUsageDecipherOnly
+
+		sharesInStr, _ := cmd.Flags().GetString("shares-in")
+		caKeyPath, _ := cmd.Flags().GetString("ca-key")
+		caKey, err := loadCAKey(cmd, caPem, sharesInStr, caKeyPath, "--shares-in", "--ca-key")
+		if err != nil {
+			return fmt.Errorf("failed to load CA private key: %w", err)
 		}
 
-		// Generate the leaf certificate + private key
-		certPEM, leafPrivKey, err := utils.GenerateKeyAndCert(
-			subject,
-			caCert,
-			caKey,
-			false, // not a CA
-			days,
-			ku,
-		)
+		// Generate the leaf private key of the profile's algorithm, or certify the supplied public key
+		var certPEM []byte
+		var leafPrivKey *ecdsa.PrivateKey
+		if externalPub != nil {
+			certPEM, err = utils.SignPublicKey(subject, externalPub, caCert, caKey, false, days, ku, opts...)
+		} else if leafPrivKey, err = utils.GenerateKey(settings.KeyAlgorithm); err == nil {
+			certPEM, err = utils.SignPublicKey(subject, &leafPrivKey.PublicKey, caCert, caKey, false, days, ku, opts...)
+		}
 		if err != nil {
-			return fmt.Errorf("failed to sign leaf certificate: %w", err)
+			return withExitCode(exitSigning, fmt.Errorf("failed to sign leaf certificate: %w", err))
 		}
 
 		certOut, _ := cmd.Flags().GetString("cert-out")
+		if caHome != "" && (certOut == "" || keyOut == "" && leafPrivKey != nil) {
+			// A CA home keeps what it issued by serial
+			serial, err := issuedSerial(certPEM)
+			if err != nil {
+				return err
+			}
+			if certOut == "" {
+				certOut = caHome.NewCertPath(serial)
+			}
+			if keyOut == "" && leafPrivKey != nil {
+				keyOut = caHome.KeyPath(serial)
+			}
+		}
 		if certOut == "" {
-			return errors.New("must specify --cert-out for the signed certificate")
+			return invalid(errors.New("must specify --cert-out for the signed certificate"))
+		}
+		if err := logIssuance(cmd, caPem, certPEM, caKey); err != nil {
+			return err
 		}
 		err = utils.WriteCertificateToFile(certPEM, certOut)
 		if err != nil {
 			return fmt.Errorf("failed to write signed certificate to '%s': %w", certOut, err)
 		}
+		if err := recordLocation(cmd, certPEM, certOut, profile); err != nil {
+			return err
+		}
 
 		// If user specified --key-out, write the newly generated leaf key
-		keyOut, _ := cmd.Flags().GetString("key-out")
 		if keyOut != "" {
-			err := utils.WriteECPrivateKeyToFile(leafPrivKey, keyOut)
+			var err error
+			if keyPass != nil {
+				err = utils.WriteEncryptedPrivateKeyToFile(leafPrivKey, keyOut, keyPass)
+			} else {
+				err = utils.WritePrivateKeyToFile(leafPrivKey, keyOut, keyFormat)
+			}
 			if err != nil {
 				return fmt.Errorf("failed to write leaf private key to '%s': %w", keyOut, err)
 			}
 		}
+		postIssueHooks(settings, hookReq, certPEM, certOut)
 
-		fmt.Printf("Signed certificate written to %s\n", certOut)
+		slog.Info("certificate written", "cert", certOut, "key_path", keyOut, "profile", profile, "ca", caPem)
+		fmt.Fprintf(cmd.OutOrStdout(), "Signed certificate written to %s (profile %s)\n", certOut, profile)
 		if keyOut != "" {
-			fmt.Printf("Leaf private key written to %s\n", keyOut)
+			fmt.Fprintf(cmd.OutOrStdout(), "Leaf private key written to %s\n", keyOut)
 		}
-		return nil
+		result, err := newCertResult(certOut, certPEM)
+		if err != nil {
+			return err
+		}
+		result.Profile, result.KeyPath = profile, keyOut
+		return emitResult(result)
 	},
 }
 
-func main() {
-	// Common subject flags
-	addSubjectFlags := func(cmd *cobra.Command) {
-		cmd.Flags().String("cn", "", "Common Name")
-		cmd.Flags().String("org", "", "Organization Name")
-		cmd.Flags().String("ou", "", "Organizational Unit")
-		cmd.Flags().String("locality", "", "Locality (City)")
-		cmd.Flags().String("province", "", "Province or State")
-		cmd.Flags().String("country", "", "Country (2-letter code)")
-		cmd.Flags().Int("days", 365, "Validity period (in days)")
+// leafKeyPassphrase returns the passphrase used to encrypt --key-out, or nil if the key is written in clear.
+// --key-pass supplies it directly; --encrypt-key prompts for it (twice) on the terminal.
+func leafKeyPassphrase(cmd *cobra.Command, keyOut string) ([]byte, error) {
+	keyPass, _ := cmd.Flags().GetString("key-pass")
+	encrypt, _ := cmd.Flags().GetBool("encrypt-key")
+	if keyPass == "" && !encrypt {
+		return nil, nil
+	}
+	if keyOut == "" && caHome == "" {
+		return nil, errors.New("--key-pass/--encrypt-key require --key-out")
+	}
+	if keyPass != "" {
+		return []byte(keyPass), nil
+	}
+	if keyOut == "" {
+		// Named after its serial once issued
+		return utils.ReadNewPassphrase("the new private key")
+	}
+	return utils.ReadNewPassphrase(fmt.Sprintf("'%s'", keyOut))
+}
+
+// resolveProfile checks that the CA at caPem may issue profile and returns the validity to use,
+// applying the CA's per-profile default when neither --days nor --not-after was given, and the profile's settings.
+func resolveProfile(cmd *cobra.Command, caPem, profile string, requested int) (int, caconfig.ProfileSettings, error) {
+	explicit := cmd.Flags().Changed("days") || cmd.Flags().Changed("not-after")
+	return resolveProfileDays(cmd, caPem, profile, requested, explicit)
+}
+
+// resolveProfileDays is resolveProfile for a validity that was explicitly requested or not by other means than flags.
+func resolveProfileDays(cmd *cobra.Command, caPem, profile string, requested int, explicit bool) (int, caconfig.ProfileSettings, error) {
+	if err := checkNotCompromised(cmd, caPem); err != nil {
+		return 0, caconfig.ProfileSettings{}, err
+	}
+	cfg, err := caconfig.LoadForCA(caPem)
+	if err != nil {
+		return 0, caconfig.ProfileSettings{}, err
+	}
+	days, err := cfg.CheckIssuance(profile, requested, explicit)
+	if err != nil {
+		return 0, caconfig.ProfileSettings{}, fmt.Errorf("'%s': %w", caPem, err)
+	}
+	settings := cfg.Settings(profile)
+	if err := applyProfileOutput(cmd, caPem, settings.Output); err != nil {
+		return 0, caconfig.ProfileSettings{}, err
+	}
+	if settings.Distribution != nil {
+		d := settings.Distribution.Resolve(caPem)
+		settings.Distribution = &d
+	}
+	return days, settings, nil
+}
+
+// addProfileFlag registers the --profile flag of leaf-issuing commands.
+func addProfileFlag(cmd *cobra.Command) {
+	cmd.Flags().String("profile", caconfig.ProfileLeaf, "Issuance profile: leaf, server-tls, client-mtls, code-signing or one defined in the CA configuration")
+}
+
+// profileKeyUsage returns the key usages of a leaf certificate issued under profile: those of the
+// command's flags, which may only narrow the profile's, or the profile's when no flag is set.
+func profileKeyUsage(cmd *cobra.Command, profile string, settings caconfig.ProfileSettings) (x509.KeyUsage, error) {
+	if settings.Kind != caconfig.ProfileLeaf {
+		return 0, fmt.Errorf("profile '%s' issues CA certificates; use create-subca", profile)
+	}
+	ku, err := settings.KeyUsageFor(keyUsageFromFlags(cmd))
+	if err != nil {
+		return 0, fmt.Errorf("profile '%s': %w", profile, err)
+	}
+	return ku, nil
+}
+
+// addPolicyFlags registers the certificate policy flags.
+func addPolicyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("policy-oid", nil, "Certificate policy OID to include (repeatable); overrides the CA profile's policies")
+	cmd.Flags().String("cps-uri", "", "CPS URI qualifier attached to each --policy-oid")
+	cmd.Flags().String("user-notice", "", "User notice text (max 200 characters) attached to each --policy-oid")
+}
+
+// addDistributionFlags registers the flags setting the URLs relying parties fetch the issuer's CRLs, certificate and OCSP answers from.
+func addDistributionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("crl-url", nil, "CRL distribution point URL to embed (repeatable); overrides the CA configuration's")
+	cmd.Flags().StringArray("ca-issuers-url", nil, "AIA caIssuers URL of the issuer certificate to embed (repeatable); overrides the CA configuration's")
+	cmd.Flags().StringArray("ocsp-url", nil, "AIA OCSP responder URL to embed (repeatable); overrides the CA configuration's")
+}
+
+// addValidityFlags registers the flags setting an explicit validity period.
+func addValidityFlags(cmd *cobra.Command) {
+	cmd.Flags().String("not-before", "", "Start of the validity period (RFC3339, e.g. 2025-06-01T22:00:00Z) (default: now)")
+	cmd.Flags().String("not-after", "", "End of the validity period (RFC3339); replaces --days")
+	cmd.Flags().Duration("backdate", 0, "Move the start of the validity period back by this much (e.g. 5m) to tolerate clock skew")
+}
+
+// validityDays returns the validity period in days: requested, or the span up to --not-after when given.
+func validityDays(cmd *cobra.Command, requested int) (int, error) {
+	notBefore, notAfter, err := validityFlags(cmd)
+	if err != nil || notAfter.IsZero() {
+		return requested, err
+	}
+	if cmd.Flags().Changed("days") {
+		return 0, errors.New("--days and --not-after are mutually exclusive")
+	}
+	if notBefore.IsZero() {
+		if notBefore, err = utils.Now(); err != nil {
+			return 0, err
+		}
+	}
+	if !notAfter.After(notBefore) {
+		return 0, fmt.Errorf("--not-after %s is not after the start of the validity period (%s)", notAfter.Format(time.RFC3339), notBefore.Format(time.RFC3339))
+	}
+	return int(math.Ceil(notAfter.Sub(notBefore).Hours() / 24)), nil
+}
+
+// validityFlags parses --not-before and --not-after; unset flags yield zero times.
+func validityFlags(cmd *cobra.Command) (notBefore, notAfter time.Time, err error) {
+	for _, f := range []struct {
+		name string
+		t    *time.Time
+	}{{"not-before", &notBefore}, {"not-after", &notAfter}} {
+		v, _ := cmd.Flags().GetString(f.name)
+		if v == "" {
+			continue
+		}
+		if *f.t, err = time.Parse(time.RFC3339, v); err != nil {
+			return time.Time{}, time.Time{}, invalid(fmt.Errorf("invalid --%s '%s' (expected RFC3339, e.g. 2025-06-01T22:00:00Z): %w", f.name, v, err))
+		}
+	}
+	return notBefore, notAfter, nil
+}
+
+// validityOption returns the option applying --not-before, --not-after and --backdate, or nil if none is set.
+// The profile's maxDays (0 for none) bounds the whole period, including the time --backdate adds.
+func validityOption(cmd *cobra.Command, days, maxDays int) (utils.CertOption, error) {
+	notBefore, notAfter, err := validityFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	backdate, _ := cmd.Flags().GetDuration("backdate")
+	if backdate < 0 {
+		return nil, errors.New("--backdate must not be negative")
+	}
+	if notBefore.IsZero() && notAfter.IsZero() && backdate == 0 {
+		return nil, nil
+	}
+	if notBefore.IsZero() {
+		if notBefore, err = utils.Now(); err != nil {
+			return nil, err
+		}
+	}
+	if notAfter.IsZero() {
+		notAfter = notBefore.Add(time.Duration(days) * 24 * time.Hour)
+	}
+	notBefore = notBefore.Add(-backdate)
+	if maxDays > 0 && notAfter.Sub(notBefore) > time.Duration(maxDays)*24*time.Hour {
+		return nil, fmt.Errorf("--backdate %s stretches the validity to %.2f days, beyond the profile's %d day maximum; lower --days or --backdate",
+			backdate, notAfter.Sub(notBefore).Hours()/24, maxDays)
+	}
+	return utils.WithValidity(notBefore, notAfter), nil
+}
+
+// issuanceOptions returns the certificate options for an issuance: the validity flags, and policies
+// from the flags or else those configured for the profile on the issuing CA.
+func issuanceOptions(cmd *cobra.Command, settings caconfig.ProfileSettings, days int) ([]utils.CertOption, error) {
+	oids, _ := cmd.Flags().GetStringArray("policy-oid")
+	cpsURI, _ := cmd.Flags().GetString("cps-uri")
+	notice, _ := cmd.Flags().GetString("user-notice")
+	policies, err := utils.PolicyFromFlags(oids, cpsURI, notice)
+	if err != nil {
+		return nil, err
+	}
+	if policies == nil {
+		policies = settings.Policies
 	}
+	opts := []utils.CertOption{utils.WithPolicies(policies)}
+	_, ekus, err := settings.Usages()
+	if err != nil {
+		return nil, err
+	}
+	if len(ekus) > 0 {
+		opts = append(opts, utils.WithExtKeyUsage(ekus))
+	}
+	distribution, err := distributionOption(cmd, settings.Distribution)
+	if err != nil {
+		return nil, err
+	}
+	if distribution != nil {
+		opts = append(opts, distribution)
+	}
+	validity, err := validityOption(cmd, days, settings.MaxDays)
+	if err != nil {
+		return nil, err
+	}
+	if validity != nil {
+		opts = append(opts, validity)
+	}
+	return opts, nil
+}
+
+// distributionOption embeds the distribution URLs of the CA configuration, each list replaced by its flag
+// when given. It returns nil when there are none, leaving a reissued certificate's URLs as they were.
+func distributionOption(cmd *cobra.Command, settings *caconfig.DistributionSettings) (utils.CertOption, error) {
+	var d caconfig.DistributionSettings
+	if settings != nil {
+		d = *settings
+	}
+	for _, f := range []struct {
+		flag string
+		urls *[]string
+	}{{"crl-url", &d.CRLURLs}, {"ca-issuers-url", &d.CAIssuersURLs}, {"ocsp-url", &d.OCSPURLs}} {
+		if cmd.Flags().Changed(f.flag) {
+			*f.urls, _ = cmd.Flags().GetStringArray(f.flag)
+		}
+		for _, u := range *f.urls {
+			if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+				return nil, fmt.Errorf("invalid %s '%s': expected an absolute URL such as http://pki.example.com/ca.crl", f.flag, u)
+			}
+		}
+	}
+	if len(d.CRLURLs) == 0 && len(d.CAIssuersURLs) == 0 && len(d.OCSPURLs) == 0 {
+		return nil, nil
+	}
+	return utils.WithDistribution(d.CRLURLs, d.CAIssuersURLs, d.OCSPURLs), nil
+}
+
+// writeCAConfig records --allowed-profiles for a newly created CA, if given.
+func writeCAConfig(cmd *cobra.Command, caPem string) error {
+	allowed, _ := cmd.Flags().GetString("allowed-profiles")
+	if allowed == "" {
+		return nil
+	}
+	profiles, err := caconfig.ParseProfileList(allowed)
+	if err != nil {
+		return err
+	}
+	return (&caconfig.Config{AllowedProfiles: profiles}).Save(caconfig.PathForCA(caPem))
+}
+
+// custodiansFromFlags builds the share custodian roster from --custodians and --contacts.
+func custodiansFromFlags(cmd *cobra.Command, n int) ([]utils.Custodian, error) {
+	labels, _ := cmd.Flags().GetString("custodians")
+	contacts, _ := cmd.Flags().GetString("contacts")
+	return utils.ParseCustodians(labels, contacts, n)
+}
+
+// sharePassphrases asks each custodian for the passphrase of their share when --encrypt-shares is set.
+func sharePassphrases(cmd *cobra.Command, sharePaths []string, custodians []utils.Custodian) ([][]byte, error) {
+	if encrypt, _ := cmd.Flags().GetBool("encrypt-shares"); !encrypt {
+		return nil, nil
+	}
+	passphrases := make([][]byte, len(sharePaths))
+	for i, path := range sharePaths {
+		pass, err := utils.ReadNewPassphrase(fmt.Sprintf("share #%d (%s) in '%s'", i+1, custodians[i], path))
+		if err != nil {
+			return nil, err
+		}
+		passphrases[i] = pass
+	}
+	return passphrases, nil
+}
+
+// loadCAKey recovers the signing key of the CA at caPem either by combining Shamir shares or, for
+// CAs that are not under Shamir custody, by reading a (possibly encrypted) PEM key file.
+func loadCAKey(cmd *cobra.Command, caPem, sharesIn, keyPath, sharesFlag, keyFlag string) (crypto.Signer, error) {
+	sharePaths := utils.ParseCommaSeparatedPaths(sharesIn)
+	switch {
+	case len(sharePaths) > 0 && keyPath != "":
+		return nil, fmt.Errorf("%s and %s are mutually exclusive", sharesFlag, keyFlag)
+	case keyPath != "":
+		key, err := utils.LoadPrivateKeyFromFile(keyPath, utils.PromptPassphrase(keyPath))
+		if err != nil {
+			return nil, err
+		}
+		return wipeOnExit(key), nil
+	case len(sharePaths) == 0:
+		return nil, invalid(fmt.Errorf("must specify either %s or %s", sharesFlag, keyFlag))
+	}
+
+	_, key, err := combineShareFiles(cmd, caPem, sharePaths)
+	if err != nil {
+		return nil, withExitCode(exitCombine, err)
+	}
+	return key, nil
+}
+
+// combineShareFiles reads share files, reports which custodians' shares are present, asks for the
+// passphrases of encrypted shares and reconstructs the key of the CA at caPem, subject to its
+// combine policy. The shares are returned for their metadata.
+func combineShareFiles(cmd *cobra.Command, caPem string, sharePaths []string) ([]*utils.Share, *ecdsa.PrivateKey, error) {
+	var files, agents []string
+	for _, p := range sharePaths {
+		if isAgentURL(p) {
+			agents = append(agents, p)
+		} else {
+			files = append(files, p)
+		}
+	}
+	shares, err := utils.ReadShareFiles(files)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Only the shares' metadata is needed once the key is reconstructed
+	defer func() {
+		for _, s := range shares {
+			secmem.Wipe(s.Data)
+		}
+	}()
+	if err := checkCombinePolicy(cmd, caPem, shares); err != nil {
+		return nil, nil, err
+	}
+	if len(agents) > 0 {
+		remote, err := requestAgentShares(agents)
+		if err != nil {
+			return nil, nil, err
+		}
+		shares = append(shares, remote...)
+		if err := utils.CheckShares(shares); err != nil {
+			return nil, nil, err
+		}
+	}
+	if desc := utils.DescribeShares(shares); desc != "" {
+		fmt.Fprintln(progress, desc)
+	}
+	fmt.Fprintln(progress, "Quorum:", utils.DescribeQuorum(shares))
+	if err := utils.UnlockShares(shares, utils.PromptSharePassphrase); err != nil {
+		return nil, nil, err
+	}
+	keyBytes, err := utils.CombineShares(shares)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to combine shares: %w", err)
+	}
+	defer secmem.Wipe(keyBytes)
+	key, err := utils.ParsePrivateKeyDER(keyBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse combined private key: %w", err)
+	}
+	slog.Info("key reconstructed", "ca", caPem, "key_id", shares[0].KeyID, "shares", len(shares))
+	entry := audit.Entry{Event: audit.EventCombine, Params: map[string]string{"key_id": shares[0].KeyID, "shares": describeIndexes(shares)}}
+	auditCA(&entry, caPem)
+	if err := recordAudit(cmd, entry); err != nil {
+		secmem.WipeKey(key)
+		return nil, nil, err
+	}
+	return shares, wipeOnExit(key), nil
+}
+
+// keysToWipe holds the private keys recovered or generated by the running command.
+var keysToWipe []*ecdsa.PrivateKey
+
+// wipeOnExit records key to be wiped when the command returns, whether it succeeds or fails.
+func wipeOnExit(key *ecdsa.PrivateKey) *ecdsa.PrivateKey {
+	keysToWipe = append(keysToWipe, key)
+	return key
+}
+
+// addSubjectFlags registers the common subject flags read by utils.BuildSubject, plus the validity flags.
+func addSubjectFlags(cmd *cobra.Command) {
+	addSubjectNameFlags(cmd)
+	cmd.Flags().Int("days", 365, "Validity period (in days)")
+	addValidityFlags(cmd)
+}
+
+// addSubjectNameFlags registers the subject attribute flags read by utils.BuildSubject.
+func addSubjectNameFlags(cmd *cobra.Command) {
+	cmd.Flags().String("cn", "", "Common Name")
+	cmd.Flags().StringArray("org", nil, "Organization Name (repeatable)")
+	cmd.Flags().StringArray("ou", nil, "Organizational Unit (repeatable)")
+	cmd.Flags().StringArray("locality", nil, "Locality (City) (repeatable)")
+	cmd.Flags().StringArray("province", nil, "Province or State (repeatable)")
+	cmd.Flags().StringArray("country", nil, "Country (2-letter code) (repeatable)")
+	cmd.Flags().StringArray("street", nil, "Street address (repeatable)")
+	cmd.Flags().StringArray("postal-code", nil, "Postal code (repeatable)")
+	cmd.Flags().StringArray("dc", nil, "Domain component, in the order written in a domain name (e.g. --dc example --dc com) (repeatable)")
+	cmd.Flags().StringArray("email", nil, "Email address attribute of the subject (repeatable)")
+	cmd.Flags().String("serial-number", "", "Subject serialNumber attribute (e.g. a device or registration number)")
+}
+
+// addKeyUsageFlags registers the boolean key usage flags of leaf-issuing commands.
+func addKeyUsageFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("digital-signature", false, "Enable x509.KeyUsageDigitalSignature")
+	cmd.Flags().Bool("key-encipherment", false, "Enable x509.KeyUsageKeyEncipherment")
+	cmd.Flags().Bool("data-encipherment", false, "Enable x509.KeyUsageDataEncipherment")
+	cmd.Flags().Bool("key-agreement", false, "Enable x509.KeyUsageKeyAgreement")
+	cmd.Flags().Bool("crl-sign", false, "Enable x509.KeyUsageCRLSign")
+	cmd.Flags().Bool("encipher-only", false, "Enable x509.KeyUsageEncipherOnly")
+	cmd.Flags().Bool("decipher-only", false, "Enable x509.KeyUsageDecipherOnly")
+}
+
+// keyUsageFromFlags gathers the key usages enabled by the flags of addKeyUsageFlags.
+func keyUsageFromFlags(cmd *cobra.Command) x509.KeyUsage {
+	var ku x509.KeyUsage
+	for _, f := range []struct {
+		name  string
+		usage x509.KeyUsage
+	}{
+		{"digital-signature", x509.KeyUsageDigitalSignature},
+		{"key-encipherment", x509.KeyUsageKeyEncipherment},
+		{"data-encipherment", x509.KeyUsageDataEncipherment},
+		{"key-agreement", x509.KeyUsageKeyAgreement},
+		{"crl-sign", x509.KeyUsageCRLSign},
+		{"encipher-only", x509.KeyUsageEncipherOnly},
+		{"decipher-only", x509.KeyUsageDecipherOnly},
+	} {
+		if on, _ := cmd.Flags().GetBool(f.name); on {
+			ku |= f.usage
+		}
+	}
+	return ku
+}
+
+func main() {
+	// Global flags
+	rootCmd.PersistentFlags().String("time-token", "", "Signed time token to take issuance time from instead of the local clock")
+	rootCmd.PersistentFlags().String("time-authority", "", "Certificate (PEM) of the time authority that signed --time-token")
+	rootCmd.PersistentFlags().String("db", inventory.Default(), "Inventory recording issued certificates and their status, a SQLite database unless it ends in .json")
+	rootCmd.PersistentFlags().Bool("pem-info", false, "Describe certificates and CRLs (subject, issuer, serial, validity) in comment lines above their PEM blocks")
+	rootCmd.PersistentFlags().String("cert-perms", "", "Mode and ownership of written certificates as MODE[:OWNER[:GROUP]], e.g. 0644 (default: 0644 less the umask)")
+	rootCmd.PersistentFlags().String("key-perms", "", "Mode and ownership of written private keys as MODE[:OWNER[:GROUP]], e.g. 0640:svc:pki (default: 0600)")
+	rootCmd.PersistentFlags().String("share-perms", "", "Mode and ownership of written key shares as MODE[:OWNER[:GROUP]] (default: 0600)")
+	rootCmd.PersistentFlags().String("umask", "", "Octal mask removed from the default modes of files without explicit permissions, e.g. 027 (default: the process umask)")
+	rootCmd.PersistentFlags().String("coordinator-cert", "", "Client certificate (PEM) presented to custodian agents listed in --shares-in as https:// URLs")
+	rootCmd.PersistentFlags().String("coordinator-key", "", "Private key (PEM) of --coordinator-cert")
+	rootCmd.PersistentFlags().String("agent-ca", "", "CA certificate(s) (PEM) that issue custodian agent certificates")
+	rootCmd.PersistentFlags().Bool("mlock", false, "Lock reconstructed keys and shares in RAM so they are never swapped out, and disable core dumps (Linux)")
+	rootCmd.PersistentFlags().String("wrapped-key", "", "Wrapped key file of envelope shares, when it is not where the shares say (see create-root --envelope)")
+	rootCmd.PersistentFlags().Int("serial-bits", utils.DefaultSerialBits, fmt.Sprintf("Random bits in new serial numbers (%d-%d); serials are also checked against the inventory", utils.MinSerialBits, utils.MaxSerialBits))
 
 	// create-root
 	addSubjectFlags(createRootCmd)
@@ -264,40 +870,73 @@ func main() {
 	createRootCmd.Flags().Int("t", 2, "Threshold (quorum) number of shares required to recover the key")
 	createRootCmd.Flags().String("shares-out", "", "Comma-separated list of file paths for the key shares (must match n).")
 	createRootCmd.Flags().String("pem-out", "", "File path for the output root CA certificate (PEM)")
+	createRootCmd.Flags().String("custodians", "", "Comma-separated custodian labels, one per share in --shares-out order (optional)")
+	createRootCmd.Flags().String("contacts", "", "Comma-separated custodian contact details, one per share (optional)")
+	createRootCmd.Flags().Bool("vss", false, "Split with Feldman verifiable secret sharing and publish the commitments next to --pem-out (<name>.vss.pem)")
+	createRootCmd.Flags().Bool("envelope", false, "Encrypt the key under a random key-encryption key written next to --pem-out (<name>.wrapped-key.pem) and split only that key")
+	createRootCmd.Flags().Bool("encrypt-shares", false, "Encrypt each share with its custodian's passphrase (Argon2id), asked for when writing and combining")
+	createRootCmd.Flags().String("recipients", "", "Comma-separated age recipients (age1...) or files holding one, in --shares-out order, to encrypt each share to its custodian's key")
+	createRootCmd.Flags().Bool("fido2", false, "Seal each share to its custodian's FIDO2 token (hmac-secret); each token is enrolled in turn and needed with its PIN to combine")
+	createRootCmd.Flags().String("issuance-log", "", "Issuance log for the new root (default: <pem-out without extension>.issuance.log)")
+	createRootCmd.Flags().String("allowed-profiles", "", "Comma-separated profiles the root may issue (subca, leaf); written to <pem-out without extension>.ca.yaml")
+	addPolicyFlags(createRootCmd)
 
 	// create-subca
 	addSubjectFlags(createSubCACmd)
 	createSubCACmd.Flags().Bool("issuing", false, "Whether this subCA is an issuing CA or not (for informational use)")
 	createSubCACmd.Flags().String("parent-pem", "", "File path to parent CA certificate (PEM)")
 	createSubCACmd.Flags().String("parent-shares-in", "", "Comma-separated list of parent CA key share files")
+	createSubCACmd.Flags().String("parent-key", "", "File path to the parent CA private key (PEM, SEC1 or PKCS#8, optionally encrypted) instead of shares")
 	createSubCACmd.Flags().Int("n", 3, "Number of total key shares for subCA")
 	createSubCACmd.Flags().Int("t", 2, "Threshold (quorum) number of shares for subCA")
 	createSubCACmd.Flags().String("shares-out", "", "Comma-separated list of file paths for the subCA key shares (must match n).")
 	createSubCACmd.Flags().String("pem-out", "", "File path for the output subCA certificate (PEM)")
+	createSubCACmd.Flags().String("custodians", "", "Comma-separated custodian labels, one per subCA share in --shares-out order (optional)")
+	createSubCACmd.Flags().String("contacts", "", "Comma-separated custodian contact details, one per subCA share (optional)")
+	createSubCACmd.Flags().Bool("vss", false, "Split the subCA key with Feldman verifiable secret sharing and publish the commitments next to --pem-out")
+	createSubCACmd.Flags().Bool("envelope", false, "Encrypt the subCA key under a random key-encryption key written next to --pem-out and split only that key")
+	createSubCACmd.Flags().Bool("encrypt-shares", false, "Encrypt each subCA share with its custodian's passphrase (Argon2id), asked for when writing and combining")
+	createSubCACmd.Flags().String("recipients", "", "Comma-separated age recipients (age1...) or files holding one, in --shares-out order, to encrypt each subCA share to its custodian's key")
+	createSubCACmd.Flags().Bool("fido2", false, "Seal each subCA share to its custodian's FIDO2 token (hmac-secret); each token is enrolled in turn and needed with its PIN to combine")
+	createSubCACmd.Flags().String("issuance-log", "", "Issuance log of the parent CA (default: <parent-pem without extension>.issuance.log)")
+	createSubCACmd.Flags().String("allowed-profiles", "", "Comma-separated profiles the subCA may issue (subca, leaf); written to <pem-out without extension>.ca.yaml")
+	addPolicyFlags(createSubCACmd)
+	addDistributionFlags(createSubCACmd)
 
 	// sign
 	addSubjectFlags(signCmd)
 	signCmd.Flags().String("ca-pem", "", "File path to the signing CA certificate (PEM)")
 	signCmd.Flags().String("shares-in", "", "Comma-separated list of share files for the signing CA's private key")
-	signCmd.Flags().String("cert-out", "", "File path for the signed leaf certificate (PEM)")
-	signCmd.Flags().String("key-out", "", "File path to store the newly generated leaf private key (PEM)")
-
-	// KeyUsage flags (booleans)
-	signCmd.Flags().Bool("digital-signature", false, "Enable x509.KeyUsageDigitalSignature")
-	signCmd.Flags().Bool("key-encipherment", false, "Enable x509.KeyUsageKeyEncipherment")
-	signCmd.Flags().Bool("data-encipherment", false, "Enable x509.KeyUsageDataEncipherment")
-	signCmd.Flags().Bool("key-agreement", false, "Enable x509.KeyUsageKeyAgreement")
-	signCmd.Flags().Bool("crl-sign", false, "Enable x509.KeyUsageCRLSign")
-	signCmd.Flags().Bool("encipher-only", false, "Enable x509.KeyUsageEncipherOnly")
-	signCmd.Flags().Bool("decipher-only", false, "Enable x509.KeyUsageDecipherOnly")
+	signCmd.Flags().String("ca-key", "", "File path to the signing CA private key (PEM, SEC1 or PKCS#8, optionally encrypted) instead of shares")
+	signCmd.Flags().String("cert-out", "", "File path for the signed leaf certificate (PEM), or - for stdout")
+	signCmd.Flags().String("key-out", "", "File path to store the newly generated leaf private key (PEM), or - for stdout")
+	signCmd.Flags().StringArray("san", nil, "Subject alternative name: DNS name, IP address, e-mail address or URI (repeatable)")
+	addProfileFlag(signCmd)
+	signCmd.Flags().String("pubkey-in", "", "Certify an existing public key (PEM PUBLIC KEY or CSR, - for stdin) instead of generating a key pair")
+	signCmd.Flags().String("issuance-log", "", "Issuance log of the signing CA (default: <ca-pem without extension>.issuance.log)")
+	signCmd.Flags().String("key-format", utils.KeyFormatSEC1, "Encoding for --key-out: sec1 (EC PRIVATE KEY) or pkcs8 (PRIVATE KEY)")
+	signCmd.Flags().Bool("encrypt-key", false, "Prompt for a passphrase and write --key-out as encrypted PKCS#8 (scrypt + AES-256)")
+	signCmd.Flags().String("key-pass", "", "Passphrase to encrypt --key-out with (visible to other local users; prefer --encrypt-key)")
+	signCmd.Flags().BoolP("interactive", "i", false, "Prompt for the CA, profile, subject, SANs, shares and output files not given as flags")
+	addPolicyFlags(signCmd)
+	addDistributionFlags(signCmd)
+
+	addKeyUsageFlags(signCmd)
 
 	// Register commands
 	rootCmd.AddCommand(createRootCmd)
 	rootCmd.AddCommand(createSubCACmd)
 	rootCmd.AddCommand(signCmd)
 
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	for _, key := range keysToWipe {
+		secmem.WipeKey(key)
+	}
+	if n := secmem.LockFailures(); n > 0 {
+		slog.Warn("buffers holding key material could not be locked in RAM; raise the locked memory limit (ulimit -l)", "buffers", n)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"my-pki/internal/audit"
+	"my-pki/internal/batch"
+	"my-pki/internal/crl"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// compromiseCmd runs the CA key compromise procedure.
+var compromiseCmd = &cobra.Command{
+	Use:   "compromise",
+	Short: "Respond to a CA key compromise: mark the CA compromised, revoke everything it issued, publish a final CRL and prepare its replacement.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ref, _ := cmd.Flags().GetString("ca")
+		if ref == "" {
+			return invalid(errors.New("must specify --ca (name, fingerprint or certificate file of the compromised CA)"))
+		}
+		db, err := openInventory(cmd)
+		if err != nil {
+			return err
+		}
+		ca, caCert, err := resolveCA(db, ref)
+		if err != nil {
+			return err
+		}
+
+		outDir, _ := cmd.Flags().GetString("out")
+		if outDir == "" {
+			outDir = fmt.Sprintf("%s-compromise-%s", safeFileName(ca.Name), time.Now().Format("20060102"))
+		}
+		if _, err := os.Stat(outDir); err == nil {
+			return fmt.Errorf("output directory '%s' already exists", outDir)
+		}
+
+		// The final CRL is signed with the compromised key itself; without it, only the inventory is updated
+		noCRL, _ := cmd.Flags().GetBool("no-crl")
+		var caKey crypto.Signer
+		if !noCRL {
+			sharesInStr, _ := cmd.Flags().GetString("shares-in")
+			caKeyPath, _ := cmd.Flags().GetString("ca-key")
+			if caKey, err = loadCAKey(cmd, ca.PemPath, sharesInStr, caKeyPath, "--shares-in", "--ca-key"); err != nil {
+				return fmt.Errorf("failed to load CA private key (use --no-crl if it is unavailable): %w", err)
+			}
+		}
+
+		var note string
+		var crlPEM []byte
+		var revoked, onCRL, subCAs []*inventory.CertRecord
+		err = updateInventory(cmd, func(db *inventory.DB) error {
+			var err error
+			if ca, caCert, err = resolveCA(db, ref); err != nil {
+				return err
+			}
+			// Certificates issued before the inventory existed are only in the issuance log
+			if ca.PemPath != "" {
+				if _, err := importIssuanceLog(db, issuanceLogPath(cmd, ca.PemPath), caCert); err != nil {
+					return err
+				}
+			}
+
+			now, err := utils.Now()
+			if err != nil {
+				return err
+			}
+			note, _ = cmd.Flags().GetString("note")
+			if ca.Status == inventory.CACompromised {
+				fmt.Fprintf(cmd.OutOrStdout(), "CA %s was already marked compromised at %s; revoking anything issued since.\n", ca.Name, ca.CompromisedAt.Format(time.RFC3339))
+			} else {
+				at := now.UTC()
+				ca.Status = inventory.CACompromised
+				ca.CompromisedAt = &at
+				ca.Note = note
+			}
+
+			// Revoke every unexpired certificate the CA issued; its own certificate is revoked for key compromise
+			for _, rec := range db.IssuedBy(ca.SHA256) {
+				if !rec.NotAfter.After(now) {
+					continue
+				}
+				if rec.Status != inventory.StatusRevoked || rec.OnHold() {
+					rec.Revoke(now, inventory.ReasonCACompromise)
+					revoked = append(revoked, rec)
+				}
+				onCRL = append(onCRL, rec)
+				if rec.IsCA {
+					subCAs = append(subCAs, rec)
+				}
+			}
+			self := db.Certificate(ca.SHA256)
+			if self == nil {
+				self = db.AddCertificate(caCert, caCert)
+			}
+			if !inventory.IsSelfSigned(caCert) {
+				self.Revoke(now, inventory.ReasonKeyCompromise)
+			}
+
+			if caKey != nil {
+				crlPEM, err = crl.Create(caCert, caKey, onCRL, ca.NextCRLNumber(), now, caCert.NotAfter)
+				if err != nil {
+					return err
+				}
+				ca.RecordCRL(now, false)
+			}
+
+			return writeCompromiseBundle(outDir, ca, caCert, onCRL, subCAs, crlPEM, now)
+		})
+		if err != nil {
+			return err
+		}
+		entry := audit.Entry{Event: audit.EventCompromise, CA: caCert.Subject.String(), Params: map[string]string{
+			"revoked": fmt.Sprintf("%d", len(revoked)), "out": outDir,
+		}, Fingerprints: []string{ca.SHA256}}
+		if note != "" {
+			entry.Params["note"] = note
+		}
+		if crlPEM != nil {
+			entry.Params["crl_number"] = fmt.Sprintf("%d", ca.CRLNumber)
+		}
+		for _, rec := range revoked {
+			entry.Fingerprints = append(entry.Fingerprints, rec.SHA256)
+		}
+		if err := recordAudit(cmd, entry); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "CA %s (%s) marked compromised.\n", ca.Name, ca.SHA256[:16])
+		fmt.Fprintf(cmd.OutOrStdout(), " - Newly revoked: %d certificate(s) (%d unexpired in total, %d sub CA(s))\n", len(revoked), len(onCRL), len(subCAs))
+		if crlPEM != nil {
+			fmt.Fprintf(cmd.OutOrStdout(), " - Final CRL #%d written to %s\n", ca.CRLNumber, filepath.Join(outDir, "final.crl"))
+		} else {
+			fmt.Fprintln(cmd.OutOrStdout(), " - No final CRL generated (--no-crl)")
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), " - Replacement checklist and re-issue manifest in %s\n", outDir)
+		return nil
+	},
+}
+
+// resolveCA finds the CA named by ref in db, registering it first if ref is a certificate file.
+func resolveCA(db *inventory.DB, ref string) (*inventory.CARecord, *x509.Certificate, error) {
+	if _, err := os.Stat(ref); err == nil {
+		caCert, err := utils.ParseCertificateFromFile(ref)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse CA certificate from '%s': %w", ref, err)
+		}
+		if !caCert.IsCA {
+			return nil, nil, fmt.Errorf("'%s' is not a CA certificate", ref)
+		}
+		return db.AddCA(caCert, ref), caCert, nil
+	}
+	ca, err := db.FindCA(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rec := db.Certificate(ca.SHA256); rec != nil {
+		caCert, err := rec.Certificate()
+		return ca, caCert, err
+	}
+	if ca.PemPath == "" {
+		return nil, nil, fmt.Errorf("certificate of CA '%s' is not in the inventory; pass its PEM file as --ca", ref)
+	}
+	caCert, err := utils.ParseCertificateFromFile(ca.PemPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate from '%s': %w", ca.PemPath, err)
+	}
+	return ca, caCert, nil
+}
+
+// writeCompromiseBundle writes the final CRL, the list of revoked certificates, a batch manifest
+// re-issuing the revoked leaves under a replacement CA, and the incident checklist to dir.
+func writeCompromiseBundle(dir string, ca *inventory.CARecord, caCert *x509.Certificate, revoked, subCAs []*inventory.CertRecord, crlPEM []byte, now time.Time) error {
+	if err := os.MkdirAll(filepath.Join(dir, "reissue"), 0755); err != nil {
+		return fmt.Errorf("failed to create '%s': %w", dir, err)
+	}
+	write := func(name string, data []byte) error {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return fmt.Errorf("failed to write '%s': %w", filepath.Join(dir, name), err)
+		}
+		return nil
+	}
+
+	if err := write("compromised-ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})); err != nil {
+		return err
+	}
+	if crlPEM != nil {
+		if err := write("final.crl", crlPEM); err != nil {
+			return err
+		}
+	}
+
+	var list strings.Builder
+	w := csv.NewWriter(&list)
+	w.Write([]string{"serial", "subject", "not_after", "is_ca", "sha256"})
+	manifest := batch.Manifest{CAPem: "REPLACEMENT-CA.pem"}
+	for _, rec := range revoked {
+		w.Write([]string{rec.Serial, rec.Subject, rec.NotAfter.Format(time.RFC3339), fmt.Sprint(rec.IsCA), rec.SHA256})
+		if rec.IsCA {
+			continue
+		}
+		// The subscriber's key was not exposed by the CA compromise, so the replacement certifies the same key
+		cert, err := rec.Certificate()
+		if err != nil {
+			return err
+		}
+		pubDER, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+		if err != nil {
+			return fmt.Errorf("failed to marshal public key of %s: %w", rec.Serial, err)
+		}
+		pubName := filepath.Join("reissue", rec.Serial+".pub.pem")
+		if err := write(pubName, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})); err != nil {
+			return err
+		}
+		manifest.Certificates = append(manifest.Certificates, batch.ManifestCert{
+			Subject:  batch.SubjectFromName(cert.Subject),
+			Days:     utils.ValidityDays(cert),
+			KeyUsage: batch.KeyUsageNames(cert.KeyUsage),
+			PubkeyIn: pubName,
+			CertOut:  filepath.Join("reissue", rec.Serial+".pem"),
+		})
+	}
+	w.Flush()
+	if err := write("revoked.csv", []byte(list.String())); err != nil {
+		return err
+	}
+	manifestYAML, err := yaml.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode re-issue manifest: %w", err)
+	}
+	if err := write("reissue-manifest.yaml", manifestYAML); err != nil {
+		return err
+	}
+	return write("CHECKLIST.md", []byte(compromiseChecklist(ca, caCert, len(revoked), subCAs, crlPEM != nil, now)))
+}
+
+// compromiseChecklist renders the remaining manual steps of the incident response.
+func compromiseChecklist(ca *inventory.CARecord, caCert *x509.Certificate, revoked int, subCAs []*inventory.CertRecord, haveCRL bool, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# CA compromise: %s\n\n", caCert.Subject)
+	fmt.Fprintf(&b, "- Fingerprint (SHA-256): %s\n", ca.SHA256)
+	fmt.Fprintf(&b, "- Marked compromised: %s\n", ca.CompromisedAt.Format(time.RFC3339))
+	if ca.Note != "" {
+		fmt.Fprintf(&b, "- Note: %s\n", ca.Note)
+	}
+	fmt.Fprintf(&b, "- Unexpired certificates revoked: %d (see revoked.csv)\n\n", revoked)
+
+	b.WriteString("## Contain\n\n")
+	b.WriteString("- [ ] Take the CA's shares / key files out of service; record who held them and when they were last used.\n")
+	b.WriteString("- [ ] Preserve evidence: issuance log, inventory, shell history and ceremony records.\n")
+	b.WriteString("- [ ] Check the issuance log (`log verify`) for certificates you do not recognise.\n\n")
+
+	b.WriteString("## Revoke\n\n")
+	if haveCRL {
+		b.WriteString("- [ ] Publish final.crl at every CRL distribution point of the compromised CA.\n")
+	} else {
+		b.WriteString("- [ ] No final CRL was generated; notify relying parties to distrust the CA directly.\n")
+	}
+	if inventory.IsSelfSigned(caCert) {
+		b.WriteString("- [ ] Remove the root certificate (compromised-ca.pem) from every trust store.\n")
+	} else {
+		fmt.Fprintf(&b, "- [ ] Have the issuing CA (%s) publish a CRL revoking this CA for keyCompromise (already recorded in the inventory).\n", caCert.Issuer)
+	}
+	for _, sub := range subCAs {
+		fmt.Fprintf(&b, "- [ ] Sub CA %s was revoked too: rebuild its hierarchy and run this procedure for it.\n", sub.Subject)
+	}
+	b.WriteString("\n## Replace\n\n")
+	if inventory.IsSelfSigned(caCert) {
+		b.WriteString("- [ ] Create a replacement root with a new key ceremony (`create-root`) and distribute it to trust stores.\n")
+	} else {
+		b.WriteString("- [ ] Create a replacement CA under a healthy parent (`create-subca`), with new custodians if possible.\n")
+	}
+	b.WriteString("- [ ] Copy the CA configuration (`.ca.yaml`) of the old CA to the replacement if its profiles still apply.\n")
+	b.WriteString("- [ ] Set `ca_pem` in reissue-manifest.yaml to the replacement CA, then from this directory run `plan --manifest reissue-manifest.yaml --out plan.json`, review it, and `apply` it.\n")
+	b.WriteString("- [ ] Deliver the re-issued certificates to their subscribers and confirm the old ones are no longer in use.\n")
+	fmt.Fprintf(&b, "\nGenerated %s.\n", now.UTC().Format(time.RFC3339))
+	return b.String()
+}
+
+// safeFileName replaces characters that are awkward in file names.
+func safeFileName(s string) string {
+	if s == "" {
+		return "ca"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ' ' || r == ':' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func init() {
+	compromiseCmd.Flags().String("ca", "", "Compromised CA: its name (CN), SHA-256 fingerprint, or certificate file (PEM)")
+	compromiseCmd.Flags().String("shares-in", "", "Comma-separated list of share files for the compromised CA's private key (to sign the final CRL)")
+	compromiseCmd.Flags().String("ca-key", "", "File path to the compromised CA private key instead of shares")
+	compromiseCmd.Flags().Bool("no-crl", false, "Skip the final CRL when the CA key is unavailable")
+	compromiseCmd.Flags().String("note", "", "Free-text description of the incident, recorded in the inventory and checklist")
+	compromiseCmd.Flags().String("out", "", "Directory for the final CRL, revoked list, re-issue manifest and checklist (default: <name>-compromise-<date>)")
+	compromiseCmd.Flags().String("issuance-log", "", "Issuance log of the CA (default: <ca pem without extension>.issuance.log)")
+	rootCmd.AddCommand(compromiseCmd)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"my-pki/internal/inventory"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// convert-inventory
+var convertInventoryCmd = &cobra.Command{
+	Use:   "convert-inventory",
+	Short: "Copy the inventory into a new file in the format its name selects: SQLite, or JSON for a .json file.",
+	Long: `Copy the inventory into a new file in the format its name selects: SQLite, or JSON for a .json file.
+
+Inventories made by earlier versions are JSON files (gosec-inventory.json); they keep being used while
+no SQLite inventory exists next to them. To move one to SQLite:
+
+  pki convert-inventory --db gosec-inventory.json --to gosec-inventory.db
+
+then keep the JSON file as a backup, or remove it.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		to, _ := cmd.Flags().GetString("to")
+		if to == "" {
+			return invalid(errors.New("must specify --to for the new inventory"))
+		}
+		if _, err := os.Stat(to); err == nil {
+			return fmt.Errorf("'%s' already exists; refusing to overwrite it", to)
+		}
+		from := inventoryPath(cmd)
+		db, err := openInventory(cmd)
+		if err != nil {
+			return err
+		}
+		if err := db.SaveAs(to); err != nil {
+			return fmt.Errorf("failed to convert inventory '%s': %w", from, err)
+		}
+		format := "JSON"
+		if inventory.IsSQLite(to) {
+			format = "SQLite"
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Inventory converted!\n - From: %s\n - To: %s (%s)\n - %d CAs, %d certificates.\n", from, to, format, len(db.CAs), len(db.Certificates))
+		return nil
+	},
+}
+
+func init() {
+	convertInventoryCmd.Flags().String("to", "", "File path of the new inventory: a SQLite database unless it ends in .json")
+	rootCmd.AddCommand(convertInventoryCmd)
+}
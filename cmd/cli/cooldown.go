@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/inventory"
+	"my-pki/internal/notify"
+	"my-pki/internal/utils"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// checkCombinePolicy enforces the combine policy of the CA at caPem before its shares are combined.
+// Under a delay, the first attempt files a request and fails; combining is allowed once the delay
+// is over. Every step is announced, and the shares are not combined unless the announcement is delivered.
+func checkCombinePolicy(cmd *cobra.Command, caPem string, shares []*utils.Share) error {
+	if caPem == "" {
+		return nil
+	}
+	cfg, err := caconfig.LoadForCA(caPem)
+	if err != nil {
+		return err
+	}
+	policy := cfg.Combine
+	if policy == nil {
+		return nil
+	}
+	caCert, err := utils.ParseCertificateFromFile(caPem)
+	if err != nil {
+		return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+	}
+	ev := newCombineEvent(cmd, caPem, caCert.Subject.String(), inventory.Fingerprint(caCert))
+	if ev.KeyID, err = utils.PublicKeyID(caCert.PublicKey); err != nil {
+		return err
+	}
+	for _, s := range shares {
+		ev.Custodians = append(ev.Custodians, fmt.Sprintf("#%d %s", s.Index, s.Custodian()))
+	}
+
+	if policy.Delay > 0 {
+		var r *inventory.CombineRequest
+		filed := false
+		err := updateInventory(cmd, func(db *inventory.DB) error {
+			ca := db.AddCA(caCert, caPem)
+			r = ca.CombineRequest
+			switch {
+			case r != nil && r.Pending(ev.Time):
+				return fmt.Errorf("combining the key of %s is delayed by policy: request %s may be used from %s (in %s)",
+					caCert.Subject, r.ID, r.NotBefore.Local().Format(time.RFC3339), r.NotBefore.Sub(ev.Time).Round(time.Second))
+			case r == nil || !r.Usable(ev.Time):
+				r = ca.RequestCombine(ev.Time, policy.Delay, policy.UseWindow(), ev.Operator, ev.Host, ev.Command)
+				filed = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if filed {
+			ev.Event, ev.RequestID, ev.NotBefore = notify.EventCombineRequested, r.ID, &r.NotBefore
+			if err := notify.Send(policy.Targets, ev); err != nil {
+				slog.Warn("combination request filed, but not every notification was delivered", "request", r.ID, "err", err)
+			}
+			return fmt.Errorf("combining the key of %s requires a %s delay: request %s filed; run the command again from %s, before %s",
+				caCert.Subject, policy.Delay, r.ID, r.NotBefore.Local().Format(time.RFC3339), r.Expires.Local().Format(time.RFC3339))
+		}
+		ev.RequestID = r.ID
+	}
+
+	ev.Event = notify.EventSharesCombined
+	if err := notify.Send(policy.Targets, ev); err != nil {
+		return fmt.Errorf("refusing to combine the key of %s without notifying: %w", caCert.Subject, err)
+	}
+	return nil
+}
+
+// newCombineEvent describes the combination of the key of the CA at caPem by the running command.
+func newCombineEvent(cmd *cobra.Command, caPem, caSubject, caSHA256 string) *notify.Event {
+	host, _ := os.Hostname()
+	return &notify.Event{
+		Time:      time.Now().UTC(),
+		CAPem:     caPem,
+		CASubject: caSubject,
+		CASHA256:  caSHA256,
+		Command:   cmd.CommandPath(),
+		Operator:  operatorName(""),
+		Host:      host,
+	}
+}
+
+var cooldownCmd = &cobra.Command{
+	Use:   "cooldown",
+	Short: "Show or cancel the pending requests to combine shares of CAs with a combine delay policy.",
+}
+
+var cooldownStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List the requests to combine CA shares and whether they may be used.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openInventory(cmd)
+		if err != nil {
+			return err
+		}
+		cas := db.CAs
+		if ref, _ := cmd.Flags().GetString("ca"); ref != "" {
+			ca, _, err := resolveCA(db, ref)
+			if err != nil {
+				return err
+			}
+			cas = []*inventory.CARecord{ca}
+		}
+		now := time.Now()
+		listed := 0
+		for _, ca := range cas {
+			r := ca.CombineRequest
+			if r == nil {
+				continue
+			}
+			listed++
+			var state string
+			switch {
+			case r.CancelledAt != nil:
+				state = fmt.Sprintf("cancelled by %s at %s", r.CancelledBy, r.CancelledAt.Local().Format(time.RFC3339))
+				if r.Note != "" {
+					state += ": " + r.Note
+				}
+			case r.Pending(now):
+				state = fmt.Sprintf("pending until %s (in %s)", r.NotBefore.Local().Format(time.RFC3339), r.NotBefore.Sub(now).Round(time.Second))
+			case r.Usable(now):
+				state = fmt.Sprintf("usable until %s", r.Expires.Local().Format(time.RFC3339))
+			default:
+				state = fmt.Sprintf("expired at %s", r.Expires.Local().Format(time.RFC3339))
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s (%s): request %s by %s on %s (%s) at %s, %s\n", ca.Name, ca.SHA256[:16], r.ID, r.Operator, r.Host, r.Command,
+				r.RequestedAt.Local().Format(time.RFC3339), state)
+		}
+		if listed == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "No combination requests")
+		}
+		return nil
+	},
+}
+
+var cooldownCancelCmd = &cobra.Command{
+	Use:   "cancel",
+	Short: "Cancel the pending request to combine the shares of a CA; a new request starts the delay over.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ref, _ := cmd.Flags().GetString("ca")
+		if ref == "" {
+			return invalid(errors.New("must specify --ca"))
+		}
+		note, _ := cmd.Flags().GetString("note")
+		by, _ := cmd.Flags().GetString("by")
+		var ca *inventory.CARecord
+		var r *inventory.CombineRequest
+		var ev *notify.Event
+		err := updateInventory(cmd, func(db *inventory.DB) error {
+			var caCert *x509.Certificate
+			var err error
+			if ca, caCert, err = resolveCA(db, ref); err != nil {
+				return err
+			}
+			r = ca.CombineRequest
+			now := time.Now()
+			if r == nil || r.CancelledAt != nil || !(r.Pending(now) || r.Usable(now)) {
+				return fmt.Errorf("CA '%s' has no open combination request", ca.Name)
+			}
+			ev = newCombineEvent(cmd, ca.PemPath, caCert.Subject.String(), ca.SHA256)
+			ev.Event, ev.RequestID, ev.Note = notify.EventCombineCancelled, r.ID, note
+			ev.Operator = operatorName(by)
+			r.Cancel(now, ev.Operator, note)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Combination request %s for %s cancelled\n", r.ID, ca.Name)
+		if ca.PemPath == "" {
+			return nil
+		}
+		cfg, err := caconfig.LoadForCA(ca.PemPath)
+		if err != nil {
+			return err
+		}
+		if cfg.Combine != nil {
+			if err := notify.Send(cfg.Combine.Targets, ev); err != nil {
+				slog.Warn("not every notification was delivered", "err", err)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	cooldownStatusCmd.Flags().String("ca", "", "Only this CA (name, fingerprint or certificate file)")
+	cooldownCancelCmd.Flags().String("ca", "", "CA (name, fingerprint or certificate file)")
+	cooldownCancelCmd.Flags().String("note", "", "Reason for the cancellation, sent with the notification")
+	cooldownCancelCmd.Flags().String("by", "", "Who cancels the request (default: current user)")
+	cooldownCmd.AddCommand(cooldownStatusCmd, cooldownCancelCmd)
+	rootCmd.AddCommand(cooldownCmd)
+}
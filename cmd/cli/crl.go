@@ -0,0 +1,337 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/audit"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/crl"
+	"my-pki/internal/dist"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// genCRLCmd publishes the revocations recorded in the inventory as a signed CRL.
+var genCRLCmd = &cobra.Command{
+	Use:   "gen-crl",
+	Short: "Sign a CRL listing the unexpired certificates the CA has revoked, or with --delta only those revoked since the last full CRL.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the CA certificate"))
+		}
+		delta, _ := cmd.Flags().GetBool("delta")
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			// Where serve-dist publishes it
+			out = dist.CRLPath(caPem)
+			if delta {
+				out = dist.DeltaCRLPath(caPem)
+			}
+		}
+		format, _ := cmd.Flags().GetString("format")
+		if format == "" {
+			format = "pem"
+			if strings.EqualFold(filepath.Ext(out), ".der") {
+				format = "der"
+			}
+		}
+		if format != "pem" && format != "der" {
+			return invalid(fmt.Errorf("invalid --format '%s' (expected pem or der)", format))
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		nextUpdate, err := crlNextUpdate(cmd, now)
+		if err != nil {
+			return err
+		}
+		if nextUpdate.After(caCert.NotAfter) {
+			slog.Warn("next update is after the CA expires", "next_update", nextUpdate.Format(time.RFC3339), "ca_not_after", caCert.NotAfter.Format(time.RFC3339))
+		}
+
+		if delta && cmd.Flags().Changed("freshest-url") {
+			return errors.New("--freshest-url applies to full CRLs and cannot be used with --delta")
+		}
+		// Checked before the shares are asked for, and again under the inventory lock
+		if err := checkBaseCRL(cmd, caCert, caPem, delta); err != nil {
+			return err
+		}
+		var extra []pkix.Extension
+		if !delta {
+			freshest, _ := cmd.Flags().GetStringArray("freshest-url")
+			if !cmd.Flags().Changed("freshest-url") {
+				cfg, err := caconfig.LoadForCA(caPem)
+				if err != nil {
+					return err
+				}
+				if cfg.Distribution != nil {
+					freshest = cfg.Distribution.Resolve(caPem).DeltaCRLURLs
+				}
+			}
+			if len(freshest) > 0 {
+				ext, err := crl.FreshestCRL(freshest)
+				if err != nil {
+					return err
+				}
+				extra = append(extra, ext)
+			}
+		}
+
+		sharesInStr, _ := cmd.Flags().GetString("shares-in")
+		caKeyPath, _ := cmd.Flags().GetString("ca-key")
+		caKey, err := loadCAKey(cmd, caPem, sharesInStr, caKeyPath, "--shares-in", "--ca-key")
+		if err != nil {
+			return fmt.Errorf("failed to load CA private key: %w", err)
+		}
+		// The entries, the number and the record of the CRL are taken under the inventory lock, so
+		// revocations made meanwhile are neither lost nor left out of a CRL that claims the number
+		var ca *inventory.CARecord
+		var revoked, released []*inventory.CertRecord
+		err = updateInventory(cmd, func(db *inventory.DB) error {
+			ca = db.AddCA(caCert, caPem)
+			if delta && ca.BaseCRLAt == nil {
+				return fmt.Errorf("no full CRL has been issued for '%s' yet; run gen-crl without --delta first", caPem)
+			}
+			revoked, released = crl.Entries(db, ca, now, delta)
+			var crlPEM []byte
+			var err error
+			if delta {
+				crlPEM, err = crl.CreateDelta(caCert, caKey, revoked, released, ca.NextCRLNumber(), ca.BaseCRLNumber, now, nextUpdate)
+			} else {
+				crlPEM, err = crl.Create(caCert, caKey, revoked, ca.NextCRLNumber(), now, nextUpdate, extra...)
+			}
+			if err != nil {
+				return withExitCode(exitSigning, err)
+			}
+			data := crlPEM
+			if format == "der" {
+				block, _ := pem.Decode(crlPEM)
+				data = block.Bytes
+			} else if utils.PEMInfo {
+				data = utils.AnnotatePEM(crlPEM)
+			}
+			if err := os.WriteFile(out, data, 0644); err != nil {
+				return fmt.Errorf("failed to write CRL to '%s': %w", out, err)
+			}
+			// The number is only consumed once the CRL exists, so a failed run does not leave a gap
+			ca.RecordCRL(now, delta)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		slog.Info("CRL issued", "ca", caCert.Subject.String(), "number", ca.CRLNumber, "delta", delta, "revoked", len(revoked), "out", out)
+		if err := recordAudit(cmd, audit.Entry{Event: audit.EventCRL, CA: caCert.Subject.String(), Params: map[string]string{
+			"number": fmt.Sprintf("%d", ca.CRLNumber), "delta": fmt.Sprintf("%t", delta), "revoked": fmt.Sprintf("%d", len(revoked)), "out": out,
+		}, Fingerprints: []string{inventory.Fingerprint(caCert)}}); err != nil {
+			return err
+		}
+
+		if delta {
+			fmt.Fprintf(cmd.OutOrStdout(), "Delta CRL #%d (base CRL #%d) for %s written to %s (%s)\n", ca.CRLNumber, ca.BaseCRLNumber, caCert.Subject, out, strings.ToUpper(format))
+		} else {
+			fmt.Fprintf(cmd.OutOrStdout(), "CRL #%d for %s written to %s (%s)\n", ca.CRLNumber, caCert.Subject, out, strings.ToUpper(format))
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), " - Revoked certificates: %d\n", len(revoked))
+		if len(released) > 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), " - Released from hold: %d\n", len(released))
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), " - Next update: %s\n", nextUpdate.Format(time.RFC3339))
+		return nil
+	},
+}
+
+// checkBaseCRL refuses a delta CRL for a CA that has not issued a full CRL yet.
+func checkBaseCRL(cmd *cobra.Command, caCert *x509.Certificate, caPem string, delta bool) error {
+	if !delta {
+		return nil
+	}
+	db, err := openInventory(cmd)
+	if err != nil {
+		return err
+	}
+	if db.AddCA(caCert, caPem).BaseCRLAt == nil {
+		return fmt.Errorf("no full CRL has been issued for '%s' yet; run gen-crl without --delta first", caPem)
+	}
+	return nil
+}
+
+// inspectCRLCmd shows what a CRL revokes and checks that the expected CA signed it.
+var inspectCRLCmd = &cobra.Command{
+	Use:   "inspect-crl",
+	Short: "Print a CRL's issuer, validity, number and revoked serials with reasons, and verify its signature against the CA.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		crlIn, _ := cmd.Flags().GetString("crl-in")
+		if crlIn == "" {
+			return invalid(errors.New("must specify --crl-in for the CRL (PEM or DER)"))
+		}
+		data, err := utils.ReadInput(crlIn)
+		if err != nil {
+			return fmt.Errorf("unable to read CRL '%s': %w", crlIn, err)
+		}
+		rl, err := x509.ParseRevocationList(derOrPEM(data))
+		if err != nil {
+			return fmt.Errorf("invalid CRL '%s': %w", crlIn, err)
+		}
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "CRL %s\n", crlIn)
+		fmt.Fprintf(cmd.OutOrStdout(), " - Issuer: %s\n", rl.Issuer)
+		base, err := crl.DeltaBase(rl)
+		if err != nil {
+			return err
+		}
+		if base != nil {
+			fmt.Fprintf(cmd.OutOrStdout(), " - Type: delta CRL, base CRL #%s\n", base)
+		} else {
+			fmt.Fprintln(cmd.OutOrStdout(), " - Type: full CRL")
+		}
+		if rl.Number != nil {
+			fmt.Fprintf(cmd.OutOrStdout(), " - CRL Number: %s\n", rl.Number)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), " - This Update: %s\n", rl.ThisUpdate.UTC().Format(time.RFC3339))
+		if rl.NextUpdate.IsZero() {
+			fmt.Fprintln(cmd.OutOrStdout(), " - Next Update: none")
+		} else {
+			fmt.Fprintf(cmd.OutOrStdout(), " - Next Update: %s\n", rl.NextUpdate.UTC().Format(time.RFC3339))
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), " - Signature algorithm: %s\n", rl.SignatureAlgorithm)
+		freshest, err := crl.FreshestURLs(rl)
+		if err != nil {
+			return err
+		}
+		for _, u := range freshest {
+			fmt.Fprintf(cmd.OutOrStdout(), " - Delta CRLs: %s\n", u)
+		}
+		result := crlResult{
+			Path:               crlIn,
+			Issuer:             rl.Issuer.String(),
+			ThisUpdate:         rl.ThisUpdate.UTC(),
+			SignatureAlgorithm: rl.SignatureAlgorithm.String(),
+			DeltaCRLURLs:       freshest,
+			Revoked:            []revokedResult{},
+			Stale:              !rl.NextUpdate.IsZero() && now.After(rl.NextUpdate),
+		}
+		if base != nil {
+			result.DeltaBase = base.String()
+		}
+		if rl.Number != nil {
+			result.Number = rl.Number.String()
+		}
+		if !rl.NextUpdate.IsZero() {
+			next := rl.NextUpdate.UTC()
+			result.NextUpdate = &next
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), " - Revoked certificates: %d\n", len(rl.RevokedCertificateEntries))
+		for _, entry := range rl.RevokedCertificateEntries {
+			r := revokedResult{
+				Serial:    hex.EncodeToString(entry.SerialNumber.Bytes()),
+				RevokedAt: entry.RevocationTime.UTC(),
+				Reason:    inventory.ReasonName(entry.ReasonCode),
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "   %s  %s  %s\n", r.Serial, r.RevokedAt.Format(time.RFC3339), r.Reason)
+			result.Revoked = append(result.Revoked, r)
+		}
+		if result.Stale {
+			fmt.Fprintf(cmd.OutOrStdout(), "Warning: the CRL is stale; its next update was due %s\n", rl.NextUpdate.UTC().Format(time.RFC3339))
+		}
+
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			fmt.Fprintln(cmd.OutOrStdout(), " - Signature: not verified (give --ca-pem)")
+			return emitResult(result)
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+		if err := rl.CheckSignatureFrom(caCert); err != nil {
+			return fmt.Errorf("CRL '%s' is not signed by %s: %w", crlIn, caCert.Subject, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), " - Signature: valid (%s)\n", caCert.Subject)
+		result.VerifiedBy = caCert.Subject.String()
+		return emitResult(result)
+	},
+}
+
+// crlResult describes a CRL under --output json.
+type crlResult struct {
+	Path               string          `json:"path"`
+	Issuer             string          `json:"issuer"`
+	DeltaBase          string          `json:"delta_base,omitempty"` // base CRL number of a delta CRL
+	Number             string          `json:"number,omitempty"`
+	ThisUpdate         time.Time       `json:"this_update"`
+	NextUpdate         *time.Time      `json:"next_update,omitempty"`
+	SignatureAlgorithm string          `json:"signature_algorithm"`
+	DeltaCRLURLs       []string        `json:"delta_crl_urls,omitempty"`
+	Revoked            []revokedResult `json:"revoked"`
+	Stale              bool            `json:"stale"`
+	VerifiedBy         string          `json:"verified_by,omitempty"` // subject of --ca-pem, when the signature was checked
+}
+
+// revokedResult is one entry of a CRL.
+type revokedResult struct {
+	Serial    string    `json:"serial"` // hex
+	RevokedAt time.Time `json:"revoked_at"`
+	Reason    string    `json:"reason"`
+}
+
+// crlNextUpdate returns the nextUpdate time from --next-update or --days.
+func crlNextUpdate(cmd *cobra.Command, now time.Time) (time.Time, error) {
+	if v, _ := cmd.Flags().GetString("next-update"); v != "" {
+		if cmd.Flags().Changed("days") {
+			return time.Time{}, errors.New("--days and --next-update are mutually exclusive")
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, invalid(fmt.Errorf("invalid --next-update '%s' (expected RFC3339, e.g. 2025-06-01T22:00:00Z): %w", v, err))
+		}
+		if !t.After(now) {
+			return time.Time{}, fmt.Errorf("--next-update %s is not in the future", v)
+		}
+		return t, nil
+	}
+	days, _ := cmd.Flags().GetInt("days")
+	if days <= 0 {
+		return time.Time{}, errors.New("--days must be positive")
+	}
+	return now.AddDate(0, 0, days), nil
+}
+
+func init() {
+	genCRLCmd.Flags().String("ca-pem", "", "File path to the CA certificate (PEM)")
+	genCRLCmd.Flags().String("shares-in", "", "Comma-separated list of share files for the CA's private key")
+	genCRLCmd.Flags().String("ca-key", "", "File path to the CA private key (PEM, SEC1 or PKCS#8, optionally encrypted) instead of shares")
+	genCRLCmd.Flags().String("out", "", "File path for the CRL (default: <ca-pem without extension>.crl, or -delta.crl with --delta, as served by serve-dist)")
+	genCRLCmd.Flags().String("format", "", "Encoding of --out: pem or der (default: der for a .der file, else pem)")
+	genCRLCmd.Flags().Int("days", 7, "Days until the CRL's next update")
+	genCRLCmd.Flags().String("next-update", "", "Next update time (RFC3339); replaces --days")
+	genCRLCmd.Flags().StringArray("freshest-url", nil, "URL where delta CRLs are published, recorded in a full CRL's FreshestCRL extension (repeatable) (default: the CA configuration's delta CRL URLs)")
+	genCRLCmd.Flags().Bool("delta", false, "Issue a delta CRL listing only revocations since the last full CRL, which it names as its base")
+	rootCmd.AddCommand(genCRLCmd)
+
+	inspectCRLCmd.Flags().String("crl-in", "", "File path to the CRL (PEM or DER), or - for stdin")
+	inspectCRLCmd.Flags().String("ca-pem", "", "File path to the CA certificate (PEM) the CRL must be signed by")
+	rootCmd.AddCommand(inspectCRLCmd)
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/hooks"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// genCSRCmd creates a key pair and CSR on the host that will use the certificate.
+var genCSRCmd = &cobra.Command{
+	Use:   "gen-csr",
+	Short: "Generate a private key and a PKCS#10 CSR, so only the CSR has to travel to the CA operator.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		subject, err := utils.BuildSubject(cmd)
+		if err != nil {
+			return err
+		}
+		sanFlags, _ := cmd.Flags().GetStringArray("san")
+		sans, err := utils.ParseSANs(sanFlags)
+		if err != nil {
+			return err
+		}
+
+		keyOut, _ := cmd.Flags().GetString("key-out")
+		if keyOut == "" {
+			return invalid(errors.New("must specify --key-out for the private key"))
+		}
+		csrOut, _ := cmd.Flags().GetString("csr-out")
+		if csrOut == "" {
+			return invalid(errors.New("must specify --csr-out for the CSR"))
+		}
+		keyFormat, _ := cmd.Flags().GetString("key-format")
+		if keyFormat != utils.KeyFormatSEC1 && keyFormat != utils.KeyFormatPKCS8 {
+			return invalid(fmt.Errorf("invalid --key-format '%s' (expected %s or %s)", keyFormat, utils.KeyFormatSEC1, utils.KeyFormatPKCS8))
+		}
+		keyPass, err := leafKeyPassphrase(cmd, keyOut)
+		if err != nil {
+			return err
+		}
+
+		key, err := utils.GenerateECKey()
+		if err != nil {
+			return err
+		}
+		template := &x509.CertificateRequest{
+			Subject:        subject,
+			DNSNames:       sans.DNSNames,
+			IPAddresses:    sans.IPAddresses,
+			EmailAddresses: sans.EmailAddresses,
+			URIs:           sans.URIs,
+		}
+		der, err := x509.CreateCertificateRequest(utils.Rand, template, key)
+		if err != nil {
+			return fmt.Errorf("failed to create CSR: %w", err)
+		}
+
+		if keyPass != nil {
+			err = utils.WriteEncryptedPrivateKeyToFile(key, keyOut, keyPass)
+		} else {
+			err = utils.WritePrivateKeyToFile(key, keyOut, keyFormat)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write private key to '%s': %w", keyOut, err)
+		}
+		csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+		if err := utils.WriteCSRToFile(csrPEM, csrOut); err != nil {
+			return fmt.Errorf("failed to write CSR to '%s': %w", csrOut, err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "CSR for %s written to %s\nPrivate key written to %s (keep it on this host)\n", subject, csrOut, keyOut)
+		return nil
+	},
+}
+
+// csrJob is one certificate request to be signed by sign-csr.
+type csrJob struct {
+	csrIn   string
+	csr     *x509.CertificateRequest
+	subject pkix.Name
+	sans    utils.SANs
+	certOut string
+	hookReq *hooks.Request
+	certPEM []byte // set once issued
+	vetoed  error  // set if a pre-issue hook refused the request
+}
+
+// signCSRCmd issues certificates for PKCS#10 requests generated elsewhere.
+var signCSRCmd = &cobra.Command{
+	Use:   "sign-csr",
+	Short: "Issue leaf certificates for PKCS#10 CSRs, taking the subject and SANs from each request unless overridden.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jobs, err := csrJobs(cmd)
+		if err != nil {
+			return err
+		}
+		_, issueErr := issueCSRJobs(cmd, jobs)
+		profile, _ := cmd.Flags().GetString("profile")
+		results := []*certResult{}
+		for _, job := range jobs {
+			if job.certPEM == nil {
+				continue
+			}
+			result, err := newCertResult(job.certOut, job.certPEM)
+			if err != nil {
+				return err
+			}
+			result.Profile = profile
+			results = append(results, result)
+		}
+		if len(results) > 0 || issueErr == nil {
+			if err := emitResult(results); err != nil {
+				return err
+			}
+		}
+		return issueErr
+	},
+}
+
+// issueCSRJobs signs jobs with the CA named by the command's flags, reconstructing the CA key once.
+// The key is returned, also alongside an error for requests that were not signed, so the caller can
+// use it within the same quorum session.
+func issueCSRJobs(cmd *cobra.Command, jobs []*csrJob) (crypto.Signer, error) {
+	days, _ := cmd.Flags().GetInt("days")
+	days, err := validityDays(cmd, days)
+	if err != nil {
+		return nil, err
+	}
+	caPem, _ := cmd.Flags().GetString("ca-pem")
+	if caPem == "" {
+		return nil, invalid(errors.New("must specify --ca-pem for the signing CA certificate"))
+	}
+	caCert, err := utils.ParseCertificateFromFile(caPem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+	}
+	profile, _ := cmd.Flags().GetString("profile")
+	days, settings, err := resolveProfile(cmd, caPem, profile, days)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := issuanceOptions(cmd, settings, days)
+	if err != nil {
+		return nil, err
+	}
+
+	ku, err := profileKeyUsage(cmd, profile, settings)
+	if err != nil {
+		return nil, err
+	}
+	if ku == 0 {
+		ku = x509.KeyUsageDigitalSignature
+	}
+	norm := settings.Normalization()
+	for _, job := range jobs {
+		job.subject = norm.Subject(job.subject)
+		job.sans = norm.SANs(job.sans)
+		if err := settings.CheckSANs(job.sans); err != nil {
+			return nil, fmt.Errorf("'%s' does not fit profile '%s': %w", job.csrIn, profile, err)
+		}
+		if err := utils.CheckKeyAlgorithm(job.csr.PublicKey, settings.KeyAlgorithm); err != nil {
+			return nil, fmt.Errorf("'%s' does not fit profile '%s': %w", job.csrIn, profile, err)
+		}
+	}
+	// A second person confirms the request before any hook runs or share is touched
+	var operator, reviewer string
+	if require, _ := cmd.Flags().GetBool("require-reviewer"); require {
+		approver, _ := cmd.Flags().GetString("approver")
+		operator = operatorName(approver)
+		if reviewer, err = requireReview(cmd.OutOrStdout(), caCert, days, ku, jobs, operator); err != nil {
+			return nil, err
+		}
+	}
+
+	// Run the pre-issue hooks of every request before the shares are assembled; a vetoed request is skipped
+	var approved []*csrJob
+	var failed []string
+	for _, job := range jobs {
+		job.hookReq, err = newHookRequest(cmd, caPem, profile, job.subject, &x509.Certificate{
+			DNSNames: job.sans.DNSNames, IPAddresses: job.sans.IPAddresses, EmailAddresses: job.sans.EmailAddresses, URIs: job.sans.URIs,
+		}, days)
+		if err != nil {
+			return nil, err
+		}
+		if err := preIssueHooks(settings, job.hookReq); err != nil {
+			if len(jobs) == 1 {
+				return nil, err
+			}
+			slog.Warn("skipping request vetoed by a pre-issue hook", "csr", job.csrIn, "err", err)
+			job.vetoed = err
+			failed = append(failed, job.csrIn)
+			continue
+		}
+		approved = append(approved, job)
+	}
+	if len(approved) == 0 {
+		return nil, errors.New("every request was vetoed by a pre-issue hook; nothing to sign")
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Issuing %d certificate(s):\n", len(approved))
+	for _, job := range approved {
+		fmt.Fprintf(cmd.OutOrStdout(), " - %s", job.subject)
+		if !job.sans.Empty() {
+			fmt.Fprintf(cmd.OutOrStdout(), " (SANs: %s)", strings.Join(job.sans.Strings(), ", "))
+		}
+		fmt.Fprintln(cmd.OutOrStdout())
+	}
+	sharesInStr, _ := cmd.Flags().GetString("shares-in")
+	caKeyPath, _ := cmd.Flags().GetString("ca-key")
+	caKey, err := loadCAKey(cmd, caPem, sharesInStr, caKeyPath, "--shares-in", "--ca-key")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA private key: %w", err)
+	}
+
+	for _, job := range approved {
+		jobOpts := append(slices.Clone(opts), utils.WithSANs(job.sans))
+		certPEM, err := utils.SignPublicKey(job.subject, job.csr.PublicKey, caCert, caKey, false, days, ku, jobOpts...)
+		if err != nil {
+			return nil, withExitCode(exitSigning, fmt.Errorf("failed to sign certificate request '%s': %w", job.csrIn, err))
+		}
+		if err := logIssuance(cmd, caPem, certPEM, caKey); err != nil {
+			return nil, err
+		}
+		if reviewer != "" {
+			if err := recordReview(cmd, certPEM, operator, reviewer); err != nil {
+				return nil, fmt.Errorf("failed to record the review in the inventory: %w", err)
+			}
+		}
+		if job.certOut == "" {
+			serial, err := issuedSerial(certPEM)
+			if err != nil {
+				return nil, err
+			}
+			job.certOut = caHome.NewCertPath(serial)
+		}
+		if err := utils.WriteCertificateToFile(certPEM, job.certOut); err != nil {
+			return nil, fmt.Errorf("failed to write signed certificate to '%s': %w", job.certOut, err)
+		}
+		if err := recordLocation(cmd, certPEM, job.certOut, profile); err != nil {
+			return nil, err
+		}
+		job.certPEM = certPEM
+		postIssueHooks(settings, job.hookReq, certPEM, job.certOut)
+		fmt.Fprintf(cmd.OutOrStdout(), "Signed certificate written to %s, valid for %d days\n", job.certOut, days)
+	}
+	if len(failed) > 0 {
+		return caKey, fmt.Errorf("%d of %d request(s) were not signed: %s", len(failed), len(jobs), strings.Join(failed, ", "))
+	}
+	return caKey, nil
+}
+
+// csrJobs reads and checks the requests named by --csr-in/--cert-out or --csr-dir/--out-dir.
+// Every request is validated before any is signed, so a bad file does not waste a quorum session.
+func csrJobs(cmd *cobra.Command) ([]*csrJob, error) {
+	csrIn, _ := cmd.Flags().GetString("csr-in")
+	csrDir, _ := cmd.Flags().GetString("csr-dir")
+	if (csrIn == "") == (csrDir == "") {
+		return nil, invalid(errors.New("must specify either --csr-in for one request or --csr-dir for a directory of requests"))
+	}
+
+	var jobs []*csrJob
+	if csrIn != "" {
+		certOut, _ := cmd.Flags().GetString("cert-out")
+		if certOut == "" && caHome == "" {
+			return nil, invalid(errors.New("must specify --cert-out for the signed certificate"))
+		}
+		jobs = append(jobs, &csrJob{csrIn: csrIn, certOut: certOut})
+	} else {
+		if subjectFlagsChanged(cmd) || cmd.Flags().Changed("san") {
+			return nil, errors.New("subject and --san overrides apply to a single request and cannot be used with --csr-dir")
+		}
+		outDir, _ := cmd.Flags().GetString("out-dir")
+		if outDir == "" && caHome == "" {
+			return nil, invalid(errors.New("must specify --out-dir for the signed certificates"))
+		}
+		entries, err := os.ReadDir(csrDir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CSR directory '%s': %w", csrDir, err)
+		}
+		for _, e := range entries {
+			ext := filepath.Ext(e.Name())
+			if e.IsDir() || (ext != ".csr" && ext != ".pem" && ext != ".req") {
+				continue
+			}
+			job := &csrJob{csrIn: filepath.Join(csrDir, e.Name())}
+			if outDir != "" {
+				job.certOut = filepath.Join(outDir, strings.TrimSuffix(e.Name(), ext)+".crt")
+			}
+			jobs = append(jobs, job)
+		}
+		if len(jobs) == 0 {
+			return nil, fmt.Errorf("no CSR files (*.csr, *.pem, *.req) found in '%s'", csrDir)
+		}
+		if outDir != "" {
+			if err := os.MkdirAll(outDir, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create output directory '%s': %w", outDir, err)
+			}
+		}
+	}
+
+	var problems []string
+	for _, job := range jobs {
+		if err := job.load(cmd); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", job.csrIn, err))
+		}
+		if csrDir != "" && job.certOut != "" {
+			if _, err := os.Stat(job.certOut); err == nil {
+				problems = append(problems, fmt.Sprintf("%s: '%s' already exists", job.csrIn, job.certOut))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("no request was signed:\n%s", strings.Join(problems, "\n"))
+	}
+	return jobs, nil
+}
+
+// load parses the request and applies the operator's subject and SAN overrides, which take precedence.
+func (job *csrJob) load(cmd *cobra.Command) error {
+	csr, err := utils.ParseCSRFile(job.csrIn)
+	if err != nil {
+		return err
+	}
+	job.csr = csr
+	job.subject = csr.Subject
+	if subjectFlagsChanged(cmd) {
+		if job.subject, err = utils.BuildSubject(cmd); err != nil {
+			return err
+		}
+	} else if job.subject.CommonName == "" {
+		return errors.New("the CSR has no common name; set the subject with --cn and the other subject flags")
+	}
+	job.sans = utils.SANsFromCSR(csr)
+	if cmd.Flags().Changed("san") {
+		sanFlags, _ := cmd.Flags().GetStringArray("san")
+		if job.sans, err = utils.ParseSANs(sanFlags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inspectCSRCmd shows what a CSR asks for, so it can be reviewed before sign-csr.
+var inspectCSRCmd = &cobra.Command{
+	Use:   "inspect-csr",
+	Short: "Print a CSR's subject, SANs, key type and requested extensions and verify its self-signature.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		csrIn, _ := cmd.Flags().GetString("csr-in")
+		if csrIn == "" {
+			return invalid(errors.New("must specify --csr-in for the certificate request"))
+		}
+		csr, err := utils.ReadCSRFile(csrIn)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "CSR %s\n", csrIn)
+		for _, line := range utils.DescribeCSR(csr) {
+			fmt.Fprintln(cmd.OutOrStdout(), line)
+		}
+		for _, w := range utils.CSRWarnings(csr) {
+			fmt.Fprintf(cmd.OutOrStdout(), "Warning: %s\n", w)
+		}
+		if err := csr.CheckSignature(); err != nil {
+			return fmt.Errorf("certificate request '%s' has an invalid signature: %w", csrIn, err)
+		}
+		result := csrResult{
+			Path:               csrIn,
+			Subject:            csr.Subject.String(),
+			SANs:               utils.SANsFromCSR(csr).Strings(),
+			PublicKey:          utils.DescribePublicKey(csr.PublicKey),
+			SignatureAlgorithm: csr.SignatureAlgorithm.String(),
+			Warnings:           utils.CSRWarnings(csr),
+		}
+		for _, ext := range csr.Extensions {
+			result.Extensions = append(result.Extensions, extensionResult{Name: utils.CSRExtensionName(ext.Id), Critical: ext.Critical})
+		}
+		return emitResult(result)
+	},
+}
+
+// csrResult describes a CSR under --output json; only CSRs with a valid signature are described.
+type csrResult struct {
+	Path               string            `json:"path"`
+	Subject            string            `json:"subject"`
+	SANs               []string          `json:"sans,omitempty"`
+	PublicKey          string            `json:"public_key"`
+	SignatureAlgorithm string            `json:"signature_algorithm"`
+	Extensions         []extensionResult `json:"extensions,omitempty"`
+	Warnings           []string          `json:"warnings,omitempty"`
+}
+
+// extensionResult names an extension requested in a CSR.
+type extensionResult struct {
+	Name     string `json:"name"`
+	Critical bool   `json:"critical"`
+}
+
+// addCSRSigningFlags registers the flags issueCSRJobs and csrJob.load read: overrides, CA, validity and usages.
+func addCSRSigningFlags(cmd *cobra.Command) {
+	addSubjectFlags(cmd)
+	cmd.Flags().StringArray("san", nil, "Replace the CSR's subject alternative names: DNS name, IP address, e-mail address or URI (repeatable)")
+	cmd.Flags().String("ca-pem", "", "File path to the signing CA certificate (PEM)")
+	cmd.Flags().String("shares-in", "", "Comma-separated list of share files for the signing CA's private key")
+	cmd.Flags().String("ca-key", "", "File path to the signing CA private key (PEM, SEC1 or PKCS#8, optionally encrypted) instead of shares")
+	cmd.Flags().String("issuance-log", "", "Issuance log of the signing CA (default: <ca-pem without extension>.issuance.log)")
+	addProfileFlag(cmd)
+	cmd.Flags().Bool("require-reviewer", false, "Show a summary and require a code from a second person running 'review' before signing")
+	addPolicyFlags(cmd)
+	addDistributionFlags(cmd)
+	addKeyUsageFlags(cmd)
+}
+
+// subjectFlagsChanged reports whether any flag of addSubjectNameFlags was given.
+func subjectFlagsChanged(cmd *cobra.Command) bool {
+	for _, name := range []string{"cn", "org", "ou", "locality", "province", "country", "street", "postal-code", "dc", "email", "serial-number"} {
+		if cmd.Flags().Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	addSubjectNameFlags(genCSRCmd)
+	genCSRCmd.Flags().StringArray("san", nil, "Subject alternative name: DNS name, IP address, e-mail address or URI (repeatable)")
+	genCSRCmd.Flags().String("key-out", "", "File path for the new private key (PEM), or - for stdout")
+	genCSRCmd.Flags().String("csr-out", "", "File path for the CSR (PEM), or - for stdout")
+	genCSRCmd.Flags().String("key-format", utils.KeyFormatSEC1, "Encoding for --key-out: sec1 (EC PRIVATE KEY) or pkcs8 (PRIVATE KEY)")
+	genCSRCmd.Flags().Bool("encrypt-key", false, "Prompt for a passphrase and write --key-out as encrypted PKCS#8 (scrypt + AES-256)")
+	genCSRCmd.Flags().String("key-pass", "", "Passphrase to encrypt --key-out with (visible to other local users; prefer --encrypt-key)")
+	rootCmd.AddCommand(genCSRCmd)
+
+	addCSRSigningFlags(signCSRCmd)
+	signCSRCmd.Flags().String("csr-in", "", "File path to the PKCS#10 certificate request (PEM), or - for stdin")
+	signCSRCmd.Flags().String("csr-dir", "", "Sign every request (*.csr, *.pem, *.req) in this directory with one CA key reconstruction")
+	signCSRCmd.Flags().String("cert-out", "", "File path for the signed certificate (PEM), or - for stdout")
+	signCSRCmd.Flags().String("out-dir", "", "With --csr-dir: directory for the certificates, written as <request name>.crt")
+	rootCmd.AddCommand(signCSRCmd)
+
+	inspectCSRCmd.Flags().String("csr-in", "", "File path to the PKCS#10 certificate request (PEM), or - for stdin")
+	rootCmd.AddCommand(inspectCSRCmd)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/age"
+	"my-pki/internal/agent"
+	"my-pki/internal/utils"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// coordinatorTLS holds the global flags used to reach custodian agents listed in --shares-in.
+var coordinatorTLS struct {
+	cert, key, agentCA string
+}
+
+// configureAgents reads the coordinator's TLS flags.
+func configureAgents(cmd *cobra.Command) {
+	coordinatorTLS.cert, _ = cmd.Flags().GetString("coordinator-cert")
+	coordinatorTLS.key, _ = cmd.Flags().GetString("coordinator-key")
+	coordinatorTLS.agentCA, _ = cmd.Flags().GetString("agent-ca")
+}
+
+// isAgentURL reports whether a --shares-in entry names a custodian agent rather than a share file.
+func isAgentURL(entry string) bool {
+	return strings.HasPrefix(entry, "https://")
+}
+
+// requestAgentShares asks every agent for its share at once and returns those whose custodians
+// approved. Declined and failed requests are reported, leaving the quorum check to the caller.
+func requestAgentShares(urls []string) ([]*utils.Share, error) {
+	if coordinatorTLS.cert == "" || coordinatorTLS.key == "" || coordinatorTLS.agentCA == "" {
+		return nil, errors.New("custodian agents need --coordinator-cert, --coordinator-key and --agent-ca")
+	}
+	client, err := agent.NewClient(coordinatorTLS.cert, coordinatorTLS.key, coordinatorTLS.agentCA)
+	if err != nil {
+		return nil, err
+	}
+	// Shares come back wrapped to a key that only lives for this command
+	identity, err := age.GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	req := &agent.ShareRequest{Command: strings.Join(os.Args, " "), Recipient: identity.Recipient().String()}
+
+	fmt.Fprintf(progress, "Requesting shares from %d custodian agent(s); waiting for their approval...\n", len(urls))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shares := make([]*utils.Share, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := fetchAgentShare(ctx, client, url, req, identity)
+			if err != nil {
+				slog.Warn("no share from custodian agent", "url", url, "err", err)
+				return
+			}
+			fmt.Fprintf(progress, "Received share #%d (%s) from %s\n", s.Index, s.Custodian(), url)
+			shares[i] = s
+		}()
+	}
+	wg.Wait()
+
+	var received []*utils.Share
+	for _, s := range shares {
+		if s != nil {
+			received = append(received, s)
+		}
+	}
+	return received, nil
+}
+
+// fetchAgentShare requests one agent's share and unwraps it.
+func fetchAgentShare(ctx context.Context, client *agent.Client, url string, req *agent.ShareRequest, identity *age.Identity) (*utils.Share, error) {
+	wrapped, err := client.RequestShare(ctx, url, req)
+	if err != nil {
+		return nil, err
+	}
+	s, err := utils.ParseShare(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("invalid share: %w", err)
+	}
+	s.Path = url
+	if s.Recipient() != req.Recipient {
+		return nil, errors.New("the share is not wrapped to this ceremony's key")
+	}
+	if err := s.Unlock([]byte(identity.String())); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+var custodianCmd = &cobra.Command{
+	Use:   "custodian",
+	Short: "Custodian tools for networked ceremonies.",
+}
+
+// custodianServeCmd keeps a share on the custodian's machine and contributes it on approval.
+var custodianServeCmd = &cobra.Command{
+	Use:         "serve",
+	Short:       "Serve one share to coordinators over mutually authenticated TLS, asking the custodian to approve each request.",
+	Annotations: map[string]string{annotationServer: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		shareIn, _ := cmd.Flags().GetString("share-in")
+		if shareIn == "" {
+			return invalid(errors.New("must specify --share-in with the custodian's share file"))
+		}
+		certFile, _ := cmd.Flags().GetString("tls-cert")
+		keyFile, _ := cmd.Flags().GetString("tls-key")
+		clientCA, _ := cmd.Flags().GetString("client-ca")
+		if certFile == "" || keyFile == "" || clientCA == "" {
+			return invalid(errors.New("must specify --tls-cert, --tls-key and --client-ca"))
+		}
+		share, err := utils.ReadShareFile(shareIn)
+		if err != nil {
+			return err
+		}
+		if !share.HasMetadata() {
+			return errors.New("shares without metadata cannot be served")
+		}
+		tlsConfig, err := agent.ServerTLSConfig(certFile, keyFile, clientCA)
+		if err != nil {
+			return err
+		}
+
+		// The share is read again for every request, so its secret is only in memory while sent
+		approve := func(req *agent.ShareRequest, coordinator *x509.Certificate) (*utils.Share, error) {
+			s, err := utils.ReadShareFile(shareIn)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(os.Stderr, "\n%s: share request from %s\n", time.Now().Format(time.RFC3339), coordinator.Subject)
+			fmt.Fprintf(os.Stderr, "  Command: %s\n", req.Command)
+			answer, err := utils.ReadLine(fmt.Sprintf("Contribute share #%d of key %s? [y/N]: ", s.Index, s.KeyID))
+			if err != nil {
+				return nil, err
+			}
+			if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+				slog.Info("share request declined", "coordinator", coordinator.Subject.String())
+				return nil, agent.ErrDeclined
+			}
+			if err := utils.UnlockShares([]*utils.Share{s}, utils.PromptSharePassphrase); err != nil {
+				slog.Error("failed to unlock share", "err", err)
+				return nil, err
+			}
+			slog.Info("share sent", "share", s, "coordinator", coordinator.Subject.String())
+			return s, nil
+		}
+
+		listen, _ := cmd.Flags().GetString("listen")
+		server := &http.Server{Addr: listen, Handler: agent.NewServer(approve), TLSConfig: tlsConfig, ReadHeaderTimeout: 10 * time.Second}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		errCh := make(chan error, 1)
+		go func() { errCh <- server.ListenAndServeTLS("", "") }()
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Serving share #%d of %d (threshold %d) of key %s for %s on %s\n", share.Index, share.Total, share.Threshold, share.KeyID, share.Custodian(), listen)
+		select {
+		case err := <-errCh:
+			return fmt.Errorf("failed to serve on %s: %w", listen, err)
+		case <-ctx.Done():
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		fmt.Fprintln(cmd.OutOrStdout(), "Stopped")
+		return server.Shutdown(shutdownCtx)
+	},
+}
+
+func init() {
+	custodianServeCmd.Flags().String("share-in", "", "The custodian's share file (encrypted shares are unlocked for each approved request)")
+	custodianServeCmd.Flags().String("listen", ":8443", "Address to listen on")
+	custodianServeCmd.Flags().String("tls-cert", "", "Certificate (PEM) of the agent, for the names coordinators use to reach it")
+	custodianServeCmd.Flags().String("tls-key", "", "Private key (PEM) of the agent certificate")
+	custodianServeCmd.Flags().String("client-ca", "", "CA certificate(s) (PEM) that issue coordinator client certificates")
+	custodianCmd.AddCommand(custodianServeCmd)
+	rootCmd.AddCommand(custodianCmd)
+}
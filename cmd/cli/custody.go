@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/audit"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// recordCustody registers the split written to sharePaths for the CA at caPem in the inventory,
+// with the custodians named in the shares as holders.
+func recordCustody(cmd *cobra.Command, caPem string, sharePaths []string) error {
+	caCert, err := utils.ParseCertificateFromFile(caPem)
+	if err != nil {
+		return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+	}
+	err = updateInventory(cmd, func(db *inventory.DB) error {
+		return assignFromShares(db.AddCA(caCert, caPem), sharePaths, "", time.Now())
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("key split into shares", "ca", caPem, "shares", len(sharePaths))
+	return recordAudit(cmd, audit.Entry{Event: audit.EventSplit, CA: caCert.Subject.String(),
+		Params: map[string]string{"shares": fmt.Sprintf("%d", len(sharePaths))}, Fingerprints: []string{inventory.Fingerprint(caCert)}})
+}
+
+// assignFromShares records the split of the shares in sharePaths as the CA's current one and
+// assigns each labelled share to its custodian, unless a transfer already recorded another holder.
+// Only the shares' metadata is read.
+func assignFromShares(ca *inventory.CARecord, sharePaths []string, note string, now time.Time) error {
+	shares, err := utils.ReadShareFiles(sharePaths)
+	if err != nil {
+		return err
+	}
+	first := shares[0]
+	if !first.HasMetadata() {
+		return fmt.Errorf("'%s' has no metadata to say which share it is; use --index and --custodian", first.Path)
+	}
+	c := ca.RecordSplit(first.KeyID, first.SplitID, first.Total, first.Threshold, now)
+	for _, s := range shares {
+		custodian := s.Custodian()
+		if custodian.Label == "" {
+			continue
+		}
+		if h, err := c.Holder(s.Index); err != nil {
+			return err
+		} else if h.Custodian != "" && h.Custodian != custodian.Label {
+			continue
+		}
+		if err := c.Assign(s.Index, custodian.Label, custodian.Contact, note, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// custodyOf returns the custody record of ca, which exists once a split has been recorded.
+func custodyOf(ca *inventory.CARecord) (*inventory.Custody, error) {
+	if ca.Custody == nil {
+		return nil, fmt.Errorf("no custody recorded for CA '%s'; register its shares with custody assign --shares-in", ca.Name)
+	}
+	return ca.Custody, nil
+}
+
+var custodyCmd = &cobra.Command{
+	Use:   "custody",
+	Short: "Record which custodian holds which share of each CA's key.",
+}
+
+var custodyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the holders of each CA's shares and who is needed to reach a quorum.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openInventory(cmd)
+		if err != nil {
+			return err
+		}
+		cas := db.CAs
+		if ref, _ := cmd.Flags().GetString("ca"); ref != "" {
+			ca, _, err := resolveCA(db, ref)
+			if err != nil {
+				return err
+			}
+			cas = []*inventory.CARecord{ca}
+		}
+		history, _ := cmd.Flags().GetBool("history")
+		listed := 0
+		for _, ca := range cas {
+			c := ca.Custody
+			if c == nil {
+				continue
+			}
+			if listed > 0 {
+				fmt.Fprintln(cmd.OutOrStdout())
+			}
+			listed++
+			fmt.Fprintf(cmd.OutOrStdout(), "%s (%s), key %s", ca.Name, ca.SHA256[:16], c.KeyID)
+			if c.SplitID != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), ", split %s", c.SplitID)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "\n  Quorum: %s\n", c.Quorum())
+			for _, h := range c.Holders {
+				switch {
+				case h.Custodian == "":
+					fmt.Fprintf(cmd.OutOrStdout(), "  #%d unassigned\n", h.Index)
+				case h.Contact != "":
+					fmt.Fprintf(cmd.OutOrStdout(), "  #%d %s <%s> since %s\n", h.Index, h.Custodian, h.Contact, h.Since.Format(time.DateOnly))
+				default:
+					fmt.Fprintf(cmd.OutOrStdout(), "  #%d %s since %s\n", h.Index, h.Custodian, h.Since.Format(time.DateOnly))
+				}
+			}
+			if !history {
+				continue
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "  History:")
+			for _, e := range c.History {
+				line := fmt.Sprintf("    %s %s", e.At.Format(time.RFC3339), e.Action)
+				if e.Index > 0 {
+					line += fmt.Sprintf(" #%d", e.Index)
+				}
+				if e.From != "" {
+					line += " from " + e.From
+				}
+				if e.To != "" {
+					line += " to " + e.To
+				}
+				if e.Note != "" {
+					line += ": " + e.Note
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), line)
+			}
+		}
+		if listed == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "No custody recorded")
+		}
+		return nil
+	},
+}
+
+var custodyAssignCmd = &cobra.Command{
+	Use:   "assign",
+	Short: "Record the holders of a CA's shares, from the custodians named in the share files or one share at a time.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ref, _ := cmd.Flags().GetString("ca")
+		if ref == "" {
+			return invalid(errors.New("must specify --ca"))
+		}
+		sharesIn, _ := cmd.Flags().GetString("shares-in")
+		index, _ := cmd.Flags().GetInt("index")
+		custodian, _ := cmd.Flags().GetString("custodian")
+		contact, _ := cmd.Flags().GetString("contact")
+		note, _ := cmd.Flags().GetString("note")
+		if (sharesIn == "") == (index == 0) {
+			return invalid(errors.New("must specify either --shares-in or --index with --custodian"))
+		}
+		var ca *inventory.CARecord
+		err := updateInventory(cmd, func(db *inventory.DB) error {
+			var caCert *x509.Certificate
+			var err error
+			if ca, caCert, err = resolveCA(db, ref); err != nil {
+				return err
+			}
+			now := time.Now()
+			if sharesIn != "" {
+				sharePaths := utils.ParseCommaSeparatedPaths(sharesIn)
+				keyID, err := utils.PublicKeyID(caCert.PublicKey)
+				if err != nil {
+					return err
+				}
+				if s, err := utils.ReadShareFile(sharePaths[0]); err != nil {
+					return err
+				} else if s.KeyID != keyID {
+					return fmt.Errorf("'%s' is a share of key %s, not of %s's key %s", s.Path, s.KeyID, ca.Name, keyID)
+				}
+				return assignFromShares(ca, sharePaths, note, now)
+			}
+			if custodian == "" {
+				return invalid(errors.New("must specify --custodian"))
+			}
+			c, err := custodyOf(ca)
+			if err != nil {
+				return err
+			}
+			return c.Assign(index, custodian, contact, note, now)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Custody of %s: %s\n", ca.Name, ca.Custody.Quorum())
+		return nil
+	},
+}
+
+var custodyTransferCmd = &cobra.Command{
+	Use:   "transfer",
+	Short: "Record the handover of a share from its holder to another custodian.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ref, _ := cmd.Flags().GetString("ca")
+		index, _ := cmd.Flags().GetInt("index")
+		to, _ := cmd.Flags().GetString("to")
+		if ref == "" || index == 0 || to == "" {
+			return invalid(errors.New("must specify --ca, --index and --to"))
+		}
+		contact, _ := cmd.Flags().GetString("contact")
+		note, _ := cmd.Flags().GetString("note")
+		var ca *inventory.CARecord
+		var from string
+		err := updateInventory(cmd, func(db *inventory.DB) error {
+			var err error
+			if ca, _, err = resolveCA(db, ref); err != nil {
+				return err
+			}
+			c, err := custodyOf(ca)
+			if err != nil {
+				return err
+			}
+			h, err := c.Holder(index)
+			if err != nil {
+				return err
+			}
+			from = h.Custodian
+			return c.Transfer(index, to, contact, note, time.Now())
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Share #%d of %s transferred from %s to %s\n", index, ca.Name, from, to)
+		fmt.Fprintln(cmd.OutOrStdout(), "The share file still names its original custodian; reshare to issue shares labelled with the new holders.")
+		return nil
+	},
+}
+
+func init() {
+	custodyListCmd.Flags().String("ca", "", "Only this CA (name, fingerprint or certificate file)")
+	custodyListCmd.Flags().Bool("history", false, "Also show every split, assignment and transfer")
+	custodyAssignCmd.Flags().String("ca", "", "CA (name, fingerprint or certificate file)")
+	custodyAssignCmd.Flags().String("shares-in", "", "Comma-separated share files whose metadata names their custodians (only the metadata is read)")
+	custodyAssignCmd.Flags().Int("index", 0, "Share index to assign")
+	custodyAssignCmd.Flags().String("custodian", "", "Custodian holding the share")
+	custodyAssignCmd.Flags().String("contact", "", "Custodian's contact details")
+	custodyAssignCmd.Flags().String("note", "", "Note for the custody history")
+	custodyTransferCmd.Flags().String("ca", "", "CA (name, fingerprint or certificate file)")
+	custodyTransferCmd.Flags().Int("index", 0, "Share index handed over")
+	custodyTransferCmd.Flags().String("to", "", "Custodian now holding the share")
+	custodyTransferCmd.Flags().String("contact", "", "New custodian's contact details")
+	custodyTransferCmd.Flags().String("note", "", "Note for the custody history, e.g. the reason for the handover")
+	custodyCmd.AddCommand(custodyListCmd, custodyAssignCmd, custodyTransferCmd)
+	rootCmd.AddCommand(custodyCmd)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes, stable for scripts.
+const (
+	exitFailure    = 1 // any other error
+	exitValidation = 2 // invalid command line: unknown command or flag, missing or invalid value
+	exitCombine    = 3 // the CA key could not be reconstructed from its shares
+	exitSigning    = 4 // the certificate or CRL could not be signed
+	exitExpiring   = 5 // expiring found certificates nearing expiry
+)
+
+// exitError carries the exit code of the error it wraps.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExitCode makes the command exit with code if it fails with err; nil stays nil.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// invalid marks err as a validation error of the command line.
+func invalid(err error) error {
+	return withExitCode(exitValidation, err)
+}
+
+// exitCode returns the exit code for err. Errors before the command started running come from its
+// command line, so they are validation errors.
+func exitCode(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	if !commandStarted {
+		return exitValidation
+	}
+	return exitFailure
+}
+
+// commandStarted is set once the command line is parsed and the global flags are applied.
+var commandStarted bool
+
+// progress receives the progress messages printed to stderr next to prompts; io.Discard under --quiet.
+var progress io.Writer = os.Stderr
+
+// configureQuiet applies --quiet: messages are dropped, while results given as --output json or
+// an output of "-" still go to stdout, and errors and prompts to stderr.
+func configureQuiet(cmd *cobra.Command) error {
+	if quiet, _ := cmd.Flags().GetBool("quiet"); !quiet {
+		return nil
+	}
+	cmd.Root().SetOut(io.Discard)
+	progress = io.Discard
+	// main reports the error once, without the usage
+	cmd.SilenceUsage, cmd.SilenceErrors = true, true
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "Print nothing but errors, prompts and results written to stdout (--output json, or an output of -); logs only errors")
+}
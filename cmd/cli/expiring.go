@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// certExtensions are the file extensions expiring reads certificates from in --dir.
+var certExtensions = []string{".pem", ".crt", ".cer", ".der"}
+
+// expiringResult is one certificate in the output of expiring.
+type expiringResult struct {
+	Serial      string    `json:"serial"` // hex
+	CommonName  string    `json:"common_name"`
+	Issuer      string    `json:"issuer"`
+	NotAfter    time.Time `json:"not_after"`
+	DaysLeft    int       `json:"days_left"` // negative once expired
+	SHA256      string    `json:"sha256"`
+	InInventory bool      `json:"in_inventory"`
+	Paths       []string  `json:"paths,omitempty"` // files in --dir holding it
+}
+
+// expiringCmd reports certificates nearing expiry, for people and for cron jobs.
+var expiringCmd = &cobra.Command{
+	Use:   "expiring",
+	Short: "Report the certificates in the inventory, and optionally in directories of PEM files, that expire within a window.",
+	Long: `Report the certificates in the inventory, and optionally in directories of PEM files, that expire within a window.
+
+Revoked certificates in the inventory are left out, and already expired ones are only reported with
+--expired. --dir walks a directory (e.g. where certificates are deployed) for .pem, .crt, .cer and
+.der files; a certificate found there and in the inventory is reported once, with its files.
+
+The command exits with code 5 when it reports any certificate, and 0 when none expires, so cron jobs
+can alert on the exit code and read the details from --json.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, _ := cmd.Flags().GetString("within")
+		within, err := parseWindow(s)
+		if err != nil {
+			return invalid(fmt.Errorf("invalid --within '%s': %w", s, err))
+		}
+		withExpired, _ := cmd.Flags().GetBool("expired")
+		dirs, _ := cmd.Flags().GetStringArray("dir")
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		deadline := now.Add(within)
+		due := func(notAfter time.Time) bool {
+			return notAfter.Before(deadline) && (withExpired || now.Before(notAfter))
+		}
+
+		results := map[string]*expiringResult{}
+		add := func(cert *x509.Certificate) *expiringResult {
+			fp := inventory.Fingerprint(cert)
+			if r, ok := results[fp]; ok {
+				return r
+			}
+			r := &expiringResult{
+				Serial:     hex.EncodeToString(cert.SerialNumber.Bytes()),
+				CommonName: cert.Subject.CommonName,
+				Issuer:     cert.Issuer.CommonName,
+				NotAfter:   cert.NotAfter.UTC(),
+				DaysLeft:   int(cert.NotAfter.Sub(now).Hours() / 24),
+				SHA256:     fp,
+			}
+			if r.CommonName == "" {
+				r.CommonName = cert.Subject.String()
+			}
+			results[fp] = r
+			return r
+		}
+
+		db, err := openInventory(cmd)
+		if err != nil {
+			return err
+		}
+		for _, rec := range db.Certificates {
+			if rec.Status == inventory.StatusRevoked || !due(rec.NotAfter) {
+				continue
+			}
+			cert, err := rec.Certificate()
+			if err != nil {
+				return err
+			}
+			add(cert).InInventory = true
+		}
+		for _, dir := range dirs {
+			err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() || !slices.Contains(certExtensions, strings.ToLower(filepath.Ext(path))) {
+					return nil
+				}
+				certs, err := utils.ParseCertificatesFromFile(path)
+				if err != nil {
+					// Keys and CSRs share these extensions
+					slog.Debug("no certificate read", "path", path, "err", err)
+					return nil
+				}
+				for _, cert := range certs {
+					if !due(cert.NotAfter) {
+						continue
+					}
+					r := add(cert)
+					r.InInventory = r.InInventory || db.Certificate(r.SHA256) != nil
+					r.Paths = append(r.Paths, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to scan '%s': %w", dir, err)
+			}
+		}
+
+		list := make([]expiringResult, 0, len(results))
+		for _, r := range results {
+			list = append(list, *r)
+		}
+		slices.SortFunc(list, func(a, b expiringResult) int {
+			if c := a.NotAfter.Compare(b.NotAfter); c != 0 {
+				return c
+			}
+			return strings.Compare(a.SHA256, b.SHA256)
+		})
+
+		if resultOut != nil {
+			if err := emitResult(list); err != nil {
+				return err
+			}
+		} else if len(list) == 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "No certificates expire within %s\n", s)
+		} else {
+			tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "DAYS LEFT\tNOT AFTER\tCN\tSERIAL\tISSUER\tWHERE")
+			for _, r := range list {
+				where := r.Paths
+				if r.InInventory {
+					where = append([]string{"inventory"}, where...)
+				}
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", r.DaysLeft, r.NotAfter.Format(time.DateOnly), r.CommonName,
+					r.Serial, r.Issuer, strings.Join(where, ", "))
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+		if len(list) > 0 {
+			// Findings are not a usage error
+			cmd.SilenceUsage = true
+			return withExitCode(exitExpiring, fmt.Errorf("%d certificate(s) expire within %s", len(list), s))
+		}
+		return nil
+	},
+}
+
+func init() {
+	expiringCmd.Flags().String("within", "30d", "Report certificates expiring within this window, e.g. 30d, 2w or 36h")
+	expiringCmd.Flags().StringArray("dir", nil, "Directory to scan for certificate files (.pem, .crt, .cer, .der) besides the inventory (repeatable)")
+	expiringCmd.Flags().Bool("expired", false, "Also report certificates that have already expired")
+	expiringCmd.Flags().Bool("json", false, "Print the certificates as a JSON array (same as --output json)")
+	rootCmd.AddCommand(expiringCmd)
+}
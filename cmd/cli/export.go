@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"my-pki/internal/inventory"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// exportColumns are the columns export-inventory can write, in default order.
+var exportColumns = []string{
+	"sha256", "serial", "subject", "issuer", "issuer_sha256", "names", "not_before", "not_after",
+	"is_ca", "status", "revoked_at", "revocation_reason", "profile", "path", "pem",
+}
+
+// exportRow is one certificate with its issuer name and SANs resolved.
+type exportRow struct {
+	rec    *inventory.CertRecord
+	issuer string
+	names  []string
+}
+
+// value returns column col of the row, as written to JSON.
+func (r exportRow) value(col string) any {
+	switch col {
+	case "sha256":
+		return r.rec.SHA256
+	case "serial":
+		return r.rec.Serial
+	case "subject":
+		return r.rec.Subject
+	case "issuer":
+		return r.issuer
+	case "issuer_sha256":
+		return r.rec.IssuerSHA256
+	case "names":
+		return r.names
+	case "not_before":
+		return r.rec.NotBefore
+	case "not_after":
+		return r.rec.NotAfter
+	case "is_ca":
+		return r.rec.IsCA
+	case "status":
+		return r.rec.Status
+	case "revoked_at":
+		return r.rec.RevokedAt
+	case "revocation_reason":
+		if r.rec.Status != inventory.StatusRevoked {
+			return nil
+		}
+		return r.rec.RevocationReason
+	case "profile":
+		return r.rec.Profile
+	case "path":
+		return r.rec.Path
+	case "pem":
+		return r.rec.PEM
+	}
+	return nil
+}
+
+// csvValue formats column col of the row for a spreadsheet cell.
+func (r exportRow) csvValue(col string) string {
+	switch v := r.value(col).(type) {
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, " ")
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	}
+	return ""
+}
+
+// exportInventoryCmd dumps the inventory for spreadsheets, CMDBs and compliance evidence.
+var exportInventoryCmd = &cobra.Command{
+	Use:   "export-inventory",
+	Short: "Export every certificate in the inventory as CSV or JSON, with a selectable set of columns.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "csv" && format != "json" {
+			return invalid(fmt.Errorf("invalid --format '%s' (expected csv or json)", format))
+		}
+		columns, _ := cmd.Flags().GetStringSlice("columns")
+		if len(columns) == 0 {
+			columns = exportColumns[:len(exportColumns)-1] // everything but the PEM
+		}
+		for _, col := range columns {
+			if !slices.Contains(exportColumns, col) {
+				return fmt.Errorf("unknown column '%s' (available: %s)", col, strings.Join(exportColumns, ", "))
+			}
+		}
+
+		db, err := openInventory(cmd)
+		if err != nil {
+			return err
+		}
+		caNames := map[string]string{}
+		for _, ca := range db.CAs {
+			caNames[ca.SHA256] = ca.Name
+		}
+		rows := make([]exportRow, 0, len(db.Certificates))
+		for _, rec := range db.Certificates {
+			row := exportRow{rec: rec, issuer: caNames[rec.IssuerSHA256]}
+			if cert, err := rec.Certificate(); err == nil {
+				row.names = inventory.Names(cert)
+			}
+			rows = append(rows, row)
+		}
+
+		write := writeInventoryCSV
+		if format == "json" {
+			write = writeInventoryJSON
+		}
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			return write(cmd.OutOrStdout(), rows, columns)
+		}
+		f, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("failed to create '%s': %w", out, err)
+		}
+		if err := write(f, rows, columns); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to export inventory to '%s': %w", out, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to export inventory to '%s': %w", out, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Exported %d certificates to %s\n", len(rows), out)
+		return nil
+	},
+}
+
+func writeInventoryCSV(w io.Writer, rows []exportRow, columns []string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, col := range columns {
+			record[i] = row.csvValue(col)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeInventoryJSON(w io.Writer, rows []exportRow, columns []string) error {
+	objects := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		obj := map[string]any{}
+		for _, col := range columns {
+			obj[col] = row.value(col)
+		}
+		objects[i] = obj
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(objects)
+}
+
+func init() {
+	exportInventoryCmd.Flags().String("format", "csv", "Output format: csv or json")
+	exportInventoryCmd.Flags().StringSlice("columns", nil, "Comma-separated columns to export (default: all but pem): "+strings.Join(exportColumns, ", "))
+	exportInventoryCmd.Flags().String("out", "", "Write to this file instead of standard output")
+	rootCmd.AddCommand(exportInventoryCmd)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"my-pki/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// fingerprintResult holds the fingerprints of one certificate under --output json.
+type fingerprintResult struct {
+	Path       string `json:"path"`
+	Subject    string `json:"subject"`
+	SHA256     string `json:"sha256"`
+	SHA1       string `json:"sha1"`
+	SPKISHA256 string `json:"spki_sha256"` // base64
+}
+
+// fingerprintCmd prints what is needed to compare certificates out of band or to pin their keys.
+var fingerprintCmd = &cobra.Command{
+	Use:   "fingerprint <cert.pem>...",
+	Short: "Print the SHA-256 and SHA-1 fingerprints of certificates and the base64 SHA-256 of their public key (SPKI pin).",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		results := []fingerprintResult{}
+		for _, path := range args {
+			certs, err := utils.ParseCertificatesFromFile(path)
+			if err != nil {
+				return err
+			}
+			for _, cert := range certs {
+				info := utils.InspectCertificate(cert)
+				results = append(results, fingerprintResult{
+					Path: path, Subject: info.Subject, SHA256: info.SHA256, SHA1: info.SHA1, SPKISHA256: info.SPKISHA256,
+				})
+			}
+		}
+		if resultOut != nil {
+			return emitResult(results)
+		}
+		for _, r := range results {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", r.Path, r.Subject)
+			fmt.Fprintf(cmd.OutOrStdout(), "  SHA-256: %s\n", r.SHA256)
+			fmt.Fprintf(cmd.OutOrStdout(), "  SHA-1:   %s\n", r.SHA1)
+			fmt.Fprintf(cmd.OutOrStdout(), "  pin-sha256: %s\n", r.SPKISHA256)
+		}
+		return nil
+	},
+}
+
+func init() {
+	fingerprintCmd.Flags().Bool("json", false, "Print the fingerprints as a JSON array (same as --output json)")
+	rootCmd.AddCommand(fingerprintCmd)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"my-pki/internal/inventory"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// graphNode is a certificate in the hierarchy drawn by graph.
+type graphNode struct {
+	id     string
+	label  string
+	isCA   bool
+	status string // as shown by list
+	issuer string // id of the issuing CA; empty for a root or a CA whose issuer is not in the inventory
+}
+
+// graphNodes builds the hierarchy below the CA with fingerprint rootSHA, or of the whole inventory
+// when it is empty. CAs known only by their CARecord (e.g. imported ones) are drawn as well.
+func graphNodes(db *inventory.DB, rootSHA string, withLeaves bool, now time.Time) []graphNode {
+	id := func(sha string) string { return "c" + sha[:16] }
+	known := map[string]bool{}
+	var nodes []graphNode
+	for _, rec := range db.Certificates {
+		if !rec.IsCA && !withLeaves {
+			continue
+		}
+		n := graphNode{id: id(rec.SHA256), label: rec.Subject, isCA: rec.IsCA, status: listStatus(rec, now)}
+		if cert, err := rec.Certificate(); err == nil && cert.Subject.CommonName != "" {
+			n.label = cert.Subject.CommonName
+		}
+		if rec.Profile != "" {
+			n.label += "\n" + rec.Profile
+		}
+		if rec.IssuerSHA256 != rec.SHA256 {
+			n.issuer = id(rec.IssuerSHA256)
+		}
+		known[rec.SHA256] = true
+		nodes = append(nodes, n)
+	}
+	for _, ca := range db.CAs {
+		if !known[ca.SHA256] {
+			status := inventory.StatusValid
+			if ca.Status == inventory.CACompromised {
+				status = inventory.StatusRevoked
+			}
+			nodes = append(nodes, graphNode{id: id(ca.SHA256), label: ca.Name, isCA: true, status: status})
+		}
+	}
+	for i := range nodes {
+		if nodes[i].issuer != "" && !hasNode(nodes, nodes[i].issuer) {
+			nodes[i].issuer = ""
+		}
+	}
+	if rootSHA == "" {
+		return nodes
+	}
+	// Keep the CA and everything issued below it
+	keep := map[string]bool{id(rootSHA): true}
+	for changed := true; changed; {
+		changed = false
+		for _, n := range nodes {
+			if !keep[n.id] && keep[n.issuer] {
+				keep[n.id], changed = true, true
+			}
+		}
+	}
+	var sub []graphNode
+	for _, n := range nodes {
+		if keep[n.id] {
+			if n.id == id(rootSHA) {
+				n.issuer = ""
+			}
+			sub = append(sub, n)
+		}
+	}
+	return sub
+}
+
+// hasNode reports whether nodes contain one with the given id.
+func hasNode(nodes []graphNode, id string) bool {
+	for _, n := range nodes {
+		if n.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDot renders nodes as a Graphviz digraph: CAs as boxes, leaves as ellipses, revoked certificates
+// in red and expired ones in grey.
+func writeDot(w io.Writer, nodes []graphNode) error {
+	var b strings.Builder
+	b.WriteString("digraph pki {\n\trankdir=TB;\n\tnode [fontname=\"Helvetica\"];\n")
+	for _, n := range nodes {
+		attrs := []string{"label=" + dotQuote(n.label)}
+		if n.isCA {
+			attrs = append(attrs, "shape=box", "style=bold")
+		} else {
+			attrs = append(attrs, "shape=ellipse")
+		}
+		switch n.status {
+		case inventory.StatusRevoked, listOnHold:
+			attrs = append(attrs, "color=red", "fontcolor=red")
+		case listExpired:
+			attrs = append(attrs, "color=grey", "fontcolor=grey")
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", n.id, strings.Join(attrs, ", "))
+	}
+	for _, n := range nodes {
+		if n.issuer != "" {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", n.issuer, n.id)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotQuote quotes s as a DOT string, keeping line breaks.
+func dotQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
+
+// writeMermaid renders nodes as a Mermaid flowchart, which Markdown renderers such as GitHub's draw inline.
+func writeMermaid(w io.Writer, nodes []graphNode) error {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	for _, n := range nodes {
+		label := strings.NewReplacer(`"`, "#quot;", "\n", "<br/>").Replace(n.label)
+		if n.isCA {
+			fmt.Fprintf(&b, "    %s[\"%s\"]\n", n.id, label)
+		} else {
+			fmt.Fprintf(&b, "    %s(\"%s\")\n", n.id, label)
+		}
+	}
+	for _, n := range nodes {
+		if n.issuer != "" {
+			fmt.Fprintf(&b, "    %s --> %s\n", n.issuer, n.id)
+		}
+	}
+	b.WriteString("    classDef revoked stroke:#c00,color:#c00,stroke-dasharray:5 5\n")
+	b.WriteString("    classDef expired stroke:#999,color:#999\n")
+	for _, n := range nodes {
+		switch n.status {
+		case inventory.StatusRevoked, listOnHold:
+			fmt.Fprintf(&b, "    class %s revoked\n", n.id)
+		case listExpired:
+			fmt.Fprintf(&b, "    class %s expired\n", n.id)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// graphCmd draws the CA hierarchy recorded in the inventory.
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Draw the root, sub-CA and leaf certificates in the inventory as a Graphviz (dot) or Mermaid diagram.",
+	Long: `Draw the root, sub-CA and leaf certificates in the inventory as a Graphviz (dot) or Mermaid diagram.
+
+CAs are drawn as boxes and leaves as rounded nodes, labelled with their common name and issuance
+profile. Revoked certificates are red and expired ones grey. Render dot output with
+"dot -Tsvg pki.dot -o pki.svg"; Mermaid output can be pasted into Markdown runbooks.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "dot" && format != "mermaid" {
+			return invalid(fmt.Errorf("invalid --format '%s' (expected dot or mermaid)", format))
+		}
+		db, err := openInventory(cmd)
+		if err != nil {
+			return err
+		}
+		var rootSHA string
+		if ref, _ := cmd.Flags().GetString("ca"); ref != "" {
+			ca, _, err := resolveCA(db, ref)
+			if err != nil {
+				return err
+			}
+			rootSHA = ca.SHA256
+		}
+		noLeaves, _ := cmd.Flags().GetBool("no-leaves")
+		nodes := graphNodes(db, rootSHA, !noLeaves, time.Now())
+		if len(nodes) == 0 {
+			dbPath, _ := cmd.Flags().GetString("db")
+			return fmt.Errorf("no certificates in inventory '%s'", dbPath)
+		}
+
+		out, _ := cmd.Flags().GetString("out")
+		w := cmd.OutOrStdout()
+		if out != "" {
+			f, err := os.Create(out)
+			if err != nil {
+				return fmt.Errorf("failed to create '%s': %w", out, err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if format == "mermaid" {
+			err = writeMermaid(w, nodes)
+		} else {
+			err = writeDot(w, nodes)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write the diagram: %w", err)
+		}
+		if out != "" {
+			fmt.Fprintf(progress, "Diagram of %d certificate(s) written to %s\n", len(nodes), out)
+		}
+		return nil
+	},
+}
+
+func init() {
+	graphCmd.Flags().String("format", "dot", "Diagram format: dot (Graphviz) or mermaid")
+	graphCmd.Flags().String("ca", "", "Only draw this CA and what it issued: name, SHA-256 fingerprint (prefix) or PEM path")
+	graphCmd.Flags().Bool("no-leaves", false, "Only draw CAs, for estates with many end-entity certificates")
+	graphCmd.Flags().String("out", "", "File path for the diagram (default: stdout)")
+	rootCmd.AddCommand(graphCmd)
+}
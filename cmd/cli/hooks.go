@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/hooks"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// newHookRequest describes an issuance about to be made by caPem under profile.
+// sans, if non-nil, supplies the subject alternative names being requested.
+func newHookRequest(cmd *cobra.Command, caPem, profile string, subject pkix.Name, sans *x509.Certificate, days int) (*hooks.Request, error) {
+	caCert, err := utils.ParseCertificateFromFile(caPem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+	}
+	now, err := utils.Now()
+	if err != nil {
+		return nil, err
+	}
+	req := &hooks.Request{
+		Command:   cmd.CommandPath(),
+		Profile:   profile,
+		CAPem:     caPem,
+		CASubject: caCert.Subject.String(),
+		Subject:   subject.String(),
+		Days:      days,
+		Time:      now.UTC(),
+	}
+	if sans != nil {
+		setHookSANs(req, sans)
+	}
+	return req, nil
+}
+
+func setHookSANs(req *hooks.Request, cert *x509.Certificate) {
+	req.DNSNames = cert.DNSNames
+	req.Emails = cert.EmailAddresses
+	req.IPs = nil
+	for _, ip := range cert.IPAddresses {
+		req.IPs = append(req.IPs, ip.String())
+	}
+	req.URIs = nil
+	for _, u := range cert.URIs {
+		req.URIs = append(req.URIs, u.String())
+	}
+}
+
+// preIssueHooks runs the profile's pre-issue hooks. Any failure vetoes the issuance.
+func preIssueHooks(settings caconfig.ProfileSettings, req *hooks.Request) error {
+	req.Stage = hooks.StagePreIssue
+	return hooks.Run(settings.PreIssue, req)
+}
+
+// postIssueHooks runs the profile's post-issue hooks for the certificate written to certOut.
+// The certificate already exists at this point, so a failing hook is reported as a warning.
+func postIssueHooks(settings caconfig.ProfileSettings, req *hooks.Request, certPEM []byte, certOut string) {
+	if len(settings.PostIssue) == 0 {
+		return
+	}
+	cert, err := parseCertPEM(certPEM)
+	if err != nil {
+		slog.Warn("post-issue hooks not run", "err", err)
+		return
+	}
+	req.Stage = hooks.StagePostIssue
+	req.Subject = cert.Subject.String()
+	setHookSANs(req, cert)
+	req.Days = utils.ValidityDays(cert)
+	req.Serial = hex.EncodeToString(cert.SerialNumber.Bytes())
+	req.SHA256 = inventory.Fingerprint(cert)
+	req.CertPEM = string(certPEM)
+	req.CertOut = certOut
+	if err := hooks.Run(settings.PostIssue, req); err != nil {
+		slog.Warn("certificate issued, but a post-issue hook failed", "cert", certOut, "err", err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// import-ca
+var importCACmd = &cobra.Command{
+	Use:   "import-ca",
+	Short: "Bring a CA created elsewhere (openssl, Vault) under Shamir custody: split its key into shares and register it in the inventory.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		certPath, _ := cmd.Flags().GetString("cert")
+		keyPath, _ := cmd.Flags().GetString("key")
+		pemOut, _ := cmd.Flags().GetString("pem-out")
+		parentPem, _ := cmd.Flags().GetString("parent-pem")
+		n, _ := cmd.Flags().GetInt("n")
+		t, _ := cmd.Flags().GetInt("t")
+		sharesOutStr, _ := cmd.Flags().GetString("shares-out")
+		if certPath == "" {
+			return invalid(errors.New("must specify --cert for the CA certificate"))
+		}
+		if keyPath == "" {
+			return invalid(errors.New("must specify --key for the CA private key"))
+		}
+		if pemOut == "" {
+			pemOut = certPath
+		}
+		if pemOut == utils.Stdio {
+			return invalid(errors.New("must specify --pem-out when --cert is read from stdin"))
+		}
+		sharePaths := utils.ParseCommaSeparatedPaths(sharesOutStr)
+		if len(sharePaths) == 0 {
+			return invalid(errors.New("must specify --shares-out for storing the key shares"))
+		}
+		if n != len(sharePaths) {
+			return fmt.Errorf("number of share files (%d) does not match n=%d", len(sharePaths), n)
+		}
+
+		certs, err := utils.ParseCertificatesFromFile(certPath)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", certPath, err)
+		}
+		caCert := certs[0]
+		if pemOut == certPath {
+			// The other commands find the CA by a PEM file
+			if _, err := utils.ParseCertificateFromFile(certPath); err != nil {
+				return fmt.Errorf("'%s' is not a PEM certificate; give --pem-out to write it as one", certPath)
+			}
+		}
+		if !caCert.IsCA {
+			return fmt.Errorf("'%s' is not a CA certificate", certPath)
+		}
+		caKey, err := utils.LoadPrivateKeyFromFile(keyPath, utils.PromptPassphrase(keyPath))
+		if err != nil {
+			return err
+		}
+		wipeOnExit(caKey)
+		if pub, ok := caKey.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(caCert.PublicKey) {
+			return fmt.Errorf("the private key '%s' does not match the certificate '%s'", keyPath, certPath)
+		}
+		selfSigned := inventory.IsSelfSigned(caCert)
+		if parentPem != "" && selfSigned {
+			return fmt.Errorf("'%s' is a self-signed root; --parent-pem is only for subordinate CAs", certPath)
+		}
+		parent := caCert
+		if parentPem != "" {
+			if parent, err = utils.ParseCertificateFromFile(parentPem); err != nil {
+				return fmt.Errorf("failed to parse parent CA certificate from '%s': %w", parentPem, err)
+			}
+			if err := caCert.CheckSignatureFrom(parent); err != nil {
+				return fmt.Errorf("'%s' was not issued by '%s': %w", certPath, parentPem, err)
+			}
+		}
+
+		custodians, err := custodiansFromFlags(cmd, n)
+		if err != nil {
+			return err
+		}
+		protection, err := shareProtection(cmd, sharePaths, custodians)
+		if err != nil {
+			return err
+		}
+		passphrases, err := sharePassphrases(cmd, sharePaths, custodians)
+		if err != nil {
+			return err
+		}
+		allowed, _ := cmd.Flags().GetString("allowed-profiles")
+		if _, err := caconfig.ParseProfileList(allowed); err != nil {
+			return err
+		}
+
+		certPEM := pemCert(caCert)
+		if pemOut != certPath {
+			if err := utils.WriteCertificateToFile(certPEM, pemOut); err != nil {
+				return fmt.Errorf("failed to write CA certificate to '%s': %w", pemOut, err)
+			}
+		}
+		if err := writeCAConfig(cmd, pemOut); err != nil {
+			return err
+		}
+
+		// A root starts its issuance log with its own certificate, as create-root does; the log of a
+		// subordinate CA's certificate belongs to its parent
+		if selfSigned {
+			if err := logIssuance(cmd, pemOut, certPEM, caKey); err != nil {
+				return err
+			}
+		} else {
+			err := updateInventory(cmd, func(db *inventory.DB) error {
+				db.AddCA(caCert, pemOut)
+				if parentPem != "" {
+					db.AddCA(parent, parentPem)
+					db.AddCertificate(caCert, parent)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		err = utils.SplitKeyAndWriteShares(caKey, n, t, sharePaths, custodians, passphrases, splitOptions(cmd, pemOut, protection)...)
+		if err != nil {
+			return fmt.Errorf("failed to split CA key: %w", err)
+		}
+		if err := recordCustody(cmd, pemOut, sharePaths); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "CA imported!\n - Certificate: %s\n - Subject: %s\n - %d shares written (%d needed).\n", pemOut, caCert.Subject, n, t)
+		if !selfSigned && parentPem == "" {
+			fmt.Fprintln(cmd.OutOrStdout(), "   Its parent is not in the inventory; give --parent-pem to record who issued it.")
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "The original key '%s' is still on disk: once the shares are distributed, destroy it and every copy (pki shred %s).\n", keyPath, keyPath)
+		if err := printCommitments(cmd, pemOut); err != nil {
+			return err
+		}
+		return emitCAResult(pemOut, certPEM, sharePaths, t)
+	},
+}
+
+func init() {
+	importCACmd.Flags().String("cert", "", "File path to the existing CA certificate (PEM or DER)")
+	importCACmd.Flags().String("key", "", "File path to the existing CA private key (PEM, SEC1 or PKCS#8, optionally encrypted)")
+	importCACmd.Flags().String("pem-out", "", "File path to write the CA certificate (PEM) to, next to which its configuration and logs are kept (default: --cert)")
+	importCACmd.Flags().String("parent-pem", "", "File path to the parent CA certificate (PEM) of a subordinate CA, to record it in the inventory")
+	importCACmd.Flags().Int("n", 3, "Number of total key shares")
+	importCACmd.Flags().Int("t", 2, "Threshold (quorum) number of shares required to recover the key")
+	importCACmd.Flags().String("shares-out", "", "Comma-separated list of file paths for the key shares (must match n).")
+	importCACmd.Flags().String("custodians", "", "Comma-separated custodian labels, one per share in --shares-out order (optional)")
+	importCACmd.Flags().String("contacts", "", "Comma-separated custodian contact details, one per share (optional)")
+	importCACmd.Flags().Bool("vss", false, "Split with Feldman verifiable secret sharing and publish the commitments next to --pem-out (<name>.vss.pem)")
+	importCACmd.Flags().Bool("envelope", false, "Encrypt the key under a random key-encryption key written next to --pem-out (<name>.wrapped-key.pem) and split only that key")
+	importCACmd.Flags().Bool("encrypt-shares", false, "Encrypt each share with its custodian's passphrase (Argon2id), asked for when writing and combining")
+	importCACmd.Flags().String("recipients", "", "Comma-separated age recipients (age1...) or files holding one, in --shares-out order, to encrypt each share to its custodian's key")
+	importCACmd.Flags().Bool("fido2", false, "Seal each share to its custodian's FIDO2 token (hmac-secret); each token is enrolled in turn and needed with its PIN to combine")
+	importCACmd.Flags().String("issuance-log", "", "Issuance log started for an imported root (default: <pem-out without extension>.issuance.log)")
+	importCACmd.Flags().String("allowed-profiles", "", "Comma-separated profiles the CA may issue (subca, leaf); written to <pem-out without extension>.ca.yaml")
+	rootCmd.AddCommand(importCACmd)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"my-pki/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// inspectResult describes one certificate of a file under --output json.
+type inspectResult struct {
+	Path string `json:"path"`
+	*utils.CertificateInfo
+}
+
+// inspectCmd prints certificates the way openssl x509 -text does, without needing openssl.
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <cert.pem>...",
+	Short: "Print the subject, issuer, validity, key, extensions and fingerprints of certificates (PEM, DER or - for stdin).",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		results := []inspectResult{}
+		for _, path := range args {
+			certs, err := utils.ParseCertificatesFromFile(path)
+			if err != nil {
+				return err
+			}
+			for i, cert := range certs {
+				info := utils.InspectCertificate(cert)
+				results = append(results, inspectResult{Path: path, CertificateInfo: info})
+				if resultOut != nil {
+					continue
+				}
+				if len(certs) > 1 {
+					fmt.Fprintf(cmd.OutOrStdout(), "Certificate %d of %d in %s\n", i+1, len(certs), path)
+				} else {
+					fmt.Fprintf(cmd.OutOrStdout(), "Certificate %s\n", path)
+				}
+				for _, line := range info.Lines(now) {
+					fmt.Fprintln(cmd.OutOrStdout(), "    "+line)
+				}
+			}
+		}
+		return emitResult(results)
+	},
+}
+
+func init() {
+	inspectCmd.Flags().Bool("json", false, "Print the certificates as a JSON array (same as --output json)")
+	rootCmd.AddCommand(inspectCmd)
+}
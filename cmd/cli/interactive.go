@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/secmem"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// askValid prompts until the answer passes valid, printing why an answer was rejected.
+func askValid(prompt, def string, valid func(string) error) (string, error) {
+	for {
+		answer, err := ask(prompt, def)
+		if err != nil {
+			return "", err
+		}
+		if err := valid(answer); err != nil {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// askFlag prompts for flag unless it was given, and sets it to the answer (repeatable flags get one value
+// per comma-separated item). Empty answers leave the flag unset.
+func askFlag(cmd *cobra.Command, flag, prompt, def string, valid func(string) error) error {
+	if cmd.Flags().Changed(flag) {
+		return nil
+	}
+	answer, err := askValid(prompt, def, valid)
+	if err != nil || answer == "" {
+		return err
+	}
+	values := []string{answer}
+	if cmd.Flags().Lookup(flag).Value.Type() == "stringArray" {
+		values = splitAnswer(answer)
+	}
+	for _, v := range values {
+		if err := cmd.Flags().Set(flag, v); err != nil {
+			return fmt.Errorf("invalid value for --%s: %w", flag, err)
+		}
+	}
+	return nil
+}
+
+// splitAnswer splits a comma-separated answer into its non-empty items.
+func splitAnswer(answer string) []string {
+	var items []string
+	for _, item := range strings.Split(answer, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// optional accepts every answer.
+func optional(string) error { return nil }
+
+// required rejects empty answers.
+func required(answer string) error {
+	if answer == "" {
+		return errors.New("a value is required")
+	}
+	return nil
+}
+
+var fileNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fileBaseName turns a common name into a file name, e.g. "www.example.com" or "Alice_Smith".
+func fileBaseName(cn string) string {
+	name := strings.Trim(fileNameUnsafe.ReplaceAllString(cn, "_"), "._")
+	if name == "" {
+		return "leaf"
+	}
+	return name
+}
+
+// writableFile accepts paths in existing directories.
+func writableFile(path string) error {
+	if err := required(path); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(filepath.Dir(path)); err != nil || !fi.IsDir() {
+		return fmt.Errorf("directory '%s' does not exist", filepath.Dir(path))
+	}
+	return nil
+}
+
+// missingSignFlags lists the flags sign cannot do without.
+func missingSignFlags(cmd *cobra.Command) []string {
+	var missing []string
+	for _, flag := range []string{"ca-pem", "cn", "cert-out"} {
+		if v, _ := cmd.Flags().GetString(flag); v == "" {
+			missing = append(missing, "--"+flag)
+		}
+	}
+	sharesIn, _ := cmd.Flags().GetString("shares-in")
+	caKey, _ := cmd.Flags().GetString("ca-key")
+	if sharesIn == "" && caKey == "" {
+		missing = append(missing, "--shares-in or --ca-key")
+	}
+	return missing
+}
+
+// promptSign asks for the sign flags that were not given: -i always, and otherwise only when required
+// flags are missing, stdin is a terminal and the operator agrees.
+func promptSign(cmd *cobra.Command) error {
+	if interactive, _ := cmd.Flags().GetBool("interactive"); !interactive {
+		missing := missingSignFlags(cmd)
+		if len(missing) == 0 || !utils.StdinIsTerminal() {
+			return nil
+		}
+		answer, err := ask(fmt.Sprintf("Missing %s. Enter them interactively? (Y/n)", strings.Join(missing, ", ")), "")
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(strings.ToLower(answer), "n") {
+			return nil
+		}
+	}
+
+	// The CA first, as its configuration decides which profiles and SANs are acceptable
+	var caCert *x509.Certificate
+	if err := askFlag(cmd, "ca-pem", "Signing CA certificate (PEM)", "", func(path string) error {
+		if err := required(path); err != nil {
+			return err
+		}
+		cert, err := utils.ParseCertificateFromFile(path)
+		if err != nil {
+			return err
+		}
+		if !cert.IsCA {
+			return fmt.Errorf("'%s' is not a CA certificate", path)
+		}
+		caCert = cert
+		return nil
+	}); err != nil {
+		return err
+	}
+	caPem, _ := cmd.Flags().GetString("ca-pem")
+	cfg, err := caconfig.LoadForCA(caPem)
+	if err != nil {
+		return err
+	}
+	if caCert == nil {
+		if caCert, err = utils.ParseCertificateFromFile(caPem); err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+	}
+	var profiles []string
+	for _, name := range cfg.ProfileNames(caconfig.ProfileLeaf) {
+		if cfg.Allows(name) {
+			profiles = append(profiles, name)
+		}
+	}
+	profile, _ := cmd.Flags().GetString("profile")
+	if err := askFlag(cmd, "profile", fmt.Sprintf("Profile (%s)", strings.Join(profiles, ", ")), profile, func(name string) error {
+		if !slices.Contains(profiles, name) {
+			return fmt.Errorf("'%s' is not one of %s", name, strings.Join(profiles, ", "))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	profile, _ = cmd.Flags().GetString("profile")
+	settings := cfg.Settings(profile)
+
+	// Subject
+	if !cmd.Flags().Changed("cn") {
+		fmt.Fprintf(os.Stderr, "Subject of the certificate (issued by %s):\n", caCert.Subject)
+	}
+	if err := askFlag(cmd, "cn", "  Common Name", "", required); err != nil {
+		return err
+	}
+	if err := askFlag(cmd, "org", "  Organization (optional, comma-separated)", "", optional); err != nil {
+		return err
+	}
+	if err := askFlag(cmd, "ou", "  Organizational Unit (optional, comma-separated)", "", optional); err != nil {
+		return err
+	}
+	if err := askFlag(cmd, "country", "  Country (optional, 2-letter code)", "", func(answer string) error {
+		for _, c := range splitAnswer(answer) {
+			if len(c) != 2 {
+				return fmt.Errorf("invalid country '%s' (expected a 2-letter code)", c)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	cn, _ := cmd.Flags().GetString("cn")
+
+	// SANs, checked against the profile's rules; a DNS-like common name is offered as the default
+	def := ""
+	if strings.Contains(cn, ".") && !strings.ContainsAny(cn, " @") {
+		def = cn
+	}
+	if err := askFlag(cmd, "san", "Subject alternative names (DNS names, IPs, e-mails or URIs, comma-separated)", def, func(answer string) error {
+		sans, err := utils.ParseSANs(splitAnswer(answer))
+		if err != nil {
+			return err
+		}
+		return settings.CheckSANs(settings.Normalization().SANs(sans))
+	}); err != nil {
+		return err
+	}
+
+	// Key of the signing CA
+	sharesIn, _ := cmd.Flags().GetString("shares-in")
+	caKey, _ := cmd.Flags().GetString("ca-key")
+	if sharesIn == "" && caKey == "" {
+		if err := askFlag(cmd, "shares-in", "Share files of the CA key, comma-separated (empty to use a key file)", "", func(answer string) error {
+			return checkShareAnswer(splitAnswer(answer))
+		}); err != nil {
+			return err
+		}
+		if sharesIn, _ = cmd.Flags().GetString("shares-in"); sharesIn == "" {
+			if err := askFlag(cmd, "ca-key", "CA private key file", "", func(path string) error {
+				if err := required(path); err != nil {
+					return err
+				}
+				_, err := os.Stat(path)
+				return err
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Output files
+	base := fileBaseName(cn)
+	if err := askFlag(cmd, "cert-out", "Certificate file", base+".pem", writableFile); err != nil {
+		return err
+	}
+	if pubkeyIn, _ := cmd.Flags().GetString("pubkey-in"); pubkeyIn == "" {
+		if err := askFlag(cmd, "key-out", "Private key file", base+".key", writableFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkShareAnswer checks that the share files (agent URLs aside) exist, belong together and meet their threshold.
+func checkShareAnswer(paths []string) error {
+	var files []string
+	agents := 0
+	for _, p := range paths {
+		if isAgentURL(p) {
+			agents++
+		} else {
+			files = append(files, p)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	shares, err := utils.ReadShareFiles(files)
+	if err != nil {
+		return err
+	}
+	for _, s := range shares {
+		secmem.Wipe(s.Data)
+	}
+	if n := len(shares) + agents; n < shares[0].Threshold {
+		return fmt.Errorf("%d share(s) given, %d needed", n, shares[0].Threshold)
+	}
+	return nil
+}
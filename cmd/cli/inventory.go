@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"my-pki/internal/audit"
+	"my-pki/internal/ctlog"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// openInventory opens the inventory named by the global --db flag.
+func openInventory(cmd *cobra.Command) (*inventory.DB, error) {
+	return inventory.Open(inventoryPath(cmd))
+}
+
+// inventoryPath returns --db, or the default inventory file.
+func inventoryPath(cmd *cobra.Command) string {
+	path, _ := cmd.Flags().GetString("db")
+	if path == "" {
+		path = inventory.Default()
+	}
+	return path
+}
+
+// updateInventory calls fn with the inventory of --db and saves it, holding the inventory lock
+// throughout (see inventory.Update).
+func updateInventory(cmd *cobra.Command, fn func(db *inventory.DB) error) error {
+	return inventory.Update(inventoryPath(cmd), fn)
+}
+
+// recordIssuance adds a certificate issued at issuedAt, and the CA at caPemPath that issued it, to the
+// inventory. A self-signed certificate is its own issuer (caPemPath may not have been written yet). Under
+// the inventory lock, it refuses a serial number the issuer already used for another certificate, then
+// calls appendLog before recording the certificate. Serials are drawn unused, so the check only trips
+// when another process issued the same serial meanwhile; the certificate is then neither logged nor written.
+func recordIssuance(cmd *cobra.Command, caPemPath string, certPEM []byte, issuedAt time.Time, appendLog func() error) error {
+	cert, issuer, err := issuedBy(certPEM, caPemPath)
+	if err != nil {
+		return err
+	}
+	err = updateInventory(cmd, func(db *inventory.DB) error {
+		if rec := db.SerialCollision(cert, issuer); rec != nil {
+			return fmt.Errorf("serial number %s of %s was already issued by %s to %s (%s); refusing to issue a duplicate",
+				rec.Serial, cert.Subject, issuer.Subject, rec.Subject, rec.SHA256[:16])
+		}
+		if err := appendLog(); err != nil {
+			return err
+		}
+		db.AddCA(issuer, caPemPath)
+		rec := db.AddCertificate(cert, issuer)
+		at := issuedAt.UTC()
+		rec.IssuedAt = &at
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("certificate issued", "subject", cert.Subject.String(), "serial", hex.EncodeToString(cert.SerialNumber.Bytes()),
+		"sha256", inventory.Fingerprint(cert), "ca", caPemPath)
+	params := map[string]string{"subject": cert.Subject.String(), "serial": hex.EncodeToString(cert.SerialNumber.Bytes())}
+	if f := cmd.Flags().Lookup("profile"); f != nil && f.Value.String() != "" {
+		params["profile"] = f.Value.String()
+	}
+	return recordAudit(cmd, audit.Entry{Event: audit.EventIssue, CA: issuer.Subject.String(), Params: params,
+		Fingerprints: []string{inventory.Fingerprint(cert), inventory.Fingerprint(issuer)}})
+}
+
+// issuedBy parses certPEM and its issuer: the CA at caPemPath, or the certificate itself for a root.
+func issuedBy(certPEM []byte, caPemPath string) (cert, issuer *x509.Certificate, err error) {
+	if cert, err = parseCertPEM(certPEM); err != nil {
+		return nil, nil, err
+	}
+	issuer = cert
+	if !inventory.IsSelfSigned(cert) {
+		if issuer, err = utils.ParseCertificateFromFile(caPemPath); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse CA certificate from '%s': %w", caPemPath, err)
+		}
+	}
+	return cert, issuer, nil
+}
+
+// recordLocation stores the issuance profile and the file a certificate was written to, if any, in
+// its inventory record, so that list shows them and reissue can renew it by serial.
+func recordLocation(cmd *cobra.Command, certPEM []byte, path, profile string) error {
+	cert, err := parseCertPEM(certPEM)
+	if err != nil {
+		return err
+	}
+	return updateInventory(cmd, func(db *inventory.DB) error {
+		rec := db.Certificate(inventory.Fingerprint(cert))
+		if rec == nil {
+			return fmt.Errorf("certificate %s is missing from the inventory", cert.Subject)
+		}
+		rec.Profile = profile
+		if path != "" && path != utils.Stdio {
+			if rec.Path, err = filepath.Abs(path); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// configureSerials applies the global --serial-bits flag and checks every new serial number against
+// those already in the inventory, so serials stay unique even after a restore from backup.
+func configureSerials(cmd *cobra.Command) error {
+	bits, _ := cmd.Flags().GetInt("serial-bits")
+	if err := utils.SetSerialBits(bits); err != nil {
+		return err
+	}
+	var used map[string]bool // loaded on first use, so commands that issue nothing never read the inventory
+	utils.ReserveSerial = func(serial *big.Int) bool {
+		if used == nil {
+			used = map[string]bool{}
+			db, err := openInventory(cmd)
+			if err != nil {
+				slog.Warn("serial numbers not checked for uniqueness", "err", err)
+			} else {
+				for _, rec := range db.Certificates {
+					used[rec.Serial] = true
+				}
+			}
+		}
+		s := hex.EncodeToString(serial.Bytes())
+		if used[s] {
+			slog.Info("serial number already in the inventory; drawing another", "serial", s)
+			return false
+		}
+		used[s] = true
+		return true
+	}
+	return nil
+}
+
+// checkNotCompromised refuses issuance from a CA that the inventory marks as compromised.
+func checkNotCompromised(cmd *cobra.Command, caPem string) error {
+	caCert, err := utils.ParseCertificateFromFile(caPem)
+	if err != nil {
+		return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+	}
+	db, err := openInventory(cmd)
+	if err != nil {
+		return err
+	}
+	ca, err := db.FindCA(inventory.Fingerprint(caCert))
+	if err == nil && ca.Status == inventory.CACompromised {
+		return fmt.Errorf("CA '%s' was marked compromised at %s and may no longer issue certificates", caPem, ca.CompromisedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// importIssuanceLog adds every certificate in caCert's issuance log to db, so certificates issued
+// before the inventory existed are covered too. It returns the number of log entries read.
+func importIssuanceLog(db *inventory.DB, logPath string, caCert *x509.Certificate) (int, error) {
+	l, err := ctlog.Open(logPath)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range l.Entries() {
+		cert, err := x509.ParseCertificate(e.Cert)
+		if err != nil {
+			return 0, fmt.Errorf("invalid certificate in issuance log entry %d: %w", e.Index, err)
+		}
+		if cert.CheckSignatureFrom(caCert) != nil {
+			continue
+		}
+		db.AddCertificate(cert, caCert)
+	}
+	return len(l.Entries()), nil
+}
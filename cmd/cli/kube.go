@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/kube"
+	"my-pki/internal/utils"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// kubeSignerCmd runs GoSeC as the signer of Kubernetes CertificateSigningRequests.
+var kubeSignerCmd = &cobra.Command{
+	Use:         "k8s-signer",
+	Short:       "Watch Kubernetes CertificateSigningRequests for a signer name and sign the approved ones with a GoSeC issuing CA.",
+	Annotations: map[string]string{annotationServer: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		signerName, _ := cmd.Flags().GetString("signer-name")
+		if signerName == "" || !strings.Contains(signerName, "/") {
+			return invalid(errors.New("must specify --signer-name as <domain>/<name>, e.g. gosec.example.com/issuing"))
+		}
+		kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
+		if kubeconfig == "" {
+			kubeconfig = os.Getenv("KUBECONFIG")
+		}
+		var client *kube.Client
+		var err error
+		if kubeconfig != "" {
+			contextName, _ := cmd.Flags().GetString("context")
+			client, err = kube.FromKubeconfig(kubeconfig, contextName)
+		} else {
+			client, err = kube.InCluster()
+		}
+		if err != nil {
+			return err
+		}
+
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the signing CA certificate"))
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+		days, _ := cmd.Flags().GetInt("days")
+		days, settings, err := resolveProfile(cmd, caPem, caconfig.ProfileLeaf, days)
+		if err != nil {
+			return err
+		}
+		opts, err := issuanceOptions(cmd, settings, days)
+		if err != nil {
+			return err
+		}
+		// The signer runs unattended, so the key is reconstructed once and kept for its lifetime
+		sharesInStr, _ := cmd.Flags().GetString("shares-in")
+		caKeyPath, _ := cmd.Flags().GetString("ca-key")
+		caKey, err := loadCAKey(cmd, caPem, sharesInStr, caKeyPath, "--shares-in", "--ca-key")
+		if err != nil {
+			return fmt.Errorf("failed to load CA private key: %w", err)
+		}
+
+		s := &kubeSigner{
+			cmd: cmd, client: client, signerName: signerName,
+			caPem: caPem, caCert: caCert, caKey: caKey, settings: settings, days: days, opts: opts,
+		}
+		once, _ := cmd.Flags().GetBool("once")
+		retry, _ := cmd.Flags().GetDuration("retry")
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Signing approved requests for %s on %s with '%s'\n", signerName, client.Server, caCert.Subject)
+		for {
+			csrs, resourceVersion, err := client.List(ctx)
+			if err == nil {
+				for _, csr := range csrs {
+					s.handle(ctx, csr)
+				}
+				if once {
+					return nil
+				}
+				err = client.Watch(ctx, resourceVersion, func(ev kube.Event) error {
+					if ev.Type != "DELETED" {
+						s.handle(ctx, ev.Object)
+					}
+					return nil
+				})
+			}
+			switch {
+			case ctx.Err() != nil:
+				fmt.Fprintln(cmd.OutOrStdout(), "Stopped")
+				return nil
+			case once:
+				return err
+			case errors.Is(err, kube.ErrGone):
+				continue // list again from the current state
+			case err != nil:
+				slog.Warn("watching certificate signing requests failed", "err", err, "retry_in", retry)
+				select {
+				case <-ctx.Done():
+				case <-time.After(retry):
+				}
+			}
+		}
+	},
+}
+
+// kubeSigner signs the CertificateSigningRequests addressed to signerName.
+type kubeSigner struct {
+	cmd        *cobra.Command
+	client     *kube.Client
+	signerName string
+	caPem      string
+	caCert     *x509.Certificate
+	caKey      crypto.Signer
+	settings   caconfig.ProfileSettings
+	days       int
+	opts       []utils.CertOption
+}
+
+// handle signs csr if it is addressed to this signer, approved and not yet signed, denied or failed.
+// A request the CA policy refuses is marked Failed with the reason.
+func (s *kubeSigner) handle(ctx context.Context, csr *kube.CertificateSigningRequest) {
+	if csr.Spec.SignerName != s.signerName || len(csr.Status.Certificate) > 0 ||
+		!csr.HasCondition(kube.ConditionApproved) || csr.HasCondition(kube.ConditionDenied) || csr.HasCondition(kube.ConditionFailed) {
+		return
+	}
+	name := csr.Metadata.Name
+	now, err := utils.Now()
+	if err != nil {
+		slog.Warn("request not handled", "csr", name, "err", err)
+		return
+	}
+	certPEM, reason, err := s.sign(csr, now)
+	if err != nil {
+		if reason == "" {
+			// Not the requester's fault: leave the request for the next attempt
+			slog.Warn("request not signed; retrying later", "csr", name, "err", err)
+			return
+		}
+		slog.Info("request refused", "csr", name, "reason", reason, "err", err)
+		csr.SetFailed(reason, err.Error(), now)
+	} else {
+		csr.Status.Certificate = certPEM
+	}
+	if err := s.client.UpdateStatus(ctx, csr); err != nil {
+		slog.Warn("failed to update request status", "csr", name, "err", err)
+		return
+	}
+	if certPEM != nil {
+		slog.Info("request signed", "csr", name, "user", csr.Spec.Username)
+	}
+}
+
+// sign applies the CA policy to csr and issues its certificate. When the request is refused, reason
+// is the Kubernetes condition reason; an error with an empty reason is a signer-side failure.
+func (s *kubeSigner) sign(csr *kube.CertificateSigningRequest, now time.Time) (certPEM []byte, reason string, err error) {
+	req, err := utils.DecodeCSRPEM(csr.Spec.Request)
+	if err != nil {
+		return nil, "InvalidRequest", err
+	}
+	if err := req.CheckSignature(); err != nil {
+		return nil, "InvalidRequest", fmt.Errorf("certificate request signature is invalid: %w", err)
+	}
+	ku, eku, err := kube.Usages(csr.Spec.Usages)
+	if err != nil {
+		return nil, "UnsupportedUsage", err
+	}
+	if ku == 0 {
+		ku = x509.KeyUsageDigitalSignature
+	}
+
+	// The requested lifetime may shorten the profile's validity, never extend it
+	validity := time.Duration(s.days) * 24 * time.Hour
+	if exp := csr.Spec.ExpirationSeconds; exp != nil && time.Duration(*exp)*time.Second < validity {
+		validity = time.Duration(*exp) * time.Second
+	}
+	days := int(math.Ceil(validity.Hours() / 24))
+
+	norm := s.settings.Normalization()
+	subject := norm.Subject(req.Subject)
+	sans := norm.SANs(utils.SANsFromCSR(req))
+	hookReq, err := newHookRequest(s.cmd, s.caPem, caconfig.ProfileLeaf, subject, &x509.Certificate{
+		DNSNames: sans.DNSNames, IPAddresses: sans.IPAddresses, EmailAddresses: sans.EmailAddresses, URIs: sans.URIs,
+	}, days)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := preIssueHooks(s.settings, hookReq); err != nil {
+		return nil, "PolicyDenied", err
+	}
+
+	opts := append(slices.Clone(s.opts), utils.WithSANs(sans), utils.WithValidity(now, now.Add(validity)),
+		func(template *x509.Certificate) error {
+			template.ExtKeyUsage = eku
+			return nil
+		})
+	certPEM, err = utils.SignPublicKey(subject, req.PublicKey, s.caCert, s.caKey, false, days, ku, opts...)
+	if err != nil {
+		return nil, "SigningFailed", err
+	}
+	if err := logIssuance(s.cmd, s.caPem, certPEM, s.caKey); err != nil {
+		return nil, "", err
+	}
+	postIssueHooks(s.settings, hookReq, certPEM, "")
+	return certPEM, "", nil
+}
+
+func init() {
+	kubeSignerCmd.Flags().String("kubeconfig", "", "Kubeconfig file (default: $KUBECONFIG, else the pod's service account)")
+	kubeSignerCmd.Flags().String("context", "", "Kubeconfig context to use (default: the current context)")
+	kubeSignerCmd.Flags().String("signer-name", "", "spec.signerName of the requests to sign, e.g. gosec.example.com/issuing")
+	kubeSignerCmd.Flags().String("ca-pem", "", "File path to the signing CA certificate (PEM)")
+	kubeSignerCmd.Flags().String("shares-in", "", "Comma-separated list of share files for the signing CA's private key")
+	kubeSignerCmd.Flags().String("ca-key", "", "File path to the signing CA private key (PEM, SEC1 or PKCS#8, optionally encrypted) instead of shares")
+	kubeSignerCmd.Flags().Int("days", 30, "Validity period (in days); a shorter spec.expirationSeconds takes precedence")
+	kubeSignerCmd.Flags().String("issuance-log", "", "Issuance log of the signing CA (default: <ca-pem without extension>.issuance.log)")
+	kubeSignerCmd.Flags().Bool("once", false, "Process the current requests and exit instead of watching")
+	kubeSignerCmd.Flags().Duration("retry", 10*time.Second, "Delay before reconnecting after an API server error")
+	addPolicyFlags(kubeSignerCmd)
+	addDistributionFlags(kubeSignerCmd)
+	rootCmd.AddCommand(kubeSignerCmd)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"my-pki/internal/inventory"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Statuses shown by list, besides inventory.StatusValid and inventory.StatusRevoked.
+const (
+	listExpired = "expired"
+	listOnHold  = "on-hold"
+)
+
+// listResult is one certificate in the output of list.
+type listResult struct {
+	Serial           string     `json:"serial"` // hex
+	CommonName       string     `json:"common_name"`
+	SANs             []string   `json:"sans,omitempty"`
+	NotAfter         time.Time  `json:"not_after"`
+	Status           string     `json:"status"`
+	Issuer           string     `json:"issuer"`
+	Profile          string     `json:"profile,omitempty"`
+	Path             string     `json:"path,omitempty"`
+	SHA256           string     `json:"sha256"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+}
+
+// listStatus is the status of rec at now: revoked (or on hold) before expired before valid.
+func listStatus(rec *inventory.CertRecord, now time.Time) string {
+	switch {
+	case rec.OnHold():
+		return listOnHold
+	case rec.Status == inventory.StatusRevoked:
+		return inventory.StatusRevoked
+	case !now.Before(rec.NotAfter):
+		return listExpired
+	}
+	return inventory.StatusValid
+}
+
+var errWindow = errors.New("expected days (30d), weeks (2w) or a duration (36h)")
+
+// parseWindow parses a time window such as 30d, 2w or a Go duration like 36h.
+func parseWindow(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, errWindow
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errWindow
+	}
+	return d, nil
+}
+
+// listCmd gives the overview of issued certificates that search gives piece by piece.
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the certificates in the inventory with their serial, CN, SANs, expiry and status.",
+	Long: `List the certificates in the inventory with their serial, CN, SANs, expiry and status.
+
+--expired, --expiring and --revoked each select certificates with that status; given together, a
+certificate matching any of them is listed. Without them every certificate is listed.
+
+--cn, --san, --issued-after and --issued-before narrow the list further, e.g. to the certificates
+of a host during an incident: list --cn '*.internal' --san 10.0.0.0/8 --issued-after 2024-01-01`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openInventory(cmd)
+		if err != nil {
+			return err
+		}
+		var q inventory.Query
+		if err := nameQuery(cmd, &q); err != nil {
+			return err
+		}
+		if ref, _ := cmd.Flags().GetString("ca"); ref != "" {
+			ca, _, err := resolveCA(db, ref)
+			if err != nil {
+				return err
+			}
+			q.IssuerSHA256 = ca.SHA256
+		}
+		expired, _ := cmd.Flags().GetBool("expired")
+		revoked, _ := cmd.Flags().GetBool("revoked")
+		var expiring time.Duration
+		if s, _ := cmd.Flags().GetString("expiring"); s != "" {
+			if expiring, err = parseWindow(s); err != nil {
+				return invalid(fmt.Errorf("invalid --expiring '%s': %w", s, err))
+			}
+		}
+		recs, err := db.Search(q)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		caNames := map[string]string{}
+		for _, ca := range db.CAs {
+			caNames[ca.SHA256] = ca.Name
+		}
+		results := []listResult{}
+		for _, rec := range recs {
+			status := listStatus(rec, now)
+			if expired || revoked || expiring > 0 {
+				match := expired && status == listExpired ||
+					revoked && (status == inventory.StatusRevoked || status == listOnHold) ||
+					expiring > 0 && status == inventory.StatusValid && rec.NotAfter.Before(now.Add(expiring))
+				if !match {
+					continue
+				}
+			}
+			r := listResult{
+				Serial:    rec.Serial,
+				NotAfter:  rec.NotAfter.UTC(),
+				Status:    status,
+				Issuer:    caNames[rec.IssuerSHA256],
+				Profile:   rec.Profile,
+				Path:      rec.Path,
+				SHA256:    rec.SHA256,
+				RevokedAt: rec.RevokedAt,
+			}
+			if rec.Status == inventory.StatusRevoked {
+				r.RevocationReason = inventory.ReasonName(rec.RevocationReason)
+			}
+			if cert, err := rec.Certificate(); err == nil {
+				r.CommonName = cert.Subject.CommonName
+				for _, name := range inventory.Names(cert) {
+					if name != r.CommonName {
+						r.SANs = append(r.SANs, name)
+					}
+				}
+			} else {
+				r.CommonName = rec.Subject
+			}
+			results = append(results, r)
+		}
+
+		if resultOut != nil {
+			return emitResult(results)
+		}
+		if len(results) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "No certificates found")
+			return nil
+		}
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SERIAL\tCN\tSANS\tNOT AFTER\tSTATUS\tPROFILE\tISSUER")
+		for _, r := range results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Serial, r.CommonName, strings.Join(r.SANs, ", "),
+				r.NotAfter.Format(time.DateOnly), r.Status, r.Profile, r.Issuer)
+		}
+		return tw.Flush()
+	},
+}
+
+func init() {
+	listCmd.Flags().String("ca", "", "Only certificates issued by this CA: name, SHA-256 fingerprint (prefix) or PEM path")
+	addNameFlags(listCmd)
+	listCmd.Flags().Bool("expired", false, "Select expired certificates")
+	listCmd.Flags().String("expiring", "", "Select valid certificates expiring within this window, e.g. 30d, 2w or 36h")
+	listCmd.Flags().Bool("revoked", false, "Select revoked certificates, including those on hold")
+	listCmd.Flags().Bool("json", false, "Print the certificates as a JSON array (same as --output json)")
+	rootCmd.AddCommand(listCmd)
+}
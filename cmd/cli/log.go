@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"my-pki/internal/ctlog"
+	"my-pki/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// logCmd groups the issuance log subcommands.
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Inspect and verify the append-only issuance log kept for each CA.",
+}
+
+// log verify
+var logVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify that a CA's issuance log is complete, unmodified and signed by the CA.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the CA whose log should be verified"))
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+
+		logPath := issuanceLogPath(cmd, caPem)
+		l, err := ctlog.Open(logPath)
+		if err != nil {
+			return err
+		}
+		if err := l.Verify(caCert); err != nil {
+			return fmt.Errorf("issuance log '%s' failed verification: %w", logPath, err)
+		}
+
+		heads := l.TreeHeads()
+		expectRoot, _ := cmd.Flags().GetString("root-hash")
+		var recorded *ctlog.TreeHead
+		if expectRoot != "" {
+			for i := range heads {
+				if hex.EncodeToString(heads[i].RootHash) == expectRoot {
+					recorded = &heads[i]
+					break
+				}
+			}
+			if recorded == nil {
+				return fmt.Errorf("no tree head with root hash %s found in '%s' (log truncated or rewritten?)", expectRoot, logPath)
+			}
+		}
+		var consistency [][]byte
+		if recorded != nil {
+			sth, proof, err := l.ConsistencyProof(recorded.TreeSize)
+			if err != nil {
+				return err
+			}
+			if !ctlog.VerifyConsistency(recorded.TreeSize, sth.TreeSize, proof, recorded.RootHash, sth.RootHash) {
+				return fmt.Errorf("tree head %s is not consistent with the latest tree head of '%s'", expectRoot, logPath)
+			}
+			consistency = proof
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Issuance log '%s' verified.\n - Entries: %d\n - Signed tree heads: %d\n", logPath, len(l.Entries()), len(heads))
+		if len(heads) > 0 {
+			latest := heads[len(heads)-1]
+			fmt.Fprintf(cmd.OutOrStdout(), " - Latest tree head: size=%d root=%s (%s)\n",
+				latest.TreeSize, hex.EncodeToString(latest.RootHash), latest.Timestamp.Format("2006-01-02T15:04:05Z07:00"))
+		}
+		if recorded != nil {
+			fmt.Fprintf(cmd.OutOrStdout(), "Tree head of size %d is consistent with the latest. Consistency proof:\n", recorded.TreeSize)
+			for _, h := range consistency {
+				fmt.Fprintf(cmd.OutOrStdout(), " - %s\n", hex.EncodeToString(h))
+			}
+		}
+		return nil
+	},
+}
+
+// log prove
+var logProveCmd = &cobra.Command{
+	Use:   "prove",
+	Short: "Print and check an inclusion proof for a certificate against the latest signed tree head.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the issuing CA"))
+		}
+		certPath, _ := cmd.Flags().GetString("cert")
+		if certPath == "" {
+			return invalid(errors.New("must specify --cert for the certificate to prove"))
+		}
+		cert, err := utils.ParseCertificateFromFile(certPath)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate from '%s': %w", certPath, err)
+		}
+
+		logPath := issuanceLogPath(cmd, caPem)
+		l, err := ctlog.Open(logPath)
+		if err != nil {
+			return err
+		}
+		index := l.Find(cert.Raw)
+		if index < 0 {
+			return fmt.Errorf("certificate '%s' is not present in issuance log '%s'", certPath, logPath)
+		}
+		sth, proof, err := l.InclusionProof(index)
+		if err != nil {
+			return err
+		}
+		if !ctlog.VerifyInclusion(ctlog.LeafHash(cert.Raw), index, sth.TreeSize, proof, sth.RootHash) {
+			return errors.New("inclusion proof does not verify against the latest tree head")
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Certificate '%s' is entry %d of %d.\n", certPath, index, sth.TreeSize)
+		fmt.Fprintf(cmd.OutOrStdout(), "Root hash: %s\n", hex.EncodeToString(sth.RootHash))
+		fmt.Fprintln(cmd.OutOrStdout(), "Audit path:")
+		for _, h := range proof {
+			fmt.Fprintf(cmd.OutOrStdout(), " - %s\n", hex.EncodeToString(h))
+		}
+		return nil
+	},
+}
+
+// issuanceLogPath returns --issuance-log if set, otherwise the default log path for the CA certificate.
+func issuanceLogPath(cmd *cobra.Command, caPemPath string) string {
+	if p, _ := cmd.Flags().GetString("issuance-log"); p != "" {
+		return p
+	}
+	return ctlog.PathForCA(caPemPath)
+}
+
+// logIssuance appends a newly issued certificate to the issuing CA's log, signing the new tree head with caKey,
+// and records it in the inventory.
+func logIssuance(cmd *cobra.Command, caPemPath string, certPEM []byte, caKey crypto.Signer) error {
+	logPath := issuanceLogPath(cmd, caPemPath)
+	now, err := utils.Now()
+	if err != nil {
+		return err
+	}
+	return recordIssuance(cmd, caPemPath, certPEM, now, func() error {
+		if err := ctlog.AppendCertificatePEM(logPath, certPEM, caKey, now); err != nil {
+			return fmt.Errorf("failed to record issuance in '%s': %w", logPath, err)
+		}
+		return nil
+	})
+}
+
+func init() {
+	logVerifyCmd.Flags().String("ca-pem", "", "File path to the CA certificate (PEM)")
+	logVerifyCmd.Flags().String("issuance-log", "", "Issuance log path (default: <ca-pem without extension>.issuance.log)")
+	logVerifyCmd.Flags().String("root-hash", "", "Hex root hash of a previously recorded tree head that must still be present")
+
+	logProveCmd.Flags().String("ca-pem", "", "File path to the issuing CA certificate (PEM)")
+	logProveCmd.Flags().String("issuance-log", "", "Issuance log path (default: <ca-pem without extension>.issuance.log)")
+	logProveCmd.Flags().String("cert", "", "File path to the certificate to prove (PEM)")
+
+	logCmd.AddCommand(logVerifyCmd)
+	logCmd.AddCommand(logProveCmd)
+	rootCmd.AddCommand(logCmd)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log/slog"
+	"my-pki/internal/logging"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// annotationServer marks long-running commands, whose requests are logged at info level by default.
+const annotationServer = "server"
+
+// configureLogging applies --log-level and --log-format. Logs go to stderr, apart from the results on stdout.
+func configureLogging(cmd *cobra.Command) error {
+	level, _ := cmd.Flags().GetString("log-level")
+	if !cmd.Flags().Changed("log-level") {
+		if quiet, _ := cmd.Flags().GetBool("quiet"); quiet {
+			level = "error"
+		} else if cmd.Annotations[annotationServer] != "" {
+			level = "info"
+		}
+	}
+	format, _ := cmd.Flags().GetString("log-format")
+	if err := logging.Setup(os.Stderr, level, format); err != nil {
+		return err
+	}
+	slog.Debug("command started", "command", cmd.CommandPath())
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().String("log-level", "warn", "Least severe log messages shown: debug, info, warn or error (servers default to info, --quiet to error)")
+	rootCmd.PersistentFlags().String("log-format", logging.FormatText, "Log format on stderr: text or json")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"my-pki/internal/inventory"
+	"my-pki/internal/opensslca"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// opensslReason maps the revocation reason of an index entry to a reason code. revoked is false for
+// removeFromCRL, which openssl writes for a certificate released from hold.
+func opensslReason(e opensslca.Entry) (code int, revoked bool, err error) {
+	switch e.Reason {
+	case "":
+		return inventory.ReasonUnspecified, true, nil
+	case "keyTime":
+		return inventory.ReasonKeyCompromise, true, nil
+	case "CAkeyTime":
+		return inventory.ReasonCACompromise, true, nil
+	case "holdInstruction":
+		return inventory.ReasonCertificateHold, true, nil
+	case "removeFromCRL":
+		return 0, false, nil
+	}
+	code, err = inventory.ParseReason(e.Reason)
+	return code, err == nil, err
+}
+
+// opensslCertFile finds the certificate of an index entry: the file it names, or newcerts/<serial>.pem.
+func opensslCertFile(dir, newcerts string, e opensslca.Entry) (string, error) {
+	var candidates []string
+	if e.File != "" && e.File != "unknown" {
+		candidates = append(candidates, e.File, filepath.Join(dir, e.File))
+	}
+	candidates = append(candidates, filepath.Join(newcerts, e.Serial+".pem"), filepath.Join(newcerts, strings.ToLower(e.Serial)+".pem"))
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no certificate file (looked for %s)", filepath.Join(newcerts, e.Serial+".pem"))
+}
+
+// importOpenSSLCmd brings the issuance history of an "openssl ca" directory into the inventory.
+var importOpenSSLCmd = &cobra.Command{
+	Use:   "import-openssl",
+	Short: "Record the issued and revoked certificates of an openssl ca directory (index.txt, newcerts/) in the inventory.",
+	Long: `Record the issued and revoked certificates of an openssl ca directory (index.txt, newcerts/) in the inventory.
+
+Each line of index.txt becomes a certificate record, with its revocation time and reason, once its
+certificate is found in newcerts/ (or at the file name the line gives) and verified against the CA
+certificate. The crlnumber file carries over, so the next CRL from GoSeC continues the sequence.
+Lines whose certificate is missing or does not match are reported and skipped. Importing again only
+adds what is new.
+
+To issue from the CA with GoSeC as well, bring its key under custody with import-ca.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, _ := cmd.Flags().GetString("dir")
+		indexPath, _ := cmd.Flags().GetString("index")
+		if indexPath == "" {
+			indexPath = filepath.Join(dir, "index.txt")
+		}
+		newcerts, _ := cmd.Flags().GetString("newcerts")
+		if newcerts == "" {
+			newcerts = filepath.Join(dir, "newcerts")
+		}
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			caPem = filepath.Join(dir, "cacert.pem")
+		}
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return invalid(fmt.Errorf("failed to parse CA certificate from '%s' (give --ca-pem): %w", caPem, err))
+		}
+		if !caCert.IsCA {
+			return fmt.Errorf("'%s' is not a CA certificate", caPem)
+		}
+		f, err := os.Open(indexPath)
+		if err != nil {
+			return fmt.Errorf("unable to read '%s': %w", indexPath, err)
+		}
+		entries, err := opensslca.ParseIndex(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse '%s': %w", indexPath, err)
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("'%s' lists no certificates", indexPath)
+		}
+
+		// record adds the entries to db; a dry run applies it to a copy that is not saved
+		record := func(db *inventory.DB) error {
+			ca := db.AddCA(caCert, caPem)
+			var imported, revoked, known int
+			var skipped []string
+			skip := func(e opensslca.Entry, err error) {
+				skipped = append(skipped, fmt.Sprintf("line %d (serial %s, %s): %v", e.Line, e.Serial, e.Subject, err))
+			}
+			for _, e := range entries {
+				path, err := opensslCertFile(dir, newcerts, e)
+				if err != nil {
+					skip(e, err)
+					continue
+				}
+				cert, err := utils.ParseCertificateFromFile(path)
+				if err != nil {
+					skip(e, err)
+					continue
+				}
+				if want, _ := new(big.Int).SetString(e.Serial, 16); cert.SerialNumber.Cmp(want) != 0 {
+					skip(e, fmt.Errorf("'%s' has serial %X", path, cert.SerialNumber))
+					continue
+				}
+				if err := cert.CheckSignatureFrom(caCert); err != nil {
+					skip(e, fmt.Errorf("'%s' was not issued by %s: %w", path, caCert.Subject, err))
+					continue
+				}
+				if rec := db.SerialCollision(cert, caCert); rec != nil {
+					skip(e, fmt.Errorf("serial already recorded for %s", rec.Subject))
+					continue
+				}
+				reason, isRevoked := 0, false
+				if e.Status == opensslca.StatusRevoked {
+					if reason, isRevoked, err = opensslReason(e); err != nil {
+						skip(e, err)
+						continue
+					}
+				}
+				if db.Certificate(inventory.Fingerprint(cert)) != nil {
+					known++
+				} else {
+					imported++
+				}
+				rec := db.AddCertificate(cert, caCert)
+				if rec.Path == "" {
+					if abs, err := filepath.Abs(path); err == nil {
+						rec.Path = abs
+					}
+				}
+				if isRevoked {
+					rec.Revoke(e.RevokedAt, reason)
+					revoked++
+				}
+			}
+
+			// crlnumber holds the next number; the inventory keeps the last one issued
+			next, err := opensslca.ReadCounter(filepath.Join(dir, "crlnumber"))
+			if err != nil {
+				return err
+			}
+			if next != nil && next.Sign() > 0 && next.IsInt64() && next.Int64()-1 > ca.CRLNumber {
+				ca.CRLNumber = next.Int64() - 1
+				fmt.Fprintf(cmd.OutOrStdout(), "CRL numbers continue from %d\n", ca.NextCRLNumber())
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: %d certificate(s) of %s imported (%d revoked), %d already in the inventory\n",
+				indexPath, imported, caCert.Subject, revoked, known)
+			if len(skipped) > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "Skipped %d line(s):\n", len(skipped))
+				for _, s := range skipped {
+					fmt.Fprintf(cmd.OutOrStdout(), " - %s\n", s)
+				}
+			}
+			if imported+known == 0 {
+				return errors.New("no certificate could be imported")
+			}
+			return nil
+		}
+		if dryRun {
+			db, err := openInventory(cmd)
+			if err != nil {
+				return err
+			}
+			if err := record(db); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Dry run: the inventory was not changed")
+			return nil
+		}
+		return updateInventory(cmd, record)
+	},
+}
+
+func init() {
+	importOpenSSLCmd.Flags().String("dir", ".", "The openssl ca directory (dir in openssl.cnf)")
+	importOpenSSLCmd.Flags().String("index", "", "The index.txt database (default: <dir>/index.txt)")
+	importOpenSSLCmd.Flags().String("newcerts", "", "Directory of issued certificates named <serial>.pem (default: <dir>/newcerts)")
+	importOpenSSLCmd.Flags().String("ca-pem", "", "The CA certificate (default: <dir>/cacert.pem)")
+	importOpenSSLCmd.Flags().Bool("dry-run", false, "Report what would be imported without changing the inventory")
+	rootCmd.AddCommand(importOpenSSLCmd)
+}
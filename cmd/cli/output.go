@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// resultOut receives the JSON results under --output json; it is nil in text mode. Everything commands
+// print for people goes to their output writer (cmd.OutOrStdout), which is then stderr, so that stdout
+// holds nothing but the result.
+var resultOut *os.File
+
+// stdoutFlags may be "-" to write their certificate, key or CSR to stdout.
+var stdoutFlags = []string{"cert-out", "key-out", "csr-out"}
+
+// fileOnlyFlags name CA certificates, next to which the CA's configuration and logs are kept, and key
+// shares; they cannot be "-".
+var fileOnlyFlags = []string{"ca-pem", "parent-pem", "root-pem", "pem-out", "shares-in", "parent-shares-in", "shares-out"}
+
+// configureResultOutput applies --output, and points the commands' output writer at stderr when stdout
+// holds a result or an output given as "-".
+func configureResultOutput(cmd *cobra.Command) error {
+	for _, name := range fileOnlyFlags {
+		if f := cmd.Flags().Lookup(name); f != nil && slices.Contains(utils.ParseCommaSeparatedPaths(f.Value.String()), utils.Stdio) {
+			return invalid(fmt.Errorf("--%s must name files; stdin and stdout ('-') cannot be used", name))
+		}
+	}
+	var stdoutFlag string
+	for _, name := range stdoutFlags {
+		if v, _ := cmd.Flags().GetString(name); v == utils.Stdio {
+			stdoutFlag = name
+			break
+		}
+	}
+
+	format, _ := cmd.Flags().GetString("output")
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		format = outputJSON
+	}
+	switch format {
+	case outputText:
+		if stdoutFlag == "" {
+			return nil
+		}
+	case outputJSON:
+		if stdoutFlag != "" {
+			return fmt.Errorf("--output json cannot be used with --%s -, as stdout holds the JSON result", stdoutFlag)
+		}
+		resultOut = os.Stdout
+		utils.Stdout = nil
+	default:
+		return invalid(fmt.Errorf("invalid --output '%s' (expected %s or %s)", format, outputText, outputJSON))
+	}
+	cmd.Root().SetOut(os.Stderr)
+	return nil
+}
+
+// emitResult writes v as the command's JSON result under --output json.
+func emitResult(v any) error {
+	if resultOut == nil {
+		return nil
+	}
+	enc := json.NewEncoder(resultOut)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// certResult describes a certificate written by a command.
+type certResult struct {
+	Path      string    `json:"path"`
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"` // hex
+	SHA256    string    `json:"sha256"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	IsCA      bool      `json:"is_ca,omitempty"`
+	SANs      []string  `json:"sans,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
+	KeyPath   string    `json:"key_path,omitempty"`
+	Shares    []string  `json:"shares,omitempty"`
+	Threshold int       `json:"threshold,omitempty"`
+}
+
+// newCertResult describes the certificate certPEM, written to path.
+func newCertResult(path string, certPEM []byte) (*certResult, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no certificate was written to '%s'", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate written to '%s': %w", path, err)
+	}
+	return &certResult{
+		Path:      path,
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		Serial:    hex.EncodeToString(cert.SerialNumber.Bytes()),
+		SHA256:    inventory.Fingerprint(cert),
+		NotBefore: cert.NotBefore.UTC(),
+		NotAfter:  cert.NotAfter.UTC(),
+		IsCA:      cert.IsCA,
+		SANs: utils.SANs{
+			DNSNames: cert.DNSNames, IPAddresses: cert.IPAddresses, EmailAddresses: cert.EmailAddresses, URIs: cert.URIs,
+		}.Strings(),
+	}, nil
+}
+
+// emitCAResult emits the result of creating a CA whose key was split into threshold-of-shares.
+func emitCAResult(path string, certPEM []byte, shares []string, threshold int) error {
+	result, err := newCertResult(path, certPEM)
+	if err != nil {
+		return err
+	}
+	result.Shares, result.Threshold = shares, threshold
+	return emitResult(result)
+}
+
+func init() {
+	rootCmd.PersistentFlags().String("output", outputText, "Result format of create-root, create-subca, import-ca, sign, sign-csr, inspect, fingerprint, list, expiring, audit show, inspect-csr, inspect-crl and api-token create and list: text or json (messages then go to stderr)")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/utils"
+
+	"github.com/spf13/cobra"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// p12Encoders maps the --encryption values to their PKCS#12 encoders.
+var p12Encoders = map[string]*pkcs12.Encoder{
+	"modern": pkcs12.Modern,    // PBES2 with AES-256-CBC, HMAC-SHA256
+	"legacy": pkcs12.LegacyRC2, // 3DES key, RC2-40 certificates, HMAC-SHA1
+}
+
+// export-p12
+var exportP12Cmd = &cobra.Command{
+	Use:   "export-p12",
+	Short: "Pack a certificate, its private key and chain into a password-protected PKCS#12 (.p12/.pfx) file for Windows, browsers and Java.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		certPath, _ := cmd.Flags().GetString("cert")
+		keyPath, _ := cmd.Flags().GetString("key")
+		chainStr, _ := cmd.Flags().GetString("chain")
+		out, _ := cmd.Flags().GetString("out")
+		encryption, _ := cmd.Flags().GetString("encryption")
+		if certPath == "" {
+			return invalid(errors.New("must specify --cert for the certificate"))
+		}
+		if keyPath == "" {
+			return invalid(errors.New("must specify --key for its private key"))
+		}
+		if out == "" {
+			return invalid(errors.New("must specify --out for the PKCS#12 file"))
+		}
+		encoder, ok := p12Encoders[encryption]
+		if !ok {
+			return invalid(fmt.Errorf("invalid --encryption '%s' (expected modern or legacy)", encryption))
+		}
+
+		cert, err := utils.ParseCertificateFromFile(certPath)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate from '%s': %w", certPath, err)
+		}
+		key, err := utils.LoadPrivateKeyFromFile(keyPath, utils.PromptPassphrase(keyPath))
+		if err != nil {
+			return err
+		}
+		wipeOnExit(key)
+		if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(cert.PublicKey) {
+			return fmt.Errorf("the private key '%s' does not match the certificate '%s'", keyPath, certPath)
+		}
+		var chain []*x509.Certificate
+		for _, path := range utils.ParseCommaSeparatedPaths(chainStr) {
+			certs, err := utils.ParseCertificatesFromFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to parse chain certificates from '%s': %w", path, err)
+			}
+			chain = append(chain, certs...)
+		}
+		if len(chain) > 0 && cert.CheckSignatureFrom(chain[0]) != nil {
+			slog.Warn("certificate not issued by the first chain certificate; importers may not link them", "cert", certPath, "chain_subject", chain[0].Subject.String())
+		}
+
+		password, err := p12Password(cmd, out)
+		if err != nil {
+			return err
+		}
+		data, err := encoder.WithRand(utils.Rand).Encode(key, cert, chain, string(password))
+		if err != nil {
+			return fmt.Errorf("failed to encode PKCS#12 file: %w", err)
+		}
+		if err := utils.WritePKCS12ToFile(data, out); err != nil {
+			return fmt.Errorf("failed to write '%s': %w", out, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "PKCS#12 file written to %s (%s encryption, %d chain certificate(s))\n", out, encryption, len(chain))
+		return nil
+	},
+}
+
+// p12Password returns --password, or asks for the password of out (twice) on the terminal.
+func p12Password(cmd *cobra.Command, out string) ([]byte, error) {
+	if password, _ := cmd.Flags().GetString("password"); password != "" {
+		return []byte(password), nil
+	}
+	return utils.ReadNewPassphrase(fmt.Sprintf("'%s'", out))
+}
+
+func init() {
+	exportP12Cmd.Flags().String("cert", "", "File path to the certificate (PEM)")
+	exportP12Cmd.Flags().String("key", "", "File path to its private key (PEM, SEC1 or PKCS#8, optionally encrypted)")
+	exportP12Cmd.Flags().String("chain", "", "Comma-separated CA certificate files (PEM bundles or DER) to include, issuer first")
+	exportP12Cmd.Flags().String("out", "", "File path for the PKCS#12 file (.p12/.pfx)")
+	exportP12Cmd.Flags().String("password", "", "Password of the PKCS#12 file (visible to other local users; omit to be prompted)")
+	exportP12Cmd.Flags().String("encryption", "modern", "modern (AES-256, HMAC-SHA256) or legacy (3DES/RC2, HMAC-SHA1) for Windows before Server 2019, Java 8 and older macOS")
+	rootCmd.AddCommand(exportP12Cmd)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// outputPermFlags names the global flags setting the permissions of each kind of written file.
+var outputPermFlags = []struct {
+	flag  string
+	perms func(*utils.OutputPerms) *utils.FilePerms
+}{
+	{"cert-perms", func(o *utils.OutputPerms) *utils.FilePerms { return &o.Certs }},
+	{"key-perms", func(o *utils.OutputPerms) *utils.FilePerms { return &o.Keys }},
+	{"share-perms", func(o *utils.OutputPerms) *utils.FilePerms { return &o.Shares }},
+}
+
+// configureOutput applies the global --cert-perms, --key-perms, --share-perms and --umask flags.
+func configureOutput(cmd *cobra.Command) error {
+	for _, f := range outputPermFlags {
+		v, _ := cmd.Flags().GetString(f.flag)
+		if v == "" {
+			continue
+		}
+		p, err := utils.ParseFilePerms(v)
+		if err != nil {
+			return invalid(fmt.Errorf("invalid --%s: %w", f.flag, err))
+		}
+		*f.perms(&utils.Output) = p
+	}
+	if v, _ := cmd.Flags().GetString("umask"); v != "" {
+		umask, err := utils.ParseMode(v)
+		if err != nil {
+			return invalid(fmt.Errorf("invalid --umask: %w", err))
+		}
+		utils.Output.Umask = &umask
+	}
+	return nil
+}
+
+// applyProfileOutput fills in the output permissions of a CA profile that were not given on the command line.
+func applyProfileOutput(cmd *cobra.Command, caPem string, settings *caconfig.OutputSettings) error {
+	if settings == nil {
+		return nil
+	}
+	perms, err := settings.Perms()
+	if err != nil {
+		return fmt.Errorf("'%s': %w", caconfig.PathForCA(caPem), err)
+	}
+	for _, f := range outputPermFlags {
+		if !cmd.Flags().Changed(f.flag) {
+			*f.perms(&utils.Output) = *f.perms(&perms)
+		}
+	}
+	if !cmd.Flags().Changed("umask") && perms.Umask != nil {
+		utils.Output.Umask = perms.Umask
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"my-pki/internal/batch"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/hooks"
+	"my-pki/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// plan
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Validate a batch manifest and write a JSON plan of what would be issued, without touching the CA key.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifestPath, _ := cmd.Flags().GetString("manifest")
+		if manifestPath == "" {
+			return invalid(errors.New("must specify --manifest for the batch manifest (YAML)"))
+		}
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			return invalid(errors.New("must specify --out for the plan (JSON)"))
+		}
+		m, data, err := batch.LoadManifest(manifestPath)
+		if err != nil {
+			return err
+		}
+		plan, err := batch.MakePlan(m, data, time.Now())
+		if err != nil {
+			return err
+		}
+		planSum, err := batch.WritePlan(plan, out)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Plan for CA %s (%s):\n", plan.CAPem, plan.CASubject)
+		for _, a := range plan.Actions {
+			fmt.Fprintf(cmd.OutOrStdout(), "  + %s %s (%s, %d days) -> %s\n", a.Action, a.SubjectString, a.Profile, a.Days, a.CertOut)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%d action(s) written to %s (SHA-256 %s)\n", len(plan.Actions), out, planSum)
+		return nil
+	},
+}
+
+// apply
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Execute a previously reviewed plan with the CA key reconstructed from shares.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		planPath, _ := cmd.Flags().GetString("plan")
+		if planPath == "" {
+			return invalid(errors.New("must specify --plan for the reviewed plan (JSON)"))
+		}
+		plan, err := batch.LoadPlan(planPath)
+		if err != nil {
+			return err
+		}
+		expected, _ := cmd.Flags().GetString("plan-sha256")
+		if expected != "" && !strings.EqualFold(expected, plan.SHA256) {
+			return fmt.Errorf("plan '%s' has SHA-256 %s, not the reviewed %s", planPath, plan.SHA256, expected)
+		}
+		if err := checkNotCompromised(cmd, plan.CAPem); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Applying plan %s (SHA-256 %s): %d action(s) for CA %s\n", planPath, plan.SHA256, len(plan.Actions), plan.CASubject)
+		caCert, err := utils.ParseCertificateFromFile(plan.CAPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", plan.CAPem, err)
+		}
+
+		// Every action's pre-issue hooks must pass before the custodians are asked for their shares
+		cfg, err := caconfig.LoadForCA(plan.CAPem)
+		if err != nil {
+			return err
+		}
+		hookReqs := make([]*hooks.Request, len(plan.Actions))
+		for i, a := range plan.Actions {
+			if hookReqs[i], err = newHookRequest(cmd, plan.CAPem, a.Profile, a.Subject.Name(), nil, a.Days); err != nil {
+				return err
+			}
+			if err := preIssueHooks(cfg.Settings(a.Profile), hookReqs[i]); err != nil {
+				return fmt.Errorf("action %d (%s): %w", i+1, a.SubjectString, err)
+			}
+		}
+
+		sharesInStr, _ := cmd.Flags().GetString("shares-in")
+		caKeyPath, _ := cmd.Flags().GetString("ca-key")
+		caKey, err := loadCAKey(cmd, plan.CAPem, sharesInStr, caKeyPath, "--shares-in", "--ca-key")
+		if err != nil {
+			return fmt.Errorf("failed to load CA private key: %w", err)
+		}
+
+		for i, a := range plan.Actions {
+			certPEM, err := applyIssue(cmd, plan.CAPem, caCert, caKey, a)
+			if err != nil {
+				return fmt.Errorf("action %d (%s): %w (%d of %d actions applied)", i+1, a.SubjectString, err, i, len(plan.Actions))
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "  issued %s -> %s\n", a.SubjectString, a.CertOut)
+			postIssueHooks(cfg.Settings(a.Profile), hookReqs[i], certPEM, a.CertOut)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Plan %s applied: %d certificate(s) issued\n", planPath, len(plan.Actions))
+		return nil
+	},
+}
+
+// applyIssue executes one "issue" action of a plan and returns the issued certificate.
+func applyIssue(cmd *cobra.Command, caPem string, caCert *x509.Certificate, caKey crypto.Signer, a batch.Action) ([]byte, error) {
+	ku, err := batch.KeyUsage(a.KeyUsage)
+	if err != nil {
+		return nil, err
+	}
+	opts := []utils.CertOption{utils.WithPolicies(a.Policies)}
+
+	var certPEM []byte
+	var key *ecdsa.PrivateKey
+	if a.PubkeyIn != "" {
+		pub, err := utils.ParsePublicKeyFile(a.PubkeyIn)
+		if err != nil {
+			return nil, err
+		}
+		certPEM, err = utils.SignPublicKey(a.Subject.Name(), pub, caCert, caKey, false, a.Days, ku, opts...)
+		if err != nil {
+			return nil, withExitCode(exitSigning, err)
+		}
+	} else {
+		certPEM, key, err = utils.GenerateKeyAndCert(a.Subject.Name(), caCert, caKey, false, a.Days, ku, opts...)
+		if err != nil {
+			return nil, withExitCode(exitSigning, err)
+		}
+	}
+
+	if err := logIssuance(cmd, caPem, certPEM, caKey); err != nil {
+		return nil, err
+	}
+	if err := utils.WriteCertificateToFile(certPEM, a.CertOut); err != nil {
+		return nil, fmt.Errorf("failed to write certificate to '%s': %w", a.CertOut, err)
+	}
+	if a.KeyOut != "" {
+		if err := utils.WritePrivateKeyToFile(key, a.KeyOut, utils.KeyFormatSEC1); err != nil {
+			return nil, fmt.Errorf("failed to write private key to '%s': %w", a.KeyOut, err)
+		}
+	}
+	return certPEM, nil
+}
+
+func init() {
+	planCmd.Flags().String("manifest", "", "Batch manifest (YAML) listing the certificates to issue")
+	planCmd.Flags().String("out", "", "File path for the plan (JSON)")
+
+	applyCmd.Flags().String("plan", "", "Reviewed plan (JSON) produced by 'plan'")
+	applyCmd.Flags().String("plan-sha256", "", "Refuse to apply unless the plan file has this SHA-256 (as recorded at review time)")
+	applyCmd.Flags().String("shares-in", "", "Comma-separated list of share files for the CA's private key")
+	applyCmd.Flags().String("ca-key", "", "File path to the CA private key (PEM, SEC1 or PKCS#8, optionally encrypted) instead of shares")
+	applyCmd.Flags().String("issuance-log", "", "Issuance log of the CA (default: <ca_pem without extension>.issuance.log)")
+
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(applyCmd)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// reissueCmd re-issues an existing certificate (e.g. from another CA) under a GoSeC CA.
+var reissueCmd = &cobra.Command{
+	Use:   "reissue",
+	Short: "Issue a new certificate copying subject, SANs and extensions from an existing certificate.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		templatePath, _ := cmd.Flags().GetString("template-cert")
+		serial, _ := cmd.Flags().GetString("serial")
+		if (templatePath == "") == (serial == "") {
+			return invalid(errors.New("must specify either --template-cert or --serial for the certificate to copy"))
+		}
+		// Renewing by serial takes the certificate, its CA and profile from the inventory
+		var oldCert *x509.Certificate
+		var recorded *inventory.CertRecord
+		var err error
+		if serial != "" {
+			db, err := openInventory(cmd)
+			if err != nil {
+				return err
+			}
+			if recorded, err = findCertRecord(cmd, db, serial, ""); err != nil {
+				return err
+			}
+			if oldCert, err = recorded.Certificate(); err != nil {
+				return err
+			}
+			templatePath = "serial " + recorded.Serial
+			if ca, err := db.FindCA(recorded.IssuerSHA256); err == nil && ca.PemPath != "" && !cmd.Flags().Changed("ca-pem") {
+				if err := cmd.Flags().Set("ca-pem", ca.PemPath); err != nil {
+					return err
+				}
+			}
+		} else if oldCert, err = utils.ParseCertificateFromFile(templatePath); err != nil {
+			return fmt.Errorf("failed to parse template certificate from '%s': %w", templatePath, err)
+		}
+
+		if oldCert.IsCA {
+			slog.Warn("the template certificate is a CA; the re-issued certificate will be a CA too")
+		}
+
+		days, _ := cmd.Flags().GetInt("days")
+		if days <= 0 {
+			days = utils.ValidityDays(oldCert)
+		}
+		days, err = validityDays(cmd, days)
+		if err != nil {
+			return err
+		}
+
+		keyFormat, _ := cmd.Flags().GetString("key-format")
+		if keyFormat != utils.KeyFormatSEC1 && keyFormat != utils.KeyFormatPKCS8 {
+			return invalid(fmt.Errorf("invalid --key-format '%s' (expected %s or %s)", keyFormat, utils.KeyFormatSEC1, utils.KeyFormatPKCS8))
+		}
+		keyOut, _ := cmd.Flags().GetString("key-out")
+		keyPass, err := leafKeyPassphrase(cmd, keyOut)
+		if err != nil {
+			return err
+		}
+
+		// Key to certify: the template's own key, an external key, or a freshly generated one
+		reuseKey, _ := cmd.Flags().GetBool("reuse-key")
+		pubkeyIn, _ := cmd.Flags().GetString("pubkey-in")
+		var pub crypto.PublicKey
+		var newKey *ecdsa.PrivateKey
+		switch {
+		case reuseKey && pubkeyIn != "":
+			return errors.New("--reuse-key and --pubkey-in are mutually exclusive")
+		case (reuseKey || pubkeyIn != "") && keyOut != "":
+			return errors.New("--key-out cannot be used with --reuse-key or --pubkey-in (no private key is generated)")
+		case reuseKey:
+			pub = oldCert.PublicKey
+		case pubkeyIn != "":
+			if pub, err = utils.ParsePublicKeyFile(pubkeyIn); err != nil {
+				return err
+			}
+		default:
+			if newKey, err = utils.GenerateECKey(); err != nil {
+				return err
+			}
+			pub = &newKey.PublicKey
+		}
+
+		certOut, _ := cmd.Flags().GetString("cert-out")
+		if certOut == "" && caHome == "" {
+			return invalid(errors.New("must specify --cert-out for the re-issued certificate"))
+		}
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the signing CA certificate"))
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+		profile := caconfig.ProfileLeaf
+		switch {
+		case recorded != nil && recorded.Profile != "":
+			profile = recorded.Profile
+		case oldCert.IsCA:
+			profile = caconfig.ProfileSubCA
+		}
+		days, settings, err := resolveProfile(cmd, caPem, profile, days)
+		if err != nil {
+			return err
+		}
+		// Policies of the template are kept unless overridden on the command line; the distribution
+		// URLs and maximum validity are the new issuer's
+		opts, err := issuanceOptions(cmd, caconfig.ProfileSettings{Distribution: settings.Distribution, MaxDays: settings.MaxDays}, days)
+		if err != nil {
+			return err
+		}
+		// The subject keeps its exact encoding; the SANs are normalized like those of new requests
+		template := utils.TemplateFromCertificate(oldCert)
+		sans := settings.Normalization().SANs(utils.SANs{
+			DNSNames: template.DNSNames, IPAddresses: template.IPAddresses, EmailAddresses: template.EmailAddresses, URIs: template.URIs,
+		})
+		if err := utils.WithSANs(sans)(template); err != nil {
+			return err
+		}
+		hookReq, err := newHookRequest(cmd, caPem, profile, oldCert.Subject, template, days)
+		if err != nil {
+			return err
+		}
+		if err := preIssueHooks(settings, hookReq); err != nil {
+			return err
+		}
+		sharesInStr, _ := cmd.Flags().GetString("shares-in")
+		caKeyPath, _ := cmd.Flags().GetString("ca-key")
+		caKey, err := loadCAKey(cmd, caPem, sharesInStr, caKeyPath, "--shares-in", "--ca-key")
+		if err != nil {
+			return fmt.Errorf("failed to load CA private key: %w", err)
+		}
+
+		certPEM, err := utils.IssueFromTemplate(template, pub, caCert, caKey, days, opts...)
+		if err != nil {
+			return withExitCode(exitSigning, fmt.Errorf("failed to re-issue certificate: %w", err))
+		}
+		if err := logIssuance(cmd, caPem, certPEM, caKey); err != nil {
+			return err
+		}
+		if caHome != "" && (certOut == "" || keyOut == "" && newKey != nil) {
+			// A CA home keeps what it issued by serial
+			serial, err := issuedSerial(certPEM)
+			if err != nil {
+				return err
+			}
+			if certOut == "" {
+				certOut = caHome.NewCertPath(serial)
+			}
+			if keyOut == "" && newKey != nil {
+				keyOut = caHome.KeyPath(serial)
+			}
+		}
+		if err := utils.WriteCertificateToFile(certPEM, certOut); err != nil {
+			return fmt.Errorf("failed to write certificate to '%s': %w", certOut, err)
+		}
+		if err := recordLocation(cmd, certPEM, certOut, profile); err != nil {
+			return err
+		}
+		if keyOut != "" {
+			if keyPass != nil {
+				err = utils.WriteEncryptedPrivateKeyToFile(newKey, keyOut, keyPass)
+			} else {
+				err = utils.WritePrivateKeyToFile(newKey, keyOut, keyFormat)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write private key to '%s': %w", keyOut, err)
+			}
+		}
+		postIssueHooks(settings, hookReq, certPEM, certOut)
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Re-issued '%s' (%s) as %s, valid for %d days\n", templatePath, oldCert.Subject, certOut, days)
+		if keyOut != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "New private key written to %s\n", keyOut)
+		}
+		return nil
+	},
+}
+
+func init() {
+	reissueCmd.Flags().String("template-cert", "", "Existing certificate (PEM) whose subject, SANs and extensions are copied")
+	reissueCmd.Flags().String("serial", "", "Hex serial of a certificate in the inventory to renew instead of --template-cert; its CA and profile are the defaults")
+	reissueCmd.Flags().String("ca", "", "With --serial: the issuing CA (name, SHA-256 fingerprint or PEM path), when several certificates share the serial")
+	reissueCmd.Flags().String("ca-pem", "", "File path to the signing CA certificate (PEM) (default with --serial: the CA that issued it)")
+	reissueCmd.Flags().String("shares-in", "", "Comma-separated list of share files for the signing CA's private key")
+	reissueCmd.Flags().String("ca-key", "", "File path to the signing CA private key (PEM, SEC1 or PKCS#8, optionally encrypted) instead of shares")
+	reissueCmd.Flags().String("cert-out", "", "File path for the re-issued certificate (PEM), or - for stdout")
+	reissueCmd.Flags().Int("days", 0, "Validity period (in days) (default: same as the template certificate)")
+	reissueCmd.Flags().Bool("reuse-key", false, "Certify the template certificate's existing public key instead of generating a new key")
+	reissueCmd.Flags().String("pubkey-in", "", "Certify an existing public key (PEM PUBLIC KEY or CSR) instead of generating a key pair")
+	reissueCmd.Flags().String("key-out", "", "File path to store the newly generated private key (PEM), or - for stdout")
+	reissueCmd.Flags().String("key-format", utils.KeyFormatSEC1, "Encoding for --key-out: sec1 (EC PRIVATE KEY) or pkcs8 (PRIVATE KEY)")
+	reissueCmd.Flags().Bool("encrypt-key", false, "Prompt for a passphrase and write --key-out as encrypted PKCS#8 (scrypt + AES-256)")
+	reissueCmd.Flags().String("key-pass", "", "Passphrase to encrypt --key-out with (visible to other local users; prefer --encrypt-key)")
+	addValidityFlags(reissueCmd)
+	addPolicyFlags(reissueCmd)
+	addDistributionFlags(reissueCmd)
+	reissueCmd.Flags().String("issuance-log", "", "Issuance log of the signing CA (default: <ca-pem without extension>.issuance.log)")
+	rootCmd.AddCommand(reissueCmd)
+}
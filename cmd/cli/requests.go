@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"my-pki/internal/inventory"
+	"my-pki/internal/queue"
+	"my-pki/internal/utils"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// requestsCmd groups the pending-request queue subcommands.
+var requestsCmd = &cobra.Command{
+	Use:   "requests",
+	Short: "Queue CSRs for review: submit, list and show requests, then approve (sign) or deny them.",
+}
+
+// requests submit
+var requestsSubmitCmd = &cobra.Command{
+	Use:   "submit",
+	Short: "Add a CSR to the queue of pending requests.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		csrIn, _ := cmd.Flags().GetString("csr-in")
+		if csrIn == "" {
+			return invalid(errors.New("must specify --csr-in for the certificate request"))
+		}
+		data, err := utils.ReadInput(csrIn)
+		if err != nil {
+			return fmt.Errorf("unable to read CSR file '%s': %w", csrIn, err)
+		}
+		requester, _ := cmd.Flags().GetString("requester")
+		note, _ := cmd.Flags().GetString("note")
+		req, err := requestQueue(cmd).Submit(data, operatorName(requester), note)
+		if err != nil {
+			return fmt.Errorf("failed to submit '%s': %w", csrIn, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Request %s submitted for %s; it will be signed once approved\n", req.ID, req.Subject)
+		return nil
+	},
+}
+
+// requests list
+var requestsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List queued requests (pending only unless --status is given).",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		status, _ := cmd.Flags().GetString("status")
+		if status != "all" && status != queue.StatusPending && status != queue.StatusIssued && status != queue.StatusDenied {
+			return invalid(fmt.Errorf("invalid --status '%s' (expected pending, issued, denied or all)", status))
+		}
+		reqs, err := requestQueue(cmd).List()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTATUS\tSUBMITTED\tREQUESTER\tSUBJECT\tSANS")
+		for _, req := range reqs {
+			if status != "all" && req.Status != status {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", req.ID, req.Status, req.Submitted.Format(time.RFC3339),
+				req.Requester, req.Subject, strings.Join(req.Names, ", "))
+		}
+		return tw.Flush()
+	},
+}
+
+// requests show
+var requestsShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show a queued request and the CSR it contains.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := requestQueue(cmd)
+		req, err := store.Get(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Request %s (%s)\n", req.ID, req.Status)
+		fmt.Fprintf(cmd.OutOrStdout(), " - Submitted: %s by %s\n", req.Submitted.Format(time.RFC3339), req.Requester)
+		if req.Note != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), " - Note: %s\n", req.Note)
+		}
+		if req.DecidedAt != nil {
+			fmt.Fprintf(cmd.OutOrStdout(), " - Decided: %s by %s\n", req.DecidedAt.Format(time.RFC3339), req.DecidedBy)
+		}
+		if req.Comment != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), " - Comment: %s\n", req.Comment)
+		}
+		if req.CertSHA256 != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), " - Certificate: %s (SHA-256 %s)\n", store.CertPath(req.ID), req.CertSHA256)
+		}
+		csr, err := utils.ReadCSRFile(store.CSRPath(req.ID))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "CSR:")
+		for _, line := range utils.DescribeCSR(csr) {
+			fmt.Fprintln(cmd.OutOrStdout(), line)
+		}
+		for _, w := range utils.CSRWarnings(csr) {
+			fmt.Fprintf(cmd.OutOrStdout(), "Warning: %s\n", w)
+		}
+		return nil
+	},
+}
+
+// requests approve
+var requestsApproveCmd = &cobra.Command{
+	Use:   "approve <id>",
+	Short: "Approve a pending request and sign it with the CA shares.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := requestQueue(cmd)
+		req, err := store.Get(args[0])
+		if err != nil {
+			return err
+		}
+		if req.Status != queue.StatusPending {
+			return fmt.Errorf("request '%s' is already %s", req.ID, req.Status)
+		}
+		job := &csrJob{csrIn: store.CSRPath(req.ID), certOut: store.CertPath(req.ID)}
+		if err := job.load(cmd); err != nil {
+			return fmt.Errorf("request '%s': %w", req.ID, err)
+		}
+		if _, err := issueCSRJobs(cmd, []*csrJob{job}); err != nil {
+			return err
+		}
+
+		cert, err := parseCertPEM(job.certPEM)
+		if err != nil {
+			return err
+		}
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		approver, _ := cmd.Flags().GetString("approver")
+		comment, _ := cmd.Flags().GetString("comment")
+		if err := req.Decide(queue.StatusIssued, operatorName(approver), comment, now); err != nil {
+			return err
+		}
+		req.CertSHA256 = inventory.Fingerprint(cert)
+		if err := store.Save(req); err != nil {
+			return fmt.Errorf("certificate %s was issued, but %w", job.certOut, err)
+		}
+		if certOut, _ := cmd.Flags().GetString("cert-out"); certOut != "" {
+			if err := utils.WriteCertificateToFile(job.certPEM, certOut); err != nil {
+				return fmt.Errorf("failed to write certificate to '%s': %w", certOut, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Copy written to %s\n", certOut)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Request %s approved\n", req.ID)
+		return nil
+	},
+}
+
+// requests deny
+var requestsDenyCmd = &cobra.Command{
+	Use:   "deny <id>",
+	Short: "Deny a pending request with a comment for the requester.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		comment, _ := cmd.Flags().GetString("comment")
+		if comment == "" {
+			return invalid(errors.New("must specify --comment with the reason for the denial"))
+		}
+		store := requestQueue(cmd)
+		req, err := store.Get(args[0])
+		if err != nil {
+			return err
+		}
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		approver, _ := cmd.Flags().GetString("approver")
+		if err := req.Decide(queue.StatusDenied, operatorName(approver), comment, now); err != nil {
+			return err
+		}
+		if err := store.Save(req); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Request %s denied\n", req.ID)
+		return nil
+	},
+}
+
+// requestQueue opens the queue named by the --queue flag.
+func requestQueue(cmd *cobra.Command) *queue.Store {
+	dir, _ := cmd.Flags().GetString("queue")
+	return queue.Open(dir)
+}
+
+// operatorName returns name, or the login name of the current user if name is empty.
+func operatorName(name string) string {
+	if name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+func init() {
+	requestsCmd.PersistentFlags().String("queue", queue.DefaultDir, "Directory holding the request queue")
+
+	requestsSubmitCmd.Flags().String("csr-in", "", "File path to the PKCS#10 certificate request (PEM), or - for stdin")
+	requestsSubmitCmd.Flags().String("requester", "", "Who is asking for the certificate (default: current user)")
+	requestsSubmitCmd.Flags().String("note", "", "Free-text justification for the reviewer")
+
+	requestsListCmd.Flags().String("status", queue.StatusPending, "Requests to list: pending, issued, denied or all")
+
+	addCSRSigningFlags(requestsApproveCmd)
+	requestsApproveCmd.Flags().String("approver", "", "Who approves the request (default: current user)")
+	requestsApproveCmd.Flags().String("comment", "", "Comment recorded with the approval")
+	requestsApproveCmd.Flags().String("cert-out", "", "Also write the certificate to this file (it is always kept in the queue)")
+
+	requestsDenyCmd.Flags().String("approver", "", "Who denies the request (default: current user)")
+	requestsDenyCmd.Flags().String("comment", "", "Reason for the denial")
+
+	requestsCmd.AddCommand(requestsSubmitCmd)
+	requestsCmd.AddCommand(requestsListCmd)
+	requestsCmd.AddCommand(requestsShowCmd)
+	requestsCmd.AddCommand(requestsApproveCmd)
+	requestsCmd.AddCommand(requestsDenyCmd)
+	rootCmd.AddCommand(requestsCmd)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// reviewEncoding encodes challenges and reviewer codes: unambiguous when read aloud and case-insensitive.
+var reviewEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// reviewKeyUsages names the key usages shown in a review summary, in a fixed order.
+var reviewKeyUsages = []struct {
+	name  string
+	usage x509.KeyUsage
+}{
+	{"digitalSignature", x509.KeyUsageDigitalSignature},
+	{"keyEncipherment", x509.KeyUsageKeyEncipherment},
+	{"dataEncipherment", x509.KeyUsageDataEncipherment},
+	{"keyAgreement", x509.KeyUsageKeyAgreement},
+	{"cRLSign", x509.KeyUsageCRLSign},
+	{"encipherOnly", x509.KeyUsageEncipherOnly},
+	{"decipherOnly", x509.KeyUsageDecipherOnly},
+}
+
+// reviewSummary returns the canonical description of what is about to be signed: the CA, the validity,
+// the key usages and, for every request in a fixed order, the subject, SANs and key as they will be
+// certified. It does not depend on file names, so the reviewer may hold renamed copies of the CSRs.
+func reviewSummary(caCert *x509.Certificate, days int, ku x509.KeyUsage, jobs []*csrJob) []string {
+	var usages []string
+	for _, u := range reviewKeyUsages {
+		if ku&u.usage != 0 {
+			usages = append(usages, u.name)
+		}
+	}
+	lines := []string{
+		fmt.Sprintf("CA: %s (SHA-256 %s)", caCert.Subject, inventory.Fingerprint(caCert)),
+		fmt.Sprintf("Validity: %d days", days),
+		"Key usage: " + strings.Join(usages, ", "),
+	}
+
+	var requests [][]string
+	for _, job := range jobs {
+		spki := sha256.Sum256(job.csr.RawSubjectPublicKeyInfo)
+		sans := "none"
+		if !job.sans.Empty() {
+			sans = strings.Join(job.sans.Strings(), ", ")
+		}
+		requests = append(requests, []string{
+			"Subject: " + job.subject.String(),
+			"SANs: " + sans,
+			fmt.Sprintf("Public key: %s (SHA-256 %s)", utils.DescribePublicKey(job.csr.PublicKey), hex.EncodeToString(spki[:])),
+		})
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return strings.Join(requests[i], "\n") < strings.Join(requests[j], "\n")
+	})
+	for i, req := range requests {
+		lines = append(lines, fmt.Sprintf("Request %d of %d:", i+1, len(requests)))
+		for _, l := range req {
+			lines = append(lines, "  "+l)
+		}
+	}
+	return lines
+}
+
+// reviewCode returns the code a reviewer gives the operator: a digest binding the summary they
+// approved, the operator's challenge and the reviewer's name, as two groups of five characters.
+func reviewCode(challenge, reviewer string, summary []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "GoSeC review v1\n%s\n%s\n", normalizeReviewCode(challenge), reviewer)
+	for _, l := range summary {
+		io.WriteString(h, l+"\n")
+	}
+	code := reviewEncoding.EncodeToString(h.Sum(nil))[:10]
+	return code[:5] + "-" + code[5:]
+}
+
+// normalizeReviewCode upper-cases a typed code or challenge and drops separators.
+func normalizeReviewCode(s string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+}
+
+// requireReview shows the summary of jobs with a fresh challenge and waits for the reviewer's name
+// and code. It returns the reviewer once the code matches; the reviewer must not be the operator.
+func requireReview(out io.Writer, caCert *x509.Certificate, days int, ku x509.KeyUsage, jobs []*csrJob, operator string) (string, error) {
+	random := make([]byte, 5)
+	if _, err := io.ReadFull(utils.Rand, random); err != nil {
+		return "", fmt.Errorf("failed to generate review challenge: %w", err)
+	}
+	challenge := reviewEncoding.EncodeToString(random)
+	summary := reviewSummary(caCert, days, ku, jobs)
+
+	fmt.Fprintln(out, "Peer review required. Summary of the request:")
+	for _, l := range summary {
+		fmt.Fprintln(out, "  "+l)
+	}
+	var csrs []string
+	for _, job := range jobs {
+		csrs = append(csrs, "--csr-in "+job.csrIn)
+	}
+	fmt.Fprintf(out, "Challenge: %s\n", challenge)
+	fmt.Fprintf(out, "The reviewer checks the same request on another terminal and reads back the code:\n  gosec-cli review --ca-pem <CA PEM> %s --days %d [same subject, --san and usage flags] --challenge %s\n", strings.Join(csrs, " "), days, challenge)
+
+	reviewer, err := utils.ReadLine("Reviewer name: ")
+	if err != nil {
+		return "", err
+	}
+	if reviewer == "" {
+		return "", errors.New("no reviewer given; nothing was signed")
+	}
+	if strings.EqualFold(reviewer, operator) {
+		return "", fmt.Errorf("the reviewer must be someone other than the operator (%s)", operator)
+	}
+	code, err := utils.ReadLine("Reviewer code: ")
+	if err != nil {
+		return "", err
+	}
+	want := normalizeReviewCode(reviewCode(challenge, reviewer, summary))
+	if subtle.ConstantTimeCompare([]byte(normalizeReviewCode(code)), []byte(want)) != 1 {
+		return "", errors.New("reviewer code does not match: the reviewer saw a different request, challenge or name; nothing was signed")
+	}
+	fmt.Fprintf(out, "Reviewed by %s\n", reviewer)
+	return reviewer, nil
+}
+
+// recordReview stores the operator and reviewer of a peer-reviewed issuance in its inventory record.
+func recordReview(cmd *cobra.Command, certPEM []byte, operator, reviewer string) error {
+	cert, err := parseCertPEM(certPEM)
+	if err != nil {
+		return err
+	}
+	return updateInventory(cmd, func(db *inventory.DB) error {
+		rec := db.Certificate(inventory.Fingerprint(cert))
+		if rec == nil {
+			return fmt.Errorf("certificate %s is missing from the inventory", cert.Subject)
+		}
+		rec.Operator = operator
+		rec.Reviewer = reviewer
+		return nil
+	})
+}
+
+// reviewCmd is run by the second person of a peer-reviewed signing to check the request independently.
+var reviewCmd = &cobra.Command{
+	Use:   "review",
+	Short: "Check a signing request waiting for peer review and print the reviewer code that lets the operator sign it.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		challenge, _ := cmd.Flags().GetString("challenge")
+		if challenge == "" {
+			return invalid(errors.New("must specify --challenge as shown by the signing command"))
+		}
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the signing CA certificate"))
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+
+		csrIns, _ := cmd.Flags().GetStringArray("csr-in")
+		if csrDir, _ := cmd.Flags().GetString("csr-dir"); csrDir != "" {
+			entries, err := os.ReadDir(csrDir)
+			if err != nil {
+				return fmt.Errorf("unable to read CSR directory '%s': %w", csrDir, err)
+			}
+			for _, e := range entries {
+				if ext := filepath.Ext(e.Name()); !e.IsDir() && (ext == ".csr" || ext == ".pem" || ext == ".req") {
+					csrIns = append(csrIns, filepath.Join(csrDir, e.Name()))
+				}
+			}
+		}
+		if len(csrIns) == 0 {
+			return invalid(errors.New("must specify --csr-in (repeatable) or --csr-dir for the requests to review"))
+		}
+		if len(csrIns) > 1 && (subjectFlagsChanged(cmd) || cmd.Flags().Changed("san")) {
+			return errors.New("subject and --san overrides apply to a single request")
+		}
+		var jobs []*csrJob
+		for _, csrIn := range csrIns {
+			job := &csrJob{csrIn: csrIn}
+			if err := job.load(cmd); err != nil {
+				return fmt.Errorf("%s: %w", csrIn, err)
+			}
+			if err := job.csr.CheckSignature(); err != nil {
+				return fmt.Errorf("certificate request '%s' has an invalid signature: %w", csrIn, err)
+			}
+			jobs = append(jobs, job)
+		}
+
+		// Resolve the validity and names exactly as the signing command does
+		days, _ := cmd.Flags().GetInt("days")
+		days, err = validityDays(cmd, days)
+		if err != nil {
+			return err
+		}
+		days, settings, err := resolveProfile(cmd, caPem, caconfig.ProfileLeaf, days)
+		if err != nil {
+			return err
+		}
+		norm := settings.Normalization()
+		for _, job := range jobs {
+			job.subject = norm.Subject(job.subject)
+			job.sans = norm.SANs(job.sans)
+			for _, w := range utils.CSRWarnings(job.csr) {
+				fmt.Fprintf(cmd.OutOrStdout(), "Warning: %s: %s\n", job.csrIn, w)
+			}
+		}
+		ku := keyUsageFromFlags(cmd)
+		if ku == 0 {
+			ku = x509.KeyUsageDigitalSignature
+		}
+		summary := reviewSummary(caCert, days, ku, jobs)
+		fmt.Fprintln(cmd.OutOrStdout(), "Request to review:")
+		for _, l := range summary {
+			fmt.Fprintln(cmd.OutOrStdout(), "  "+l)
+		}
+
+		reviewerFlag, _ := cmd.Flags().GetString("reviewer")
+		reviewer := operatorName(reviewerFlag)
+		answer, err := utils.ReadLine(fmt.Sprintf("Approve as %s? Compare the summary with the operator's screen [y/N]: ", reviewer))
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(answer, "y") && !strings.EqualFold(answer, "yes") {
+			return errors.New("not approved; no code was issued")
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Reviewer: %s\nReviewer code: %s\n", reviewer, reviewCode(challenge, reviewer, summary))
+		return nil
+	},
+}
+
+func init() {
+	reviewCmd.Flags().String("ca-pem", "", "File path to the signing CA certificate (PEM)")
+	reviewCmd.Flags().StringArray("csr-in", nil, "File path to a certificate request being signed (PEM) (repeatable)")
+	reviewCmd.Flags().String("csr-dir", "", "Review every request (*.csr, *.pem, *.req) in this directory")
+	reviewCmd.Flags().String("challenge", "", "Challenge shown by the signing command")
+	reviewCmd.Flags().String("reviewer", "", "Reviewer name, entered by the operator with the code (default: current user)")
+	addSubjectFlags(reviewCmd)
+	reviewCmd.Flags().StringArray("san", nil, "Subject alternative names the operator substitutes for the CSR's (repeatable)")
+	addKeyUsageFlags(reviewCmd)
+	rootCmd.AddCommand(reviewCmd)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/audit"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// revokeCmd records the revocation of a certificate in the inventory, from which CRLs are built.
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke a certificate by serial number or file, recording the reason and time in the inventory.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serial, _ := cmd.Flags().GetString("serial")
+		certIn, _ := cmd.Flags().GetString("cert-in")
+		if (serial == "") == (certIn == "") {
+			return invalid(errors.New("must specify either --serial or --cert-in for the certificate to revoke"))
+		}
+		reasonStr, _ := cmd.Flags().GetString("reason")
+		reason, err := inventory.ParseReason(reasonStr)
+		if err != nil {
+			return err
+		}
+		var rec *inventory.CertRecord
+		var entry audit.Entry
+		var wasHeld bool
+		err = updateInventory(cmd, func(db *inventory.DB) error {
+			if rec, err = findCertRecord(cmd, db, serial, certIn); err != nil {
+				return err
+			}
+			if rec.IssuerSHA256 == rec.SHA256 {
+				return fmt.Errorf("%s is a self-signed root and cannot be revoked by a CRL; retire it with compromise or rollover", rec.Subject)
+			}
+			if rec.Status == inventory.StatusRevoked && (!rec.OnHold() || reason == inventory.ReasonCertificateHold) {
+				return nil
+			}
+			now, err := utils.Now()
+			if err != nil {
+				return err
+			}
+			wasHeld = rec.OnHold()
+			rec.Revoke(now, reason)
+			entry = certAuditEntry(db, audit.EventRevoke, rec)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if entry.Event == "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s (serial %s) was already revoked at %s (%s)\n", rec.Subject, rec.Serial, rec.RevokedAt.Format(time.RFC3339), inventory.ReasonName(rec.RevocationReason))
+			return nil
+		}
+		slog.Info("certificate revoked", "subject", rec.Subject, "serial", rec.Serial, "reason", inventory.ReasonName(reason))
+		entry.Params["reason"] = inventory.ReasonName(reason)
+		if err := recordAudit(cmd, entry); err != nil {
+			return err
+		}
+		switch {
+		case wasHeld:
+			fmt.Fprintf(cmd.OutOrStdout(), "Revoked %s (serial %s), which was on hold, at %s: %s\n", rec.Subject, rec.Serial, rec.RevokedAt.Format(time.RFC3339), inventory.ReasonName(reason))
+		case reason == inventory.ReasonCertificateHold:
+			fmt.Fprintf(cmd.OutOrStdout(), "Put %s (serial %s) on hold at %s; release it with unhold or revoke it with a final reason\n", rec.Subject, rec.Serial, rec.RevokedAt.Format(time.RFC3339))
+		default:
+			fmt.Fprintf(cmd.OutOrStdout(), "Revoked %s (serial %s) at %s: %s\n", rec.Subject, rec.Serial, rec.RevokedAt.Format(time.RFC3339), inventory.ReasonName(reason))
+		}
+		if rec.IsCA {
+			fmt.Fprintln(cmd.OutOrStdout(), "Warning: this is a CA certificate; everything it issued no longer validates once the revocation is published")
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Publish a new CRL from the issuing CA to distribute the revocation")
+		return nil
+	},
+}
+
+// unholdCmd reinstates a certificate suspended with the certificateHold reason.
+var unholdCmd = &cobra.Command{
+	Use:   "unhold",
+	Short: "Release a certificate on hold (revoked with certificateHold), so the next CRLs no longer list it.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serial, _ := cmd.Flags().GetString("serial")
+		certIn, _ := cmd.Flags().GetString("cert-in")
+		if (serial == "") == (certIn == "") {
+			return invalid(errors.New("must specify either --serial or --cert-in for the certificate to release"))
+		}
+		var rec *inventory.CertRecord
+		var entry audit.Entry
+		err := updateInventory(cmd, func(db *inventory.DB) error {
+			var err error
+			if rec, err = findCertRecord(cmd, db, serial, certIn); err != nil {
+				return err
+			}
+			if rec.Status == inventory.StatusRevoked && !rec.OnHold() {
+				return fmt.Errorf("%s (serial %s) was revoked for %s, which is final; only certificateHold can be released", rec.Subject, rec.Serial, inventory.ReasonName(rec.RevocationReason))
+			}
+			now, err := utils.Now()
+			if err != nil {
+				return err
+			}
+			if err := rec.Release(now); err != nil {
+				return err
+			}
+			entry = certAuditEntry(db, audit.EventUnhold, rec)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := recordAudit(cmd, entry); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Released %s (serial %s) from hold at %s\n", rec.Subject, rec.Serial, rec.ReleasedAt.Format(time.RFC3339))
+		fmt.Fprintln(cmd.OutOrStdout(), "Publish a new CRL from the issuing CA: a delta CRL lists it as removeFromCRL, a full CRL no longer lists it")
+		return nil
+	},
+}
+
+// findCertRecord returns the inventory record named by --serial (narrowed by --ca) or --cert-in.
+func findCertRecord(cmd *cobra.Command, db *inventory.DB, serial, certIn string) (*inventory.CertRecord, error) {
+	if certIn != "" {
+		cert, err := utils.ParseCertificateFromFile(certIn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate from '%s': %w", certIn, err)
+		}
+		rec := db.Certificate(inventory.Fingerprint(cert))
+		if rec == nil {
+			return nil, fmt.Errorf("'%s' (%s) is not in the inventory; only certificates issued here can be revoked", certIn, cert.Subject)
+		}
+		return rec, nil
+	}
+
+	recs, err := db.BySerial(serial)
+	if err != nil {
+		return nil, err
+	}
+	if ref, _ := cmd.Flags().GetString("ca"); ref != "" {
+		ca, err := db.FindCA(ref)
+		if err != nil {
+			return nil, err
+		}
+		var byCA []*inventory.CertRecord
+		for _, rec := range recs {
+			if rec.IssuerSHA256 == ca.SHA256 {
+				byCA = append(byCA, rec)
+			}
+		}
+		recs = byCA
+	}
+	switch len(recs) {
+	case 0:
+		return nil, fmt.Errorf("no certificate with serial %s in the inventory", serial)
+	case 1:
+		return recs[0], nil
+	}
+	var issuers []string
+	for _, rec := range recs {
+		issuers = append(issuers, rec.IssuerSHA256[:16])
+	}
+	return nil, fmt.Errorf("serial %s was issued by %d CAs (%s); select one with --ca", serial, len(recs), strings.Join(issuers, ", "))
+}
+
+func init() {
+	revokeCmd.Flags().String("serial", "", "Hex serial number of the certificate to revoke")
+	revokeCmd.Flags().String("cert-in", "", "File path to the certificate to revoke (PEM)")
+	revokeCmd.Flags().String("ca", "", "Issuing CA (name, fingerprint or certificate file) when the serial alone is ambiguous")
+	revokeCmd.Flags().String("reason", "unspecified", "Revocation reason: unspecified, keyCompromise, cACompromise, affiliationChanged, superseded, cessationOfOperation, certificateHold (suspend until unhold), privilegeWithdrawn or aACompromise")
+	rootCmd.AddCommand(revokeCmd)
+
+	unholdCmd.Flags().String("serial", "", "Hex serial number of the certificate to release")
+	unholdCmd.Flags().String("cert-in", "", "File path to the certificate to release (PEM)")
+	unholdCmd.Flags().String("ca", "", "Issuing CA (name, fingerprint or certificate file) when the serial alone is ambiguous")
+	rootCmd.AddCommand(unholdCmd)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// rolloverCmd replaces a root CA key, cross-certifying old and new keys so existing chains keep validating.
+var rolloverCmd = &cobra.Command{
+	Use:   "rollover",
+	Short: "Roll a root CA over to a new key, issuing new-with-old and old-with-new link certificates, and split the new key.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the current root CA certificate"))
+		}
+		oldCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+		if !oldCert.IsCA || !inventory.IsSelfSigned(oldCert) {
+			return fmt.Errorf("'%s' is not a self-signed root CA; replace a sub-CA with create-subca instead", caPem)
+		}
+
+		pemOut, _ := cmd.Flags().GetString("pem-out")
+		if pemOut == "" {
+			return invalid(errors.New("must specify --pem-out for the new root CA certificate"))
+		}
+		newWithOldOut, _ := cmd.Flags().GetString("new-with-old-out")
+		if newWithOldOut == "" {
+			newWithOldOut = trimExt(pemOut) + ".new-with-old.pem"
+		}
+		oldWithNewOut, _ := cmd.Flags().GetString("old-with-new-out")
+		if oldWithNewOut == "" {
+			oldWithNewOut = trimExt(pemOut) + ".old-with-new.pem"
+		}
+
+		n, _ := cmd.Flags().GetInt("n")
+		t, _ := cmd.Flags().GetInt("t")
+		sharesOutStr, _ := cmd.Flags().GetString("shares-out")
+		sharePaths := utils.ParseCommaSeparatedPaths(sharesOutStr)
+		if len(sharePaths) == 0 {
+			return invalid(errors.New("must specify --shares-out for storing the new key shares"))
+		}
+		if n != len(sharePaths) {
+			return fmt.Errorf("number of share files (%d) does not match n=%d", len(sharePaths), n)
+		}
+		custodians, err := custodiansFromFlags(cmd, n)
+		if err != nil {
+			return err
+		}
+		protection, err := shareProtection(cmd, sharePaths, custodians)
+		if err != nil {
+			return err
+		}
+		passphrases, err := sharePassphrases(cmd, sharePaths, custodians)
+		if err != nil {
+			return err
+		}
+
+		days, _ := cmd.Flags().GetInt("days")
+		if days <= 0 {
+			days = utils.ValidityDays(oldCert)
+		}
+		now, err := utils.Now()
+		if err != nil {
+			return err
+		}
+		// Link certificates cannot outlive the old root: clients trusting it stop at its expiry anyway
+		oldNotAfter := oldCert.NotAfter
+		if !oldNotAfter.After(now) {
+			return fmt.Errorf("root CA '%s' expired on %s; create a new root with create-root instead", caPem, oldNotAfter.Format(time.RFC3339))
+		}
+		linkNotAfter := now.Add(time.Duration(days) * 24 * time.Hour)
+		if linkNotAfter.After(oldNotAfter) {
+			linkNotAfter = oldNotAfter
+		}
+
+		sharesInStr, _ := cmd.Flags().GetString("shares-in")
+		caKeyPath, _ := cmd.Flags().GetString("ca-key")
+		oldKey, err := loadCAKey(cmd, caPem, sharesInStr, caKeyPath, "--shares-in", "--ca-key")
+		if err != nil {
+			return fmt.Errorf("failed to load CA private key: %w", err)
+		}
+		if pub, ok := oldKey.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(oldCert.PublicKey) {
+			return fmt.Errorf("the CA private key does not match the certificate '%s'", caPem)
+		}
+		newKey, err := utils.GenerateECKey()
+		if err != nil {
+			return err
+		}
+		wipeOnExit(newKey)
+
+		// New root: same name and extensions as the old one, new key, self-signed
+		newPEM, err := utils.SelfSignFromTemplate(utils.TemplateFromCertificate(oldCert), newKey, days,
+			utils.WithValidity(now, now.Add(time.Duration(days)*24*time.Hour)))
+		if err != nil {
+			return fmt.Errorf("failed to generate new root CA: %w", err)
+		}
+		newCert, err := parseCertPEM(newPEM)
+		if err != nil {
+			return err
+		}
+		// Link certificates are self-issued (same name on both sides), so Go would omit the authority
+		// key identifier that path builders need to tell them apart from self-signed roots
+		// New-with-old: lets clients that only trust the old root validate chains under the new key
+		newWithOld := utils.TemplateFromCertificate(newCert)
+		newWithOld.AuthorityKeyId = oldCert.SubjectKeyId
+		newWithOldPEM, err := utils.IssueFromTemplate(newWithOld, &newKey.PublicKey, oldCert, oldKey, days,
+			utils.WithValidity(now, linkNotAfter))
+		if err != nil {
+			return withExitCode(exitSigning, fmt.Errorf("failed to issue new-with-old link certificate: %w", err))
+		}
+		// Old-with-new: lets clients that only trust the new root validate certificates issued under the old key
+		oldWithNew := utils.TemplateFromCertificate(oldCert)
+		oldWithNew.AuthorityKeyId = newCert.SubjectKeyId
+		oldWithNewPEM, err := utils.IssueFromTemplate(oldWithNew, oldCert.PublicKey, newCert, newKey, days,
+			utils.WithValidity(now, oldNotAfter))
+		if err != nil {
+			return withExitCode(exitSigning, fmt.Errorf("failed to issue old-with-new link certificate: %w", err))
+		}
+
+		// Each certificate goes into the log of the key that signed it
+		if err := logIssuance(cmd, pemOut, newPEM, newKey); err != nil {
+			return err
+		}
+		if err := utils.WriteCertificateToFile(newPEM, pemOut); err != nil {
+			return fmt.Errorf("failed to write root CA cert to '%s': %w", pemOut, err)
+		}
+		if err := logIssuance(cmd, pemOut, oldWithNewPEM, newKey); err != nil {
+			return err
+		}
+		if err := logIssuance(cmd, caPem, newWithOldPEM, oldKey); err != nil {
+			return err
+		}
+		for path, certPEM := range map[string][]byte{newWithOldOut: newWithOldPEM, oldWithNewOut: oldWithNewPEM} {
+			if err := utils.WriteCertificateToFile(certPEM, path); err != nil {
+				return fmt.Errorf("failed to write link certificate to '%s': %w", path, err)
+			}
+		}
+
+		// The new root keeps the old root's profiles and policy
+		cfg, err := caconfig.LoadForCA(caPem)
+		if err != nil {
+			return err
+		}
+		if len(cfg.AllowedProfiles) > 0 || len(cfg.Profiles) > 0 {
+			if err := cfg.Save(caconfig.PathForCA(pemOut)); err != nil {
+				return err
+			}
+		}
+
+		if err := utils.SplitKeyAndWriteShares(newKey, n, t, sharePaths, custodians, passphrases, splitOptions(cmd, pemOut, protection)...); err != nil {
+			return fmt.Errorf("failed to split new root key: %w", err)
+		}
+		if err := recordCustody(cmd, pemOut, sharePaths); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Root CA '%s' rolled over to a new key!\n", oldCert.Subject)
+		fmt.Fprintf(cmd.OutOrStdout(), " - New root certificate: %s (SHA-256 %s)\n", pemOut, inventory.Fingerprint(newCert))
+		fmt.Fprintf(cmd.OutOrStdout(), " - New-with-old link: %s (valid until %s)\n", newWithOldOut, linkNotAfter.Format(time.RFC3339))
+		fmt.Fprintf(cmd.OutOrStdout(), " - Old-with-new link: %s (valid until %s)\n", oldWithNewOut, oldNotAfter.Format(time.RFC3339))
+		fmt.Fprintf(cmd.OutOrStdout(), " - %d shares of the new key written.\n", n)
+		if err := printCommitments(cmd, pemOut); err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Distribute the new root and both link certificates; keep the old shares until the old root expires.")
+		return nil
+	},
+}
+
+func init() {
+	rolloverCmd.Flags().String("ca-pem", "", "File path to the current root CA certificate (PEM)")
+	rolloverCmd.Flags().String("shares-in", "", "Comma-separated list of share files for the current root's private key")
+	rolloverCmd.Flags().String("ca-key", "", "File path to the current root private key instead of shares")
+	rolloverCmd.Flags().String("pem-out", "", "File path for the new root CA certificate (PEM)")
+	rolloverCmd.Flags().String("new-with-old-out", "", "File path for the new key certified by the old root (default: <pem-out without extension>.new-with-old.pem)")
+	rolloverCmd.Flags().String("old-with-new-out", "", "File path for the old key certified by the new root (default: <pem-out without extension>.old-with-new.pem)")
+	rolloverCmd.Flags().Int("days", 0, "Validity period (in days) of the new root (default: same as the current root)")
+	rolloverCmd.Flags().Int("n", 3, "Number of total key shares for the new key")
+	rolloverCmd.Flags().Int("t", 2, "Threshold (quorum) number of shares required to recover the new key")
+	rolloverCmd.Flags().String("shares-out", "", "Comma-separated list of file paths for the new key shares (must match n)")
+	rolloverCmd.Flags().String("custodians", "", "Comma-separated custodian labels, one per share in --shares-out order (optional)")
+	rolloverCmd.Flags().String("contacts", "", "Comma-separated custodian contact details, one per share (optional)")
+	rolloverCmd.Flags().Bool("vss", false, "Split the new key with Feldman verifiable secret sharing and publish the commitments next to --pem-out")
+	rolloverCmd.Flags().Bool("envelope", false, "Encrypt the new key under a random key-encryption key written next to --pem-out and split only that key")
+	rolloverCmd.Flags().Bool("encrypt-shares", false, "Encrypt each share of the new key with its custodian's passphrase (Argon2id)")
+	rolloverCmd.Flags().String("recipients", "", "Comma-separated age recipients (age1...) or files holding one, in --shares-out order, to encrypt each share of the new key to its custodian's key")
+	rolloverCmd.Flags().Bool("fido2", false, "Seal each share of the new key to its custodian's FIDO2 token (hmac-secret); each token is enrolled in turn and needed with its PIN to combine")
+	rootCmd.AddCommand(rolloverCmd)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"my-pki/internal/inventory"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// searchResult is one certificate in the output of search.
+type searchResult struct {
+	inventory.CertRecord
+	Issuer string   `json:"issuer"`
+	Names  []string `json:"names"`
+}
+
+// searchCmd answers ad-hoc questions about the certificate estate from the inventory.
+var searchCmd = &cobra.Command{
+	Use:   "search",
+	Short: "Search the inventory for certificates by SAN, subject, issuer, status or expiry and print them as JSON.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := openInventory(cmd)
+		if err != nil {
+			return err
+		}
+
+		var q inventory.Query
+		if err := nameQuery(cmd, &q); err != nil {
+			return err
+		}
+		q.Subject, _ = cmd.Flags().GetString("subject")
+		q.Status, _ = cmd.Flags().GetString("status")
+		if q.Status != "" && q.Status != inventory.StatusValid && q.Status != inventory.StatusRevoked {
+			return invalid(fmt.Errorf("invalid --status '%s' (expected %s or %s)", q.Status, inventory.StatusValid, inventory.StatusRevoked))
+		}
+		if issuer, _ := cmd.Flags().GetString("issuer"); issuer != "" {
+			ca, err := db.FindCA(issuer)
+			if err != nil {
+				return err
+			}
+			q.IssuerSHA256 = ca.SHA256
+		}
+		if q.ExpiresBefore, err = searchTime(cmd, "expires-before"); err != nil {
+			return err
+		}
+		if q.ExpiresAfter, err = searchTime(cmd, "expires-after"); err != nil {
+			return err
+		}
+		if cmd.Flags().Changed("ca") {
+			isCA, _ := cmd.Flags().GetBool("ca")
+			q.CA = &isCA
+		}
+
+		recs, err := db.Search(q)
+		if err != nil {
+			return err
+		}
+		withPEM, _ := cmd.Flags().GetBool("pem")
+		caNames := map[string]string{}
+		for _, ca := range db.CAs {
+			caNames[ca.SHA256] = ca.Name
+		}
+		results := make([]searchResult, 0, len(recs))
+		for _, rec := range recs {
+			r := searchResult{CertRecord: *rec, Issuer: caNames[rec.IssuerSHA256]}
+			if cert, err := rec.Certificate(); err == nil {
+				r.Names = inventory.Names(cert)
+			}
+			if !withPEM {
+				r.PEM = ""
+			}
+			results = append(results, r)
+		}
+
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	},
+}
+
+// nameQuery fills q from the flags added by addNameFlags.
+func nameQuery(cmd *cobra.Command, q *inventory.Query) (err error) {
+	q.SAN, _ = cmd.Flags().GetString("san")
+	q.CN, _ = cmd.Flags().GetString("cn")
+	if q.IssuedBefore, err = searchTime(cmd, "issued-before"); err != nil {
+		return err
+	}
+	q.IssuedAfter, err = searchTime(cmd, "issued-after")
+	return err
+}
+
+// addNameFlags adds the flags that find the certificates of a host: by CN, SAN or IP range, and by issuance date.
+func addNameFlags(cmd *cobra.Command) {
+	cmd.Flags().String("san", "", "Glob matched against the subject CN and every SAN, e.g. '*.db.internal', or a CIDR range matched against the IP SANs, e.g. 10.0.0.0/8")
+	cmd.Flags().String("cn", "", "Glob matched against the subject CN, e.g. '*.internal'")
+	cmd.Flags().String("issued-before", "", "Only certificates valid from before this date (YYYY-MM-DD or RFC 3339)")
+	cmd.Flags().String("issued-after", "", "Only certificates valid from after this date (YYYY-MM-DD or RFC 3339)")
+}
+
+// searchTime parses a date (YYYY-MM-DD, midnight UTC) or RFC 3339 time flag; an unset flag yields the zero time.
+func searchTime(cmd *cobra.Command, name string) (time.Time, error) {
+	s, _ := cmd.Flags().GetString(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, invalid(fmt.Errorf("invalid --%s '%s' (expected YYYY-MM-DD or RFC 3339)", name, s))
+	}
+	return t, nil
+}
+
+func init() {
+	addNameFlags(searchCmd)
+	searchCmd.Flags().String("subject", "", "Glob matched against the full subject DN, e.g. '*O=Example*'")
+	searchCmd.Flags().String("issuer", "", "Issuing CA: name, SHA-256 fingerprint (prefix) or PEM path")
+	searchCmd.Flags().String("status", "", "Only certificates with this status: valid or revoked")
+	searchCmd.Flags().String("expires-before", "", "Only certificates expiring before this date (YYYY-MM-DD or RFC 3339)")
+	searchCmd.Flags().String("expires-after", "", "Only certificates expiring after this date (YYYY-MM-DD or RFC 3339)")
+	searchCmd.Flags().Bool("ca", false, "Only CA certificates (--ca=false: only end-entity certificates)")
+	searchCmd.Flags().Bool("pem", false, "Include the certificate PEM in the output")
+	rootCmd.AddCommand(searchCmd)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"my-pki/internal/ctlog"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// selftestCmd runs a miniature ceremony in a scratch directory to validate the installation.
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Exercise key generation, share split/combine, signing and verification in a temporary directory.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keep, _ := cmd.Flags().GetBool("keep")
+		dir, err := os.MkdirTemp("", "gosec-selftest-")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		if keep {
+			fmt.Fprintf(cmd.OutOrStdout(), "Working directory: %s\n", dir)
+		} else {
+			defer os.RemoveAll(dir)
+		}
+
+		st := &selftest{dir: dir}
+		steps := []struct {
+			name string
+			run  func() error
+		}{
+			{"generate root CA", st.generateRoot},
+			{"split and combine shares", st.splitAndCombine},
+			{"encrypt and decrypt private key", st.encryptDecrypt},
+			{"sign sub CA and leaf", st.signChain},
+			{"verify certificate chain", st.verifyChain},
+			{"issuance log append and verify", st.issuanceLog},
+			{"time token sign and verify", st.timeToken},
+		}
+
+		failed := 0
+		for _, step := range steps {
+			if failed > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "SKIP  %s\n", step.name)
+				continue
+			}
+			if err := step.run(); err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "FAIL  %s: %v\n", step.name, err)
+				failed++
+				continue
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "PASS  %s\n", step.name)
+		}
+		if failed > 0 {
+			return errors.New("self-test failed; do not use this installation for a ceremony")
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Self-test passed.")
+		return nil
+	},
+}
+
+// selftest carries the artefacts produced by one step into the next.
+type selftest struct {
+	dir      string
+	rootPEM  []byte
+	rootKey  *ecdsa.PrivateKey
+	rootCert *x509.Certificate
+	subPEM   []byte
+	subKey   *ecdsa.PrivateKey
+	leafPEM  []byte
+}
+
+func (st *selftest) path(name string) string { return filepath.Join(st.dir, name) }
+
+func (st *selftest) generateRoot() error {
+	var err error
+	st.rootPEM, st.rootKey, err = utils.GenerateKeyAndCert(pkix.Name{CommonName: "GoSeC Self-Test Root"}, nil, nil, true, 1, x509.KeyUsageCRLSign)
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteCertificateToFile(st.rootPEM, st.path("root.pem")); err != nil {
+		return err
+	}
+	st.rootCert, err = utils.ParseCertificateFromFile(st.path("root.pem"))
+	if err != nil {
+		return err
+	}
+	return st.rootCert.CheckSignatureFrom(st.rootCert)
+}
+
+func (st *selftest) splitAndCombine() error {
+	paths := []string{st.path("share1"), st.path("share2"), st.path("share3")}
+	custodians := []utils.Custodian{{Label: "A"}, {Label: "B"}, {Label: "C"}}
+	if err := utils.SplitKeyAndWriteShares(st.rootKey, 3, 2, paths, custodians, nil); err != nil {
+		return err
+	}
+	// Every quorum must reconstruct the key; a single share must not.
+	for _, subset := range [][]string{{paths[0], paths[1]}, {paths[1], paths[2]}, {paths[2], paths[0]}} {
+		keyBytes, err := utils.CombineSharesFromFiles(subset)
+		if err != nil {
+			return err
+		}
+		key, err := utils.ParsePrivateKeyDER(keyBytes)
+		if err != nil {
+			return err
+		}
+		if !key.Equal(st.rootKey) {
+			return fmt.Errorf("shares %v reconstructed a different key", subset)
+		}
+	}
+	if _, err := utils.CombineSharesFromFiles(paths[:1]); err == nil {
+		return errors.New("a single share below the threshold was accepted")
+	}
+	return nil
+}
+
+func (st *selftest) encryptDecrypt() error {
+	pass := []byte("selftest-passphrase")
+	if err := utils.WriteEncryptedPrivateKeyToFile(st.rootKey, st.path("root.key"), pass); err != nil {
+		return err
+	}
+	key, err := utils.LoadPrivateKeyFromFile(st.path("root.key"), func() ([]byte, error) { return pass, nil })
+	if err != nil {
+		return err
+	}
+	if !key.Equal(st.rootKey) {
+		return errors.New("decrypted key does not match")
+	}
+	_, err = utils.LoadPrivateKeyFromFile(st.path("root.key"), func() ([]byte, error) { return []byte("wrong"), nil })
+	if !errors.Is(err, utils.ErrIncorrectPassphrase) {
+		return fmt.Errorf("wrong passphrase not rejected (got %v)", err)
+	}
+	return nil
+}
+
+func (st *selftest) signChain() error {
+	var err error
+	st.subPEM, st.subKey, err = utils.GenerateKeyAndCert(pkix.Name{CommonName: "GoSeC Self-Test Sub CA"}, st.rootCert, st.rootKey, true, 1, x509.KeyUsageCRLSign)
+	if err != nil {
+		return err
+	}
+	subCert, err := parseCertPEM(st.subPEM)
+	if err != nil {
+		return err
+	}
+	st.leafPEM, _, err = utils.GenerateKeyAndCert(pkix.Name{CommonName: "selftest.invalid"}, subCert, st.subKey, false, 1, x509.KeyUsageDigitalSignature)
+	return err
+}
+
+func (st *selftest) verifyChain() error {
+	subCert, err := parseCertPEM(st.subPEM)
+	if err != nil {
+		return err
+	}
+	leafCert, err := parseCertPEM(st.leafPEM)
+	if err != nil {
+		return err
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(st.rootCert)
+	intermediates.AddCert(subCert)
+	_, err = leafCert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   leafCert.NotBefore.Add(time.Minute),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+func (st *selftest) issuanceLog() error {
+	logPath := ctlog.PathForCA(st.path("root.pem"))
+	now := time.Now()
+	for _, certPEM := range [][]byte{st.rootPEM, st.subPEM} {
+		if err := ctlog.AppendCertificatePEM(logPath, certPEM, st.rootKey, now); err != nil {
+			return err
+		}
+	}
+	l, err := ctlog.Open(logPath)
+	if err != nil {
+		return err
+	}
+	if err := l.Verify(st.rootCert); err != nil {
+		return err
+	}
+	sth, proof, err := l.InclusionProof(1)
+	if err != nil {
+		return err
+	}
+	if !ctlog.VerifyInclusion(ctlog.LeafHash(l.Entries()[1].Cert), 1, sth.TreeSize, proof, sth.RootHash) {
+		return errors.New("inclusion proof did not verify")
+	}
+	return nil
+}
+
+func (st *selftest) timeToken() error {
+	now := time.Now().Truncate(time.Second)
+	token, err := utils.CreateTimeToken(now, st.rootKey)
+	if err != nil {
+		return err
+	}
+	t, err := utils.VerifyTimeToken(token, st.rootCert)
+	if err != nil {
+		return err
+	}
+	if !t.Equal(now) {
+		return fmt.Errorf("token time %s does not match %s", t, now)
+	}
+	tampered := bytes.Replace(token, []byte(now.UTC().Format(time.RFC3339)), []byte(now.Add(time.Hour).UTC().Format(time.RFC3339)), 1)
+	if _, err := utils.VerifyTimeToken(tampered, st.rootCert); err == nil {
+		return errors.New("tampered time token was accepted")
+	}
+	return nil
+}
+
+// parseCertPEM decodes a single PEM certificate held in memory.
+func parseCertPEM(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("failed to decode PEM block containing certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func init() {
+	selftestCmd.Flags().Bool("keep", false, "Keep the temporary directory for inspection")
+	rootCmd.AddCommand(selftestCmd)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-pki/internal/dist"
+	"my-pki/internal/utils"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// serveDistCmd publishes CA certificates and CRLs over HTTP, where AIA and CDP URLs point.
+var serveDistCmd = &cobra.Command{
+	Use:         "serve-dist",
+	Short:       "Serve CA certificates, chains and the latest CRLs over HTTP at the AIA and CRL distribution point URLs.",
+	Annotations: map[string]string{annotationServer: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caPems, _ := cmd.Flags().GetStringArray("ca-pem")
+		if len(caPems) == 0 && caHome != "" {
+			var err error
+			if caPems, err = caHome.CAs(); err != nil {
+				return err
+			}
+		}
+		if len(caPems) == 0 {
+			return invalid(errors.New("must specify --ca-pem for each CA to publish (repeatable)"))
+		}
+		var cas []dist.CA
+		for _, caPem := range caPems {
+			// NAME=PATH publishes a CA under another name than its file's
+			name, path, renamed := strings.Cut(caPem, "=")
+			if !renamed {
+				path = caPem
+			}
+			if _, err := utils.ParseCertificateFromFile(path); err != nil {
+				return fmt.Errorf("failed to parse CA certificate from '%s': %w", path, err)
+			}
+			ca := dist.NewCA(path)
+			if renamed {
+				ca.Name = name
+			}
+			cas = append(cas, ca)
+		}
+		srv, err := dist.NewServer(cas)
+		if err != nil {
+			return err
+		}
+
+		listen, _ := cmd.Flags().GetString("listen")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		var handler http.Handler = srv
+		if !quiet {
+			handler = accessLog(srv)
+		}
+		server := &http.Server{Addr: listen, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		errCh := make(chan error, 1)
+		go func() { errCh <- server.ListenAndServe() }()
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Serving %d CA(s) on %s:\n", len(cas), listen)
+		for _, p := range srv.Paths() {
+			fmt.Fprintf(cmd.OutOrStdout(), " - %s\n", p)
+		}
+		select {
+		case err := <-errCh:
+			return fmt.Errorf("failed to serve on %s: %w", listen, err)
+		case <-ctx.Done():
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		fmt.Fprintln(cmd.OutOrStdout(), "Stopped")
+		return server.Shutdown(shutdownCtx)
+	},
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes on, which the gRPC service needs to send its responses.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// accessLog logs one event per request.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		slog.Info("request", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path, "status", rec.status)
+	})
+}
+
+func init() {
+	serveDistCmd.Flags().StringArray("ca-pem", nil, "CA certificate (PEM) to publish, optionally as NAME=PATH to publish under NAME (repeatable); its CRLs are read from <path without extension>.crl and -delta.crl (default with --ca-dir: every CA in it)")
+	serveDistCmd.Flags().String("listen", ":8080", "Address to listen on")
+	rootCmd.AddCommand(serveDistCmd)
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"my-pki/internal/age"
+	"my-pki/internal/audit"
+	"my-pki/internal/fido2"
+	"my-pki/internal/inventory"
+	"my-pki/internal/mnemonic"
+	"my-pki/internal/secmem"
+	"my-pki/internal/utils"
+	"my-pki/internal/vss"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// verifySharesCmd lets custodians audit their shares without the key ever leaving memory.
+var verifySharesCmd = &cobra.Command{
+	Use:   "verify-shares",
+	Short: "Check that a quorum of shares reconstructs the key of a CA certificate, without writing the key anywhere; VSS shares can also be checked one by one.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the CA certificate"))
+		}
+		sharesInStr, _ := cmd.Flags().GetString("shares-in")
+		sharePaths := utils.ParseCommaSeparatedPaths(sharesInStr)
+		if len(sharePaths) == 0 {
+			return invalid(errors.New("must specify --shares-in with the share files to verify"))
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+		caKeyID, err := utils.PublicKeyID(caCert.PublicKey)
+		if err != nil {
+			return err
+		}
+
+		shares, err := utils.ReadShareFiles(sharePaths)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			for _, s := range shares {
+				clear(s.Data)
+			}
+		}()
+		// Metadata alone already tells whether a share was split from another key
+		for _, s := range shares {
+			if s.HasMetadata() && s.KeyID != caKeyID {
+				return fmt.Errorf("share '%s' belongs to key %s, not to the key %s of %s", s.Path, s.KeyID, caKeyID, caCert.Subject)
+			}
+		}
+		if desc := utils.DescribeShares(shares); desc != "" {
+			fmt.Fprintln(progress, desc)
+		}
+		fmt.Fprintln(progress, "Quorum:", utils.DescribeQuorum(shares))
+		if err := utils.UnlockShares(shares, utils.PromptSharePassphrase); err != nil {
+			return err
+		}
+
+		// VSS shares are checked individually, which is all a single custodian can do
+		verifiable := 0
+		for _, s := range shares {
+			if s.Commitments == nil {
+				continue
+			}
+			if err := verifyCommitments(cmd, caPem, caCert, s); err != nil {
+				return err
+			}
+			if err := s.Commitments.Verify(s.Index, s.Data); err != nil {
+				return fmt.Errorf("share '%s' (#%d %s) fails VSS verification: %w", s.Path, s.Index, s.Custodian(), err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Share #%d (%s) in '%s' is consistent with the commitments (fingerprint %s)\n", s.Index, s.Custodian(), s.Path, s.Commitments.Fingerprint())
+			verifiable++
+		}
+		if provided, threshold := utils.QuorumStatus(shares); verifiable == len(shares) && provided < threshold {
+			fmt.Fprintf(cmd.OutOrStdout(), "Shares verified: %d VSS share(s) of the key of %s; provide %d to also check the reconstruction\n", verifiable, caCert.Subject, threshold)
+			return nil
+		}
+
+		keyBytes, err := utils.CombineShares(shares)
+		if err != nil {
+			return withExitCode(exitCombine, fmt.Errorf("failed to combine shares: %w", err))
+		}
+		defer clear(keyBytes)
+		key, err := utils.ParsePrivateKeyDER(keyBytes)
+		if err != nil {
+			return withExitCode(exitCombine, fmt.Errorf("the shares do not reconstruct a valid key: %w", err))
+		}
+		defer secmem.WipeKey(key)
+		if !key.PublicKey.Equal(caCert.PublicKey) {
+			return withExitCode(exitCombine, fmt.Errorf("the shares reconstruct a key that does not match %s", caCert.Subject))
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Shares verified: %d share(s) reconstruct the key of %s (key %s)\n", len(shares), caCert.Subject, caKeyID)
+		return nil
+	},
+}
+
+// verifyCommitments checks that the commitments in a VSS share commit to the CA's key and match the
+// published ones, so a dealer cannot hand custodians commitments to different polynomials.
+func verifyCommitments(cmd *cobra.Command, caPem string, caCert *x509.Certificate, s *utils.Share) error {
+	pub, ok := caCert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || !s.Commitments.Matches(pub) {
+		return fmt.Errorf("the VSS commitments in '%s' do not commit to the key of %s", s.Path, caCert.Subject)
+	}
+	path, _ := cmd.Flags().GetString("commitments")
+	if path == "" {
+		path = vss.PathForCA(caPem)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			slog.Warn("no published commitments; compare the fingerprint with the other custodians", "path", path, "fingerprint", s.Commitments.Fingerprint())
+			return nil
+		}
+	}
+	published, splitID, err := vss.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if splitID != s.SplitID || published.Encode() != s.Commitments.Encode() {
+		return fmt.Errorf("the VSS commitments in '%s' differ from those published in '%s'", s.Path, path)
+	}
+	return nil
+}
+
+// splitOptions returns the share split options selected by --vss and --envelope for the CA
+// certificate at caPem, followed by the share protection options.
+func splitOptions(cmd *cobra.Command, caPem string, protection []utils.SplitOption) []utils.SplitOption {
+	var opts []utils.SplitOption
+	if verifiable, _ := cmd.Flags().GetBool("vss"); verifiable {
+		opts = append(opts, utils.WithVerifiableShares(vss.PathForCA(caPem)))
+	}
+	if envelope, _ := cmd.Flags().GetBool("envelope"); envelope {
+		opts = append(opts, utils.WithEnvelope(utils.WrappedKeyPathForCA(caPem)))
+	}
+	return append(opts, protection...)
+}
+
+// shareProtection returns the split options that encrypt the shares to their custodians' age keys
+// (--recipients) or FIDO2 tokens (--fido2), enrolling each token in turn.
+func shareProtection(cmd *cobra.Command, sharePaths []string, custodians []utils.Custodian) ([]utils.SplitOption, error) {
+	recipientsStr, _ := cmd.Flags().GetString("recipients")
+	encrypt, _ := cmd.Flags().GetBool("encrypt-shares")
+	tokens, _ := cmd.Flags().GetBool("fido2")
+	modes := 0
+	for _, set := range []bool{recipientsStr != "", encrypt, tokens} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return nil, errors.New("--encrypt-shares, --recipients and --fido2 are mutually exclusive")
+	}
+	verifiable, _ := cmd.Flags().GetBool("vss")
+	if envelope, _ := cmd.Flags().GetBool("envelope"); envelope && verifiable {
+		return nil, errors.New("--vss and --envelope are mutually exclusive: VSS shares the key itself")
+	}
+	switch {
+	case recipientsStr != "":
+		recipients, err := shareRecipients(recipientsStr, len(sharePaths))
+		if err != nil {
+			return nil, err
+		}
+		return []utils.SplitOption{utils.WithRecipients(recipients)}, nil
+	case tokens:
+		seals := make([]*utils.TokenSeal, len(sharePaths))
+		for i, path := range sharePaths {
+			if _, err := utils.ReadLine(fmt.Sprintf("Insert the FIDO2 token of %s for share #%d ('%s'), press Enter, then touch it twice: ", custodians[i], i+1, path)); err != nil {
+				return nil, err
+			}
+			user := custodians[i].Label
+			if user == "" {
+				user = fmt.Sprintf("share-%d", i+1)
+			}
+			seal, err := utils.EnrollToken(&fido2.Token{}, user)
+			if err != nil {
+				return nil, fmt.Errorf("failed to enroll the token for share #%d: %w", i+1, err)
+			}
+			seals[i] = seal
+		}
+		return []utils.SplitOption{utils.WithTokenSeals(seals)}, nil
+	}
+	return nil, nil
+}
+
+// shareRecipients parses --recipients, one age recipient (or a file holding it) per share.
+func shareRecipients(recipientsStr string, n int) ([]*age.Recipient, error) {
+	entries := utils.ParseCommaSeparatedPaths(recipientsStr)
+	if len(entries) != n {
+		return nil, fmt.Errorf("number of recipients (%d) does not match n=%d", len(entries), n)
+	}
+	recipients := make([]*age.Recipient, n)
+	for i, entry := range entries {
+		if !strings.HasPrefix(entry, "age1") {
+			data, err := os.ReadFile(entry)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read recipient file: %w", err)
+			}
+			entry = ""
+			for _, line := range strings.Split(string(data), "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					entry = line
+					break
+				}
+			}
+		}
+		r, err := age.ParseRecipient(entry)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %d: %w", i+1, err)
+		}
+		recipients[i] = r
+	}
+	return recipients, nil
+}
+
+// printCommitments reports where the commitments of a VSS split were published, for custodians to
+// compare, or where the wrapped key of an envelope split was written.
+func printCommitments(cmd *cobra.Command, caPem string) error {
+	if envelope, _ := cmd.Flags().GetBool("envelope"); envelope {
+		fmt.Fprintf(cmd.OutOrStdout(), " - Wrapped key: %s (encrypted under the key the shares protect; back it up with the certificate)\n", utils.WrappedKeyPathForCA(caPem))
+	}
+	if verifiable, _ := cmd.Flags().GetBool("vss"); !verifiable {
+		return nil
+	}
+	path := vss.PathForCA(caPem)
+	c, _, err := vss.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), " - VSS commitments: %s (fingerprint %s); each custodian can check their share with verify-shares.\n", path, c.Fingerprint())
+	return nil
+}
+
+// reshareCmd issues a fresh set of shares for an existing CA key, e.g. when a custodian leaves.
+var reshareCmd = &cobra.Command{
+	Use:   "reshare",
+	Short: "Combine a quorum of a CA's shares and split the same key into a brand-new set of shares, optionally with a new share count and threshold.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the CA certificate"))
+		}
+		sharesInStr, _ := cmd.Flags().GetString("shares-in")
+		sharesIn := utils.ParseCommaSeparatedPaths(sharesInStr)
+		if len(sharesIn) == 0 {
+			return invalid(errors.New("must specify --shares-in with a quorum of the current shares"))
+		}
+		sharesOutStr, _ := cmd.Flags().GetString("shares-out")
+		sharesOut := utils.ParseCommaSeparatedPaths(sharesOutStr)
+		if len(sharesOut) == 0 {
+			return invalid(errors.New("must specify --shares-out for the new shares"))
+		}
+		// A failure halfway through must not leave the custodians with neither set
+		for _, out := range sharesOut {
+			for _, in := range sharesIn {
+				if filepath.Clean(out) == filepath.Clean(in) {
+					return fmt.Errorf("'%s' is both read and written; write the new shares to new files", out)
+				}
+			}
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+
+		oldShares, key, err := combineShareFiles(cmd, caPem, sharesIn)
+		if err != nil {
+			return withExitCode(exitCombine, err)
+		}
+		defer secmem.WipeKey(key)
+		if !key.PublicKey.Equal(caCert.PublicKey) {
+			return withExitCode(exitCombine, fmt.Errorf("the shares do not reconstruct the key of %s", caCert.Subject))
+		}
+		old := &utils.Share{}
+		for _, s := range oldShares {
+			if s.HasMetadata() {
+				old = s
+			}
+		}
+
+		n, _ := cmd.Flags().GetInt("n")
+		t, _ := cmd.Flags().GetInt("t")
+		if n == 0 {
+			n = old.Total
+		}
+		if t == 0 {
+			t = old.Threshold
+		}
+		if n == 0 || t == 0 {
+			return errors.New("the shares carry no metadata, so their share count and threshold are unknown; give --n and --t")
+		}
+		if t < 2 || t > n {
+			return fmt.Errorf("invalid threshold t=%d for n=%d (need 2 <= t <= n)", t, n)
+		}
+		if len(sharesOut) != n {
+			return fmt.Errorf("number of share files (%d) does not match n=%d", len(sharesOut), n)
+		}
+		if old.Threshold > 0 && t < old.Threshold {
+			slog.Warn("lowering the threshold; fewer custodians will be able to reconstruct the key", "from", old.Threshold, "to", t)
+		}
+		// The roster is tied to share indexes, so it is only kept while the share count is; a new
+		// count needs a new roster rather than shares that silently name no custodian
+		custodians := old.Roster
+		if n != old.Total && len(custodians) > 0 {
+			if !cmd.Flags().Changed("custodians") {
+				return invalid(fmt.Errorf("the current shares name %d custodians; give --custodians (and --contacts) for the %d new shares", len(custodians), n))
+			}
+			custodians = nil
+		}
+		if custodians == nil || cmd.Flags().Changed("custodians") || cmd.Flags().Changed("contacts") {
+			if custodians, err = custodiansFromFlags(cmd, n); err != nil {
+				return err
+			}
+		}
+		protection, err := shareProtection(cmd, sharesOut, custodians)
+		if err != nil {
+			return err
+		}
+		passphrases, err := sharePassphrases(cmd, sharesOut, custodians)
+		if err != nil {
+			return err
+		}
+		if passphrases == nil && protection == nil {
+			for _, s := range oldShares {
+				if s.Encrypted() {
+					slog.Warn("the current shares are encrypted but the new ones will not be; add --encrypt-shares, --recipients or --fido2 to keep them encrypted")
+					break
+				}
+			}
+		}
+		if err := utils.SplitKeyAndWriteShares(key, n, t, sharesOut, custodians, passphrases, splitOptions(cmd, caPem, protection)...); err != nil {
+			return fmt.Errorf("failed to split key: %w", err)
+		}
+		if err := recordCustody(cmd, caPem, sharesOut); err != nil {
+			return err
+		}
+		if err := recordAudit(cmd, audit.Entry{Event: audit.EventReshare, CA: caCert.Subject.String(), Params: map[string]string{
+			"from": fmt.Sprintf("%d of %d", old.Threshold, old.Total), "to": fmt.Sprintf("%d of %d", t, n), "key_id": old.KeyID,
+		}, Fingerprints: []string{inventory.Fingerprint(caCert)}}); err != nil {
+			return err
+		}
+
+		if n != old.Total || t != old.Threshold {
+			fmt.Fprintf(cmd.OutOrStdout(), "Key of %s reshared from %d-of-%d to %d-of-%d!\n", caCert.Subject, old.Threshold, old.Total, t, n)
+		} else {
+			fmt.Fprintf(cmd.OutOrStdout(), "Key of %s reshared!\n", caCert.Subject)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), " - %d new shares written (threshold %d).\n", n, t)
+		if err := printCommitments(cmd, caPem); err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Hand the new shares to their custodians, then destroy every old share (see shred); old and new shares cannot be combined.")
+		return nil
+	},
+}
+
+// custodianKeygenCmd creates the age key pair a custodian receives wrapped shares with.
+var custodianKeygenCmd = &cobra.Command{
+	Use:   "custodian-keygen",
+	Short: "Generate an age X25519 key pair for a custodian; give the public key to the dealer for --recipients.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			return invalid(errors.New("must specify --out for the identity file"))
+		}
+		if _, err := os.Stat(out); err == nil {
+			return fmt.Errorf("'%s' already exists; refusing to overwrite an identity", out)
+		}
+		id, err := age.GenerateIdentity()
+		if err != nil {
+			return err
+		}
+		recipient := id.Recipient().String()
+		data := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().UTC().Format(time.RFC3339), recipient, id)
+		if err := utils.WriteShareFile(out, []byte(data)); err != nil {
+			return fmt.Errorf("failed to write identity to '%s': %w", out, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Identity written to '%s'; keep it as safe as your share.\n", out)
+		fmt.Fprintf(cmd.OutOrStdout(), "Public key: %s\n", recipient)
+		return nil
+	},
+}
+
+// exportShareWordsCmd writes a share as words that a custodian can copy onto paper.
+var exportShareWordsCmd = &cobra.Command{
+	Use:   "export-share-words",
+	Short: "Write a key share as a numbered list of words with a checksum, for a paper backup kept in a safe.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		shareIn, _ := cmd.Flags().GetString("share-in")
+		if shareIn == "" {
+			return invalid(errors.New("must specify --share-in with the share file to export"))
+		}
+		out, _ := cmd.Flags().GetString("out")
+		s, err := utils.ReadShareFile(shareIn)
+		if err != nil {
+			return err
+		}
+		if err := utils.UnlockShares([]*utils.Share{s}, utils.PromptSharePassphrase); err != nil {
+			return err
+		}
+		defer clear(s.Data)
+		words, err := utils.EncodeShareWords(s)
+		if err != nil {
+			return err
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "# GoSeC key share #%d of %d (threshold %d), custodian %s\n", s.Index, s.Total, s.Threshold, s.Custodian())
+		fmt.Fprintf(&b, "# Key %s, split %s, %d words\n", s.KeyID, s.SplitID, len(words))
+		if s.Commitments != nil {
+			fmt.Fprintf(&b, "# VSS share: import it with the commitments published next to the CA certificate (fingerprint %s)\n", s.Commitments.Fingerprint())
+		}
+		for i := 0; i < len(words); i += 6 {
+			var row []string
+			for j := i; j < min(i+6, len(words)); j++ {
+				row = append(row, fmt.Sprintf("%3d. %-7s", j+1, words[j]))
+			}
+			b.WriteString(strings.TrimRight(strings.Join(row, " "), " ") + "\n")
+		}
+		if s.Encrypted() {
+			slog.Warn("the words are not encrypted like the share file; keep the paper as safe as the key itself")
+		}
+		if out == "" {
+			fmt.Fprint(cmd.OutOrStdout(), b.String())
+			return nil
+		}
+		if err := utils.WriteShareFile(out, []byte(b.String())); err != nil {
+			return fmt.Errorf("failed to write words to '%s': %w", out, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Share #%d written as %d words to '%s'; copy them onto paper, check them with import-share-words, then shred the file.\n", s.Index, len(words), out)
+		return nil
+	},
+}
+
+// importShareWordsCmd rebuilds a share file from a transcribed paper backup.
+var importShareWordsCmd = &cobra.Command{
+	Use:   "import-share-words",
+	Short: "Rebuild a key share file from the words written by export-share-words, checking their checksum.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		wordsIn, _ := cmd.Flags().GetString("words-in")
+		if wordsIn == "" {
+			return invalid(errors.New("must specify --words-in with the transcribed words ('-' for stdin)"))
+		}
+		shareOut, _ := cmd.Flags().GetString("share-out")
+		if shareOut == "" {
+			return invalid(errors.New("must specify --share-out for the rebuilt share file"))
+		}
+		var text []byte
+		var err error
+		if wordsIn == "-" {
+			text, err = io.ReadAll(os.Stdin)
+		} else {
+			text, err = os.ReadFile(wordsIn)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read words from '%s': %w", wordsIn, err)
+		}
+		defer clear(text)
+
+		var commitments *vss.Commitments
+		var splitID string
+		if path, _ := cmd.Flags().GetString("commitments"); path != "" {
+			if commitments, splitID, err = vss.ReadFile(path); err != nil {
+				return err
+			}
+		}
+		s, err := utils.ParseShareWords(mnemonic.Fields(string(text)), commitments)
+		if err != nil {
+			return fmt.Errorf("failed to decode share words: %w", err)
+		}
+		defer clear(s.Data)
+		if s.Commitments != nil && splitID != s.SplitID {
+			return errors.New("the share belongs to a different split than the commitments")
+		}
+
+		var data []byte
+		if encrypt, _ := cmd.Flags().GetBool("encrypt-share"); encrypt {
+			pass, err := utils.ReadNewPassphrase(fmt.Sprintf("share #%d in '%s'", s.Index, shareOut))
+			if err != nil {
+				return err
+			}
+			data, err = utils.EncodeEncryptedShare(s, pass)
+			clear(pass)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt share: %w", err)
+			}
+		} else {
+			data = utils.EncodeShare(s)
+		}
+		if err := utils.WriteShareFile(shareOut, data); err != nil {
+			return fmt.Errorf("failed to write share to '%s': %w", shareOut, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Share #%d of %d (threshold %d) of key %s written to '%s'\n", s.Index, s.Total, s.Threshold, s.KeyID, shareOut)
+		if s.Commitments != nil {
+			fmt.Fprintln(cmd.OutOrStdout(), " - consistent with the VSS commitments (fingerprint "+s.Commitments.Fingerprint()+")")
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), " - custodian labels are not part of the words; verify the share with verify-shares before relying on it.")
+		return nil
+	},
+}
+
+func init() {
+	verifySharesCmd.Flags().String("ca-pem", "", "File path to the CA certificate (PEM) whose key the shares must reconstruct")
+	verifySharesCmd.Flags().String("shares-in", "", "Comma-separated list of share files to verify (at least the threshold, or any number of VSS shares)")
+	verifySharesCmd.Flags().String("commitments", "", "Published VSS commitments to compare the shares' with (default: <ca-pem without extension>.vss.pem, if present)")
+	rootCmd.AddCommand(verifySharesCmd)
+
+	reshareCmd.Flags().String("ca-pem", "", "File path to the CA certificate (PEM) whose key is reshared")
+	reshareCmd.Flags().String("shares-in", "", "Comma-separated list of current share files (at least the threshold)")
+	reshareCmd.Flags().String("shares-out", "", "Comma-separated file paths for the new shares, one per share")
+	reshareCmd.Flags().Int("n", 0, "Number of new shares (default: the current share count)")
+	reshareCmd.Flags().Int("t", 0, "Threshold of the new shares (default: the current threshold)")
+	reshareCmd.Flags().String("custodians", "", "Comma-separated custodian labels of the new shares, in --shares-out order (default: the current custodians; required when --n changes the share count of named custodians)")
+	reshareCmd.Flags().String("contacts", "", "Comma-separated custodian contact details of the new shares (default: the current contacts)")
+	reshareCmd.Flags().Bool("encrypt-shares", false, "Encrypt each new share with its custodian's passphrase (Argon2id)")
+	reshareCmd.Flags().String("recipients", "", "Comma-separated age recipients (age1...) or files holding one, to encrypt each new share to its custodian's key")
+	reshareCmd.Flags().Bool("fido2", false, "Seal each new share to its custodian's FIDO2 token (hmac-secret), enrolled in turn")
+	reshareCmd.Flags().Bool("vss", false, "Split with Feldman verifiable secret sharing and publish the commitments next to --ca-pem")
+	reshareCmd.Flags().Bool("envelope", false, "Encrypt the key under a random key-encryption key written next to --ca-pem (<name>.wrapped-key.pem) and split only that key")
+	rootCmd.AddCommand(reshareCmd)
+
+	custodianKeygenCmd.Flags().String("out", "", "File path for the identity (secret key), in age-keygen format")
+	rootCmd.AddCommand(custodianKeygenCmd)
+
+	exportShareWordsCmd.Flags().String("share-in", "", "Share file to export (asks for its passphrase if encrypted)")
+	exportShareWordsCmd.Flags().String("out", "", "File to write the words to (default: standard output)")
+	rootCmd.AddCommand(exportShareWordsCmd)
+
+	importShareWordsCmd.Flags().String("words-in", "", "File with the transcribed words, or '-' for standard input")
+	importShareWordsCmd.Flags().String("share-out", "", "File path for the rebuilt share")
+	importShareWordsCmd.Flags().String("commitments", "", "Published VSS commitments of the split, required for VSS shares")
+	importShareWordsCmd.Flags().Bool("encrypt-share", false, "Encrypt the rebuilt share with a new passphrase (Argon2id)")
+	rootCmd.AddCommand(importShareWordsCmd)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"my-pki/internal/shred"
+
+	"github.com/spf13/cobra"
+)
+
+// shredCmd securely deletes superseded key material.
+var shredCmd = &cobra.Command{
+	Use:   "shred <file>...",
+	Short: "Overwrite files (old shares, keys, key backups) before deleting them, reporting when secure deletion cannot be guaranteed.",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		passes, _ := cmd.Flags().GetInt("passes")
+		var failed int
+		for _, path := range args {
+			res, err := shred.File(path, passes)
+			if err != nil {
+				slog.Error("shred failed", "path", path, "err", err)
+				failed++
+				continue
+			}
+			reportShred(cmd.OutOrStdout(), res)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d file(s) were not shredded", failed, len(args))
+		}
+		return nil
+	},
+}
+
+// reportShred prints the outcome of a secure deletion and why it may not be complete.
+func reportShred(out io.Writer, res *shred.Result) {
+	fmt.Fprintf(out, "Shredded %s (%d bytes, %d random passes and zeros)\n", res.Path, res.Size, res.Passes)
+	for _, c := range res.Caveats {
+		fmt.Fprintf(out, "Warning: secure deletion of %s cannot be guaranteed: %s\n", res.Path, c)
+	}
+}
+
+// shredAll shreds paths, reporting each, and returns the first failure.
+func shredAll(out io.Writer, paths []string) error {
+	var errs []error
+	for _, path := range paths {
+		res, err := shred.File(path, shred.DefaultPasses)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		reportShred(out, res)
+	}
+	return errors.Join(errs...)
+}
+
+func init() {
+	shredCmd.Flags().Int("passes", shred.DefaultPasses, "Random overwrite passes before the final pass of zeros")
+	rootCmd.AddCommand(shredCmd)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"my-pki/internal/utils"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// timeTokenCmd groups the time token subcommands.
+var timeTokenCmd = &cobra.Command{
+	Use:   "time-token",
+	Short: "Create signed time tokens for issuing on air-gapped machines with untrusted clocks.",
+}
+
+// time-token issue
+var timeTokenIssueCmd = &cobra.Command{
+	Use:   "issue",
+	Short: "Sign the current time with a time authority key (run on a machine with a trusted clock).",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyPath, _ := cmd.Flags().GetString("key")
+		if keyPath == "" {
+			return invalid(errors.New("must specify --key for the time authority private key"))
+		}
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			return invalid(errors.New("must specify --out for the time token"))
+		}
+		key, err := utils.LoadPrivateKeyFromFile(keyPath, utils.PromptPassphrase(keyPath))
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		token, err := utils.CreateTimeToken(now, key)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(out, token, 0644); err != nil {
+			return fmt.Errorf("failed to write time token to '%s': %w", out, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Time token for %s written to %s\n", now.UTC().Format(time.RFC3339), out)
+		return nil
+	},
+}
+
+// configureClock switches issuance to a verified time token when --time-token is given.
+func configureClock(cmd *cobra.Command) error {
+	tokenPath, _ := cmd.Flags().GetString("time-token")
+	authorityPath, _ := cmd.Flags().GetString("time-authority")
+	if tokenPath == "" {
+		if authorityPath != "" {
+			return errors.New("--time-authority requires --time-token")
+		}
+		return nil
+	}
+	if authorityPath == "" {
+		return errors.New("--time-token requires --time-authority to verify it")
+	}
+	clock, t, err := utils.LoadTimeTokenClock(tokenPath, authorityPath)
+	if err != nil {
+		return err
+	}
+	utils.IssuanceClock = clock
+	fmt.Fprintf(progress, "Using trusted time %s from time token '%s' (local clock: %s)\n",
+		t.UTC().Format(time.RFC3339), tokenPath, time.Now().UTC().Format(time.RFC3339))
+	return nil
+}
+
+func init() {
+	timeTokenIssueCmd.Flags().String("key", "", "Time authority private key (PEM)")
+	timeTokenIssueCmd.Flags().String("out", "", "File path for the time token")
+
+	timeTokenCmd.AddCommand(timeTokenIssueCmd)
+	rootCmd.AddCommand(timeTokenCmd)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/piv"
+	"my-pki/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// signTokenCmd enrolls a PIV token: the key is generated on the token and never leaves it.
+var signTokenCmd = &cobra.Command{
+	Use:   "sign-token",
+	Short: "Generate a leaf key on a YubiKey/PIV token, sign its CSR with a CA and write the certificate back to the slot.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		subject, err := utils.BuildSubject(cmd)
+		if err != nil {
+			return err
+		}
+		days, _ := cmd.Flags().GetInt("days")
+		days, err = validityDays(cmd, days)
+		if err != nil {
+			return err
+		}
+
+		slot, _ := cmd.Flags().GetString("slot")
+		if err := piv.CheckSlot(slot); err != nil {
+			return err
+		}
+
+		caPem, _ := cmd.Flags().GetString("ca-pem")
+		if caPem == "" {
+			return invalid(errors.New("must specify --ca-pem for the signing CA certificate"))
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPem)
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", caPem, err)
+		}
+		days, settings, err := resolveProfile(cmd, caPem, caconfig.ProfileLeaf, days)
+		if err != nil {
+			return err
+		}
+		opts, err := issuanceOptions(cmd, settings, days)
+		if err != nil {
+			return err
+		}
+		subject = settings.Normalization().Subject(subject)
+		hookReq, err := newHookRequest(cmd, caPem, caconfig.ProfileLeaf, subject, nil, days)
+		if err != nil {
+			return err
+		}
+		if err := preIssueHooks(settings, hookReq); err != nil {
+			return err
+		}
+
+		// Reconstruct the CA key before touching the token, so a missing share does not leave a fresh uncertified key in the slot
+		sharesInStr, _ := cmd.Flags().GetString("shares-in")
+		caKeyPath, _ := cmd.Flags().GetString("ca-key")
+		caKey, err := loadCAKey(cmd, caPem, sharesInStr, caKeyPath, "--shares-in", "--ca-key")
+		if err != nil {
+			return fmt.Errorf("failed to load CA private key: %w", err)
+		}
+
+		token := &piv.Token{}
+		token.Tool, _ = cmd.Flags().GetString("piv-tool")
+		token.Reader, _ = cmd.Flags().GetString("reader")
+		token.ManagementKey, _ = cmd.Flags().GetString("management-key")
+		token.PINPolicy, _ = cmd.Flags().GetString("pin-policy")
+		token.TouchPolicy, _ = cmd.Flags().GetString("touch-policy")
+		pin, _ := cmd.Flags().GetString("pin")
+		if pin != "" {
+			token.PIN = []byte(pin)
+		} else if token.PIN, err = utils.ReadPassphrase("Enter PIN for the token: "); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Generating P-256 key in slot %s...\n", slot)
+		pub, err := token.GenerateKey(slot)
+		if err != nil {
+			return err
+		}
+		if _, err := token.RequestCertificate(slot, subject.CommonName, pub); err != nil {
+			return err
+		}
+
+		// Key management (9d) keys are used for ECDH, the other slots sign
+		ku := x509.KeyUsageDigitalSignature
+		if slot == "9d" {
+			ku = x509.KeyUsageKeyAgreement
+		}
+		certPEM, err := utils.SignPublicKey(subject, pub, caCert, caKey, false, days, ku, opts...)
+		if err != nil {
+			return withExitCode(exitSigning, fmt.Errorf("failed to sign token certificate: %w", err))
+		}
+		if err := logIssuance(cmd, caPem, certPEM, caKey); err != nil {
+			return err
+		}
+		// Keep a copy on disk before importing, so the certificate is not lost if the import fails
+		certOut, _ := cmd.Flags().GetString("cert-out")
+		if certOut != "" {
+			if err := utils.WriteCertificateToFile(certPEM, certOut); err != nil {
+				return fmt.Errorf("failed to write certificate to '%s': %w", certOut, err)
+			}
+		}
+		if err := token.ImportCertificate(slot, certPEM); err != nil {
+			return err
+		}
+		postIssueHooks(settings, hookReq, certPEM, certOut)
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Certificate for %s written to slot %s, valid for %d days\n", subject, slot, days)
+		if certOut != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "Copy saved to %s\n", certOut)
+		}
+		return nil
+	},
+}
+
+func init() {
+	addSubjectFlags(signTokenCmd)
+	signTokenCmd.Flags().String("slot", "9a", "PIV slot for the key and certificate: 9a, 9c, 9d or 9e")
+	signTokenCmd.Flags().String("ca-pem", "", "File path to the signing CA certificate (PEM)")
+	signTokenCmd.Flags().String("shares-in", "", "Comma-separated list of share files for the signing CA's private key")
+	signTokenCmd.Flags().String("ca-key", "", "File path to the signing CA private key (PEM, SEC1 or PKCS#8, optionally encrypted) instead of shares")
+	signTokenCmd.Flags().String("cert-out", "", "Also write the issued certificate to this file (PEM) (optional)")
+	signTokenCmd.Flags().String("pin", "", "Token PIN (visible to other local users; prompted for if omitted)")
+	signTokenCmd.Flags().String("management-key", "", "Token management key (hex) (default: the tool's default key)")
+	signTokenCmd.Flags().String("reader", "", "Name (substring) of the smartcard reader to use (default: first reader)")
+	signTokenCmd.Flags().String("pin-policy", "", "PIN policy for the new key: never, once or always (default: token default)")
+	signTokenCmd.Flags().String("touch-policy", "", "Touch policy for the new key: never, always or cached (default: token default)")
+	signTokenCmd.Flags().String("piv-tool", piv.DefaultTool, "Path to the yubico-piv-tool executable")
+	signTokenCmd.Flags().String("issuance-log", "", "Issuance log of the signing CA (default: <ca-pem without extension>.issuance.log)")
+	addPolicyFlags(signTokenCmd)
+	addDistributionFlags(signTokenCmd)
+	rootCmd.AddCommand(signTokenCmd)
+}
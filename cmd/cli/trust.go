@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"my-pki/internal/inventory"
+	"my-pki/internal/truststore"
+	"my-pki/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// install-trust
+var installTrustCmd = &cobra.Command{
+	Use:   "install-trust <root.pem>",
+	Short: "Install a CA certificate into the system trust store (Linux ca-certificates, macOS System keychain, Windows root store).",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cert, err := utils.ParseCertificateFromFile(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", args[0], err)
+		}
+		if !cert.IsCA {
+			return fmt.Errorf("'%s' is not a CA certificate", args[0])
+		}
+		if !inventory.IsSelfSigned(cert) {
+			slog.Warn("not a self-signed root; it becomes a trust anchor in its own right", "cert", args[0])
+		}
+		where, err := truststore.Install(cert)
+		if err != nil {
+			return fmt.Errorf("failed to install '%s': %w", args[0], err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Installed %s into %s\n - SHA-256: %s\n", cert.Subject, where, inventory.Fingerprint(cert))
+		fmt.Fprintln(cmd.OutOrStdout(), "Firefox and Java keep their own trust stores and are not changed; restart running browsers to pick up the change.")
+		return nil
+	},
+}
+
+// uninstall-trust
+var uninstallTrustCmd = &cobra.Command{
+	Use:   "uninstall-trust <root.pem>",
+	Short: "Remove a CA certificate installed with install-trust from the system trust store.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cert, err := utils.ParseCertificateFromFile(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse CA certificate from '%s': %w", args[0], err)
+		}
+		where, err := truststore.Uninstall(cert)
+		if err != nil {
+			return fmt.Errorf("failed to uninstall '%s': %w", args[0], err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Removed %s from %s\n", cert.Subject, where)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(installTrustCmd)
+	rootCmd.AddCommand(uninstallTrustCmd)
+}
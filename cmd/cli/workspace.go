@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"my-pki/internal/utils"
+	"my-pki/internal/workspace"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// workspaceCmd groups the encrypted workspace subcommands.
+var workspaceCmd = &cobra.Command{
+	Use:   "workspace",
+	Short: "Seal a workspace (inventory, CA configs, logs, certificates) into an encrypted container and unseal it again.",
+}
+
+// workspace seal
+var workspaceSealCmd = &cobra.Command{
+	Use:   "seal",
+	Short: "Encrypt every file in a workspace directory into one container and remove the plaintext files.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, _ := cmd.Flags().GetString("dir")
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			return invalid(errors.New("must specify --out for the sealed container"))
+		}
+		keyFile, _ := cmd.Flags().GetString("key-file")
+		force, _ := cmd.Flags().GetBool("force")
+		if _, err := os.Stat(out); err == nil && !force {
+			return fmt.Errorf("'%s' already exists; use --force to replace it", out)
+		}
+		key, err := workspaceKey(keyFile, true)
+		if err != nil {
+			return err
+		}
+
+		// Never seal the container into itself, nor the key that opens it
+		skip, err := pathsInside(dir, out, keyFile)
+		if err != nil {
+			return err
+		}
+		sealed, files, err := workspace.Seal(dir, key, func(rel string) bool { return slices.Contains(skip, rel) })
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no files to seal in '%s'", dir)
+		}
+		tmp := out + ".tmp"
+		if err := os.WriteFile(tmp, sealed, 0600); err != nil {
+			return fmt.Errorf("failed to write '%s': %w", out, err)
+		}
+		if err := os.Rename(tmp, out); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to write '%s': %w", out, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Sealed %d file(s) from '%s' into %s\n", len(files), dir, out)
+
+		if keep, _ := cmd.Flags().GetBool("keep"); keep {
+			return nil
+		}
+		// The plaintext includes shares and keys: overwrite it rather than just unlinking it
+		if err := shredAll(cmd.OutOrStdout(), files); err != nil {
+			return fmt.Errorf("sealed, but failed to remove plaintext: %w", err)
+		}
+		removeEmptyDirs(dir)
+		fmt.Fprintln(cmd.OutOrStdout(), "Plaintext files shredded. Where secure deletion cannot be guaranteed, rely on full-disk encryption as well.")
+		return nil
+	},
+}
+
+// workspace unseal
+var workspaceUnsealCmd = &cobra.Command{
+	Use:   "unseal",
+	Short: "Decrypt a sealed workspace container into a new or empty directory.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, _ := cmd.Flags().GetString("in")
+		if in == "" {
+			return invalid(errors.New("must specify --in for the sealed container"))
+		}
+		dir, _ := cmd.Flags().GetString("dir")
+		if dir == "" {
+			return invalid(errors.New("must specify --dir to unseal into"))
+		}
+		data, err := os.ReadFile(in)
+		if err != nil {
+			return fmt.Errorf("unable to read '%s': %w", in, err)
+		}
+		keyFile, _ := cmd.Flags().GetString("key-file")
+		key, err := workspaceKey(keyFile, false)
+		if err != nil {
+			return err
+		}
+		n, err := workspace.Unseal(data, key, dir)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Unsealed %d file(s) into '%s'. Seal it again with 'workspace seal --dir %s --out %s --force' when done.\n", n, dir, dir, in)
+		return nil
+	},
+}
+
+// workspace keygen
+var workspaceKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Create a random key file for sealing workspaces without a passphrase.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			return invalid(errors.New("must specify --out for the key file"))
+		}
+		key, err := workspace.NewKeyFile()
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create key file '%s': %w", out, err)
+		}
+		if _, err := f.Write(key); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write key file '%s': %w", out, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write key file '%s': %w", out, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Key file written to %s; keep it apart from the sealed workspace (e.g. on removable media)\n", out)
+		return nil
+	},
+}
+
+// workspaceKey reads the key file, or prompts for a passphrase (twice when sealing).
+func workspaceKey(keyFile string, sealing bool) (workspace.Key, error) {
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return workspace.Key{}, fmt.Errorf("unable to read key file '%s': %w", keyFile, err)
+		}
+		return workspace.Key{KeyFile: data}, nil
+	}
+	var pass []byte
+	var err error
+	if sealing {
+		pass, err = utils.ReadNewPassphrase("the workspace")
+	} else {
+		pass, err = utils.ReadPassphrase("Enter passphrase for the workspace: ")
+	}
+	if err != nil {
+		return workspace.Key{}, err
+	}
+	return workspace.Key{Passphrase: pass}, nil
+}
+
+// pathsInside returns those of paths that lie inside dir, relative to it and slash-separated.
+func pathsInside(dir string, paths ...string) ([]string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var inside []string
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(absDir, abs); err == nil && filepath.IsLocal(rel) {
+			inside = append(inside, filepath.ToSlash(rel), filepath.ToSlash(rel)+".tmp")
+		}
+	}
+	return inside, nil
+}
+
+// removeEmptyDirs removes the directories below dir left empty after sealing, deepest first.
+func removeEmptyDirs(dir string) {
+	var dirs []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() && path != dir {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	slices.SortFunc(dirs, func(a, b string) int {
+		return strings.Count(b, string(filepath.Separator)) - strings.Count(a, string(filepath.Separator))
+	})
+	for _, d := range dirs {
+		os.Remove(d) // fails, harmlessly, if not empty
+	}
+}
+
+func init() {
+	workspaceSealCmd.Flags().String("dir", ".", "Workspace directory to seal")
+	workspaceSealCmd.Flags().String("out", "", "File path for the sealed container")
+	workspaceSealCmd.Flags().String("key-file", "", "Seal with this key file instead of a passphrase")
+	workspaceSealCmd.Flags().Bool("keep", false, "Keep the plaintext files after sealing")
+	workspaceSealCmd.Flags().Bool("force", false, "Replace an existing container at --out")
+
+	workspaceUnsealCmd.Flags().String("in", "", "File path to the sealed container")
+	workspaceUnsealCmd.Flags().String("dir", "", "New or empty directory to unseal into")
+	workspaceUnsealCmd.Flags().String("key-file", "", "Key file the workspace was sealed with (default: prompt for the passphrase)")
+
+	workspaceKeygenCmd.Flags().String("out", "", "File path for the new key file")
+
+	workspaceCmd.AddCommand(workspaceSealCmd)
+	workspaceCmd.AddCommand(workspaceUnsealCmd)
+	workspaceCmd.AddCommand(workspaceKeygenCmd)
+	rootCmd.AddCommand(workspaceCmd)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log/slog"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+)
+
+// Preference keys and bounds of the display settings.
+const (
+	prefTextScale    = "display.textScale"
+	prefHighContrast = "display.highContrast"
+	minTextScale     = 0.75
+	maxTextScale     = 3
+	textScaleStep    = 0.25
+)
+
+// highContrastColors are the colours of the high-contrast theme: white on black, with a yellow
+// accent for focus, selection and primary actions. Colours not listed come from the dark theme.
+var highContrastColors = map[fyne.ThemeColorName]color.Color{
+	theme.ColorNameBackground:          color.Black,
+	theme.ColorNameForeground:          color.White,
+	theme.ColorNameButton:              color.NRGBA{R: 0x26, G: 0x26, B: 0x26, A: 0xff},
+	theme.ColorNameDisabledButton:      color.NRGBA{R: 0x1a, G: 0x1a, B: 0x1a, A: 0xff},
+	theme.ColorNameDisabled:            color.NRGBA{R: 0xb0, G: 0xb0, B: 0xb0, A: 0xff},
+	theme.ColorNamePlaceHolder:         color.NRGBA{R: 0xc8, G: 0xc8, B: 0xc8, A: 0xff},
+	theme.ColorNamePrimary:             color.NRGBA{R: 0xff, G: 0xd7, B: 0x00, A: 0xff},
+	theme.ColorNameFocus:               color.NRGBA{R: 0xff, G: 0xd7, B: 0x00, A: 0x80},
+	theme.ColorNameSelection:           color.NRGBA{R: 0xff, G: 0xd7, B: 0x00, A: 0x66},
+	theme.ColorNameHover:               color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0x33},
+	theme.ColorNamePressed:             color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0x66},
+	theme.ColorNameForegroundOnPrimary: color.Black,
+	theme.ColorNameForegroundOnError:   color.Black,
+	theme.ColorNameForegroundOnSuccess: color.Black,
+	theme.ColorNameForegroundOnWarning: color.Black,
+	theme.ColorNameError:               color.NRGBA{R: 0xff, G: 0x80, B: 0x80, A: 0xff},
+	theme.ColorNameSuccess:             color.NRGBA{R: 0x66, G: 0xff, B: 0x66, A: 0xff},
+	theme.ColorNameWarning:             color.NRGBA{R: 0xff, G: 0xb0, B: 0x40, A: 0xff},
+	theme.ColorNameHyperlink:           color.NRGBA{R: 0x80, G: 0xd0, B: 0xff, A: 0xff},
+	theme.ColorNameInputBackground:     color.Black,
+	theme.ColorNameInputBorder:         color.White,
+	theme.ColorNameSeparator:           color.White,
+	theme.ColorNameScrollBar:           color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xaa},
+	theme.ColorNameHeaderBackground:    color.Black,
+	theme.ColorNameMenuBackground:      color.Black,
+	theme.ColorNameOverlayBackground:   color.Black,
+}
+
+// displayTheme is the default theme with every size multiplied by scale and, when highContrast
+// is set, the high-contrast colours.
+type displayTheme struct {
+	scale        float32
+	highContrast bool
+}
+
+func (t *displayTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) color.Color {
+	if !t.highContrast {
+		return theme.DefaultTheme().Color(name, variant)
+	}
+	if c, ok := highContrastColors[name]; ok {
+		return c
+	}
+	return theme.DefaultTheme().Color(name, theme.VariantDark)
+}
+
+func (t *displayTheme) Font(style fyne.TextStyle) fyne.Resource {
+	return theme.DefaultTheme().Font(style)
+}
+
+func (t *displayTheme) Icon(name fyne.ThemeIconName) fyne.Resource {
+	return theme.DefaultTheme().Icon(name)
+}
+
+func (t *displayTheme) Size(name fyne.ThemeSizeName) float32 {
+	return theme.DefaultTheme().Size(name) * t.scale
+}
+
+// display applies the text scale and contrast chosen by the operator, saved in the app preferences
+// so that a ceremony laptop keeps them between launches.
+type display struct {
+	app          fyne.App
+	current      displayTheme
+	menu         *fyne.MainMenu
+	contrastItem *fyne.MenuItem
+}
+
+// newDisplay loads the saved display settings and applies them to a.
+func newDisplay(a fyne.App) *display {
+	p := a.Preferences()
+	d := &display{app: a, current: displayTheme{
+		scale:        clampTextScale(float32(p.FloatWithFallback(prefTextScale, 1))),
+		highContrast: p.BoolWithFallback(prefHighContrast, false),
+	}}
+	t := d.current
+	a.Settings().SetTheme(&t)
+	return d
+}
+
+// clampTextScale keeps scale within the supported range.
+func clampTextScale(scale float32) float32 {
+	return min(max(scale, minTextScale), maxTextScale)
+}
+
+// setTextScale changes the text scale, which also scales padding and icons.
+func (d *display) setTextScale(scale float32) {
+	d.current.scale = clampTextScale(scale)
+	d.apply()
+}
+
+// toggleHighContrast switches between the default and the high-contrast colours.
+func (d *display) toggleHighContrast() {
+	d.current.highContrast = !d.current.highContrast
+	d.apply()
+}
+
+// apply saves the settings and redraws the app with them.
+func (d *display) apply() {
+	p := d.app.Preferences()
+	p.SetFloat(prefTextScale, float64(d.current.scale))
+	p.SetBool(prefHighContrast, d.current.highContrast)
+	t := d.current
+	d.app.Settings().SetTheme(&t)
+	if d.contrastItem != nil {
+		d.contrastItem.Checked = d.current.highContrast
+		d.menu.Refresh()
+	}
+	slog.Info("display", "text_scale", fmt.Sprintf("%d%%", int(d.current.scale*100)), "high_contrast", d.current.highContrast)
+}
+
+// install adds the View and Tabs menus to win and binds their keyboard shortcuts. Alt opens the
+// menus from anywhere, including while typing in a field; the shortcuts work when no field has focus.
+func (d *display) install(win fyne.Window, tabs *container.AppTabs) {
+	shortcut := func(key fyne.KeyName, mod fyne.KeyModifier, action func()) *desktop.CustomShortcut {
+		s := &desktop.CustomShortcut{KeyName: key, Modifier: fyne.KeyModifierShortcutDefault | mod}
+		win.Canvas().AddShortcut(s, func(fyne.Shortcut) { action() })
+		return s
+	}
+	item := func(label string, s fyne.Shortcut, action func()) *fyne.MenuItem {
+		i := fyne.NewMenuItem(label, action)
+		i.Shortcut = s
+		return i
+	}
+
+	larger := func() { d.setTextScale(d.current.scale + textScaleStep) }
+	smaller := func() { d.setTextScale(d.current.scale - textScaleStep) }
+	reset := func() { d.setTextScale(1) }
+	d.contrastItem = item("High Contrast", shortcut(fyne.KeyH, fyne.KeyModifierShift, d.toggleHighContrast), d.toggleHighContrast)
+	d.contrastItem.Checked = d.current.highContrast
+	view := fyne.NewMenu("View",
+		item("Larger Text", shortcut(fyne.KeyEqual, 0, larger), larger),
+		item("Smaller Text", shortcut(fyne.KeyMinus, 0, smaller), smaller),
+		item("Reset Text Size", shortcut(fyne.Key0, 0, reset), reset),
+		fyne.NewMenuItemSeparator(),
+		d.contrastItem,
+	)
+
+	// Tabs are switched by number, and the first field of the new tab takes the focus so that
+	// Tab and Shift+Tab move through its form from the top
+	var tabItems []*fyne.MenuItem
+	for i, tab := range tabs.Items {
+		selectTab := func() {
+			tabs.SelectIndex(i)
+			win.Canvas().Unfocus()
+			win.Canvas().FocusNext()
+		}
+		if i < 9 {
+			tabItems = append(tabItems, item(tab.Text, shortcut(fyne.KeyName(strconv.Itoa(i+1)), 0, selectTab), selectTab))
+		} else {
+			tabItems = append(tabItems, fyne.NewMenuItem(tab.Text, selectTab))
+		}
+	}
+
+	d.menu = fyne.NewMainMenu(view, fyne.NewMenu("Tabs", tabItems...))
+	win.SetMainMenu(d.menu)
+}
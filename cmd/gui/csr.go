@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/ctlog"
+	"my-pki/internal/secmem"
+	"my-pki/internal/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// -------------------------------------------------------------------------------------
+// Sign CSR Tab
+// -------------------------------------------------------------------------------------
+
+// signCSRTab issues a certificate for a CSR generated elsewhere; no leaf key is ever created here.
+func signCSRTab(win fyne.Window) fyne.CanvasObject {
+	csrEntry := widget.NewEntry()
+	csrEntry.SetPlaceHolder("Select the CSR (PEM) to sign")
+	csrBrowse := createFileOpenButton(win, "Browse (CSR)", csrEntry)
+
+	// Read-only review pane, refreshed whenever the CSR path changes
+	reviewText := widget.NewMultiLineEntry()
+	reviewText.Wrapping = fyne.TextWrapWord
+	reviewText.SetMinRowsVisible(8)
+	reviewText.Disable()
+	var loaded *x509.CertificateRequest
+	csrEntry.OnChanged = func(path string) {
+		loaded = nil
+		if strings.TrimSpace(path) == "" {
+			reviewText.SetText("")
+			return
+		}
+		csr, err := utils.ReadCSRFile(path)
+		if err != nil {
+			reviewText.SetText(err.Error())
+			return
+		}
+		lines := utils.DescribeCSR(csr)
+		for _, w := range utils.CSRWarnings(csr) {
+			lines = append(lines, "Warning: "+w)
+		}
+		reviewText.SetText(strings.Join(lines, "\n"))
+		loaded = csr
+	}
+
+	daysEntry := widget.NewEntry()
+	daysEntry.SetText("365")
+
+	caPemEntry := widget.NewEntry()
+	caPemEntry.SetPlaceHolder("Select the signing CA PEM")
+	caPemBrowse := createFileOpenButton(win, "Browse (CA PEM)", caPemEntry)
+
+	sharesInEntry := widget.NewEntry()
+	sharesInEntry.SetPlaceHolder("Select signing CA key shares...")
+	quorumLabel := newQuorumLabel(sharesInEntry)
+
+	addShareBtn := widget.NewButton("Add CA Share", func() {
+		dlg := dialog.NewFileOpen(
+			func(reader fyne.URIReadCloser, err error) {
+				if err != nil {
+					showError(win, err)
+					return
+				}
+				if reader == nil {
+					return
+				}
+				newPath := reader.URI().Path()
+				_ = reader.Close()
+
+				existing := sharesInEntry.Text
+				if existing == "" {
+					sharesInEntry.SetText(newPath)
+				} else {
+					sharesInEntry.SetText(existing + "," + newPath)
+				}
+			},
+			win,
+		)
+		dlg.Show()
+	})
+
+	certOutEntry := widget.NewEntry()
+	certOutEntry.SetPlaceHolder("Where to save the new certificate")
+	certOutBrowse := createFileSaveButton(win, "Browse (Cert Out)", certOutEntry)
+
+	usageChecks := newKeyUsageChecks()
+	usageChecks.checks[0].SetChecked(true) // digital signature
+
+	signButton := widget.NewButtonWithIcon("Sign CSR", theme.ConfirmIcon(), func() {
+		if loaded == nil {
+			showError(win, fmt.Errorf("no valid CSR loaded"))
+			return
+		}
+		if err := loaded.CheckSignature(); err != nil {
+			showError(win, fmt.Errorf("CSR signature is invalid: %w", err))
+			return
+		}
+		days, err := strconv.Atoi(daysEntry.Text)
+		if err != nil {
+			showError(win, fmt.Errorf("invalid days: %w", err))
+			return
+		}
+		if caPemEntry.Text == "" {
+			showError(win, fmt.Errorf("missing CA PEM path"))
+			return
+		}
+		if certOutEntry.Text == "" {
+			showError(win, fmt.Errorf("missing certificate output path"))
+			return
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPemEntry.Text)
+		if err != nil {
+			showError(win, fmt.Errorf("failed to parse CA cert: %w", err))
+			return
+		}
+		days, opts, settings, err := checkCAProfile(caPemEntry.Text, caconfig.ProfileLeaf, days)
+		if err != nil {
+			showError(win, err)
+			return
+		}
+		norm := settings.Normalization()
+		subject := norm.Subject(loaded.Subject)
+		opts = append(opts, utils.WithSANs(norm.SANs(utils.SANsFromCSR(loaded))))
+
+		sharePaths := strings.Split(strings.TrimSpace(sharesInEntry.Text), ",")
+		unlockShares(win, sharePaths, func(shares []*utils.Share) {
+			caKeyBytes, err := combineShares(shares)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to combine CA shares: %w", err))
+				return
+			}
+			defer secmem.Wipe(caKeyBytes)
+			caKey, err := utils.ParsePrivateKeyDER(caKeyBytes)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to parse CA key: %w", err))
+				return
+			}
+			defer secmem.WipeKey(caKey)
+
+			certPEM, err := utils.SignPublicKey(subject, loaded.PublicKey, caCert, caKey, false, days, usageChecks.usage(), opts...)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to sign CSR: %w", err))
+				return
+			}
+			err = ctlog.AppendCertificatePEM(ctlog.PathForCA(caPemEntry.Text), certPEM, caKey, time.Now())
+			if err != nil {
+				showError(win, fmt.Errorf("failed to record issuance: %w", err))
+				return
+			}
+			resume.step(tabSignCSR, fmt.Sprintf("issuance for %s logged, certificate not yet written to %s", loaded.Subject, certOutEntry.Text))
+			if err := utils.WriteCertificateToFile(certPEM, certOutEntry.Text); err != nil {
+				showError(win, fmt.Errorf("failed to write certificate: %w", err))
+				return
+			}
+			resume.done(tabSignCSR)
+			showSuccess(win, fmt.Sprintf("Certificate for %s written to: %s", subject, certOutEntry.Text))
+		})
+	})
+
+	for field, e := range map[string]*widget.Entry{
+		"CSR": csrEntry, "Days": daysEntry, "CA PEM": caPemEntry, "CA Shares": sharesInEntry, "Cert Out": certOutEntry,
+	} {
+		resume.entry(tabSignCSR, field, e)
+	}
+	usageChecks.persist(tabSignCSR)
+
+	csrForm := &widget.Form{
+		Items: []*widget.FormItem{
+			{
+				Text:   "CSR",
+				Widget: container.NewBorder(nil, nil, nil, csrBrowse, csrEntry),
+			},
+			{Text: "Days (Validity)", Widget: daysEntry},
+		},
+	}
+
+	caForm := &widget.Form{
+		Items: []*widget.FormItem{
+			{
+				Text:   "CA PEM",
+				Widget: container.NewBorder(nil, nil, nil, caPemBrowse, caPemEntry),
+			},
+			{
+				Text:   "CA Key Shares",
+				Widget: container.NewBorder(nil, nil, nil, addShareBtn, sharesInEntry),
+			},
+			{Text: "Quorum", Widget: quorumLabel},
+		},
+	}
+
+	outForm := &widget.Form{
+		Items: []*widget.FormItem{
+			{
+				Text:   "Cert Out",
+				Widget: container.NewBorder(nil, nil, nil, certOutBrowse, certOutEntry),
+			},
+		},
+	}
+
+	content := container.NewVBox(
+		widget.NewCard("Certificate Request", "", csrForm),
+		widget.NewCard("Request Contents", "Subject and SANs are issued as requested", reviewText),
+		widget.NewCard("Signing CA Information", "", caForm),
+		usageChecks.card(),
+		widget.NewCard("Output Files", "", outForm),
+		signButton,
+	)
+
+	return container.NewVScroll(content)
+}
@@ -4,11 +4,17 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/ctlog"
+	"my-pki/internal/logging"
+	"my-pki/internal/secmem"
 	"my-pki/internal/utils"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -18,33 +24,332 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
-// createSubjectFromInputs builds an x509 subject from form inputs
-func createSubjectFromInputs(
-	cn, org, ou, locality, province, country string,
-) pkix.Name {
-	// filter out empty values
-	var subject pkix.Name
-	if org != "" {
-		subject.Organization = []string{org}
+// Tab titles, also the keys under which resume saves each tab's form.
+const (
+	tabRoot    = "Create Root CA"
+	tabSubCA   = "Create SubCA"
+	tabSign    = "Sign Leaf"
+	tabSignCSR = "Sign CSR"
+	tabInbox   = "Approval Inbox"
+)
+
+// subjectFields holds the subject entries of a form. Repeatable attributes take several values separated by ';'.
+type subjectFields struct {
+	cn, org, ou, locality, province, country    *widget.Entry
+	street, postalCode, dc, email, serialNumber *widget.Entry
+}
+
+// newSubjectFields creates the subject entries; cnPlaceholder hints at the expected common name.
+func newSubjectFields(cnPlaceholder string) *subjectFields {
+	f := &subjectFields{}
+	for _, e := range []**widget.Entry{&f.cn, &f.org, &f.ou, &f.locality, &f.province, &f.country, &f.street, &f.postalCode, &f.dc, &f.email, &f.serialNumber} {
+		*e = widget.NewEntry()
+	}
+	f.cn.SetPlaceHolder(cnPlaceholder)
+	f.org.SetPlaceHolder("e.g. My Company")
+	f.ou.SetPlaceHolder("e.g. Security Dept.; PKI Team")
+	f.locality.SetPlaceHolder("City")
+	f.province.SetPlaceHolder("State/Province")
+	f.country.SetPlaceHolder("Country Code (e.g. US)")
+	f.street.SetPlaceHolder("Optional")
+	f.postalCode.SetPlaceHolder("Optional")
+	f.dc.SetPlaceHolder("Optional, e.g. example; com")
+	f.email.SetPlaceHolder("Optional")
+	f.serialNumber.SetPlaceHolder("Optional subject serialNumber")
+	return f
+}
+
+// formItems returns the form rows for the subject entries.
+func (f *subjectFields) formItems() []*widget.FormItem {
+	return []*widget.FormItem{
+		{Text: "Common Name", Widget: f.cn},
+		{Text: "Organization", Widget: f.org},
+		{Text: "Org Unit", Widget: f.ou},
+		{Text: "Locality", Widget: f.locality},
+		{Text: "Province", Widget: f.province},
+		{Text: "Country", Widget: f.country},
+		{Text: "Street", Widget: f.street},
+		{Text: "Postal Code", Widget: f.postalCode},
+		{Text: "Domain Comp.", Widget: f.dc},
+		{Text: "Email", Widget: f.email},
+		{Text: "Serial Number", Widget: f.serialNumber},
+	}
+}
+
+// persist saves the subject entries of tab for session resumption.
+func (f *subjectFields) persist(tab string) {
+	for _, item := range f.formItems() {
+		resume.entry(tab, item.Text, item.Widget.(*widget.Entry))
+	}
+}
+
+// subject builds an x509 subject from the entries.
+func (f *subjectFields) subject() (pkix.Name, error) {
+	split := func(e *widget.Entry) []string { return strings.Split(e.Text, ";") }
+	return utils.SubjectAttributes{
+		CommonName:         f.cn.Text,
+		SerialNumber:       f.serialNumber.Text,
+		Organization:       split(f.org),
+		OrganizationalUnit: split(f.ou),
+		Locality:           split(f.locality),
+		Province:           split(f.province),
+		Country:            split(f.country),
+		StreetAddress:      split(f.street),
+		PostalCode:         split(f.postalCode),
+		DomainComponent:    split(f.dc),
+		Email:              split(f.email),
+	}.Name()
+}
+
+// keyUsageChecks holds one checkbox per key usage a leaf certificate may enable.
+type keyUsageChecks struct {
+	checks []*widget.Check
+	usages []x509.KeyUsage
+}
+
+func newKeyUsageChecks() *keyUsageChecks {
+	k := &keyUsageChecks{}
+	for _, u := range []struct {
+		label string
+		usage x509.KeyUsage
+	}{
+		{"Digital Signature", x509.KeyUsageDigitalSignature},
+		{"Key Encipherment", x509.KeyUsageKeyEncipherment},
+		{"Data Encipherment", x509.KeyUsageDataEncipherment},
+		{"Key Agreement", x509.KeyUsageKeyAgreement},
+		{"CRL Sign", x509.KeyUsageCRLSign},
+		{"Encipher Only", x509.KeyUsageEncipherOnly},
+		{"Decipher Only", x509.KeyUsageDecipherOnly},
+	} {
+		k.checks = append(k.checks, widget.NewCheck(u.label, nil))
+		k.usages = append(k.usages, u.usage)
 	}
-	if ou != "" {
-		subject.OrganizationalUnit = []string{ou}
+	return k
+}
+
+// usage returns the key usages that are checked.
+func (k *keyUsageChecks) usage() x509.KeyUsage {
+	var ku x509.KeyUsage
+	for i, check := range k.checks {
+		if check.Checked {
+			ku |= k.usages[i]
+		}
+	}
+	return ku
+}
+
+// persist saves the checkboxes of tab for session resumption.
+func (k *keyUsageChecks) persist(tab string) {
+	for _, check := range k.checks {
+		resume.check(tab, check.Text, check)
+	}
+}
+
+// card lays the checkboxes out in a card.
+func (k *keyUsageChecks) card() fyne.CanvasObject {
+	objects := make([]fyne.CanvasObject, len(k.checks))
+	for i, check := range k.checks {
+		objects[i] = check
+	}
+	return widget.NewCard("Key Usage", "Select the key usages to enable", container.NewVBox(objects...))
+}
+
+// combineShares combines shares unlocked by unlockShares. On failure the error lists which
+// custodians' shares were provided and which are still missing.
+func combineShares(shares []*utils.Share) ([]byte, error) {
+	slog.Info("combining shares", "files", len(shares), "quorum", utils.DescribeQuorum(shares))
+	// Only the shares' metadata is needed once the key is reconstructed
+	defer func() {
+		for _, s := range shares {
+			secmem.Wipe(s.Data)
+		}
+	}()
+	keyBytes, err := utils.CombineShares(shares)
+	if err != nil {
+		if summary := utils.DescribeShares(shares); summary != "" {
+			return nil, fmt.Errorf("%w\n%s", err, summary)
+		}
+		return nil, err
+	}
+	return keyBytes, nil
+}
+
+// unlockShares reads share files and asks for the passphrase of each encrypted one in turn, then
+// calls then with the unlocked shares. Nothing is called if a dialog is cancelled.
+func unlockShares(win fyne.Window, paths []string, then func([]*utils.Share)) {
+	shares, err := utils.ReadShareFiles(paths)
+	if err != nil {
+		showError(win, err)
+		return
+	}
+	var next func(i int)
+	next = func(i int) {
+		for i < len(shares) && !shares[i].Locked() {
+			i++
+		}
+		if i == len(shares) {
+			then(shares)
+			return
+		}
+		s := shares[i]
+		if s.TokenSealed() {
+			msg := fmt.Sprintf("Insert the FIDO2 token of %s for share #%d ('%s'), press Unlock, then touch it.\nIts PIN is asked in the terminal the GUI was started from.", s.Custodian(), s.Index, s.Path)
+			dialog.ShowConfirm(fmt.Sprintf("Unlock Share #%d", s.Index), msg, func(ok bool) {
+				if !ok {
+					return
+				}
+				if err := s.Unlock(nil); err != nil {
+					showError(win, fmt.Errorf("failed to unlock share '%s': %w", s.Path, err))
+					return
+				}
+				next(i + 1)
+			}, win)
+			return
+		}
+		passEntry := widget.NewPasswordEntry()
+		items := []*widget.FormItem{
+			{Text: "Custodian", Widget: widget.NewLabel(s.Custodian().String())},
+			{Text: "File", Widget: widget.NewLabel(s.Path)},
+			{Text: "Passphrase", Widget: passEntry},
+		}
+		// Shares wrapped to an age key take the custodian's secret key or identity file instead
+		if r := s.Recipient(); r != "" {
+			items[2] = &widget.FormItem{Text: "Recipient", Widget: widget.NewLabel(r)}
+			items = append(items, &widget.FormItem{Text: "Secret Key or Identity File", Widget: passEntry})
+		}
+		dlg := dialog.NewForm(fmt.Sprintf("Unlock Share #%d", s.Index), "Unlock", "Cancel", items, func(ok bool) {
+			if !ok {
+				return
+			}
+			pass := []byte(passEntry.Text)
+			passEntry.SetText("")
+			err := s.Unlock(pass)
+			secmem.Wipe(pass)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to unlock share '%s': %w", s.Path, err))
+				return
+			}
+			next(i + 1)
+		}, win)
+		dlg.Resize(fyne.NewSize(450, dlg.MinSize().Height))
+		dlg.Show()
+	}
+	next(0)
+}
+
+// newSharePassphrases asks the custodian of each share in paths for a new passphrase in turn, then
+// calls then with them.
+func newSharePassphrases(win fyne.Window, paths []string, custodians []utils.Custodian, then func([][]byte)) {
+	passphrases := make([][]byte, 0, len(paths))
+	var next func()
+	next = func() {
+		i := len(passphrases)
+		if i == len(paths) {
+			then(passphrases)
+			return
+		}
+		title := fmt.Sprintf("Passphrase for Share #%d (%s)", i+1, custodians[i])
+		showNewPassphraseDialog(win, title, func(pass []byte) {
+			passphrases = append(passphrases, pass)
+			next()
+		})
+	}
+	next()
+}
+
+// checkCAProfile enforces the issuing CA's configuration (allowed profiles, validity cap) for profile
+// and returns the certificate options configured for it, such as certificate policies and extended
+// key usages, and its settings.
+func checkCAProfile(caPem, profile string, days int) (int, []utils.CertOption, caconfig.ProfileSettings, error) {
+	cfg, err := caconfig.LoadForCA(caPem)
+	if err != nil {
+		return 0, nil, caconfig.ProfileSettings{}, err
+	}
+	days, err = cfg.CheckIssuance(profile, days, true)
+	if err != nil {
+		return 0, nil, caconfig.ProfileSettings{}, fmt.Errorf("'%s': %w", caPem, err)
+	}
+	settings := cfg.Settings(profile)
+	_, ekus, err := settings.Usages()
+	if err != nil {
+		return 0, nil, caconfig.ProfileSettings{}, fmt.Errorf("profile '%s': %w", profile, err)
+	}
+	opts := []utils.CertOption{utils.WithPolicies(settings.Policies), utils.WithExtKeyUsage(ekus)}
+	if settings.Distribution != nil {
+		d := settings.Distribution.Resolve(caPem)
+		opts = append(opts, utils.WithDistribution(d.CRLURLs, d.CAIssuersURLs, d.OCSPURLs))
+	}
+	return days, opts, settings, nil
+}
+
+// profileSelect offers the leaf profiles of the CA in caPemEntry, refreshed when the CA changes.
+func profileSelect(caPemEntry *widget.Entry) *widget.Select {
+	sel := widget.NewSelect((&caconfig.Config{}).ProfileNames(caconfig.ProfileLeaf), nil)
+	sel.SetSelected(caconfig.ProfileLeaf)
+	caPemEntry.OnChanged = func(caPem string) {
+		cfg, err := caconfig.LoadForCA(caPem)
+		if err != nil {
+			return
+		}
+		selected := sel.Selected
+		sel.Options = cfg.ProfileNames(caconfig.ProfileLeaf)
+		if !slices.Contains(sel.Options, selected) {
+			selected = caconfig.ProfileLeaf
+		}
+		sel.SetSelected(selected)
+		sel.Refresh()
 	}
-	if locality != "" {
-		subject.Locality = []string{locality}
+	return sel
+}
+
+// showNewPassphraseDialog asks for a new passphrase twice and calls onConfirm once both entries match.
+func showNewPassphraseDialog(win fyne.Window, title string, onConfirm func([]byte)) {
+	passEntry := widget.NewPasswordEntry()
+	confirmEntry := widget.NewPasswordEntry()
+	confirmEntry.Validator = func(text string) error {
+		if text != passEntry.Text {
+			return fmt.Errorf("passphrases do not match")
+		}
+		return nil
 	}
-	if province != "" {
-		subject.Province = []string{province}
+	passEntry.Validator = func(text string) error {
+		if text == "" {
+			return fmt.Errorf("passphrase must not be empty")
+		}
+		return nil
 	}
-	if country != "" {
-		subject.Country = []string{country}
+
+	items := []*widget.FormItem{
+		{Text: "Passphrase", Widget: passEntry},
+		{Text: "Confirm", Widget: confirmEntry},
 	}
-	subject.CommonName = cn
-	return subject
+	dlg := dialog.NewForm(title, "OK", "Cancel", items, func(ok bool) {
+		if ok {
+			onConfirm([]byte(passEntry.Text))
+		}
+	}, win)
+	dlg.Resize(fyne.NewSize(400, dlg.MinSize().Height))
+	dlg.Show()
 }
 
-func showError(win fyne.Window, err error) {
-	dialog.ShowError(err, win)
+// newQuorumLabel returns a label that tracks how many of the required shares listed in entry
+// have been loaded, e.g. "2 of 3 shares loaded".
+func newQuorumLabel(entry *widget.Entry) *widget.Label {
+	label := widget.NewLabel("No shares loaded")
+	entry.OnChanged = func(text string) {
+		paths := utils.ParseCommaSeparatedPaths(text)
+		if len(paths) == 0 {
+			label.SetText("No shares loaded")
+			return
+		}
+		shares, err := utils.ReadShareFiles(paths)
+		if err != nil {
+			label.SetText(fmt.Sprintf("Invalid shares: %v", err))
+			return
+		}
+		label.SetText(utils.DescribeQuorum(shares))
+	}
+	return label
 }
 
 func createFileOpenButton(win fyne.Window, label string, targetEntry *widget.Entry) *widget.Button {
@@ -99,23 +404,7 @@ func createFileSaveButton(win fyne.Window, label string, targetEntry *widget.Ent
 
 func createRootTab(win fyne.Window) fyne.CanvasObject {
 	// Subject Fields
-	cnEntry := widget.NewEntry()
-	cnEntry.SetPlaceHolder("e.g. My Root CA")
-
-	orgEntry := widget.NewEntry()
-	orgEntry.SetPlaceHolder("e.g. My Company")
-
-	ouEntry := widget.NewEntry()
-	ouEntry.SetPlaceHolder("e.g. Security Dept.")
-
-	localityEntry := widget.NewEntry()
-	localityEntry.SetPlaceHolder("City")
-
-	provinceEntry := widget.NewEntry()
-	provinceEntry.SetPlaceHolder("State/Province")
-
-	countryEntry := widget.NewEntry()
-	countryEntry.SetPlaceHolder("Country Code (e.g. US)")
+	subjectFields := newSubjectFields("e.g. My Root CA")
 
 	daysEntry := widget.NewEntry()
 	daysEntry.SetText("365")
@@ -136,6 +425,14 @@ func createRootTab(win fyne.Window) fyne.CanvasObject {
 	sharesOutEntry := widget.NewEntry()
 	sharesOutEntry.SetPlaceHolder("Auto-populated after using 'Add File'...")
 
+	custodiansEntry := widget.NewEntry()
+	custodiansEntry.SetPlaceHolder("Optional, one per share (e.g. Alice,Bob,Carol)")
+
+	contactsEntry := widget.NewEntry()
+	contactsEntry.SetPlaceHolder("Optional, one per share (e.g. alice@corp,bob@corp,)")
+
+	encryptSharesCheck := widget.NewCheck("Encrypt each share with its custodian's passphrase", nil)
+
 	pemOutBrowse := createFileSaveButton(win, "Browse (PEM Out)", pemOutEntry)
 
 	sharesOutBrowseBtn := widget.NewButton("Add Share File", func() {
@@ -166,21 +463,18 @@ func createRootTab(win fyne.Window) fyne.CanvasObject {
 
 	// Create form sections
 	subjectForm := &widget.Form{
-		Items: []*widget.FormItem{
-			{Text: "Common Name", Widget: cnEntry},
-			{Text: "Organization", Widget: orgEntry},
-			{Text: "Org Unit", Widget: ouEntry},
-			{Text: "Locality", Widget: localityEntry},
-			{Text: "Province", Widget: provinceEntry},
-			{Text: "Country", Widget: countryEntry},
-			{Text: "Days (Validity)", Widget: daysEntry},
-		},
+		Items: append(subjectFields.formItems(),
+			&widget.FormItem{Text: "Days (Validity)", Widget: daysEntry},
+		),
 	}
 
 	shamirForm := &widget.Form{
 		Items: []*widget.FormItem{
 			{Text: "Number of Shares (n)", Widget: nEntry},
 			{Text: "Threshold (t)", Widget: tEntry},
+			{Text: "Custodians", Widget: custodiansEntry},
+			{Text: "Contacts", Widget: contactsEntry},
+			{Text: "Share Encryption", Widget: encryptSharesCheck},
 		},
 	}
 
@@ -199,10 +493,11 @@ func createRootTab(win fyne.Window) fyne.CanvasObject {
 
 	// Button to create
 	createButton := widget.NewButtonWithIcon("Create Root CA", theme.ConfirmIcon(), func() {
-		subject := createSubjectFromInputs(
-			cnEntry.Text, orgEntry.Text, ouEntry.Text,
-			localityEntry.Text, provinceEntry.Text, countryEntry.Text,
-		)
+		subject, err := subjectFields.subject()
+		if err != nil {
+			showError(win, err)
+			return
+		}
 
 		days, err := strconv.Atoi(daysEntry.Text)
 		if err != nil {
@@ -231,36 +526,64 @@ func createRootTab(win fyne.Window) fyne.CanvasObject {
 			showError(win, fmt.Errorf("number of share paths must equal n=%d", n))
 			return
 		}
-
-		// Generate
-		ku := x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
-		certPEM, privKey, err := utils.GenerateKeyAndCert(subject, nil, nil, true, days, ku)
+		custodians, err := utils.ParseCustodians(custodiansEntry.Text, contactsEntry.Text, n)
 		if err != nil {
-			showError(win, fmt.Errorf("failed to generate root CA: %w", err))
+			showError(win, err)
 			return
 		}
 
-		// Write certificate
-		err = utils.WriteCertificateToFile(certPEM, pemOutEntry.Text)
-		if err != nil {
-			showError(win, fmt.Errorf("failed to write root CA cert: %w", err))
-			return
-		}
+		create := func(passphrases [][]byte) {
+			// Generate
+			subject = utils.DefaultNormalization.Subject(subject)
+			ku := x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+			certPEM, privKey, err := utils.GenerateKeyAndCert(subject, nil, nil, true, days, ku)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to generate root CA: %w", err))
+				return
+			}
+			defer secmem.WipeKey(privKey)
 
-		// Split the key with Shamir
-		err = utils.SplitKeyAndWriteShares(privKey, n, t, sharePaths)
-		if err != nil {
-			showError(win, fmt.Errorf("failed to split key: %w", err))
-			return
-		}
+			// Record the root certificate as the first entry of its issuance log
+			err = ctlog.AppendCertificatePEM(ctlog.PathForCA(pemOutEntry.Text), certPEM, privKey, time.Now())
+			if err != nil {
+				showError(win, fmt.Errorf("failed to record issuance: %w", err))
+				return
+			}
 
-		dialog.ShowInformation(
-			"Success",
-			fmt.Sprintf("Root CA created!\nCert: %s\n%d shares written.", pemOutEntry.Text, n),
-			win,
-		)
+			// Write certificate
+			err = utils.WriteCertificateToFile(certPEM, pemOutEntry.Text)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to write root CA cert: %w", err))
+				return
+			}
+			resume.step(tabRoot, fmt.Sprintf("root certificate written to %s, shares not yet written", pemOutEntry.Text))
+
+			// Split the key with Shamir
+			err = utils.SplitKeyAndWriteShares(privKey, n, t, sharePaths, custodians, passphrases)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to split key: %w", err))
+				return
+			}
+
+			resume.done(tabRoot)
+			showSuccess(win, fmt.Sprintf("Root CA created!\nCert: %s\n%d shares written.", pemOutEntry.Text, n))
+		}
+		if encryptSharesCheck.Checked {
+			newSharePassphrases(win, sharePaths, custodians, create)
+		} else {
+			create(nil)
+		}
 	})
 
+	subjectFields.persist(tabRoot)
+	for field, e := range map[string]*widget.Entry{
+		"Days": daysEntry, "n": nEntry, "t": tEntry, "Custodians": custodiansEntry, "Contacts": contactsEntry,
+		"Shares Out": sharesOutEntry, "PEM Out": pemOutEntry,
+	} {
+		resume.entry(tabRoot, field, e)
+	}
+	resume.check(tabRoot, "Encrypt Shares", encryptSharesCheck)
+
 	// Use cards or group containers
 	subjectCard := widget.NewCard("Subject Information", "Fill out the certificate details", subjectForm)
 	shamirCard := widget.NewCard("Shamir Parameters", "Threshold & shares for private key splitting", shamirForm)
@@ -282,14 +605,7 @@ func createRootTab(win fyne.Window) fyne.CanvasObject {
 
 func createSubCATab(win fyne.Window) fyne.CanvasObject {
 	// Subject fields
-	cnEntry := widget.NewEntry()
-	cnEntry.SetPlaceHolder("e.g. My SubCA")
-
-	orgEntry := widget.NewEntry()
-	ouEntry := widget.NewEntry()
-	localityEntry := widget.NewEntry()
-	provinceEntry := widget.NewEntry()
-	countryEntry := widget.NewEntry()
+	subjectFields := newSubjectFields("e.g. My SubCA")
 
 	daysEntry := widget.NewEntry()
 	daysEntry.SetText("365")
@@ -302,6 +618,7 @@ func createSubCATab(win fyne.Window) fyne.CanvasObject {
 
 	parentSharesEntry := widget.NewEntry()
 	parentSharesEntry.SetPlaceHolder("Parent CA key share files (comma-separated)")
+	parentQuorumLabel := newQuorumLabel(parentSharesEntry)
 
 	addParentShareBtn := widget.NewButton("Add Parent Share", func() {
 		dlg := dialog.NewFileOpen(
@@ -337,6 +654,14 @@ func createSubCATab(win fyne.Window) fyne.CanvasObject {
 	sharesOutEntry := widget.NewEntry()
 	sharesOutEntry.SetPlaceHolder("SubCA key shares will be saved here...")
 
+	custodiansEntry := widget.NewEntry()
+	custodiansEntry.SetPlaceHolder("Optional, one per share (e.g. Alice,Bob,Carol)")
+
+	contactsEntry := widget.NewEntry()
+	contactsEntry.SetPlaceHolder("Optional, one per share")
+
+	encryptSharesCheck := widget.NewCheck("Encrypt each share with its custodian's passphrase", nil)
+
 	addSubShareBtn := widget.NewButton("Add Share Out (SubCA)", func() {
 		dlg := dialog.NewFileSave(
 			func(writer fyne.URIWriteCloser, err error) {
@@ -368,15 +693,9 @@ func createSubCATab(win fyne.Window) fyne.CanvasObject {
 
 	// Sections
 	subjectForm := &widget.Form{
-		Items: []*widget.FormItem{
-			{Text: "Common Name", Widget: cnEntry},
-			{Text: "Organization", Widget: orgEntry},
-			{Text: "Org Unit", Widget: ouEntry},
-			{Text: "Locality", Widget: localityEntry},
-			{Text: "Province", Widget: provinceEntry},
-			{Text: "Country", Widget: countryEntry},
-			{Text: "Days (Validity)", Widget: daysEntry},
-		},
+		Items: append(subjectFields.formItems(),
+			&widget.FormItem{Text: "Days (Validity)", Widget: daysEntry},
+		),
 	}
 
 	parentForm := &widget.Form{
@@ -389,6 +708,7 @@ func createSubCATab(win fyne.Window) fyne.CanvasObject {
 				Text:   "Parent Shares",
 				Widget: container.NewBorder(nil, nil, nil, addParentShareBtn, parentSharesEntry),
 			},
+			{Text: "Quorum", Widget: parentQuorumLabel},
 		},
 	}
 
@@ -400,6 +720,9 @@ func createSubCATab(win fyne.Window) fyne.CanvasObject {
 				Text:   "SubCA Shares Out",
 				Widget: container.NewBorder(nil, nil, nil, addSubShareBtn, sharesOutEntry),
 			},
+			{Text: "Custodians", Widget: custodiansEntry},
+			{Text: "Contacts", Widget: contactsEntry},
+			{Text: "Share Encryption", Widget: encryptSharesCheck},
 		},
 	}
 
@@ -413,10 +736,11 @@ func createSubCATab(win fyne.Window) fyne.CanvasObject {
 	}
 
 	createButton := widget.NewButtonWithIcon("Create SubCA", theme.ConfirmIcon(), func() {
-		subject := createSubjectFromInputs(
-			cnEntry.Text, orgEntry.Text, ouEntry.Text,
-			localityEntry.Text, provinceEntry.Text, countryEntry.Text,
-		)
+		subject, err := subjectFields.subject()
+		if err != nil {
+			showError(win, err)
+			return
+		}
 
 		days, err := strconv.Atoi(daysEntry.Text)
 		if err != nil {
@@ -434,43 +758,18 @@ func createSubCATab(win fyne.Window) fyne.CanvasObject {
 			showError(win, fmt.Errorf("failed to parse parent cert: %w", err))
 			return
 		}
-
-		// Combine parent shares
-		parentSharePaths := strings.Split(strings.TrimSpace(parentSharesEntry.Text), ",")
-		if len(parentSharePaths) == 0 {
-			showError(win, fmt.Errorf("no parent shares selected"))
-			return
-		}
-		parentKeyBytes, err := utils.CombineSharesFromFiles(parentSharePaths)
+		days, opts, settings, err := checkCAProfile(parentPemEntry.Text, caconfig.ProfileSubCA, days)
 		if err != nil {
-			showError(win, fmt.Errorf("failed to combine parent shares: %w", err))
-			return
-		}
-		parentKey, err := x509.ParseECPrivateKey(parentKeyBytes)
-		if err != nil {
-			showError(win, fmt.Errorf("failed to parse parent key: %w", err))
-			return
-		}
-
-		// Generate SubCA
-		ku := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
-		subCertPEM, subKey, err := utils.GenerateKeyAndCert(subject, parentCert, parentKey, true, days, ku)
-		if err != nil {
-			showError(win, fmt.Errorf("failed to generate subCA: %w", err))
+			showError(win, err)
 			return
 		}
+		subject = settings.Normalization().Subject(subject)
 
-		if pemOutEntry.Text == "" {
-			showError(win, fmt.Errorf("must specify output path for subCA cert"))
-			return
-		}
-		err = utils.WriteCertificateToFile(subCertPEM, pemOutEntry.Text)
-		if err != nil {
-			showError(win, fmt.Errorf("failed to write subCA cert: %w", err))
+		parentSharePaths := strings.Split(strings.TrimSpace(parentSharesEntry.Text), ",")
+		if len(parentSharePaths) == 0 {
+			showError(win, fmt.Errorf("no parent shares selected"))
 			return
 		}
-
-		// Shamir split
 		n, err := strconv.Atoi(nEntry.Text)
 		if err != nil {
 			showError(win, fmt.Errorf("invalid n: %w", err))
@@ -486,22 +785,87 @@ func createSubCATab(win fyne.Window) fyne.CanvasObject {
 			showError(win, fmt.Errorf("number of share files must match n=%d", n))
 			return
 		}
-		err = utils.SplitKeyAndWriteShares(subKey, n, t, subSharePaths)
+		custodians, err := utils.ParseCustodians(custodiansEntry.Text, contactsEntry.Text, n)
 		if err != nil {
-			showError(win, fmt.Errorf("failed to split subCA key: %w", err))
+			showError(win, err)
 			return
 		}
 
-		dialog.ShowInformation(
-			"Success",
-			fmt.Sprintf("SubCA created!\nCert: %s\nIssuing: %v\n%d shares written.",
+		create := func(parentShares []*utils.Share, passphrases [][]byte) {
+			// Combine parent shares
+			parentKeyBytes, err := combineShares(parentShares)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to combine parent shares: %w", err))
+				return
+			}
+			defer secmem.Wipe(parentKeyBytes)
+			parentKey, err := utils.ParsePrivateKeyDER(parentKeyBytes)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to parse parent key: %w", err))
+				return
+			}
+			defer secmem.WipeKey(parentKey)
+
+			// Generate SubCA
+			ku := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+			subCertPEM, subKey, err := utils.GenerateKeyAndCert(subject, parentCert, parentKey, true, days, ku, opts...)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to generate subCA: %w", err))
+				return
+			}
+			defer secmem.WipeKey(subKey)
+
+			if pemOutEntry.Text == "" {
+				showError(win, fmt.Errorf("must specify output path for subCA cert"))
+				return
+			}
+			err = ctlog.AppendCertificatePEM(ctlog.PathForCA(parentPemEntry.Text), subCertPEM, parentKey, time.Now())
+			if err != nil {
+				showError(win, fmt.Errorf("failed to record issuance: %w", err))
+				return
+			}
+			err = utils.WriteCertificateToFile(subCertPEM, pemOutEntry.Text)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to write subCA cert: %w", err))
+				return
+			}
+			resume.step(tabSubCA, fmt.Sprintf("SubCA certificate written to %s, shares not yet written", pemOutEntry.Text))
+
+			// Shamir split
+			err = utils.SplitKeyAndWriteShares(subKey, n, t, subSharePaths, custodians, passphrases)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to split subCA key: %w", err))
+				return
+			}
+
+			resume.done(tabSubCA)
+			showSuccess(win, fmt.Sprintf("SubCA created!\nCert: %s\nIssuing: %v\n%d shares written.",
 				pemOutEntry.Text,
 				issuingCheck.Checked,
-				n),
-			win,
-		)
+				n))
+		}
+		// Parent custodians unlock their shares first, then the subCA custodians choose passphrases
+		unlockShares(win, parentSharePaths, func(parentShares []*utils.Share) {
+			if !encryptSharesCheck.Checked {
+				create(parentShares, nil)
+				return
+			}
+			newSharePassphrases(win, subSharePaths, custodians, func(passphrases [][]byte) {
+				create(parentShares, passphrases)
+			})
+		})
 	})
 
+	subjectFields.persist(tabSubCA)
+	for field, e := range map[string]*widget.Entry{
+		"Days": daysEntry, "Parent PEM": parentPemEntry, "Parent Shares": parentSharesEntry, "n": nEntry, "t": tEntry,
+		"Custodians": custodiansEntry, "Contacts": contactsEntry, "Shares Out": sharesOutEntry, "PEM Out": pemOutEntry,
+	} {
+		resume.entry(tabSubCA, field, e)
+	}
+	resume.check(tabSubCA, "Issuing", issuingCheck)
+	resume.check(tabSubCA, "Encrypt Shares", encryptSharesCheck)
+
 	subjectCard := widget.NewCard("Subject Information", "SubCA certificate details", subjectForm)
 	parentCard := widget.NewCard("Parent CA", "Existing CA certificate and shares", parentForm)
 	shamirCard := widget.NewCard("Shamir Parameters", "", shamirForm)
@@ -524,14 +888,7 @@ func createSubCATab(win fyne.Window) fyne.CanvasObject {
 
 func signTab(win fyne.Window) fyne.CanvasObject {
 	// Subject fields
-	cnEntry := widget.NewEntry()
-	cnEntry.SetPlaceHolder("Leaf certificate CN (e.g. myserver.local)")
-
-	orgEntry := widget.NewEntry()
-	ouEntry := widget.NewEntry()
-	localityEntry := widget.NewEntry()
-	provinceEntry := widget.NewEntry()
-	countryEntry := widget.NewEntry()
+	subjectFields := newSubjectFields("Leaf certificate CN (e.g. myserver.local)")
 
 	daysEntry := widget.NewEntry()
 	daysEntry.SetText("365")
@@ -542,6 +899,7 @@ func signTab(win fyne.Window) fyne.CanvasObject {
 
 	sharesInEntry := widget.NewEntry()
 	sharesInEntry.SetPlaceHolder("Select parent CA key shares...")
+	quorumLabel := newQuorumLabel(sharesInEntry)
 
 	addShareBtn := widget.NewButton("Add CA Share", func() {
 		dlg := dialog.NewFileOpen(
@@ -577,24 +935,22 @@ func signTab(win fyne.Window) fyne.CanvasObject {
 	keyOutEntry.SetPlaceHolder("Where to save the private key (optional)")
 	keyOutBrowse := createFileSaveButton(win, "Browse (Leaf Key Out)", keyOutEntry)
 
-	// KeyUsage checkboxes
-	dsCheck := widget.NewCheck("Digital Signature", nil)
-	keCheck := widget.NewCheck("Key Encipherment", nil)
-	deCheck := widget.NewCheck("Data Encipherment", nil)
-	kaCheck := widget.NewCheck("Key Agreement", nil)
-	crlCheck := widget.NewCheck("CRL Sign", nil)
-	eoCheck := widget.NewCheck("Encipher Only", nil)
-	doCheck := widget.NewCheck("Decipher Only", nil)
+	keyFormatSelect := widget.NewSelect([]string{utils.KeyFormatSEC1, utils.KeyFormatPKCS8}, nil)
+	keyFormatSelect.SetSelected(utils.KeyFormatSEC1)
 
-	signButton := widget.NewButtonWithIcon("Sign Leaf Certificate", theme.ConfirmIcon(), func() {
-		subject := createSubjectFromInputs(
-			cnEntry.Text,
-			orgEntry.Text,
-			ouEntry.Text,
-			localityEntry.Text,
-			provinceEntry.Text,
-			countryEntry.Text,
-		)
+	usageChecks := newKeyUsageChecks()
+	profileSel := profileSelect(caPemEntry)
+	sanEntry := widget.NewEntry()
+	sanEntry.SetPlaceHolder("Optional, e.g. www.example.com; 10.0.0.1")
+
+	encryptKeyCheck := widget.NewCheck("Encrypt with passphrase", nil)
+
+	signLeaf := func(keyPass []byte) {
+		subject, err := subjectFields.subject()
+		if err != nil {
+			showError(win, err)
+			return
+		}
 
 		days, err := strconv.Atoi(daysEntry.Text)
 		if err != nil {
@@ -610,91 +966,127 @@ func signTab(win fyne.Window) fyne.CanvasObject {
 			showError(win, fmt.Errorf("failed to parse CA cert: %w", err))
 			return
 		}
-
-		sharePaths := strings.Split(strings.TrimSpace(sharesInEntry.Text), ",")
-		if len(sharePaths) == 0 {
-			showError(win, fmt.Errorf("no CA key shares selected"))
+		profile := profileSel.Selected
+		days, opts, settings, err := checkCAProfile(caPemEntry.Text, profile, days)
+		if err != nil {
+			showError(win, err)
 			return
 		}
-		caKeyBytes, err := utils.CombineSharesFromFiles(sharePaths)
-		if err != nil {
-			showError(win, fmt.Errorf("failed to combine CA shares: %w", err))
+		if settings.Kind != caconfig.ProfileLeaf {
+			showError(win, fmt.Errorf("profile '%s' issues CA certificates; use the SubCA tab", profile))
 			return
 		}
-		caKey, err := x509.ParseECPrivateKey(caKeyBytes)
+		ku, err := settings.KeyUsageFor(usageChecks.usage())
 		if err != nil {
-			showError(win, fmt.Errorf("failed to parse CA key: %w", err))
+			showError(win, fmt.Errorf("profile '%s': %w", profile, err))
 			return
 		}
-
-		// Build KeyUsage
-		var ku x509.KeyUsage
-		if dsCheck.Checked {
-			ku |= x509.KeyUsageDigitalSignature
-		}
-		if keCheck.Checked {
-			ku |= x509.KeyUsageKeyEncipherment
-		}
-		if deCheck.Checked {
-			ku |= x509.KeyUsageDataEncipherment
-		}
-		if kaCheck.Checked {
-			ku |= x509.KeyUsageKeyAgreement
-		}
-		if crlCheck.Checked {
-			ku |= x509.KeyUsageCRLSign
-		}
-		if eoCheck.Checked {
-			ku |= x509.KeyUsageEncipherOnly
-		}
-		if doCheck.Checked {
-			ku |= x509.KeyUsageDecipherOnly
-		}
-
-		// Generate & sign leaf
-		certPEM, leafKey, err := utils.GenerateKeyAndCert(subject, caCert, caKey, false, days, ku)
+		subject = settings.Normalization().Subject(subject)
+		sans, err := utils.ParseSANs(strings.Split(sanEntry.Text, ";"))
 		if err != nil {
-			showError(win, fmt.Errorf("failed to sign leaf: %w", err))
+			showError(win, err)
 			return
 		}
-
-		if certOutEntry.Text == "" {
-			showError(win, fmt.Errorf("missing leaf cert output path"))
+		sans = settings.Normalization().SANs(sans)
+		if err := settings.CheckSANs(sans); err != nil {
+			showError(win, fmt.Errorf("profile '%s': %w", profile, err))
 			return
 		}
-		err = utils.WriteCertificateToFile(certPEM, certOutEntry.Text)
-		if err != nil {
-			showError(win, fmt.Errorf("failed to write leaf cert: %w", err))
+		opts = append(opts, utils.WithSANs(sans))
+
+		sharePaths := strings.Split(strings.TrimSpace(sharesInEntry.Text), ",")
+		if len(sharePaths) == 0 {
+			showError(win, fmt.Errorf("no CA key shares selected"))
 			return
 		}
+		unlockShares(win, sharePaths, func(shares []*utils.Share) {
+			caKeyBytes, err := combineShares(shares)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to combine CA shares: %w", err))
+				return
+			}
+			defer secmem.Wipe(caKeyBytes)
+			caKey, err := utils.ParsePrivateKeyDER(caKeyBytes)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to parse CA key: %w", err))
+				return
+			}
+			defer secmem.WipeKey(caKey)
 
-		if keyOutEntry.Text != "" {
-			err := utils.WriteECPrivateKeyToFile(leafKey, keyOutEntry.Text)
+			// Generate a key of the profile's algorithm & sign leaf
+			leafKey, err := utils.GenerateKey(settings.KeyAlgorithm)
 			if err != nil {
-				showError(win, fmt.Errorf("failed to write leaf key: %w", err))
+				showError(win, err)
+				return
+			}
+			certPEM, err := utils.SignPublicKey(subject, &leafKey.PublicKey, caCert, caKey, false, days, ku, opts...)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to sign leaf: %w", err))
 				return
 			}
-		}
 
-		dialog.ShowInformation(
-			"Success",
-			fmt.Sprintf("Leaf cert written to: %s\nLeaf key written to: %s",
-				certOutEntry.Text, keyOutEntry.Text),
-			win,
-		)
+			if certOutEntry.Text == "" {
+				showError(win, fmt.Errorf("missing leaf cert output path"))
+				return
+			}
+			err = ctlog.AppendCertificatePEM(ctlog.PathForCA(caPemEntry.Text), certPEM, caKey, time.Now())
+			if err != nil {
+				showError(win, fmt.Errorf("failed to record issuance: %w", err))
+				return
+			}
+			err = utils.WriteCertificateToFile(certPEM, certOutEntry.Text)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to write leaf cert: %w", err))
+				return
+			}
+			if keyOutEntry.Text != "" {
+				resume.step(tabSign, fmt.Sprintf("leaf certificate written to %s, key not yet written", certOutEntry.Text))
+			}
+
+			if keyOutEntry.Text != "" {
+				if keyPass != nil {
+					err = utils.WriteEncryptedPrivateKeyToFile(leafKey, keyOutEntry.Text, keyPass)
+				} else {
+					err = utils.WritePrivateKeyToFile(leafKey, keyOutEntry.Text, keyFormatSelect.Selected)
+				}
+				if err != nil {
+					showError(win, fmt.Errorf("failed to write leaf key: %w", err))
+					return
+				}
+			}
+
+			resume.done(tabSign)
+			showSuccess(win, fmt.Sprintf("Leaf cert written to: %s\nLeaf key written to: %s",
+				certOutEntry.Text, keyOutEntry.Text))
+		})
+	}
+
+	signButton := widget.NewButtonWithIcon("Sign Leaf Certificate", theme.ConfirmIcon(), func() {
+		if encryptKeyCheck.Checked && keyOutEntry.Text != "" {
+			showNewPassphraseDialog(win, "Leaf Key Passphrase", signLeaf)
+			return
+		}
+		signLeaf(nil)
 	})
 
+	subjectFields.persist(tabSign)
+	for field, e := range map[string]*widget.Entry{
+		"Days": daysEntry, "CA PEM": caPemEntry, "CA Shares": sharesInEntry, "Cert Out": certOutEntry, "Key Out": keyOutEntry,
+	} {
+		resume.entry(tabSign, field, e)
+	}
+	resume.entry(tabSign, "SANs", sanEntry)
+	resume.choice(tabSign, "Key Format", keyFormatSelect)
+	resume.choice(tabSign, "Profile", profileSel)
+	resume.check(tabSign, "Encrypt Key", encryptKeyCheck)
+	usageChecks.persist(tabSign)
+
 	// Build forms
 	subjectForm := &widget.Form{
-		Items: []*widget.FormItem{
-			{Text: "Common Name", Widget: cnEntry},
-			{Text: "Organization", Widget: orgEntry},
-			{Text: "Org Unit", Widget: ouEntry},
-			{Text: "Locality", Widget: localityEntry},
-			{Text: "Province", Widget: provinceEntry},
-			{Text: "Country", Widget: countryEntry},
-			{Text: "Days (Validity)", Widget: daysEntry},
-		},
+		Items: append(subjectFields.formItems(),
+			&widget.FormItem{Text: "SANs", Widget: sanEntry},
+			&widget.FormItem{Text: "Days (Validity)", Widget: daysEntry},
+		),
 	}
 
 	caForm := &widget.Form{
@@ -703,10 +1095,12 @@ func signTab(win fyne.Window) fyne.CanvasObject {
 				Text:   "CA PEM",
 				Widget: container.NewBorder(nil, nil, nil, caPemBrowse, caPemEntry),
 			},
+			{Text: "Profile", Widget: profileSel},
 			{
 				Text:   "CA Key Shares",
 				Widget: container.NewBorder(nil, nil, nil, addShareBtn, sharesInEntry),
 			},
+			{Text: "Quorum", Widget: quorumLabel},
 		},
 	}
 
@@ -720,17 +1114,15 @@ func signTab(win fyne.Window) fyne.CanvasObject {
 				Text:   "Leaf Key Out",
 				Widget: container.NewBorder(nil, nil, nil, keyOutBrowse, keyOutEntry),
 			},
+			{Text: "Leaf Key Format", Widget: keyFormatSelect},
+			{Text: "Leaf Key Protection", Widget: encryptKeyCheck},
 		},
 	}
 
-	usageCard := widget.NewCard("Key Usage", "Select the key usages to enable",
-		container.NewVBox(dsCheck, keCheck, deCheck, kaCheck, crlCheck, eoCheck, doCheck),
-	)
-
 	content := container.NewVBox(
 		widget.NewCard("Leaf Certificate Subject", "", subjectForm),
 		widget.NewCard("Parent CA Information", "", caForm),
-		usageCard,
+		usageChecks.card(),
 		widget.NewCard("Output Files", "", outForm),
 		signButton,
 	)
@@ -742,31 +1134,60 @@ func signTab(win fyne.Window) fyne.CanvasObject {
 // Main
 // -------------------------------------------------------------------------------------
 
+// envOr returns the environment variable name, or def if it is unset.
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
 func main() {
-	// Disable or redirect logs
-	log.SetOutput(io.Discard)
+	// Keep logs for the session log tab instead of discarding them
+	if err := logging.Setup(session, envOr("GOSEC_LOG_LEVEL", "info"), envOr("GOSEC_LOG_FORMAT", logging.FormatText)); err != nil {
+		_ = logging.Setup(session, "info", logging.FormatText)
+		slog.Warn("invalid logging settings; using info and text", "err", err)
+	}
+
+	// Keys and shares stay out of swap and core dumps where the platform allows it
+	if err := secmem.Enable(); err != nil {
+		slog.Warn("memory protection unavailable", "err", err)
+	}
 
 	// Create the Fyne app
 	a := app.NewWithID("com.mkarten.gosec")
 
-	// (Optional) Use a built-in or custom theme
-	// a.Settings().SetTheme(theme.DarkTheme())
+	// Text scale and contrast chosen by the operator
+	disp := newDisplay(a)
 
 	w := a.NewWindow("GoSec PKI Tool")
 	w.Resize(fyne.NewSize(720, 800))
 
 	// Create tabs
-	rootTab := container.NewTabItem("Create Root CA", createRootTab(w))
-	subCATab := container.NewTabItem("Create SubCA", createSubCATab(w))
-	signTabItem := container.NewTabItem("Sign Leaf", signTab(w))
+	resume.open()
+	rootTab := container.NewTabItem(tabRoot, createRootTab(w))
+	subCATab := container.NewTabItem(tabSubCA, createSubCATab(w))
+	signTabItem := container.NewTabItem(tabSign, signTab(w))
+	signCSRTabItem := container.NewTabItem(tabSignCSR, signCSRTab(w))
+	inboxTabItem := container.NewTabItem(tabInbox, inboxTab(w))
+	revocationTabItem := container.NewTabItem("Revocation", revocationTab(w))
+	logTab := container.NewTabItem("Session Log", sessionLogTab(w))
 
 	tabs := container.NewAppTabs(
 		rootTab,
 		subCATab,
 		signTabItem,
+		signCSRTabItem,
+		inboxTabItem,
+		revocationTabItem,
+		logTab,
 	)
 	tabs.SetTabLocation(container.TabLocationTop)
+	tabs.OnSelected = func(item *container.TabItem) { resume.selectTab(item.Text) }
 
 	w.SetContent(tabs)
+	disp.install(w, tabs)
+	// Offer to pick up an interrupted ceremony where it stopped
+	resume.offer(w, tabs)
 	w.ShowAndRun()
 }
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"my-pki/internal/ctlog"
+	"my-pki/internal/inventory"
+	"my-pki/internal/queue"
+	"my-pki/internal/secmem"
+	"my-pki/internal/utils"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// -------------------------------------------------------------------------------------
+// Approval Inbox Tab
+// -------------------------------------------------------------------------------------
+
+// inboxTab lists the pending requests of the request queue with their CSR and how they fare
+// against the issuing CA's profile, and approves or denies them as requests approve and deny do.
+func inboxTab(win fyne.Window) fyne.CanvasObject {
+	queueEntry := widget.NewEntry()
+	queueEntry.SetText(queue.DefaultDir)
+
+	dbEntry := widget.NewEntry()
+	dbEntry.SetText(inventory.Default())
+	dbBrowse := createFileOpenButton(win, "Browse (Inventory)", dbEntry)
+
+	operatorEntry := widget.NewEntry()
+	if u, err := user.Current(); err == nil {
+		operatorEntry.SetText(u.Username)
+	}
+
+	caPemEntry := widget.NewEntry()
+	caPemEntry.SetPlaceHolder("Select the signing CA PEM")
+	caPemBrowse := createFileOpenButton(win, "Browse (CA PEM)", caPemEntry)
+	profileSel := profileSelect(caPemEntry)
+	daysEntry := widget.NewEntry()
+	daysEntry.SetText("365")
+
+	sharesInEntry := widget.NewEntry()
+	sharesInEntry.SetPlaceHolder("Select signing CA key shares...")
+	quorumLabel := newQuorumLabel(sharesInEntry)
+	addShareBtn := widget.NewButton("Add CA Share", func() {
+		dlg := dialog.NewFileOpen(
+			func(reader fyne.URIReadCloser, err error) {
+				if err != nil {
+					showError(win, err)
+					return
+				}
+				if reader == nil {
+					return
+				}
+				newPath := reader.URI().Path()
+				_ = reader.Close()
+
+				existing := sharesInEntry.Text
+				if existing == "" {
+					sharesInEntry.SetText(newPath)
+				} else {
+					sharesInEntry.SetText(existing + "," + newPath)
+				}
+			},
+			win,
+		)
+		dlg.Show()
+	})
+
+	commentEntry := widget.NewMultiLineEntry()
+	commentEntry.SetPlaceHolder("Comment for the requester (required to deny)")
+	commentEntry.SetMinRowsVisible(2)
+
+	// The queue is read again before every decision, so the CLI can be used alongside
+	var pending []*queue.Request
+	selected := -1
+	list := widget.NewList(
+		func() int { return len(pending) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			req := pending[i]
+			o.(*widget.Label).SetText(fmt.Sprintf("%s  %s  from %s, %s", req.ID, req.Subject, req.Requester, req.Submitted.Local().Format("2006-01-02 15:04")))
+		},
+	)
+	details := widget.NewMultiLineEntry()
+	details.Wrapping = fyne.TextWrapWord
+	details.SetMinRowsVisible(12)
+	details.Disable()
+	details.SetText("Load the queue and select a request")
+
+	// show describes the selected request and evaluates its CSR against the chosen CA and profile
+	show := func() {
+		if selected < 0 {
+			return
+		}
+		req := pending[selected]
+		lines := []string{
+			"Request: " + req.ID,
+			"Submitted: " + req.Submitted.Local().Format(time.RFC1123) + " by " + req.Requester,
+		}
+		if req.Note != "" {
+			lines = append(lines, "Note: "+req.Note)
+		}
+		csr, err := utils.ReadCSRFile(queue.Open(queueEntry.Text).CSRPath(req.ID))
+		if err != nil {
+			details.SetText(strings.Join(append(lines, err.Error()), "\n"))
+			return
+		}
+		lines = append(lines, "", "CSR:")
+		lines = append(lines, utils.DescribeCSR(csr)...)
+		lines = append(lines, "", "Policy:")
+		for _, check := range evaluateRequest(csr, caPemEntry.Text, profileSel.Selected, daysEntry.Text) {
+			lines = append(lines, " - "+check)
+		}
+		details.SetText(strings.Join(lines, "\n"))
+	}
+	onCAChanged := caPemEntry.OnChanged
+	caPemEntry.OnChanged = func(caPem string) {
+		onCAChanged(caPem)
+		show()
+	}
+	profileSel.OnChanged = func(string) { show() }
+	daysEntry.OnChanged = func(string) { show() }
+
+	load := func() error {
+		reqs, err := queue.Open(queueEntry.Text).List()
+		if err != nil {
+			return err
+		}
+		pending = pending[:0]
+		for _, req := range reqs {
+			if req.Status == queue.StatusPending {
+				pending = append(pending, req)
+			}
+		}
+		selected = -1
+		list.UnselectAll()
+		list.Refresh()
+		details.SetText(fmt.Sprintf("%d pending request(s) in %s", len(pending), queueEntry.Text))
+		return nil
+	}
+	loadButton := widget.NewButtonWithIcon("Load", theme.ViewRefreshIcon(), func() {
+		if err := load(); err != nil {
+			showError(win, fmt.Errorf("failed to load request queue: %w", err))
+		}
+	})
+	list.OnSelected = func(i widget.ListItemID) {
+		selected = i
+		show()
+	}
+
+	// current re-reads the selected request, which must still be pending
+	current := func() (*queue.Store, *queue.Request, error) {
+		if selected < 0 {
+			return nil, nil, fmt.Errorf("no request selected")
+		}
+		if strings.TrimSpace(operatorEntry.Text) == "" {
+			return nil, nil, fmt.Errorf("missing operator name")
+		}
+		store := queue.Open(queueEntry.Text)
+		req, err := store.Get(pending[selected].ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if req.Status != queue.StatusPending {
+			return nil, nil, fmt.Errorf("request '%s' is already %s", req.ID, req.Status)
+		}
+		return store, req, nil
+	}
+
+	approveButton := widget.NewButtonWithIcon("Approve and Sign", theme.ConfirmIcon(), func() {
+		store, req, err := current()
+		if err != nil {
+			showError(win, err)
+			return
+		}
+		csr, err := utils.ReadCSRFile(store.CSRPath(req.ID))
+		if err != nil {
+			showError(win, err)
+			return
+		}
+		if err := csr.CheckSignature(); err != nil {
+			showError(win, fmt.Errorf("CSR signature is invalid: %w", err))
+			return
+		}
+		if caPemEntry.Text == "" {
+			showError(win, fmt.Errorf("missing CA PEM path"))
+			return
+		}
+		days, err := strconv.Atoi(daysEntry.Text)
+		if err != nil {
+			showError(win, fmt.Errorf("invalid days: %w", err))
+			return
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPemEntry.Text)
+		if err != nil {
+			showError(win, fmt.Errorf("failed to parse CA cert: %w", err))
+			return
+		}
+		profile := profileSel.Selected
+		days, opts, settings, err := checkCAProfile(caPemEntry.Text, profile, days)
+		if err != nil {
+			showError(win, err)
+			return
+		}
+		norm := settings.Normalization()
+		subject, sans := norm.Subject(csr.Subject), norm.SANs(utils.SANsFromCSR(csr))
+		if err := settings.CheckSANs(sans); err != nil {
+			showError(win, fmt.Errorf("request '%s' does not fit profile '%s': %w", req.ID, profile, err))
+			return
+		}
+		if err := utils.CheckKeyAlgorithm(csr.PublicKey, settings.KeyAlgorithm); err != nil {
+			showError(win, fmt.Errorf("request '%s' does not fit profile '%s': %w", req.ID, profile, err))
+			return
+		}
+		ku, _, err := settings.Usages()
+		if err != nil {
+			showError(win, fmt.Errorf("profile '%s': %w", profile, err))
+			return
+		}
+		if ku == 0 {
+			ku = x509.KeyUsageDigitalSignature
+		}
+		opts = append(opts, utils.WithSANs(sans))
+		operator, comment := strings.TrimSpace(operatorEntry.Text), strings.TrimSpace(commentEntry.Text)
+
+		sharePaths := strings.Split(strings.TrimSpace(sharesInEntry.Text), ",")
+		unlockShares(win, sharePaths, func(shares []*utils.Share) {
+			caKeyBytes, err := combineShares(shares)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to combine CA shares: %w", err))
+				return
+			}
+			defer secmem.Wipe(caKeyBytes)
+			caKey, err := utils.ParsePrivateKeyDER(caKeyBytes)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to parse CA key: %w", err))
+				return
+			}
+			defer secmem.WipeKey(caKey)
+			if !caKey.PublicKey.Equal(caCert.PublicKey) {
+				showError(win, fmt.Errorf("the shares do not reconstruct the key of this CA"))
+				return
+			}
+
+			certPEM, err := utils.SignPublicKey(subject, csr.PublicKey, caCert, caKey, false, days, ku, opts...)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to sign request '%s': %w", req.ID, err))
+				return
+			}
+			block, _ := pem.Decode(certPEM)
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to parse the signed certificate: %w", err))
+				return
+			}
+			// Checked, logged and recorded under the inventory lock, as the CLI does
+			now := time.Now()
+			err = inventory.Update(dbEntry.Text, func(db *inventory.DB) error {
+				if rec := db.SerialCollision(cert, caCert); rec != nil {
+					return fmt.Errorf("serial number %s was already issued to %s; refusing to issue a duplicate", rec.Serial, rec.Subject)
+				}
+				if err := ctlog.AppendCertificatePEM(ctlog.PathForCA(caPemEntry.Text), certPEM, caKey, now); err != nil {
+					return fmt.Errorf("failed to record issuance: %w", err)
+				}
+				db.AddCA(caCert, caPemEntry.Text)
+				rec := db.AddCertificate(cert, caCert)
+				at := now.UTC()
+				rec.IssuedAt, rec.Profile = &at, profile
+				return nil
+			})
+			if err != nil {
+				showError(win, err)
+				return
+			}
+			resume.step(tabInbox, fmt.Sprintf("request %s signed and logged, certificate not yet written to the queue", req.ID))
+			if err := os.WriteFile(store.CertPath(req.ID), certPEM, 0644); err != nil {
+				showError(win, fmt.Errorf("failed to write certificate: %w", err))
+				return
+			}
+			if err := req.Decide(queue.StatusIssued, operator, comment, now); err != nil {
+				showError(win, err)
+				return
+			}
+			req.CertSHA256 = inventory.Fingerprint(cert)
+			if err := store.Save(req); err != nil {
+				showError(win, fmt.Errorf("certificate %s was issued, but %w", store.CertPath(req.ID), err))
+				return
+			}
+			resume.done(tabInbox)
+			commentEntry.SetText("")
+			if err := load(); err != nil {
+				showError(win, fmt.Errorf("failed to reload request queue: %w", err))
+				return
+			}
+			showSuccess(win, fmt.Sprintf("Request %s approved: certificate for %s (serial %s) written to: %s",
+				req.ID, subject, hex.EncodeToString(cert.SerialNumber.Bytes()), store.CertPath(req.ID)))
+		})
+	})
+
+	denyButton := widget.NewButtonWithIcon("Deny", theme.CancelIcon(), func() {
+		store, req, err := current()
+		if err != nil {
+			showError(win, err)
+			return
+		}
+		comment := strings.TrimSpace(commentEntry.Text)
+		if comment == "" {
+			showError(win, fmt.Errorf("a comment with the reason for the denial is required"))
+			return
+		}
+		dialog.ShowConfirm("Deny Request", fmt.Sprintf("Deny request %s for %s?", req.ID, req.Subject), func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := req.Decide(queue.StatusDenied, strings.TrimSpace(operatorEntry.Text), comment, time.Now()); err != nil {
+				showError(win, err)
+				return
+			}
+			if err := store.Save(req); err != nil {
+				showError(win, err)
+				return
+			}
+			commentEntry.SetText("")
+			if err := load(); err != nil {
+				showError(win, fmt.Errorf("failed to reload request queue: %w", err))
+				return
+			}
+			showSuccess(win, fmt.Sprintf("Request %s denied", req.ID))
+		}, win)
+	})
+
+	for field, e := range map[string]*widget.Entry{
+		"Queue": queueEntry, "Inventory": dbEntry, "Operator": operatorEntry, "CA PEM": caPemEntry, "Days": daysEntry, "CA Shares": sharesInEntry,
+	} {
+		resume.entry(tabInbox, field, e)
+	}
+	resume.choice(tabInbox, "Profile", profileSel)
+
+	queueForm := &widget.Form{
+		Items: []*widget.FormItem{
+			{Text: "Queue", Widget: queueEntry},
+			{Text: "Inventory", Widget: container.NewBorder(nil, nil, nil, dbBrowse, dbEntry)},
+			{Text: "Operator", Widget: operatorEntry},
+		},
+	}
+	caForm := &widget.Form{
+		Items: []*widget.FormItem{
+			{Text: "CA PEM", Widget: container.NewBorder(nil, nil, nil, caPemBrowse, caPemEntry)},
+			{Text: "Profile", Widget: profileSel},
+			{Text: "Days (Validity)", Widget: daysEntry},
+			{Text: "CA Key Shares", Widget: container.NewBorder(nil, nil, nil, addShareBtn, sharesInEntry)},
+			{Text: "Quorum", Widget: quorumLabel},
+		},
+	}
+
+	top := widget.NewCard("Request Queue", "Pending requests submitted with requests submit", container.NewVBox(queueForm, loadButton))
+	bottom := container.NewVBox(
+		widget.NewCard("Selected Request", "CSR and policy evaluation", details),
+		widget.NewCard("Signing CA Information", "", caForm),
+		widget.NewCard("Decision", "Approving signs with the CA's quorum of shares", container.NewVBox(commentEntry, container.NewGridWithColumns(2, approveButton, denyButton))),
+	)
+	return container.NewBorder(top, nil, nil, nil, container.NewVSplit(list, container.NewVScroll(bottom)))
+}
+
+// evaluateRequest checks csr against the issuing CA's configuration for profile and days, as
+// requests approve would, and returns one line per check.
+func evaluateRequest(csr *x509.CertificateRequest, caPem, profile, daysText string) []string {
+	var checks []string
+	result := func(name string, err error) {
+		if err != nil {
+			checks = append(checks, fmt.Sprintf("FAIL %s: %v", name, err))
+		} else {
+			checks = append(checks, "PASS "+name)
+		}
+	}
+	result("CSR signature", csr.CheckSignature())
+	for _, w := range utils.CSRWarnings(csr) {
+		checks = append(checks, "WARN "+w)
+	}
+	if caPem == "" {
+		return append(checks, "Select the signing CA to check the profile")
+	}
+	days, err := strconv.Atoi(daysText)
+	if err != nil {
+		result("validity", fmt.Errorf("invalid days '%s'", daysText))
+		return checks
+	}
+	days, _, settings, err := checkCAProfile(caPem, profile, days)
+	if err != nil {
+		result(fmt.Sprintf("profile '%s'", profile), err)
+		return checks
+	}
+	result(fmt.Sprintf("profile '%s', valid for %d days", profile, days), nil)
+	result("SAN rules", settings.CheckSANs(settings.Normalization().SANs(utils.SANsFromCSR(csr))))
+	result("key algorithm", utils.CheckKeyAlgorithm(csr.PublicKey, settings.KeyAlgorithm))
+	return checks
+}
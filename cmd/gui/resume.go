@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// resumeState is the in-progress state of the GUI forms, saved after every change so that an
+// interrupted ceremony can be picked up again. It holds form values and completed steps only:
+// password entries are never registered and key material never passes through it.
+type resumeState struct {
+	Saved  time.Time                    `json:"saved"`
+	Tab    string                       `json:"tab,omitempty"`
+	Fields map[string]map[string]string `json:"fields,omitempty"` // tab -> field -> value
+	Steps  map[string]string            `json:"steps,omitempty"`  // tab -> last completed step
+}
+
+// empty reports whether there is nothing worth resuming.
+func (s *resumeState) empty() bool {
+	return len(s.Fields) == 0 && len(s.Steps) == 0
+}
+
+// resumer keeps the resume state of the running GUI and the state left by the previous session.
+type resumer struct {
+	mu       sync.Mutex
+	path     string
+	state    resumeState
+	previous *resumeState
+	setters  map[string]map[string]func(string)
+	failed   bool
+}
+
+// resume is the resume state of the running GUI.
+var resume = &resumer{setters: map[string]map[string]func(string){}}
+
+// resumePath returns the file holding the resume state, in the user's configuration directory.
+func resumePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gosec", "gui-session.json"), nil
+}
+
+// open loads the state left by the previous session, if any. Until offer is answered, the
+// previous state stays on disk, so a second crash does not lose it.
+func (r *resumer) open() {
+	path, err := resumePath()
+	if err != nil {
+		slog.Warn("session resumption disabled", "err", err)
+		r.failed = true
+		return
+	}
+	r.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Warn("unable to read saved session", "path", path, "err", err)
+		return
+	}
+	prev := &resumeState{}
+	if err := json.Unmarshal(data, prev); err != nil {
+		slog.Warn("ignoring corrupt saved session", "path", path, "err", err)
+		return
+	}
+	if !prev.empty() {
+		r.previous = prev
+	}
+}
+
+// entry saves e's text under tab and field, and restores it on resumption. Password entries are ignored.
+func (r *resumer) entry(tab, field string, e *widget.Entry) {
+	if e.Password {
+		return
+	}
+	prev := e.OnChanged
+	e.OnChanged = func(text string) {
+		if prev != nil {
+			prev(text)
+		}
+		r.set(tab, field, text)
+	}
+	r.register(tab, field, e.SetText)
+}
+
+// check saves c's state under tab and field, and restores it on resumption.
+func (r *resumer) check(tab, field string, c *widget.Check) {
+	prev := c.OnChanged
+	c.OnChanged = func(checked bool) {
+		if prev != nil {
+			prev(checked)
+		}
+		r.set(tab, field, strconv.FormatBool(checked))
+	}
+	r.register(tab, field, func(v string) { c.SetChecked(v == "true") })
+}
+
+// choice saves s's selection under tab and field, and restores it on resumption.
+func (r *resumer) choice(tab, field string, s *widget.Select) {
+	prev := s.OnChanged
+	s.OnChanged = func(selected string) {
+		if prev != nil {
+			prev(selected)
+		}
+		r.set(tab, field, selected)
+	}
+	r.register(tab, field, s.SetSelected)
+}
+
+func (r *resumer) register(tab, field string, set func(string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.setters[tab] == nil {
+		r.setters[tab] = map[string]func(string){}
+	}
+	r.setters[tab][field] = set
+}
+
+func (r *resumer) set(tab, field, value string) {
+	r.mu.Lock()
+	if r.state.Fields == nil {
+		r.state.Fields = map[string]map[string]string{}
+	}
+	if r.state.Fields[tab] == nil {
+		r.state.Fields[tab] = map[string]string{}
+	}
+	r.state.Fields[tab][field] = value
+	r.mu.Unlock()
+	r.save()
+}
+
+// step records the last completed step of the ceremony in tab.
+func (r *resumer) step(tab, desc string) {
+	r.mu.Lock()
+	if r.state.Steps == nil {
+		r.state.Steps = map[string]string{}
+	}
+	r.state.Steps[tab] = desc
+	r.mu.Unlock()
+	r.save()
+}
+
+// done forgets the state of tab once its ceremony has completed.
+func (r *resumer) done(tab string) {
+	r.mu.Lock()
+	delete(r.state.Fields, tab)
+	delete(r.state.Steps, tab)
+	r.mu.Unlock()
+	r.save()
+}
+
+// selectTab records the tab the operator is working in.
+func (r *resumer) selectTab(tab string) {
+	r.mu.Lock()
+	r.state.Tab = tab
+	r.mu.Unlock()
+	r.save()
+}
+
+// save writes the current state, or removes the file when there is nothing to resume. Failures
+// are logged once and otherwise ignored: resumption is a convenience, not part of a ceremony.
+func (r *resumer) save() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.path == "" || r.failed || r.previous != nil {
+		return
+	}
+	if r.state.empty() {
+		if err := os.Remove(r.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			r.fail(err)
+		}
+		return
+	}
+	r.state.Saved = time.Now().UTC()
+	data, err := json.MarshalIndent(r.state, "", "  ")
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		r.fail(err)
+		return
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		r.fail(err)
+		return
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		os.Remove(tmp)
+		r.fail(err)
+	}
+}
+
+func (r *resumer) fail(err error) {
+	r.failed = true
+	slog.Warn("session resumption disabled: failed to save", "path", r.path, "err", err)
+}
+
+// offer asks whether to resume the previous session, if one was left unfinished. Until the
+// operator answers, the previous state is kept and the current one is not saved.
+func (r *resumer) offer(win fyne.Window, tabs *container.AppTabs) {
+	r.mu.Lock()
+	prev := r.previous
+	r.mu.Unlock()
+	if prev == nil {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "An unfinished session from %s was found.\n", prev.Saved.Local().Format("2006-01-02 15:04"))
+	var names []string
+	for tab := range prev.Fields {
+		names = append(names, tab)
+	}
+	for tab := range prev.Steps {
+		if prev.Fields[tab] == nil {
+			names = append(names, tab)
+		}
+	}
+	sort.Strings(names)
+	for _, tab := range names {
+		if step, ok := prev.Steps[tab]; ok {
+			fmt.Fprintf(&b, "\n%s - last completed step: %s", tab, step)
+		} else {
+			fmt.Fprintf(&b, "\n%s - form filled in, nothing issued yet", tab)
+		}
+	}
+	b.WriteString("\n\nRestore its form values? Key material and passphrases are never saved: the CA key is reconstructed from the shares again when you continue.")
+	label := widget.NewLabel(b.String())
+	label.Wrapping = fyne.TextWrapWord
+
+	dlg := dialog.NewCustomConfirm("Resume Session", "Resume", "Start Over", label, func(ok bool) {
+		r.mu.Lock()
+		r.previous = nil
+		if ok {
+			r.state = *prev
+		}
+		r.mu.Unlock()
+		if !ok {
+			slog.Info("discarded the unfinished session", "saved", prev.Saved.Local().Format(time.RFC3339))
+			r.save()
+			return
+		}
+		for tab, fields := range prev.Fields {
+			for field, value := range fields {
+				if set := r.setters[tab][field]; set != nil {
+					set(value)
+				}
+			}
+		}
+		for _, item := range tabs.Items {
+			if item.Text == prev.Tab {
+				tabs.Select(item)
+			}
+		}
+		slog.Info("resumed the session", "saved", prev.Saved.Local().Format(time.RFC3339))
+		for _, tab := range names {
+			if step, ok := prev.Steps[tab]; ok {
+				slog.Info("last completed step", "tab", tab, "step", step)
+			}
+		}
+		r.save()
+	}, win)
+	dlg.Resize(fyne.NewSize(520, 260))
+	dlg.Show()
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"my-pki/internal/crl"
+	"my-pki/internal/dist"
+	"my-pki/internal/inventory"
+	"my-pki/internal/secmem"
+	"my-pki/internal/utils"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// -------------------------------------------------------------------------------------
+// Revocation Tab
+// -------------------------------------------------------------------------------------
+
+// revocationTab lists the certificates recorded in the inventory, revokes or releases them and
+// signs the CRL publishing the result, as the revoke, unhold and gen-crl commands do.
+func revocationTab(win fyne.Window) fyne.CanvasObject {
+	dbEntry := widget.NewEntry()
+	dbEntry.SetText(inventory.Default())
+	dbBrowse := createFileOpenButton(win, "Browse (Inventory)", dbEntry)
+
+	caPemEntry := widget.NewEntry()
+	caPemEntry.SetPlaceHolder("Select the issuing CA PEM (empty lists every certificate)")
+	caPemBrowse := createFileOpenButton(win, "Browse (CA PEM)", caPemEntry)
+
+	// The inventory is read again before every change, so the CLI can be used alongside
+	var records []*inventory.CertRecord
+	selected := -1
+	list := widget.NewList(
+		func() int { return len(records) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			o.(*widget.Label).SetText(describeRecord(records[i]))
+		},
+	)
+	details := widget.NewLabel("Load the inventory and select a certificate")
+	details.Wrapping = fyne.TextWrapWord
+
+	load := func() error {
+		db, err := inventory.Open(dbEntry.Text)
+		if err != nil {
+			return err
+		}
+		records = db.Certificates
+		if caPemEntry.Text != "" {
+			caCert, err := utils.ParseCertificateFromFile(caPemEntry.Text)
+			if err != nil {
+				return fmt.Errorf("failed to parse CA cert: %w", err)
+			}
+			records = db.IssuedBy(inventory.Fingerprint(caCert))
+		}
+		selected = -1
+		list.UnselectAll()
+		list.Refresh()
+		details.SetText(fmt.Sprintf("%d certificate(s) in %s", len(records), dbEntry.Text))
+		return nil
+	}
+	loadButton := widget.NewButtonWithIcon("Load", theme.ViewRefreshIcon(), func() {
+		if err := load(); err != nil {
+			showError(win, fmt.Errorf("failed to load inventory: %w", err))
+		}
+	})
+	list.OnSelected = func(i widget.ListItemID) {
+		selected = i
+		rec := records[i]
+		lines := []string{
+			"Subject: " + rec.Subject,
+			"Serial: " + rec.Serial,
+			"SHA-256: " + rec.SHA256,
+			"Expires: " + rec.NotAfter.Local().Format(time.RFC1123),
+			"Status: " + recordStatus(rec),
+		}
+		if rec.ReleasedAt != nil {
+			lines = append(lines, "Released from hold: "+rec.ReleasedAt.Local().Format(time.RFC1123))
+		}
+		details.SetText(strings.Join(lines, "\n"))
+	}
+
+	// change applies fn to the selected record in a freshly read inventory and saves it
+	change := func(fn func(rec *inventory.CertRecord) (string, error)) {
+		if selected < 0 {
+			showError(win, fmt.Errorf("no certificate selected"))
+			return
+		}
+		sha := records[selected].SHA256
+		var msg string
+		err := inventory.Update(dbEntry.Text, func(db *inventory.DB) error {
+			rec := db.Certificate(sha)
+			if rec == nil {
+				return fmt.Errorf("certificate %s is no longer in the inventory", sha)
+			}
+			var err error
+			msg, err = fn(rec)
+			return err
+		})
+		if err != nil {
+			showError(win, err)
+			return
+		}
+		if err := load(); err != nil {
+			showError(win, fmt.Errorf("failed to reload inventory: %w", err))
+			return
+		}
+		showSuccess(win, msg+"\nGenerate a new CRL to publish it.")
+	}
+
+	reasonSelect := widget.NewSelect(inventory.ReasonNames(), nil)
+	reasonSelect.SetSelected(inventory.ReasonName(inventory.ReasonUnspecified))
+	revokeButton := widget.NewButtonWithIcon("Revoke", theme.DeleteIcon(), func() {
+		if selected < 0 {
+			showError(win, fmt.Errorf("no certificate selected"))
+			return
+		}
+		reason, err := inventory.ParseReason(reasonSelect.Selected)
+		if err != nil {
+			showError(win, err)
+			return
+		}
+		msg := fmt.Sprintf("Revoke %s (serial %s) for %s?", records[selected].Subject, records[selected].Serial, reasonSelect.Selected)
+		if reason == inventory.ReasonCertificateHold {
+			msg = fmt.Sprintf("Put %s (serial %s) on hold? It can be released later.", records[selected].Subject, records[selected].Serial)
+		}
+		dialog.ShowConfirm("Revoke Certificate", msg, func(ok bool) {
+			if !ok {
+				return
+			}
+			change(func(rec *inventory.CertRecord) (string, error) {
+				if rec.IssuerSHA256 == rec.SHA256 {
+					return "", fmt.Errorf("%s is a self-signed root and cannot be revoked by a CRL", rec.Subject)
+				}
+				if rec.Status == inventory.StatusRevoked && (!rec.OnHold() || reason == inventory.ReasonCertificateHold) {
+					return "", fmt.Errorf("%s (serial %s) is already %s", rec.Subject, rec.Serial, recordStatus(rec))
+				}
+				rec.Revoke(time.Now(), reason)
+				if reason == inventory.ReasonCertificateHold {
+					return fmt.Sprintf("%s (serial %s) put on hold", rec.Subject, rec.Serial), nil
+				}
+				return fmt.Sprintf("%s (serial %s) revoked: %s", rec.Subject, rec.Serial, inventory.ReasonName(reason)), nil
+			})
+		}, win)
+	})
+	unholdButton := widget.NewButtonWithIcon("Unhold", theme.ContentUndoIcon(), func() {
+		change(func(rec *inventory.CertRecord) (string, error) {
+			if err := rec.Release(time.Now()); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s (serial %s) released from hold", rec.Subject, rec.Serial), nil
+		})
+	})
+
+	// CRL signing, with the quorum of the issuing CA's shares
+	sharesInEntry := widget.NewEntry()
+	sharesInEntry.SetPlaceHolder("Select issuing CA key shares...")
+	quorumLabel := newQuorumLabel(sharesInEntry)
+	addShareBtn := widget.NewButton("Add CA Share", func() {
+		dlg := dialog.NewFileOpen(
+			func(reader fyne.URIReadCloser, err error) {
+				if err != nil {
+					showError(win, err)
+					return
+				}
+				if reader == nil {
+					return
+				}
+				newPath := reader.URI().Path()
+				_ = reader.Close()
+
+				existing := sharesInEntry.Text
+				if existing == "" {
+					sharesInEntry.SetText(newPath)
+				} else {
+					sharesInEntry.SetText(existing + "," + newPath)
+				}
+			},
+			win,
+		)
+		dlg.Show()
+	})
+	daysEntry := widget.NewEntry()
+	daysEntry.SetText("7")
+	deltaCheck := widget.NewCheck("Delta CRL (changes since the last full CRL)", nil)
+	crlOutEntry := widget.NewEntry()
+	crlOutEntry.SetPlaceHolder("Default: next to the CA PEM, where serve-dist publishes it")
+	crlOutBrowse := createFileSaveButton(win, "Browse (CRL Out)", crlOutEntry)
+
+	crlButton := widget.NewButtonWithIcon("Generate CRL", theme.ConfirmIcon(), func() {
+		if caPemEntry.Text == "" {
+			showError(win, fmt.Errorf("missing CA PEM path"))
+			return
+		}
+		days, err := strconv.Atoi(daysEntry.Text)
+		if err != nil || days <= 0 {
+			showError(win, fmt.Errorf("invalid days '%s'", daysEntry.Text))
+			return
+		}
+		caCert, err := utils.ParseCertificateFromFile(caPemEntry.Text)
+		if err != nil {
+			showError(win, fmt.Errorf("failed to parse CA cert: %w", err))
+			return
+		}
+		delta := deltaCheck.Checked
+		out := crlOutEntry.Text
+		if out == "" {
+			out = dist.CRLPath(caPemEntry.Text)
+			if delta {
+				out = dist.DeltaCRLPath(caPemEntry.Text)
+			}
+		}
+
+		db, err := inventory.Open(dbEntry.Text)
+		if err != nil {
+			showError(win, fmt.Errorf("failed to load inventory: %w", err))
+			return
+		}
+		ca := db.AddCA(caCert, caPemEntry.Text)
+		if delta && ca.BaseCRLAt == nil {
+			showError(win, fmt.Errorf("no full CRL has been issued for this CA yet; generate a full CRL first"))
+			return
+		}
+
+		sharePaths := strings.Split(strings.TrimSpace(sharesInEntry.Text), ",")
+		unlockShares(win, sharePaths, func(shares []*utils.Share) {
+			caKeyBytes, err := combineShares(shares)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to combine CA shares: %w", err))
+				return
+			}
+			defer secmem.Wipe(caKeyBytes)
+			caKey, err := utils.ParsePrivateKeyDER(caKeyBytes)
+			if err != nil {
+				showError(win, fmt.Errorf("failed to parse CA key: %w", err))
+				return
+			}
+			defer secmem.WipeKey(caKey)
+			if !caKey.PublicKey.Equal(caCert.PublicKey) {
+				showError(win, fmt.Errorf("the shares do not reconstruct the key of this CA"))
+				return
+			}
+
+			// Entries, number and record of the CRL are taken under the inventory lock, as gen-crl does
+			now := time.Now()
+			nextUpdate := now.AddDate(0, 0, days)
+			var revoked, released []*inventory.CertRecord
+			err = inventory.Update(dbEntry.Text, func(db *inventory.DB) error {
+				ca = db.AddCA(caCert, caPemEntry.Text)
+				revoked, released = crl.Entries(db, ca, now, delta)
+				var crlPEM []byte
+				var err error
+				if delta {
+					crlPEM, err = crl.CreateDelta(caCert, caKey, revoked, released, ca.NextCRLNumber(), ca.BaseCRLNumber, now, nextUpdate)
+				} else {
+					crlPEM, err = crl.Create(caCert, caKey, revoked, ca.NextCRLNumber(), now, nextUpdate)
+				}
+				if err != nil {
+					return err
+				}
+				if err := os.WriteFile(out, crlPEM, 0644); err != nil {
+					return fmt.Errorf("failed to write CRL: %w", err)
+				}
+				ca.RecordCRL(now, delta)
+				return nil
+			})
+			if err != nil {
+				showError(win, err)
+				return
+			}
+			kind := "CRL"
+			if delta {
+				kind = "Delta CRL"
+			}
+			showSuccess(win, fmt.Sprintf("%s #%d for %s written to: %s\nRevoked certificates: %d\nReleased from hold: %d\nNext update: %s",
+				kind, ca.CRLNumber, caCert.Subject, out, len(revoked), len(released), nextUpdate.Format(time.RFC3339)))
+		})
+	})
+
+	inventoryForm := &widget.Form{
+		Items: []*widget.FormItem{
+			{Text: "Inventory", Widget: container.NewBorder(nil, nil, nil, dbBrowse, dbEntry)},
+			{Text: "CA PEM", Widget: container.NewBorder(nil, nil, nil, caPemBrowse, caPemEntry)},
+		},
+	}
+	actionForm := &widget.Form{
+		Items: []*widget.FormItem{
+			{Text: "Reason", Widget: reasonSelect},
+		},
+	}
+	crlForm := &widget.Form{
+		Items: []*widget.FormItem{
+			{Text: "CA Key Shares", Widget: container.NewBorder(nil, nil, nil, addShareBtn, sharesInEntry)},
+			{Text: "Quorum", Widget: quorumLabel},
+			{Text: "Days (Next Update)", Widget: daysEntry},
+			{Text: "CRL Type", Widget: deltaCheck},
+			{Text: "CRL Out", Widget: container.NewBorder(nil, nil, nil, crlOutBrowse, crlOutEntry)},
+		},
+	}
+
+	top := widget.NewCard("Inventory", "Certificates issued by the CLI", container.NewVBox(inventoryForm, loadButton))
+	bottom := container.NewVBox(
+		details,
+		widget.NewCard("Selected Certificate", "", container.NewVBox(actionForm, container.NewGridWithColumns(2, revokeButton, unholdButton))),
+		widget.NewCard("Publish", "Sign a CRL with the issuing CA", container.NewVBox(crlForm, crlButton)),
+	)
+	return container.NewBorder(top, bottom, nil, nil, list)
+}
+
+// describeRecord is the one-line list entry of rec.
+func describeRecord(rec *inventory.CertRecord) string {
+	return fmt.Sprintf("%s  [%s]  serial %s", rec.Subject, recordStatus(rec), rec.Serial)
+}
+
+// recordStatus describes the status of rec, with the reason and time of a revocation.
+func recordStatus(rec *inventory.CertRecord) string {
+	switch {
+	case rec.OnHold():
+		return "on hold since " + rec.RevokedAt.Local().Format("2006-01-02 15:04")
+	case rec.Status == inventory.StatusRevoked:
+		return fmt.Sprintf("revoked %s (%s)", rec.RevokedAt.Local().Format("2006-01-02 15:04"), inventory.ReasonName(rec.RevocationReason))
+	case !rec.NotAfter.After(time.Now()):
+		return "expired"
+	}
+	return inventory.StatusValid
+}
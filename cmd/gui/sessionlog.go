@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// sessionLog collects everything logged during this GUI session (it is the output of the default
+// slog logger) and mirrors it into the "Session Log" tab.
+type sessionLog struct {
+	mu    sync.Mutex
+	buf   strings.Builder
+	entry *widget.Entry
+}
+
+// session is the log of the running GUI. It never receives key material: only messages
+// and errors are written to it.
+var session = &sessionLog{}
+
+// Write implements io.Writer so the logger can write here.
+func (l *sessionLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	l.buf.Write(p)
+	text := l.buf.String()
+	entry := l.entry
+	l.mu.Unlock()
+	if entry != nil {
+		entry.SetText(text)
+		entry.CursorRow = strings.Count(text, "\n")
+		entry.Refresh()
+	}
+	return len(p), nil
+}
+
+// String returns the whole log.
+func (l *sessionLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+// sessionLogTab builds the tab showing the session log with copy and save actions.
+func sessionLogTab(win fyne.Window) fyne.CanvasObject {
+	entry := widget.NewMultiLineEntry()
+	entry.Wrapping = fyne.TextWrapWord
+	entry.SetText(session.String())
+	session.mu.Lock()
+	session.entry = entry
+	session.mu.Unlock()
+
+	copyBtn := widget.NewButton("Copy to Clipboard", func() {
+		win.Clipboard().SetContent(session.String())
+	})
+	saveBtn := widget.NewButton("Save to File...", func() {
+		dlg := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				showError(win, err)
+				return
+			}
+			if writer == nil {
+				return
+			}
+			path := writer.URI().Path()
+			_ = writer.Close()
+			if err := os.WriteFile(path, []byte(session.String()), 0600); err != nil {
+				showError(win, fmt.Errorf("failed to save session log: %w", err))
+				return
+			}
+			slog.Info("session log saved", "path", path)
+		}, win)
+		dlg.SetFileName("gosec-session.log")
+		dlg.Show()
+	})
+
+	return container.NewBorder(nil, container.NewHBox(copyBtn, saveBtn), nil, nil, entry)
+}
+
+// errorDetails renders the full error text and the chain of wrapped errors.
+func errorDetails(err error) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\nError chain:\n", err)
+	for i, e := 0, err; e != nil; i, e = i+1, errors.Unwrap(e) {
+		fmt.Fprintf(&b, "%d. [%T] %s\n", i+1, e, e)
+	}
+	return b.String()
+}
+
+// showError logs err to the session log and shows a dialog with a one-line summary and an
+// expandable, copyable detail view.
+func showError(win fyne.Window, err error) {
+	slog.Error("operation failed", "err", err)
+
+	summary := strings.SplitN(err.Error(), "\n", 2)[0]
+	if len(summary) > 120 {
+		summary = summary[:117] + "..."
+	}
+	details := errorDetails(err)
+	detailEntry := widget.NewMultiLineEntry()
+	detailEntry.Wrapping = fyne.TextWrapWord
+	detailEntry.SetText(details)
+	detailEntry.SetMinRowsVisible(8)
+
+	copyBtn := widget.NewButton("Copy Details", func() {
+		win.Clipboard().SetContent(details)
+	})
+	accordion := widget.NewAccordion(
+		widget.NewAccordionItem("Details", container.NewBorder(nil, copyBtn, nil, nil, detailEntry)),
+	)
+	summaryLabel := widget.NewLabel(summary)
+	summaryLabel.Wrapping = fyne.TextWrapWord
+
+	dlg := dialog.NewCustom("Error", "Close", container.NewVBox(summaryLabel, accordion), win)
+	dlg.Resize(fyne.NewSize(560, 200))
+	dlg.Show()
+}
+
+// showSuccess logs msg to the session log and shows it in an information dialog.
+func showSuccess(win fyne.Window, msg string) {
+	slog.Info(strings.ReplaceAll(msg, "\n", " | "))
+	dialog.ShowInformation("Success", msg, win)
+}
@@ -0,0 +1,108 @@
+// Package age encrypts to the X25519 recipients of the age file encryption format
+// (age-encryption.org/v1) with filippo.io/age, so shares can be wrapped to keys made with age-keygen.
+//
+// It only adapts filippo.io/age to whole byte slices and identity files of X25519 keys; the format
+// itself is left to that implementation.
+package age
+
+import (
+	"bytes"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// intro is the first line of every age file.
+const intro = "age-encryption.org/v1"
+
+// ErrNoIdentity is returned by Decrypt when none of the identities can unwrap the file key.
+var ErrNoIdentity = errors.New("no identity matches any of the file's recipients")
+
+// Recipient is an X25519 public key, written "age1...".
+type Recipient = age.X25519Recipient
+
+// Identity is an X25519 private key, written "AGE-SECRET-KEY-1...".
+type Identity = age.X25519Identity
+
+// ParseRecipient decodes a recipient in its "age1..." form.
+func ParseRecipient(s string) (*Recipient, error) {
+	return age.ParseX25519Recipient(strings.TrimSpace(s))
+}
+
+// GenerateIdentity creates a new identity from crypto/rand.
+func GenerateIdentity() (*Identity, error) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate X25519 key: %w", err)
+	}
+	return id, nil
+}
+
+// ParseIdentities decodes an identity file as written by age-keygen: one secret key per line,
+// with blank lines and '#' comments ignored.
+func ParseIdentities(data []byte) ([]*Identity, error) {
+	var ids []*Identity
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, err := age.ParseX25519Identity(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no secret keys found")
+	}
+	return ids, nil
+}
+
+// Encrypt encrypts plaintext to the recipients as an age file, drawing keys and nonces from
+// crypto/rand.
+func Encrypt(plaintext []byte, recipients ...*Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	var to []age.Recipient
+	for _, r := range recipients {
+		to = append(to, r)
+	}
+	var out bytes.Buffer
+	w, err := age.Encrypt(&out, to...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// Decrypt decrypts an age file with the first identity that matches one of its X25519 recipients.
+func Decrypt(ciphertext []byte, identities ...*Identity) ([]byte, error) {
+	var with []age.Identity
+	for _, id := range identities {
+		with = append(with, id)
+	}
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), with...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, ErrNoIdentity
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// IsEncrypted reports whether data starts like an age file.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(intro+"\n"))
+}
@@ -0,0 +1,190 @@
+// Package agent lets custodians keep their share on their own machine. A custodian agent serves
+// one share over mutually authenticated TLS and contributes it to a coordinator's ceremony only
+// once the custodian has approved the request on the agent's terminal. The share travels wrapped
+// to an age key the coordinator generated for the ceremony, on top of TLS.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"my-pki/internal/age"
+	"my-pki/internal/utils"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestPath is where agents accept share requests.
+const RequestPath = "/v1/share-requests"
+
+// ApprovalTimeout bounds how long a coordinator waits for a custodian to approve.
+const ApprovalTimeout = 15 * time.Minute
+
+// ErrDeclined is returned by an Approver when the custodian refuses a request.
+var ErrDeclined = errors.New("the custodian declined the request")
+
+// ShareRequest asks an agent for its share.
+type ShareRequest struct {
+	Command   string `json:"command"`   // what the coordinator will do with the key, shown to the custodian
+	Recipient string `json:"recipient"` // age recipient the share is wrapped to
+}
+
+type shareResponse struct {
+	Share string `json:"share,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Approver asks the custodian about a request from the coordinator identified by its client
+// certificate, and returns the unlocked share if they approve.
+type Approver func(req *ShareRequest, coordinator *x509.Certificate) (*utils.Share, error)
+
+// Server answers share requests, one at a time.
+type Server struct {
+	approve Approver
+	mu      sync.Mutex
+}
+
+// NewServer returns a server that asks approve about every request.
+func NewServer(approve Approver) *Server {
+	return &Server{approve: approve}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != RequestPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, shareResponse{Error: "method not allowed"})
+		return
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		writeJSON(w, http.StatusUnauthorized, shareResponse{Error: "a client certificate is required"})
+		return
+	}
+	var req ShareRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, shareResponse{Error: "malformed request: " + err.Error()})
+		return
+	}
+	recipient, err := age.ParseRecipient(req.Recipient)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, shareResponse{Error: err.Error()})
+		return
+	}
+
+	// The custodian answers one request at a time on the agent's terminal
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share, err := s.approve(&req, r.TLS.PeerCertificates[0])
+	if errors.Is(err, ErrDeclined) {
+		writeJSON(w, http.StatusForbidden, shareResponse{Error: err.Error()})
+		return
+	} else if err != nil {
+		writeJSON(w, http.StatusInternalServerError, shareResponse{Error: err.Error()})
+		return
+	}
+	defer clear(share.Data)
+	wrapped, err := utils.EncodeWrappedShare(share, recipient)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, shareResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, shareResponse{Share: string(wrapped)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// ServerTLSConfig returns the agent's TLS configuration, which requires coordinators to present a
+// certificate issued by a CA in clientCAFile.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the agent certificate: %w", err)
+	}
+	pool, err := loadPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// Client requests shares from agents on behalf of a coordinator.
+type Client struct {
+	HTTP *http.Client
+}
+
+// NewClient returns a client that authenticates with the coordinator certificate in certFile and
+// trusts agents whose certificates were issued by a CA in caFile.
+func NewClient(certFile, keyFile, caFile string) (*Client, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the coordinator certificate: %w", err)
+	}
+	pool, err := loadPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{pair}, RootCAs: pool}
+	return &Client{HTTP: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: ApprovalTimeout}}, nil
+}
+
+// RequestShare asks the agent at agentURL for its share and returns it wrapped to req.Recipient.
+func (c *Client) RequestShare(ctx context.Context, agentURL string, req *ShareRequest) ([]byte, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(agentURL, "/")+RequestPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTP.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out shareResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("unexpected response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusForbidden {
+			return nil, ErrDeclined
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, out.Error)
+	}
+	return []byte(out.Share), nil
+}
+
+// loadPool reads the CA certificates in path.
+func loadPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in '%s'", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,352 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/utils"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Roles a server configuration grants to tokens and client certificates.
+const (
+	RoleRequester = "requester" // requests certificates of its allowed profiles
+	RoleApprover  = "approver"  // the operator role: looks up and revokes certificates
+	RoleAuditor   = "auditor"   // looks up certificates
+	RoleAdmin     = "admin"     // all of the above, for any profile
+)
+
+// Permission is an operation a role may be granted.
+type Permission string
+
+// Permissions of the roles. Reading the CA certificates and CRLs and inspecting certificates are
+// open to every authenticated client.
+const (
+	PermIssue  Permission = "issue"
+	PermRead   Permission = "read"
+	PermRevoke Permission = "revoke"
+)
+
+// rolePermissions lists what each role may do.
+var rolePermissions = map[string][]Permission{
+	RoleRequester: {PermIssue},
+	RoleApprover:  {PermRead, PermRevoke},
+	RoleAuditor:   {PermRead},
+	RoleAdmin:     {PermIssue, PermRead, PermRevoke},
+}
+
+// Roles returns the names of the roles.
+func Roles() []string {
+	return []string{RoleRequester, RoleApprover, RoleAuditor, RoleAdmin}
+}
+
+// tokenPrefix starts every API token, so leaked tokens are easy to recognize.
+const tokenPrefix = "gosec_"
+
+// Token grants a role to the holders of a bearer token. Only the SHA-256 of the token is kept.
+type Token struct {
+	Name     string     `json:"name" yaml:"name"`
+	Role     string     `json:"role" yaml:"role"`
+	Profiles []string   `json:"profiles,omitempty" yaml:"profiles,omitempty"` // the profiles a requester may request
+	SHA256   string     `json:"-" yaml:"sha256"`
+	Expires  *time.Time `json:"expires,omitempty" yaml:"expires,omitempty"`
+	// RatePerMinute overrides the rate limit of limits for this token
+	RatePerMinute int `json:"rate_per_minute,omitempty" yaml:"rate_per_minute,omitempty"`
+}
+
+// CertClient grants a role to the client certificates with a subject.
+type CertClient struct {
+	Subject       string   `yaml:"subject"` // as printed by inspect, e.g. CN=ci-bot,O=Example
+	Role          string   `yaml:"role"`
+	Profiles      []string `yaml:"profiles,omitempty"`
+	RatePerMinute int      `yaml:"rate_per_minute,omitempty"`
+}
+
+// Enroller grants a role to EST or CMP shared secrets, by the name the audit log gives their users:
+// est:USERNAME or cmp:REFERENCE. A name ending in * covers every name it starts, e.g. est:*.
+type Enroller struct {
+	Name          string   `yaml:"name"`
+	Role          string   `yaml:"role"`
+	Profiles      []string `yaml:"profiles,omitempty"`
+	RatePerMinute int      `yaml:"rate_per_minute,omitempty"`
+}
+
+// Config is the server configuration of serve --config, granting roles to API tokens and client
+// certificates:
+//
+//	tokens:
+//	  - name: ci-deploy
+//	    role: requester
+//	    profiles: [server-tls]
+//	    sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    expires: 2027-01-01T00:00:00Z
+//	clients:
+//	  - subject: CN=pki-operator,O=Example
+//	    role: approver
+//	enrollers:
+//	  - name: est:*
+//	    role: requester
+//	    profiles: [device]
+//	limits:
+//	  rate_per_minute: 60
+//	  daily_quotas: {server-tls: 500, "*": 100}
+//
+// Tokens are added with api-token create, which prints the token once and keeps its hash.
+type Config struct {
+	Tokens    []Token      `yaml:"tokens,omitempty"`
+	Clients   []CertClient `yaml:"clients,omitempty"`
+	Enrollers []Enroller   `yaml:"enrollers,omitempty"`
+	Limits    Limits       `yaml:"limits,omitempty"`
+}
+
+// LoadConfig reads and checks the server configuration at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read server configuration '%s': %w", path, err)
+	}
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid server configuration '%s': %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid server configuration '%s': %w", path, err)
+	}
+	return &c, nil
+}
+
+// Save writes the configuration to path, readable only by its owner.
+func (c *Config) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode server configuration: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write server configuration '%s': %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the roles of the tokens and clients and the limits.
+func (c *Config) Validate() error {
+	names := map[string]bool{}
+	for _, t := range c.Tokens {
+		if t.Name == "" {
+			return errors.New("a token has no name")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("token '%s' is listed twice", t.Name)
+		}
+		names[t.Name] = true
+		if sum, err := hex.DecodeString(t.SHA256); err != nil || len(sum) != sha256.Size {
+			return fmt.Errorf("token '%s': sha256 must be 64 hex digits", t.Name)
+		}
+		if err := checkRole(t.Role, t.Profiles, t.RatePerMinute); err != nil {
+			return fmt.Errorf("token '%s': %w", t.Name, err)
+		}
+	}
+	for _, cl := range c.Clients {
+		if cl.Subject == "" {
+			return errors.New("a client has no subject")
+		}
+		if err := checkRole(cl.Role, cl.Profiles, cl.RatePerMinute); err != nil {
+			return fmt.Errorf("client '%s': %w", cl.Subject, err)
+		}
+	}
+	for _, e := range c.Enrollers {
+		if !strings.HasPrefix(e.Name, "est:") && !strings.HasPrefix(e.Name, "cmp:") {
+			return fmt.Errorf("enroller '%s': name must start with est: or cmp:", e.Name)
+		}
+		if err := checkRole(e.Role, e.Profiles, e.RatePerMinute); err != nil {
+			return fmt.Errorf("enroller '%s': %w", e.Name, err)
+		}
+	}
+	return c.Limits.validate()
+}
+
+// checkRole checks a role, the profiles it is granted and its rate limit.
+func checkRole(role string, profiles []string, ratePerMinute int) error {
+	if _, ok := rolePermissions[role]; !ok {
+		return fmt.Errorf("unknown role '%s' (expected %s)", role, strings.Join(Roles(), ", "))
+	}
+	if role == RoleRequester && len(profiles) == 0 {
+		return errors.New("a requester must be given the profiles it may request")
+	}
+	if ratePerMinute < 0 {
+		return errors.New("rate_per_minute must not be negative")
+	}
+	return nil
+}
+
+// Token returns the token named name, or nil.
+func (c *Config) Token(name string) *Token {
+	for i := range c.Tokens {
+		if c.Tokens[i].Name == name {
+			return &c.Tokens[i]
+		}
+	}
+	return nil
+}
+
+// NewToken returns a new random API token and its SHA-256 to keep in the configuration.
+func NewToken() (token, sum string, err error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(utils.Rand, b); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token = tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, TokenSHA256(token), nil
+}
+
+// TokenSHA256 returns the hex SHA-256 of token, as the configuration keeps it.
+func TokenSHA256(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Principal is an authenticated client and the role it was granted.
+type Principal struct {
+	Name     string // for the audit log: token:NAME or the client certificate subject
+	Role     string
+	Profiles []string // the profiles it may request; empty for any
+	// RatePerMinute overrides the rate limit of the limits when set
+	RatePerMinute int
+}
+
+// Can reports whether the principal's role grants perm.
+func (p *Principal) Can(perm Permission) bool {
+	return slices.Contains(rolePermissions[p.Role], perm)
+}
+
+// Authorize returns an error answered with 403 unless the principal may perform perm. For
+// PermIssue, profile is the requested profile, empty for the default leaf.
+func (p *Principal) Authorize(perm Permission, profile string) error {
+	if !p.Can(perm) {
+		return WithStatus(http.StatusForbidden, fmt.Errorf("%s (role %s) may not %s certificates", p.Name, p.Role, verbs[perm]))
+	}
+	if perm != PermIssue || len(p.Profiles) == 0 {
+		return nil
+	}
+	if profile == "" {
+		profile = caconfig.ProfileLeaf
+	}
+	if !slices.Contains(p.Profiles, profile) {
+		return WithStatus(http.StatusForbidden, fmt.Errorf("%s may not request profile '%s' (allowed: %s)", p.Name, profile, strings.Join(p.Profiles, ", ")))
+	}
+	return nil
+}
+
+// verbs phrases the permissions in refusals.
+var verbs = map[Permission]string{PermIssue: "request", PermRead: "look up", PermRevoke: "revoke"}
+
+// Authenticate returns the principal of r: the holder of a bearer token in the configuration, else
+// the client certificate with a role in it. Without a configuration every client certificate is an
+// admin. Unknown or expired tokens and missing credentials are answered with 401, client
+// certificates without a role with 403.
+func (c *Config) Authenticate(r *http.Request) (*Principal, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok || c == nil {
+			return nil, WithStatus(http.StatusUnauthorized, errors.New("unsupported Authorization header; expected a bearer token"))
+		}
+		sum := []byte(TokenSHA256(token))
+		for _, t := range c.Tokens {
+			if subtle.ConstantTimeCompare(sum, []byte(t.SHA256)) != 1 {
+				continue
+			}
+			if t.Expires != nil && !time.Now().Before(*t.Expires) {
+				return nil, WithStatus(http.StatusUnauthorized, fmt.Errorf("token '%s' expired on %s", t.Name, t.Expires.UTC().Format(time.DateOnly)))
+			}
+			return &Principal{Name: "token:" + t.Name, Role: t.Role, Profiles: t.Profiles, RatePerMinute: t.RatePerMinute}, nil
+		}
+		return nil, WithStatus(http.StatusUnauthorized, errors.New("unknown token"))
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		if c == nil {
+			return nil, WithStatus(http.StatusUnauthorized, errors.New("a client certificate is required"))
+		}
+		return nil, WithStatus(http.StatusUnauthorized, errors.New("a client certificate or a bearer token is required"))
+	}
+	return c.Principal(r.TLS.PeerCertificates[0].Subject.String())
+}
+
+// Principal returns the principal of a client authenticated by the server: by the subject of its
+// client certificate, or for EST and CMP by a shared secret named est:USERNAME or cmp:REFERENCE.
+// Without a configuration it is an admin; clients not granted a role are refused with 403.
+func (c *Config) Principal(name string) (*Principal, error) {
+	if c == nil {
+		return &Principal{Name: name, Role: RoleAdmin}, nil
+	}
+	if strings.HasPrefix(name, "est:") || strings.HasPrefix(name, "cmp:") {
+		// An exact name takes precedence over a wildcard
+		var match *Enroller
+		for i, e := range c.Enrollers {
+			if e.Name == name {
+				match = &c.Enrollers[i]
+				break
+			}
+			if prefix, ok := strings.CutSuffix(e.Name, "*"); ok && match == nil && strings.HasPrefix(name, prefix) {
+				match = &c.Enrollers[i]
+			}
+		}
+		if match == nil {
+			return nil, WithStatus(http.StatusForbidden, fmt.Errorf("%s is not granted a role", name))
+		}
+		return &Principal{Name: name, Role: match.Role, Profiles: match.Profiles, RatePerMinute: match.RatePerMinute}, nil
+	}
+	for _, cl := range c.Clients {
+		if cl.Subject == name {
+			return &Principal{Name: name, Role: cl.Role, Profiles: cl.Profiles, RatePerMinute: cl.RatePerMinute}, nil
+		}
+	}
+	return nil, WithStatus(http.StatusForbidden, fmt.Errorf("client certificate %s is not granted a role", name))
+}
+
+// Renewer returns the principal of a certificate of profile current renewing itself, by EST
+// reenrollment or a CMP key update, into profile, empty for current. A certificate may always be
+// renewed into its own profile; another profile must be granted to its subject.
+func (c *Config) Renewer(subject, current, profile string) (*Principal, error) {
+	if current == "" {
+		current = caconfig.ProfileLeaf
+	}
+	if profile != "" && profile != current {
+		p, err := c.Principal(subject)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.Authorize(PermIssue, profile); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	p := &Principal{Name: subject, Role: RoleRequester, Profiles: []string{current}}
+	if granted, err := c.Principal(subject); err == nil {
+		p.RatePerMinute = granted.RatePerMinute
+	}
+	return p, nil
+}
+
+type principalKey struct{}
+
+// withPrincipal returns ctx carrying the authenticated principal p.
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principal returns the principal of a request authenticated by ServeHTTP.
+func principal(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalKey{}).(*Principal)
+	return p
+}
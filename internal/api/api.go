@@ -0,0 +1,425 @@
+// Package api serves a CA over an HTTPS JSON API, so other services can request, revoke and look up
+// certificates without shelling out to the CLI. Clients authenticate with a TLS client certificate
+// or an API token, and a server configuration grants them roles. The package parses requests and
+// answers them; issuing and revoking is left to a Backend.
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// maxBody bounds request bodies; a CSR or a certificate chain fits many times over.
+const maxBody = 1 << 20
+
+// ErrNotFound is returned by a Backend for a certificate that is not in the inventory.
+var ErrNotFound = errors.New("no such certificate in the inventory")
+
+// Error is an error answered with a particular HTTP status.
+type Error struct {
+	Status int
+	Err    error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// WithStatus returns err to be answered with status, e.g. http.StatusForbidden for a request the CA
+// policy refuses.
+func WithStatus(status int, err error) error {
+	return &Error{Status: status, Err: err}
+}
+
+// Subject is the subject of a certificate to issue.
+type Subject struct {
+	CommonName         string   `json:"common_name"`
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizational_unit,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Province           []string `json:"province,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+}
+
+// Name returns the subject as a distinguished name.
+func (s *Subject) Name() pkix.Name {
+	return pkix.Name{
+		CommonName:         s.CommonName,
+		Organization:       s.Organization,
+		OrganizationalUnit: s.OrganizationalUnit,
+		Country:            s.Country,
+		Province:           s.Province,
+		Locality:           s.Locality,
+	}
+}
+
+// IssueRequest asks for a certificate. With a CSR, its key is certified and its subject and SANs are
+// used unless the request gives others; without one, the CA generates the key pair and returns it.
+type IssueRequest struct {
+	Profile string   `json:"profile,omitempty"` // default: leaf
+	Subject *Subject `json:"subject,omitempty"`
+	SANs    []string `json:"sans,omitempty"` // DNS names, IP addresses, e-mail addresses or URIs
+	Days    int      `json:"days,omitempty"` // default: the profile's validity
+	CSR     string   `json:"csr,omitempty"`  // PKCS#10 request (PEM)
+	// Template is a request whose proof of possession another front end has checked, such as a CMP
+	// certificate template. Its subject, SANs and public key are used like those of a CSR.
+	Template *x509.CertificateRequest `json:"-"`
+}
+
+// Issued is an issued certificate.
+type Issued struct {
+	Certificate string    `json:"certificate"` // PEM
+	Chain       string    `json:"chain"`       // PEM certificates of the issuing CA up to its root
+	PrivateKey  string    `json:"private_key,omitempty"`
+	Subject     string    `json:"subject"`
+	Serial      string    `json:"serial"` // hex
+	SHA256      string    `json:"sha256"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Profile     string    `json:"profile"`
+}
+
+// RevokeRequest gives the reason of a revocation.
+type RevokeRequest struct {
+	Reason string `json:"reason,omitempty"` // RFC 5280 reason name, e.g. keyCompromise; default: unspecified
+}
+
+// CAInfo describes the issuing CA.
+type CAInfo struct {
+	Subject  string    `json:"subject"`
+	SHA256   string    `json:"sha256"`
+	NotAfter time.Time `json:"not_after"`
+	Chain    string    `json:"chain"` // PEM, the CA certificate first
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Backend is the CA behind the API. It is called concurrently, also by other front ends such as the
+// gRPC service. client names the caller, e.g. the subject of its client certificate, for the audit log.
+type Backend interface {
+	// Issue issues a certificate for req.
+	Issue(client string, req *IssueRequest) (*Issued, error)
+	// Revoke revokes the certificate with the fingerprint or hex serial id and returns its record.
+	Revoke(client string, id string, reason int) (*inventory.CertRecord, error)
+	// Certificates returns the records matching q; q.IssuerSHA256 may also be a CA name.
+	Certificates(q inventory.Query) ([]*inventory.CertRecord, error)
+	// Certificate returns the record with the fingerprint or hex serial id.
+	Certificate(id string) (*inventory.CertRecord, error)
+	// Chain returns the issuing CA certificate followed by its issuers.
+	Chain() []*x509.Certificate
+}
+
+// Server answers API requests with a Backend.
+type Server struct {
+	backend Backend
+	access  *Config
+	limiter *Limiter
+	mux     *http.ServeMux
+}
+
+// routes lists the endpoints, as shown by Routes.
+var routes = []string{
+	"GET /v1/ca",
+	"POST /v1/certificates",
+	"GET /v1/certificates",
+	"GET /v1/certificates/{id}",
+	"POST /v1/certificates/{id}/revoke",
+	"POST /v1/inspect",
+	"GET /metrics",
+}
+
+// NewServer returns a server answering with backend. access grants roles to clients; when nil,
+// every client certificate is an admin. limiter, when set, limits the rate of each client and
+// counts requests for GET /metrics.
+func NewServer(backend Backend, access *Config, limiter *Limiter) *Server {
+	s := &Server{backend: backend, access: access, limiter: limiter, mux: http.NewServeMux()}
+	handlers := []http.HandlerFunc{s.ca, s.issue, s.list, s.get, s.revoke, s.inspect, s.metrics}
+	for i, route := range routes {
+		s.mux.HandleFunc(route, handlers[i])
+	}
+	return s
+}
+
+// Routes returns the method and path of every endpoint.
+func (s *Server) Routes() []string {
+	return routes
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, err := s.access.Authenticate(r)
+	if err != nil {
+		if s.access != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeError(w, err)
+		return
+	}
+	if err := s.limiter.Allow(p); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.mux.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
+}
+
+func (s *Server) ca(w http.ResponseWriter, r *http.Request) {
+	chain := s.backend.Chain()
+	writeJSON(w, http.StatusOK, CAInfo{
+		Subject:  chain[0].Subject.String(),
+		SHA256:   inventory.Fingerprint(chain[0]),
+		NotAfter: chain[0].NotAfter.UTC(),
+		Chain:    string(EncodeChain(chain)),
+	})
+}
+
+func (s *Server) issue(w http.ResponseWriter, r *http.Request) {
+	var req IssueRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.CSR == "" && (req.Subject == nil || req.Subject.CommonName == "") && len(req.SANs) == 0 {
+		writeError(w, WithStatus(http.StatusBadRequest, errors.New("a csr, a subject common_name or sans are required")))
+		return
+	}
+	if req.Days < 0 {
+		writeError(w, WithStatus(http.StatusBadRequest, errors.New("days must not be negative")))
+		return
+	}
+	if err := principal(r).Authorize(PermIssue, req.Profile); err != nil {
+		writeError(w, err)
+		return
+	}
+	issued, err := s.backend.Issue(principal(r).Name, &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, issued)
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	if err := principal(r).Authorize(PermRead, ""); err != nil {
+		writeError(w, err)
+		return
+	}
+	q, err := parseQuery(r)
+	if err != nil {
+		writeError(w, WithStatus(http.StatusBadRequest, err))
+		return
+	}
+	recs, err := s.backend.Certificates(q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	withPEM, _ := strconv.ParseBool(r.URL.Query().Get("pem"))
+	out := make([]inventory.CertRecord, 0, len(recs))
+	for _, rec := range recs {
+		c := *rec
+		if !withPEM {
+			c.PEM = ""
+		}
+		out = append(out, c)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	if err := principal(r).Authorize(PermRead, ""); err != nil {
+		writeError(w, err)
+		return
+	}
+	rec, err := s.backend.Certificate(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	if err := principal(r).Authorize(PermRevoke, ""); err != nil {
+		writeError(w, err)
+		return
+	}
+	var req RevokeRequest
+	if r.ContentLength != 0 && !decode(w, r, &req) {
+		return
+	}
+	reason := inventory.ReasonUnspecified
+	if req.Reason != "" {
+		var err error
+		if reason, err = inventory.ParseReason(req.Reason); err != nil {
+			writeError(w, WithStatus(http.StatusBadRequest, err))
+			return
+		}
+	}
+	rec, err := s.backend.Revoke(principal(r).Name, r.PathValue("id"), reason)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+func (s *Server) inspect(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBody))
+	if err != nil {
+		writeError(w, WithStatus(http.StatusBadRequest, err))
+		return
+	}
+	var certs []*x509.Certificate
+	if cert, err := x509.ParseCertificate(data); err == nil {
+		certs = append(certs, cert)
+	}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			writeError(w, WithStatus(http.StatusBadRequest, fmt.Errorf("failed to parse x509 certificate: %w", err)))
+			return
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		writeError(w, WithStatus(http.StatusBadRequest, errors.New("the body holds no certificate (PEM or DER)")))
+		return
+	}
+	infos := make([]*utils.CertificateInfo, 0, len(certs))
+	for _, cert := range certs {
+		infos = append(infos, utils.InspectCertificate(cert))
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	if err := principal(r).Authorize(PermRead, ""); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.limiter.WriteMetrics(w)
+}
+
+// parseQuery reads the inventory query of GET /v1/certificates from the URL parameters.
+func parseQuery(r *http.Request) (inventory.Query, error) {
+	v := r.URL.Query()
+	q := inventory.Query{
+		SAN:          v.Get("san"),
+		CN:           v.Get("cn"),
+		Subject:      v.Get("subject"),
+		IssuerSHA256: v.Get("issuer"),
+		Status:       v.Get("status"),
+	}
+	if q.Status != "" && q.Status != inventory.StatusValid && q.Status != inventory.StatusRevoked {
+		return q, fmt.Errorf("invalid status '%s' (expected %s or %s)", q.Status, inventory.StatusValid, inventory.StatusRevoked)
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"expires-before", &q.ExpiresBefore}, {"expires-after", &q.ExpiresAfter},
+		{"issued-before", &q.IssuedBefore}, {"issued-after", &q.IssuedAfter},
+	} {
+		s := v.Get(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			if t, err = time.Parse(time.RFC3339, s); err != nil {
+				return q, fmt.Errorf("invalid %s '%s' (expected YYYY-MM-DD or RFC 3339)", p.name, s)
+			}
+		}
+		*p.t = t
+	}
+	if s := v.Get("ca"); s != "" {
+		isCA, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid ca '%s' (expected true or false)", s)
+		}
+		q.CA = &isCA
+	}
+	return q, nil
+}
+
+// EncodeChain returns certs as concatenated PEM blocks.
+func EncodeChain(certs []*x509.Certificate) []byte {
+	var out []byte
+	for _, cert := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out
+}
+
+// decode reads the JSON body of r into v, answering a malformed body with 400.
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, WithStatus(http.StatusBadRequest, fmt.Errorf("malformed request: %w", err)))
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		status = e.Status
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	}
+	var limit *LimitError
+	if errors.As(err, &limit) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limit.RetryAfter.Seconds()))))
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// ServerTLSConfig returns the API's TLS configuration, which requires clients to present a
+// certificate issued by a CA in clientCAFile.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the server certificate: %w", err)
+	}
+	data, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read '%s': %w", clientCAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in '%s'", clientCAFile)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
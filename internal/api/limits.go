@@ -0,0 +1,214 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"my-pki/internal/inventory"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits contain runaway automation and compromised credentials. Zero values mean no limit.
+type Limits struct {
+	RatePerMinute int `yaml:"rate_per_minute,omitempty"` // requests per minute of each client
+	Burst         int `yaml:"burst,omitempty"`           // requests a client may make at once (default: its rate per minute)
+	// DailyQuotas bounds the certificates issued per profile and UTC day, over every front end.
+	// "*" applies to the profiles not listed.
+	DailyQuotas map[string]int `yaml:"daily_quotas,omitempty"`
+}
+
+// validate checks that no limit is negative.
+func (l *Limits) validate() error {
+	if l.RatePerMinute < 0 || l.Burst < 0 {
+		return errors.New("limits: rate_per_minute and burst must not be negative")
+	}
+	for profile, n := range l.DailyQuotas {
+		if n < 0 {
+			return fmt.Errorf("limits: daily quota of '%s' must not be negative", profile)
+		}
+	}
+	return nil
+}
+
+// LimitError refuses a request over a rate limit or quota. It is answered with 429 and a
+// Retry-After header.
+type LimitError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string { return e.Message }
+
+// Limiter enforces Limits and counts requests and issuances for the metrics. Its methods may be
+// called concurrently, and on a nil Limiter, which limits and counts nothing.
+type Limiter struct {
+	limits Limits
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	day      string         // UTC date the counts of issued are of
+	issued   map[string]int // per profile, today
+	requests map[string]int // per client
+	limited  map[string]int // per client
+	exceeded map[string]int // quota refusals per profile
+}
+
+// bucket is the token bucket of a client's rate limit.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter enforcing limits.
+func NewLimiter(limits Limits) *Limiter {
+	return &Limiter{
+		limits:   limits,
+		buckets:  map[string]*bucket{},
+		issued:   map[string]int{},
+		requests: map[string]int{},
+		limited:  map[string]int{},
+		exceeded: map[string]int{},
+	}
+}
+
+// Allow counts a request of p and refuses it once p exceeds its rate: its own, else that of the limits.
+func (l *Limiter) Allow(p *Principal) error {
+	if l == nil {
+		return nil
+	}
+	rate, burst := l.limits.RatePerMinute, l.limits.Burst
+	if p.RatePerMinute > 0 {
+		rate = p.RatePerMinute
+	}
+	if burst == 0 {
+		burst = rate
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests[p.Name]++
+	if rate == 0 {
+		return nil
+	}
+	now := time.Now()
+	b := l.buckets[p.Name]
+	if b == nil {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[p.Name] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Minutes()*float64(rate))
+	b.last = now
+	if b.tokens < 1 {
+		l.limited[p.Name]++
+		wait := time.Duration((1 - b.tokens) / float64(rate) * float64(time.Minute))
+		return WithStatus(http.StatusTooManyRequests, &LimitError{
+			Message:    fmt.Sprintf("%s exceeded its rate limit of %d requests per minute", p.Name, rate),
+			RetryAfter: wait,
+		})
+	}
+	b.tokens--
+	return nil
+}
+
+// quota returns the daily quota of profile, 0 for none.
+func (l *Limiter) quota(profile string) int {
+	if n, ok := l.limits.DailyQuotas[profile]; ok {
+		return n
+	}
+	return l.limits.DailyQuotas["*"]
+}
+
+// rollover starts the counts of a new day. The caller holds mu.
+func (l *Limiter) rollover(now time.Time) {
+	if day := now.UTC().Format(time.DateOnly); day != l.day {
+		l.day = day
+		clear(l.issued)
+	}
+}
+
+// CheckQuota refuses issuing a certificate of profile once today's quota of it is used up. Callers
+// serialize it with Issued, so that concurrent requests cannot both take the last one.
+func (l *Limiter) CheckQuota(profile string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.rollover(now)
+	quota := l.quota(profile)
+	if quota == 0 || l.issued[profile] < quota {
+		return nil
+	}
+	l.exceeded[profile]++
+	midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	return WithStatus(http.StatusTooManyRequests, &LimitError{
+		Message:    fmt.Sprintf("the daily quota of %d certificates of profile '%s' is used up until 00:00 UTC", quota, profile),
+		RetryAfter: midnight.Sub(now),
+	})
+}
+
+// Issued counts a certificate of profile against today's quota.
+func (l *Limiter) Issued(profile string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollover(time.Now())
+	l.issued[profile]++
+}
+
+// Seed counts the certificates of recs issued today, so a restarted server keeps today's quotas.
+// Records without an issuance time, from older inventories, count by their start of validity.
+func (l *Limiter) Seed(recs []*inventory.CertRecord) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.rollover(now)
+	midnight := now.UTC().Truncate(24 * time.Hour)
+	for _, rec := range recs {
+		issued := rec.NotBefore
+		if rec.IssuedAt != nil {
+			issued = *rec.IssuedAt
+		}
+		if rec.Profile != "" && !issued.Before(midnight) {
+			l.issued[rec.Profile]++
+		}
+	}
+}
+
+// WriteMetrics writes the counts and limits in the Prometheus text format.
+func (l *Limiter) WriteMetrics(w io.Writer) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollover(time.Now())
+	metric := func(name, kind, help, label string, values map[string]int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{%s=%s} %d\n", name, label, strconv.Quote(k), values[k])
+		}
+	}
+	metric("gosec_api_requests_total", "counter", "Authenticated API, gRPC, EST and CMP requests, by client.", "client", l.requests)
+	metric("gosec_api_rate_limited_total", "counter", "Requests refused by the client's rate limit.", "client", l.limited)
+	fmt.Fprintf(w, "# HELP gosec_api_rate_limit_per_minute Requests per minute each client may make by default, 0 for no limit.\n")
+	fmt.Fprintf(w, "# TYPE gosec_api_rate_limit_per_minute gauge\ngosec_api_rate_limit_per_minute %d\n", l.limits.RatePerMinute)
+	metric("gosec_issued_today", "gauge", "Certificates issued today (UTC), by profile.", "profile", l.issued)
+	metric("gosec_daily_quota", "gauge", "Certificates that may be issued per day, by profile; * for the profiles not listed.", "profile", l.limits.DailyQuotas)
+	metric("gosec_quota_exceeded_total", "counter", "Issuances refused by the daily quota of their profile.", "profile", l.exceeded)
+}
@@ -0,0 +1,156 @@
+// Package audit keeps the operation audit log: an append-only file recording who issued, revoked,
+// reconstructed or re-split what, when and with which parameters.
+//
+// Each line is one JSON entry carrying the SHA-256 of the entry before it, so removing, reordering or
+// editing an entry breaks the chain from that point on. Truncating the end of the log is not
+// detectable from the log alone; compare its length with a copy kept elsewhere.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Events recorded in the log.
+const (
+	EventIssue      = "issue"      // a certificate was issued
+	EventRevoke     = "revoke"     // a certificate was revoked or put on hold
+	EventUnhold     = "unhold"     // a certificate was released from hold
+	EventCombine    = "combine"    // a CA key was reconstructed from shares
+	EventSplit      = "split"      // a CA key was split into shares
+	EventReshare    = "reshare"    // a CA key was re-split into a new set of shares
+	EventCRL        = "crl"        // a CRL was issued
+	EventCompromise = "compromise" // a CA was declared compromised
+)
+
+// Entry is one recorded operation.
+type Entry struct {
+	Seq          int               `json:"seq"`
+	Time         time.Time         `json:"time"`
+	Event        string            `json:"event"`
+	Operator     string            `json:"operator"`
+	Host         string            `json:"host"`
+	Command      string            `json:"command"`
+	CA           string            `json:"ca,omitempty"` // subject of the CA concerned
+	Params       map[string]string `json:"params,omitempty"`
+	Fingerprints []string          `json:"fingerprints,omitempty"` // SHA-256 of the certificates concerned
+	Prev         string            `json:"prev"`                   // hash of the previous entry; empty for the first
+	Hash         string            `json:"hash"`
+}
+
+// PathForInventory returns the default log next to an inventory, e.g. "db/inventory.db" -> "db/inventory.audit.log".
+func PathForInventory(dbPath string) string {
+	return strings.TrimSuffix(dbPath, filepath.Ext(dbPath)) + ".audit.log"
+}
+
+// hash returns the hex SHA-256 of the entry's JSON encoding without its Hash.
+func (e Entry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Open reads the log at path. A missing file yields no entries.
+func Open(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("malformed audit log line %d: %w", lineNo, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log '%s': %w", path, err)
+	}
+	return entries, nil
+}
+
+// Append chains e to the last entry of the log at path and appends it, creating the log if needed.
+// Seq, Prev and Hash are filled in.
+func Append(path string, e *Entry) error {
+	entries, err := Open(path)
+	if err != nil {
+		return err
+	}
+	e.Seq, e.Prev = 1, ""
+	if n := len(entries); n > 0 {
+		e.Seq, e.Prev = entries[n-1].Seq+1, entries[n-1].Hash
+	}
+	e.Time = e.Time.UTC()
+	if e.Hash, err = e.hash(); err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("unable to open audit log '%s': %w", path, err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to append to audit log '%s': %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to append to audit log '%s': %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log '%s': %w", path, err)
+	}
+	return nil
+}
+
+// Verify checks that every entry's hash matches its contents, chains to the one before it and
+// follows it in sequence.
+func Verify(entries []Entry) error {
+	prev, seq := "", 0
+	for _, e := range entries {
+		if e.Seq != seq+1 {
+			return fmt.Errorf("entry %d follows entry %d: entries are missing or reordered", e.Seq, seq)
+		}
+		if e.Prev != prev {
+			return fmt.Errorf("entry %d does not chain to the entry before it", e.Seq)
+		}
+		h, err := e.hash()
+		if err != nil {
+			return err
+		}
+		if h != e.Hash {
+			return fmt.Errorf("entry %d was modified after it was written", e.Seq)
+		}
+		prev, seq = e.Hash, e.Seq
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+// Package batch turns a reviewed issuance manifest into a machine-readable plan and executes it.
+//
+// `pki plan` validates a manifest and writes a JSON plan describing exactly what would be issued;
+// the plan is reviewed (e.g. in a pull request) and `pki apply` executes it once the CA key is
+// available. Apply refuses to run if the CA certificate or any referenced public key changed since
+// the plan was made.
+package batch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"my-pki/internal/caconfig"
+	"my-pki/internal/utils"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PlanVersion identifies the plan format.
+const PlanVersion = 1
+
+// keyUsageNames maps manifest key usage names (the same as the sign flags) to x509 bits.
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digital-signature": x509.KeyUsageDigitalSignature,
+	"key-encipherment":  x509.KeyUsageKeyEncipherment,
+	"data-encipherment": x509.KeyUsageDataEncipherment,
+	"key-agreement":     x509.KeyUsageKeyAgreement,
+	"crl-sign":          x509.KeyUsageCRLSign,
+	"encipher-only":     x509.KeyUsageEncipherOnly,
+	"decipher-only":     x509.KeyUsageDecipherOnly,
+}
+
+// Subject mirrors the subject flags of the issuing commands.
+type Subject struct {
+	CN       string `yaml:"cn" json:"cn"`
+	Org      string `yaml:"org,omitempty" json:"org,omitempty"`
+	OU       string `yaml:"ou,omitempty" json:"ou,omitempty"`
+	Locality string `yaml:"locality,omitempty" json:"locality,omitempty"`
+	Province string `yaml:"province,omitempty" json:"province,omitempty"`
+	Country  string `yaml:"country,omitempty" json:"country,omitempty"`
+}
+
+// Name converts the subject to a pkix.Name.
+func (s Subject) Name() pkix.Name {
+	var name pkix.Name
+	if s.Org != "" {
+		name.Organization = []string{s.Org}
+	}
+	if s.OU != "" {
+		name.OrganizationalUnit = []string{s.OU}
+	}
+	if s.Locality != "" {
+		name.Locality = []string{s.Locality}
+	}
+	if s.Province != "" {
+		name.Province = []string{s.Province}
+	}
+	if s.Country != "" {
+		name.Country = []string{s.Country}
+	}
+	name.CommonName = s.CN
+	return name
+}
+
+// normalized applies the name normalization rules n to s.
+func (s Subject) normalized(n utils.Normalization) Subject {
+	name := n.Subject(s.Name())
+	first := func(values []string) string {
+		if len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	}
+	return Subject{
+		CN:       name.CommonName,
+		Org:      first(name.Organization),
+		OU:       first(name.OrganizationalUnit),
+		Locality: first(name.Locality),
+		Province: first(name.Province),
+		Country:  first(name.Country),
+	}
+}
+
+// ManifestCert is one certificate requested in a manifest.
+type ManifestCert struct {
+	Subject  `yaml:",inline"`
+	Days     int                       `yaml:"days,omitempty"`
+	KeyUsage []string                  `yaml:"key_usage,omitempty"`
+	Policies []utils.CertificatePolicy `yaml:"policies,omitempty"`
+	PubkeyIn string                    `yaml:"pubkey_in,omitempty"`
+	KeyOut   string                    `yaml:"key_out,omitempty"`
+	CertOut  string                    `yaml:"cert_out"`
+}
+
+// Manifest is the desired batch of issuances from one CA.
+type Manifest struct {
+	CAPem        string         `yaml:"ca_pem"`
+	Certificates []ManifestCert `yaml:"certificates"`
+}
+
+// Action is one step of a plan.
+type Action struct {
+	Action        string                    `json:"action"` // "issue"
+	Profile       string                    `json:"profile"`
+	Subject       Subject                   `json:"subject"`
+	Days          int                       `json:"days"`
+	KeyUsage      []string                  `json:"key_usage,omitempty"`
+	Policies      []utils.CertificatePolicy `json:"policies,omitempty"`
+	PubkeyIn      string                    `json:"pubkey_in,omitempty"`
+	PubkeySHA256  string                    `json:"pubkey_sha256,omitempty"`
+	KeyOut        string                    `json:"key_out,omitempty"`
+	CertOut       string                    `json:"cert_out"`
+	SubjectString string                    `json:"subject_dn"` // for reviewers only
+}
+
+// Plan is the reviewed, machine-readable list of actions.
+type Plan struct {
+	Version        int       `json:"version"`
+	Created        time.Time `json:"created"`
+	ManifestSHA256 string    `json:"manifest_sha256"`
+	CAPem          string    `json:"ca_pem"`
+	CASHA256       string    `json:"ca_sha256"`
+	CASubject      string    `json:"ca_subject"`
+	Actions        []Action  `json:"actions"`
+
+	// SHA256 is the hash of the plan file as loaded, so operators can confirm it is the reviewed one.
+	SHA256 string `json:"-"`
+}
+
+// LoadManifest reads a YAML manifest.
+func LoadManifest(path string) (*Manifest, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read manifest '%s': %w", path, err)
+	}
+	var m Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest '%s': %w", path, err)
+	}
+	return &m, data, nil
+}
+
+// MakePlan validates the manifest against the CA and its configuration and returns the plan.
+// All problems are reported together so a manifest can be fixed in one pass.
+func MakePlan(m *Manifest, manifestData []byte, now time.Time) (*Plan, error) {
+	if m.CAPem == "" {
+		return nil, errors.New("manifest must set ca_pem")
+	}
+	caCert, caSum, err := fingerprintCA(m.CAPem)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := caconfig.LoadForCA(m.CAPem)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(manifestData)
+	plan := &Plan{
+		Version:        PlanVersion,
+		Created:        now.UTC(),
+		ManifestSHA256: hex.EncodeToString(sum[:]),
+		CAPem:          m.CAPem,
+		CASHA256:       caSum,
+		CASubject:      caCert.Subject.String(),
+	}
+
+	var problems []string
+	outputs := make(map[string]int)
+	for i, c := range m.Certificates {
+		fail := func(format string, args ...any) {
+			problems = append(problems, fmt.Sprintf("certificate %d (%s): %s", i+1, c.CN, fmt.Sprintf(format, args...)))
+		}
+		if c.CN == "" {
+			fail("cn is required")
+		}
+		if c.CertOut == "" {
+			fail("cert_out is required")
+		}
+		if c.PubkeyIn != "" && c.KeyOut != "" {
+			fail("pubkey_in and key_out are mutually exclusive")
+		}
+		for _, out := range []string{c.CertOut, c.KeyOut} {
+			if out == "" {
+				continue
+			}
+			if prev, ok := outputs[out]; ok {
+				fail("output '%s' is also written by certificate %d", out, prev)
+			}
+			outputs[out] = i + 1
+			if _, err := os.Stat(out); err == nil {
+				fail("output '%s' already exists", out)
+			}
+		}
+		if _, err := KeyUsage(c.KeyUsage); err != nil {
+			fail("%v", err)
+		}
+		if _, err := utils.CertificatePoliciesExtension(c.Policies); err != nil {
+			fail("%v", err)
+		}
+
+		days := c.Days
+		if days == 0 {
+			days = 365
+		}
+		days, err := cfg.CheckIssuance(caconfig.ProfileLeaf, days, c.Days != 0)
+		if err != nil {
+			fail("%v", err)
+		}
+		policies := c.Policies
+		if len(policies) == 0 {
+			policies = cfg.Settings(caconfig.ProfileLeaf).Policies
+		}
+
+		subject := c.Subject.normalized(cfg.Settings(caconfig.ProfileLeaf).Normalization())
+		action := Action{
+			Action:        "issue",
+			Profile:       caconfig.ProfileLeaf,
+			Subject:       subject,
+			Days:          days,
+			KeyUsage:      c.KeyUsage,
+			Policies:      policies,
+			PubkeyIn:      c.PubkeyIn,
+			KeyOut:        c.KeyOut,
+			CertOut:       c.CertOut,
+			SubjectString: subject.Name().String(),
+		}
+		if c.PubkeyIn != "" {
+			if action.PubkeySHA256, err = fingerprintPublicKey(c.PubkeyIn); err != nil {
+				fail("%v", err)
+			}
+		}
+		plan.Actions = append(plan.Actions, action)
+	}
+	if len(plan.Actions) == 0 {
+		problems = append(problems, "manifest contains no certificates")
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("manifest is invalid:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return plan, nil
+}
+
+// WritePlan writes the plan as indented JSON and returns the SHA-256 of the written file.
+func WritePlan(p *Plan, path string) (string, error) {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode plan: %w", err)
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write plan '%s': %w", path, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// LoadPlan reads a plan and checks that the CA and all referenced public keys are unchanged
+// and that the CA configuration still permits every action.
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read plan '%s': %w", path, err)
+	}
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid plan '%s': %w", path, err)
+	}
+	if p.Version != PlanVersion {
+		return nil, fmt.Errorf("unsupported plan version %d", p.Version)
+	}
+	sum := sha256.Sum256(data)
+	p.SHA256 = hex.EncodeToString(sum[:])
+	cfg, err := caconfig.LoadForCA(p.CAPem)
+	if err != nil {
+		return nil, err
+	}
+	if _, sum, err := fingerprintCA(p.CAPem); err != nil {
+		return nil, err
+	} else if sum != p.CASHA256 {
+		return nil, fmt.Errorf("CA certificate '%s' changed since the plan was made", p.CAPem)
+	}
+	for i, a := range p.Actions {
+		if a.Action != "issue" {
+			return nil, fmt.Errorf("action %d: unsupported action '%s'", i+1, a.Action)
+		}
+		// The CA configuration may have been tightened since the plan was reviewed
+		if _, err := cfg.CheckIssuance(a.Profile, a.Days, true); err != nil {
+			return nil, fmt.Errorf("action %d: %w", i+1, err)
+		}
+		if a.PubkeyIn != "" {
+			sum, err := fingerprintPublicKey(a.PubkeyIn)
+			if err != nil {
+				return nil, fmt.Errorf("action %d: %w", i+1, err)
+			}
+			if sum != a.PubkeySHA256 {
+				return nil, fmt.Errorf("action %d: public key '%s' changed since the plan was made", i+1, a.PubkeyIn)
+			}
+		}
+		for _, out := range []string{a.CertOut, a.KeyOut} {
+			if out == "" {
+				continue
+			}
+			if _, err := os.Stat(out); err == nil {
+				return nil, fmt.Errorf("action %d: output '%s' already exists", i+1, out)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// KeyUsage converts key usage names to x509 bits.
+func KeyUsage(names []string) (x509.KeyUsage, error) {
+	var ku x509.KeyUsage
+	for _, n := range names {
+		bit, ok := keyUsageNames[n]
+		if !ok {
+			known := make([]string, 0, len(keyUsageNames))
+			for k := range keyUsageNames {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return 0, fmt.Errorf("unknown key usage '%s' (expected one of %s)", n, strings.Join(known, ", "))
+		}
+		ku |= bit
+	}
+	return ku, nil
+}
+
+// KeyUsageNames is the inverse of KeyUsage, listing the manifest names of the bits set in ku.
+func KeyUsageNames(ku x509.KeyUsage) []string {
+	var names []string
+	for n, bit := range keyUsageNames {
+		if ku&bit != 0 {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SubjectFromName takes the first value of each supported attribute of name.
+func SubjectFromName(name pkix.Name) Subject {
+	first := func(v []string) string {
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0]
+	}
+	return Subject{
+		CN:       name.CommonName,
+		Org:      first(name.Organization),
+		OU:       first(name.OrganizationalUnit),
+		Locality: first(name.Locality),
+		Province: first(name.Province),
+		Country:  first(name.Country),
+	}
+}
+
+// fingerprintCA parses the CA certificate and returns its SHA-256 fingerprint.
+func fingerprintCA(path string) (*x509.Certificate, string, error) {
+	cert, err := utils.ParseCertificateFromFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse CA certificate from '%s': %w", path, err)
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return cert, hex.EncodeToString(sum[:]), nil
+}
+
+// fingerprintPublicKey returns the SHA-256 of the DER public key in a PUBLIC KEY or CSR file.
+func fingerprintPublicKey(path string) (string, error) {
+	pub, err := utils.ParsePublicKeyFile(path)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal public key from '%s': %w", path, err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
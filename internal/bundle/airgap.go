@@ -0,0 +1,174 @@
+package bundle
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"maps"
+	"my-pki/internal/utils"
+	"time"
+)
+
+// Air-gap bundle kinds.
+const (
+	KindRequests = "requests" // CSRs carried to the offline CA
+	KindSigned   = "signed"   // certificates carried back from it
+)
+
+// signatureName is the archive member holding the signature over the manifest.
+const signatureName = "manifest.sig"
+
+// AirgapManifest describes an air-gap bundle: certificate requests for an offline CA, or the
+// certificates it issued for them. File fields name archive members.
+type AirgapManifest struct {
+	Version  int               `json:"version"`
+	Kind     string            `json:"kind"`
+	Created  time.Time         `json:"created"`
+	Signer   string            `json:"signer"`            // PEM public key that signed the manifest
+	Answers  string            `json:"answers,omitempty"` // signed bundles: ID of the request bundle
+	CA       string            `json:"ca,omitempty"`      // signed bundles: issuing CA certificate
+	Requests []AirgapRequest   `json:"requests"`
+	SHA256   map[string]string `json:"sha256"` // member name -> hex SHA-256
+}
+
+// AirgapRequest is one request in an air-gap bundle and, in a signed bundle, its outcome.
+type AirgapRequest struct {
+	ID        string   `json:"id"`
+	Requester string   `json:"requester,omitempty"`
+	Note      string   `json:"note,omitempty"`
+	Subject   string   `json:"subject"`
+	Names     []string `json:"names,omitempty"`
+	CSR       string   `json:"csr,omitempty"`
+	Cert      string   `json:"cert,omitempty"`
+	Approver  string   `json:"approver,omitempty"`
+	Error     string   `json:"error,omitempty"` // why no certificate was issued
+}
+
+// Airgap is an air-gap manifest and the files it lists.
+type Airgap struct {
+	AirgapManifest
+	Files     map[string][]byte
+	ID        string           // hex SHA-256 of the signed manifest, set by WriteAirgap and ReadAirgap
+	SignerKey crypto.PublicKey // parsed Signer, set by ReadAirgap
+}
+
+// Add stores a file in the bundle and returns its name.
+func (a *Airgap) Add(name string, data []byte) string {
+	if a.Files == nil {
+		a.Files = map[string][]byte{}
+	}
+	a.Files[name] = data
+	return name
+}
+
+// WriteAirgap signs a's manifest with signer and packs it into a new archive at filePath.
+func WriteAirgap(filePath string, a *Airgap, signer crypto.Signer) error {
+	if a.Kind != KindRequests && a.Kind != KindSigned {
+		return fmt.Errorf("unknown bundle kind '%s'", a.Kind)
+	}
+	if _, ok := signer.Public().(*ecdsa.PublicKey); !ok {
+		return fmt.Errorf("unsupported signing key type %T (expected ECDSA)", signer.Public())
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return fmt.Errorf("failed to encode signer public key: %w", err)
+	}
+	a.Version = Version
+	a.Signer = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	var names []string
+	a.SHA256, names = hashFiles(a.Files)
+	manifest, err := json.MarshalIndent(a.AirgapManifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+	digest := sha256.Sum256(manifest)
+	sig, err := signer.Sign(utils.Rand, digest[:], crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("failed to sign bundle manifest: %w", err)
+	}
+	a.ID = hex.EncodeToString(digest[:])
+
+	files := maps.Clone(a.Files)
+	if files == nil {
+		files = map[string][]byte{}
+	}
+	files[signatureName] = sig
+	return writeArchive(filePath, a.Created, manifest, files, append(names, signatureName))
+}
+
+// ReadAirgap unpacks an air-gap bundle of the given kind and checks its manifest signature and file hashes.
+// The signature only proves possession of the key in SignerKey; callers must check that the key is trusted.
+func ReadAirgap(filePath, kind string) (*Airgap, error) {
+	manifest, files, err := readArchive(filePath)
+	if err != nil {
+		return nil, err
+	}
+	sig, ok := files[signatureName]
+	if !ok {
+		return nil, fmt.Errorf("bundle '%s' is not signed", filePath)
+	}
+	delete(files, signatureName)
+
+	a := &Airgap{Files: files}
+	if err := json.Unmarshal(manifest, &a.AirgapManifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if a.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version %d (expected %d)", a.Version, Version)
+	}
+	if a.Kind != kind {
+		return nil, fmt.Errorf("'%s' is a %s bundle, expected a %s bundle", filePath, a.Kind, kind)
+	}
+	block, _ := pem.Decode([]byte(a.Signer))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("bundle manifest lacks the signer public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signer public key in bundle: %w", err)
+	}
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signer key type %T (expected ECDSA)", pub)
+	}
+	digest := sha256.Sum256(manifest)
+	if !ecdsa.VerifyASN1(ecPub, digest[:], sig) {
+		return nil, fmt.Errorf("bundle '%s' has an invalid signature", filePath)
+	}
+	a.SignerKey = pub
+	a.ID = hex.EncodeToString(digest[:])
+
+	if err := checkHashes(a.SHA256, a.Files); err != nil {
+		return nil, err
+	}
+	if kind == KindSigned {
+		if _, ok := a.Files[a.CA]; !ok {
+			return nil, errors.New("signed bundle lacks the issuing CA certificate")
+		}
+	}
+	seen := map[string]bool{}
+	for _, req := range a.Requests {
+		if req.ID == "" || seen[req.ID] {
+			return nil, fmt.Errorf("bundle lists request '%s' more than once or without an ID", req.ID)
+		}
+		seen[req.ID] = true
+		for _, name := range []string{req.CSR, req.Cert} {
+			if _, ok := a.Files[name]; name != "" && !ok {
+				return nil, fmt.Errorf("bundle manifest references missing file '%s'", name)
+			}
+		}
+		switch {
+		case kind == KindRequests && req.CSR == "":
+			return nil, fmt.Errorf("request '%s' in bundle has no CSR", req.ID)
+		case kind == KindSigned && req.Cert == "" && req.Error == "":
+			return nil, fmt.Errorf("request '%s' in bundle has neither a certificate nor an error", req.ID)
+		}
+	}
+	return a, nil
+}
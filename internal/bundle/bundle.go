@@ -0,0 +1,217 @@
+// Package bundle reads and writes issuing bundles: the files an online issuing CA host needs from the
+// offline root workspace (issuing CA certificate, chain, root certificate, root CRL and CA configuration),
+// packed in a gzip-compressed tar archive with a manifest of SHA-256 hashes.
+//
+// A bundle never contains key material; the issuing CA's shares travel with their custodians.
+//
+// The same archive format carries air-gap bundles (see Airgap): certificate requests taken to an
+// offline CA and the certificates brought back, with a signed manifest.
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// Version identifies the bundle format.
+const Version = 1
+
+// manifestName is the archive member holding the Manifest.
+const manifestName = "manifest.json"
+
+// maxFileSize bounds each member read from a bundle.
+const maxFileSize = 16 << 20
+
+// Manifest describes the contents of a bundle. File fields name archive members.
+type Manifest struct {
+	Version   int               `json:"version"`
+	Created   time.Time         `json:"created"`
+	IssuingCA string            `json:"issuing_ca"`
+	Chain     string            `json:"chain"`
+	Root      string            `json:"root"`
+	CRL       string            `json:"crl,omitempty"`
+	Config    string            `json:"config,omitempty"`
+	SHA256    map[string]string `json:"sha256"` // member name -> hex SHA-256
+}
+
+// Bundle is a manifest and the files it lists.
+type Bundle struct {
+	Manifest
+	Files map[string][]byte
+}
+
+// Add stores a file in the bundle and returns its name.
+func (b *Bundle) Add(name string, data []byte) string {
+	if b.Files == nil {
+		b.Files = map[string][]byte{}
+	}
+	b.Files[name] = data
+	return name
+}
+
+// Write packs b into a new archive at path; an existing file is not overwritten.
+func Write(filePath string, b *Bundle) error {
+	b.Version = Version
+	var names []string
+	b.SHA256, names = hashFiles(b.Files)
+	manifest, err := json.MarshalIndent(b.Manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+
+	return writeArchive(filePath, b.Created, manifest, b.Files, names)
+}
+
+// writeArchive packs the manifest and files (in the order of names) into a new archive at filePath.
+func writeArchive(filePath string, created time.Time, manifest []byte, files map[string][]byte, names []string) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: created}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(manifestName, manifest); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	for _, name := range names {
+		if err := add(name, files[name]); err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle '%s': %w", filePath, err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write bundle '%s': %w", filePath, err)
+	}
+	return f.Close()
+}
+
+// Read unpacks the archive at path and checks every file against the manifest.
+func Read(filePath string) (*Bundle, error) {
+	manifest, files, err := readArchive(filePath)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{Files: files}
+	if err := json.Unmarshal(manifest, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if b.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version %d (expected %d)", b.Version, Version)
+	}
+
+	if err := checkHashes(b.SHA256, b.Files); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{b.IssuingCA, b.Chain, b.Root, b.CRL, b.Config} {
+		if _, ok := b.Files[name]; name != "" && !ok {
+			return nil, fmt.Errorf("bundle manifest references missing file '%s'", name)
+		}
+	}
+	if b.IssuingCA == "" || b.Chain == "" || b.Root == "" {
+		return nil, errors.New("bundle manifest lacks the issuing CA, chain or root")
+	}
+	return b, nil
+}
+
+// readArchive unpacks the archive at filePath into its manifest and the other members.
+func readArchive(filePath string) ([]byte, map[string][]byte, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open bundle '%s': %w", filePath, err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("'%s' is not a bundle: %w", filePath, err)
+	}
+	tr := tar.NewReader(zr)
+
+	files := map[string][]byte{}
+	var manifest []byte
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("corrupt bundle '%s': %w", filePath, err)
+		}
+		// Members are flat files; anything else could escape the import directory
+		if hdr.Typeflag != tar.TypeReg || hdr.Name != path.Base(hdr.Name) || hdr.Name == "." || hdr.Name == ".." {
+			return nil, nil, fmt.Errorf("bundle '%s' contains unexpected member '%s'", filePath, hdr.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxFileSize+1))
+		if err != nil {
+			return nil, nil, fmt.Errorf("corrupt bundle '%s': %w", filePath, err)
+		}
+		if len(data) > maxFileSize {
+			return nil, nil, fmt.Errorf("bundle member '%s' is too large", hdr.Name)
+		}
+		if hdr.Name == manifestName {
+			manifest = data
+			continue
+		}
+		if _, dup := files[hdr.Name]; dup {
+			return nil, nil, fmt.Errorf("bundle '%s' contains '%s' twice", filePath, hdr.Name)
+		}
+		files[hdr.Name] = data
+	}
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("bundle '%s' has no %s", filePath, manifestName)
+	}
+	return manifest, files, nil
+}
+
+// hashFiles returns the hex SHA-256 of every file and the sorted file names.
+func hashFiles(files map[string][]byte) (map[string]string, []string) {
+	sums := map[string]string{}
+	names := make([]string, 0, len(files))
+	for name, data := range files {
+		sum := sha256.Sum256(data)
+		sums[name] = hex.EncodeToString(sum[:])
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return sums, names
+}
+
+// checkHashes verifies that files are exactly those listed in sums, with matching SHA-256 hashes.
+func checkHashes(sums map[string]string, files map[string][]byte) error {
+	if len(sums) != len(files) {
+		return fmt.Errorf("bundle lists %d files but contains %d", len(sums), len(files))
+	}
+	for name, data := range files {
+		sum := sha256.Sum256(data)
+		if sums[name] != hex.EncodeToString(sum[:]) {
+			return fmt.Errorf("bundle member '%s' does not match the manifest hash", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,444 @@
+// Package caconfig reads and writes the per-CA configuration file kept next to each CA certificate.
+//
+// The file declares which issuance profiles the CA may be used for, and defaults per profile,
+// so that e.g. a root CA can be restricted to only ever signing sub CAs:
+//
+//	allowed_profiles: [subca]
+//	distribution:           # embedded in issued certificates as CRL distribution points and AIA
+//	  base_url: http://pki.example.com   # where serve-dist publishes this CA
+//	  ocsp_urls: [http://ocsp.example.com]
+//	profiles:
+//	  subca:
+//	    days: 1825
+//	    max_days: 3650
+//	    policies:
+//	      - oid: 1.3.6.1.4.1.99999.1.1
+//	        cps_uri: https://pki.example.com/cps
+//	    pre_issue: ["./check-naming.sh"]
+//	    post_issue: ["./push-to-inventory.sh"]
+//	    output:             # permissions of written files (default: certs 0644, keys and shares 0600)
+//	      umask: "027"
+//	      keys: "0640:svc:pki"
+//	  leaf:
+//	    normalize:          # rules applied to names before policy checks; all are on when omitted
+//	      lowercase_hostnames: true
+//	      strip_trailing_dots: true
+//	      canonical_ips: true
+//	      deduplicate: true
+//	      trim_subject: true
+//	  server-tls:         # the built-in named profiles can be tightened, and new ones defined
+//	    max_days: 90
+//	    sans: {require: true, types: [dns], dns_domains: [example.com]}
+//	  vpn:
+//	    key_usage: [digitalSignature, keyAgreement]
+//	    ext_key_usage: [clientAuth, serverAuth]
+//	combine:              # guards the reconstruction of the key from its shares
+//	  delay: 24h          # combining must be requested this long in advance
+//	  webhooks: [https://hooks.example.com/pki]
+//	  email:
+//	    smtp: mail.example.com:587
+//	    from: pki@example.com
+//	    to: [security@example.com]
+//
+// A CA without a configuration file is unrestricted.
+package caconfig
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"my-pki/internal/dist"
+	"my-pki/internal/notify"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Built-in profiles used by the issuing commands. Leaf and subca are the kinds of every profile;
+// the named leaf profiles come with key usages, validity caps and SAN rules that a CA may override.
+const (
+	ProfileLeaf        = "leaf"
+	ProfileSubCA       = "subca"
+	ProfileServerTLS   = "server-tls"
+	ProfileClientMTLS  = "client-mtls"
+	ProfileCodeSigning = "code-signing"
+)
+
+// builtinProfiles are the defaults of the named profiles.
+var builtinProfiles = map[string]ProfileSettings{
+	ProfileServerTLS: {
+		KeyUsage:    []string{"digitalSignature"},
+		ExtKeyUsage: []string{"serverAuth"},
+		MaxDays:     398,
+		SANs:        &utils.SANRules{Require: true, Types: []string{utils.SANTypeDNS, utils.SANTypeIP}},
+	},
+	ProfileClientMTLS: {
+		KeyUsage:    []string{"digitalSignature"},
+		ExtKeyUsage: []string{"clientAuth"},
+		MaxDays:     825,
+		SANs:        &utils.SANRules{Types: []string{utils.SANTypeDNS, utils.SANTypeEmail, utils.SANTypeURI}},
+	},
+	ProfileCodeSigning: {
+		KeyUsage:     []string{"digitalSignature"},
+		ExtKeyUsage:  []string{"codeSigning"},
+		MaxDays:      1095,
+		KeyAlgorithm: utils.KeyAlgorithmECDSAP384,
+		SANs:         &utils.SANRules{Types: []string{utils.SANTypeEmail, utils.SANTypeURI}},
+	},
+}
+
+// ProfileSettings holds the per-CA defaults for one profile.
+type ProfileSettings struct {
+	Days      int                       `yaml:"days,omitempty"`       // default validity when none is requested explicitly
+	MaxDays   int                       `yaml:"max_days,omitempty"`   // upper bound on the validity of issued certificates
+	Policies  []utils.CertificatePolicy `yaml:"policies,omitempty"`   // certificatePolicies added to issued certificates
+	PreIssue  []string                  `yaml:"pre_issue,omitempty"`  // shell commands that must succeed before issuance
+	PostIssue []string                  `yaml:"post_issue,omitempty"` // shell commands run after issuance
+	Normalize *utils.Normalization      `yaml:"normalize,omitempty"`  // name normalization rules (default: all)
+	Output    *OutputSettings           `yaml:"output,omitempty"`     // permissions of the files written when issuing
+	// Distribution overrides the CA's distribution settings for this profile
+	Distribution *DistributionSettings `yaml:"distribution,omitempty"`
+	Kind         string                `yaml:"kind,omitempty"`          // leaf or subca; profiles defined in the file are leaf by default
+	KeyUsage     []string              `yaml:"key_usage,omitempty"`     // RFC 5280 names, e.g. digitalSignature
+	ExtKeyUsage  []string              `yaml:"ext_key_usage,omitempty"` // e.g. serverAuth, clientAuth, codeSigning
+	KeyAlgorithm string                `yaml:"key_algorithm,omitempty"` // ecdsa-p256 or ecdsa-p384, for generated and certified keys
+	SANs         *utils.SANRules       `yaml:"sans,omitempty"`          // restrictions on subject alternative names
+}
+
+// merge returns s with the settings configured in over replacing its own.
+func (s ProfileSettings) merge(over ProfileSettings) ProfileSettings {
+	if over.Days != 0 {
+		s.Days = over.Days
+	}
+	if over.MaxDays != 0 {
+		s.MaxDays = over.MaxDays
+	}
+	if over.Policies != nil {
+		s.Policies = over.Policies
+	}
+	if over.PreIssue != nil {
+		s.PreIssue = over.PreIssue
+	}
+	if over.PostIssue != nil {
+		s.PostIssue = over.PostIssue
+	}
+	if over.Normalize != nil {
+		s.Normalize = over.Normalize
+	}
+	if over.Output != nil {
+		s.Output = over.Output
+	}
+	if over.Distribution != nil {
+		s.Distribution = over.Distribution
+	}
+	if over.Kind != "" {
+		s.Kind = over.Kind
+	}
+	if over.KeyUsage != nil {
+		s.KeyUsage = over.KeyUsage
+	}
+	if over.ExtKeyUsage != nil {
+		s.ExtKeyUsage = over.ExtKeyUsage
+	}
+	if over.KeyAlgorithm != "" {
+		s.KeyAlgorithm = over.KeyAlgorithm
+	}
+	if over.SANs != nil {
+		s.SANs = over.SANs
+	}
+	return s
+}
+
+// Usages parses the key usages and extended key usages of the profile.
+func (s ProfileSettings) Usages() (x509.KeyUsage, []x509.ExtKeyUsage, error) {
+	ku, err := utils.ParseKeyUsage(s.KeyUsage)
+	if err != nil {
+		return 0, nil, err
+	}
+	ekus, err := utils.ParseExtKeyUsage(s.ExtKeyUsage)
+	if err != nil {
+		return 0, nil, err
+	}
+	return ku, ekus, nil
+}
+
+// KeyUsageFor returns the key usages to issue: requested, which may only narrow the profile's, or
+// the profile's when none are requested.
+func (s ProfileSettings) KeyUsageFor(requested x509.KeyUsage) (x509.KeyUsage, error) {
+	allowed, _, err := s.Usages()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case allowed == 0:
+		return requested, nil
+	case requested == 0:
+		return allowed, nil
+	case requested&^allowed != 0:
+		return 0, fmt.Errorf("key usage %s is not allowed (allowed: %s)",
+			strings.Join(utils.KeyUsageNames(requested&^allowed), ", "), strings.Join(utils.KeyUsageNames(allowed), ", "))
+	}
+	return requested, nil
+}
+
+// CheckSANs verifies sans against the profile's SAN rules, if any.
+func (s ProfileSettings) CheckSANs(sans utils.SANs) error {
+	if s.SANs == nil {
+		return nil
+	}
+	return s.SANs.Check(sans)
+}
+
+// DistributionSettings say where relying parties find a CA's certificate, CRLs and OCSP responder.
+// The certificates the CA issues point to them. Lists left empty are derived from BaseURL, where
+// serve-dist publishes the CA under Name.
+type DistributionSettings struct {
+	BaseURL       string   `yaml:"base_url,omitempty"`
+	Name          string   `yaml:"name,omitempty"` // default: the CA certificate file name without extension
+	CRLURLs       []string `yaml:"crl_urls,omitempty"`
+	CAIssuersURLs []string `yaml:"ca_issuers_urls,omitempty"`
+	OCSPURLs      []string `yaml:"ocsp_urls,omitempty"`
+	DeltaCRLURLs  []string `yaml:"delta_crl_urls,omitempty"` // advertised by full CRLs
+	Deltas        bool     `yaml:"deltas,omitempty"`         // BaseURL also serves delta CRLs
+}
+
+// Resolve returns d with the URLs derived from BaseURL filled in, for the CA certificate at caPemPath.
+func (d DistributionSettings) Resolve(caPemPath string) DistributionSettings {
+	if d.BaseURL == "" {
+		return d
+	}
+	name := d.Name
+	if name == "" {
+		name = dist.NewCA(caPemPath).Name
+	}
+	if len(d.CRLURLs) == 0 {
+		d.CRLURLs = []string{dist.CRLURL(d.BaseURL, name)}
+	}
+	if len(d.CAIssuersURLs) == 0 {
+		d.CAIssuersURLs = []string{dist.CertURL(d.BaseURL, name)}
+	}
+	if len(d.DeltaCRLURLs) == 0 && d.Deltas {
+		d.DeltaCRLURLs = []string{dist.DeltaCRLURL(d.BaseURL, name)}
+	}
+	return d
+}
+
+// OutputSettings sets the permissions of written certificates, private keys and key shares.
+// Each file kind takes "MODE[:OWNER[:GROUP]]" (see utils.ParseFilePerms); umask narrows the
+// default modes of the kinds left unset.
+type OutputSettings struct {
+	Umask  string `yaml:"umask,omitempty"`
+	Certs  string `yaml:"certs,omitempty"`
+	Keys   string `yaml:"keys,omitempty"`
+	Shares string `yaml:"shares,omitempty"`
+}
+
+// Perms parses the settings.
+func (o *OutputSettings) Perms() (utils.OutputPerms, error) {
+	var perms utils.OutputPerms
+	if o.Umask != "" {
+		umask, err := utils.ParseMode(o.Umask)
+		if err != nil {
+			return perms, fmt.Errorf("invalid output umask: %w", err)
+		}
+		perms.Umask = &umask
+	}
+	for _, f := range []struct {
+		name  string
+		value string
+		perms *utils.FilePerms
+	}{
+		{"certs", o.Certs, &perms.Certs},
+		{"keys", o.Keys, &perms.Keys},
+		{"shares", o.Shares, &perms.Shares},
+	} {
+		if f.value == "" {
+			continue
+		}
+		p, err := utils.ParseFilePerms(f.value)
+		if err != nil {
+			return perms, fmt.Errorf("invalid output %s: %w", f.name, err)
+		}
+		*f.perms = p
+	}
+	return perms, nil
+}
+
+// Normalization returns the name normalization rules of the profile, utils.DefaultNormalization if none are configured.
+func (s ProfileSettings) Normalization() utils.Normalization {
+	if s.Normalize == nil {
+		return utils.DefaultNormalization
+	}
+	return *s.Normalize
+}
+
+// CombinePolicy guards the reconstruction of the CA key from its shares: every combination is
+// announced to the notification targets and, with a delay, must be requested that long in advance.
+type CombinePolicy struct {
+	notify.Targets `yaml:",inline"`
+	Delay          time.Duration `yaml:"delay,omitempty"`
+	Window         time.Duration `yaml:"window,omitempty"` // how long a request may be used once the delay is over (default 24h)
+}
+
+// DefaultCombineWindow is how long a combination request may be used when no window is configured.
+const DefaultCombineWindow = 24 * time.Hour
+
+// UseWindow returns the configured window, or DefaultCombineWindow.
+func (p *CombinePolicy) UseWindow() time.Duration {
+	if p.Window > 0 {
+		return p.Window
+	}
+	return DefaultCombineWindow
+}
+
+// Config is the content of a CA configuration file.
+type Config struct {
+	AllowedProfiles []string                   `yaml:"allowed_profiles,omitempty"`
+	Distribution    *DistributionSettings      `yaml:"distribution,omitempty"`
+	Profiles        map[string]ProfileSettings `yaml:"profiles,omitempty"`
+	Combine         *CombinePolicy             `yaml:"combine,omitempty"`
+}
+
+// PathForCA returns the configuration file location for a CA certificate, e.g. "rootCA.pem" -> "rootCA.ca.yaml".
+func PathForCA(caPemPath string) string {
+	return strings.TrimSuffix(caPemPath, filepath.Ext(caPemPath)) + ".ca.yaml"
+}
+
+// Load reads the configuration at path. A missing file yields an empty, unrestricted configuration.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA configuration '%s': %w", path, err)
+	}
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid CA configuration '%s': %w", path, err)
+	}
+	return &c, nil
+}
+
+// LoadForCA reads the configuration belonging to the CA certificate at caPemPath.
+func LoadForCA(caPemPath string) (*Config, error) {
+	return Load(PathForCA(caPemPath))
+}
+
+// Save writes the configuration to path.
+func (c *Config) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode CA configuration: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write CA configuration '%s': %w", path, err)
+	}
+	return nil
+}
+
+// Allows reports whether the CA may issue certificates with the given profile, listed by name
+// or by kind. An empty allow-list permits every profile.
+func (c *Config) Allows(profile string) bool {
+	if len(c.AllowedProfiles) == 0 {
+		return true
+	}
+	kind := c.Settings(profile).Kind
+	for _, p := range c.AllowedProfiles {
+		if p == profile || p == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Known reports whether profile is built in or defined in the configuration.
+func (c *Config) Known(profile string) bool {
+	_, builtin := builtinProfiles[profile]
+	_, defined := c.Profiles[profile]
+	return builtin || defined || profile == ProfileLeaf || profile == ProfileSubCA
+}
+
+// ProfileNames lists the profiles of the given kind available to the CA, built-in ones first.
+func (c *Config) ProfileNames(kind string) []string {
+	names := []string{kind}
+	for _, name := range []string{ProfileServerTLS, ProfileClientMTLS, ProfileCodeSigning} {
+		if kind == ProfileLeaf {
+			names = append(names, name)
+		}
+	}
+	var defined []string
+	for name := range c.Profiles {
+		if !slices.Contains(names, name) && name != ProfileLeaf && name != ProfileSubCA && c.Settings(name).Kind == kind {
+			defined = append(defined, name)
+		}
+	}
+	slices.Sort(defined)
+	return append(names, defined...)
+}
+
+// ResolveDays returns the validity to use for profile. When explicit is false the profile's
+// default replaces requested, if one is configured, and the command's default is lowered to the
+// profile's cap. An explicit validity is checked against the cap.
+func (c *Config) ResolveDays(profile string, requested int, explicit bool) (int, error) {
+	settings := c.Settings(profile)
+	days := requested
+	if !explicit && settings.Days > 0 {
+		days = settings.Days
+	} else if !explicit && settings.MaxDays > 0 {
+		days = min(days, settings.MaxDays)
+	}
+	if settings.MaxDays > 0 && days > settings.MaxDays {
+		return 0, fmt.Errorf("validity of %d days exceeds the %d day maximum for profile '%s'", days, settings.MaxDays, profile)
+	}
+	return days, nil
+}
+
+// Settings returns the settings for profile: the built-in defaults of a named profile overridden by
+// the configured ones (zero values if none), with the CA's distribution settings unless the profile
+// overrides them.
+func (c *Config) Settings(profile string) ProfileSettings {
+	s := builtinProfiles[profile].merge(c.Profiles[profile])
+	if s.Kind == "" {
+		s.Kind = ProfileLeaf
+		if profile == ProfileSubCA {
+			s.Kind = ProfileSubCA
+		}
+	}
+	if s.Distribution == nil {
+		s.Distribution = c.Distribution
+	}
+	return s
+}
+
+// CheckIssuance verifies that the CA may issue profile and resolves the validity period.
+func (c *Config) CheckIssuance(profile string, requested int, explicit bool) (int, error) {
+	if !c.Known(profile) {
+		return 0, fmt.Errorf("unknown profile '%s' (available: %s)", profile, strings.Join(append(c.ProfileNames(ProfileLeaf), c.ProfileNames(ProfileSubCA)...), ", "))
+	}
+	if !c.Allows(profile) {
+		return 0, fmt.Errorf("this CA may not issue '%s' certificates (allowed: %s)", profile, strings.Join(c.AllowedProfiles, ", "))
+	}
+	return c.ResolveDays(profile, requested, explicit)
+}
+
+// ParseProfileList splits a comma-separated list of built-in profile names, rejecting unknown names.
+func ParseProfileList(s string) ([]string, error) {
+	var profiles []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !(&Config{}).Known(p) {
+			return nil, fmt.Errorf("unknown profile '%s' (expected %s, %s, %s, %s or %s)", p, ProfileLeaf, ProfileSubCA, ProfileServerTLS, ProfileClientMTLS, ProfileCodeSigning)
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
@@ -0,0 +1,127 @@
+// Package cadir lays out a managed CA home, a directory in which commands find their CAs and put
+// what they write without being told every path, much like OpenSSL's CA directory:
+//
+//	certs/      CA certificates (<name>.pem), with their configuration and logs next to them
+//	crl/        CRLs (<name>.crl, <name>-delta.crl)
+//	db/         the inventory
+//	newcerts/   issued certificates (<serial>.pem)
+//	private/    generated private keys (<serial>.key), readable by the owner only
+//
+// Key shares are not kept in it: they belong to their custodians.
+package cadir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Subdirectories of a CA home.
+const (
+	CertsDir    = "certs"
+	CRLDir      = "crl"
+	DBDir       = "db"
+	NewCertsDir = "newcerts"
+	PrivateDir  = "private"
+)
+
+// inventoryFile is the name of the inventory in DBDir, and legacyInventoryFile that of the JSON
+// inventory homes made by earlier versions have instead.
+const (
+	inventoryFile       = "inventory.db"
+	legacyInventoryFile = "inventory.json"
+)
+
+// Dir is the root of a CA home.
+type Dir string
+
+// Init creates the subdirectories that are missing; private/ is created for the owner only.
+func (d Dir) Init() error {
+	for _, sub := range []string{CertsDir, CRLDir, DBDir, NewCertsDir, PrivateDir} {
+		mode := os.FileMode(0755)
+		if sub == PrivateDir {
+			mode = 0700
+		}
+		if err := os.MkdirAll(filepath.Join(string(d), sub), mode); err != nil {
+			return fmt.Errorf("failed to create CA directory '%s': %w", filepath.Join(string(d), sub), err)
+		}
+	}
+	return nil
+}
+
+// CAPath returns the certificate file of the CA called name.
+func (d Dir) CAPath(name string) string {
+	return filepath.Join(string(d), CertsDir, name+".pem")
+}
+
+// CAs returns the certificate files in certs/.
+func (d Dir) CAs() ([]string, error) {
+	return filepath.Glob(filepath.Join(string(d), CertsDir, "*.pem"))
+}
+
+// InventoryPath returns the inventory file, the legacy JSON one if only that exists.
+func (d Dir) InventoryPath() string {
+	path := filepath.Join(string(d), DBDir, inventoryFile)
+	legacy := filepath.Join(string(d), DBDir, legacyInventoryFile)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(legacy); err == nil {
+			return legacy
+		}
+	}
+	return path
+}
+
+// NewCertPath returns the file of the issued certificate with the hex serial.
+func (d Dir) NewCertPath(serial string) string {
+	return filepath.Join(string(d), NewCertsDir, serial+".pem")
+}
+
+// NewCertsPath returns the directory of issued certificates.
+func (d Dir) NewCertsPath() string {
+	return filepath.Join(string(d), NewCertsDir)
+}
+
+// KeyPath returns the file of the private key generated for the certificate with the hex serial.
+func (d Dir) KeyPath(serial string) string {
+	return filepath.Join(string(d), PrivateDir, serial+".key")
+}
+
+// Of returns the CA home holding the CA certificate at caPemPath, if it is in the certs/ of one.
+func Of(caPemPath string) (Dir, bool) {
+	certs := filepath.Dir(caPemPath)
+	if filepath.Base(certs) != CertsDir {
+		return "", false
+	}
+	d := Dir(filepath.Dir(certs))
+	if fi, err := os.Stat(filepath.Join(string(d), CRLDir)); err != nil || !fi.IsDir() {
+		return "", false
+	}
+	return d, true
+}
+
+// CRLPath returns the file in crl/ for a CRL of the CA at caPemPath, e.g. "certs/issuing.pem" and
+// suffix ".crl" -> "crl/issuing.crl".
+func (d Dir) CRLPath(caPemPath, suffix string) string {
+	base := strings.TrimSuffix(filepath.Base(caPemPath), filepath.Ext(caPemPath))
+	return filepath.Join(string(d), CRLDir, base+suffix)
+}
+
+var unsafeChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Name turns a common name into a CA name usable as a file name, e.g. "Example Issuing CA 1" ->
+// "example-issuing-ca-1".
+func Name(commonName string) string {
+	name := strings.Trim(unsafeChars.ReplaceAllString(strings.ToLower(commonName), "-"), "-")
+	if name == "" {
+		return "ca"
+	}
+	return name
+}
+
+// IsName reports whether ref names a CA rather than a file: it has no directory and no extension.
+func IsName(ref string) bool {
+	return ref != "" && ref != "-" && !strings.ContainsAny(ref, `/\`) && filepath.Ext(ref) == ""
+}
@@ -0,0 +1,195 @@
+// Package ceremony records key ceremonies in transcripts that participants sign. A transcript is a
+// text body of "key: value" lines grouped in steps, followed by PEM signature blocks over it.
+package ceremony
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"my-pki/internal/utils"
+)
+
+const signaturePEMType = "GOSEC CEREMONY SIGNATURE"
+
+// Transcript is the record of a ceremony and the signatures of those who attest it.
+type Transcript struct {
+	Body       []byte
+	Signatures []*Signature
+}
+
+// Signature is one participant's signature over a transcript body.
+type Signature struct {
+	Signer    string
+	SignedAt  time.Time
+	PublicKey *ecdsa.PublicKey
+	Value     []byte // ASN.1 ECDSA signature
+}
+
+// Recorder builds a transcript body step by step.
+type Recorder struct {
+	b    bytes.Buffer
+	step int
+}
+
+// NewRecorder starts a transcript for the named ceremony.
+func NewRecorder(title string) *Recorder {
+	r := &Recorder{}
+	fmt.Fprintf(&r.b, "# GoSeC key ceremony transcript v1\n# %s\n", title)
+	return r
+}
+
+// Step starts the next numbered step.
+func (r *Recorder) Step(title string) {
+	r.step++
+	fmt.Fprintf(&r.b, "\n## Step %d: %s\n", r.step, title)
+}
+
+// Record adds a value to the current step; line breaks are flattened so one line holds one value.
+func (r *Recorder) Record(key, value string) {
+	value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+	fmt.Fprintf(&r.b, "%s: %s\n", key, value)
+}
+
+// Transcript returns the unsigned transcript recorded so far.
+func (r *Recorder) Transcript() *Transcript {
+	return &Transcript{Body: bytes.Clone(r.b.Bytes())}
+}
+
+// Digest returns the SHA-256 of the body, which participants can compare out of band.
+func (t *Transcript) Digest() string {
+	sum := sha256.Sum256(t.Body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign appends signer's signature made with key at time now.
+func (t *Transcript) Sign(signer string, key *ecdsa.PrivateKey, now time.Time) error {
+	if strings.ContainsAny(signer, "\r\n") {
+		return errors.New("signer name must be a single line")
+	}
+	sig := &Signature{Signer: signer, SignedAt: now.UTC().Truncate(time.Second), PublicKey: &key.PublicKey}
+	digest := sig.digest(t.Body)
+	var err error
+	if sig.Value, err = ecdsa.SignASN1(utils.Rand, key, digest[:]); err != nil {
+		return fmt.Errorf("failed to sign transcript: %w", err)
+	}
+	t.Signatures = append(t.Signatures, sig)
+	return nil
+}
+
+// Verify checks the signature over body.
+func (s *Signature) Verify(body []byte) error {
+	digest := s.digest(body)
+	if !ecdsa.VerifyASN1(s.PublicKey, digest[:], s.Value) {
+		return fmt.Errorf("invalid signature by %s", s.Signer)
+	}
+	return nil
+}
+
+// KeyFingerprint returns the SHA-256 of the signer's public key, to compare with their known key.
+func (s *Signature) KeyFingerprint() string {
+	der, err := x509.MarshalPKIXPublicKey(s.PublicKey)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// digest binds the signer's name and signing time to the body.
+func (s *Signature) digest(body []byte) [32]byte {
+	bodySum := sha256.Sum256(body)
+	return sha256.Sum256([]byte(fmt.Sprintf("GoSeC ceremony signature v1\n%x\n%s\n%s\n", bodySum, s.Signer, s.SignedAt.Format(time.RFC3339))))
+}
+
+// Encode serialises the transcript: the body, then one PEM block per signature.
+func (t *Transcript) Encode() []byte {
+	out := bytes.Clone(t.Body)
+	for _, s := range t.Signatures {
+		der, err := x509.MarshalPKIXPublicKey(s.PublicKey)
+		if err != nil {
+			continue
+		}
+		out = append(out, pem.EncodeToMemory(&pem.Block{
+			Type: signaturePEMType,
+			Headers: map[string]string{
+				"Signer":     s.Signer,
+				"Signed-At":  s.SignedAt.Format(time.RFC3339),
+				"Public-Key": base64.StdEncoding.EncodeToString(der),
+			},
+			Bytes: s.Value,
+		})...)
+	}
+	return out
+}
+
+// Parse decodes a transcript written by Encode.
+func Parse(data []byte) (*Transcript, error) {
+	start := bytes.Index(data, []byte("-----BEGIN "+signaturePEMType+"-----"))
+	if start < 0 {
+		start = len(data)
+	}
+	t := &Transcript{Body: bytes.Clone(data[:start])}
+	if !bytes.HasPrefix(t.Body, []byte("# GoSeC key ceremony transcript v1\n")) {
+		return nil, errors.New("not a GoSeC ceremony transcript")
+	}
+	rest := data[start:]
+	for len(bytes.TrimSpace(rest)) > 0 {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil || block.Type != signaturePEMType {
+			return nil, errors.New("malformed signature block")
+		}
+		s := &Signature{Signer: block.Headers["Signer"], Value: block.Bytes}
+		var err error
+		if s.SignedAt, err = time.Parse(time.RFC3339, block.Headers["Signed-At"]); err != nil {
+			return nil, fmt.Errorf("invalid Signed-At of signature by %s", s.Signer)
+		}
+		der, err := base64.StdEncoding.DecodeString(block.Headers["Public-Key"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid Public-Key of signature by %s", s.Signer)
+		}
+		pub, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Public-Key of signature by %s: %w", s.Signer, err)
+		}
+		var ok bool
+		if s.PublicKey, ok = pub.(*ecdsa.PublicKey); !ok {
+			return nil, fmt.Errorf("signature by %s does not use an ECDSA key", s.Signer)
+		}
+		t.Signatures = append(t.Signatures, s)
+	}
+	return t, nil
+}
+
+// ReadFile reads a transcript from path.
+func ReadFile(path string) (*Transcript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcript '%s': %w", path, err)
+	}
+	t, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transcript '%s': %w", path, err)
+	}
+	return t, nil
+}
+
+// Value returns the first value recorded under key, e.g. "Certificate SHA-256".
+func (t *Transcript) Value(key string) string {
+	for _, line := range strings.Split(string(t.Body), "\n") {
+		if v, ok := strings.CutPrefix(line, key+": "); ok {
+			return v
+		}
+	}
+	return ""
+}
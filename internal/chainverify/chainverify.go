@@ -0,0 +1,391 @@
+// Package chainverify builds a certificate chain from a leaf to a trusted root and checks every
+// constraint along it, reporting each violation individually instead of stopping at the first one.
+//
+// The checks are stricter than crypto/x509 where its defaults are lenient: a CA's extended key
+// usages constrain every certificate below it even when the leaf has no EKU extension, name
+// constraints also apply to a hostname-like subject CN, and a CA without the keyCertSign usage
+// cannot issue. Revocation is checked separately, against CRLs the caller supplies.
+package chainverify
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"my-pki/internal/inventory"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Constraint names used in violations.
+const (
+	ConstraintSignature        = "signature"
+	ConstraintValidity         = "validity"
+	ConstraintBasicConstraints = "basic-constraints"
+	ConstraintKeyUsage         = "key-usage"
+	ConstraintPathLength       = "path-length"
+	ConstraintNameConstraints  = "name-constraints"
+	ConstraintEKUNesting       = "eku-nesting"
+	ConstraintRevocation       = "revocation"
+)
+
+// Violation is one constraint a certificate in the chain does not satisfy.
+type Violation struct {
+	Depth      int // 0 is the leaf
+	Cert       *x509.Certificate
+	Constraint string
+	Detail     string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("depth %d (%s): %s: %s", v.Depth, v.Cert.Subject, v.Constraint, v.Detail)
+}
+
+// maxDepth bounds chain building against issuer loops.
+const maxDepth = 16
+
+// Build returns the chain from leaf to one of roots, using intermediates as needed.
+// Issuers are matched by name and signature only, so a chain that breaks other constraints
+// is still returned for Check to report on.
+func Build(leaf *x509.Certificate, intermediates, roots []*x509.Certificate) ([]*x509.Certificate, error) {
+	chain := []*x509.Certificate{leaf}
+	for cert := leaf; ; {
+		if isRoot(cert, roots) {
+			return chain, nil
+		}
+		if len(chain) > maxDepth {
+			return nil, fmt.Errorf("no chain to a trusted root within %d certificates", maxDepth)
+		}
+		issuer := findIssuer(cert, roots)
+		if issuer == nil {
+			issuer = findIssuer(cert, intermediates)
+		}
+		if issuer == nil {
+			return nil, fmt.Errorf("no issuer found for '%s' (issuer '%s')", cert.Subject, cert.Issuer)
+		}
+		chain = append(chain, issuer)
+		cert = issuer
+	}
+}
+
+func isRoot(cert *x509.Certificate, roots []*x509.Certificate) bool {
+	for _, root := range roots {
+		if cert.Equal(root) {
+			return true
+		}
+	}
+	return false
+}
+
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, c := range candidates {
+		if c.Equal(cert) || !bytes.Equal(c.RawSubject, cert.RawIssuer) {
+			continue
+		}
+		if c.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// Check verifies chain (leaf first, trust anchor last) at time at and returns every violation found.
+func Check(chain []*x509.Certificate, at time.Time) []Violation {
+	if len(chain) == 0 {
+		return nil
+	}
+	var vs []Violation
+	add := func(depth int, constraint, format string, a ...any) {
+		vs = append(vs, Violation{Depth: depth, Cert: chain[depth], Constraint: constraint, Detail: fmt.Sprintf(format, a...)})
+	}
+
+	for i, cert := range chain {
+		if at.Before(cert.NotBefore) {
+			add(i, ConstraintValidity, "not valid before %s", cert.NotBefore.UTC().Format(time.RFC3339))
+		}
+		if at.After(cert.NotAfter) {
+			add(i, ConstraintValidity, "expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+		}
+		if i+1 < len(chain) {
+			if err := chain[i+1].CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+				add(i, ConstraintSignature, "not signed by '%s': %v", chain[i+1].Subject, err)
+			}
+		}
+		if i == 0 {
+			continue
+		}
+
+		// Every certificate above the leaf acts as an issuer
+		if !cert.BasicConstraintsValid || !cert.IsCA {
+			add(i, ConstraintBasicConstraints, "issues '%s' but is not a CA (basicConstraints cA is not set)", chain[i-1].Subject)
+		}
+		if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+			add(i, ConstraintKeyUsage, "issues '%s' but lacks the keyCertSign key usage", chain[i-1].Subject)
+		}
+		// Intermediate CAs below this one, not counting the leaf
+		if below := i - 1; cert.BasicConstraintsValid && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) && below > cert.MaxPathLen {
+			add(i, ConstraintPathLength, "pathLenConstraint %d allows at most %d intermediate CA(s) below it, but the chain has %d", cert.MaxPathLen, cert.MaxPathLen, below)
+		}
+		for j := i - 1; j >= 0; j-- {
+			for _, d := range nameViolations(cert, chain[j], j == 0) {
+				add(j, ConstraintNameConstraints, "%s (constraint of '%s', depth %d)", d, cert.Subject, i)
+			}
+			if d := ekuViolation(cert, chain[j]); d != "" {
+				add(j, ConstraintEKUNesting, "%s (constraint of '%s', depth %d)", d, cert.Subject, i)
+			}
+		}
+	}
+	return vs
+}
+
+// CheckRevocation checks every certificate of chain (leaf first, trust anchor last) below the anchor
+// against the CRLs among crls that its issuer signed, at time at. It returns the violations and the
+// depths of the certificates no CRL covers.
+func CheckRevocation(chain []*x509.Certificate, crls []*x509.RevocationList, at time.Time) (vs []Violation, uncovered []int) {
+	add := func(depth int, format string, a ...any) {
+		vs = append(vs, Violation{Depth: depth, Cert: chain[depth], Constraint: ConstraintRevocation, Detail: fmt.Sprintf(format, a...)})
+	}
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		covered := false
+		for _, rl := range crls {
+			if !bytes.Equal(rl.RawIssuer, issuer.RawSubject) {
+				continue
+			}
+			if err := rl.CheckSignatureFrom(issuer); err != nil {
+				add(i, "CRL #%s names '%s' as issuer but is not signed by it: %v", rl.Number, issuer.Subject, err)
+				continue
+			}
+			covered = true
+			if !rl.NextUpdate.IsZero() && at.After(rl.NextUpdate) {
+				add(i, "CRL #%s of '%s' is stale: its next update was due %s", rl.Number, issuer.Subject, rl.NextUpdate.UTC().Format(time.RFC3339))
+			}
+			for _, entry := range rl.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) != 0 || entry.ReasonCode == inventory.ReasonRemoveFromCRL || entry.RevocationTime.After(at) {
+					continue
+				}
+				add(i, "revoked at %s (%s), listed in CRL #%s of '%s'", entry.RevocationTime.UTC().Format(time.RFC3339),
+					inventory.ReasonName(entry.ReasonCode), rl.Number, issuer.Subject)
+			}
+		}
+		if !covered {
+			uncovered = append(uncovered, i)
+		}
+	}
+	return vs, uncovered
+}
+
+// Err summarizes violations as a single error, or returns nil if there are none.
+func Err(vs []Violation) error {
+	if len(vs) == 0 {
+		return nil
+	}
+	lines := make([]string, len(vs))
+	for i, v := range vs {
+		lines[i] = v.String()
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+// ekuViolation reports the extended key usages of cert that ca does not allow.
+func ekuViolation(ca, cert *x509.Certificate) string {
+	if len(ca.ExtKeyUsage) == 0 && len(ca.UnknownExtKeyUsage) == 0 {
+		return ""
+	}
+	if hasEKU(ca.ExtKeyUsage, x509.ExtKeyUsageAny) {
+		return ""
+	}
+	allowed := ekuNames(ca.ExtKeyUsage)
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return fmt.Sprintf("has no extended key usage (any purpose) but the CA only allows %s", strings.Join(allowed, ", "))
+	}
+	var bad []string
+	for _, u := range cert.ExtKeyUsage {
+		if !hasEKU(ca.ExtKeyUsage, u) {
+			bad = append(bad, ekuName(u))
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		found := false
+		for _, caOID := range ca.UnknownExtKeyUsage {
+			found = found || oid.Equal(caOID)
+		}
+		if !found {
+			bad = append(bad, oid.String())
+		}
+	}
+	if len(bad) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("extended key usage %s is not allowed by the CA (allowed: %s)", strings.Join(bad, ", "), strings.Join(allowed, ", "))
+}
+
+func hasEKU(usages []x509.ExtKeyUsage, u x509.ExtKeyUsage) bool {
+	for _, have := range usages {
+		if have == u {
+			return true
+		}
+	}
+	return false
+}
+
+var ekuNameMap = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+func ekuName(u x509.ExtKeyUsage) string {
+	if name, ok := ekuNameMap[u]; ok {
+		return name
+	}
+	return fmt.Sprintf("eku(%d)", u)
+}
+
+func ekuNames(usages []x509.ExtKeyUsage) []string {
+	names := make([]string, len(usages))
+	for i, u := range usages {
+		names[i] = ekuName(u)
+	}
+	return names
+}
+
+// nameViolations checks the names of cert against the name constraints of ca.
+// For the leaf, a hostname-like subject CN is checked as a DNS name as well.
+func nameViolations(ca, cert *x509.Certificate, leaf bool) []string {
+	var out []string
+	hasDNS := len(ca.PermittedDNSDomains) > 0 || len(ca.ExcludedDNSDomains) > 0
+	dnsNames := cert.DNSNames
+	if leaf && hasDNS && looksLikeHostname(cert.Subject.CommonName) && !contains(dnsNames, cert.Subject.CommonName) {
+		dnsNames = append(append([]string{}, dnsNames...), cert.Subject.CommonName)
+	}
+	for _, name := range dnsNames {
+		out = append(out, checkName("DNS name", name, ca.PermittedDNSDomains, ca.ExcludedDNSDomains, matchDomain)...)
+	}
+	for _, email := range cert.EmailAddresses {
+		out = append(out, checkName("e-mail address", email, ca.PermittedEmailAddresses, ca.ExcludedEmailAddresses, matchEmail)...)
+	}
+	for _, u := range cert.URIs {
+		out = append(out, checkName("URI", u.String(), ca.PermittedURIDomains, ca.ExcludedURIDomains, matchURI)...)
+	}
+	for _, ip := range cert.IPAddresses {
+		if permitted := ca.PermittedIPRanges; len(permitted) > 0 && !inRanges(ip, permitted) {
+			out = append(out, fmt.Sprintf("IP address %s is not within the permitted ranges %s", ip, rangesString(permitted)))
+		}
+		for _, r := range ca.ExcludedIPRanges {
+			if r.Contains(ip) {
+				out = append(out, fmt.Sprintf("IP address %s is within the excluded range %s", ip, r))
+			}
+		}
+	}
+	return out
+}
+
+func checkName(kind, name string, permitted, excluded []string, match func(name, constraint string) bool) []string {
+	var out []string
+	if len(permitted) > 0 {
+		ok := false
+		for _, c := range permitted {
+			ok = ok || match(name, c)
+		}
+		if !ok {
+			out = append(out, fmt.Sprintf("%s '%s' is not within the permitted subtrees [%s]", kind, name, strings.Join(permitted, ", ")))
+		}
+	}
+	for _, c := range excluded {
+		if match(name, c) {
+			out = append(out, fmt.Sprintf("%s '%s' is within the excluded subtree '%s'", kind, name, c))
+		}
+	}
+	return out
+}
+
+// matchDomain applies RFC 5280 dNSName matching: "example.com" covers the domain and its
+// subdomains, ".example.com" only its subdomains.
+func matchDomain(name, constraint string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	constraint = strings.ToLower(constraint)
+	if constraint == "" {
+		return true
+	}
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(name, constraint)
+	}
+	return name == constraint || strings.HasSuffix(name, "."+constraint)
+}
+
+// matchEmail applies RFC 5280 rfc822Name matching: a full mailbox, a host, or ".domain" for subdomains.
+func matchEmail(email, constraint string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+	host := strings.ToLower(email[at+1:])
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+	return host == constraint
+}
+
+// matchURI applies RFC 5280 uniformResourceIdentifier matching against the URI host.
+func matchURI(raw, constraint string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+	return host == constraint
+}
+
+func inRanges(ip net.IP, ranges []*net.IPNet) bool {
+	for _, r := range ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func rangesString(ranges []*net.IPNet) string {
+	s := make([]string, len(ranges))
+	for i, r := range ranges {
+		s[i] = r.String()
+	}
+	return "[" + strings.Join(s, ", ") + "]"
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeHostname reports whether cn is a dotted DNS name rather than a descriptive name.
+func looksLikeHostname(cn string) bool {
+	if !strings.Contains(cn, ".") || net.ParseIP(cn) != nil {
+		return false
+	}
+	for _, r := range cn {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '*') {
+			return false
+		}
+	}
+	return true
+}
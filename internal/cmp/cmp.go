@@ -0,0 +1,477 @@
+// Package cmp serves a CA over the Certificate Management Protocol (RFC 4210) over HTTP (RFC 6712),
+// for enterprise clients and registration authorities that speak only CMP. It answers initialization
+// requests (ir) and key update requests (kur), and the certificate confirmations that end them.
+//
+// Requests are protected with a password-based MAC keyed by a shared secret, which the sender key
+// identifier names, or signed. An ir may be signed with a certificate from an enrollment CA, such as
+// that of a registration authority, which may then vouch for the proof of possession (raVerified).
+// A kur is signed with the certificate being updated. Responses are protected with the request's
+// secret, or else signed with the CA key. Issuing is left to the same Backend as the JSON API.
+package cmp
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"my-pki/internal/api"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// maxBody bounds request messages; a few certificates fit many times over.
+const maxBody = 256 << 10
+
+// contentType is the media type of CMP messages over HTTP (RFC 6712 §3.4).
+const contentType = "application/pkixcmp"
+
+// Server answers CMP requests with a Backend.
+type Server struct {
+	backend   api.Backend
+	signer    crypto.Signer
+	access    *api.Config
+	limiter   *api.Limiter
+	enrollCAs *x509.CertPool
+	secrets   map[string]string
+	mux       *http.ServeMux
+}
+
+// routes lists the endpoints, as shown by Routes. The label of the second selects the profile.
+var routes = []string{
+	"POST /.well-known/cmp",
+	"POST /.well-known/cmp/p/{label}",
+}
+
+// NewServer returns a server answering with backend, signing responses with the CA key signer.
+// Requests signed with a certificate from enrollCAs, or protected with one of secrets, keyed by
+// sender key identifier, may enroll in the profiles access grants them (any without access), at
+// the rate limiter allows.
+func NewServer(backend api.Backend, signer crypto.Signer, access *api.Config, limiter *api.Limiter, enrollCAs *x509.CertPool, secrets map[string]string) *Server {
+	s := &Server{backend: backend, signer: signer, access: access, limiter: limiter, enrollCAs: enrollCAs, secrets: secrets, mux: http.NewServeMux()}
+	for _, route := range routes {
+		s.mux.HandleFunc(route, s.serve)
+	}
+	return s
+}
+
+// Routes returns the method and path of every endpoint.
+func (s *Server) Routes() []string {
+	return routes
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != contentType {
+		http.Error(w, "expected a CMP message of type "+contentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	var msg pkiMessage
+	var hdr pkiHeader
+	if rest, err := asn1.Unmarshal(data, &msg); err != nil || len(rest) > 0 {
+		http.Error(w, "malformed CMP message", http.StatusBadRequest)
+		return
+	}
+	if _, err := asn1.Unmarshal(msg.Header.FullBytes, &hdr); err != nil {
+		http.Error(w, fmt.Sprintf("malformed CMP message header: %v", err), http.StatusBadRequest)
+		return
+	}
+	resp, err := s.respond(&msg, &hdr, r.PathValue("label"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(resp)
+}
+
+// auth is how a request was authenticated.
+type auth struct {
+	client string
+	pbm    *pbmParameter // the request's, when protected with a shared secret
+	secret []byte
+	signer *x509.Certificate // the protection certificate, when signed
+	enroll bool              // signer was issued by an enrollment CA
+	issued bool              // signer was issued by this CA
+}
+
+// failure is a request refused with a PKIFailureInfo bit.
+type failure struct {
+	bit int
+	err error
+}
+
+func (f *failure) Error() string { return f.err.Error() }
+
+func (f *failure) Unwrap() error { return f.err }
+
+func fail(bit int, err error) error {
+	return &failure{bit: bit, err: err}
+}
+
+// failBit returns the PKIFailureInfo bit of err.
+func failBit(err error) int {
+	var f *failure
+	if errors.As(err, &f) {
+		return f.bit
+	}
+	var e *api.Error
+	if errors.As(err, &e) {
+		switch e.Status {
+		case http.StatusBadRequest:
+			return failBadCertTemplate
+		case http.StatusForbidden:
+			return failNotAuthorized
+		case http.StatusTooManyRequests:
+			return failSystemUnavail
+		}
+	}
+	return failSystemFailure
+}
+
+// respond answers msg, with an error message if it cannot be processed.
+func (s *Server) respond(msg *pkiMessage, hdr *pkiHeader, profile string) ([]byte, error) {
+	a, err := s.authenticate(msg, hdr)
+	if err == nil && hdr.PVNO != 2 && hdr.PVNO != 3 {
+		err = fail(failUnsupportedVersion, fmt.Errorf("unsupported CMP version %d", hdr.PVNO))
+	}
+	var body asn1.RawValue
+	confirmed := false
+	if err == nil {
+		switch tag := msg.Body.Tag; {
+		case msg.Body.Class != asn1.ClassContextSpecific:
+			err = fail(failBadDataFormat, errors.New("malformed message body"))
+		case tag == bodyIR || tag == bodyKUR:
+			body, err = s.certify(msg, a, profile)
+			confirmed = slices.ContainsFunc(hdr.GeneralInfo, func(i infoTypeAndValue) bool { return i.InfoType.Equal(oidImplicitConfirm) })
+		case tag == bodyCertConf:
+			body = tagged(bodyPKIConf, asn1.NullBytes)
+		default:
+			err = fail(failBadRequest, fmt.Errorf("%s messages are not supported; only ir, kur and certConf are", bodyNames[tag]))
+		}
+	}
+	if err != nil {
+		der, merr := asn1.Marshal(errorMsgContent{Status: statusInfo(statusRejection, failBit(err), err.Error())})
+		if merr != nil {
+			return nil, merr
+		}
+		body = tagged(bodyError, der)
+		if failBit(err) == failBadMessageCheck || failBit(err) == failSignerNotTrusted || failBit(err) == failBadAlg {
+			a = nil // sign rather than answer with a secret that was not proven
+		}
+	}
+	return s.message(hdr, a, body, confirmed)
+}
+
+// authenticate checks the protection of msg.
+func (s *Server) authenticate(msg *pkiMessage, hdr *pkiHeader) (*auth, error) {
+	if msg.Protection.BitLength == 0 {
+		return nil, fail(failNotAuthorized, errors.New("unprotected messages are not accepted"))
+	}
+	part, err := asn1.Marshal(protectedPart{Header: msg.Header, Body: msg.Body})
+	if err != nil {
+		return nil, err
+	}
+	if hdr.ProtectionAlg.Algorithm.Equal(oidPasswordBasedMAC) {
+		var params pbmParameter
+		if _, err := asn1.Unmarshal(hdr.ProtectionAlg.Parameters.FullBytes, &params); err != nil {
+			return nil, fail(failBadAlg, fmt.Errorf("malformed PBM parameters: %w", err))
+		}
+		kid := string(hdr.SenderKID)
+		secret, known := s.secrets[kid]
+		mac, err := pbm([]byte(secret), &params, part)
+		if err != nil {
+			return nil, fail(failBadAlg, err)
+		}
+		if !known || !hmac.Equal(mac, msg.Protection.RightAlign()) {
+			return nil, fail(failBadMessageCheck, errors.New("the message is not protected with a known shared secret"))
+		}
+		return &auth{client: "cmp:" + kid, pbm: &params, secret: []byte(secret)}, nil
+	}
+
+	if len(msg.ExtraCerts) == 0 {
+		return nil, fail(failSignerNotTrusted, errors.New("a signed message must carry its signer's certificate first in extraCerts"))
+	}
+	var certs []*x509.Certificate
+	for _, raw := range msg.ExtraCerts {
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, fail(failBadDataFormat, fmt.Errorf("malformed certificate in extraCerts: %w", err))
+		}
+		certs = append(certs, cert)
+	}
+	signer := certs[0]
+	if err := verifySignature(signer.PublicKey, hdr.ProtectionAlg, part, msg.Protection.RightAlign()); err != nil {
+		return nil, fail(failBadMessageCheck, fmt.Errorf("protection: %w", err))
+	}
+	a := &auth{client: signer.Subject.String(), signer: signer}
+	now := time.Now()
+	a.issued = signer.CheckSignatureFrom(s.backend.Chain()[0]) == nil && !now.Before(signer.NotBefore) && !now.After(signer.NotAfter)
+	if s.enrollCAs != nil {
+		intermediates := x509.NewCertPool()
+		for _, c := range certs[1:] {
+			intermediates.AddCert(c)
+		}
+		_, err := signer.Verify(x509.VerifyOptions{Roots: s.enrollCAs, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+		a.enroll = err == nil
+	}
+	if !a.issued && !a.enroll {
+		return nil, fail(failSignerNotTrusted, fmt.Errorf("the signer %s was issued neither by this CA nor by an enrollment CA", signer.Subject))
+	}
+	return a, nil
+}
+
+// certify answers an ir or a kur with an ip or a kup holding a response per certificate request.
+func (s *Server) certify(msg *pkiMessage, a *auth, profile string) (asn1.RawValue, error) {
+	kur := msg.Body.Tag == bodyKUR
+	if kur && !a.issued {
+		return asn1.RawValue{}, fail(failNotAuthorized, errors.New("a kur must be signed with the certificate being updated"))
+	}
+	if !kur && a.pbm == nil && !a.enroll {
+		return asn1.RawValue{}, fail(failNotAuthorized, errors.New("an ir must be protected with a shared secret or signed with a certificate from an enrollment CA; use kur to update a certificate"))
+	}
+	// A certificate updating itself needs no role, and is limited by the default rate
+	p, err := s.access.Principal(a.client)
+	if err != nil {
+		p = &api.Principal{Name: a.client}
+	}
+	if err := s.limiter.Allow(p); err != nil {
+		return asn1.RawValue{}, err
+	}
+	var reqs []asn1.RawValue
+	if _, err := asn1.Unmarshal(msg.Body.Bytes, &reqs); err != nil || len(reqs) == 0 {
+		return asn1.RawValue{}, fail(failBadDataFormat, errors.New("malformed certificate request messages"))
+	}
+	var responses []certResponse
+	for _, req := range reqs {
+		responses = append(responses, s.certifyOne(req, a, profile, kur))
+	}
+	der, err := asn1.Marshal(struct{ Response []certResponse }{responses})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	if kur {
+		return tagged(bodyKUP, der), nil
+	}
+	return tagged(bodyIP, der), nil
+}
+
+// certifyOne answers a CertReqMsg with the certificate or the reason it is refused.
+func (s *Server) certifyOne(reqMsg asn1.RawValue, a *auth, profile string, kur bool) certResponse {
+	var cr certRequest
+	fields, err := elements(reqMsg.Bytes)
+	if err == nil && len(fields) == 0 {
+		err = errors.New("empty certificate request message")
+	}
+	if err == nil {
+		_, err = asn1.Unmarshal(fields[0].FullBytes, &cr)
+	}
+	if err != nil {
+		return certResponse{Status: statusInfo(statusRejection, failBadDataFormat, fmt.Sprintf("malformed certificate request: %v", err))}
+	}
+	var popo *asn1.RawValue
+	if len(fields) > 1 && fields[1].Class == asn1.ClassContextSpecific {
+		popo = &fields[1]
+	}
+	der, err := s.issue(&cr, fields[0].FullBytes, popo, a, profile, kur)
+	if err != nil {
+		return certResponse{CertReqID: cr.CertReqID, Status: statusInfo(statusRejection, failBit(err), err.Error())}
+	}
+	// CertifiedKeyPair with certOrEncCert [0] certificate
+	pair, err := asn1.Marshal(struct{ Cert asn1.RawValue }{tagged(0, der)})
+	if err != nil {
+		return certResponse{CertReqID: cr.CertReqID, Status: statusInfo(statusRejection, failSystemFailure, err.Error())}
+	}
+	return certResponse{CertReqID: cr.CertReqID, Status: statusInfo(statusAccepted, 0, ""), CertifiedKeyPair: asn1.RawValue{FullBytes: pair}}
+}
+
+// issue checks a certificate request and its proof of possession and returns the DER certificate.
+func (s *Server) issue(cr *certRequest, certReqDER []byte, popo *asn1.RawValue, a *auth, profile string, kur bool) ([]byte, error) {
+	t, err := parseTemplate(cr.Template)
+	if err != nil {
+		return nil, fail(failBadCertTemplate, err)
+	}
+	if t.PublicKey == nil {
+		return nil, fail(failBadCertTemplate, errors.New("the certificate template has no public key"))
+	}
+	if err := checkPOP(popo, t.PublicKey, certReqDER, a); err != nil {
+		return nil, err
+	}
+
+	req := &x509.CertificateRequest{PublicKey: t.PublicKey}
+	if t.Subject != nil {
+		req.Subject = *t.Subject
+	}
+	if t.SANs != nil {
+		req.DNSNames, req.IPAddresses, req.EmailAddresses, req.URIs = t.SANs.DNSNames, t.SANs.IPAddresses, t.SANs.EmailAddresses, t.SANs.URIs
+	}
+	if kur {
+		// The updated certificate keeps the names of the current one
+		old := a.signer
+		sum := sha256.Sum256(old.Raw)
+		rec, err := s.backend.Certificate(hex.EncodeToString(sum[:]))
+		if err != nil {
+			return nil, fail(failNotAuthorized, fmt.Errorf("the certificate being updated cannot be: %w", err))
+		}
+		if rec.Status == inventory.StatusRevoked {
+			return nil, fail(failCertRevoked, errors.New("the certificate being updated is revoked"))
+		}
+		if t.Subject != nil && t.Subject.String() != old.Subject.String() {
+			return nil, fail(failBadCertTemplate, errors.New("a key update must keep the subject of the certificate being updated"))
+		}
+		oldSANs := utils.SANs{DNSNames: old.DNSNames, IPAddresses: old.IPAddresses, EmailAddresses: old.EmailAddresses, URIs: old.URIs}
+		if t.SANs != nil && !sameStrings(utils.SANsFromCSR(req).Strings(), oldSANs.Strings()) {
+			return nil, fail(failBadCertTemplate, errors.New("a key update must keep the SANs of the certificate being updated"))
+		}
+		req.Subject = old.Subject
+		req.DNSNames, req.IPAddresses, req.EmailAddresses, req.URIs = old.DNSNames, old.IPAddresses, old.EmailAddresses, old.URIs
+		if _, err := s.access.Renewer(a.client, rec.Profile, profile); err != nil {
+			return nil, err
+		}
+		if profile == "" {
+			profile = rec.Profile
+		}
+	} else if t.Subject == nil && t.SANs == nil {
+		return nil, fail(failBadCertTemplate, errors.New("the certificate template has neither a subject nor SANs"))
+	} else {
+		p, err := s.access.Principal(a.client)
+		if err == nil {
+			err = p.Authorize(api.PermIssue, profile)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	issued, err := s.backend.Issue(a.client, &api.IssueRequest{Profile: profile, Days: t.Days, Template: req})
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(issued.Certificate))
+	if block == nil {
+		return nil, errors.New("issued certificate is not PEM")
+	}
+	return block.Bytes, nil
+}
+
+// checkPOP verifies the proof of possession of the private key of pub (RFC 4211 §4).
+func checkPOP(popo *asn1.RawValue, pub any, certReqDER []byte, a *auth) error {
+	switch {
+	case popo == nil:
+		return fail(failBadPOP, errors.New("the proof of possession is missing"))
+	case popo.Tag == 0:
+		if !a.enroll {
+			return fail(failBadPOP, errors.New("only a registration authority signing with a certificate from an enrollment CA may vouch for the proof of possession"))
+		}
+		return nil
+	case popo.Tag != 1:
+		return fail(failBadPOP, errors.New("only signature proofs of possession are supported"))
+	}
+	fields, err := elements(popo.Bytes)
+	if err != nil || len(fields) != 2 {
+		if err == nil && len(fields) == 3 {
+			return fail(failBadPOP, errors.New("proofs of possession over POPOSigningKeyInput are not supported"))
+		}
+		return fail(failBadPOP, errors.New("malformed proof of possession"))
+	}
+	var alg pkix.AlgorithmIdentifier
+	var sig asn1.BitString
+	if _, err := asn1.Unmarshal(fields[0].FullBytes, &alg); err != nil {
+		return fail(failBadPOP, fmt.Errorf("malformed proof of possession: %w", err))
+	}
+	if _, err := asn1.Unmarshal(fields[1].FullBytes, &sig); err != nil {
+		return fail(failBadPOP, fmt.Errorf("malformed proof of possession: %w", err))
+	}
+	if err := verifySignature(pub, alg, certReqDER, sig.RightAlign()); err != nil {
+		return fail(failBadPOP, fmt.Errorf("proof of possession: %w", err))
+	}
+	return nil
+}
+
+// message builds the protected response to the request with header req.
+func (s *Server) message(req *pkiHeader, a *auth, body asn1.RawValue, confirmed bool) ([]byte, error) {
+	chain := s.backend.Chain()
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(utils.Rand, nonce); err != nil {
+		return nil, err
+	}
+	h := pkiHeader{
+		PVNO:          2,
+		Sender:        tagged(4, chain[0].RawSubject),
+		Recipient:     req.Sender,
+		MessageTime:   time.Now().UTC().Truncate(time.Second),
+		TransactionID: req.TransactionID,
+		SenderNonce:   nonce,
+		RecipNonce:    req.SenderNonce,
+	}
+	if confirmed {
+		h.GeneralInfo = []infoTypeAndValue{{InfoType: oidImplicitConfirm, InfoValue: asn1.RawValue{Tag: asn1.TagNull}}}
+	}
+	var params *pbmParameter
+	if a != nil && a.pbm != nil {
+		var err error
+		if params, err = newPBM(a.pbm); err != nil {
+			return nil, err
+		}
+		der, err := asn1.Marshal(*params)
+		if err != nil {
+			return nil, err
+		}
+		h.ProtectionAlg = pkix.AlgorithmIdentifier{Algorithm: oidPasswordBasedMAC, Parameters: asn1.RawValue{FullBytes: der}}
+		h.SenderKID = req.SenderKID
+	} else {
+		alg, err := signatureAlgorithmOf(s.signer)
+		if err != nil {
+			return nil, err
+		}
+		h.ProtectionAlg, h.SenderKID = alg, chain[0].SubjectKeyId
+	}
+	hdrDER, err := asn1.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	header := asn1.RawValue{FullBytes: hdrDER}
+	part, err := asn1.Marshal(protectedPart{Header: header, Body: body})
+	if err != nil {
+		return nil, err
+	}
+	var protection []byte
+	if params != nil {
+		protection, err = pbm(a.secret, params, part)
+	} else {
+		protection, err = sign(s.signer, part)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := pkiMessage{Header: header, Body: body, Protection: asn1.BitString{Bytes: protection, BitLength: 8 * len(protection)}}
+	for _, c := range chain {
+		out.ExtraCerts = append(out.ExtraCerts, asn1.RawValue{FullBytes: c.Raw})
+	}
+	return asn1.Marshal(out)
+}
+
+// sameStrings reports whether a and b hold the same strings in any order.
+func sameStrings(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
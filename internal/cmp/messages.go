@@ -0,0 +1,254 @@
+package cmp
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Body types of PKIBody (RFC 4210 §5.1.2) the responder answers or sends.
+const (
+	bodyIR       = 0
+	bodyIP       = 1
+	bodyKUR      = 7
+	bodyKUP      = 8
+	bodyPKIConf  = 19
+	bodyError    = 23
+	bodyCertConf = 24
+)
+
+// bodyNames names the body types in messages.
+var bodyNames = map[int]string{
+	0: "ir", 1: "ip", 2: "cr", 3: "cp", 4: "p10cr", 5: "popdecc", 6: "popdecr", 7: "kur", 8: "kup", 9: "krr", 10: "krp",
+	11: "rr", 12: "rp", 13: "ccr", 14: "ccp", 15: "ckuann", 16: "cann", 17: "rann", 18: "crlann", 19: "pkiconf",
+	20: "nested", 21: "genm", 22: "genp", 23: "error", 24: "certConf", 25: "pollReq", 26: "pollRep",
+}
+
+// PKIStatus values (RFC 4210 §5.2.3).
+const (
+	statusAccepted  = 0
+	statusRejection = 2
+)
+
+// PKIFailureInfo bits (RFC 4210 §5.2.3).
+const (
+	failBadAlg             = 0
+	failBadMessageCheck    = 1
+	failBadRequest         = 2
+	failBadDataFormat      = 5
+	failBadPOP             = 9
+	failCertRevoked        = 10
+	failBadCertTemplate    = 19
+	failSignerNotTrusted   = 20
+	failUnsupportedVersion = 22
+	failNotAuthorized      = 23
+	failSystemUnavail      = 24
+	failSystemFailure      = 25
+)
+
+var (
+	oidImplicitConfirm = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 4, 13}
+	oidSubjectAltName  = asn1.ObjectIdentifier{2, 5, 29, 17}
+)
+
+type pkiMessage struct {
+	Header     asn1.RawValue
+	Body       asn1.RawValue
+	Protection asn1.BitString  `asn1:"explicit,optional,tag:0"`
+	ExtraCerts []asn1.RawValue `asn1:"explicit,optional,tag:1"`
+}
+
+type pkiHeader struct {
+	PVNO          int
+	Sender        asn1.RawValue
+	Recipient     asn1.RawValue
+	MessageTime   time.Time                `asn1:"generalized,explicit,optional,tag:0"`
+	ProtectionAlg pkix.AlgorithmIdentifier `asn1:"explicit,optional,tag:1"`
+	SenderKID     []byte                   `asn1:"explicit,optional,tag:2"`
+	RecipKID      []byte                   `asn1:"explicit,optional,tag:3"`
+	TransactionID []byte                   `asn1:"explicit,optional,tag:4"`
+	SenderNonce   []byte                   `asn1:"explicit,optional,tag:5"`
+	RecipNonce    []byte                   `asn1:"explicit,optional,tag:6"`
+	FreeText      []asn1.RawValue          `asn1:"explicit,optional,tag:7"`
+	GeneralInfo   []infoTypeAndValue       `asn1:"explicit,optional,tag:8"`
+}
+
+type infoTypeAndValue struct {
+	InfoType  asn1.ObjectIdentifier
+	InfoValue asn1.RawValue `asn1:"optional"`
+}
+
+// protectedPart is what the protection of a message covers.
+type protectedPart struct {
+	Header asn1.RawValue
+	Body   asn1.RawValue
+}
+
+type certRequest struct {
+	CertReqID int
+	Template  asn1.RawValue
+	Controls  asn1.RawValue `asn1:"optional"`
+}
+
+type certResponse struct {
+	CertReqID        int
+	Status           pkiStatusInfo
+	CertifiedKeyPair asn1.RawValue `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+type errorMsgContent struct {
+	Status pkiStatusInfo
+}
+
+// statusInfo returns a status with text and, for a rejection, the failure bit.
+func statusInfo(status int, failBit int, text string) pkiStatusInfo {
+	info := pkiStatusInfo{Status: status}
+	if text != "" {
+		info.StatusString = []asn1.RawValue{{Tag: asn1.TagUTF8String, Bytes: []byte(text)}}
+	}
+	if status == statusRejection {
+		bits := make([]byte, failBit/8+1)
+		bits[failBit/8] = 0x80 >> (failBit % 8)
+		info.FailInfo = asn1.BitString{Bytes: bits, BitLength: failBit + 1}
+	}
+	return info
+}
+
+// tagged returns der wrapped in the explicit context-specific tag, as the choices of PKIBody are.
+func tagged(tag int, der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: der}
+}
+
+// elements returns the elements of a constructed value's contents.
+func elements(contents []byte) ([]asn1.RawValue, error) {
+	var out []asn1.RawValue
+	for len(contents) > 0 {
+		var v asn1.RawValue
+		rest, err := asn1.Unmarshal(contents, &v)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+		contents = rest
+	}
+	return out, nil
+}
+
+// implicitSequence restores the SEQUENCE tag of a value tagged implicitly, so it can be parsed.
+func implicitSequence(v asn1.RawValue) []byte {
+	der, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: v.Bytes})
+	return der
+}
+
+// template is what a CertTemplate (RFC 4211 §5) asks for.
+type template struct {
+	Subject   *pkix.Name // nil when absent
+	PublicKey any
+	SANs      *x509.CertificateRequest // only the SAN fields are set; nil when absent
+	Days      int                      // 0 when no end of validity is requested
+}
+
+// parseTemplate reads the subject, public key, SANs and requested end of validity of a CertTemplate.
+// Other requested extensions are ignored: the profile decides them.
+func parseTemplate(v asn1.RawValue) (*template, error) {
+	fields, err := elements(v.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("malformed certificate template: %w", err)
+	}
+	t := &template{}
+	for _, f := range fields {
+		if f.Class != asn1.ClassContextSpecific {
+			return nil, errors.New("malformed certificate template")
+		}
+		switch f.Tag {
+		case 4: // validity: notAfter [1] Time
+			validity, err := elements(f.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("malformed validity: %w", err)
+			}
+			for _, e := range validity {
+				if e.Tag != 1 {
+					continue
+				}
+				var notAfter time.Time
+				if _, err := asn1.Unmarshal(e.Bytes, &notAfter); err != nil {
+					return nil, fmt.Errorf("malformed validity: %w", err)
+				}
+				t.Days = int(math.Ceil(time.Until(notAfter).Hours() / 24))
+				if t.Days < 1 {
+					return nil, errors.New("the requested validity ends in the past")
+				}
+			}
+		case 5: // subject [5] Name
+			var rdn pkix.RDNSequence
+			if _, err := asn1.Unmarshal(f.Bytes, &rdn); err != nil {
+				return nil, fmt.Errorf("malformed subject: %w", err)
+			}
+			if len(rdn) > 0 {
+				var name pkix.Name
+				name.FillFromRDNSequence(&rdn)
+				t.Subject = &name
+			}
+		case 6: // publicKey [6] SubjectPublicKeyInfo
+			if t.PublicKey, err = x509.ParsePKIXPublicKey(implicitSequence(f)); err != nil {
+				return nil, fmt.Errorf("unsupported public key: %w", err)
+			}
+		case 9: // extensions [9] Extensions
+			var exts []pkix.Extension
+			if _, err := asn1.Unmarshal(implicitSequence(f), &exts); err != nil {
+				return nil, fmt.Errorf("malformed extensions: %w", err)
+			}
+			for _, ext := range exts {
+				if ext.Id.Equal(oidSubjectAltName) {
+					if t.SANs, err = parseSANs(ext.Value); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	return t, nil
+}
+
+// parseSANs reads the DNS names, IP addresses, e-mail addresses and URIs of a SAN extension.
+func parseSANs(der []byte) (*x509.CertificateRequest, error) {
+	var names []asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &names); err != nil {
+		return nil, fmt.Errorf("malformed subject alternative names: %w", err)
+	}
+	sans := &x509.CertificateRequest{}
+	for _, n := range names {
+		switch n.Tag {
+		case 1:
+			sans.EmailAddresses = append(sans.EmailAddresses, string(n.Bytes))
+		case 2:
+			sans.DNSNames = append(sans.DNSNames, string(n.Bytes))
+		case 6:
+			u, err := url.Parse(string(n.Bytes))
+			if err != nil {
+				return nil, fmt.Errorf("invalid URI '%s': %w", n.Bytes, err)
+			}
+			sans.URIs = append(sans.URIs, u)
+		case 7:
+			if len(n.Bytes) != net.IPv4len && len(n.Bytes) != net.IPv6len {
+				return nil, errors.New("malformed IP address")
+			}
+			sans.IPAddresses = append(sans.IPAddresses, net.IP(n.Bytes))
+		default:
+			return nil, fmt.Errorf("unsupported subject alternative name of type %d", n.Tag)
+		}
+	}
+	return sans, nil
+}
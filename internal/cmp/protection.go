@@ -0,0 +1,170 @@
+package cmp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"my-pki/internal/utils"
+)
+
+// maxIterations bounds the PBM iteration count a client may make the server compute, as OpenSSL does.
+const maxIterations = 100000
+
+var (
+	oidPasswordBasedMAC = asn1.ObjectIdentifier{1, 2, 840, 113533, 7, 66, 13}
+
+	// One-way functions of PBM
+	owfs = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+	// MAC algorithms of PBM
+	macs = map[string]crypto.Hash{
+		"1.3.6.1.5.5.8.1.2":   crypto.SHA1,
+		"1.2.840.113549.2.7":  crypto.SHA1,
+		"1.2.840.113549.2.9":  crypto.SHA256,
+		"1.2.840.113549.2.10": crypto.SHA384,
+		"1.2.840.113549.2.11": crypto.SHA512,
+	}
+	// Signature algorithms of signature protection and of proofs of possession
+	signatureAlgorithms = map[string]signatureAlgorithm{
+		"1.2.840.10045.4.3.2":   {keyECDSA, crypto.SHA256},
+		"1.2.840.10045.4.3.3":   {keyECDSA, crypto.SHA384},
+		"1.2.840.10045.4.3.4":   {keyECDSA, crypto.SHA512},
+		"1.2.840.113549.1.1.11": {keyRSA, crypto.SHA256},
+		"1.2.840.113549.1.1.12": {keyRSA, crypto.SHA384},
+		"1.2.840.113549.1.1.13": {keyRSA, crypto.SHA512},
+		"1.3.101.112":           {keyEd25519, 0},
+	}
+)
+
+// Key types of signature algorithms.
+const (
+	keyECDSA = iota
+	keyRSA
+	keyEd25519
+)
+
+type signatureAlgorithm struct {
+	key  int
+	hash crypto.Hash // 0 for Ed25519, which signs the message itself
+}
+
+type pbmParameter struct {
+	Salt           []byte
+	OWF            pkix.AlgorithmIdentifier
+	IterationCount int
+	MAC            pkix.AlgorithmIdentifier
+}
+
+// pbm computes the password-based MAC of data (RFC 4211 §4.4).
+func pbm(secret []byte, params *pbmParameter, data []byte) ([]byte, error) {
+	owf, ok := owfs[params.OWF.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported PBM one-way function %s", params.OWF.Algorithm)
+	}
+	mac, ok := macs[params.MAC.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported PBM MAC algorithm %s", params.MAC.Algorithm)
+	}
+	if params.IterationCount < 1 || params.IterationCount > maxIterations {
+		return nil, fmt.Errorf("PBM iteration count %d is outside 1 to %d", params.IterationCount, maxIterations)
+	}
+	h := owf.New()
+	h.Write(secret)
+	h.Write(params.Salt)
+	key := h.Sum(nil)
+	for i := 1; i < params.IterationCount; i++ {
+		h.Reset()
+		h.Write(key)
+		key = h.Sum(key[:0])
+	}
+	m := hmac.New(mac.New, key)
+	m.Write(data)
+	return m.Sum(nil), nil
+}
+
+// newPBM returns the parameters of a fresh salt with the algorithms of the request's.
+func newPBM(request *pbmParameter) (*pbmParameter, error) {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(utils.Rand, salt); err != nil {
+		return nil, err
+	}
+	params := *request
+	params.Salt = salt
+	return &params, nil
+}
+
+// verifySignature checks sig over data by pub with the signature algorithm alg.
+func verifySignature(pub crypto.PublicKey, alg pkix.AlgorithmIdentifier, data, sig []byte) error {
+	sa, ok := signatureAlgorithms[alg.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported signature algorithm %s", alg.Algorithm)
+	}
+	var digest []byte
+	if sa.hash != 0 {
+		h := sa.hash.New()
+		h.Write(data)
+		digest = h.Sum(nil)
+	}
+	valid := false
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		valid = sa.key == keyECDSA && ecdsa.VerifyASN1(k, digest, sig)
+	case *rsa.PublicKey:
+		valid = sa.key == keyRSA && rsa.VerifyPKCS1v15(k, sa.hash, digest, sig) == nil
+	case ed25519.PublicKey:
+		valid = sa.key == keyEd25519 && ed25519.Verify(k, data, sig)
+	default:
+		return fmt.Errorf("unsupported %T key", pub)
+	}
+	if !valid {
+		return errors.New("signature is invalid")
+	}
+	return nil
+}
+
+// caHashes maps the ECDSA curves of CA keys to the hash their responses are signed with.
+var caHashes = map[elliptic.Curve]struct {
+	hash crypto.Hash
+	oid  asn1.ObjectIdentifier
+}{
+	elliptic.P256(): {crypto.SHA256, asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+	elliptic.P384(): {crypto.SHA384, asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}},
+	elliptic.P521(): {crypto.SHA512, asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}},
+}
+
+// signatureAlgorithmOf returns the algorithm responses are signed with by the CA key signer.
+func signatureAlgorithmOf(signer crypto.Signer) (pkix.AlgorithmIdentifier, error) {
+	if pub, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		if h, ok := caHashes[pub.Curve]; ok {
+			return pkix.AlgorithmIdentifier{Algorithm: h.oid}, nil
+		}
+	}
+	return pkix.AlgorithmIdentifier{}, fmt.Errorf("unsupported CA key %T for signing CMP responses", signer.Public())
+}
+
+// sign signs data with the CA key signer, as signatureAlgorithmOf names.
+func sign(signer crypto.Signer, data []byte) ([]byte, error) {
+	if _, err := signatureAlgorithmOf(signer); err != nil {
+		return nil, err
+	}
+	hash := caHashes[signer.Public().(*ecdsa.PublicKey).Curve].hash
+	h := hash.New()
+	h.Write(data)
+	sig, err := signer.Sign(utils.Rand, h.Sum(nil), hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign the response: %w", err)
+	}
+	return sig, nil
+}
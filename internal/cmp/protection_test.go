@@ -0,0 +1,224 @@
+package cmp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"my-pki/internal/utils"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func algorithm(oid string) pkix.AlgorithmIdentifier {
+	var id asn1.ObjectIdentifier
+	for _, arc := range strings.Split(oid, ".") {
+		n, _ := strconv.Atoi(arc)
+		id = append(id, n)
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: id}
+}
+
+func TestPBM(t *testing.T) {
+	salt := unhex(t, "000102030405060708090a0b0c0d0e0f")
+	tests := []struct {
+		name       string
+		secret     string
+		owf        string
+		iterations int
+		mac        string
+		data       string
+		want       string
+		wantErr    string
+	}{
+		{"sha1, hmac-sha1", "insta", "1.3.14.3.2.26", 1, "1.3.6.1.5.5.8.1.2", "protected part",
+			"15e990b65399396b77c28db786e39a04b156d02a", ""},
+		{"sha1, 500 iterations", "insta", "1.3.14.3.2.26", 500, "1.2.840.113549.2.7", "protected part",
+			"1eb5860459dd5054492bc726d708be340c495c15", ""},
+		{"sha256, hmac-sha256", "insta", "2.16.840.1.101.3.4.2.1", 500, "1.2.840.113549.2.9", "protected part",
+			"6ec32e85d7e5cd4ed0cc40b64133c47fd48f24f7e241c594dc92ed47d2769ef6", ""},
+		{"sha512, hmac-sha384, no data", "pass:word", "2.16.840.1.101.3.4.2.3", 10, "1.2.840.113549.2.10", "",
+			"c14bb4527ca0a7fc7dc0c53a57b6cb17228fb6dbf9ff954a931dde2a5731ce6a682c8ca1488b1d767abc599e60b6f96c", ""},
+		{"md5 one-way function", "insta", "1.2.840.113549.2.5", 1, "1.2.840.113549.2.9", "", "", "unsupported PBM one-way function"},
+		{"hmac-md5", "insta", "1.3.14.3.2.26", 1, "1.3.6.1.5.5.8.1.1", "", "", "unsupported PBM MAC algorithm"},
+		{"no iterations", "insta", "1.3.14.3.2.26", 0, "1.3.6.1.5.5.8.1.2", "", "", "outside 1 to 100000"},
+		{"too many iterations", "insta", "1.3.14.3.2.26", maxIterations + 1, "1.3.6.1.5.5.8.1.2", "", "", "outside 1 to 100000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := &pbmParameter{Salt: salt, OWF: algorithm(tt.owf), IterationCount: tt.iterations, MAC: algorithm(tt.mac)}
+			got, err := pbm([]byte(tt.secret), params, []byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("pbm = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pbm: %v", err)
+			}
+			if hex.EncodeToString(got) != tt.want {
+				t.Errorf("pbm = %x, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPBM(t *testing.T) {
+	saved := utils.Rand
+	utils.Rand = bytes.NewReader(bytes.Repeat([]byte{0xa5}, 16))
+	t.Cleanup(func() { utils.Rand = saved })
+
+	request := &pbmParameter{Salt: []byte("client salt"), OWF: algorithm("2.16.840.1.101.3.4.2.1"), IterationCount: 500, MAC: algorithm("1.2.840.113549.2.9")}
+	params, err := newPBM(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(params.Salt, bytes.Repeat([]byte{0xa5}, 16)) {
+		t.Errorf("newPBM salt = %x, want 16 bytes of utils.Rand", params.Salt)
+	}
+	if !params.OWF.Algorithm.Equal(request.OWF.Algorithm) || !params.MAC.Algorithm.Equal(request.MAC.Algorithm) ||
+		params.IterationCount != 500 || string(request.Salt) != "client salt" {
+		t.Errorf("newPBM = %+v from %+v", params, request)
+	}
+	// utils.Rand is exhausted
+	if _, err := newPBM(request); err == nil {
+		t.Error("newPBM succeeded without randomness")
+	}
+}
+
+// testKey is the P-256 key of RFC 6979 appendix A.2.5.
+func testKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	d, _ := new(big.Int).SetString("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721", 16)
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = key.Curve.ScalarBaseMult(d.Bytes())
+	return key
+}
+
+func TestVerifySignature(t *testing.T) {
+	ecKey := testKey(t)
+	// RFC 6979 appendix A.2.5, SHA-256 and the message "sample"
+	r, _ := new(big.Int).SetString("EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716", 16)
+	s, _ := new(big.Int).SetString("F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8", 16)
+	ecSig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 8032 §7.1, tests 1 and 2
+	edKey1 := ed25519.PublicKey(unhex(t, "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"))
+	edSig1 := unhex(t, "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b")
+	edKey2 := ed25519.PublicKey(unhex(t, "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c"))
+	edSig2 := unhex(t, "92a009a9f0d4cab8720e820b5f642540a2b27b5416503f8fb3762223ebdb69da085ac1e43e15996e458f3613d0f11d8c387b2eaeb4302aeeb00d291612bb0c00")
+
+	tests := []struct {
+		name    string
+		pub     crypto.PublicKey
+		alg     string
+		data    []byte
+		sig     []byte
+		wantErr string
+	}{
+		{"ecdsa-with-SHA256", &ecKey.PublicKey, "1.2.840.10045.4.3.2", []byte("sample"), ecSig, ""},
+		{"ed25519, empty message", edKey1, "1.3.101.112", nil, edSig1, ""},
+		{"ed25519", edKey2, "1.3.101.112", []byte{0x72}, edSig2, ""},
+		{"ecdsa, tampered data", &ecKey.PublicKey, "1.2.840.10045.4.3.2", []byte("simple"), ecSig, "signature is invalid"},
+		{"ecdsa, other hash", &ecKey.PublicKey, "1.2.840.10045.4.3.3", []byte("sample"), ecSig, "signature is invalid"},
+		{"ed25519, tampered data", edKey2, "1.3.101.112", []byte{0x73}, edSig2, "signature is invalid"},
+		{"ed25519, other key", edKey1, "1.3.101.112", []byte{0x72}, edSig2, "signature is invalid"},
+		{"ecdsa key, ed25519 algorithm", &ecKey.PublicKey, "1.3.101.112", []byte("sample"), ecSig, "signature is invalid"},
+		{"ed25519 key, ecdsa algorithm", edKey1, "1.2.840.10045.4.3.2", nil, edSig1, "signature is invalid"},
+		{"ecdsa-with-SHA1", &ecKey.PublicKey, "1.2.840.10045.4.1", []byte("sample"), ecSig, "unsupported signature algorithm"},
+		{"unsupported key", []byte("key"), "1.3.101.112", nil, edSig1, "unsupported []uint8 key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifySignature(tt.pub, algorithm(tt.alg), tt.data, tt.sig)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("verifySignature: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("verifySignature = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// recordingSigner remembers the randomness source it was given.
+type recordingSigner struct {
+	crypto.Signer
+	rand io.Reader
+}
+
+func (s *recordingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.rand = rand
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestSign(t *testing.T) {
+	saved := utils.Rand
+	utils.Rand = io.MultiReader(rand.Reader) // a reader of its own, to tell it apart
+	t.Cleanup(func() { utils.Rand = saved })
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		signer  crypto.Signer
+		wantAlg string
+	}{
+		{"P-256", testKey(t), "1.2.840.10045.4.3.2"},
+		{"P-384", p384, "1.2.840.10045.4.3.3"},
+		{"Ed25519", edKey, ""},
+	}
+	data := []byte("protected part")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := &recordingSigner{Signer: tt.signer}
+			alg, err := signatureAlgorithmOf(signer)
+			sig, signErr := sign(signer, data)
+			if tt.wantAlg == "" {
+				if err == nil || signErr == nil {
+					t.Fatalf("signatureAlgorithmOf = %v, sign = %v, want errors", alg.Algorithm, signErr)
+				}
+				return
+			}
+			if err != nil || signErr != nil {
+				t.Fatalf("signatureAlgorithmOf: %v, sign: %v", err, signErr)
+			}
+			if alg.Algorithm.String() != tt.wantAlg {
+				t.Errorf("signatureAlgorithmOf = %s, want %s", alg.Algorithm, tt.wantAlg)
+			}
+			if signer.rand != utils.Rand {
+				t.Errorf("sign drew from %T rather than utils.Rand", signer.rand)
+			}
+			if err := verifySignature(signer.Public(), alg, data, sig); err != nil {
+				t.Errorf("verifySignature of sign: %v", err)
+			}
+		})
+	}
+}
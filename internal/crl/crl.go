@@ -0,0 +1,174 @@
+// Package crl builds full and delta certificate revocation lists from the revocations recorded in the inventory.
+package crl
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"time"
+)
+
+// CRL extension identifiers (RFC 5280 sections 5.2.4 and 5.2.6).
+var (
+	oidDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidFreshestCRL       = asn1.ObjectIdentifier{2, 5, 29, 46}
+)
+
+// distributionPoint is a DistributionPoint holding only a full name of URIs, as in the CRL distribution points extension.
+type distributionPoint struct {
+	Name struct {
+		FullName []asn1.RawValue `asn1:"optional,tag:0"`
+	} `asn1:"optional,tag:0"`
+}
+
+// Create signs a CRL for caCert listing every revoked record in revoked, and returns it PEM encoded.
+// extra carries additional CRL extensions, such as FreshestCRL.
+func Create(caCert *x509.Certificate, signer crypto.Signer, revoked []*inventory.CertRecord, number int64, thisUpdate, nextUpdate time.Time, extra ...pkix.Extension) ([]byte, error) {
+	return create(caCert, signer, revoked, nil, number, thisUpdate, nextUpdate, extra)
+}
+
+// Entries selects the records of ca the next CRL lists. A full CRL lists every unexpired revoked
+// certificate, since expired ones fail validation anyway (RFC 5280 section 3.3). A delta CRL lists the
+// revocations made since the base CRL, and in released the holds lifted since then, which the base still lists.
+func Entries(db *inventory.DB, ca *inventory.CARecord, now time.Time, delta bool) (revoked, released []*inventory.CertRecord) {
+	for _, rec := range db.IssuedBy(ca.SHA256) {
+		if !rec.NotAfter.After(now) {
+			continue
+		}
+		if rec.Status != inventory.StatusRevoked {
+			if delta && rec.ReleasedAt != nil && rec.ReleasedAt.After(*ca.BaseCRLAt) {
+				released = append(released, rec)
+			}
+			continue
+		}
+		if delta && !rec.RevokedAt.After(*ca.BaseCRLAt) {
+			continue // already on the base CRL
+		}
+		revoked = append(revoked, rec)
+	}
+	return revoked, released
+}
+
+// FreshestCRL returns the extension telling relying parties where the delta CRLs of a full CRL are published.
+func FreshestCRL(urls []string) (pkix.Extension, error) {
+	var dp distributionPoint
+	for _, u := range urls {
+		dp.Name.FullName = append(dp.Name.FullName, asn1.RawValue{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(u)})
+	}
+	value, err := asn1.Marshal([]distributionPoint{dp})
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("failed to encode freshest CRL extension: %w", err)
+	}
+	return pkix.Extension{Id: oidFreshestCRL, Value: value}, nil
+}
+
+// FreshestURLs returns the delta CRL URLs named by the freshest CRL extension of rl, if any.
+func FreshestURLs(rl *x509.RevocationList) ([]string, error) {
+	for _, ext := range rl.Extensions {
+		if !ext.Id.Equal(oidFreshestCRL) {
+			continue
+		}
+		var dps []distributionPoint
+		if _, err := asn1.Unmarshal(ext.Value, &dps); err != nil {
+			return nil, fmt.Errorf("invalid freshest CRL extension: %w", err)
+		}
+		var urls []string
+		for _, dp := range dps {
+			for _, name := range dp.Name.FullName {
+				if name.Tag == 6 && name.Class == asn1.ClassContextSpecific {
+					urls = append(urls, string(name.Bytes))
+				}
+			}
+		}
+		return urls, nil
+	}
+	return nil, nil
+}
+
+// DeltaBase returns the base CRL number named by the delta CRL indicator of rl, or nil for a full CRL.
+func DeltaBase(rl *x509.RevocationList) (*big.Int, error) {
+	for _, ext := range rl.Extensions {
+		if !ext.Id.Equal(oidDeltaCRLIndicator) {
+			continue
+		}
+		base := new(big.Int)
+		if _, err := asn1.Unmarshal(ext.Value, &base); err != nil {
+			return nil, fmt.Errorf("invalid delta CRL indicator: %w", err)
+		}
+		return base, nil
+	}
+	return nil, nil
+}
+
+// CreateDelta signs a delta CRL listing the revocations in revoked made since the full CRL numbered
+// baseNumber, and the certificates in released taken off hold since then as removeFromCRL. Relying
+// parties combine it with that base CRL (or a later one).
+func CreateDelta(caCert *x509.Certificate, signer crypto.Signer, revoked, released []*inventory.CertRecord, number, baseNumber int64, thisUpdate, nextUpdate time.Time) ([]byte, error) {
+	if baseNumber <= 0 || baseNumber >= number {
+		return nil, fmt.Errorf("invalid base CRL number %d for delta CRL %d", baseNumber, number)
+	}
+	value, err := asn1.Marshal(big.NewInt(baseNumber))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode delta CRL indicator: %w", err)
+	}
+	return create(caCert, signer, revoked, released, number, thisUpdate, nextUpdate, []pkix.Extension{
+		{Id: oidDeltaCRLIndicator, Critical: true, Value: value},
+	})
+}
+
+func create(caCert *x509.Certificate, signer crypto.Signer, revoked, released []*inventory.CertRecord, number int64, thisUpdate, nextUpdate time.Time, extra []pkix.Extension) ([]byte, error) {
+	if caCert.KeyUsage != 0 && caCert.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return nil, fmt.Errorf("CA certificate '%s' lacks the cRLSign key usage and cannot sign CRLs", caCert.Subject)
+	}
+	template := &x509.RevocationList{
+		Number:          big.NewInt(number),
+		ThisUpdate:      thisUpdate,
+		NextUpdate:      nextUpdate,
+		ExtraExtensions: extra,
+	}
+	for _, rec := range revoked {
+		if rec.Status != inventory.StatusRevoked || rec.RevokedAt == nil {
+			continue
+		}
+		entry, err := crlEntry(rec, *rec.RevokedAt, rec.RevocationReason)
+		if err != nil {
+			return nil, err
+		}
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, entry)
+	}
+	for _, rec := range released {
+		if rec.Status == inventory.StatusRevoked || rec.ReleasedAt == nil {
+			continue
+		}
+		entry, err := crlEntry(rec, *rec.ReleasedAt, inventory.ReasonRemoveFromCRL)
+		if err != nil {
+			return nil, err
+		}
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, entry)
+	}
+	der, err := x509.CreateRevocationList(utils.Rand, template, caCert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
+
+// crlEntry returns the CRL entry of rec with the given time and reason.
+func crlEntry(rec *inventory.CertRecord, at time.Time, reason int) (x509.RevocationListEntry, error) {
+	serial, err := hex.DecodeString(rec.Serial)
+	if err != nil {
+		return x509.RevocationListEntry{}, fmt.Errorf("invalid serial '%s' in inventory: %w", rec.Serial, err)
+	}
+	return x509.RevocationListEntry{
+		SerialNumber:   new(big.Int).SetBytes(serial),
+		RevocationTime: at,
+		ReasonCode:     reason,
+	}, nil
+}
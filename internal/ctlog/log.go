@@ -0,0 +1,251 @@
+// Package ctlog implements a local, append-only, certificate-transparency-style issuance log.
+//
+// Every certificate issued by a CA is appended to the CA's log file together with a signed tree
+// head (STH) covering all entries so far. The STH is signed with the CA key at issuance time,
+// so the log can later be checked for completeness and tampering with only the CA certificate.
+package ctlog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Entry is a single logged certificate.
+type Entry struct {
+	Index     int       `json:"index"`
+	Timestamp time.Time `json:"timestamp"`
+	Cert      []byte    `json:"cert"`
+}
+
+// TreeHead is a signed commitment to the first TreeSize entries of the log.
+type TreeHead struct {
+	TreeSize  int       `json:"tree_size"`
+	Timestamp time.Time `json:"timestamp"`
+	RootHash  []byte    `json:"root_hash"`
+	Signature []byte    `json:"signature"`
+}
+
+// record is one line of the log file; exactly one field is set.
+type record struct {
+	Entry *Entry    `json:"entry,omitempty"`
+	STH   *TreeHead `json:"sth,omitempty"`
+}
+
+// Log is an in-memory view of an issuance log file.
+type Log struct {
+	path       string
+	entries    []Entry
+	treeHeads  []TreeHead
+	leafHashes [][]byte
+}
+
+// PathForCA returns the default log location for a CA certificate, e.g. "rootCA.pem" -> "rootCA.issuance.log".
+func PathForCA(caPemPath string) string {
+	return strings.TrimSuffix(caPemPath, filepath.Ext(caPemPath)) + ".issuance.log"
+}
+
+// Open reads the log at path. A missing file yields an empty log.
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open issuance log '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("malformed issuance log line %d: %w", lineNo, err)
+		}
+		switch {
+		case rec.Entry != nil:
+			if rec.Entry.Index != len(l.entries) {
+				return nil, fmt.Errorf("issuance log line %d: entry index %d out of sequence (expected %d)",
+					lineNo, rec.Entry.Index, len(l.entries))
+			}
+			l.entries = append(l.entries, *rec.Entry)
+			l.leafHashes = append(l.leafHashes, LeafHash(rec.Entry.Cert))
+		case rec.STH != nil:
+			l.treeHeads = append(l.treeHeads, *rec.STH)
+		default:
+			return nil, fmt.Errorf("issuance log line %d: empty record", lineNo)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read issuance log '%s': %w", path, err)
+	}
+	return l, nil
+}
+
+// Entries returns the logged certificates in issuance order.
+func (l *Log) Entries() []Entry { return l.entries }
+
+// TreeHeads returns all signed tree heads in the order they were written.
+func (l *Log) TreeHeads() []TreeHead { return l.treeHeads }
+
+// Append logs certDER and writes a new tree head signed by signer.
+func (l *Log) Append(certDER []byte, signer crypto.Signer, now time.Time) (*TreeHead, error) {
+	entry := Entry{Index: len(l.entries), Timestamp: now.UTC(), Cert: certDER}
+	leafHashes := append(l.leafHashes[:len(l.leafHashes):len(l.leafHashes)], LeafHash(certDER))
+
+	sth := TreeHead{TreeSize: len(leafHashes), Timestamp: now.UTC(), RootHash: RootHash(leafHashes)}
+	digest := sha256.Sum256(sth.signedData())
+	sig, err := signer.Sign(utils.Rand, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign tree head: %w", err)
+	}
+	sth.Signature = sig
+
+	var buf bytes.Buffer
+	for _, rec := range []record{{Entry: &entry}, {STH: &sth}} {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode log record: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open issuance log '%s': %w", l.path, err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to append to issuance log '%s': %w", l.path, err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close issuance log '%s': %w", l.path, err)
+	}
+
+	l.entries = append(l.entries, entry)
+	l.leafHashes = leafHashes
+	l.treeHeads = append(l.treeHeads, sth)
+	return &sth, nil
+}
+
+// AppendCertificatePEM opens the log at path and appends the certificate contained in certPEM.
+func AppendCertificatePEM(path string, certPEM []byte, signer crypto.Signer, now time.Time) error {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return errors.New("failed to decode PEM block containing certificate")
+	}
+	l, err := Open(path)
+	if err != nil {
+		return err
+	}
+	_, err = l.Append(block.Bytes, signer, now)
+	return err
+}
+
+// Find returns the index of certDER in the log, or -1 if it has not been logged.
+func (l *Log) Find(certDER []byte) int {
+	for i, e := range l.entries {
+		if bytes.Equal(e.Cert, certDER) {
+			return i
+		}
+	}
+	return -1
+}
+
+// InclusionProof returns the audit path proving entry index is included in the latest tree head.
+func (l *Log) InclusionProof(index int) (*TreeHead, [][]byte, error) {
+	if len(l.treeHeads) == 0 {
+		return nil, nil, errors.New("log has no signed tree head")
+	}
+	sth := l.treeHeads[len(l.treeHeads)-1]
+	if index < 0 || index >= sth.TreeSize || sth.TreeSize > len(l.leafHashes) {
+		return nil, nil, fmt.Errorf("entry %d is not covered by the latest tree head (size %d)", index, sth.TreeSize)
+	}
+	return &sth, InclusionProof(l.leafHashes[:sth.TreeSize], index), nil
+}
+
+// ConsistencyProof returns the proof that the tree head of oldSize entries is a prefix of the latest
+// tree head.
+func (l *Log) ConsistencyProof(oldSize int) (*TreeHead, [][]byte, error) {
+	if len(l.treeHeads) == 0 {
+		return nil, nil, errors.New("log has no signed tree head")
+	}
+	sth := l.treeHeads[len(l.treeHeads)-1]
+	if oldSize <= 0 || oldSize > sth.TreeSize || sth.TreeSize > len(l.leafHashes) {
+		return nil, nil, fmt.Errorf("a tree of %d entries is not covered by the latest tree head (size %d)", oldSize, sth.TreeSize)
+	}
+	return &sth, ConsistencyProof(l.leafHashes[:sth.TreeSize], oldSize), nil
+}
+
+// Verify checks every tree head signature against the CA certificate, recomputes every root hash,
+// checks that the latest tree head covers all entries and that every entry was signed by the CA.
+// It returns the first problem found.
+func (l *Log) Verify(caCert *x509.Certificate) error {
+	ecPub, ok := caCert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported CA public key type %T", caCert.PublicKey)
+	}
+
+	lastSize := 0
+	for i, sth := range l.treeHeads {
+		digest := sha256.Sum256(sth.signedData())
+		if !ecdsa.VerifyASN1(ecPub, digest[:], sth.Signature) {
+			return fmt.Errorf("tree head %d (size %d): invalid signature", i, sth.TreeSize)
+		}
+		if sth.TreeSize < lastSize {
+			return fmt.Errorf("tree head %d: size shrank from %d to %d", i, lastSize, sth.TreeSize)
+		}
+		if sth.TreeSize > len(l.leafHashes) {
+			return fmt.Errorf("tree head %d: covers %d entries but only %d are present (entries removed?)",
+				i, sth.TreeSize, len(l.leafHashes))
+		}
+		if !bytes.Equal(RootHash(l.leafHashes[:sth.TreeSize]), sth.RootHash) {
+			return fmt.Errorf("tree head %d (size %d): root hash mismatch (entries modified?)", i, sth.TreeSize)
+		}
+		lastSize = sth.TreeSize
+	}
+	if lastSize != len(l.entries) {
+		return fmt.Errorf("%d entries are not covered by any signed tree head", len(l.entries)-lastSize)
+	}
+
+	for _, e := range l.entries {
+		cert, err := x509.ParseCertificate(e.Cert)
+		if err != nil {
+			return fmt.Errorf("entry %d: invalid certificate: %w", e.Index, err)
+		}
+		if err := cert.CheckSignatureFrom(caCert); err != nil {
+			return fmt.Errorf("entry %d (%s): not issued by this CA: %w", e.Index, cert.Subject.CommonName, err)
+		}
+	}
+	return nil
+}
+
+// signedData is the byte string covered by a tree head signature.
+func (th *TreeHead) signedData() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("gosec-sth-v1")
+	binary.Write(&buf, binary.BigEndian, uint64(th.TreeSize))
+	binary.Write(&buf, binary.BigEndian, th.Timestamp.UnixNano())
+	buf.Write(th.RootHash)
+	return buf.Bytes()
+}
@@ -0,0 +1,116 @@
+package ctlog
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"my-pki/internal/utils"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordingSigner is an ECDSA signer that remembers the randomness source it was given.
+type recordingSigner struct {
+	*ecdsa.PrivateKey
+	rand []io.Reader
+}
+
+func (s *recordingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.rand = append(s.rand, rand)
+	return s.PrivateKey.Sign(rand, digest, opts)
+}
+
+func TestLogAppend(t *testing.T) {
+	saved := utils.Rand
+	utils.Rand = bytes.NewReader(bytes.Repeat([]byte{0x5a}, 4096))
+	t.Cleanup(func() { utils.Rand = saved })
+
+	// The P-256 key of RFC 6979 appendix A.2.5
+	d, _ := new(big.Int).SetString("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721", 16)
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = key.Curve.ScalarBaseMult(d.Bytes())
+	signer := &recordingSigner{PrivateKey: key}
+
+	path := filepath.Join(t.TempDir(), "root.issuance.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, leaf := range leaves {
+		if _, err := l.Append(unhex(t, leaf), signer, now); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	for i, r := range signer.rand {
+		if r != utils.Rand {
+			t.Errorf("tree head %d was signed with %T rather than utils.Rand", i+1, r)
+		}
+	}
+
+	// Read back, the log has the reference tree heads, each signed by the key
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heads := l.TreeHeads()
+	if len(heads) != len(roots) || len(l.Entries()) != len(leaves) {
+		t.Fatalf("log has %d entries and %d tree heads, want %d", len(l.Entries()), len(heads), len(leaves))
+	}
+	for i, sth := range heads {
+		if sth.TreeSize != i+1 || hex.EncodeToString(sth.RootHash) != roots[i] {
+			t.Errorf("tree head %d: size %d, root %x, want %s", i+1, sth.TreeSize, sth.RootHash, roots[i])
+		}
+		digest := sha256.Sum256(sth.signedData())
+		if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sth.Signature) {
+			t.Errorf("tree head %d: invalid signature", i+1)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		prove   func() (*TreeHead, [][]byte, error)
+		check   func(sth *TreeHead, proof [][]byte) bool
+		wantErr bool
+	}{
+		{"inclusion of entry 5", func() (*TreeHead, [][]byte, error) { return l.InclusionProof(5) },
+			func(sth *TreeHead, proof [][]byte) bool {
+				return VerifyInclusion(LeafHash(l.Entries()[5].Cert), 5, sth.TreeSize, proof, sth.RootHash)
+			}, false},
+		{"consistency from 6", func() (*TreeHead, [][]byte, error) { return l.ConsistencyProof(6) },
+			func(sth *TreeHead, proof [][]byte) bool {
+				return VerifyConsistency(6, sth.TreeSize, proof, heads[5].RootHash, sth.RootHash)
+			}, false},
+		{"consistency from the latest", func() (*TreeHead, [][]byte, error) { return l.ConsistencyProof(8) },
+			func(sth *TreeHead, proof [][]byte) bool {
+				return len(proof) == 0 && VerifyConsistency(8, sth.TreeSize, proof, heads[7].RootHash, sth.RootHash)
+			}, false},
+		{"inclusion past the end", func() (*TreeHead, [][]byte, error) { return l.InclusionProof(8) }, nil, true},
+		{"consistency from nothing", func() (*TreeHead, [][]byte, error) { return l.ConsistencyProof(0) }, nil, true},
+		{"consistency from a larger tree", func() (*TreeHead, [][]byte, error) { return l.ConsistencyProof(9) }, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sth, proof, err := tt.prove()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sth.TreeSize != 8 || !tt.check(sth, proof) {
+				t.Errorf("proof %x against tree head of size %d does not verify", proof, sth.TreeSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package ctlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// Hashing follows RFC 6962 section 2.1: leaves and interior nodes use distinct prefixes
+// so a leaf can never be confused with an interior node.
+
+// LeafHash returns the Merkle leaf hash of data.
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// nodeHash returns the hash of an interior node with the given children.
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// largestPowerOfTwoBelow returns the largest power of two strictly less than n (n > 1).
+func largestPowerOfTwoBelow(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// RootHash computes the Merkle tree hash over the given leaf hashes.
+func RootHash(leafHashes [][]byte) []byte {
+	switch n := len(leafHashes); n {
+	case 0:
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	case 1:
+		return leafHashes[0]
+	default:
+		k := largestPowerOfTwoBelow(n)
+		return nodeHash(RootHash(leafHashes[:k]), RootHash(leafHashes[k:]))
+	}
+}
+
+// InclusionProof returns the audit path for the leaf at index within the tree formed by leafHashes.
+func InclusionProof(leafHashes [][]byte, index int) [][]byte {
+	n := len(leafHashes)
+	if n <= 1 || index < 0 || index >= n {
+		return nil
+	}
+	k := largestPowerOfTwoBelow(n)
+	if index < k {
+		return append(InclusionProof(leafHashes[:k], index), RootHash(leafHashes[k:]))
+	}
+	return append(InclusionProof(leafHashes[k:], index-k), RootHash(leafHashes[:k]))
+}
+
+// VerifyInclusion checks that leafHash is present at index in a tree of treeSize leaves with the given root.
+func VerifyInclusion(leafHash []byte, index, treeSize int, proof [][]byte, root []byte) bool {
+	if index < 0 || index >= treeSize {
+		return false
+	}
+	fn, sn := index, treeSize-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn%2 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn%2 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+// ConsistencyProof returns the proof that the tree of the first oldSize leaves is a prefix of the tree
+// formed by leafHashes (RFC 6962 section 2.1.2).
+func ConsistencyProof(leafHashes [][]byte, oldSize int) [][]byte {
+	if oldSize <= 0 || oldSize >= len(leafHashes) {
+		return nil
+	}
+	return subProof(leafHashes, oldSize, true)
+}
+
+// subProof is SUBPROOF of RFC 6962; complete tells whether the first oldSize leaves form a subtree
+// whose hash the verifier already has.
+func subProof(leafHashes [][]byte, oldSize int, complete bool) [][]byte {
+	n := len(leafHashes)
+	if oldSize == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{RootHash(leafHashes)}
+	}
+	k := largestPowerOfTwoBelow(n)
+	if oldSize <= k {
+		return append(subProof(leafHashes[:k], oldSize, complete), RootHash(leafHashes[k:]))
+	}
+	return append(subProof(leafHashes[k:], oldSize-k, false), RootHash(leafHashes[:k]))
+}
+
+// VerifyConsistency checks that the tree of oldSize leaves with oldRoot is a prefix of the tree of
+// newSize leaves with newRoot (RFC 9162 section 2.1.4.2).
+func VerifyConsistency(oldSize, newSize int, proof [][]byte, oldRoot, newRoot []byte) bool {
+	if oldSize <= 0 || oldSize > newSize {
+		return false
+	}
+	if oldSize == newSize {
+		return len(proof) == 0 && bytes.Equal(oldRoot, newRoot)
+	}
+	if oldSize&(oldSize-1) == 0 {
+		proof = append([][]byte{oldRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+	fn, sn := oldSize-1, newSize-1
+	for fn%2 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, p := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn%2 == 1 || fn == sn {
+			fr = nodeHash(p, fr)
+			sr = nodeHash(p, sr)
+			for fn%2 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, oldRoot) && bytes.Equal(sr, newRoot)
+}
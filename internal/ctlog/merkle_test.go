@@ -0,0 +1,191 @@
+package ctlog
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// The test vectors are those of the Certificate Transparency reference implementation: a tree of
+// the following eight leaves, its root hashes and proofs.
+var leaves = []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+
+var roots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func leafHashes(t *testing.T) [][]byte {
+	t.Helper()
+	var hashes [][]byte
+	for _, l := range leaves {
+		hashes = append(hashes, LeafHash(unhex(t, l)))
+	}
+	return hashes
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func unhexAll(t *testing.T, ss []string) [][]byte {
+	t.Helper()
+	var out [][]byte
+	for _, s := range ss {
+		out = append(out, unhex(t, s))
+	}
+	return out
+}
+
+func equalProofs(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRootHash(t *testing.T) {
+	hashes := leafHashes(t)
+	for n, want := range roots {
+		if got := hex.EncodeToString(RootHash(hashes[:n+1])); got != want {
+			t.Errorf("RootHash of %d leaves = %s, want %s", n+1, got, want)
+		}
+	}
+	if got := hex.EncodeToString(RootHash(nil)); got != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("RootHash of the empty tree = %s", got)
+	}
+}
+
+func TestInclusionProof(t *testing.T) {
+	tests := []struct {
+		index, size int
+		proof       []string
+	}{
+		{0, 1, nil},
+		{0, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{5, 8, []string{
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 3, []string{
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		}},
+		{1, 5, []string{
+			"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	}
+	hashes := leafHashes(t)
+	for _, tt := range tests {
+		want := unhexAll(t, tt.proof)
+		root := unhex(t, roots[tt.size-1])
+		got := InclusionProof(hashes[:tt.size], tt.index)
+		if !equalProofs(got, want) {
+			t.Errorf("InclusionProof(%d of %d) = %x, want %x", tt.index, tt.size, got, want)
+		}
+		if !VerifyInclusion(hashes[tt.index], tt.index, tt.size, want, root) {
+			t.Errorf("VerifyInclusion(%d of %d) rejected the reference proof", tt.index, tt.size)
+		}
+		// The proof must not verify for another leaf or position
+		if VerifyInclusion(hashes[(tt.index+1)%8], tt.index, tt.size, want, root) {
+			t.Errorf("VerifyInclusion(%d of %d) accepted another leaf", tt.index, tt.size)
+		}
+		if tt.size > 1 && VerifyInclusion(hashes[tt.index], tt.index^1, tt.size, want, root) {
+			t.Errorf("VerifyInclusion(%d of %d) accepted another index", tt.index, tt.size)
+		}
+		if len(want) > 0 && VerifyInclusion(hashes[tt.index], tt.index, tt.size, want[:len(want)-1], root) {
+			t.Errorf("VerifyInclusion(%d of %d) accepted a truncated proof", tt.index, tt.size)
+		}
+	}
+}
+
+func TestConsistencyProof(t *testing.T) {
+	tests := []struct {
+		oldSize, newSize int
+		proof            []string
+	}{
+		{1, 1, nil},
+		{1, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{6, 8, []string{
+			"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 5, []string{
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+		{3, 7, []string{
+			"0298d122906dcfc10892cb53a73992fc5b9f493ea4c9badb27b791b4127a7fe7",
+			"07506a85fd9dd2f120eb694f86011e5bb4662e5c415a62917033d4a9624487e7",
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+			"837dbb152e9b079010717e84e865da4ebc0fa198a806d59d31bf15accef22d0e",
+		}},
+	}
+	hashes := leafHashes(t)
+	for _, tt := range tests {
+		want := unhexAll(t, tt.proof)
+		oldRoot, newRoot := unhex(t, roots[tt.oldSize-1]), unhex(t, roots[tt.newSize-1])
+		got := ConsistencyProof(hashes[:tt.newSize], tt.oldSize)
+		if !equalProofs(got, want) {
+			t.Errorf("ConsistencyProof(%d to %d) = %x, want %x", tt.oldSize, tt.newSize, got, want)
+		}
+		if !VerifyConsistency(tt.oldSize, tt.newSize, want, oldRoot, newRoot) {
+			t.Errorf("VerifyConsistency(%d to %d) rejected the reference proof", tt.oldSize, tt.newSize)
+		}
+		if tt.oldSize == tt.newSize {
+			continue
+		}
+		// A rewritten history or a truncated proof must not verify
+		if VerifyConsistency(tt.oldSize, tt.newSize, want, unhex(t, roots[tt.oldSize]), newRoot) {
+			t.Errorf("VerifyConsistency(%d to %d) accepted another old root", tt.oldSize, tt.newSize)
+		}
+		if VerifyConsistency(tt.oldSize, tt.newSize, want, oldRoot, oldRoot) {
+			t.Errorf("VerifyConsistency(%d to %d) accepted another new root", tt.oldSize, tt.newSize)
+		}
+		if VerifyConsistency(tt.oldSize, tt.newSize, want[:len(want)-1], oldRoot, newRoot) {
+			t.Errorf("VerifyConsistency(%d to %d) accepted a truncated proof", tt.oldSize, tt.newSize)
+		}
+	}
+	if VerifyConsistency(4, 2, nil, unhex(t, roots[3]), unhex(t, roots[1])) {
+		t.Error("VerifyConsistency accepted a tree that shrank")
+	}
+}
+
+func TestConsistencyProofAllSizes(t *testing.T) {
+	hashes := leafHashes(t)
+	for n := 1; n <= len(hashes); n++ {
+		for m := 1; m <= n; m++ {
+			proof := ConsistencyProof(hashes[:n], m)
+			if !VerifyConsistency(m, n, proof, unhex(t, roots[m-1]), unhex(t, roots[n-1])) {
+				t.Errorf("VerifyConsistency(%d to %d) rejected its own proof", m, n)
+			}
+		}
+	}
+}
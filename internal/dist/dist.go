@@ -0,0 +1,303 @@
+// Package dist serves the public material of CAs over HTTP: certificates, chains and CRLs, at the
+// locations relying parties are pointed to by the AIA and CRL distribution point extensions.
+//
+// Each CA is published under a name, by default its certificate file name without extension:
+//
+//	/<name>.crt         certificate, DER (application/pkix-cert), the AIA caIssuers form
+//	/<name>.pem         certificate, PEM
+//	/<name>-chain.pem   certificate followed by its issuers up to the root, PEM
+//	/<name>.crl         latest full CRL, DER (application/pkix-crl)
+//	/<name>-delta.crl   latest delta CRL, DER
+//
+// Files are read on every request, so a CRL replaced by gen-crl is served without a restart.
+package dist
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"my-pki/internal/cadir"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Suffixes of the published files.
+const (
+	certSuffix     = ".crt"
+	pemSuffix      = ".pem"
+	chainSuffix    = "-chain.pem"
+	crlSuffix      = ".crl"
+	deltaCRLSuffix = "-delta.crl"
+)
+
+// CA is one published CA: its certificate and the CRL files gen-crl writes for it.
+type CA struct {
+	Name     string
+	PemPath  string
+	CRLPath  string
+	DeltaCRL string
+}
+
+// NewCA returns the CA for the certificate at pemPath, published under its file name without
+// extension, with the CRLs gen-crl writes next to it by default.
+func NewCA(pemPath string) CA {
+	base := strings.TrimSuffix(pemPath, filepath.Ext(pemPath))
+	return CA{Name: filepath.Base(base), PemPath: pemPath, CRLPath: CRLPath(pemPath), DeltaCRL: DeltaCRLPath(pemPath)}
+}
+
+// CRLPath returns the default file of a CA's full CRL, e.g. "issuingCA.pem" -> "issuingCA.crl", or
+// in the crl/ directory of a managed CA home.
+func CRLPath(caPemPath string) string {
+	return crlFile(caPemPath, crlSuffix)
+}
+
+// DeltaCRLPath returns the default file of a CA's delta CRL, e.g. "issuingCA.pem" -> "issuingCA-delta.crl".
+func DeltaCRLPath(caPemPath string) string {
+	return crlFile(caPemPath, deltaCRLSuffix)
+}
+
+func crlFile(caPemPath, suffix string) string {
+	if d, ok := cadir.Of(caPemPath); ok {
+		return d.CRLPath(caPemPath, suffix)
+	}
+	return strings.TrimSuffix(caPemPath, filepath.Ext(caPemPath)) + suffix
+}
+
+// CertURL returns the URL of a CA's DER certificate under baseURL, for the AIA caIssuers extension.
+func CertURL(baseURL, name string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + name + certSuffix
+}
+
+// CRLURL returns the URL of a CA's full CRL under baseURL, for the CRL distribution points extension.
+func CRLURL(baseURL, name string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + name + crlSuffix
+}
+
+// DeltaCRLURL returns the URL of a CA's delta CRL under baseURL, for the freshest CRL extension.
+func DeltaCRLURL(baseURL, name string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + name + deltaCRLSuffix
+}
+
+// Server is an http.Handler publishing CAs.
+type Server struct {
+	cas map[string]CA
+	// Logger receives the files that could not be served; nil logs to the default logger.
+	Logger *slog.Logger
+}
+
+// NewServer returns a server publishing cas, whose names must be unique.
+func NewServer(cas []CA) (*Server, error) {
+	s := &Server{cas: map[string]CA{}}
+	for _, ca := range cas {
+		if ca.Name == "" || strings.ContainsAny(ca.Name, "/?#") {
+			return nil, fmt.Errorf("invalid CA name '%s'", ca.Name)
+		}
+		if prev, ok := s.cas[ca.Name]; ok {
+			return nil, fmt.Errorf("CAs '%s' and '%s' would both be published as '%s'", prev.PemPath, ca.PemPath, ca.Name)
+		}
+		s.cas[ca.Name] = ca
+	}
+	return s, nil
+}
+
+// Paths lists the URL paths served, in order.
+func (s *Server) Paths() []string {
+	var paths []string
+	for _, name := range s.names() {
+		for _, suffix := range []string{certSuffix, pemSuffix, chainSuffix, crlSuffix, deltaCRLSuffix} {
+			paths = append(paths, "/"+name+suffix)
+		}
+	}
+	return paths
+}
+
+// names returns the published CA names, sorted.
+func (s *Server) names() []string {
+	var names []string
+	for name := range s.cas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServeHTTP serves the index at "/" and the published files.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == "/" {
+		s.serveIndex(w)
+		return
+	}
+	file := strings.TrimPrefix(r.URL.Path, "/")
+	// Longer suffixes first: "-chain.pem" also ends in ".pem" and "-delta.crl" in ".crl"
+	for _, suffix := range []string{chainSuffix, deltaCRLSuffix, certSuffix, pemSuffix, crlSuffix} {
+		name, ok := strings.CutSuffix(file, suffix)
+		if !ok {
+			continue
+		}
+		ca, ok := s.cas[name]
+		if !ok {
+			break
+		}
+		if err := s.serveFile(w, r, ca, suffix); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				http.Error(w, "not published yet", http.StatusNotFound)
+				return
+			}
+			// Details stay in the log: they name local files
+			s.logger().Error("failed to serve", "path", r.URL.Path, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// logger returns Logger, or the default logger.
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// serveFile serves the file of ca named by suffix.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, ca CA, suffix string) error {
+	switch suffix {
+	case certSuffix, pemSuffix:
+		cert, err := readCertificate(ca.PemPath)
+		if err != nil {
+			return err
+		}
+		if suffix == certSuffix {
+			serve(w, r, "application/pkix-cert", cert.Raw, cert.NotBefore, time.Time{})
+		} else {
+			serve(w, r, "application/x-pem-file", pemCert(cert), cert.NotBefore, time.Time{})
+		}
+	case chainSuffix:
+		chain, err := s.chain(ca)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		for _, cert := range chain {
+			buf.Write(pemCert(cert))
+		}
+		serve(w, r, "application/x-pem-file", buf.Bytes(), chain[0].NotBefore, time.Time{})
+	case crlSuffix, deltaCRLSuffix:
+		path := ca.CRLPath
+		if suffix == deltaCRLSuffix {
+			path = ca.DeltaCRL
+		}
+		der, rl, err := ReadCRL(path)
+		if err != nil {
+			return err
+		}
+		serve(w, r, "application/pkix-crl", der, rl.ThisUpdate, rl.NextUpdate)
+	}
+	return nil
+}
+
+// serve writes data with conditional request support. A CRL may be cached until its nextUpdate.
+func serve(w http.ResponseWriter, r *http.Request, contentType string, data []byte, modified, expires time.Time) {
+	w.Header().Set("Content-Type", contentType)
+	if !expires.IsZero() {
+		maxAge := int(time.Until(expires).Seconds())
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+		w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+	}
+	http.ServeContent(w, r, "", modified, bytes.NewReader(data))
+}
+
+// chain returns ca's certificate followed by its issuers among the published CAs, up to a self-signed root.
+func (s *Server) chain(ca CA) ([]*x509.Certificate, error) {
+	cert, err := readCertificate(ca.PemPath)
+	if err != nil {
+		return nil, err
+	}
+	var pool []*x509.Certificate
+	for _, other := range s.cas {
+		if c, err := readCertificate(other.PemPath); err == nil {
+			pool = append(pool, c)
+		}
+	}
+	chain := []*x509.Certificate{cert}
+	for len(chain) <= len(pool) {
+		last := chain[len(chain)-1]
+		if bytes.Equal(last.RawIssuer, last.RawSubject) && last.CheckSignatureFrom(last) == nil {
+			break
+		}
+		var parent *x509.Certificate
+		for _, c := range pool {
+			if bytes.Equal(c.RawSubject, last.RawIssuer) && last.CheckSignatureFrom(c) == nil {
+				parent = c
+				break
+			}
+		}
+		if parent == nil {
+			break // the rest of the chain is not published here
+		}
+		chain = append(chain, parent)
+	}
+	return chain, nil
+}
+
+// serveIndex lists the published files.
+func (s *Server) serveIndex(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, "<!DOCTYPE html>\n<html><head><title>PKI distribution point</title></head><body>\n<ul>\n")
+	for _, p := range s.Paths() {
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(p), html.EscapeString(p))
+	}
+	fmt.Fprint(w, "</ul>\n</body></html>\n")
+}
+
+// readCertificate reads the first certificate of a PEM file.
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in '%s'", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// ReadCRL reads a CRL file in PEM or DER form and returns its DER encoding.
+func ReadCRL(path string) ([]byte, *x509.RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		der = block.Bytes
+	}
+	rl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CRL '%s': %w", path, err)
+	}
+	return der, rl, nil
+}
+
+// pemCert returns the PEM encoding of cert.
+func pemCert(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
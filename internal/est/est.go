@@ -0,0 +1,287 @@
+// Package est serves a CA over Enrollment over Secure Transport (RFC 7030), the protocol devices and
+// their enrollment agents use to fetch the CA certificates and to request and renew certificates.
+//
+// Clients enroll with a TLS client certificate issued by one of the enrollment CAs, such as a
+// manufacturer's device identity CA, or with a username and shared secret over HTTP basic
+// authentication for bootstrapping. They renew with the certificate being renewed. Issuing is left
+// to the same Backend as the JSON API.
+package est
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"my-pki/internal/api"
+	"my-pki/internal/inventory"
+	"my-pki/internal/utils"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxBody bounds request bodies; a CSR fits many times over.
+const maxBody = 64 << 10
+
+// Secrets are the usernames and shared secrets that may enroll without a client certificate.
+type Secrets map[string]string
+
+// ReadSecrets reads a file of "username:secret" lines. Empty lines and lines starting with # are skipped.
+func ReadSecrets(path string) (Secrets, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read '%s': %w", path, err)
+	}
+	defer f.Close()
+	secrets := Secrets{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, secret, ok := strings.Cut(line, ":")
+		if !ok || user == "" || secret == "" {
+			return nil, fmt.Errorf("%s:%d: expected username:secret", path, n)
+		}
+		secrets[user] = secret
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read '%s': %w", path, err)
+	}
+	return secrets, nil
+}
+
+// Server answers EST requests with a Backend. An optional label in the path, as in
+// /.well-known/est/server-tls/simpleenroll, selects the issuance profile.
+type Server struct {
+	backend   api.Backend
+	access    *api.Config
+	limiter   *api.Limiter
+	enrollCAs *x509.CertPool
+	secrets   Secrets
+	mux       *http.ServeMux
+}
+
+// routes lists the operations, as shown by Routes.
+var routes = []string{
+	"GET /.well-known/est/cacerts",
+	"POST /.well-known/est/simpleenroll",
+	"POST /.well-known/est/simplereenroll",
+}
+
+// NewServer returns a server answering with backend. Clients authenticated by a certificate from
+// enrollCAs or by one of secrets may enroll in the profiles access grants them (any without access),
+// at the rate limiter allows.
+func NewServer(backend api.Backend, access *api.Config, limiter *api.Limiter, enrollCAs *x509.CertPool, secrets Secrets) *Server {
+	s := &Server{backend: backend, access: access, limiter: limiter, enrollCAs: enrollCAs, secrets: secrets, mux: http.NewServeMux()}
+	handlers := []http.HandlerFunc{s.cacerts, s.enroll, s.reenroll}
+	for i, route := range routes {
+		s.mux.HandleFunc(route, handlers[i])
+		method, path, _ := strings.Cut(route, " ")
+		s.mux.HandleFunc(method+" "+strings.Replace(path, "/est/", "/est/{label}/", 1), handlers[i])
+	}
+	return s
+}
+
+// Routes returns the method and path of every operation.
+func (s *Server) Routes() []string {
+	return routes
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) cacerts(w http.ResponseWriter, r *http.Request) {
+	writeCerts(w, s.backend.Chain())
+}
+
+func (s *Server) enroll(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="EST"`)
+		http.Error(w, "a client certificate from an enrollment CA or a shared secret is required", http.StatusUnauthorized)
+		return
+	}
+	p, err := s.access.Principal(client)
+	if err == nil {
+		err = s.limiter.Allow(p)
+	}
+	if err == nil {
+		err = p.Authorize(api.PermIssue, r.PathValue("label"))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	csr, err := readCSR(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.issue(w, p.Name, &api.IssueRequest{Profile: r.PathValue("label"), CSR: csr})
+}
+
+func (s *Server) reenroll(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "renewal requires the certificate being renewed as the client certificate", http.StatusUnauthorized)
+		return
+	}
+	current := r.TLS.PeerCertificates[0]
+	if err := current.CheckSignatureFrom(s.backend.Chain()[0]); err != nil {
+		http.Error(w, "the client certificate was not issued by this CA", http.StatusForbidden)
+		return
+	}
+	sum := sha256.Sum256(current.Raw)
+	rec, err := s.backend.Certificate(hex.EncodeToString(sum[:]))
+	if err != nil {
+		writeError(w, api.WithStatus(http.StatusForbidden, fmt.Errorf("the client certificate cannot be renewed: %w", err)))
+		return
+	}
+	if rec.Status == inventory.StatusRevoked {
+		http.Error(w, "the client certificate is revoked", http.StatusForbidden)
+		return
+	}
+	csrPEM, err := readCSR(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	block, _ := pem.Decode([]byte(csrPEM))
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		writeError(w, api.WithStatus(http.StatusBadRequest, fmt.Errorf("invalid certificate request: %w", err)))
+		return
+	}
+	// RFC 7030 §4.2.2: the names of the certificate being renewed are kept
+	if csr.Subject.String() != current.Subject.String() || !sameSANs(csr, current) {
+		http.Error(w, "the request's subject and SANs must be those of the certificate being renewed", http.StatusBadRequest)
+		return
+	}
+	profile := r.PathValue("label")
+	p, err := s.access.Renewer(current.Subject.String(), rec.Profile, profile)
+	if err == nil {
+		err = s.limiter.Allow(p)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if profile == "" {
+		profile = rec.Profile
+	}
+	s.issue(w, p.Name, &api.IssueRequest{Profile: profile, CSR: csrPEM})
+}
+
+func (s *Server) issue(w http.ResponseWriter, client string, req *api.IssueRequest) {
+	issued, err := s.backend.Issue(client, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	block, _ := pem.Decode([]byte(issued.Certificate))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeCerts(w, []*x509.Certificate{cert})
+}
+
+// authenticate names the client of an enrollment: the subject of a certificate issued by an
+// enrollment CA, else the username of a shared secret.
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && s.enrollCAs != nil {
+		intermediates := x509.NewCertPool()
+		for _, c := range r.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+		leaf := r.TLS.PeerCertificates[0]
+		opts := x509.VerifyOptions{Roots: s.enrollCAs, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+		if _, err := leaf.Verify(opts); err == nil {
+			return leaf.Subject.String(), true
+		}
+	}
+	user, secret, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	want, known := s.secrets[user]
+	// Compare even for an unknown user, so timing does not tell which usernames exist
+	match := subtle.ConstantTimeCompare([]byte(secret), []byte(want)) == 1
+	if !known || !match {
+		return "", false
+	}
+	return "est:" + user, true
+}
+
+// readCSR reads the base64-encoded PKCS#10 request of an enrollment and returns it as PEM. A PEM
+// body is accepted too.
+func readCSR(w http.ResponseWriter, r *http.Request) (string, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		return "", api.WithStatus(http.StatusBadRequest, fmt.Errorf("failed to read request: %w", err))
+	}
+	if block, _ := pem.Decode(body); block != nil {
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: block.Bytes})), nil
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil || len(der) == 0 {
+		return "", api.WithStatus(http.StatusBadRequest, errors.New("expected a base64-encoded PKCS#10 certificate request"))
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// sameSANs reports whether csr asks for the SANs of cert, in any order.
+func sameSANs(csr *x509.CertificateRequest, cert *x509.Certificate) bool {
+	requested := utils.SANsFromCSR(csr).Strings()
+	current := utils.SANs{DNSNames: cert.DNSNames, IPAddresses: cert.IPAddresses, EmailAddresses: cert.EmailAddresses, URIs: cert.URIs}.Strings()
+	slices.Sort(requested)
+	slices.Sort(current)
+	return slices.Equal(requested, current)
+}
+
+// writeCerts answers with certs as a base64-encoded certs-only PKCS#7 message.
+func writeCerts(w http.ResponseWriter, certs []*x509.Certificate) {
+	p7, err := certsOnly(certs)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	encoded := base64.StdEncoding.EncodeToString(p7)
+	var b strings.Builder
+	for len(encoded) > 64 {
+		b.WriteString(encoded[:64] + "\r\n")
+		encoded = encoded[64:]
+	}
+	b.WriteString(encoded + "\r\n")
+	w.Header().Set("Content-Type", "application/pkcs7-mime; smime-type=certs-only")
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	_, _ = io.WriteString(w, b.String())
+}
+
+// writeError answers with the status of err and its message as text, as RFC 7030 §4.2.3 allows.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var e *api.Error
+	if errors.As(err, &e) {
+		status = e.Status
+	} else if errors.Is(err, api.ErrNotFound) {
+		status = http.StatusNotFound
+	}
+	var limit *api.LimitError
+	if errors.As(err, &limit) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limit.RetryAfter.Seconds()))))
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,153 @@
+// Package fido2 derives secrets from a FIDO2 security key's hmac-secret extension through the
+// libfido2 tools (fido2-token, fido2-cred, fido2-assert), so a share can be sealed to a token.
+// The token computes HMAC(credential secret, salt) internally; the secret never leaves it, and
+// each derivation needs a touch and the token's PIN.
+package fido2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultRP is the relying party identifier of the credentials created for shares.
+const DefaultRP = "gosec"
+
+// SecretSize is the length of the hmac-secret output for a single salt.
+const SecretSize = 32
+
+// Token identifies the security key to use.
+type Token struct {
+	Device  string // device path as listed by fido2-token -L; the only connected key if empty
+	ToolDir string // directory holding the libfido2 tools; PATH if empty
+}
+
+// Devices lists the paths of the connected FIDO2 devices.
+func (t *Token) Devices() ([]string, error) {
+	out, err := t.run("fido2-token", nil, "-L")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list FIDO2 devices: %w", err)
+	}
+	var devices []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if path, _, ok := strings.Cut(strings.TrimSpace(line), ": "); ok {
+			devices = append(devices, path)
+		}
+	}
+	return devices, nil
+}
+
+// MakeCredential creates a non-resident credential with the hmac-secret extension for user and
+// returns its identifier, which is needed to derive secrets later.
+func (t *Token) MakeCredential(rp, user string) ([]byte, error) {
+	device, err := t.device()
+	if err != nil {
+		return nil, err
+	}
+	userID := make([]byte, 32)
+	if _, err := rand.Read(userID); err != nil {
+		return nil, err
+	}
+	input := strings.Join([]string{clientDataHash(), rp, user, base64.StdEncoding.EncodeToString(userID)}, "\n") + "\n"
+	out, err := t.run("fido2-cred", []byte(input), "-M", "-h", "-v", device, "es256")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create FIDO2 credential: %w", err)
+	}
+	// client data hash, relying party, format, authenticator data, credential id, signature, ...
+	lines := strings.Split(string(out), "\n")
+	if len(lines) < 5 {
+		return nil, errors.New("unexpected output from fido2-cred")
+	}
+	credID, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[4]))
+	if err != nil || len(credID) == 0 {
+		return nil, errors.New("unexpected credential id from fido2-cred")
+	}
+	return credID, nil
+}
+
+// HMACSecret has the token derive the hmac-secret of credID for salt, with user verification.
+func (t *Token) HMACSecret(rp string, credID, salt []byte) ([]byte, error) {
+	if len(salt) != SecretSize {
+		return nil, fmt.Errorf("hmac-secret salt must be %d bytes", SecretSize)
+	}
+	device, err := t.device()
+	if err != nil {
+		return nil, err
+	}
+	input := strings.Join([]string{
+		clientDataHash(), rp,
+		base64.StdEncoding.EncodeToString(credID),
+		base64.StdEncoding.EncodeToString(salt),
+	}, "\n") + "\n"
+	out, err := t.run("fido2-assert", []byte(input), "-G", "-h", "-v", device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get FIDO2 assertion (is this the right token?): %w", err)
+	}
+	// The hmac-secret is the last line, after the assertion itself
+	lines := strings.Fields(string(out))
+	if len(lines) < 5 {
+		return nil, errors.New("unexpected output from fido2-assert")
+	}
+	secret, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil || len(secret) != SecretSize {
+		return nil, errors.New("the token returned no hmac-secret; does it support the extension?")
+	}
+	return secret, nil
+}
+
+// device returns the configured device, or the only connected one.
+func (t *Token) device() (string, error) {
+	if t.Device != "" {
+		return t.Device, nil
+	}
+	devices, err := t.Devices()
+	if err != nil {
+		return "", err
+	}
+	switch len(devices) {
+	case 0:
+		return "", errors.New("no FIDO2 token found; insert the custodian's token")
+	case 1:
+		return devices[0], nil
+	default:
+		return "", fmt.Errorf("%d FIDO2 tokens connected (%s); leave only the custodian's", len(devices), strings.Join(devices, ", "))
+	}
+}
+
+// clientDataHash returns a random client data hash: assertions are not verified by a server here.
+func clientDataHash() string {
+	cdh := make([]byte, 32)
+	_, _ = rand.Read(cdh)
+	return base64.StdEncoding.EncodeToString(cdh)
+}
+
+// run executes a libfido2 tool with stdin as input and returns stdout. The tools ask for the PIN
+// on the terminal themselves.
+func (t *Token) run(tool string, stdin []byte, args ...string) ([]byte, error) {
+	path := tool
+	if t.ToolDir != "" {
+		path = filepath.Join(t.ToolDir, tool)
+	}
+	path, err := exec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found; install the libfido2 tools: %w", tool, err)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return nil, fmt.Errorf("%s: %w", tool, err)
+		}
+		return nil, fmt.Errorf("%s: %w: %s", tool, err, msg)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,324 @@
+// Package grpcca serves a CA as the gRPC service of ca.proto (SignCertificate, RevokeCertificate,
+// GetCRL, ListCertificates) and is a Go client of it, for internal consumers that want typed,
+// low-latency calls rather than JSON.
+//
+// The messages and service stubs are generated by protoc-gen-go and protoc-gen-go-grpc, and calls are
+// served by grpc-go over the HTTP/2 of net/http, so the service shares the TLS settings of the API.
+package grpcca
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ca.proto
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"my-pki/internal/api"
+	"my-pki/internal/inventory"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// statusError converts an error of the backend to a gRPC status.
+func statusError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code := codes.Internal
+	var e *api.Error
+	if errors.As(err, &e) {
+		switch e.Status {
+		case http.StatusBadRequest:
+			code = codes.InvalidArgument
+		case http.StatusUnauthorized:
+			code = codes.Unauthenticated
+		case http.StatusForbidden:
+			code = codes.PermissionDenied
+		case http.StatusNotFound:
+			code = codes.NotFound
+		case http.StatusConflict:
+			code = codes.FailedPrecondition
+		case http.StatusTooManyRequests:
+			code = codes.ResourceExhausted
+		}
+	} else if errors.Is(err, api.ErrNotFound) {
+		code = codes.NotFound
+	}
+	return status.Error(code, err.Error())
+}
+
+// Backend is the CA behind the service: that of the JSON API, which also hands out its latest CRL.
+type Backend interface {
+	api.Backend
+	// CRL returns the latest full or delta CRL, DER encoded.
+	CRL(delta bool) ([]byte, error)
+}
+
+// Server answers the calls of the service with a Backend.
+type Server struct {
+	UnimplementedCAServer
+	backend Backend
+	access  *api.Config
+	limiter *api.Limiter
+	grpc    *grpc.Server
+}
+
+// NewServer returns a server answering with backend. access grants roles to clients and limiter
+// limits their rate as for the JSON API; both may be nil.
+func NewServer(backend Backend, access *api.Config, limiter *api.Limiter) *Server {
+	s := &Server{backend: backend, access: access, limiter: limiter}
+	s.grpc = grpc.NewServer(grpc.UnaryInterceptor(s.intercept))
+	RegisterCAServer(s.grpc, s)
+	return s
+}
+
+// Methods returns the full names of the service's methods.
+func (s *Server) Methods() []string {
+	var names []string
+	for _, m := range CA_ServiceDesc.Methods {
+		names = append(names, "/"+CA_ServiceDesc.ServiceName+"/"+m.MethodName)
+	}
+	return names
+}
+
+// authKey is the context key of the authResult of a call.
+type authKey struct{}
+
+// authResult is the client ServeHTTP authenticated, or why it refused it.
+type authResult struct {
+	principal *api.Principal
+	err       error
+}
+
+// ServeHTTP authenticates and rate limits the client of a call as the JSON API does, then hands the
+// call to grpc-go with the outcome in its context.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "this is a gRPC endpoint", http.StatusUnsupportedMediaType)
+		return
+	}
+	principal, err := s.access.Authenticate(r)
+	if err == nil {
+		err = s.limiter.Allow(principal)
+	}
+	s.grpc.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authKey{}, &authResult{principal, err})))
+}
+
+// intercept ends the calls of clients ServeHTTP refused, and converts the errors of the others.
+func (s *Server) intercept(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	auth, _ := ctx.Value(authKey{}).(*authResult)
+	if auth == nil {
+		return nil, status.Error(codes.Unauthenticated, "the call was not authenticated")
+	}
+	if auth.err != nil {
+		return nil, statusError(auth.err)
+	}
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return resp, nil
+}
+
+// principalOf returns the client of a call.
+func principalOf(ctx context.Context) *api.Principal {
+	return ctx.Value(authKey{}).(*authResult).principal
+}
+
+// SignCertificate issues a certificate.
+func (s *Server) SignCertificate(ctx context.Context, req *SignCertificateRequest) (*SignCertificateResponse, error) {
+	principal := principalOf(ctx)
+	if err := principal.Authorize(api.PermIssue, req.Profile); err != nil {
+		return nil, err
+	}
+	if len(req.Csr) == 0 && req.CommonName == "" && len(req.Sans) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "a csr, a common_name or sans are required")
+	}
+	if req.Days < 0 {
+		return nil, status.Error(codes.InvalidArgument, "days must not be negative")
+	}
+	issueReq := &api.IssueRequest{Profile: req.Profile, SANs: req.Sans, Days: int(req.Days)}
+	if req.CommonName != "" || len(req.Organization) > 0 || len(req.OrganizationalUnit) > 0 || len(req.Country) > 0 {
+		issueReq.Subject = &api.Subject{
+			CommonName: req.CommonName, Organization: req.Organization, OrganizationalUnit: req.OrganizationalUnit, Country: req.Country,
+		}
+	}
+	if len(req.Csr) > 0 {
+		issueReq.CSR = string(req.Csr)
+		if !bytes.HasPrefix(bytes.TrimSpace(req.Csr), []byte("-----")) {
+			issueReq.CSR = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: req.Csr}))
+		}
+	}
+	issued, err := s.backend.Issue(principal.Name, issueReq)
+	if err != nil {
+		return nil, err
+	}
+	resp := &SignCertificateResponse{
+		Serial: issued.Serial, Sha256: issued.SHA256, Profile: issued.Profile,
+		NotBefore: issued.NotBefore.Unix(), NotAfter: issued.NotAfter.Unix(),
+	}
+	if block, _ := pem.Decode([]byte(issued.Certificate)); block != nil {
+		resp.Certificate = block.Bytes
+	}
+	for rest := []byte(issued.Chain); ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		resp.Chain = append(resp.Chain, block.Bytes)
+	}
+	if block, _ := pem.Decode([]byte(issued.PrivateKey)); block != nil {
+		resp.PrivateKey = block.Bytes
+	}
+	return resp, nil
+}
+
+// RevokeCertificate revokes a certificate and returns its record.
+func (s *Server) RevokeCertificate(ctx context.Context, req *RevokeCertificateRequest) (*Certificate, error) {
+	principal := principalOf(ctx)
+	if err := principal.Authorize(api.PermRevoke, ""); err != nil {
+		return nil, err
+	}
+	reason := inventory.ReasonUnspecified
+	if req.Reason != "" {
+		var err error
+		if reason, err = inventory.ParseReason(req.Reason); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	rec, err := s.backend.Revoke(principal.Name, req.Id, reason)
+	if err != nil {
+		return nil, err
+	}
+	return certificateOf(rec, false), nil
+}
+
+// GetCRL returns the CA's latest CRL, which every authenticated client may read.
+func (s *Server) GetCRL(_ context.Context, req *GetCRLRequest) (*GetCRLResponse, error) {
+	crl, err := s.backend.CRL(req.Delta)
+	if err != nil {
+		return nil, err
+	}
+	return &GetCRLResponse{Crl: crl}, nil
+}
+
+// ListCertificates searches the inventory.
+func (s *Server) ListCertificates(ctx context.Context, req *ListCertificatesRequest) (*ListCertificatesResponse, error) {
+	if err := principalOf(ctx).Authorize(api.PermRead, ""); err != nil {
+		return nil, err
+	}
+	if req.Status != "" && req.Status != inventory.StatusValid && req.Status != inventory.StatusRevoked {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid status '%s' (expected %s or %s)", req.Status, inventory.StatusValid, inventory.StatusRevoked)
+	}
+	q := inventory.Query{SAN: req.San, CN: req.Cn, Subject: req.Subject, IssuerSHA256: req.Issuer, Status: req.Status}
+	if req.ExpiresBefore != 0 {
+		q.ExpiresBefore = time.Unix(req.ExpiresBefore, 0)
+	}
+	if req.ExpiresAfter != 0 {
+		q.ExpiresAfter = time.Unix(req.ExpiresAfter, 0)
+	}
+	recs, err := s.backend.Certificates(q)
+	if err != nil {
+		return nil, err
+	}
+	resp := &ListCertificatesResponse{}
+	for _, rec := range recs {
+		resp.Certificates = append(resp.Certificates, certificateOf(rec, req.IncludeDer))
+	}
+	return resp, nil
+}
+
+// certificateOf converts an inventory record, with its certificate if withDER is set.
+func certificateOf(rec *inventory.CertRecord, withDER bool) *Certificate {
+	c := &Certificate{
+		Sha256: rec.SHA256, Serial: rec.Serial, Subject: rec.Subject, IssuerSha256: rec.IssuerSHA256,
+		NotBefore: rec.NotBefore.Unix(), NotAfter: rec.NotAfter.Unix(), IsCa: rec.IsCA, Status: rec.Status, Profile: rec.Profile,
+	}
+	if rec.RevokedAt != nil {
+		c.RevokedAt = rec.RevokedAt.Unix()
+		c.RevocationReason = inventory.ReasonName(rec.RevocationReason)
+	}
+	if withDER {
+		if block, _ := pem.Decode([]byte(rec.PEM)); block != nil {
+			c.Der = block.Bytes
+		}
+	}
+	return c
+}
+
+// Client calls the service of a CA. Errors of calls are gRPC statuses (see status.Code).
+type Client struct {
+	CAClient
+	Token string // API token sent as a bearer token, when set
+	conn  *grpc.ClientConn
+}
+
+// NewClient returns a client of the service at serverURL (e.g. https://pki.example.com:9443) that
+// authenticates with the certificate in certFile and trusts servers whose certificates were issued
+// by a CA in caFile. Without certFile it presents no certificate; set Token to authenticate with an
+// API token instead.
+func NewClient(serverURL, certFile, keyFile, caFile string) (*Client, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid server URL '%s' (expected https://HOST[:PORT])", serverURL)
+	}
+	target := u.Host
+	if u.Port() == "" {
+		target = net.JoinHostPort(u.Hostname(), "443")
+	}
+	var certs []tls.Certificate
+	if certFile != "" {
+		pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		certs = append(certs, pair)
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read '%s': %w", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in '%s'", caFile)
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: certs, RootCAs: pool}
+	c := &Client{}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)), grpc.WithPerRPCCredentials(bearer{c}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to '%s': %w", serverURL, err)
+	}
+	c.CAClient, c.conn = NewCAClient(conn), conn
+	return c, nil
+}
+
+// Close closes the connection of the client.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// bearer sends the Token of a client, when set, with each call.
+type bearer struct {
+	c *Client
+}
+
+func (b bearer) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	if b.c.Token == "" {
+		return nil, nil
+	}
+	return map[string]string{"authorization": "Bearer " + b.c.Token}, nil
+}
+
+func (bearer) RequireTransportSecurity() bool { return true }
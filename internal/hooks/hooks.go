@@ -0,0 +1,89 @@
+// Package hooks runs the pre-issue and post-issue commands configured for a CA profile.
+//
+// Each command is run through the system shell with the issuance request, as JSON, on stdin.
+// A failing pre-issue hook vetoes the issuance; post-issue hooks run once the certificate exists.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Stages passed to the hooks.
+const (
+	StagePreIssue  = "pre-issue"
+	StagePostIssue = "post-issue"
+)
+
+// Timeout bounds how long a single hook may run.
+const Timeout = 5 * time.Minute
+
+// Request is the issuance context passed to hooks on stdin.
+type Request struct {
+	Stage     string    `json:"stage"`
+	Command   string    `json:"command"` // CLI command performing the issuance, e.g. "sign"
+	Profile   string    `json:"profile"`
+	CAPem     string    `json:"ca_pem"`
+	CASubject string    `json:"ca_subject"`
+	Subject   string    `json:"subject"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	IPs       []string  `json:"ip_addresses,omitempty"`
+	Emails    []string  `json:"email_addresses,omitempty"`
+	URIs      []string  `json:"uris,omitempty"`
+	Days      int       `json:"days"`
+	Time      time.Time `json:"time"`
+	// Set for post-issue hooks only
+	Serial  string `json:"serial,omitempty"` // hex
+	SHA256  string `json:"sha256,omitempty"`
+	CertPEM string `json:"cert_pem,omitempty"`
+	CertOut string `json:"cert_out,omitempty"`
+}
+
+// Run executes commands in order with req on stdin, stopping at the first failure.
+// Hook output is passed through to stderr.
+func Run(commands []string, req *Request) error {
+	if len(commands) == 0 {
+		return nil
+	}
+	input, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode hook request: %w", err)
+	}
+	for _, command := range commands {
+		if strings.TrimSpace(command) == "" {
+			continue
+		}
+		if err := run(command, input); err != nil {
+			return fmt.Errorf("%s hook '%s' failed: %w", req.Stage, command, err)
+		}
+	}
+	return nil
+}
+
+func run(command string, input []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out after %s", Timeout)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package inventory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// CombineRequest is a request to combine the shares of a CA's key, which a delay policy only
+// allows once the delay is over, until it expires. It can be cancelled in the meantime.
+type CombineRequest struct {
+	ID          string     `json:"id"`
+	RequestedAt time.Time  `json:"requested_at"`
+	NotBefore   time.Time  `json:"not_before"`
+	Expires     time.Time  `json:"expires"`
+	Operator    string     `json:"operator,omitempty"`
+	Host        string     `json:"host,omitempty"`
+	Command     string     `json:"command,omitempty"`
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
+	CancelledBy string     `json:"cancelled_by,omitempty"`
+	Note        string     `json:"note,omitempty"` // reason for the cancellation
+}
+
+// RequestCombine files a new combination request for the CA, replacing any previous one.
+func (ca *CARecord) RequestCombine(now time.Time, delay, window time.Duration, operator, host, command string) *CombineRequest {
+	id := make([]byte, 8)
+	rand.Read(id)
+	now = now.UTC()
+	r := &CombineRequest{
+		ID:          hex.EncodeToString(id),
+		RequestedAt: now,
+		NotBefore:   now.Add(delay),
+		Expires:     now.Add(delay + window),
+		Operator:    operator,
+		Host:        host,
+		Command:     command,
+	}
+	ca.CombineRequest = r
+	return r
+}
+
+// Pending reports whether the request is still in its delay at now.
+func (r *CombineRequest) Pending(now time.Time) bool {
+	return r.CancelledAt == nil && now.Before(r.NotBefore)
+}
+
+// Usable reports whether the delay of the request is over at now and it has not expired.
+func (r *CombineRequest) Usable(now time.Time) bool {
+	return r.CancelledAt == nil && !now.Before(r.NotBefore) && now.Before(r.Expires)
+}
+
+// Cancel voids the request.
+func (r *CombineRequest) Cancel(now time.Time, by, note string) {
+	at := now.UTC()
+	r.CancelledAt, r.CancelledBy, r.Note = &at, by, note
+}
@@ -0,0 +1,126 @@
+package inventory
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Custody actions recorded in a CA's custody history.
+const (
+	CustodySplit    = "split"
+	CustodyAssign   = "assign"
+	CustodyTransfer = "transfer"
+)
+
+// Custody records who holds each share of the current split of a CA's key.
+type Custody struct {
+	KeyID     string         `json:"key_id"`
+	SplitID   string         `json:"split_id,omitempty"`
+	Threshold int            `json:"threshold"`
+	Holders   []*ShareHolder `json:"holders"` // indexed by share index - 1
+	History   []CustodyEvent `json:"history,omitempty"`
+}
+
+// ShareHolder is the custodian of one share; Custodian is empty while the share is unassigned.
+type ShareHolder struct {
+	Index     int        `json:"index"`
+	Custodian string     `json:"custodian,omitempty"`
+	Contact   string     `json:"contact,omitempty"`
+	Since     *time.Time `json:"since,omitempty"`
+}
+
+// CustodyEvent is one change of custody, kept so past holders can be traced.
+type CustodyEvent struct {
+	At     time.Time `json:"at"`
+	Action string    `json:"action"`
+	Index  int       `json:"index,omitempty"`
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to,omitempty"`
+	Note   string    `json:"note,omitempty"`
+}
+
+// RecordSplit starts the custody of a new split of the CA's key with total unassigned shares,
+// replacing the holders of any previous split. Recording the current split again changes nothing.
+func (ca *CARecord) RecordSplit(keyID, splitID string, total, threshold int, at time.Time) *Custody {
+	c := ca.Custody
+	if c != nil && c.KeyID == keyID && c.SplitID == splitID {
+		return c
+	}
+	if c == nil {
+		c = &Custody{}
+		ca.Custody = c
+	}
+	c.KeyID, c.SplitID, c.Threshold = keyID, splitID, threshold
+	c.Holders = make([]*ShareHolder, total)
+	for i := range c.Holders {
+		c.Holders[i] = &ShareHolder{Index: i + 1}
+	}
+	note := fmt.Sprintf("%d-of-%d split of key %s", threshold, total, keyID)
+	if splitID != "" {
+		note += " (split " + splitID + ")"
+	}
+	c.History = append(c.History, CustodyEvent{At: at.UTC(), Action: CustodySplit, Note: note})
+	return c
+}
+
+// Holder returns the holder of share index.
+func (c *Custody) Holder(index int) (*ShareHolder, error) {
+	if index < 1 || index > len(c.Holders) {
+		return nil, fmt.Errorf("share index %d out of range 1..%d", index, len(c.Holders))
+	}
+	return c.Holders[index-1], nil
+}
+
+// Assign records custodian as the holder of an unassigned share, or corrects the contact of its
+// current holder. Handing a share to someone else is a Transfer.
+func (c *Custody) Assign(index int, custodian, contact, note string, at time.Time) error {
+	h, err := c.Holder(index)
+	if err != nil {
+		return err
+	}
+	if h.Custodian != "" && h.Custodian != custodian {
+		return fmt.Errorf("share #%d is held by %s; record a handover with transfer", index, h.Custodian)
+	}
+	if h.Custodian == custodian && h.Contact == contact {
+		return nil
+	}
+	if h.Custodian == "" {
+		since := at.UTC()
+		h.Since = &since
+	}
+	h.Custodian, h.Contact = custodian, contact
+	c.History = append(c.History, CustodyEvent{At: at.UTC(), Action: CustodyAssign, Index: index, To: custodian, Note: note})
+	return nil
+}
+
+// Transfer records the handover of share index from its current holder to custodian.
+func (c *Custody) Transfer(index int, custodian, contact, note string, at time.Time) error {
+	h, err := c.Holder(index)
+	if err != nil {
+		return err
+	}
+	if h.Custodian == "" {
+		return fmt.Errorf("share #%d has no recorded holder; use assign", index)
+	}
+	if h.Custodian == custodian {
+		return fmt.Errorf("share #%d is already held by %s", index, custodian)
+	}
+	since := at.UTC()
+	c.History = append(c.History, CustodyEvent{At: since, Action: CustodyTransfer, Index: index, From: h.Custodian, To: custodian, Note: note})
+	h.Custodian, h.Contact, h.Since = custodian, contact, &since
+	return nil
+}
+
+// Quorum describes who must be present to combine the key, e.g. "any 2 of: Alice (#1), Bob (#2)".
+func (c *Custody) Quorum() string {
+	var names []string
+	for _, h := range c.Holders {
+		name := h.Custodian
+		if name == "" {
+			name = "unassigned"
+		}
+		names = append(names, fmt.Sprintf("%s (#%d)", name, h.Index))
+	}
+	return fmt.Sprintf("any %d of: %s", c.Threshold, strings.Join(names, ", "))
+}
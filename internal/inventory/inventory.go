@@ -0,0 +1,447 @@
+// Package inventory keeps a record of every CA and certificate issued by this installation,
+// together with their status (active, compromised, revoked), in a SQLite database or a JSON file.
+//
+// The issuance logs remain the tamper-evident record of what each CA signed; the inventory is
+// the mutable index used to answer "what did this CA issue and what is its status".
+package inventory
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPath is the inventory used when none is given, a SQLite database.
+const DefaultPath = "gosec-inventory.db"
+
+// LegacyPath is the JSON inventory earlier versions used when none was given.
+const LegacyPath = "gosec-inventory.json"
+
+// Default returns DefaultPath, or LegacyPath if only that exists, so that installations keep their
+// inventory until they convert it.
+func Default() string {
+	return withLegacy(DefaultPath, LegacyPath)
+}
+
+// withLegacy returns path, or legacy if path does not exist and legacy does.
+func withLegacy(path, legacy string) string {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(legacy); err == nil {
+			return legacy
+		}
+	}
+	return path
+}
+
+// sqliteMagic starts every SQLite database file.
+const sqliteMagic = "SQLite format 3\x00"
+
+// IsSQLite reports whether the inventory at path is a SQLite database: an existing file is one if
+// it starts like one, a new one unless its name ends in .json.
+func IsSQLite(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return !strings.EqualFold(filepath.Ext(path), ".json")
+	}
+	defer f.Close()
+	head := make([]byte, len(sqliteMagic))
+	_, err = io.ReadFull(f, head)
+	return err == nil && string(head) == sqliteMagic
+}
+
+// Certificate statuses.
+const (
+	StatusValid   = "valid"
+	StatusRevoked = "revoked"
+)
+
+// CA statuses.
+const (
+	CAActive      = "active"
+	CACompromised = "compromised"
+)
+
+// Revocation reason codes (RFC 5280 section 5.3.1).
+const (
+	ReasonUnspecified          = 0
+	ReasonKeyCompromise        = 1
+	ReasonCACompromise         = 2
+	ReasonAffiliationChanged   = 3
+	ReasonSuperseded           = 4
+	ReasonCessationOfOperation = 5
+	ReasonCertificateHold      = 6 // suspension, lifted with removeFromCRL
+	ReasonRemoveFromCRL        = 8 // only in delta CRLs, for a certificate released from hold
+	ReasonPrivilegeWithdrawn   = 9
+	ReasonAACompromise         = 10
+)
+
+// reasonNames are the RFC 5280 names of the supported revocation reasons.
+var reasonNames = map[int]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonRemoveFromCRL:        "removeFromCRL",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "aACompromise",
+}
+
+// ReasonName returns the RFC 5280 name of a revocation reason code.
+func ReasonName(code int) string {
+	if name, ok := reasonNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("reason %d", code)
+}
+
+// ParseReason accepts a revocation reason by RFC 5280 name (case-insensitive) or code. removeFromCRL
+// is not a reason to revoke: it is written to delta CRLs when a certificate on hold is released.
+func ParseReason(s string) (int, error) {
+	for code, name := range reasonNames {
+		if strings.EqualFold(s, name) || s == strconv.Itoa(code) {
+			if code == ReasonRemoveFromCRL {
+				return 0, errors.New("removeFromCRL is not a revocation reason; release a certificate on hold with unhold")
+			}
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason '%s' (expected one of %s)", s, strings.Join(ReasonNames(), ", "))
+}
+
+// ReasonNames lists the reasons ParseReason accepts, by code.
+func ReasonNames() []string {
+	var names []string
+	for _, code := range slices.Sorted(maps.Keys(reasonNames)) {
+		if code != ReasonRemoveFromCRL {
+			names = append(names, reasonNames[code])
+		}
+	}
+	return names
+}
+
+// CertRecord describes one issued certificate.
+type CertRecord struct {
+	SHA256           string     `json:"sha256"`
+	Serial           string     `json:"serial"` // hex
+	Subject          string     `json:"subject"`
+	IssuerSHA256     string     `json:"issuer_sha256"`
+	NotBefore        time.Time  `json:"not_before"`
+	NotAfter         time.Time  `json:"not_after"`
+	IssuedAt         *time.Time `json:"issued_at,omitempty"` // when this installation signed it; unset for imports
+	IsCA             bool       `json:"is_ca,omitempty"`
+	Status           string     `json:"status"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason int        `json:"revocation_reason,omitempty"`
+	ReleasedAt       *time.Time `json:"released_at,omitempty"` // when a certificateHold was last lifted
+	Operator         string     `json:"operator,omitempty"`    // who signed a peer-reviewed certificate
+	Reviewer         string     `json:"reviewer,omitempty"`    // who reviewed it
+	Profile          string     `json:"profile,omitempty"`     // issuance profile, e.g. server-tls
+	Path             string     `json:"path,omitempty"`        // absolute path the certificate was written to
+	PEM              string     `json:"pem,omitempty"`
+}
+
+// CARecord describes a CA that has issued certificates.
+type CARecord struct {
+	SHA256        string     `json:"sha256"`
+	Name          string     `json:"name"` // subject common name
+	PemPath       string     `json:"pem_path,omitempty"`
+	Status        string     `json:"status"`
+	CompromisedAt *time.Time `json:"compromised_at,omitempty"`
+	Note          string     `json:"note,omitempty"`
+	CRLNumber     int64      `json:"crl_number,omitempty"`      // number of the last CRL issued
+	BaseCRLNumber int64      `json:"base_crl_number,omitempty"` // number of the last full CRL, the base of delta CRLs
+	BaseCRLAt     *time.Time `json:"base_crl_at,omitempty"`     // thisUpdate of that full CRL
+	Custody       *Custody   `json:"custody,omitempty"`         // who holds the shares of the CA's key
+	// CombineRequest is the latest request to combine the CA's shares under a delay policy
+	CombineRequest *CombineRequest `json:"combine_request,omitempty"`
+}
+
+// NextCRLNumber returns the number for the CA's next CRL. Full and delta CRLs share one sequence (RFC 5280 section 5.2.3).
+func (ca *CARecord) NextCRLNumber() int64 {
+	return ca.CRLNumber + 1
+}
+
+// RecordCRL consumes the next CRL number once a CRL has been issued. A full CRL becomes the base of later delta CRLs.
+func (ca *CARecord) RecordCRL(thisUpdate time.Time, delta bool) {
+	ca.CRLNumber++
+	if !delta {
+		at := thisUpdate.UTC()
+		ca.BaseCRLNumber = ca.CRLNumber
+		ca.BaseCRLAt = &at
+	}
+}
+
+// DB is an in-memory view of an inventory file.
+type DB struct {
+	path         string
+	sqlite       bool
+	saved        map[string]string // SQLite rows as last read or written, by table and SHA-256
+	CAs          []*CARecord       `json:"cas"`
+	Certificates []*CertRecord     `json:"certificates"`
+}
+
+// Open reads the inventory at path, a SQLite database or a JSON file (see IsSQLite). A missing file
+// yields an empty inventory.
+func Open(path string) (*DB, error) {
+	db := &DB{path: path, sqlite: IsSQLite(path)}
+	if db.sqlite {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return db, nil
+		}
+		if err := db.loadSQLite(); err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read inventory '%s': %w", path, err)
+	}
+	if err := json.Unmarshal(data, db); err != nil {
+		return nil, fmt.Errorf("invalid inventory '%s': %w", path, err)
+	}
+	return db, nil
+}
+
+// Update opens the inventory at path, calls fn with it and saves it unless fn fails, all under an
+// exclusive lock of path.lock. Other processes updating the inventory wait, so what fn checks still
+// holds when its changes are saved.
+func Update(path string, fn func(db *DB) error) error {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to lock inventory '%s': %w", path, err)
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("failed to lock inventory '%s': %w", path, err)
+	}
+	defer unlockFile(f)
+	db, err := Open(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(db); err != nil {
+		return err
+	}
+	return db.save()
+}
+
+// SaveAs writes the inventory to path instead, in the format IsSQLite picks for it, and saves it
+// there from then on.
+func (db *DB) SaveAs(path string) error {
+	db.path, db.sqlite, db.saved = path, IsSQLite(path), nil
+	return db.save()
+}
+
+// save writes the inventory back to the file it was opened from, replacing its contents atomically.
+// Callers go through Update so concurrent writers never overwrite each other's changes.
+func (db *DB) save() error {
+	if db.sqlite {
+		return db.saveSQLite()
+	}
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode inventory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), ".inventory-*")
+	if err != nil {
+		return fmt.Errorf("failed to write inventory '%s': %w", db.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write inventory '%s': %w", db.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write inventory '%s': %w", db.path, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write inventory '%s': %w", db.path, err)
+	}
+	if err := os.Rename(tmp.Name(), db.path); err != nil {
+		return fmt.Errorf("failed to write inventory '%s': %w", db.path, err)
+	}
+	return nil
+}
+
+// Fingerprint returns the hex SHA-256 of a certificate's DER encoding.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// AddCA registers caCert, or updates the PEM path of an existing record, and returns its record.
+func (db *DB) AddCA(caCert *x509.Certificate, pemPath string) *CARecord {
+	fp := Fingerprint(caCert)
+	for _, ca := range db.CAs {
+		if ca.SHA256 == fp {
+			if pemPath != "" {
+				ca.PemPath = pemPath
+			}
+			return ca
+		}
+	}
+	ca := &CARecord{SHA256: fp, Name: caCert.Subject.CommonName, PemPath: pemPath, Status: CAActive}
+	db.CAs = append(db.CAs, ca)
+	return ca
+}
+
+// AddCertificate records cert as issued by issuer. Recording the same certificate again is a no-op.
+func (db *DB) AddCertificate(cert, issuer *x509.Certificate) *CertRecord {
+	fp := Fingerprint(cert)
+	if rec := db.Certificate(fp); rec != nil {
+		return rec
+	}
+	rec := &CertRecord{
+		SHA256:       fp,
+		Serial:       hex.EncodeToString(cert.SerialNumber.Bytes()),
+		Subject:      cert.Subject.String(),
+		IssuerSHA256: Fingerprint(issuer),
+		NotBefore:    cert.NotBefore.UTC(),
+		NotAfter:     cert.NotAfter.UTC(),
+		IsCA:         cert.IsCA,
+		Status:       StatusValid,
+		PEM:          string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+	}
+	db.Certificates = append(db.Certificates, rec)
+	return rec
+}
+
+// SerialCollision returns the record of another certificate that issuer already issued with the
+// serial number of cert, or nil. Serials must be unique per issuer (RFC 5280 section 4.1.2.2).
+func (db *DB) SerialCollision(cert, issuer *x509.Certificate) *CertRecord {
+	fp, issuerFP := Fingerprint(cert), Fingerprint(issuer)
+	serial := hex.EncodeToString(cert.SerialNumber.Bytes())
+	for _, rec := range db.Certificates {
+		if rec.Serial == serial && rec.IssuerSHA256 == issuerFP && rec.SHA256 != fp {
+			return rec
+		}
+	}
+	return nil
+}
+
+// Certificate returns the record with the given fingerprint, or nil.
+func (db *DB) Certificate(sha string) *CertRecord {
+	for _, rec := range db.Certificates {
+		if rec.SHA256 == sha {
+			return rec
+		}
+	}
+	return nil
+}
+
+// BySerial returns the certificates with the given hex serial number, which may use upper case,
+// colons and leading zeros. Serials are only unique per issuer, so there may be several.
+func (db *DB) BySerial(serial string) ([]*CertRecord, error) {
+	clean := strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(serial))
+	n, ok := new(big.Int).SetString(clean, 16)
+	if !ok || n.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid serial number '%s' (expected hex)", serial)
+	}
+	want := hex.EncodeToString(n.Bytes())
+	var out []*CertRecord
+	for _, rec := range db.Certificates {
+		if rec.Serial == want {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// FindCA resolves ref, which may be a CA name, a (prefix of a) SHA-256 fingerprint or a PEM path.
+func (db *DB) FindCA(ref string) (*CARecord, error) {
+	var matches []*CARecord
+	for _, ca := range db.CAs {
+		if ca.Name == ref || ca.PemPath == ref || (len(ref) >= 8 && strings.HasPrefix(ca.SHA256, strings.ToLower(ref))) {
+			matches = append(matches, ca)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no CA '%s' in inventory '%s'", ref, db.path)
+	case 1:
+		return matches[0], nil
+	}
+	var fps []string
+	for _, ca := range matches {
+		fps = append(fps, ca.SHA256[:16])
+	}
+	return nil, fmt.Errorf("'%s' matches %d CAs (%s); use a fingerprint instead", ref, len(matches), strings.Join(fps, ", "))
+}
+
+// IssuedBy returns the certificates issued by the CA with the given fingerprint, excluding a self-signed CA's own certificate.
+func (db *DB) IssuedBy(caSHA string) []*CertRecord {
+	var out []*CertRecord
+	for _, rec := range db.Certificates {
+		if rec.IssuerSHA256 == caSHA && rec.SHA256 != caSHA {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// Revoke marks rec as revoked. Revoking an already revoked certificate keeps the original time and reason,
+// unless it is on hold and reason is final: the new time then puts the change on the next delta CRL.
+func (rec *CertRecord) Revoke(at time.Time, reason int) {
+	if rec.Status == StatusRevoked && (!rec.OnHold() || reason == ReasonCertificateHold) {
+		return
+	}
+	at = at.UTC()
+	rec.Status = StatusRevoked
+	rec.RevokedAt = &at
+	rec.RevocationReason = reason
+	rec.ReleasedAt = nil
+}
+
+// OnHold reports whether rec is suspended with certificateHold, and so may be released.
+func (rec *CertRecord) OnHold() bool {
+	return rec.Status == StatusRevoked && rec.RevocationReason == ReasonCertificateHold
+}
+
+// Release reinstates a certificate on hold. It leaves the next full CRL, and delta CRLs list it as
+// removeFromCRL until then.
+func (rec *CertRecord) Release(at time.Time) error {
+	if !rec.OnHold() {
+		return fmt.Errorf("%s (serial %s) is not on hold", rec.Subject, rec.Serial)
+	}
+	at = at.UTC()
+	rec.Status = StatusValid
+	rec.RevokedAt = nil
+	rec.RevocationReason = ReasonUnspecified
+	rec.ReleasedAt = &at
+	return nil
+}
+
+// Certificate parses the stored certificate.
+func (rec *CertRecord) Certificate() (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(rec.PEM))
+	if block == nil {
+		return nil, fmt.Errorf("inventory record %s has no certificate", rec.SHA256)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// IsSelfSigned reports whether cert is its own issuer.
+func IsSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
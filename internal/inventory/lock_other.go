@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package inventory
+
+import "os"
+
+// lockFile does nothing where files cannot be locked; such platforms run a single process.
+func lockFile(f *os.File) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
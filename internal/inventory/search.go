@@ -0,0 +1,120 @@
+package inventory
+
+import (
+	"crypto/x509"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Query selects certificates from the inventory. Zero fields match everything.
+type Query struct {
+	SAN           string // glob matched against the DNS, IP, e-mail and URI SANs and the subject CN, or a CIDR range of IP SANs
+	CN            string // glob matched against the subject CN
+	Subject       string // glob matched against the full subject DN
+	IssuerSHA256  string
+	Status        string
+	ExpiresBefore time.Time
+	ExpiresAfter  time.Time
+	IssuedBefore  time.Time // compared with the start of the validity period
+	IssuedAfter   time.Time
+	CA            *bool // only CAs (true) or only end-entity certificates (false)
+}
+
+// Names returns the subject CN and every SAN of cert, as matched by Query.SAN.
+func Names(cert *x509.Certificate) []string {
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	return names
+}
+
+// Search returns the certificates matching q, in inventory order.
+func (db *DB) Search(q Query) ([]*CertRecord, error) {
+	san, cn, subject := glob(q.SAN), glob(q.CN), glob(q.Subject)
+	prefix, prefixErr := netip.ParsePrefix(q.SAN)
+	if prefixErr == nil {
+		prefix = prefix.Masked()
+	}
+	var out []*CertRecord
+	for _, rec := range db.Certificates {
+		if q.IssuerSHA256 != "" && rec.IssuerSHA256 != q.IssuerSHA256 {
+			continue
+		}
+		if q.Status != "" && rec.Status != q.Status {
+			continue
+		}
+		if !q.ExpiresBefore.IsZero() && !rec.NotAfter.Before(q.ExpiresBefore) {
+			continue
+		}
+		if !q.ExpiresAfter.IsZero() && !rec.NotAfter.After(q.ExpiresAfter) {
+			continue
+		}
+		if !q.IssuedBefore.IsZero() && !rec.NotBefore.Before(q.IssuedBefore) {
+			continue
+		}
+		if !q.IssuedAfter.IsZero() && !rec.NotBefore.After(q.IssuedAfter) {
+			continue
+		}
+		if q.CA != nil && rec.IsCA != *q.CA {
+			continue
+		}
+		if q.Subject != "" && !subject.MatchString(rec.Subject) {
+			continue
+		}
+		if q.SAN == "" && q.CN == "" {
+			out = append(out, rec)
+			continue
+		}
+		cert, err := rec.Certificate()
+		if err != nil {
+			return nil, err
+		}
+		if q.CN != "" && !cn.MatchString(cert.Subject.CommonName) {
+			continue
+		}
+		if prefixErr == nil && !inPrefix(cert, prefix) {
+			continue
+		}
+		if q.SAN != "" && prefixErr != nil {
+			found := false
+			for _, name := range Names(cert) {
+				found = found || san.MatchString(name)
+			}
+			if !found {
+				continue
+			}
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+// inPrefix reports whether an IP SAN of cert lies in prefix.
+func inPrefix(cert *x509.Certificate, prefix netip.Prefix) bool {
+	for _, ip := range cert.IPAddresses {
+		if addr, ok := netip.AddrFromSlice(ip); ok && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// glob compiles a case-insensitive pattern where * matches any run of characters (including dots and
+// slashes) and ? any single character. "*.db.internal" also matches the wildcard SAN itself.
+func glob(pattern string) *regexp.Regexp {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\*`, ".*")
+	re = strings.ReplaceAll(re, `\?`, ".")
+	return regexp.MustCompile("(?i)^" + re + "$")
+}
@@ -0,0 +1,203 @@
+//go:build !js && !wasip1
+
+package inventory
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"my-pki/internal/utils"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// schema creates the tables of a SQLite inventory. Each row keeps the full record as JSON next to
+// the columns worth querying with the sqlite3 shell, so new record fields need no migration.
+const schema = `
+CREATE TABLE IF NOT EXISTS cas (
+	sha256   TEXT PRIMARY KEY,
+	name     TEXT NOT NULL,
+	status   TEXT NOT NULL,
+	pem_path TEXT,
+	record   TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS certificates (
+	sha256        TEXT PRIMARY KEY,
+	serial        TEXT NOT NULL,
+	subject       TEXT NOT NULL,
+	sans          TEXT,
+	issuer_sha256 TEXT NOT NULL,
+	not_before    TEXT NOT NULL,
+	not_after     TEXT NOT NULL,
+	issued_at     TEXT,
+	status        TEXT NOT NULL,
+	profile       TEXT,
+	path          TEXT,
+	record        TEXT NOT NULL
+);
+`
+
+// serialIndex makes the database itself reject two certificates of one issuer sharing a serial.
+const serialIndex = `CREATE UNIQUE INDEX IF NOT EXISTS certificates_serial ON certificates (issuer_sha256, serial)`
+
+// openSQL opens the SQLite database at path and creates its tables if needed.
+func openSQL(path string) (*sql.DB, error) {
+	conn, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open inventory '%s': %w", path, err)
+	}
+	if _, err := conn.Exec(schema); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to open inventory '%s': %w", path, err)
+	}
+	if err := createSerialIndex(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to open inventory '%s': %w", path, err)
+	}
+	return conn, nil
+}
+
+// createSerialIndex creates the unique serial index, replacing the plain one older inventories were
+// created with.
+func createSerialIndex(conn *sql.DB) error {
+	var def string
+	err := conn.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'index' AND name = 'certificates_serial'").Scan(&def)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case strings.Contains(strings.ToUpper(def), "UNIQUE"):
+		return nil
+	default:
+		if _, err := conn.Exec("DROP INDEX certificates_serial"); err != nil {
+			return err
+		}
+	}
+	if _, err := conn.Exec(serialIndex); err != nil {
+		return fmt.Errorf("duplicate certificate serials: %w", err)
+	}
+	return nil
+}
+
+// loadSQLite reads the records of the SQLite inventory at db.path into db.
+func (db *DB) loadSQLite() error {
+	conn, err := openSQL(db.path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	db.saved = map[string]string{}
+	if err := readRecords(conn, "cas", db.saved, &db.CAs); err != nil {
+		return fmt.Errorf("invalid inventory '%s': %w", db.path, err)
+	}
+	if err := readRecords(conn, "certificates", db.saved, &db.Certificates); err != nil {
+		return fmt.Errorf("invalid inventory '%s': %w", db.path, err)
+	}
+	return nil
+}
+
+// readRecords appends the JSON records of table to out and remembers each in saved.
+func readRecords[T any](conn *sql.DB, table string, saved map[string]string, out *[]*T) error {
+	rows, err := conn.Query("SELECT sha256, record FROM " + table + " ORDER BY rowid")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sha, data string
+		if err := rows.Scan(&sha, &data); err != nil {
+			return err
+		}
+		rec := new(T)
+		if err := json.Unmarshal([]byte(data), rec); err != nil {
+			return err
+		}
+		*out = append(*out, rec)
+		saved[table+":"+sha] = data
+	}
+	return rows.Err()
+}
+
+// saveSQLite writes the records of db that were added or changed since it was read to the SQLite
+// inventory at db.path, in one transaction.
+func (db *DB) saveSQLite() error {
+	conn, err := openSQL(db.path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to write inventory '%s': %w", db.path, err)
+	}
+	written, err := db.writeRows(tx)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to write inventory '%s': %w", db.path, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to write inventory '%s': %w", db.path, err)
+	}
+	if db.saved == nil {
+		db.saved = map[string]string{}
+	}
+	for key, data := range written {
+		db.saved[key] = data
+	}
+	return nil
+}
+
+// writeRows upserts the records whose JSON differs from the saved row and returns what it wrote.
+// Rows are never deleted: the inventory only gains records or updates them.
+
+func (db *DB) writeRows(tx *sql.Tx) (map[string]string, error) {
+	written := map[string]string{}
+	for _, ca := range db.CAs {
+		data, err := json.Marshal(ca)
+		if err != nil {
+			return nil, err
+		}
+		key := "cas:" + ca.SHA256
+		if db.saved[key] == string(data) {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO cas (sha256, name, status, pem_path, record) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(sha256) DO UPDATE SET name = excluded.name, status = excluded.status,
+			pem_path = excluded.pem_path, record = excluded.record`,
+			ca.SHA256, ca.Name, ca.Status, ca.PemPath, string(data)); err != nil {
+			return nil, fmt.Errorf("CA '%s': %w", ca.Name, err)
+		}
+		written[key] = string(data)
+	}
+	for _, rec := range db.Certificates {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		key := "certificates:" + rec.SHA256
+		if db.saved[key] == string(data) {
+			continue
+		}
+		var sans, issuedAt string
+		if cert, err := rec.Certificate(); err == nil {
+			sans = strings.Join(utils.SANs{DNSNames: cert.DNSNames, IPAddresses: cert.IPAddresses, EmailAddresses: cert.EmailAddresses, URIs: cert.URIs}.Strings(), ",")
+		}
+		if rec.IssuedAt != nil {
+			issuedAt = rec.IssuedAt.Format(time.RFC3339)
+		}
+		if _, err := tx.Exec(`INSERT INTO certificates (sha256, serial, subject, sans, issuer_sha256, not_before, not_after,
+			issued_at, status, profile, path, record) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(sha256) DO UPDATE SET serial = excluded.serial, subject = excluded.subject, sans = excluded.sans,
+			issuer_sha256 = excluded.issuer_sha256, not_before = excluded.not_before, not_after = excluded.not_after,
+			issued_at = excluded.issued_at, status = excluded.status, profile = excluded.profile, path = excluded.path,
+			record = excluded.record`,
+			rec.SHA256, rec.Serial, rec.Subject, sans, rec.IssuerSHA256, rec.NotBefore.Format(time.RFC3339),
+			rec.NotAfter.Format(time.RFC3339), issuedAt, rec.Status, rec.Profile, rec.Path, string(data)); err != nil {
+			return nil, fmt.Errorf("certificate %s (serial %s): %w", rec.Subject, rec.Serial, err)
+		}
+		written[key] = string(data)
+	}
+	return written, nil
+}
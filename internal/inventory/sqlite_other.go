@@ -0,0 +1,15 @@
+//go:build js || wasip1
+
+package inventory
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// loadSQLite is not supported on this platform, which the SQLite driver does not support.
+func (db *DB) loadSQLite() error {
+	return fmt.Errorf("SQLite inventories are not supported on %s; use a .json inventory", runtime.GOOS)
+}
+
+func (db *DB) saveSQLite() error { return db.loadSQLite() }
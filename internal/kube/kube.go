@@ -0,0 +1,430 @@
+// Package kube is a minimal client for the Kubernetes certificates.k8s.io/v1 API: it lists and
+// watches CertificateSigningRequest objects and writes their status, which is all a signer needs.
+//
+// It talks to the API server over plain HTTPS rather than through client-go, and authenticates
+// with a kubeconfig file (bearer token or client certificate) or the pod's service account.
+package kube
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// csrPath is the API path of the CertificateSigningRequest collection.
+const csrPath = "/apis/certificates.k8s.io/v1/certificatesigningrequests"
+
+// Service account files mounted into every pod.
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	serviceAccountCA  = serviceAccountDir + "/ca.crt"
+	serviceAccountTok = serviceAccountDir + "/token"
+)
+
+// Condition types of a CertificateSigningRequest.
+const (
+	ConditionApproved = "Approved"
+	ConditionDenied   = "Denied"
+	ConditionFailed   = "Failed"
+)
+
+// ErrGone reports that a watch resource version has expired and the collection must be listed again.
+var ErrGone = errors.New("watch resource version expired")
+
+// ObjectMeta holds the metadata fields the signer uses.
+type ObjectMeta struct {
+	Name            string `json:"name"`
+	UID             string `json:"uid,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// CertificateSigningRequest is a certificates.k8s.io/v1 CertificateSigningRequest.
+type CertificateSigningRequest struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     CSRSpec    `json:"spec"`
+	Status   CSRStatus  `json:"status"`
+
+	raw []byte // object as read, so status updates keep the fields not modelled here
+}
+
+// CSRSpec is the request part of a CertificateSigningRequest.
+type CSRSpec struct {
+	Request           []byte              `json:"request"` // PEM PKCS#10 request
+	SignerName        string              `json:"signerName"`
+	ExpirationSeconds *int64              `json:"expirationSeconds,omitempty"`
+	Usages            []string            `json:"usages,omitempty"`
+	Username          string              `json:"username,omitempty"`
+	UID               string              `json:"uid,omitempty"`
+	Groups            []string            `json:"groups,omitempty"`
+	Extra             map[string][]string `json:"extra,omitempty"`
+}
+
+// CSRStatus is the outcome part of a CertificateSigningRequest.
+type CSRStatus struct {
+	Conditions  []Condition `json:"conditions,omitempty"`
+	Certificate []byte      `json:"certificate,omitempty"` // PEM certificates, leaf first
+}
+
+// Condition is an approval, denial or failure recorded on a CertificateSigningRequest.
+type Condition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastUpdateTime     string `json:"lastUpdateTime,omitempty"` // RFC 3339
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+// SetFailed records a Failed condition, telling the requester why no certificate will be issued.
+func (c *CertificateSigningRequest) SetFailed(reason, message string, now time.Time) {
+	ts := now.UTC().Format(time.RFC3339)
+	c.Status.Conditions = append(c.Status.Conditions, Condition{
+		Type: ConditionFailed, Status: "True", Reason: reason, Message: message, LastUpdateTime: ts, LastTransitionTime: ts,
+	})
+}
+
+// HasCondition reports whether the request carries a true condition of type typ.
+func (c *CertificateSigningRequest) HasCondition(typ string) bool {
+	for _, cond := range c.Status.Conditions {
+		if cond.Type == typ && cond.Status != "False" {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is one change reported by a watch.
+type Event struct {
+	Type   string // ADDED, MODIFIED or DELETED
+	Object *CertificateSigningRequest
+}
+
+// Client talks to one Kubernetes API server.
+type Client struct {
+	Server string // e.g. https://10.0.0.1:6443
+	Token  string // bearer token; empty when a client certificate is used
+	HTTP   *http.Client
+}
+
+// InCluster returns a client for the API server of the pod this process runs in.
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes pod (KUBERNETES_SERVICE_HOST is not set); use a kubeconfig")
+	}
+	token, err := os.ReadFile(serviceAccountTok)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account token: %w", err)
+	}
+	caPEM, err := os.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account CA: %w", err)
+	}
+	tlsConfig, err := newTLSConfig(caPEM, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		Server: "https://" + strings.TrimSuffix(host, "/") + ":" + port,
+		Token:  strings.TrimSpace(string(token)),
+		HTTP:   &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}, nil
+}
+
+// kubeconfig is the subset of a kubeconfig file the client understands.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string    `yaml:"token"`
+			TokenFile             string    `yaml:"tokenFile"`
+			ClientCertificate     string    `yaml:"client-certificate"`
+			ClientCertificateData string    `yaml:"client-certificate-data"`
+			ClientKey             string    `yaml:"client-key"`
+			ClientKeyData         string    `yaml:"client-key-data"`
+			Exec                  yaml.Node `yaml:"exec"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// FromKubeconfig returns a client for the cluster and user of context in the kubeconfig at path.
+// An empty context selects the file's current context. Exec and auth-provider plugins are not supported.
+func FromKubeconfig(path, contextName string) (*Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read kubeconfig '%s': %w", path, err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig '%s': %w", path, err)
+	}
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+	// Relative file references are resolved against the kubeconfig's directory, as kubectl does
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(filepath.Dir(path), p)
+	}
+	// Inline *-data fields are base64 and take precedence over the file references
+	readData := func(inline, file string) ([]byte, error) {
+		if inline != "" {
+			return base64.StdEncoding.DecodeString(inline)
+		}
+		if file == "" {
+			return nil, nil
+		}
+		return os.ReadFile(resolve(file))
+	}
+
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == contextName {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("kubeconfig '%s' has no context '%s'", path, contextName)
+	}
+
+	c := &Client{}
+	var caPEM []byte
+	insecure := false
+	found = false
+	for _, cl := range kc.Clusters {
+		if cl.Name != clusterName {
+			continue
+		}
+		found = true
+		c.Server = strings.TrimSuffix(cl.Cluster.Server, "/")
+		insecure = cl.Cluster.InsecureSkipTLSVerify
+		if caPEM, err = readData(cl.Cluster.CertificateAuthorityData, cl.Cluster.CertificateAuthority); err != nil {
+			return nil, fmt.Errorf("unable to read cluster CA of '%s': %w", clusterName, err)
+		}
+	}
+	if !found || c.Server == "" {
+		return nil, fmt.Errorf("kubeconfig '%s' has no server for cluster '%s'", path, clusterName)
+	}
+	tlsConfig, err := newTLSConfig(caPEM, insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		if !u.User.Exec.IsZero() {
+			return nil, fmt.Errorf("user '%s' uses an exec credential plugin, which is not supported; use a token or client certificate", userName)
+		}
+		c.Token = u.User.Token
+		if c.Token == "" && u.User.TokenFile != "" {
+			token, err := os.ReadFile(resolve(u.User.TokenFile))
+			if err != nil {
+				return nil, fmt.Errorf("unable to read token of user '%s': %w", userName, err)
+			}
+			c.Token = strings.TrimSpace(string(token))
+		}
+		certPEM, err := readData(u.User.ClientCertificateData, u.User.ClientCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client certificate of user '%s': %w", userName, err)
+		}
+		keyPEM, err := readData(u.User.ClientKeyData, u.User.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client key of user '%s': %w", userName, err)
+		}
+		if len(certPEM) > 0 {
+			pair, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client certificate of user '%s': %w", userName, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+	c.HTTP = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	return c, nil
+}
+
+// newTLSConfig trusts the CA certificates in caPEM, or the system roots if caPEM is empty.
+func newTLSConfig(caPEM []byte, insecure bool) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: insecure}
+	if len(caPEM) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates found in the cluster CA")
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.Server+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(msg, &status) == nil && status.Message != "" {
+			msg = []byte(status.Message)
+		}
+		if resp.StatusCode == http.StatusGone {
+			return nil, ErrGone
+		}
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// List returns every CertificateSigningRequest and the collection's resource version, from which to watch.
+func (c *Client) List(ctx context.Context) ([]*CertificateSigningRequest, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, csrPath, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("invalid CertificateSigningRequest list: %w", err)
+	}
+	var csrs []*CertificateSigningRequest
+	for _, item := range list.Items {
+		csr, err := decodeCSR(item)
+		if err != nil {
+			return nil, "", err
+		}
+		csrs = append(csrs, csr)
+	}
+	return csrs, list.Metadata.ResourceVersion, nil
+}
+
+// Watch calls fn for every change after resourceVersion until the server ends the watch, ctx is
+// done or fn fails. It returns ErrGone when resourceVersion is too old and the caller must list again.
+func (c *Client) Watch(ctx context.Context, resourceVersion string, fn func(Event) error) error {
+	q := url.Values{"watch": {"true"}, "resourceVersion": {resourceVersion}, "allowWatchBookmarks": {"true"}}
+	resp, err := c.do(ctx, http.MethodGet, csrPath+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var ev struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("watch interrupted: %w", err)
+		}
+		switch ev.Type {
+		case "BOOKMARK":
+			continue
+		case "ERROR":
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(ev.Object, &status)
+			if status.Code == http.StatusGone {
+				return ErrGone
+			}
+			return fmt.Errorf("watch error: %s", status.Message)
+		}
+		obj, err := decodeCSR(ev.Object)
+		if err != nil {
+			return err
+		}
+		if err := fn(Event{Type: ev.Type, Object: obj}); err != nil {
+			return err
+		}
+	}
+}
+
+func decodeCSR(data []byte) (*CertificateSigningRequest, error) {
+	csr := &CertificateSigningRequest{raw: data}
+	if err := json.Unmarshal(data, csr); err != nil {
+		return nil, fmt.Errorf("invalid CertificateSigningRequest: %w", err)
+	}
+	return csr, nil
+}
+
+// UpdateStatus writes csr's status. The update fails with a conflict if csr has changed since it was read.
+func (c *Client) UpdateStatus(ctx context.Context, csr *CertificateSigningRequest) error {
+	obj := map[string]json.RawMessage{}
+	if err := json.Unmarshal(csr.raw, &obj); err != nil {
+		return fmt.Errorf("CertificateSigningRequest '%s' was not read from the API server: %w", csr.Metadata.Name, err)
+	}
+	status, err := json.Marshal(csr.Status)
+	if err != nil {
+		return fmt.Errorf("failed to encode CertificateSigningRequest status: %w", err)
+	}
+	obj["status"] = status
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("failed to encode CertificateSigningRequest: %w", err)
+	}
+	resp, err := c.do(ctx, http.MethodPut, csrPath+"/"+url.PathEscape(csr.Metadata.Name)+"/status", body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,55 @@
+package kube
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// keyUsages maps Kubernetes key usage names to X.509 key usages.
+var keyUsages = map[string]x509.KeyUsage{
+	"signing":            x509.KeyUsageDigitalSignature,
+	"digital signature":  x509.KeyUsageDigitalSignature,
+	"content commitment": x509.KeyUsageContentCommitment,
+	"key encipherment":   x509.KeyUsageKeyEncipherment,
+	"key agreement":      x509.KeyUsageKeyAgreement,
+	"data encipherment":  x509.KeyUsageDataEncipherment,
+	"encipher only":      x509.KeyUsageEncipherOnly,
+	"decipher only":      x509.KeyUsageDecipherOnly,
+}
+
+// extKeyUsages maps Kubernetes key usage names to X.509 extended key usages.
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"server auth":      x509.ExtKeyUsageServerAuth,
+	"client auth":      x509.ExtKeyUsageClientAuth,
+	"code signing":     x509.ExtKeyUsageCodeSigning,
+	"email protection": x509.ExtKeyUsageEmailProtection,
+	"s/mime":           x509.ExtKeyUsageEmailProtection,
+	"ipsec end system": x509.ExtKeyUsageIPSECEndSystem,
+	"ipsec tunnel":     x509.ExtKeyUsageIPSECTunnel,
+	"ipsec user":       x509.ExtKeyUsageIPSECUser,
+	"timestamping":     x509.ExtKeyUsageTimeStamping,
+	"ocsp signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// Usages converts the usages requested in a CertificateSigningRequest. CA usages ("cert sign",
+// "crl sign", "any") are refused: the signer only issues leaf certificates.
+func Usages(names []string) (x509.KeyUsage, []x509.ExtKeyUsage, error) {
+	var ku x509.KeyUsage
+	var eku []x509.ExtKeyUsage
+	for _, name := range names {
+		if u, ok := keyUsages[name]; ok {
+			ku |= u
+			continue
+		}
+		if u, ok := extKeyUsages[name]; ok {
+			eku = append(eku, u)
+			continue
+		}
+		switch name {
+		case "cert sign", "crl sign", "any":
+			return 0, nil, fmt.Errorf("usage '%s' is not allowed for leaf certificates", name)
+		}
+		return 0, nil, fmt.Errorf("unknown usage '%s'", name)
+	}
+	return ku, eku, nil
+}
@@ -0,0 +1,97 @@
+// Package logging configures the structured (log/slog) logging shared by the CLI, the GUI and the
+// servers.
+//
+// Key material must never reach a log. Callers log metadata only, and the handler enforces it as
+// well: attributes named like secrets (key, passphrase, share, ...) and values that are private
+// keys or raw bytes are replaced by Redacted, whatever the level or format.
+package logging
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted replaces the value of attributes that could hold key material.
+const Redacted = "[REDACTED]"
+
+// sensitiveNames are attribute names, or suffixes after an underscore (ca_key), that are never logged.
+var sensitiveNames = []string{"key", "private_key", "passphrase", "password", "pin", "secret", "share", "token", "kek", "seed", "words"}
+
+var levels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	level, ok := levels[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("invalid log level '%s' (expected debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// NewHandler returns a text or JSON handler writing records at level and above to w, with redaction.
+func NewHandler(w io.Writer, level slog.Level, format string) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	switch format {
+	case FormatText:
+		return slog.NewTextHandler(w, opts), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("invalid log format '%s' (expected %s or %s)", format, FormatText, FormatJSON)
+}
+
+// Setup makes the handler for w, level and format the default, also for the standard log package.
+func Setup(w io.Writer, level, format string) error {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	h, err := NewHandler(w, l, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// redact drops the value of attributes that may be key material.
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey || a.Key == slog.MessageKey || a.Key == slog.SourceKey) {
+		return a
+	}
+	if Sensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	if a.Value.Kind() == slog.KindAny {
+		switch a.Value.Any().(type) {
+		case []byte, interface{ Public() crypto.PublicKey }:
+			return slog.String(a.Key, Redacted)
+		}
+	}
+	return a
+}
+
+// Sensitive reports whether an attribute named name is redacted.
+func Sensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveNames {
+		if name == s || strings.HasSuffix(name, "_"+s) {
+			return true
+		}
+	}
+	return false
+}
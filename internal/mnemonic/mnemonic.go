@@ -0,0 +1,149 @@
+// Package mnemonic encodes short binary secrets, such as key shares, as word lists that custodians
+// can copy onto paper, in the style of SLIP-39.
+//
+// Every word carries 10 bits and is identified by its first four letters, so abbreviated or
+// slightly misspelled endings still decode. A mnemonic is one length word, the data words (the
+// data zero-padded to a multiple of 10 bits) and three checksum words holding the first 30 bits
+// of SHA-256 over the length and data, which catch mistyped and swapped words.
+package mnemonic
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//go:embed wordlist.txt
+var wordlistText string
+
+// Wordlist holds the 1024 words, in order of their 10-bit values.
+var Wordlist = strings.Fields(wordlistText)
+
+var byPrefix = func() map[string]int {
+	m := make(map[string]int, len(Wordlist))
+	for i, w := range Wordlist {
+		m[w[:4]] = i
+	}
+	return m
+}()
+
+const checksumWords = 3
+
+// Encode returns the mnemonic of data, which may be at most 1023 bytes long.
+func Encode(data []byte) ([]string, error) {
+	if len(data) > 1023 {
+		return nil, fmt.Errorf("%d bytes is too long for a mnemonic", len(data))
+	}
+	values := []int{len(data)}
+	values = append(values, pack(data)...)
+	values = append(values, checksum(data)...)
+	words := make([]string, len(values))
+	for i, v := range values {
+		words[i] = Wordlist[v]
+	}
+	return words, nil
+}
+
+// Decode returns the data encoded in words, reporting the first unknown word by position.
+func Decode(words []string) ([]byte, error) {
+	values := make([]int, len(words))
+	for i, w := range words {
+		v, ok := lookup(w)
+		if !ok {
+			return nil, fmt.Errorf("word %d '%s' is not in the word list", i+1, w)
+		}
+		values[i] = v
+	}
+	if len(values) < 1+checksumWords {
+		return nil, fmt.Errorf("%d words is too short for a mnemonic", len(values))
+	}
+	n := values[0]
+	dataWords := values[1 : len(values)-checksumWords]
+	if want := (n*8 + 9) / 10; len(dataWords) != want {
+		return nil, fmt.Errorf("expected %d words for %d bytes of data but got %d; a word is missing or repeated", want+1+checksumWords, n, len(values))
+	}
+	data, ok := unpack(dataWords, n)
+	if !ok || !equal(checksum(data), values[len(values)-checksumWords:]) {
+		return nil, errors.New("checksum mismatch: a word was mistyped or the words are out of order")
+	}
+	return data, nil
+}
+
+// Fields splits a transcribed mnemonic into words, ignoring case, punctuation, the position
+// numbers printed next to each word and comment lines starting with '#'.
+func Fields(text string) []string {
+	var words []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, f := range strings.FieldsFunc(strings.ToLower(line), func(r rune) bool { return r < 'a' || r > 'z' }) {
+			words = append(words, f)
+		}
+	}
+	return words
+}
+
+// lookup returns the value of a word, recognising it by its first four letters.
+func lookup(word string) (int, bool) {
+	if len(word) < 4 {
+		return 0, false
+	}
+	v, ok := byPrefix[strings.ToLower(word[:4])]
+	return v, ok
+}
+
+// pack splits data into 10-bit values, zero-padding the last one.
+func pack(data []byte) []int {
+	var values []int
+	acc, bits := 0, 0
+	for _, b := range data {
+		acc = acc<<8 | int(b)
+		bits += 8
+		for bits >= 10 {
+			bits -= 10
+			values = append(values, acc>>bits&0x3ff)
+		}
+	}
+	if bits > 0 {
+		values = append(values, acc<<(10-bits)&0x3ff)
+	}
+	return values
+}
+
+// unpack reverses pack, reporting whether the padding bits were zero.
+func unpack(values []int, n int) ([]byte, bool) {
+	data := make([]byte, 0, n)
+	acc, bits := 0, 0
+	for _, v := range values {
+		acc = acc<<10 | v
+		bits += 10
+		for bits >= 8 && len(data) < n {
+			bits -= 8
+			data = append(data, byte(acc>>bits))
+		}
+		acc &= 1<<bits - 1
+	}
+	return data, acc == 0
+}
+
+// checksum returns the first 30 bits of SHA-256 over the length and data, as three values.
+func checksum(data []byte) []int {
+	sum := sha256.Sum256(append([]byte{byte(len(data) >> 8), byte(len(data))}, data...))
+	v := int(sum[0])<<22 | int(sum[1])<<14 | int(sum[2])<<6 | int(sum[3])>>2
+	return []int{v >> 20 & 0x3ff, v >> 10 & 0x3ff, v & 0x3ff}
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
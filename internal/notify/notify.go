@@ -0,0 +1,132 @@
+// Package notify tells security teams about sensitive operations on a CA key, such as the
+// combination of its shares, by POSTing a JSON event to webhooks and sending it by email.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Events sent when the shares of a CA key are combined.
+const (
+	EventCombineRequested = "combine-requested" // a delay window started
+	EventCombineCancelled = "combine-cancelled" // a pending request was cancelled
+	EventSharesCombined   = "shares-combined"   // the key is about to be reconstructed
+)
+
+// Timeout bounds the delivery of one notification.
+const Timeout = 30 * time.Second
+
+// Email says how events are mailed. The SMTP password is read from the environment variable named
+// by PasswordEnv, so it is never written to the CA configuration.
+type Email struct {
+	SMTP        string   `yaml:"smtp"` // host:port, STARTTLS is used when offered
+	From        string   `yaml:"from"`
+	To          []string `yaml:"to"`
+	Username    string   `yaml:"username,omitempty"`
+	PasswordEnv string   `yaml:"password_env,omitempty"`
+}
+
+// Targets are the destinations of a CA's notifications.
+type Targets struct {
+	Webhooks []string `yaml:"webhooks,omitempty"`
+	Email    *Email   `yaml:"email,omitempty"`
+}
+
+// Empty reports whether there is nowhere to send notifications.
+func (t Targets) Empty() bool {
+	return len(t.Webhooks) == 0 && t.Email == nil
+}
+
+// Event describes what happened to a CA key, and who did it from where.
+type Event struct {
+	Event      string     `json:"event"`
+	Time       time.Time  `json:"time"`
+	CAPem      string     `json:"ca_pem"`
+	CASubject  string     `json:"ca_subject"`
+	CASHA256   string     `json:"ca_sha256"`
+	KeyID      string     `json:"key_id,omitempty"`
+	Command    string     `json:"command,omitempty"`
+	Operator   string     `json:"operator,omitempty"`
+	Host       string     `json:"host,omitempty"`
+	Custodians []string   `json:"custodians,omitempty"` // holders of the shares presented, as far as known
+	RequestID  string     `json:"request_id,omitempty"`
+	NotBefore  *time.Time `json:"not_before,omitempty"` // end of the delay window
+	Note       string     `json:"note,omitempty"`
+}
+
+// Summary is a one-line description of the event, used as the email subject.
+func (e *Event) Summary() string {
+	switch e.Event {
+	case EventCombineRequested:
+		return fmt.Sprintf("Combination of the key of %s requested, allowed from %s", e.CASubject, e.NotBefore.Format(time.RFC3339))
+	case EventCombineCancelled:
+		return fmt.Sprintf("Combination of the key of %s cancelled", e.CASubject)
+	default:
+		return fmt.Sprintf("Shares of the key of %s are being combined", e.CASubject)
+	}
+}
+
+// Send delivers ev to every target and returns the failures joined; every target is tried.
+func Send(t Targets, ev *Event) error {
+	body, err := json.MarshalIndent(ev, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	var errs []error
+	for _, url := range t.Webhooks {
+		if err := postWebhook(url, body); err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s: %w", url, err))
+		}
+	}
+	if t.Email != nil {
+		if err := sendEmail(t.Email, ev.Summary(), body); err != nil {
+			errs = append(errs, fmt.Errorf("email to %s: %w", strings.Join(t.Email.To, ", "), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func postWebhook(url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func sendEmail(e *Email, subject string, body []byte) error {
+	if e.SMTP == "" || e.From == "" || len(e.To) == 0 {
+		return errors.New("email needs smtp, from and to")
+	}
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := strings.Cut(e.SMTP, ":")
+		auth = smtp.PlainAuth("", e.Username, os.Getenv(e.PasswordEnv), host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: [GoSeC] %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n",
+		e.From, strings.Join(e.To, ", "), subject, time.Now().Format(time.RFC1123Z))
+	msg.Write(bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n")))
+	msg.WriteString("\r\n")
+	return smtp.SendMail(e.SMTP, auth, e.From, e.To, msg.Bytes())
+}
@@ -0,0 +1,108 @@
+// Package opensslca reads the database of an "openssl ca" directory: the index.txt listing every
+// issued certificate with its status, and the serial and crlnumber counter files.
+package opensslca
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// Statuses of an index entry.
+const (
+	StatusValid   = 'V'
+	StatusRevoked = 'R'
+	StatusExpired = 'E'
+)
+
+// Entry is one line of index.txt.
+type Entry struct {
+	Line      int
+	Status    byte
+	NotAfter  time.Time
+	RevokedAt time.Time // zero unless revoked
+	Reason    string    // as written by openssl, e.g. keyCompromise or CACompromise; empty if none
+	// ReasonTime is the compromise or hold time of the keyTime, CAkeyTime and holdInstruction pseudo-reasons
+	ReasonTime string
+	Serial     string // hex, as written (upper case)
+	File       string // "unknown" unless openssl ca was told the file name
+	Subject    string // OpenSSL one-line form, e.g. /C=DE/O=Example/CN=host
+}
+
+// ParseIndex reads index.txt: one tab-separated line per certificate with status, expiry, revocation
+// time and reason, serial, file name and subject.
+func ParseIndex(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("line %d: expected 6 tab-separated fields, found %d", n, len(fields))
+		}
+		e := Entry{Line: n, Serial: fields[3], File: fields[4], Subject: fields[5]}
+		if len(fields[0]) != 1 || !strings.ContainsAny(fields[0], "VRE") {
+			return nil, fmt.Errorf("line %d: invalid status '%s'", n, fields[0])
+		}
+		e.Status = fields[0][0]
+		var err error
+		if e.NotAfter, err = parseTime(fields[1]); err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry: %w", n, err)
+		}
+		if e.Status == StatusRevoked {
+			parts := strings.SplitN(fields[2], ",", 3)
+			if e.RevokedAt, err = parseTime(parts[0]); err != nil {
+				return nil, fmt.Errorf("line %d: invalid revocation time: %w", n, err)
+			}
+			if len(parts) > 1 {
+				e.Reason = parts[1]
+			}
+			if len(parts) > 2 {
+				e.ReasonTime = parts[2]
+			}
+		}
+		if _, ok := new(big.Int).SetString(e.Serial, 16); !ok {
+			return nil, fmt.Errorf("line %d: invalid serial '%s'", n, e.Serial)
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// parseTime parses the UTCTime (YYMMDDHHMMSSZ) or GeneralizedTime (YYYYMMDDHHMMSSZ) openssl writes.
+func parseTime(s string) (time.Time, error) {
+	switch len(s) {
+	case len("060102150405Z"):
+		return time.Parse("060102150405Z", s)
+	case len("20060102150405Z"):
+		return time.Parse("20060102150405Z", s)
+	}
+	return time.Time{}, fmt.Errorf("'%s' is not a UTCTime or GeneralizedTime", s)
+}
+
+// ReadCounter reads a serial or crlnumber file: the next number to use, in hex. A missing file yields nil.
+func ReadCounter(path string) (*big.Int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read '%s': %w", path, err)
+	}
+	n, ok := new(big.Int).SetString(strings.TrimSpace(string(data)), 16)
+	if !ok {
+		return nil, fmt.Errorf("'%s' does not hold a hex number", path)
+	}
+	return n, nil
+}
@@ -0,0 +1,168 @@
+// Package piv drives a PIV smartcard (e.g. a YubiKey) through yubico-piv-tool so that a leaf key
+// can be generated on the token, certified by a CA and the certificate written back to the slot.
+// The private key never leaves the token.
+package piv
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultTool is the yubico-piv-tool executable looked up in PATH.
+const DefaultTool = "yubico-piv-tool"
+
+// Slots that can hold a key and certificate.
+var validSlots = map[string]string{
+	"9a": "authentication",
+	"9c": "digital signature",
+	"9d": "key management",
+	"9e": "card authentication",
+}
+
+// Token identifies the card and the credentials used to operate it.
+type Token struct {
+	Tool          string // executable, DefaultTool if empty
+	Reader        string // optional reader name substring
+	ManagementKey string // optional; the tool's default management key is used if empty
+	PIN           []byte
+	PINPolicy     string // optional: never, once, always
+	TouchPolicy   string // optional: never, always, cached
+}
+
+// CheckSlot validates a slot name such as "9a".
+func CheckSlot(slot string) error {
+	if _, ok := validSlots[slot]; !ok {
+		return fmt.Errorf("unsupported PIV slot '%s' (expected 9a, 9c, 9d or 9e)", slot)
+	}
+	return nil
+}
+
+// GenerateKey creates a P-256 key in slot and returns its public key.
+func (t *Token) GenerateKey(slot string) (crypto.PublicKey, error) {
+	args := []string{"-a", "generate", "-s", slot, "-A", "ECCP256"}
+	if t.PINPolicy != "" {
+		args = append(args, "--pin-policy="+t.PINPolicy)
+	}
+	if t.TouchPolicy != "" {
+		args = append(args, "--touch-policy="+t.TouchPolicy)
+	}
+	out, err := t.run(nil, t.withManagementKey(args)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key in slot %s: %w", slot, err)
+	}
+	block, _ := pem.Decode(out)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("unexpected output from %s generate", t.tool())
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key from token: %w", err)
+	}
+	return pub, nil
+}
+
+// RequestCertificate has the token sign a CSR for the key in slot, proving possession of the key.
+// pub must be the public key returned by GenerateKey.
+func (t *Token) RequestCertificate(slot, cn string, pub crypto.PublicKey) (*x509.CertificateRequest, error) {
+	pubPEM, err := marshalPublicKeyPEM(pub)
+	if err != nil {
+		return nil, err
+	}
+	// yubico-piv-tool separates subject attributes with '/', so keep the CSR subject simple;
+	// the issued certificate's subject is set by the CA, not taken from the CSR.
+	subject := "/CN=" + strings.NewReplacer("/", "_", "\\", "_").Replace(cn) + "/"
+	args := []string{"-a", "verify-pin", "-a", "request-certificate", "-s", slot, "-S", subject}
+	out, err := t.run(pubPEM, t.withPIN(args)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSR in slot %s: %w", slot, err)
+	}
+	block, _ := pem.Decode(out)
+	if block == nil || !strings.Contains(block.Type, "CERTIFICATE REQUEST") {
+		return nil, fmt.Errorf("unexpected output from %s request-certificate", t.tool())
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSR from token: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("CSR from token has an invalid signature: %w", err)
+	}
+	if !publicKeysEqual(csr.PublicKey, pub) {
+		return nil, errors.New("CSR from token does not match the generated key")
+	}
+	return csr, nil
+}
+
+// ImportCertificate writes certPEM into slot.
+func (t *Token) ImportCertificate(slot string, certPEM []byte) error {
+	args := []string{"-a", "import-certificate", "-s", slot}
+	if _, err := t.run(certPEM, t.withManagementKey(args)...); err != nil {
+		return fmt.Errorf("failed to import certificate into slot %s: %w", slot, err)
+	}
+	return nil
+}
+
+func (t *Token) tool() string {
+	if t.Tool != "" {
+		return t.Tool
+	}
+	return DefaultTool
+}
+
+func (t *Token) withManagementKey(args []string) []string {
+	if t.ManagementKey != "" {
+		args = append(args, "--key="+t.ManagementKey)
+	}
+	return args
+}
+
+func (t *Token) withPIN(args []string) []string {
+	if len(t.PIN) > 0 {
+		args = append(args, "--pin="+string(t.PIN))
+	}
+	return args
+}
+
+// run executes the tool with stdin as input and returns stdout.
+func (t *Token) run(stdin []byte, args ...string) ([]byte, error) {
+	if t.Reader != "" {
+		args = append([]string{"--reader=" + t.Reader}, args...)
+	}
+	path, err := exec.LookPath(t.tool())
+	if err != nil {
+		return nil, fmt.Errorf("%s not found; install yubico-piv-tool or pass its path: %w", t.tool(), err)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		return nil, fmt.Errorf("%s: %w: %s", filepath.Base(path), err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+func marshalPublicKeyPEM(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	ea, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && ea.Equal(b)
+}
@@ -0,0 +1,201 @@
+// Package queue keeps certificate requests pending review. Requesters submit CSRs into the queue;
+// nothing is signed until an operator explicitly approves a request, which puts a review step
+// between requesters and share holders.
+//
+// The queue is a directory holding, per request, <id>.json (the record), <id>.csr (the CSR as
+// submitted) and, once issued, <id>.crt.
+package queue
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDir is the queue directory used when none is given.
+const DefaultDir = "gosec-requests"
+
+// Request statuses.
+const (
+	StatusPending = "pending"
+	StatusIssued  = "issued"
+	StatusDenied  = "denied"
+)
+
+// Request is one submitted CSR and its review outcome.
+type Request struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Submitted  time.Time  `json:"submitted"`
+	Requester  string     `json:"requester,omitempty"`
+	Note       string     `json:"note,omitempty"`
+	Subject    string     `json:"subject"`
+	Names      []string   `json:"names,omitempty"`
+	DecidedAt  *time.Time `json:"decided_at,omitempty"`
+	DecidedBy  string     `json:"decided_by,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	CertSHA256 string     `json:"cert_sha256,omitempty"`
+}
+
+// Store is a queue directory.
+type Store struct {
+	Dir string
+}
+
+// Open returns the queue in dir. The directory is created on the first submission.
+func Open(dir string) *Store {
+	if dir == "" {
+		dir = DefaultDir
+	}
+	return &Store{Dir: dir}
+}
+
+// CSRPath returns the file holding the CSR of request id.
+func (s *Store) CSRPath(id string) string { return filepath.Join(s.Dir, id+".csr") }
+
+// CertPath returns the file holding the certificate issued for request id.
+func (s *Store) CertPath(id string) string { return filepath.Join(s.Dir, id+".crt") }
+
+func (s *Store) recordPath(id string) string { return filepath.Join(s.Dir, id+".json") }
+
+// Submit checks csrPEM and adds it to the queue as a pending request.
+func (s *Store) Submit(csrPEM []byte, requester, note string) (*Request, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || (block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST") {
+		return nil, errors.New("failed to decode PEM block containing a certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("certificate request signature is invalid: %w", err)
+	}
+	now, err := utils.Now()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create request queue '%s': %w", s.Dir, err)
+	}
+
+	id, err := s.newID()
+	if err != nil {
+		return nil, err
+	}
+	req := &Request{
+		ID:        id,
+		Status:    StatusPending,
+		Submitted: now.UTC(),
+		Requester: requester,
+		Note:      note,
+		Subject:   csr.Subject.String(),
+		Names:     utils.SANsFromCSR(csr).Strings(),
+	}
+	normalized := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})
+	if err := os.WriteFile(s.CSRPath(id), normalized, 0644); err != nil {
+		return nil, fmt.Errorf("failed to store request: %w", err)
+	}
+	if err := s.Save(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// newID draws a random request ID not yet in use.
+func (s *Store) newID() (string, error) {
+	b := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(utils.Rand, b); err != nil {
+			return "", fmt.Errorf("failed to generate request ID: %w", err)
+		}
+		id := hex.EncodeToString(b)
+		if _, err := os.Stat(s.recordPath(id)); errors.Is(err, os.ErrNotExist) {
+			return id, nil
+		}
+	}
+}
+
+// Get returns request id.
+func (s *Store) Get(id string) (*Request, error) {
+	if id == "" || id != filepath.Base(id) || strings.ContainsAny(id, `.\/`) {
+		return nil, fmt.Errorf("invalid request ID '%s'", id)
+	}
+	data, err := os.ReadFile(s.recordPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no request '%s' in queue '%s'", id, s.Dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read request '%s': %w", id, err)
+	}
+	req := &Request{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("invalid request record '%s': %w", id, err)
+	}
+	return req, nil
+}
+
+// List returns every request, oldest first. A missing queue directory is an empty queue.
+func (s *Store) List() ([]*Request, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read request queue '%s': %w", s.Dir, err)
+	}
+	var reqs []*Request
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		req, err := s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].Submitted.Before(reqs[j].Submitted) })
+	return reqs, nil
+}
+
+// Save writes req back to the queue, replacing its record atomically.
+func (s *Store) Save(req *Request) error {
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+	tmp := s.recordPath(req.ID) + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write request '%s': %w", req.ID, err)
+	}
+	if err := os.Rename(tmp, s.recordPath(req.ID)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write request '%s': %w", req.ID, err)
+	}
+	return nil
+}
+
+// Decide records the outcome of a pending request.
+func (req *Request) Decide(status, by, comment string, at time.Time) error {
+	if req.Status != StatusPending {
+		return fmt.Errorf("request '%s' is already %s", req.ID, req.Status)
+	}
+	at = at.UTC()
+	req.Status = status
+	req.DecidedAt = &at
+	req.DecidedBy = by
+	req.Comment = comment
+	return nil
+}
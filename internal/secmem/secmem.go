@@ -0,0 +1,59 @@
+// Package secmem wipes secrets once used and can keep them out of swap and core dumps. Go's
+// garbage collector and the standard library may hold copies this package cannot reach, so both
+// are best effort: they shorten how long key material lingers rather than guarantee its removal.
+package secmem
+
+import (
+	"crypto/ecdsa"
+	"sync/atomic"
+)
+
+var (
+	enabled      atomic.Bool
+	lockFailures atomic.Int64
+)
+
+// Enable disables core dumps of the process and makes Lock pin buffers in RAM. It fails where the
+// platform offers neither.
+func Enable() error {
+	if err := protectProcess(); err != nil {
+		return err
+	}
+	enabled.Store(true)
+	return nil
+}
+
+// Enabled reports whether Enable succeeded.
+func Enabled() bool { return enabled.Load() }
+
+// Lock pins the pages holding b in RAM, so they are never written to swap, once Enable has been
+// called. Failures, e.g. over RLIMIT_MEMLOCK, leave b as it would be without Enable and are only
+// counted: see LockFailures.
+func Lock(b []byte) {
+	if len(b) == 0 || !enabled.Load() {
+		return
+	}
+	if lock(b) != nil {
+		lockFailures.Add(1)
+	}
+}
+
+// LockFailures returns how many buffers Lock could not pin.
+func LockFailures() int64 { return lockFailures.Load() }
+
+// Wipe zeroes buffers holding secrets and releases their pages if they were locked.
+func Wipe(bufs ...[]byte) {
+	for _, b := range bufs {
+		clear(b)
+		if len(b) > 0 && enabled.Load() {
+			_ = unlock(b)
+		}
+	}
+}
+
+// WipeKey zeroes the private scalar of an ECDSA key. The key must not be used afterwards.
+func WipeKey(key *ecdsa.PrivateKey) {
+	if key != nil && key.D != nil {
+		clear(key.D.Bits())
+	}
+}
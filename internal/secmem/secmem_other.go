@@ -0,0 +1,16 @@
+//go:build !linux
+
+package secmem
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// protectProcess is only implemented on Linux.
+func protectProcess() error {
+	return fmt.Errorf("locking memory is not supported on %s", runtime.GOOS)
+}
+
+func lock(b []byte) error   { return nil }
+func unlock(b []byte) error { return nil }
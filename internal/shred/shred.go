@@ -0,0 +1,111 @@
+// Package shred overwrites files before deleting them, for key material that is superseded or
+// was only needed temporarily.
+//
+// Overwriting in place only destroys the data when the filesystem writes it back to the same
+// blocks and the device does not keep old copies. Copy-on-write and log-structured filesystems,
+// SSDs and flash (wear levelling), network filesystems and snapshots all break that assumption,
+// so every result says whether deletion could be confirmed or why it cannot be guaranteed.
+package shred
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DefaultPasses is the number of random overwrites before the final pass of zeros.
+const DefaultPasses = 3
+
+// Result describes the deletion of one file.
+type Result struct {
+	Path   string
+	Size   int64
+	Passes int
+	// Caveats explain why the old data may survive; none means secure deletion is as certain as
+	// it can be from user space.
+	Caveats []string
+}
+
+// Guaranteed reports whether no caveat applies.
+func (r *Result) Guaranteed() bool {
+	return len(r.Caveats) == 0
+}
+
+// File overwrites path passes times with random data and once with zeros, syncing after each pass,
+// then renames it to a random name, truncates it and removes it. Symbolic links and directories are refused.
+func File(path string, passes int) (*Result, error) {
+	if passes < 1 {
+		return nil, errors.New("at least one overwrite pass is required")
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("'%s' is not a regular file", path)
+	}
+	res := &Result{Path: path, Size: info.Size(), Passes: passes}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	res.Caveats = storageCaveats(f)
+	if hardLinks(info) > 1 {
+		res.Caveats = append(res.Caveats, "the file has other hard links; they are overwritten too but not removed")
+	}
+	for pass := 0; pass <= passes; pass++ {
+		var src io.Reader = rand.Reader
+		if pass == passes {
+			src = zeros{}
+		}
+		if err := overwrite(f, info.Size(), src); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to overwrite '%s': %w", path, err)
+		}
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to truncate '%s': %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	// A random name hides the original one from the directory entry, where the filesystem allows
+	renamed := path
+	name := make([]byte, 8)
+	if _, err := rand.Read(name); err == nil {
+		candidate := filepath.Join(filepath.Dir(path), "."+hex.EncodeToString(name))
+		if os.Rename(path, candidate) == nil {
+			renamed = candidate
+		}
+	}
+	if err := os.Remove(renamed); err != nil {
+		return nil, fmt.Errorf("overwrote '%s' but failed to remove it: %w", path, err)
+	}
+	return res, nil
+}
+
+// overwrite writes size bytes from src at the start of f and syncs them to the device.
+func overwrite(f *os.File, size int64, src io.Reader) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, src, size); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// zeros is an endless reader of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,75 @@
+package shred
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Filesystems on which overwriting a file does not reach the blocks that held its data, by statfs magic.
+var unsafeFilesystems = map[int64]string{
+	unix.BTRFS_SUPER_MAGIC:     "btrfs is copy-on-write: overwrites go to new blocks and snapshots may keep the old ones",
+	0x2fc12fc1:                 "ZFS is copy-on-write: overwrites go to new blocks and snapshots may keep the old ones",
+	0xca451a4e:                 "bcachefs is copy-on-write: overwrites go to new blocks",
+	unix.F2FS_SUPER_MAGIC:      "F2FS is log-structured: overwrites go to new blocks",
+	unix.NILFS_SUPER_MAGIC:     "NILFS is log-structured: overwrites go to new blocks",
+	unix.OVERLAYFS_SUPER_MAGIC: "the file is on an overlay filesystem: a copy may remain in a lower layer",
+	unix.NFS_SUPER_MAGIC:       "the file is on NFS: the server decides where data is written",
+	unix.CIFS_SUPER_MAGIC:      "the file is on a CIFS share: the server decides where data is written",
+	unix.SMB2_SUPER_MAGIC:      "the file is on an SMB share: the server decides where data is written",
+	unix.FUSE_SUPER_MAGIC:      "the file is on a FUSE filesystem, whose storage is unknown",
+	unix.TMPFS_MAGIC:           "the file is on tmpfs: its pages may have been written to swap",
+}
+
+// storageCaveats inspects the filesystem and block device holding f.
+func storageCaveats(f *os.File) []string {
+	var caveats []string
+	var fs unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &fs); err != nil {
+		caveats = append(caveats, fmt.Sprintf("could not identify the filesystem: %v", err))
+	} else if reason, ok := unsafeFilesystems[int64(fs.Type)]; ok {
+		return append(caveats, reason)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return append(caveats, fmt.Sprintf("could not identify the storage device: %v", err))
+	}
+	dev := fmt.Sprintf("%d:%d", unix.Major(uint64(st.Dev)), unix.Minor(uint64(st.Dev)))
+	rotational, err := rotationalDevice(dev)
+	switch {
+	case err != nil:
+		caveats = append(caveats, fmt.Sprintf("could not tell whether device %s is a hard disk or an SSD: %v", dev, err))
+	case !rotational:
+		caveats = append(caveats, fmt.Sprintf("device %s is an SSD or flash device: wear levelling may keep copies of the old data", dev))
+	}
+	return caveats
+}
+
+// rotationalDevice reports whether the block device major:minor is a spinning disk, from sysfs.
+// A partition takes the value of its disk.
+func rotationalDevice(dev string) (bool, error) {
+	sysPath, err := filepath.EvalSymlinks(filepath.Join("/sys/dev/block", dev))
+	if err != nil {
+		return false, err
+	}
+	for _, dir := range []string{sysPath, filepath.Dir(sysPath)} {
+		data, err := os.ReadFile(filepath.Join(dir, "queue", "rotational"))
+		if err == nil {
+			return strings.TrimSpace(string(data)) == "1", nil
+		}
+	}
+	return false, fmt.Errorf("no queue/rotational for %s", sysPath)
+}
+
+// hardLinks returns the link count of a file.
+func hardLinks(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package shred
+
+import (
+	"os"
+	"runtime"
+)
+
+// storageCaveats cannot inspect the storage outside Linux, so deletion is never reported as guaranteed.
+func storageCaveats(f *os.File) []string {
+	if runtime.GOOS == "darwin" {
+		return []string{"APFS is copy-on-write and Macs use SSDs: overwrites go to new blocks"}
+	}
+	return []string{"the filesystem and storage device cannot be inspected on " + runtime.GOOS + "; copy-on-write filesystems and SSDs may keep the old data"}
+}
+
+// hardLinks is not inspected outside Linux.
+func hardLinks(info os.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,71 @@
+// Package truststore installs CA certificates into the operating system's trust store, so that
+// browsers and tools using the system roots trust certificates issued under them.
+//
+// It drives the platform tools rather than the stores themselves: update-ca-certificates and its
+// equivalents on Linux, security on macOS and certutil on Windows. Applications with their own
+// stores, such as Firefox (NSS) and Java (cacerts), are not covered.
+package truststore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fileName names cert in stores that hold one file per certificate. The fingerprint prefix makes
+// the name unique to the certificate, so uninstalling finds exactly what was installed.
+func fileName(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	name := strings.Trim(unsafeChars.ReplaceAllString(cert.Subject.CommonName, "_"), "._")
+	if name == "" {
+		name = "ca"
+	}
+	return fmt.Sprintf("gosec-%s-%s", name, hex.EncodeToString(sum[:8]))
+}
+
+func encodePEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// withTempFile writes cert to a temporary PEM file for tools that only read files.
+func withTempFile(cert *x509.Certificate, f func(path string) error) error {
+	dir, err := os.MkdirTemp("", "gosec-trust-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, fileName(cert)+".pem")
+	if err := os.WriteFile(path, encodePEM(cert), 0644); err != nil {
+		return fmt.Errorf("failed to write '%s': %w", path, err)
+	}
+	return f(path)
+}
+
+// run executes a platform tool, returning its error output on failure.
+func run(name string, args ...string) error {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return fmt.Errorf("%s not found: %w", name, err)
+	}
+	cmd := exec.Command(path, args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, msg)
+		}
+		return fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package truststore
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+)
+
+const systemKeychain = "/Library/Keychains/System.keychain"
+
+// Install adds cert to the System keychain, trusted as a root for all users, and returns the keychain.
+// security asks for an administrator's password when not run as root.
+func Install(cert *x509.Certificate) (string, error) {
+	err := withTempFile(cert, func(path string) error {
+		return run("security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", systemKeychain, path)
+	})
+	if err != nil {
+		return "", err
+	}
+	return systemKeychain, nil
+}
+
+// Uninstall removes cert and its trust settings from the System keychain.
+func Uninstall(cert *x509.Certificate) (string, error) {
+	// Trust settings may be absent if the certificate was added some other way
+	_ = withTempFile(cert, func(path string) error {
+		return run("security", "remove-trusted-cert", "-d", path)
+	})
+	sum := sha1.Sum(cert.Raw)
+	if err := run("security", "delete-certificate", "-Z", strings.ToUpper(hex.EncodeToString(sum[:])), systemKeychain); err != nil {
+		return "", err
+	}
+	return systemKeychain, nil
+}
@@ -0,0 +1,94 @@
+package truststore
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// linuxStore is an anchor directory and the command that rebuilds the system bundle from it.
+type linuxStore struct {
+	dir    string
+	ext    string
+	update []string
+}
+
+// linuxStores are tried in order; the first whose directory and tool exist is used.
+var linuxStores = []linuxStore{
+	{"/usr/local/share/ca-certificates", ".crt", []string{"update-ca-certificates"}},           // Debian, Ubuntu, Alpine
+	{"/etc/pki/ca-trust/source/anchors", ".pem", []string{"update-ca-trust", "extract"}},       // Fedora, RHEL
+	{"/etc/ca-certificates/trust-source/anchors", ".crt", []string{"trust", "extract-compat"}}, // Arch
+	{"/usr/share/pki/trust/anchors", ".pem", []string{"update-ca-certificates"}},               // openSUSE
+}
+
+func systemStore() (*linuxStore, error) {
+	for i, s := range linuxStores {
+		if fi, err := os.Stat(s.dir); err != nil || !fi.IsDir() {
+			continue
+		}
+		if _, err := exec.LookPath(s.update[0]); err != nil {
+			continue
+		}
+		return &linuxStores[i], nil
+	}
+	return nil, errors.New("no supported system trust store found (expected ca-certificates, ca-trust or p11-kit)")
+}
+
+func checkRoot() error {
+	if os.Geteuid() != 0 {
+		return errors.New("changing the system trust store needs root; run the command with sudo")
+	}
+	return nil
+}
+
+// Install adds cert to the system trust store and returns where it was put.
+func Install(cert *x509.Certificate) (string, error) {
+	s, err := systemStore()
+	if err != nil {
+		return "", err
+	}
+	if err := checkRoot(); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.dir, fileName(cert)+s.ext)
+	if err := os.WriteFile(path, encodePEM(cert), 0644); err != nil {
+		return "", fmt.Errorf("failed to write '%s': %w", path, err)
+	}
+	if err := run(s.update[0], s.update[1:]...); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Uninstall removes a certificate added by Install and returns where it was.
+func Uninstall(cert *x509.Certificate) (string, error) {
+	s, err := systemStore()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.dir, fileName(cert)+s.ext)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("'%s' is not installed in %s", cert.Subject, s.dir)
+	}
+	if err := checkRoot(); err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("failed to remove '%s': %w", path, err)
+	}
+	if err := run(s.update[0], append(s.update[1:], updateFreshArgs(s)...)...); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// updateFreshArgs makes update-ca-certificates also drop the links of removed certificates.
+func updateFreshArgs(s *linuxStore) []string {
+	if s.update[0] == "update-ca-certificates" {
+		return []string{"--fresh"}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !windows
+
+package truststore
+
+import (
+	"crypto/x509"
+	"fmt"
+	"runtime"
+)
+
+// Install is not supported on this platform.
+func Install(cert *x509.Certificate) (string, error) {
+	return "", fmt.Errorf("installing into the trust store is not supported on %s", runtime.GOOS)
+}
+
+// Uninstall is not supported on this platform.
+func Uninstall(cert *x509.Certificate) (string, error) {
+	return "", fmt.Errorf("removing from the trust store is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,28 @@
+package truststore
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+)
+
+const rootStore = `LocalMachine\Root`
+
+// Install adds cert to the machine's Trusted Root Certification Authorities; this needs an
+// elevated (administrator) prompt.
+func Install(cert *x509.Certificate) (string, error) {
+	err := withTempFile(cert, func(path string) error {
+		return run("certutil", "-addstore", "-f", "Root", path)
+	})
+	if err != nil {
+		return "", err
+	}
+	return rootStore, nil
+}
+
+// Uninstall removes cert, found by its serial number, from the machine's root store.
+func Uninstall(cert *x509.Certificate) (string, error) {
+	if err := run("certutil", "-delstore", "Root", hex.EncodeToString(cert.SerialNumber.Bytes())); err != nil {
+		return "", err
+	}
+	return rootStore, nil
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Clock supplies the current time used to compute certificate validity periods.
+type Clock interface {
+	Now() (time.Time, error)
+}
+
+// SystemClock reads the local system clock.
+type SystemClock struct{}
+
+// Now returns the local time.
+func (SystemClock) Now() (time.Time, error) { return time.Now(), nil }
+
+// FixedClock always returns the same instant, e.g. a time taken from a verified time token.
+type FixedClock time.Time
+
+// Now returns the fixed instant.
+func (c FixedClock) Now() (time.Time, error) { return time.Time(c), nil }
+
+// IssuanceClock is the clock used for all issuance. It defaults to the local system clock.
+var IssuanceClock Clock = SystemClock{}
+
+// Now returns the current time according to IssuanceClock.
+func Now() (time.Time, error) {
+	t, err := IssuanceClock.Now()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read issuance clock: %w", err)
+	}
+	return t, nil
+}
+
+const timeTokenPEMType = "GOSEC TIME TOKEN"
+
+// timeTokenSignedData is the byte string covered by a time token signature.
+func timeTokenSignedData(t time.Time) []byte {
+	return append([]byte("gosec-time-v1\x00"), t.UTC().Format(time.RFC3339Nano)...)
+}
+
+// CreateTimeToken produces a PEM time token asserting the given time, signed by a time authority key.
+// The token is meant to be created on a machine with a trusted clock and carried to an air-gapped host.
+func CreateTimeToken(t time.Time, signer crypto.Signer) ([]byte, error) {
+	digest := sha256.Sum256(timeTokenSignedData(t))
+	sig, err := signer.Sign(Rand, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign time token: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    timeTokenPEMType,
+		Headers: map[string]string{"Time": t.UTC().Format(time.RFC3339Nano)},
+		Bytes:   sig,
+	}), nil
+}
+
+// VerifyTimeToken checks a PEM time token against the time authority certificate and returns the asserted time.
+func VerifyTimeToken(data []byte, authority *x509.Certificate) (time.Time, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != timeTokenPEMType {
+		return time.Time{}, errors.New("failed to decode PEM block containing time token")
+	}
+	t, err := time.Parse(time.RFC3339Nano, block.Headers["Time"])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time in token: %w", err)
+	}
+	pub, ok := authority.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return time.Time{}, fmt.Errorf("unsupported time authority key type %T", authority.PublicKey)
+	}
+	digest := sha256.Sum256(timeTokenSignedData(t))
+	if !ecdsa.VerifyASN1(pub, digest[:], block.Bytes) {
+		return time.Time{}, errors.New("time token signature is not valid for the given time authority")
+	}
+	if t.Before(authority.NotBefore) || t.After(authority.NotAfter) {
+		return time.Time{}, errors.New("time token lies outside the time authority certificate's validity period")
+	}
+	return t, nil
+}
+
+// LoadTimeTokenClock reads and verifies a time token file and returns a clock fixed at the asserted time.
+func LoadTimeTokenClock(tokenPath, authorityPath string) (Clock, time.Time, error) {
+	authority, err := ParseCertificateFromFile(authorityPath)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to parse time authority certificate: %w", err)
+	}
+	data, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to read time token '%s': %w", tokenPath, err)
+	}
+	t, err := VerifyTimeToken(data, authority)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("time token '%s' rejected: %w", tokenPath, err)
+	}
+	return FixedClock(t), t, nil
+}
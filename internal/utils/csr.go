@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// csrExtensionNames names extensions commonly requested in CSRs.
+var csrExtensionNames = map[string]string{
+	"2.5.29.14": "subjectKeyIdentifier",
+	"2.5.29.15": "keyUsage",
+	"2.5.29.17": "subjectAltName",
+	"2.5.29.19": "basicConstraints",
+	"2.5.29.37": "extKeyUsage",
+}
+
+// DescribeCSR lists what csr asks for, one " - Field: value" line each, including whether its self-signature is valid.
+func DescribeCSR(csr *x509.CertificateRequest) []string {
+	lines := []string{" - Subject: " + csr.Subject.String()}
+	if sans := SANsFromCSR(csr); sans.Empty() {
+		lines = append(lines, " - SANs: none")
+	} else {
+		lines = append(lines, " - SANs: "+strings.Join(sans.Strings(), ", "))
+	}
+	lines = append(lines,
+		" - Public key: "+DescribePublicKey(csr.PublicKey),
+		" - Signature algorithm: "+csr.SignatureAlgorithm.String(),
+	)
+	if err := csr.CheckSignature(); err != nil {
+		lines = append(lines, fmt.Sprintf(" - Signature: INVALID (%v)", err))
+	} else {
+		lines = append(lines, " - Signature: valid")
+	}
+	for _, ext := range csr.Extensions {
+		lines = append(lines, fmt.Sprintf(" - Requested extension: %s (critical: %v)", CSRExtensionName(ext.Id), ext.Critical))
+	}
+	return lines
+}
+
+// CSRExtensionName returns the name of an extension commonly requested in CSRs, or else its OID.
+func CSRExtensionName(id asn1.ObjectIdentifier) string {
+	if name, ok := csrExtensionNames[id.String()]; ok {
+		return name
+	}
+	return id.String()
+}
+
+// CSRWarnings returns points of csr worth a second look before signing it.
+func CSRWarnings(csr *x509.CertificateRequest) []string {
+	var warnings []string
+	if csr.Subject.CommonName == "" && SANsFromCSR(csr).Empty() {
+		warnings = append(warnings, "the CSR names neither a common name nor any SAN")
+	}
+	if cn := csr.Subject.CommonName; cn != "" && len(csr.DNSNames) > 0 && !slices.Contains(csr.DNSNames, cn) {
+		warnings = append(warnings, fmt.Sprintf("the common name '%s' is not among the DNS SANs", cn))
+	}
+	if rsaKey, ok := csr.PublicKey.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < 2048 {
+		warnings = append(warnings, fmt.Sprintf("RSA key of %d bits is too weak", rsaKey.N.BitLen()))
+	}
+	return warnings
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"my-pki/internal/secmem"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Envelope shares split a random key-encryption key (KEK) instead of the CA key itself. The CA key
+// is encrypted under the KEK and kept in a wrapped key file next to the certificate, which can be
+// backed up separately: it is useless without a quorum.
+
+const (
+	envelopeScheme       = "envelope-aes256gcm"
+	wrappedKeyPEMType    = "GOSEC WRAPPED KEY"
+	envelopeKEKSize      = 32
+	wrappedKeyAADContext = "GoSeC wrapped key v1\n"
+)
+
+// WrappedKeyFile, when set, is read instead of the wrapped key file recorded in envelope shares.
+var WrappedKeyFile string
+
+// WrappedKeyPathForCA returns the default wrapped key file of a CA certificate, next to it.
+func WrappedKeyPathForCA(caPemPath string) string {
+	return strings.TrimSuffix(caPemPath, filepath.Ext(caPemPath)) + ".wrapped-key.pem"
+}
+
+// WithEnvelope splits a random KEK instead of the key and writes the key, encrypted under the KEK,
+// to wrappedKeyOut. Shares are then small and the same size whatever the key.
+func WithEnvelope(wrappedKeyOut string) SplitOption {
+	return func(c *splitConfig) { c.wrappedKeyOut = wrappedKeyOut }
+}
+
+// wrappedKeyAAD binds the wrapped key to its key and split identifiers.
+func wrappedKeyAAD(keyID, splitID string) []byte {
+	return []byte(wrappedKeyAADContext + keyID + "\n" + splitID + "\n")
+}
+
+func kekCipher(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeWrappedKey encrypts keyBytes under a fresh KEK, writes it to path and returns the KEK.
+func writeWrappedKey(path string, keyBytes []byte, keyID, splitID string) ([]byte, error) {
+	kek := make([]byte, envelopeKEKSize)
+	secmem.Lock(kek)
+	if _, err := io.ReadFull(Rand, kek); err != nil {
+		return nil, fmt.Errorf("failed to generate key-encryption key: %w", err)
+	}
+	aead, err := kekCipher(kek)
+	if err != nil {
+		secmem.Wipe(kek)
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(Rand, nonce); err != nil {
+		secmem.Wipe(kek)
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{
+		Type: wrappedKeyPEMType,
+		Headers: map[string]string{
+			"Key-Id":     keyID,
+			"Split-Id":   splitID,
+			"Encryption": envelopeScheme,
+		},
+		Bytes: aead.Seal(nonce, nonce, keyBytes, wrappedKeyAAD(keyID, splitID)),
+	})
+	// Useless without a quorum, but still key material: it gets the permissions of shares
+	if err := writeOutputFile(path, data, Output.Shares, DefaultShareMode); err != nil {
+		secmem.Wipe(kek)
+		return nil, fmt.Errorf("failed to write wrapped key '%s': %w", path, err)
+	}
+	return kek, nil
+}
+
+// openEnvelope decrypts the wrapped key of envelope shares with the KEK they combine to. A wrong
+// KEK yields nil without error, as a wrong key would, so the caller can look for the bad share.
+func openEnvelope(shares []*Share, kek []byte) ([]byte, error) {
+	path := WrappedKeyFile
+	for _, s := range shares {
+		if path == "" {
+			path = s.WrappedKey
+		}
+	}
+	if path == "" {
+		return nil, errors.New("these shares protect a wrapped key but do not say where it is; give its file with --wrapped-key")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wrapped key '%s': %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != wrappedKeyPEMType {
+		return nil, fmt.Errorf("'%s' is not a wrapped key file", path)
+	}
+	if enc := block.Headers["Encryption"]; enc != envelopeScheme {
+		return nil, fmt.Errorf("unsupported wrapped key encryption '%s'", enc)
+	}
+	keyID, splitID := block.Headers["Key-Id"], block.Headers["Split-Id"]
+	if s := shares[0]; keyID != s.KeyID || splitID != s.SplitID {
+		return nil, fmt.Errorf("'%s' wraps key %s (split %s), not key %s (split %s) of the shares", path, keyID, splitID, s.KeyID, s.SplitID)
+	}
+	if len(kek) != envelopeKEKSize {
+		return nil, nil
+	}
+	aead, err := kekCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(block.Bytes) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key '%s' is truncated", path)
+	}
+	nonce, ciphertext := block.Bytes[:aead.NonceSize()], block.Bytes[aead.NonceSize():]
+	keyBytes, err := aead.Open(nil, nonce, ciphertext, wrappedKeyAAD(keyID, splitID))
+	if err != nil {
+		return nil, nil
+	}
+	secmem.Lock(keyBytes)
+	return keyBytes, nil
+}
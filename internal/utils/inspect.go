@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// certExtensionNames names the certificate extensions InspectCertificate knows.
+var certExtensionNames = map[string]string{
+	"2.5.29.14":               "Subject Key Identifier",
+	"2.5.29.15":               "Key Usage",
+	"2.5.29.17":               "Subject Alternative Name",
+	"2.5.29.19":               "Basic Constraints",
+	"2.5.29.30":               "Name Constraints",
+	"2.5.29.31":               "CRL Distribution Points",
+	"2.5.29.32":               "Certificate Policies",
+	"2.5.29.35":               "Authority Key Identifier",
+	"2.5.29.37":               "Extended Key Usage",
+	"2.5.29.46":               "Freshest CRL",
+	"1.3.6.1.5.5.7.1.1":       "Authority Information Access",
+	"1.3.6.1.5.5.7.48.1.5":    "OCSP No Check",
+	"1.3.6.1.4.1.11129.2.4.2": "CT Signed Certificate Timestamps",
+	"1.3.6.1.4.1.11129.2.4.3": "CT Precertificate Poison",
+}
+
+// CertificateInfo is what InspectCertificate reports about a certificate.
+type CertificateInfo struct {
+	Version            int             `json:"version"`
+	Serial             string          `json:"serial"` // hex
+	SignatureAlgorithm string          `json:"signature_algorithm"`
+	Issuer             string          `json:"issuer"`
+	Subject            string          `json:"subject"`
+	NotBefore          time.Time       `json:"not_before"`
+	NotAfter           time.Time       `json:"not_after"`
+	PublicKey          string          `json:"public_key"`
+	IsCA               bool            `json:"is_ca"`
+	SANs               []string        `json:"sans,omitempty"`
+	Extensions         []ExtensionInfo `json:"extensions"`
+	SHA256             string          `json:"sha256"`
+	SHA1               string          `json:"sha1"`
+	SPKISHA256         string          `json:"spki_sha256"` // base64, as used for public key pinning
+}
+
+// ExtensionInfo describes one extension of a certificate; Values are empty for extensions without a decoder.
+type ExtensionInfo struct {
+	Name     string   `json:"name"`
+	OID      string   `json:"oid"`
+	Critical bool     `json:"critical"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// InspectCertificate describes cert: its names, validity, key, extensions and fingerprints.
+func InspectCertificate(cert *x509.Certificate) *CertificateInfo {
+	sha := sha256.Sum256(cert.Raw)
+	sha1Sum := sha1.Sum(cert.Raw)
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	info := &CertificateInfo{
+		Version:            cert.Version,
+		Serial:             hex.EncodeToString(cert.SerialNumber.Bytes()),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		Issuer:             cert.Issuer.String(),
+		Subject:            cert.Subject.String(),
+		NotBefore:          cert.NotBefore.UTC(),
+		NotAfter:           cert.NotAfter.UTC(),
+		PublicKey:          DescribePublicKey(cert.PublicKey),
+		IsCA:               cert.IsCA,
+		SANs:               SANs{DNSNames: cert.DNSNames, IPAddresses: cert.IPAddresses, EmailAddresses: cert.EmailAddresses, URIs: cert.URIs}.Strings(),
+		Extensions:         []ExtensionInfo{},
+		SHA256:             hex.EncodeToString(sha[:]),
+		SHA1:               hex.EncodeToString(sha1Sum[:]),
+		SPKISHA256:         base64.StdEncoding.EncodeToString(spki[:]),
+	}
+	for _, ext := range cert.Extensions {
+		name, ok := certExtensionNames[ext.Id.String()]
+		if !ok {
+			name = "Unknown extension"
+		}
+		info.Extensions = append(info.Extensions, ExtensionInfo{
+			Name:     name,
+			OID:      ext.Id.String(),
+			Critical: ext.Critical,
+			Values:   extensionValues(cert, ext.Id),
+		})
+	}
+	return info
+}
+
+// extensionValues renders the parsed content of the extension id of cert, one entry per value.
+func extensionValues(cert *x509.Certificate, id asn1.ObjectIdentifier) []string {
+	switch id.String() {
+	case "2.5.29.14":
+		return []string{colonHex(cert.SubjectKeyId)}
+	case "2.5.29.35":
+		return []string{colonHex(cert.AuthorityKeyId)}
+	case "2.5.29.15":
+		return KeyUsageNames(cert.KeyUsage)
+	case "2.5.29.37":
+		names := ExtKeyUsageNames(cert.ExtKeyUsage)
+		for _, oid := range cert.UnknownExtKeyUsage {
+			names = append(names, oid.String())
+		}
+		return names
+	case "2.5.29.19":
+		v := "CA:FALSE"
+		if cert.IsCA {
+			v = "CA:TRUE"
+		}
+		if cert.IsCA && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+			v += fmt.Sprintf(", pathlen:%d", cert.MaxPathLen)
+		}
+		return []string{v}
+	case "2.5.29.17":
+		var names []string
+		for _, n := range cert.DNSNames {
+			names = append(names, "DNS:"+n)
+		}
+		for _, ip := range cert.IPAddresses {
+			names = append(names, "IP:"+ip.String())
+		}
+		for _, e := range cert.EmailAddresses {
+			names = append(names, "email:"+e)
+		}
+		for _, u := range cert.URIs {
+			names = append(names, "URI:"+u.String())
+		}
+		return names
+	case "2.5.29.32":
+		var oids []string
+		for _, oid := range cert.Policies {
+			oids = append(oids, "Policy: "+oid.String())
+		}
+		return oids
+	case "2.5.29.31":
+		return prefixed("URI:", cert.CRLDistributionPoints)
+	case "1.3.6.1.5.5.7.1.1":
+		return append(prefixed("OCSP - URI:", cert.OCSPServer), prefixed("CA Issuers - URI:", cert.IssuingCertificateURL)...)
+	case "2.5.29.30":
+		var v []string
+		v = append(v, prefixed("Permitted DNS:", cert.PermittedDNSDomains)...)
+		for _, ip := range cert.PermittedIPRanges {
+			v = append(v, "Permitted IP:"+ip.String())
+		}
+		v = append(v, prefixed("Permitted email:", cert.PermittedEmailAddresses)...)
+		v = append(v, prefixed("Permitted URI:", cert.PermittedURIDomains)...)
+		v = append(v, prefixed("Excluded DNS:", cert.ExcludedDNSDomains)...)
+		for _, ip := range cert.ExcludedIPRanges {
+			v = append(v, "Excluded IP:"+ip.String())
+		}
+		v = append(v, prefixed("Excluded email:", cert.ExcludedEmailAddresses)...)
+		v = append(v, prefixed("Excluded URI:", cert.ExcludedURIDomains)...)
+		return v
+	}
+	return nil
+}
+
+// Lines renders info like openssl x509 -text, relative to now for the expiry.
+func (info *CertificateInfo) Lines(now time.Time) []string {
+	lines := []string{
+		fmt.Sprintf("Version: %d", info.Version),
+		"Serial Number: " + colonHexString(info.Serial),
+		"Signature Algorithm: " + info.SignatureAlgorithm,
+		"Issuer: " + info.Issuer,
+		"Validity",
+		"    Not Before: " + info.NotBefore.Format(time.RFC3339),
+		"    Not After : " + info.NotAfter.Format(time.RFC3339) + " (" + describeExpiry(info.NotAfter, now) + ")",
+		"Subject: " + info.Subject,
+		"Subject Public Key Info: " + info.PublicKey,
+	}
+	if len(info.Extensions) > 0 {
+		lines = append(lines, "X509v3 extensions:")
+	}
+	for _, ext := range info.Extensions {
+		header := "    " + ext.Name
+		if ext.Name == "Unknown extension" {
+			header = "    " + ext.OID
+		}
+		if ext.Critical {
+			header += ": critical"
+		} else {
+			header += ":"
+		}
+		lines = append(lines, header)
+		if len(ext.Values) == 0 {
+			continue
+		}
+		if ext.Name == "Key Usage" || ext.Name == "Extended Key Usage" || ext.Name == "Subject Alternative Name" {
+			lines = append(lines, "        "+strings.Join(ext.Values, ", "))
+			continue
+		}
+		for _, v := range ext.Values {
+			lines = append(lines, "        "+v)
+		}
+	}
+	return append(lines,
+		"Fingerprints",
+		"    SHA-256: "+colonHexString(info.SHA256),
+		"    SHA-1  : "+colonHexString(info.SHA1),
+		"    SPKI SHA-256 (pin): "+info.SPKISHA256,
+	)
+}
+
+// describeExpiry says how long until, or since, notAfter.
+func describeExpiry(notAfter, now time.Time) string {
+	days := int(notAfter.Sub(now).Hours() / 24)
+	switch {
+	case !now.Before(notAfter):
+		return fmt.Sprintf("expired %d days ago", -days)
+	case days == 0:
+		return "expires within a day"
+	default:
+		return fmt.Sprintf("expires in %d days", days)
+	}
+}
+
+// colonHex formats b as upper-case hex bytes separated by colons, as openssl does.
+func colonHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02X", c)
+	}
+	return strings.Join(parts, ":")
+}
+
+// colonHexString reformats a hex string with colonHex.
+func colonHexString(s string) string {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return s
+	}
+	return colonHex(b)
+}
+
+// prefixed returns each of values with prefix.
+func prefixed(prefix string, values []string) []string {
+	var out []string
+	for _, v := range values {
+		out = append(out, prefix+v)
+	}
+	return out
+}
@@ -0,0 +1,480 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseFunc is called to obtain a passphrase when an encrypted private key is encountered.
+type PassphraseFunc func() ([]byte, error)
+
+// ErrIncorrectPassphrase is returned when an encrypted private key cannot be decrypted.
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase or corrupted key")
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algo          pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// scryptParams follows RFC 7914 section 7.1.
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// scrypt cost parameters used when encrypting keys. These match OpenSSL's defaults
+// (N=2^14, r=8, p=1, 16 MiB) so encrypted keys remain readable by `openssl pkey`.
+const (
+	scryptN = 1 << 14
+	scryptR = 8
+	scryptP = 1
+)
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// Supported private key output formats.
+const (
+	KeyFormatSEC1  = "sec1"
+	KeyFormatPKCS8 = "pkcs8"
+)
+
+// ParsePrivateKeyDER parses a DER-encoded ECDSA private key in either SEC1 or PKCS#8 form.
+func ParsePrivateKeyDER(der []byte) (*ecdsa.PrivateKey, error) {
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("failed to parse private key as SEC1 or PKCS#8")
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T (only ECDSA is supported)", key)
+	}
+	return ecKey, nil
+}
+
+// MarshalPrivateKeyPEM encodes an ECDSA private key as PEM in the given format ("sec1" or "pkcs8").
+func MarshalPrivateKeyPEM(privKey *ecdsa.PrivateKey, format string) ([]byte, error) {
+	switch format {
+	case KeyFormatSEC1, "":
+		der, err := x509.MarshalECPrivateKey(privKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal ECDSA private key: %w", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case KeyFormatPKCS8:
+		der, err := x509.MarshalPKCS8PrivateKey(privKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal PKCS#8 private key: %w", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	default:
+		return nil, fmt.Errorf("unknown key format '%s' (expected %s or %s)", format, KeyFormatSEC1, KeyFormatPKCS8)
+	}
+}
+
+// WritePrivateKeyToFile writes an ECDSA private key to a file in PEM format using the given format.
+func WritePrivateKeyToFile(privKey *ecdsa.PrivateKey, outPath, format string) error {
+	pemBytes, err := MarshalPrivateKeyPEM(privKey, format)
+	if err != nil {
+		return err
+	}
+	return writeOutputFile(outPath, pemBytes, Output.Keys, DefaultKeyMode)
+}
+
+// EncryptPrivateKeyPEM encodes an ECDSA private key as an encrypted PKCS#8 PEM block
+// ("ENCRYPTED PRIVATE KEY") using PBES2 with scrypt key derivation and AES-256-CBC.
+func EncryptPrivateKeyPEM(privKey *ecdsa.PrivateKey, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal PKCS#8 private key: %w", err)
+	}
+
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(Rand, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	if _, err := io.ReadFull(Rand, iv); err != nil {
+		return nil, fmt.Errorf("failed to generate IV: %w", err)
+	}
+
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise cipher: %w", err)
+	}
+	padLen := aes.BlockSize - len(der)%aes.BlockSize
+	plain := append(der, bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+
+	kdfParams, err := asn1.Marshal(scryptParams{
+		Salt:                     salt,
+		CostParameter:            scryptN,
+		BlockSize:                scryptR,
+		ParallelizationParameter: scryptP,
+		KeyLength:                32,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	encParams, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	infoDER, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algo:          pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: encParams}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal encrypted private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: infoDER}), nil
+}
+
+// WriteEncryptedPrivateKeyToFile writes an ECDSA private key to a file as passphrase-encrypted PKCS#8.
+func WriteEncryptedPrivateKeyToFile(privKey *ecdsa.PrivateKey, outPath string, passphrase []byte) error {
+	pemBytes, err := EncryptPrivateKeyPEM(privKey, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeOutputFile(outPath, pemBytes, Output.Keys, DefaultKeyMode)
+}
+
+// WritePKCS12ToFile writes a PKCS#12 file, which holds a private key, with the key permissions.
+func WritePKCS12ToFile(data []byte, outPath string) error {
+	return writeOutputFile(outPath, data, Output.Keys, DefaultKeyMode)
+}
+
+// IsEncryptedPEM reports whether a PEM-encoded private key is passphrase protected.
+func IsEncryptedPEM(data []byte) bool {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+	return block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block)
+}
+
+// ParsePrivateKeyPEM parses an ECDSA private key from PEM data. It accepts SEC1 ("EC PRIVATE KEY"),
+// PKCS#8 ("PRIVATE KEY"), encrypted PKCS#8 ("ENCRYPTED PRIVATE KEY") and legacy OpenSSL-encrypted
+// SEC1 keys. The passphrase callback is only invoked for encrypted keys.
+func ParsePrivateKeyPEM(data []byte, passphrase PassphraseFunc) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to decode PEM block containing private key")
+	}
+
+	der := block.Bytes
+	if block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block) {
+		if passphrase == nil {
+			return nil, errors.New("private key is encrypted but no passphrase was provided")
+		}
+		pass, err := passphrase()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			der, err = decryptPKCS8(block.Bytes, pass)
+		} else {
+			// Legacy OpenSSL "Proc-Type: 4,ENCRYPTED" keys are still common for existing CAs.
+			der, err = x509.DecryptPEMBlock(block, pass)
+			if err != nil {
+				err = ErrIncorrectPassphrase
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch block.Type {
+	case "EC PRIVATE KEY", "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+		return ParsePrivateKeyDER(der)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type '%s'", block.Type)
+	}
+}
+
+// LoadPrivateKeyFromFile reads a PEM private key from file, prompting for a passphrase if it is encrypted.
+func LoadPrivateKeyFromFile(path string, passphrase PassphraseFunc) (*ecdsa.PrivateKey, error) {
+	data, err := ReadInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key file '%s': %w", path, err)
+	}
+	key, err := ParsePrivateKeyPEM(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private key '%s': %w", path, err)
+	}
+	return key, nil
+}
+
+// decryptPKCS8 decrypts a PBES2-protected EncryptedPrivateKeyInfo and returns the inner PKCS#8 DER.
+func decryptPKCS8(der, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private key: %w", err)
+	}
+	if !info.Algo.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption algorithm %v (only PBES2 is supported)", info.Algo.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse PBES2 parameters: %w", err)
+	}
+
+	var newCipher func([]byte) (cipher.Block, error)
+	var keyLen int
+	switch enc := params.EncryptionScheme.Algorithm; {
+	case enc.Equal(oidAES128CBC):
+		newCipher, keyLen = aes.NewCipher, 16
+	case enc.Equal(oidAES192CBC):
+		newCipher, keyLen = aes.NewCipher, 24
+	case enc.Equal(oidAES256CBC):
+		newCipher, keyLen = aes.NewCipher, 32
+	case enc.Equal(oidDESEDE3CBC):
+		newCipher, keyLen = des.NewTripleDESCipher, 24
+	default:
+		return nil, fmt.Errorf("unsupported PBES2 cipher %v", enc)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("failed to parse cipher IV: %w", err)
+	}
+
+	key, err := deriveKeyPBES2(params.KeyDerivationFunc, password, keyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise cipher: %w", err)
+	}
+	if len(iv) != block.BlockSize() || len(info.EncryptedData)%block.BlockSize() != 0 {
+		return nil, errors.New("malformed encrypted private key")
+	}
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+	return unpadPKCS7(plain, block.BlockSize())
+}
+
+// deriveKeyPBES2 runs the key derivation function named in a PBES2 parameter block.
+func deriveKeyPBES2(kdf pkix.AlgorithmIdentifier, password []byte, keyLen int) ([]byte, error) {
+	if kdf.Algorithm.Equal(oidScrypt) {
+		var params scryptParams
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("failed to parse scrypt parameters: %w", err)
+		}
+		if params.KeyLength != 0 && params.KeyLength != keyLen {
+			return nil, fmt.Errorf("scrypt key length %d does not match cipher key length %d", params.KeyLength, keyLen)
+		}
+		key, err := scrypt.Key(password, params.Salt, params.CostParameter, params.BlockSize, params.ParallelizationParameter, keyLen)
+		if err != nil {
+			return nil, fmt.Errorf("scrypt key derivation failed: %w", err)
+		}
+		return key, nil
+	}
+	if !kdf.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %v", kdf.Algorithm)
+	}
+	var params pbkdf2Params
+	if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse PBKDF2 parameters: %w", err)
+	}
+	if params.KeyLength != 0 && params.KeyLength != keyLen {
+		return nil, fmt.Errorf("PBKDF2 key length %d does not match cipher key length %d", params.KeyLength, keyLen)
+	}
+
+	var h func() hash.Hash
+	switch prf := params.PRF.Algorithm; {
+	case len(prf) == 0, prf.Equal(oidHMACWithSHA1):
+		h = sha1.New
+	case prf.Equal(oidHMACWithSHA256):
+		h = sha256.New
+	case prf.Equal(oidHMACWithSHA384):
+		h = sha512.New384
+	case prf.Equal(oidHMACWithSHA512):
+		h = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 PRF %v", prf)
+	}
+	return pbkdf2.Key(password, params.Salt, params.IterationCount, keyLen, h), nil
+}
+
+// unpadPKCS7 strips and validates PKCS#7 padding. A bad pad almost always means a wrong passphrase.
+func unpadPKCS7(data []byte, blockSize int) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrIncorrectPassphrase
+	}
+	n := int(data[len(data)-1])
+	if n == 0 || n > blockSize || n > len(data) {
+		return nil, ErrIncorrectPassphrase
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, ErrIncorrectPassphrase
+		}
+	}
+	return data[:len(data)-n], nil
+}
+
+// ParsePublicKeyFile reads a public key to be certified from a PEM file. It accepts a bare
+// "PUBLIC KEY" block or a PKCS#10 "CERTIFICATE REQUEST", whose self-signature is checked.
+func ParsePublicKeyFile(path string) (crypto.PublicKey, error) {
+	data, err := ReadInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read public key file '%s': %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block in '%s'", path)
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		return pub, nil
+	case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+		csr, err := parseCSR(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return csr.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type '%s' (expected PUBLIC KEY or CERTIFICATE REQUEST)", block.Type)
+	}
+}
+
+// WriteCSRToFile writes a PEM certificate request with the certificate permissions.
+func WriteCSRToFile(csrPEM []byte, outPath string) error {
+	return writeOutputFile(outPath, csrPEM, Output.Certs, DefaultCertMode)
+}
+
+// ParseCSRFile reads a PEM PKCS#10 certificate request and checks its self-signature.
+func ParseCSRFile(path string) (*x509.CertificateRequest, error) {
+	csr, err := ReadCSRFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("certificate request signature is invalid: %w", err)
+	}
+	return csr, nil
+}
+
+// ReadCSRFile reads a PEM PKCS#10 certificate request without checking its signature, for inspection.
+func ReadCSRFile(path string) (*x509.CertificateRequest, error) {
+	data, err := ReadInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CSR file '%s': %w", path, err)
+	}
+	csr, err := DecodeCSRPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w in '%s'", err, path)
+	}
+	return csr, nil
+}
+
+// DecodeCSRPEM parses a PEM PKCS#10 certificate request without checking its signature.
+func DecodeCSRPEM(data []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || (block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST") {
+		return nil, errors.New("failed to decode PEM block containing a certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate request: %w", err)
+	}
+	return csr, nil
+}
+
+// DescribePublicKey names the algorithm and size of pub, e.g. "ECDSA P-256" or "RSA 2048".
+func DescribePublicKey(pub crypto.PublicKey) string {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA " + k.Curve.Params().Name
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", k.N.BitLen())
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
+
+func parseCSR(der []byte) (*x509.CertificateRequest, error) {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("certificate request signature is invalid: %w", err)
+	}
+	return csr, nil
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Normalization selects the rewrites applied to subject and SAN names before policy checks and
+// issuance, so the same name is always issued, logged and inventoried in the same form.
+type Normalization struct {
+	LowercaseHostnames bool `yaml:"lowercase_hostnames"` // DNS SANs, host-like common names, URI hosts and e-mail domains
+	StripTrailingDots  bool `yaml:"strip_trailing_dots"` // "www.example.com." -> "www.example.com"
+	CanonicalIPs       bool `yaml:"canonical_ips"`       // IPv4-mapped IPv6 addresses ("::ffff:10.0.0.1") as IPv4
+	Deduplicate        bool `yaml:"deduplicate"`         // drop repeated SANs, compared after the other rules
+	TrimSubject        bool `yaml:"trim_subject"`        // trim whitespace around subject values and drop empty ones
+}
+
+// DefaultNormalization applies every rule. It is used when a CA profile does not configure normalization.
+var DefaultNormalization = Normalization{
+	LowercaseHostnames: true,
+	StripTrailingDots:  true,
+	CanonicalIPs:       true,
+	Deduplicate:        true,
+	TrimSubject:        true,
+}
+
+// hostname normalizes a DNS name.
+func (n Normalization) hostname(name string) string {
+	if n.StripTrailingDots {
+		name = strings.TrimRight(name, ".")
+	}
+	if n.LowercaseHostnames {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+// SANs returns a normalized copy of sans.
+func (n Normalization) SANs(sans SANs) SANs {
+	var out SANs
+	for _, name := range sans.DNSNames {
+		name = n.hostname(name)
+		if !n.Deduplicate || !slices.Contains(out.DNSNames, name) {
+			out.DNSNames = append(out.DNSNames, name)
+		}
+	}
+	for _, ip := range sans.IPAddresses {
+		if v4 := ip.To4(); n.CanonicalIPs && v4 != nil {
+			ip = v4
+		}
+		if !n.Deduplicate || !slices.ContainsFunc(out.IPAddresses, ip.Equal) {
+			out.IPAddresses = append(out.IPAddresses, ip)
+		}
+	}
+	for _, email := range sans.EmailAddresses {
+		// Only the domain is case-insensitive; the local part belongs to the mail server
+		if at := strings.LastIndex(email, "@"); at >= 0 {
+			email = email[:at+1] + n.hostname(email[at+1:])
+		}
+		if !n.Deduplicate || !slices.Contains(out.EmailAddresses, email) {
+			out.EmailAddresses = append(out.EmailAddresses, email)
+		}
+	}
+	for _, u := range sans.URIs {
+		c := *u
+		if n.LowercaseHostnames {
+			c.Scheme = strings.ToLower(c.Scheme)
+			c.Host = strings.ToLower(c.Host)
+		}
+		if !n.Deduplicate || !slices.ContainsFunc(out.URIs, func(o *url.URL) bool { return o.String() == c.String() }) {
+			out.URIs = append(out.URIs, &c)
+		}
+	}
+	return out
+}
+
+// Subject returns a normalized copy of name. A common name that looks like a hostname is
+// normalized like a DNS SAN.
+func (n Normalization) Subject(name pkix.Name) pkix.Name {
+	if n.TrimSubject {
+		trim := func(values []string) []string {
+			var out []string
+			for _, v := range values {
+				if v = strings.TrimSpace(v); v != "" {
+					out = append(out, v)
+				}
+			}
+			return out
+		}
+		name.CommonName = strings.TrimSpace(name.CommonName)
+		name.SerialNumber = strings.TrimSpace(name.SerialNumber)
+		name.Country = trim(name.Country)
+		name.Organization = trim(name.Organization)
+		name.OrganizationalUnit = trim(name.OrganizationalUnit)
+		name.Locality = trim(name.Locality)
+		name.Province = trim(name.Province)
+		name.StreetAddress = trim(name.StreetAddress)
+		name.PostalCode = trim(name.PostalCode)
+		extra := make([]pkix.AttributeTypeAndValue, 0, len(name.ExtraNames))
+		for _, atv := range name.ExtraNames {
+			if s, ok := atv.Value.(string); ok {
+				if s = strings.TrimSpace(s); s == "" {
+					continue
+				}
+				atv.Value = s
+			}
+			extra = append(extra, atv)
+		}
+		name.ExtraNames = extra
+	}
+	if looksLikeHostname(name.CommonName) {
+		name.CommonName = n.hostname(name.CommonName)
+	}
+	return name
+}
+
+// looksLikeHostname reports whether s is a dotted DNS name (possibly a wildcard) rather than a free-form name.
+func looksLikeHostname(s string) bool {
+	if !strings.Contains(strings.TrimRight(s, "."), ".") || net.ParseIP(s) != nil {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-.*_", r)) {
+			return false
+		}
+	}
+	return true
+}
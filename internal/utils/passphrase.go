@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// stdinReader is shared so that consecutive prompts on piped input do not lose buffered lines.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// ReadPassphrase prints prompt to stderr and reads a passphrase from the terminal without echoing it.
+// When stdin is not a terminal (e.g. piped input), a single line is read from stdin instead.
+func ReadPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// Read as bytes, so the passphrase is not copied into a string that cannot be wiped
+		line, err := stdinReader.ReadBytes('\n')
+		if err != nil && len(line) == 0 {
+			return nil, fmt.Errorf("failed to read passphrase from stdin: %w", err)
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return pass, nil
+}
+
+// StdinIsTerminal reports whether stdin is a terminal, so that an operator can answer prompts.
+func StdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// ReadLine prints prompt to stderr and reads one line of visible input from stdin.
+func ReadLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read from stdin: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// PromptPassphrase returns a PassphraseFunc that asks for the passphrase of the named file.
+func PromptPassphrase(path string) PassphraseFunc {
+	return func() ([]byte, error) {
+		return ReadPassphrase(fmt.Sprintf("Enter passphrase for '%s': ", path))
+	}
+}
+
+// ReadNewPassphrase prompts for a new passphrase twice and checks that both entries match.
+func ReadNewPassphrase(what string) ([]byte, error) {
+	pass, err := ReadPassphrase(fmt.Sprintf("Enter passphrase for %s: ", what))
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	confirm, err := ReadPassphrase(fmt.Sprintf("Confirm passphrase for %s: ", what))
+	if err != nil {
+		return nil, err
+	}
+	defer clear(confirm)
+	if !bytes.Equal(pass, confirm) {
+		clear(pass)
+		return nil, errors.New("passphrases do not match")
+	}
+	return pass, nil
+}
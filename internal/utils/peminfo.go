@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// PEMInfo, when set, makes WriteCertificateToFile (and CRL writers) describe each certificate and CRL
+// in comment lines above its PEM block. PEM parsers (Go, OpenSSL) skip text outside the armour, so
+// the cryptographic content is unchanged.
+var PEMInfo bool
+
+// AnnotatePEM returns data with a comment block above every certificate and CRL, replacing any
+// text that preceded the blocks. Data without PEM blocks is returned unchanged.
+func AnnotatePEM(data []byte) []byte {
+	var out bytes.Buffer
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if out.Len() > 0 {
+			out.WriteByte('\n')
+		}
+		for _, line := range describePEMBlock(block) {
+			fmt.Fprintf(&out, "# %s\n", line)
+		}
+		out.Write(pem.EncodeToMemory(block))
+	}
+	if out.Len() == 0 {
+		return data
+	}
+	return out.Bytes()
+}
+
+// describePEMBlock returns the informational lines for a certificate or CRL block, or none for other blocks.
+func describePEMBlock(block *pem.Block) []string {
+	sum := sha256.Sum256(block.Bytes)
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		return []string{
+			"Subject: " + cert.Subject.String(),
+			"Issuer: " + cert.Issuer.String(),
+			"Serial: " + hex.EncodeToString(cert.SerialNumber.Bytes()),
+			"Not Before: " + cert.NotBefore.UTC().Format(time.RFC3339),
+			"Not After: " + cert.NotAfter.UTC().Format(time.RFC3339),
+			"SHA-256: " + hex.EncodeToString(sum[:]),
+		}
+	case "X509 CRL":
+		rl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		lines := []string{"Issuer: " + rl.Issuer.String()}
+		if rl.Number != nil {
+			lines = append(lines, "CRL Number: "+rl.Number.String())
+		}
+		lines = append(lines,
+			"This Update: "+rl.ThisUpdate.UTC().Format(time.RFC3339),
+			"Next Update: "+rl.NextUpdate.UTC().Format(time.RFC3339),
+			fmt.Sprintf("Revoked: %d", len(rl.RevokedCertificateEntries)),
+		)
+		return lines
+	}
+	return nil
+}
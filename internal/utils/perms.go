@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Default modes of written files, before the process umask.
+const (
+	DefaultCertMode  os.FileMode = 0644
+	DefaultKeyMode   os.FileMode = 0600
+	DefaultShareMode os.FileMode = 0600
+)
+
+// FilePerms says how a written file is created: its exact mode (0 keeps the default, filtered by the
+// process umask) and optionally its owner and group, as names or numeric IDs.
+type FilePerms struct {
+	Mode  os.FileMode
+	Owner string
+	Group string
+}
+
+// IsZero reports whether p leaves everything at its default.
+func (p FilePerms) IsZero() bool {
+	return p == FilePerms{}
+}
+
+// String formats p as accepted by ParseFilePerms.
+func (p FilePerms) String() string {
+	var mode string
+	if p.Mode != 0 {
+		mode = fmt.Sprintf("%04o", p.Mode)
+	}
+	return strings.TrimRight(mode+":"+p.Owner+":"+p.Group, ":")
+}
+
+// ParseFilePerms parses "MODE[:OWNER[:GROUP]]", e.g. "0640:svc:pki", "0644" or ":svc" (ownership only).
+func ParseFilePerms(s string) (FilePerms, error) {
+	var p FilePerms
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return p, fmt.Errorf("invalid permissions '%s' (expected MODE[:OWNER[:GROUP]], e.g. 0640:svc:pki)", s)
+	}
+	if parts[0] != "" {
+		mode, err := ParseMode(parts[0])
+		if err != nil {
+			return p, err
+		}
+		if mode == 0 {
+			return p, fmt.Errorf("invalid permissions '%s': mode 0 would leave the file unreadable", s)
+		}
+		p.Mode = mode
+	}
+	if len(parts) > 1 {
+		p.Owner = parts[1]
+	}
+	if len(parts) > 2 {
+		p.Group = parts[2]
+	}
+	return p, nil
+}
+
+// ParseMode parses an octal permission mode such as "0640" or "027".
+func ParseMode(s string) (os.FileMode, error) {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0777 {
+		return 0, fmt.Errorf("invalid mode '%s' (expected octal permissions, e.g. 0640)", s)
+	}
+	return os.FileMode(v), nil
+}
+
+// OutputPerms holds the permissions of the certificates, private keys and key shares this process writes.
+type OutputPerms struct {
+	Certs, Keys, Shares FilePerms
+	Umask               *os.FileMode // removed from the default mode of kinds without an explicit mode
+}
+
+// Output is applied by WriteCertificateToFile, the private key writers and SplitKeyAndWriteShares.
+var Output OutputPerms
+
+// WriteShareFile writes key share material, such as a share file or its mnemonic, with the share permissions.
+func WriteShareFile(path string, data []byte) error {
+	if path == Stdio {
+		return errors.New("key shares cannot be written to stdout; give a file path")
+	}
+	return writeOutputFile(path, data, Output.Shares, DefaultShareMode)
+}
+
+// writeOutputFile writes data to path with the mode and ownership of p, defaulting to defaultMode.
+// An explicit mode is set exactly, also on an existing file, before any data is written to it.
+func writeOutputFile(path string, data []byte, p FilePerms, defaultMode os.FileMode) error {
+	if path == Stdio {
+		return writeStdout(data)
+	}
+	mode := p.Mode
+	if mode == 0 && Output.Umask != nil {
+		mode = defaultMode &^ *Output.Umask
+	}
+	createMode := defaultMode
+	if mode != 0 {
+		createMode = mode
+	}
+	// Resolve the owner first, so an unknown name does not leave an empty file behind
+	uid, gid, err := lookupOwnership(p.Owner, p.Group)
+	if err != nil {
+		return fmt.Errorf("failed to set ownership %s:%s on '%s': %w", p.Owner, p.Group, path, err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, createMode)
+	if err != nil {
+		return err
+	}
+	if mode != 0 {
+		if err := f.Chmod(mode); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to set mode %04o on '%s': %w", mode, path, err)
+		}
+	}
+	if p.Owner != "" || p.Group != "" {
+		if err := f.Chown(uid, gid); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to set ownership %s:%s on '%s': %w", p.Owner, p.Group, path, err)
+		}
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	slog.Debug("file written", "path", path)
+	return nil
+}
+
+// lookupOwnership resolves owner and group names or IDs; an empty one is returned as -1 (unchanged).
+func lookupOwnership(owner, group string) (uid, gid int, err error) {
+	uid, err = lookupID(owner, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	gid, err = lookupID(group, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	return uid, gid, err
+}
+
+// lookupID returns the numeric ID named by s, resolving a name with lookup, or -1 if s is empty.
+func lookupID(s string, lookup func(string) (string, error)) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	idStr, err := lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' has no numeric ID", s)
+	}
+	return id, nil
+}
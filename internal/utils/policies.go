@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	oidExtensionCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidQualifierCPS                 = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+	oidQualifierUserNotice          = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 2}
+)
+
+// CertOption adjusts the certificate template before it is signed.
+type CertOption func(*x509.Certificate) error
+
+// CertificatePolicy is one entry of the certificatePolicies extension (RFC 5280 section 4.2.1.4).
+type CertificatePolicy struct {
+	OID        string `yaml:"oid" json:"oid"`
+	CPSURI     string `yaml:"cps_uri,omitempty" json:"cps_uri,omitempty"`
+	UserNotice string `yaml:"user_notice,omitempty" json:"user_notice,omitempty"`
+}
+
+type policyInformation struct {
+	PolicyIdentifier asn1.ObjectIdentifier
+	Qualifiers       []policyQualifierInfo `asn1:"optional,omitempty"`
+}
+
+type policyQualifierInfo struct {
+	PolicyQualifierID asn1.ObjectIdentifier
+	Qualifier         asn1.RawValue
+}
+
+type userNotice struct {
+	ExplicitText string `asn1:"utf8"`
+}
+
+// ParseOID parses a dotted-decimal object identifier such as "1.3.6.1.4.1.99999.1".
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID '%s'", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID '%s'", s)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// PolicyFromFlags builds policies for each OID, attaching the same CPS URI and user notice to each.
+func PolicyFromFlags(oids []string, cpsURI, notice string) ([]CertificatePolicy, error) {
+	if len(oids) == 0 {
+		if cpsURI != "" || notice != "" {
+			return nil, errors.New("a CPS URI or user notice requires at least one policy OID")
+		}
+		return nil, nil
+	}
+	policies := make([]CertificatePolicy, len(oids))
+	for i, oid := range oids {
+		policies[i] = CertificatePolicy{OID: strings.TrimSpace(oid), CPSURI: cpsURI, UserNotice: notice}
+	}
+	return policies, nil
+}
+
+// CertificatePoliciesExtension encodes policies, including CPS URI and user notice qualifiers.
+func CertificatePoliciesExtension(policies []CertificatePolicy) (pkix.Extension, error) {
+	var infos []policyInformation
+	for _, p := range policies {
+		oid, err := ParseOID(p.OID)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		info := policyInformation{PolicyIdentifier: oid}
+		if p.CPSURI != "" {
+			value, err := asn1.MarshalWithParams(p.CPSURI, "ia5")
+			if err != nil {
+				return pkix.Extension{}, fmt.Errorf("invalid CPS URI '%s': %w", p.CPSURI, err)
+			}
+			info.Qualifiers = append(info.Qualifiers, policyQualifierInfo{oidQualifierCPS, asn1.RawValue{FullBytes: value}})
+		}
+		if p.UserNotice != "" {
+			if len(p.UserNotice) > 200 {
+				return pkix.Extension{}, errors.New("user notice must not exceed 200 characters")
+			}
+			value, err := asn1.Marshal(userNotice{ExplicitText: p.UserNotice})
+			if err != nil {
+				return pkix.Extension{}, fmt.Errorf("failed to encode user notice: %w", err)
+			}
+			info.Qualifiers = append(info.Qualifiers, policyQualifierInfo{oidQualifierUserNotice, asn1.RawValue{FullBytes: value}})
+		}
+		infos = append(infos, info)
+	}
+	value, err := asn1.Marshal(infos)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("failed to encode certificate policies: %w", err)
+	}
+	return pkix.Extension{Id: oidExtensionCertificatePolicies, Value: value}, nil
+}
+
+// WithPolicies sets the certificatePolicies extension. It is a no-op for an empty list.
+func WithPolicies(policies []CertificatePolicy) CertOption {
+	return func(template *x509.Certificate) error {
+		if len(policies) == 0 {
+			return nil
+		}
+		ext, err := CertificatePoliciesExtension(policies)
+		if err != nil {
+			return err
+		}
+		// Replace policies carried over from elsewhere (e.g. a re-issued certificate)
+		extensions := template.ExtraExtensions[:0:0]
+		for _, e := range template.ExtraExtensions {
+			if !e.Id.Equal(oidExtensionCertificatePolicies) {
+				extensions = append(extensions, e)
+			}
+		}
+		template.ExtraExtensions = append(extensions, ext)
+		return nil
+	}
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"math"
+)
+
+// issuerBoundExtensions are extensions that describe the old issuer or the old certificate itself.
+// They are never copied when re-issuing; the new CA and the new key supply their own.
+var issuerBoundExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14},                     // subject key identifier
+	{2, 5, 29, 35},                     // authority key identifier
+	{2, 5, 29, 31},                     // CRL distribution points
+	{1, 3, 6, 1, 5, 5, 7, 1, 1},        // authority information access
+	{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}, // embedded SCT list
+	{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}, // CT precertificate poison
+	{2, 5, 29, 15},                     // key usage (rebuilt from KeyUsage)
+	{2, 5, 29, 17},                     // subject alternative name (rebuilt from SAN fields)
+	{2, 5, 29, 19},                     // basic constraints (rebuilt from IsCA/MaxPathLen)
+	{2, 5, 29, 30},                     // name constraints (rebuilt from Permitted*/Excluded*)
+	{2, 5, 29, 37},                     // extended key usage (rebuilt from ExtKeyUsage)
+}
+
+// TemplateFromCertificate returns a template carrying over the subject, SANs, key usages, basic
+// constraints, name constraints, policies and any other extensions of an existing certificate.
+// Extensions tied to the old issuer (AKI, SKI, CRL DP, AIA, SCTs) are dropped.
+func TemplateFromCertificate(old *x509.Certificate) *x509.Certificate {
+	template := &x509.Certificate{
+		Subject:               old.Subject,
+		DNSNames:              old.DNSNames,
+		EmailAddresses:        old.EmailAddresses,
+		IPAddresses:           old.IPAddresses,
+		URIs:                  old.URIs,
+		KeyUsage:              old.KeyUsage,
+		ExtKeyUsage:           old.ExtKeyUsage,
+		UnknownExtKeyUsage:    old.UnknownExtKeyUsage,
+		BasicConstraintsValid: old.BasicConstraintsValid,
+		IsCA:                  old.IsCA,
+		MaxPathLen:            old.MaxPathLen,
+		MaxPathLenZero:        old.MaxPathLenZero,
+
+		PermittedDNSDomainsCritical: old.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         old.PermittedDNSDomains,
+		ExcludedDNSDomains:          old.ExcludedDNSDomains,
+		PermittedIPRanges:           old.PermittedIPRanges,
+		ExcludedIPRanges:            old.ExcludedIPRanges,
+		PermittedEmailAddresses:     old.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      old.ExcludedEmailAddresses,
+		PermittedURIDomains:         old.PermittedURIDomains,
+		ExcludedURIDomains:          old.ExcludedURIDomains,
+	}
+	// Raw subject keeps the exact encoding (string types, attribute order) of the original name.
+	template.RawSubject = old.RawSubject
+
+	for _, ext := range old.Extensions {
+		if !containsOID(issuerBoundExtensions, ext.Id) {
+			template.ExtraExtensions = append(template.ExtraExtensions, ext)
+		}
+	}
+	return template
+}
+
+// ValidityDays returns the validity period of cert in whole days, rounded up.
+func ValidityDays(cert *x509.Certificate) int {
+	return int(math.Ceil(cert.NotAfter.Sub(cert.NotBefore).Hours() / 24))
+}
+
+// IssueFromTemplate signs template for pub with a fresh serial number and validity period.
+func IssueFromTemplate(
+	template *x509.Certificate,
+	pub crypto.PublicKey,
+	parentCert *x509.Certificate,
+	parentKey crypto.Signer,
+	validityDays int,
+	opts ...CertOption,
+) ([]byte, error) {
+	if parentCert == nil || parentKey == nil {
+		return nil, errors.New("a parent certificate and key are required to re-issue a certificate")
+	}
+	for _, opt := range opts {
+		if err := opt(template); err != nil {
+			return nil, err
+		}
+	}
+	return signTemplate(template, pub, parentCert, parentKey, validityDays)
+}
+
+// SelfSignFromTemplate signs template for key's own public key with a fresh serial number and validity period.
+func SelfSignFromTemplate(template *x509.Certificate, key crypto.Signer, validityDays int, opts ...CertOption) ([]byte, error) {
+	for _, opt := range opts {
+		if err := opt(template); err != nil {
+			return nil, err
+		}
+	}
+	return signTemplate(template, key.Public(), nil, key, validityDays)
+}
+
+func containsOID(list []asn1.ObjectIdentifier, oid asn1.ObjectIdentifier) bool {
+	for _, o := range list {
+		if o.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// SANs holds subject alternative names by type.
+type SANs struct {
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+}
+
+// ParseSANs classifies names as IP addresses, URIs (containing "://"), e-mail addresses
+// (containing "@") or otherwise DNS names. Empty names are skipped.
+func ParseSANs(names []string) (SANs, error) {
+	var sans SANs
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case net.ParseIP(name) != nil:
+			sans.IPAddresses = append(sans.IPAddresses, net.ParseIP(name))
+		case strings.Contains(name, "://"):
+			u, err := url.Parse(name)
+			if err != nil {
+				return SANs{}, fmt.Errorf("invalid URI SAN '%s': %w", name, err)
+			}
+			sans.URIs = append(sans.URIs, u)
+		case strings.Contains(name, "@"):
+			if _, err := mail.ParseAddress(name); err != nil {
+				return SANs{}, fmt.Errorf("invalid e-mail SAN '%s': %w", name, err)
+			}
+			sans.EmailAddresses = append(sans.EmailAddresses, name)
+		default:
+			sans.DNSNames = append(sans.DNSNames, name)
+		}
+	}
+	return sans, nil
+}
+
+// SANsFromCSR returns the subject alternative names requested in csr.
+func SANsFromCSR(csr *x509.CertificateRequest) SANs {
+	return SANs{DNSNames: csr.DNSNames, IPAddresses: csr.IPAddresses, EmailAddresses: csr.EmailAddresses, URIs: csr.URIs}
+}
+
+// Empty reports whether no names are set.
+func (s SANs) Empty() bool {
+	return len(s.DNSNames) == 0 && len(s.IPAddresses) == 0 && len(s.EmailAddresses) == 0 && len(s.URIs) == 0
+}
+
+// Strings returns every name in the order DNS, IP, e-mail, URI.
+func (s SANs) Strings() []string {
+	var out []string
+	out = append(out, s.DNSNames...)
+	for _, ip := range s.IPAddresses {
+		out = append(out, ip.String())
+	}
+	out = append(out, s.EmailAddresses...)
+	for _, u := range s.URIs {
+		out = append(out, u.String())
+	}
+	return out
+}
+
+// WithSANs sets the subject alternative names of the certificate.
+func WithSANs(sans SANs) CertOption {
+	return func(template *x509.Certificate) error {
+		template.DNSNames = sans.DNSNames
+		template.IPAddresses = sans.IPAddresses
+		template.EmailAddresses = sans.EmailAddresses
+		template.URIs = sans.URIs
+		return nil
+	}
+}
+
+// SAN types named in SANRules.
+const (
+	SANTypeDNS   = "dns"
+	SANTypeIP    = "ip"
+	SANTypeEmail = "email"
+	SANTypeURI   = "uri"
+)
+
+// SANRules restrict the subject alternative names of the certificates issued under a profile.
+type SANRules struct {
+	Require     bool     `yaml:"require,omitempty"`      // at least one SAN must be given
+	Types       []string `yaml:"types,omitempty"`        // allowed types: dns, ip, email, uri (default: all)
+	DNSDomains  []string `yaml:"dns_domains,omitempty"`  // DNS names must be one of these domains or below them
+	NoWildcards bool     `yaml:"no_wildcards,omitempty"` // reject wildcard DNS names such as *.example.com
+	Max         int      `yaml:"max,omitempty"`          // upper bound on the number of SANs
+}
+
+// Check reports the first name in sans that breaks the rules.
+func (r SANRules) Check(sans SANs) error {
+	names := sans.Strings()
+	if r.Require && len(names) == 0 {
+		return fmt.Errorf("at least one subject alternative name is required")
+	}
+	if r.Max > 0 && len(names) > r.Max {
+		return fmt.Errorf("%d subject alternative names given, at most %d allowed", len(names), r.Max)
+	}
+	for _, t := range []struct {
+		name  string
+		count int
+	}{
+		{SANTypeDNS, len(sans.DNSNames)},
+		{SANTypeIP, len(sans.IPAddresses)},
+		{SANTypeEmail, len(sans.EmailAddresses)},
+		{SANTypeURI, len(sans.URIs)},
+	} {
+		if t.count > 0 && len(r.Types) > 0 && !containsFold(r.Types, t.name) {
+			return fmt.Errorf("%s subject alternative names are not allowed (allowed: %s)", t.name, strings.Join(r.Types, ", "))
+		}
+	}
+	for _, name := range sans.DNSNames {
+		if r.NoWildcards && strings.HasPrefix(name, "*.") {
+			return fmt.Errorf("wildcard DNS name '%s' is not allowed", name)
+		}
+		if len(r.DNSDomains) > 0 && !inDomains(strings.TrimPrefix(name, "*."), r.DNSDomains) {
+			return fmt.Errorf("DNS name '%s' is outside the allowed domains (%s)", name, strings.Join(r.DNSDomains, ", "))
+		}
+	}
+	return nil
+}
+
+// inDomains reports whether name is one of domains or a subdomain of one.
+func inDomains(name string, domains []string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSuffix(d, "."))
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,783 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"my-pki/internal/age"
+	"my-pki/internal/fido2"
+	"my-pki/internal/mnemonic"
+	"my-pki/internal/secmem"
+	"my-pki/internal/vss"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+const sharePEMType = "GOSEC KEY SHARE"
+
+// Share encryption: an AES-256-GCM key derived from the custodian's passphrase with Argon2id, using
+// the parameters of RFC 9106's second recommended option. They are stored in each file, so they can
+// be raised later without breaking existing shares.
+const (
+	shareEncryption              = "argon2id-aes256gcm"
+	shareArgon2Time       uint32 = 3
+	shareArgon2MemoryKiB  uint32 = 64 * 1024
+	shareArgon2Threads    uint8  = 4
+	shareArgon2SaltLength        = 16
+)
+
+// shareWrapping marks a share encrypted to its custodian's age X25519 public key.
+const shareWrapping = "age-x25519"
+
+// shareTokenSealing marks a share encrypted with a key derived from its custodian's FIDO2 token.
+const shareTokenSealing = "fido2-hmac-secret-aes256gcm"
+
+// TokenSeal is a share key derived from a custodian's FIDO2 token, with what is needed to derive it again.
+type TokenSeal struct {
+	RP         string
+	Credential []byte
+	Salt       []byte
+	secret     []byte // hmac-secret output; only kept while the shares are written
+}
+
+// EnrollToken creates a credential named user on the token and derives a share key from it.
+// The custodian touches the token twice and enters its PIN.
+func EnrollToken(token *fido2.Token, user string) (*TokenSeal, error) {
+	seal := &TokenSeal{RP: fido2.DefaultRP, Salt: make([]byte, fido2.SecretSize)}
+	if _, err := io.ReadFull(Rand, seal.Salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	var err error
+	if seal.Credential, err = token.MakeCredential(seal.RP, user); err != nil {
+		return nil, err
+	}
+	if seal.secret, err = token.HMACSecret(seal.RP, seal.Credential, seal.Salt); err != nil {
+		return nil, err
+	}
+	return seal, nil
+}
+
+// Wipe zeroes the derived key once the shares are written.
+func (t *TokenSeal) Wipe() { clear(t.secret) }
+
+// cipher returns the AES-256-GCM cipher of a share sealed to the token.
+func (t *TokenSeal) cipher() (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	defer clear(key)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, t.secret, t.Salt, []byte("GoSeC FIDO2 share key v1")), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealedShare is the encrypted form of a share's data and the parameters needed to decrypt it.
+type sealedShare struct {
+	recipient  string     // set for shares wrapped to an age key, whose ciphertext is an age file
+	token      *TokenSeal // set for shares sealed to a FIDO2 token
+	salt       []byte
+	time       uint32
+	memoryKiB  uint32
+	threads    uint8
+	ciphertext []byte // nonce followed by the GCM output
+}
+
+// Custodian identifies the person holding a share.
+type Custodian struct {
+	Label   string
+	Contact string
+}
+
+// Share is a single Shamir share together with the metadata stored alongside it.
+// Shares written before metadata was introduced only carry Data.
+type Share struct {
+	Path  string
+	Data  []byte
+	KeyID string
+	// SplitID distinguishes splits of the same key, e.g. before and after a reshare.
+	SplitID   string
+	Index     int // 1-based
+	Total     int
+	Threshold int
+	// Roster lists the custodians of all shares of the key, indexed by share index - 1.
+	Roster []Custodian
+	// Commitments is set for Feldman VSS shares, whose Data is a scalar modulo the curve order
+	// rather than a GF(256) Shamir share.
+	Commitments *vss.Commitments
+	// Envelope is set for shares of a key-encryption key rather than of the key itself; the key is
+	// encrypted under it in the WrappedKey file, if known.
+	Envelope   bool
+	WrappedKey string
+	// sealed is set for a share encrypted with a passphrase; Data is nil until Unlock.
+	sealed *sealedShare
+}
+
+// HasMetadata reports whether the share was written with metadata headers.
+func (s *Share) HasMetadata() bool { return s.Index > 0 }
+
+// Encrypted reports whether the share file is protected by a passphrase.
+func (s *Share) Encrypted() bool { return s.sealed != nil }
+
+// Locked reports whether the share is encrypted and has not been unlocked yet.
+func (s *Share) Locked() bool { return s.sealed != nil && s.Data == nil }
+
+// TokenSealed reports whether the share is encrypted to its custodian's FIDO2 token.
+func (s *Share) TokenSealed() bool { return s.sealed != nil && s.sealed.token != nil }
+
+// Recipient returns the age recipient a wrapped share is encrypted to, or "" for other shares.
+func (s *Share) Recipient() string {
+	if s.sealed == nil {
+		return ""
+	}
+	return s.sealed.recipient
+}
+
+// Unlock decrypts an encrypted share with its passphrase or, for a share wrapped to an age key,
+// with the custodian's secret key or the path of their identity file.
+func (s *Share) Unlock(passphrase []byte) error {
+	if !s.Locked() {
+		return nil
+	}
+	if s.sealed.recipient != "" {
+		return s.unwrap(passphrase)
+	}
+	var gcm cipher.AEAD
+	var err error
+	if t := s.sealed.token; t != nil {
+		if t.secret, err = (&fido2.Token{}).HMACSecret(t.RP, t.Credential, t.Salt); err != nil {
+			return err
+		}
+		defer t.Wipe()
+		gcm, err = t.cipher()
+	} else {
+		gcm, err = shareCipher(passphrase, s.sealed.salt, s.sealed.time, s.sealed.memoryKiB, s.sealed.threads)
+	}
+	if err != nil {
+		return err
+	}
+	ct := s.sealed.ciphertext
+	if len(ct) < gcm.NonceSize() {
+		return errors.New("encrypted share is truncated")
+	}
+	data, err := gcm.Open(nil, ct[:gcm.NonceSize()], ct[gcm.NonceSize():], shareAAD(s))
+	if err != nil && s.sealed.token != nil {
+		return errors.New("the token's secret does not decrypt the share: wrong token, or the share file was modified")
+	} else if err != nil {
+		return errors.New("wrong passphrase, or the share file was modified")
+	}
+	secmem.Lock(data)
+	s.Data = data
+	return nil
+}
+
+// unwrap decrypts a share wrapped to an age key with the matching identity.
+func (s *Share) unwrap(secret []byte) error {
+	identityFile := secret
+	if !bytes.HasPrefix(bytes.TrimSpace(secret), []byte("AGE-SECRET-KEY-")) {
+		var err error
+		if identityFile, err = os.ReadFile(strings.TrimSpace(string(secret))); err != nil {
+			return fmt.Errorf("failed to read identity file: %w", err)
+		}
+		defer clear(identityFile)
+	}
+	identities, err := age.ParseIdentities(identityFile)
+	if err != nil {
+		return fmt.Errorf("invalid age identity: %w", err)
+	}
+	plaintext, err := age.Decrypt(s.sealed.ciphertext, identities...)
+	if errors.Is(err, age.ErrNoIdentity) {
+		return fmt.Errorf("the secret key does not belong to recipient %s", s.sealed.recipient)
+	} else if err != nil {
+		return err
+	}
+	defer secmem.Wipe(plaintext)
+	aad := shareAAD(s)
+	if !bytes.HasPrefix(plaintext, aad) {
+		return errors.New("the share's metadata does not match its encrypted data")
+	}
+	s.Data = bytes.Clone(plaintext[len(aad):])
+	secmem.Lock(s.Data)
+	return nil
+}
+
+// UnlockShares unlocks every locked share, asking passphrase for each.
+func UnlockShares(shares []*Share, passphrase func(*Share) ([]byte, error)) error {
+	for _, s := range shares {
+		if !s.Locked() {
+			continue
+		}
+		pass, err := passphrase(s)
+		if err != nil {
+			return err
+		}
+		err = s.Unlock(pass)
+		clear(pass)
+		if err != nil {
+			return fmt.Errorf("failed to unlock share '%s': %w", s.Path, err)
+		}
+	}
+	return nil
+}
+
+// PromptSharePassphrase asks the custodian of s for its passphrase or their age secret key on the
+// terminal, or to insert their FIDO2 token.
+func PromptSharePassphrase(s *Share) ([]byte, error) {
+	if s.TokenSealed() {
+		_, err := ReadLine(fmt.Sprintf("Insert the FIDO2 token of %s for share #%d in '%s', press Enter, then touch it: ", s.Custodian(), s.Index, s.Path))
+		return nil, err
+	}
+	if r := s.Recipient(); r != "" {
+		return ReadPassphrase(fmt.Sprintf("Secret key or identity file for share #%d (%s) in '%s', wrapped to %s: ", s.Index, s.Custodian(), s.Path, r))
+	}
+	return ReadPassphrase(fmt.Sprintf("Passphrase for share #%d (%s) in '%s': ", s.Index, s.Custodian(), s.Path))
+}
+
+// shareCipher derives the AES-256-GCM cipher of an encrypted share from its passphrase.
+func shareCipher(passphrase, salt []byte, time, memoryKiB uint32, threads uint8) (cipher.AEAD, error) {
+	key := argon2.IDKey(passphrase, salt, time, memoryKiB, threads, 32)
+	defer clear(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// shareAAD binds an encrypted share to its metadata, so headers cannot be swapped between files.
+func shareAAD(s *Share) []byte {
+	return []byte(fmt.Sprintf("GoSeC share v1\n%s\n%d\n%d\n%d\n", s.KeyID, s.Index, s.Total, s.Threshold))
+}
+
+// Custodian returns the custodian recorded for this share, if any.
+func (s *Share) Custodian() Custodian {
+	if s.Index > 0 && s.Index <= len(s.Roster) {
+		return s.Roster[s.Index-1]
+	}
+	return Custodian{}
+}
+
+// LogValue logs the share by its metadata, never its secret.
+func (s *Share) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("path", s.Path),
+		slog.Int("index", s.Index),
+		slog.String("custodian", s.Custodian().String()),
+		slog.String("key_id", s.KeyID),
+		slog.String("split_id", s.SplitID),
+	)
+}
+
+// String describes the custodian for operators, e.g. "Bob <bob@example.com>".
+func (c Custodian) String() string {
+	switch {
+	case c.Label != "" && c.Contact != "":
+		return fmt.Sprintf("%s <%s>", c.Label, c.Contact)
+	case c.Label != "":
+		return c.Label
+	case c.Contact != "":
+		return "<" + c.Contact + ">"
+	default:
+		return "unlabelled"
+	}
+}
+
+// KeyID returns a short identifier for the public half of privKey, used to tie shares to their key.
+func KeyID(privKey *ecdsa.PrivateKey) (string, error) {
+	return PublicKeyID(&privKey.PublicKey)
+}
+
+// PublicKeyID returns the identifier KeyID gives the key pair of pub, e.g. of a CA certificate.
+func PublicKeyID(pub any) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// EncodeShare serialises a share as a PEM block whose headers carry the metadata.
+func EncodeShare(s *Share) []byte {
+	headers := shareHeaders(s)
+	headers["Share-Checksum"] = shareChecksum(s, s.Data)
+	return pem.EncodeToMemory(&pem.Block{Type: sharePEMType, Headers: headers, Bytes: s.Data})
+}
+
+// shareChecksum returns the SHA-256 checksum of a share's identifying metadata and PEM body, which
+// lets a corrupted or truncated file be named before it spoils a combine. It is not a MAC: encrypted
+// shares rely on AES-GCM against deliberate tampering.
+func shareChecksum(s *Share, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "GoSeC share checksum v1\n%s\n%s\n%d\n%d\n%d\n", s.KeyID, s.SplitID, s.Index, s.Total, s.Threshold)
+	if s.Commitments != nil {
+		fmt.Fprintf(h, "%s\n%s\n", vss.Scheme, s.Commitments.Encode())
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// EncodeEncryptedShare serialises a share like EncodeShare, with its data encrypted under passphrase.
+// The metadata stays readable, so quorum and custodians can be shown before any passphrase is entered.
+func EncodeEncryptedShare(s *Share, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, shareArgon2SaltLength)
+	if _, err := io.ReadFull(Rand, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	gcm, err := shareCipher(passphrase, salt, shareArgon2Time, shareArgon2MemoryKiB, shareArgon2Threads)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(Rand, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	headers := shareHeaders(s)
+	headers["Encryption"] = shareEncryption
+	headers["KDF-Salt"] = hex.EncodeToString(salt)
+	headers["KDF-Params"] = fmt.Sprintf("t=%d,m=%d,p=%d", shareArgon2Time, shareArgon2MemoryKiB, shareArgon2Threads)
+	ct := gcm.Seal(nonce, nonce, s.Data, shareAAD(s))
+	headers["Share-Checksum"] = shareChecksum(s, ct)
+	return pem.EncodeToMemory(&pem.Block{Type: sharePEMType, Headers: headers, Bytes: ct}), nil
+}
+
+// EncodeWrappedShare serialises a share like EncodeShare, with its data encrypted to the age
+// recipient of its custodian. The metadata is included in the encrypted data and checked on unwrap.
+func EncodeWrappedShare(s *Share, recipient *age.Recipient) ([]byte, error) {
+	plaintext := append(shareAAD(s), s.Data...)
+	defer clear(plaintext)
+	ct, err := age.Encrypt(plaintext, recipient)
+	if err != nil {
+		return nil, err
+	}
+	headers := shareHeaders(s)
+	headers["Encryption"] = shareWrapping
+	headers["Recipient"] = recipient.String()
+	headers["Share-Checksum"] = shareChecksum(s, ct)
+	return pem.EncodeToMemory(&pem.Block{Type: sharePEMType, Headers: headers, Bytes: ct}), nil
+}
+
+// EncodeTokenShare serialises a share like EncodeShare, with its data encrypted under the key
+// derived from its custodian's FIDO2 token.
+func EncodeTokenShare(s *Share, seal *TokenSeal) ([]byte, error) {
+	gcm, err := seal.cipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(Rand, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	headers := shareHeaders(s)
+	headers["Encryption"] = shareTokenSealing
+	headers["FIDO2-RP"] = seal.RP
+	headers["FIDO2-Credential"] = base64.StdEncoding.EncodeToString(seal.Credential)
+	headers["KDF-Salt"] = hex.EncodeToString(seal.Salt)
+	ct := gcm.Seal(nonce, nonce, s.Data, shareAAD(s))
+	headers["Share-Checksum"] = shareChecksum(s, ct)
+	return pem.EncodeToMemory(&pem.Block{Type: sharePEMType, Headers: headers, Bytes: ct}), nil
+}
+
+// shareHeaders returns the metadata headers of a share.
+func shareHeaders(s *Share) map[string]string {
+	headers := map[string]string{
+		"Key-Id":      s.KeyID,
+		"Share-Index": strconv.Itoa(s.Index),
+		"Share-Count": strconv.Itoa(s.Total),
+		"Threshold":   strconv.Itoa(s.Threshold),
+	}
+	if s.SplitID != "" {
+		headers["Split-Id"] = s.SplitID
+	}
+	if s.Commitments != nil {
+		headers["Scheme"] = vss.Scheme
+		headers["VSS-Curve"] = s.Commitments.Curve.Params().Name
+		headers["VSS-Commitments"] = s.Commitments.Encode()
+	}
+	if s.Envelope {
+		headers["Scheme"] = envelopeScheme
+		if s.WrappedKey != "" {
+			headers["Wrapped-Key"] = s.WrappedKey
+		}
+	}
+	for i, c := range s.Roster {
+		if c.Label != "" {
+			headers[fmt.Sprintf("Custodian-%d", i+1)] = c.Label
+		}
+		if c.Contact != "" {
+			headers[fmt.Sprintf("Contact-%d", i+1)] = c.Contact
+		}
+	}
+	return headers
+}
+
+// ParseShare decodes a share file. Both the PEM format and legacy bare base64 shares are accepted.
+func ParseShare(raw []byte) (*Share, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		if strings.Contains(string(raw), "-----BEGIN "+sharePEMType) {
+			return nil, errors.New("malformed PEM block: the share file is corrupted or truncated")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64: %w", err)
+		}
+		return &Share{Data: decoded}, nil
+	}
+	if block.Type != sharePEMType {
+		return nil, fmt.Errorf("unexpected PEM block type '%s' (expected %s)", block.Type, sharePEMType)
+	}
+
+	s := &Share{Data: block.Bytes, KeyID: block.Headers["Key-Id"], SplitID: block.Headers["Split-Id"]}
+	var err error
+	if s.Index, err = strconv.Atoi(block.Headers["Share-Index"]); err != nil {
+		return nil, errors.New("invalid Share-Index header")
+	}
+	if s.Total, err = strconv.Atoi(block.Headers["Share-Count"]); err != nil {
+		return nil, errors.New("invalid Share-Count header")
+	}
+	if s.Threshold, err = strconv.Atoi(block.Headers["Threshold"]); err != nil {
+		return nil, errors.New("invalid Threshold header")
+	}
+	if s.Total > 255 || s.Index < 1 || s.Index > s.Total {
+		return nil, fmt.Errorf("share index %d out of range 1..%d", s.Index, s.Total)
+	}
+	s.Roster = make([]Custodian, s.Total)
+	for i := range s.Roster {
+		s.Roster[i] = Custodian{
+			Label:   block.Headers[fmt.Sprintf("Custodian-%d", i+1)],
+			Contact: block.Headers[fmt.Sprintf("Contact-%d", i+1)],
+		}
+	}
+	if scheme, ok := block.Headers["Scheme"]; ok && scheme == envelopeScheme {
+		s.Envelope = true
+		s.WrappedKey = block.Headers["Wrapped-Key"]
+	} else if ok {
+		if scheme != vss.Scheme {
+			return nil, fmt.Errorf("unsupported share scheme '%s'", scheme)
+		}
+		if s.Commitments, err = vss.Parse(block.Headers["VSS-Curve"], block.Headers["VSS-Commitments"]); err != nil {
+			return nil, fmt.Errorf("invalid VSS commitments: %w", err)
+		}
+		if s.Commitments.Threshold() != s.Threshold {
+			return nil, fmt.Errorf("VSS commitments are for threshold %d, not %d", s.Commitments.Threshold(), s.Threshold)
+		}
+	}
+	// Shares written before checksums were introduced have none
+	if sum, ok := block.Headers["Share-Checksum"]; ok && sum != shareChecksum(s, block.Bytes) {
+		return nil, errors.New("checksum mismatch: the share file is corrupted or truncated")
+	}
+	if enc, ok := block.Headers["Encryption"]; ok && enc == shareWrapping {
+		if _, err := age.ParseRecipient(block.Headers["Recipient"]); err != nil {
+			return nil, fmt.Errorf("invalid Recipient header: %w", err)
+		}
+		s.sealed = &sealedShare{recipient: block.Headers["Recipient"], ciphertext: block.Bytes}
+		s.Data = nil
+	} else if ok && enc == shareTokenSealing {
+		seal := &TokenSeal{RP: block.Headers["FIDO2-RP"]}
+		if seal.Credential, err = base64.StdEncoding.DecodeString(block.Headers["FIDO2-Credential"]); err != nil || len(seal.Credential) == 0 {
+			return nil, errors.New("invalid FIDO2-Credential header")
+		}
+		if seal.Salt, err = hex.DecodeString(block.Headers["KDF-Salt"]); err != nil || len(seal.Salt) != fido2.SecretSize {
+			return nil, errors.New("invalid KDF-Salt header")
+		}
+		s.sealed = &sealedShare{token: seal, ciphertext: block.Bytes}
+		s.Data = nil
+	} else if ok {
+		if enc != shareEncryption {
+			return nil, fmt.Errorf("unsupported share encryption '%s'", enc)
+		}
+		sealed := &sealedShare{ciphertext: block.Bytes}
+		if sealed.salt, err = hex.DecodeString(block.Headers["KDF-Salt"]); err != nil || len(sealed.salt) == 0 {
+			return nil, errors.New("invalid KDF-Salt header")
+		}
+		var threads uint
+		if _, err := fmt.Sscanf(block.Headers["KDF-Params"], "t=%d,m=%d,p=%d", &sealed.time, &sealed.memoryKiB, &threads); err != nil ||
+			sealed.time == 0 || sealed.memoryKiB == 0 || threads == 0 || threads > 255 {
+			return nil, errors.New("invalid KDF-Params header")
+		}
+		sealed.threads = uint8(threads)
+		s.sealed = sealed
+		s.Data = nil
+	}
+	return s, nil
+}
+
+// Share mnemonic layout: version, flags (bit 0: VSS share; bits 1-2: VSS curve; bit 3: envelope
+// share), key identifier, split identifier, index, share count, threshold, then the share data.
+const (
+	shareWordsVersion = 1
+	shareWordsHeader  = 2 + 16 + 8 + 3
+)
+
+// vssCurveNames are the curves VSS shares may use, by their code in share mnemonics.
+var vssCurveNames = []string{"", "P-256", "P-384", "P-521"}
+
+// EncodeShareWords returns an unlocked share as a mnemonic for paper backup. Custodian labels, VSS
+// commitments and the wrapped key path are not included; they are kept next to the CA certificate.
+func EncodeShareWords(s *Share) ([]string, error) {
+	if !s.HasMetadata() {
+		return nil, errors.New("shares without metadata cannot be written as words")
+	}
+	if s.Locked() {
+		return nil, fmt.Errorf("share '%s' is encrypted and has not been unlocked", s.Path)
+	}
+	keyID, err := hex.DecodeString(s.KeyID)
+	if err != nil || len(keyID) != 16 {
+		return nil, fmt.Errorf("invalid key identifier '%s'", s.KeyID)
+	}
+	splitID := make([]byte, 8)
+	if s.SplitID != "" {
+		if b, err := hex.DecodeString(s.SplitID); err != nil || len(b) != 8 {
+			return nil, fmt.Errorf("invalid split identifier '%s'", s.SplitID)
+		} else {
+			copy(splitID, b)
+		}
+	}
+	flags := byte(0)
+	if s.Commitments != nil {
+		code := slices.Index(vssCurveNames, s.Commitments.Curve.Params().Name)
+		if code < 1 {
+			return nil, fmt.Errorf("unsupported VSS curve %s", s.Commitments.Curve.Params().Name)
+		}
+		flags = 1 | byte(code)<<1
+	}
+	if s.Envelope {
+		flags |= 8
+	}
+	payload := []byte{shareWordsVersion, flags}
+	payload = append(payload, keyID...)
+	payload = append(payload, splitID...)
+	payload = append(payload, byte(s.Index), byte(s.Total), byte(s.Threshold))
+	payload = append(payload, s.Data...)
+	defer clear(payload)
+	return mnemonic.Encode(payload)
+}
+
+// ParseShareWords decodes a mnemonic written by EncodeShareWords. A VSS share needs the commitments
+// it was dealt with, and is checked against them.
+func ParseShareWords(words []string, commitments *vss.Commitments) (*Share, error) {
+	payload, err := mnemonic.Decode(words)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) <= shareWordsHeader || payload[0] != shareWordsVersion {
+		return nil, errors.New("the words do not encode a GoSeC key share")
+	}
+	flags := payload[1]
+	s := &Share{
+		KeyID:     hex.EncodeToString(payload[2:18]),
+		Index:     int(payload[26]),
+		Total:     int(payload[27]),
+		Threshold: int(payload[28]),
+		Data:      append([]byte(nil), payload[shareWordsHeader:]...),
+	}
+	if splitID := payload[18:26]; !bytes.Equal(splitID, make([]byte, 8)) {
+		s.SplitID = hex.EncodeToString(splitID)
+	}
+	clear(payload)
+	if s.Index < 1 || s.Index > s.Total {
+		return nil, fmt.Errorf("share index %d out of range 1..%d", s.Index, s.Total)
+	}
+	// The wrapped key file is not part of the words; it is found from other shares or --wrapped-key
+	s.Envelope = flags&8 != 0
+	if flags&1 == 0 {
+		return s, nil
+	}
+	code := int(flags >> 1 & 3)
+	if code == 0 {
+		return nil, errors.New("invalid VSS curve code")
+	}
+	if commitments == nil {
+		return nil, errors.New("this is a VSS share: give the commitments it was dealt with")
+	}
+	if name := commitments.Curve.Params().Name; name != vssCurveNames[code] {
+		return nil, fmt.Errorf("the share is on %s but the commitments on %s", vssCurveNames[code], name)
+	}
+	if commitments.Threshold() != s.Threshold {
+		return nil, fmt.Errorf("the commitments are for threshold %d, not %d", commitments.Threshold(), s.Threshold)
+	}
+	if err := commitments.Verify(s.Index, s.Data); err != nil {
+		return nil, fmt.Errorf("the share fails VSS verification: %w", err)
+	}
+	s.Commitments = commitments
+	return s, nil
+}
+
+// ReadShareFile reads and parses a single share file.
+func ReadShareFile(path string) (*Share, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read share file '%s': %w", path, err)
+	}
+	s, err := ParseShare(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid share file '%s': %w", path, err)
+	}
+	s.Path = path
+	return s, nil
+}
+
+// ReadShareFiles reads several share files and checks that their metadata is consistent.
+func ReadShareFiles(paths []string) ([]*Share, error) {
+	var shares []*Share
+	for _, path := range paths {
+		s, err := ReadShareFile(path)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	if err := CheckShares(shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// CheckShares checks that shares, wherever they were read from, belong to the same split of a key
+// and are all different.
+func CheckShares(shares []*Share) error {
+	seen := make(map[int]string)
+	for i, s := range shares {
+		if !s.HasMetadata() {
+			continue
+		}
+		for _, other := range shares[:i] {
+			if other.HasMetadata() && other.KeyID != s.KeyID {
+				return fmt.Errorf("'%s' belongs to key %s but '%s' belongs to key %s",
+					other.Path, other.KeyID, s.Path, s.KeyID)
+			}
+		}
+		for _, other := range shares[:i] {
+			if other.HasMetadata() && other.SplitID != s.SplitID {
+				return fmt.Errorf("'%s' and '%s' come from different splits of key %s (e.g. before and after a reshare) and cannot be combined",
+					other.Path, s.Path, s.KeyID)
+			}
+		}
+		if prev, ok := seen[s.Index]; ok {
+			return fmt.Errorf("'%s' and '%s' are the same share (%d/%d)", prev, s.Path, s.Index, s.Total)
+		}
+		seen[s.Index] = s.Path
+	}
+	return nil
+}
+
+// DescribeShares summarises which shares (and custodians) are present and which are missing.
+func DescribeShares(shares []*Share) string {
+	var present []string
+	var roster []Custodian
+	have := make(map[int]bool)
+	legacy := 0
+	for _, s := range shares {
+		if !s.HasMetadata() {
+			legacy++
+			continue
+		}
+		have[s.Index] = true
+		roster = s.Roster
+		present = append(present, fmt.Sprintf("#%d %s", s.Index, s.Custodian()))
+	}
+	sort.Strings(present)
+
+	var b strings.Builder
+	if len(present) > 0 {
+		fmt.Fprintf(&b, "Shares provided: %s", strings.Join(present, ", "))
+	}
+	if legacy > 0 {
+		if b.Len() > 0 {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%d share(s) without metadata", legacy)
+	}
+	var missing []string
+	for i, c := range roster {
+		if !have[i+1] {
+			missing = append(missing, fmt.Sprintf("#%d %s", i+1, c))
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(&b, "\nShares not provided: %s", strings.Join(missing, ", "))
+	}
+	return b.String()
+}
+
+// QuorumStatus returns how many distinct shares were provided and the threshold recorded in their
+// metadata. The threshold is 0 when none of the shares carry metadata.
+func QuorumStatus(shares []*Share) (provided, threshold int) {
+	seen := make(map[int]bool)
+	for _, s := range shares {
+		if !s.HasMetadata() {
+			provided++
+			continue
+		}
+		if !seen[s.Index] {
+			seen[s.Index] = true
+			provided++
+		}
+		if s.Threshold > threshold {
+			threshold = s.Threshold
+		}
+	}
+	return provided, threshold
+}
+
+// DescribeQuorum summarises quorum progress, e.g. "2 of 3 shares loaded".
+func DescribeQuorum(shares []*Share) string {
+	provided, threshold := QuorumStatus(shares)
+	if threshold == 0 {
+		return fmt.Sprintf("%d share(s) loaded (threshold unknown)", provided)
+	}
+	if provided >= threshold {
+		return fmt.Sprintf("%d of %d shares loaded (quorum reached)", provided, threshold)
+	}
+	return fmt.Sprintf("%d of %d shares loaded", provided, threshold)
+}
+
+// ParseCustodians pairs comma-separated custodian labels and contacts into a roster of n entries.
+// Both inputs are optional; when given they must list exactly n entries (empty entries are allowed).
+func ParseCustodians(labels, contacts string, n int) ([]Custodian, error) {
+	split := func(s, what string) ([]string, error) {
+		if strings.TrimSpace(s) == "" {
+			return make([]string, n), nil
+		}
+		parts := strings.Split(s, ",")
+		if len(parts) != n {
+			return nil, fmt.Errorf("number of %s (%d) does not match n=%d", what, len(parts), n)
+		}
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		return parts, nil
+	}
+	ls, err := split(labels, "custodians")
+	if err != nil {
+		return nil, err
+	}
+	cs, err := split(contacts, "contacts")
+	if err != nil {
+		return nil, err
+	}
+	roster := make([]Custodian, n)
+	for i := range roster {
+		roster[i] = Custodian{Label: ls[i], Contact: cs[i]}
+	}
+	return roster, nil
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// Stdio is the path naming stdin when certificates, keys, CSRs and CRLs are read, and stdout when
+// certificates, keys and CSRs are written. Key shares are always files.
+const Stdio = "-"
+
+// Stdout receives what is written to Stdio. A command whose messages would get in the way points it at
+// the original stdout before moving its messages to stderr; nil means stdout is reserved for something else.
+var Stdout io.Writer = os.Stdout
+
+// stdinRead is set once stdin was read as an input, which can only happen once.
+var stdinRead bool
+
+// ReadInput reads the file at path, or all of stdin for Stdio.
+func ReadInput(path string) ([]byte, error) {
+	if path != Stdio {
+		return os.ReadFile(path)
+	}
+	if stdinRead {
+		return nil, errors.New("stdin was already read; only one input can be '-'")
+	}
+	stdinRead = true
+	return io.ReadAll(stdinReader)
+}
+
+// writeStdout writes data to Stdout, for an output path of Stdio.
+func writeStdout(data []byte) error {
+	if Stdout == nil {
+		return errors.New("stdout is reserved for the command's result and cannot take an output")
+	}
+	_, err := Stdout.Write(data)
+	return err
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	oidCountry            = asn1.ObjectIdentifier{2, 5, 4, 6}
+	oidProvince           = asn1.ObjectIdentifier{2, 5, 4, 8}
+	oidLocality           = asn1.ObjectIdentifier{2, 5, 4, 7}
+	oidStreetAddress      = asn1.ObjectIdentifier{2, 5, 4, 9}
+	oidPostalCode         = asn1.ObjectIdentifier{2, 5, 4, 17}
+	oidOrganization       = asn1.ObjectIdentifier{2, 5, 4, 10}
+	oidOrganizationalUnit = asn1.ObjectIdentifier{2, 5, 4, 11}
+	oidCommonName         = asn1.ObjectIdentifier{2, 5, 4, 3}
+	oidSerialNumber       = asn1.ObjectIdentifier{2, 5, 4, 5}
+	oidEmailAddress       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+	oidDomainComponent    = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}
+)
+
+// SubjectAttributes holds the subject attributes that can be set on issued certificates.
+// Every attribute except CommonName and SerialNumber may have several values.
+type SubjectAttributes struct {
+	CommonName         string
+	SerialNumber       string
+	Organization       []string
+	OrganizationalUnit []string
+	Locality           []string
+	Province           []string
+	Country            []string
+	StreetAddress      []string
+	PostalCode         []string
+	DomainComponent    []string
+	Email              []string
+}
+
+// Name builds the subject. Empty values are ignored; a common name is required.
+func (a SubjectAttributes) Name() (pkix.Name, error) {
+	cn := strings.TrimSpace(a.CommonName)
+	if cn == "" {
+		return pkix.Name{}, errors.New("common name (CN) is required")
+	}
+	subject := pkix.Name{
+		CommonName:         cn,
+		SerialNumber:       strings.TrimSpace(a.SerialNumber),
+		Organization:       nonEmpty(a.Organization),
+		OrganizationalUnit: nonEmpty(a.OrganizationalUnit),
+		Locality:           nonEmpty(a.Locality),
+		Province:           nonEmpty(a.Province),
+		Country:            nonEmpty(a.Country),
+		StreetAddress:      nonEmpty(a.StreetAddress),
+		PostalCode:         nonEmpty(a.PostalCode),
+	}
+	for _, c := range subject.Country {
+		if len(c) != 2 {
+			return pkix.Name{}, fmt.Errorf("invalid country '%s' (expected a 2-letter code)", c)
+		}
+	}
+
+	// The attributes are also listed in ExtraNames, which takes precedence when encoding, so that every
+	// value gets its own RDN (pkix.Name would put repeated values in one multi-valued RDN) in the usual
+	// order. domainComponent and emailAddress are IA5Strings, which pkix.Name has no fields for.
+	// Domain components are given as written ("example", "com") and encoded most significant first.
+	add := func(oid asn1.ObjectIdentifier, values ...string) {
+		for _, v := range values {
+			if v != "" {
+				subject.ExtraNames = append(subject.ExtraNames, pkix.AttributeTypeAndValue{Type: oid, Value: v})
+			}
+		}
+	}
+	dcs := nonEmpty(a.DomainComponent)
+	for i := len(dcs) - 1; i >= 0; i-- {
+		value, err := ia5Value(dcs[i])
+		if err != nil {
+			return pkix.Name{}, fmt.Errorf("invalid domain component '%s': %w", dcs[i], err)
+		}
+		subject.ExtraNames = append(subject.ExtraNames, pkix.AttributeTypeAndValue{Type: oidDomainComponent, Value: value})
+	}
+	add(oidCountry, subject.Country...)
+	add(oidProvince, subject.Province...)
+	add(oidLocality, subject.Locality...)
+	add(oidStreetAddress, subject.StreetAddress...)
+	add(oidPostalCode, subject.PostalCode...)
+	add(oidOrganization, subject.Organization...)
+	add(oidOrganizationalUnit, subject.OrganizationalUnit...)
+	add(oidCommonName, subject.CommonName)
+	add(oidSerialNumber, subject.SerialNumber)
+	for _, email := range nonEmpty(a.Email) {
+		if !strings.Contains(email, "@") {
+			return pkix.Name{}, fmt.Errorf("invalid email address '%s'", email)
+		}
+		value, err := ia5Value(email)
+		if err != nil {
+			return pkix.Name{}, fmt.Errorf("invalid email address '%s': %w", email, err)
+		}
+		subject.ExtraNames = append(subject.ExtraNames, pkix.AttributeTypeAndValue{Type: oidEmailAddress, Value: value})
+	}
+	return subject, nil
+}
+
+// nonEmpty trims values and drops empty ones, returning nil if none remain.
+func nonEmpty(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func ia5Value(s string) (asn1.RawValue, error) {
+	for _, r := range s {
+		if r > 0x7f {
+			return asn1.RawValue{}, errors.New("only ASCII characters are allowed")
+		}
+	}
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagIA5String, Bytes: []byte(s)}, nil
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// keyUsageNames are the RFC 5280 names of the key usages, in bit order.
+var keyUsageNames = []struct {
+	name  string
+	usage x509.KeyUsage
+}{
+	{"digitalSignature", x509.KeyUsageDigitalSignature},
+	{"contentCommitment", x509.KeyUsageContentCommitment},
+	{"keyEncipherment", x509.KeyUsageKeyEncipherment},
+	{"dataEncipherment", x509.KeyUsageDataEncipherment},
+	{"keyAgreement", x509.KeyUsageKeyAgreement},
+	{"keyCertSign", x509.KeyUsageCertSign},
+	{"cRLSign", x509.KeyUsageCRLSign},
+	{"encipherOnly", x509.KeyUsageEncipherOnly},
+	{"decipherOnly", x509.KeyUsageDecipherOnly},
+}
+
+// extKeyUsageNames are the names of the supported extended key usages.
+var extKeyUsageNames = []struct {
+	name  string
+	usage x509.ExtKeyUsage
+}{
+	{"serverAuth", x509.ExtKeyUsageServerAuth},
+	{"clientAuth", x509.ExtKeyUsageClientAuth},
+	{"codeSigning", x509.ExtKeyUsageCodeSigning},
+	{"emailProtection", x509.ExtKeyUsageEmailProtection},
+	{"timeStamping", x509.ExtKeyUsageTimeStamping},
+	{"OCSPSigning", x509.ExtKeyUsageOCSPSigning},
+	{"any", x509.ExtKeyUsageAny},
+}
+
+// ParseKeyUsage combines key usages given by RFC 5280 name (case-insensitive), e.g. "digitalSignature".
+func ParseKeyUsage(names []string) (x509.KeyUsage, error) {
+	var ku x509.KeyUsage
+	for _, name := range names {
+		found := false
+		for _, u := range keyUsageNames {
+			if strings.EqualFold(name, u.name) {
+				ku |= u.usage
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown key usage '%s'", name)
+		}
+	}
+	return ku, nil
+}
+
+// KeyUsageNames returns the RFC 5280 names of the usages in ku.
+func KeyUsageNames(ku x509.KeyUsage) []string {
+	var names []string
+	for _, u := range keyUsageNames {
+		if ku&u.usage != 0 {
+			names = append(names, u.name)
+		}
+	}
+	return names
+}
+
+// ParseExtKeyUsage returns the extended key usages given by name (case-insensitive), e.g. "serverAuth".
+func ParseExtKeyUsage(names []string) ([]x509.ExtKeyUsage, error) {
+	var ekus []x509.ExtKeyUsage
+	for _, name := range names {
+		found := false
+		for _, u := range extKeyUsageNames {
+			if strings.EqualFold(name, u.name) {
+				ekus = append(ekus, u.usage)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown extended key usage '%s'", name)
+		}
+	}
+	return ekus, nil
+}
+
+// ExtKeyUsageNames returns the names of ekus; usages without a name are given as numbers.
+func ExtKeyUsageNames(ekus []x509.ExtKeyUsage) []string {
+	var names []string
+	for _, eku := range ekus {
+		name := fmt.Sprintf("extKeyUsage(%d)", eku)
+		for _, u := range extKeyUsageNames {
+			if u.usage == eku {
+				name = u.name
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// WithExtKeyUsage sets the extended key usages of the certificate.
+func WithExtKeyUsage(ekus []x509.ExtKeyUsage) CertOption {
+	return func(template *x509.Certificate) error {
+		template.ExtKeyUsage = ekus
+		return nil
+	}
+}
@@ -1,118 +1,302 @@
 package utils
 
 import (
+	"crypto"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"github.com/hashicorp/vault/shamir"
 	"github.com/spf13/cobra"
+	"io"
 	"math/big"
-	"os"
+	"my-pki/internal/age"
+	"my-pki/internal/secmem"
+	"my-pki/internal/vss"
 	"strings"
 	"time"
 )
 
-// NewSerialNumber creates a random 128-bit serial number as a *big.Int
-func NewSerialNumber() (*big.Int, error) {
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate serial number: %w", err)
-	}
-	return serialNumber, nil
-}
+// Rand is the randomness source used for key generation, serial numbers and signatures.
+// It defaults to crypto/rand; test harnesses may replace it with a deterministic stream so that
+// generated keys and serial numbers are reproducible. ECDSA signatures still mix in system entropy,
+// and Shamir splitting and age encryption always use crypto/rand, which their implementations read
+// themselves.
+var Rand io.Reader = rand.Reader
 
-// BuildSubject returns a pkix.Name based on Cobra flags for subject attributes.
-func BuildSubject(cmd *cobra.Command) (pkix.Name, error) {
-	cn, _ := cmd.Flags().GetString("cn")
-	org, _ := cmd.Flags().GetString("org")
-	ou, _ := cmd.Flags().GetString("ou")
-	locality, _ := cmd.Flags().GetString("locality")
-	province, _ := cmd.Flags().GetString("province")
-	country, _ := cmd.Flags().GetString("country")
+// Serial number entropy bounds. RFC 5280 limits serials to 20 octets of a positive integer (at most
+// 159 bits of randomness); the CA/Browser Forum requires at least 64 bits of output from a CSPRNG.
+const (
+	MinSerialBits     = 64
+	MaxSerialBits     = 159
+	DefaultSerialBits = 128
+)
 
-	if cn == "" {
-		return pkix.Name{}, errors.New("common name (CN) is required")
-	}
+// SerialBits is the number of random bits in new serial numbers.
+var SerialBits = DefaultSerialBits
 
-	var subject pkix.Name
+// ReserveSerial, if set, is asked to claim each new serial number and returns false if it is already in use,
+// in which case another serial is drawn.
+var ReserveSerial func(serial *big.Int) bool
 
-	if org != "" {
-		subject.Organization = []string{org}
-	}
-	if ou != "" {
-		subject.OrganizationalUnit = []string{ou}
-	}
-	if locality != "" {
-		subject.Locality = []string{locality}
-	}
-	if province != "" {
-		subject.Province = []string{province}
+// maxSerialAttempts bounds the draws when ReserveSerial keeps rejecting serials.
+const maxSerialAttempts = 16
+
+// SetSerialBits sets SerialBits after checking it is within MinSerialBits and MaxSerialBits.
+func SetSerialBits(bits int) error {
+	if bits < MinSerialBits || bits > MaxSerialBits {
+		return fmt.Errorf("serial number length must be between %d and %d bits, got %d", MinSerialBits, MaxSerialBits, bits)
 	}
-	if country != "" {
-		subject.Country = []string{country}
+	SerialBits = bits
+	return nil
+}
+
+// NewSerialNumber creates a random positive serial number of SerialBits bits as a *big.Int
+func NewSerialNumber() (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), uint(SerialBits))
+	for attempt := 0; attempt < maxSerialAttempts; attempt++ {
+		serialNumber, err := rand.Int(Rand, serialNumberLimit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate serial number: %w", err)
+		}
+		if serialNumber.Sign() == 0 {
+			continue
+		}
+		if ReserveSerial == nil || ReserveSerial(serialNumber) {
+			return serialNumber, nil
+		}
 	}
-	subject.CommonName = cn
-	return subject, nil
+	return nil, fmt.Errorf("no unused serial number found after %d attempts", maxSerialAttempts)
+}
+
+// BuildSubject returns a pkix.Name based on Cobra flags for subject attributes.
+// All attributes except --cn and --serial-number may be repeated to give several values.
+func BuildSubject(cmd *cobra.Command) (pkix.Name, error) {
+	var attrs SubjectAttributes
+	attrs.CommonName, _ = cmd.Flags().GetString("cn")
+	attrs.SerialNumber, _ = cmd.Flags().GetString("serial-number")
+	attrs.Organization, _ = cmd.Flags().GetStringArray("org")
+	attrs.OrganizationalUnit, _ = cmd.Flags().GetStringArray("ou")
+	attrs.Locality, _ = cmd.Flags().GetStringArray("locality")
+	attrs.Province, _ = cmd.Flags().GetStringArray("province")
+	attrs.Country, _ = cmd.Flags().GetStringArray("country")
+	attrs.StreetAddress, _ = cmd.Flags().GetStringArray("street")
+	attrs.PostalCode, _ = cmd.Flags().GetStringArray("postal-code")
+	attrs.DomainComponent, _ = cmd.Flags().GetStringArray("dc")
+	attrs.Email, _ = cmd.Flags().GetStringArray("email")
+	return attrs.Name()
 }
 
 // GenerateKeyAndCert generates an ECDSA key and a certificate (self-signed or signed by a parent).
+// Options may add further content, such as certificate policies.
+// The parent key only needs to implement crypto.Signer, so it may be backed by reconstructed shares,
+// a key file, an HSM, a KMS or a smartcard.
 func GenerateKeyAndCert(
 	subject pkix.Name,
 	parentCert *x509.Certificate,
-	parentKey *ecdsa.PrivateKey,
+	parentKey crypto.Signer,
 	isCA bool,
 	validityDays int,
 	keyUsage x509.KeyUsage,
+	opts ...CertOption,
 ) ([]byte, *ecdsa.PrivateKey, error) {
 
-	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	priv, err := GenerateECKey()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to generate ECDSA key: %w", err)
+		return nil, nil, err
 	}
 
-	serialNumber, err := NewSerialNumber()
+	// Self-signed if parentCert/key is nil
+	if parentCert == nil || parentKey == nil {
+		parentCert, parentKey = nil, priv
+	}
+	certPEM, err := issueCertificate(subject, &priv.PublicKey, parentCert, parentKey, isCA, validityDays, keyUsage, opts...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
+		return nil, nil, err
+	}
+	return certPEM, priv, nil
+}
+
+// Key algorithms of generated keys, as named in issuance profiles.
+const (
+	KeyAlgorithmECDSAP256 = "ecdsa-p256"
+	KeyAlgorithmECDSAP384 = "ecdsa-p384"
+)
+
+// GenerateECKey creates a P-256 key from Rand.
+func GenerateECKey() (*ecdsa.PrivateKey, error) {
+	return generateECKey(elliptic.P256(), ecdh.P256())
+}
+
+// GenerateKey creates a key for alg, one of the KeyAlgorithm constants; "" means P-256.
+func GenerateKey(alg string) (*ecdsa.PrivateKey, error) {
+	switch alg {
+	case "", KeyAlgorithmECDSAP256:
+		return GenerateECKey()
+	case KeyAlgorithmECDSAP384:
+		return generateECKey(elliptic.P384(), ecdh.P384())
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm '%s' (expected %s or %s)", alg, KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384)
 	}
+}
 
-	notBefore := time.Now()
-	notAfter := notBefore.Add(time.Duration(validityDays) * 24 * time.Hour)
+// CheckKeyAlgorithm verifies that pub is a key of algorithm alg; "" accepts any key.
+func CheckKeyAlgorithm(pub crypto.PublicKey, alg string) error {
+	if alg == "" {
+		return nil
+	}
+	want := map[string]elliptic.Curve{KeyAlgorithmECDSAP256: elliptic.P256(), KeyAlgorithmECDSAP384: elliptic.P384()}[alg]
+	if want == nil {
+		return fmt.Errorf("unsupported key algorithm '%s' (expected %s or %s)", alg, KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384)
+	}
+	if k, ok := pub.(*ecdsa.PublicKey); !ok || k.Curve != want {
+		return fmt.Errorf("%s key given where the profile requires %s", DescribePublicKey(pub), alg)
+	}
+	return nil
+}
 
+// generateECKey creates a key on curve from Rand. ecdsa.GenerateKey deliberately perturbs custom
+// readers, so when Rand has been replaced the scalar is drawn from it directly by rejection sampling.
+func generateECKey(curve elliptic.Curve, ecdhCurve ecdh.Curve) (*ecdsa.PrivateKey, error) {
+	if Rand == rand.Reader {
+		priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ECDSA key: %w", err)
+		}
+		return priv, nil
+	}
+
+	scalar := make([]byte, (curve.Params().BitSize+7)/8)
+	for {
+		if _, err := io.ReadFull(Rand, scalar); err != nil {
+			return nil, fmt.Errorf("failed to generate ECDSA key: %w", err)
+		}
+		ecdhKey, err := ecdhCurve.NewPrivateKey(scalar)
+		if err != nil {
+			continue // zero or not below the group order; draw again
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(ecdhKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal ECDSA key: %w", err)
+		}
+		return ParsePrivateKeyDER(der)
+	}
+}
+
+// SignPublicKey issues a certificate for an externally generated public key, so the matching
+// private key never has to leave the machine it was generated on.
+func SignPublicKey(
+	subject pkix.Name,
+	pub crypto.PublicKey,
+	parentCert *x509.Certificate,
+	parentKey crypto.Signer,
+	isCA bool,
+	validityDays int,
+	keyUsage x509.KeyUsage,
+	opts ...CertOption,
+) ([]byte, error) {
+	if parentCert == nil || parentKey == nil {
+		return nil, errors.New("a parent certificate and key are required to sign an external public key")
+	}
+	return issueCertificate(subject, pub, parentCert, parentKey, isCA, validityDays, keyUsage, opts...)
+}
+
+// issueCertificate builds the certificate template and signs it. A nil parentCert means self-signed.
+func issueCertificate(
+	subject pkix.Name,
+	pub crypto.PublicKey,
+	parentCert *x509.Certificate,
+	signer crypto.Signer,
+	isCA bool,
+	validityDays int,
+	keyUsage x509.KeyUsage,
+	opts ...CertOption,
+) ([]byte, error) {
 	template := x509.Certificate{
-		SerialNumber:          serialNumber,
 		Subject:               subject,
-		NotBefore:             notBefore,
-		NotAfter:              notAfter,
 		IsCA:                  isCA,
 		BasicConstraintsValid: true,
 	}
 
-	// If it's a CA, automatically add CertSign to keyUsage.
+	// If it's a CA, automatically add CertSign and CRLSign to keyUsage.
 	if isCA {
-		keyUsage |= x509.KeyUsageCertSign
+		keyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
 		template.MaxPathLenZero = false
 		template.MaxPathLen = 1
 	}
 	template.KeyUsage = keyUsage
 
-	// Self-signed if parentCert/key is nil
+	for _, opt := range opts {
+		if err := opt(&template); err != nil {
+			return nil, err
+		}
+	}
+	return signTemplate(&template, pub, parentCert, signer, validityDays)
+}
+
+// WithValidity sets an explicit validity period instead of starting now and lasting validityDays.
+func WithValidity(notBefore, notAfter time.Time) CertOption {
+	return func(template *x509.Certificate) error {
+		if !notAfter.After(notBefore) {
+			return fmt.Errorf("validity end %s is not after its start %s", notAfter.Format(time.RFC3339), notBefore.Format(time.RFC3339))
+		}
+		template.NotBefore = notBefore
+		template.NotAfter = notAfter
+		return nil
+	}
+}
+
+// WithDistribution points relying parties to the issuer's CRLs (CRL distribution points), its
+// certificate (AIA caIssuers) and its OCSP responders. Empty lists leave the extension out.
+func WithDistribution(crlURLs, caIssuersURLs, ocspURLs []string) CertOption {
+	return func(template *x509.Certificate) error {
+		template.CRLDistributionPoints = crlURLs
+		template.IssuingCertificateURL = caIssuersURLs
+		template.OCSPServer = ocspURLs
+		return nil
+	}
+}
+
+// signTemplate assigns a fresh serial number and, unless already set, a validity period to template and signs it.
+// A nil parentCert means self-signed.
+func signTemplate(
+	template *x509.Certificate,
+	pub crypto.PublicKey,
+	parentCert *x509.Certificate,
+	signer crypto.Signer,
+	validityDays int,
+) ([]byte, error) {
+	serialNumber, err := NewSerialNumber()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template.SerialNumber = serialNumber
+	if template.NotBefore.IsZero() {
+		if template.NotBefore, err = Now(); err != nil {
+			return nil, err
+		}
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = template.NotBefore.Add(time.Duration(validityDays) * 24 * time.Hour)
+	}
+
 	var certBytes []byte
-	if parentCert == nil || parentKey == nil {
-		certBytes, err = x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if parentCert == nil {
+		certBytes, err = x509.CreateCertificate(Rand, template, template, pub, signer)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
+			return nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
 		}
 	} else {
-		certBytes, err = x509.CreateCertificate(rand.Reader, &template, parentCert, &priv.PublicKey, parentKey)
+		certBytes, err = x509.CreateCertificate(Rand, template, parentCert, pub, signer)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+			return nil, fmt.Errorf("failed to create certificate: %w", err)
 		}
 	}
 
@@ -120,13 +304,12 @@ func GenerateKeyAndCert(
 		Type:  "CERTIFICATE",
 		Bytes: certBytes,
 	})
-
-	return certPEM, priv, nil
+	return certPEM, nil
 }
 
 // ParseCertificateFromFile reads a PEM certificate from file and returns *x509.Certificate
 func ParseCertificateFromFile(path string) (*x509.Certificate, error) {
-	data, err := os.ReadFile(path)
+	data, err := ReadInput(path)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read certificate file '%s': %w", path, err)
 	}
@@ -141,65 +324,310 @@ func ParseCertificateFromFile(path string) (*x509.Certificate, error) {
 	return cert, nil
 }
 
-// WriteCertificateToFile writes a PEM certificate to the specified file
+// ParseCertificatesFromFile reads every certificate in a PEM file, such as a chain file, or the
+// certificate of a DER file
+func ParseCertificatesFromFile(path string) ([]*x509.Certificate, error) {
+	data, err := ReadInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate file '%s': %w", path, err)
+	}
+	if cert, err := x509.ParseCertificate(data); err == nil {
+		return []*x509.Certificate{cert}, nil
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse x509 certificate in '%s': %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in '%s'", path)
+	}
+	return certs, nil
+}
+
+// WriteCertificateToFile writes a PEM certificate to the specified file with Output.Certs permissions, described in comments if PEMInfo is set
 func WriteCertificateToFile(certPEM []byte, outPath string) error {
-	return os.WriteFile(outPath, certPEM, 0644)
+	if PEMInfo {
+		certPEM = AnnotatePEM(certPEM)
+	}
+	return writeOutputFile(outPath, certPEM, Output.Certs, DefaultCertMode)
 }
 
 // WriteECPrivateKeyToFile writes an ECDSA private key to a file in PEM format (type: "EC PRIVATE KEY").
 func WriteECPrivateKeyToFile(privKey *ecdsa.PrivateKey, outPath string) error {
-	keyBytes, err := x509.MarshalECPrivateKey(privKey)
+	return WritePrivateKeyToFile(privKey, outPath, KeyFormatSEC1)
+}
+
+// CombineSharesFromFiles reconstructs the private key bytes from multiple share files
+func CombineSharesFromFiles(paths []string) ([]byte, error) {
+	shares, err := ReadShareFiles(paths)
 	if err != nil {
-		return fmt.Errorf("failed to marshal ECDSA private key: %w", err)
+		return nil, err
+	}
+	return CombineShares(shares)
+}
+
+// CombineShares reconstructs the private key bytes from already parsed shares.
+// When the shares carry metadata, a missing quorum is reported before attempting the combine.
+func CombineShares(shares []*Share) ([]byte, error) {
+	if provided, threshold := QuorumStatus(shares); provided < threshold {
+		return nil, fmt.Errorf("quorum not reached: %d of %d required shares provided", provided, threshold)
+	}
+	for _, s := range shares {
+		if s.Locked() {
+			return nil, fmt.Errorf("share '%s' is encrypted and has not been unlocked", s.Path)
+		}
+		// A verifiable share proves on its own whether it is intact
+		if s.Commitments != nil {
+			if err := s.Commitments.Verify(s.Index, s.Data); err != nil {
+				return nil, fmt.Errorf("share '%s' (#%d %s) fails VSS verification: %w", s.Path, s.Index, s.Custodian(), err)
+			}
+		}
 	}
-	block := &pem.Block{
-		Type:  "EC PRIVATE KEY",
-		Bytes: keyBytes,
+	keyBytes, err := combineKey(shares)
+	if err != nil {
+		return nil, err
+	}
+	keyID := ""
+	for _, s := range shares {
+		if s.HasMetadata() {
+			keyID = s.KeyID
+		}
 	}
-	pemBytes := pem.EncodeToMemory(block)
-	return os.WriteFile(outPath, pemBytes, 0600)
+	if keyID == "" || combinedKeyID(keyBytes) == keyID {
+		return keyBytes, nil
+	}
+	secmem.Wipe(keyBytes)
+
+	// Every share passed its checksum, so one was altered consistently or comes from another key
+	// with a forged Key-Id. With spare shares, the odd one out is the share whose removal fixes the key.
+	if _, threshold := QuorumStatus(shares); len(shares) > threshold {
+		for i, s := range shares {
+			rest := append(append([]*Share{}, shares[:i]...), shares[i+1:]...)
+			if candidate, err := combineKey(rest); err == nil {
+				ok := combinedKeyID(candidate) == keyID
+				secmem.Wipe(candidate)
+				if ok {
+					return nil, fmt.Errorf("share '%s' (#%d %s) is inconsistent with the others: it is corrupted or belongs to a different key",
+						s.Path, s.Index, s.Custodian())
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("the shares do not reconstruct key %s: one of them is corrupted or belongs to a different key; provide one more share to identify it", keyID)
 }
 
-// CombineSharesFromFiles reconstructs the private key bytes from multiple share files
-func CombineSharesFromFiles(paths []string) ([]byte, error) {
-	var shares [][]byte
-	for _, path := range paths {
-		raw, err := os.ReadFile(path)
-		if err != nil {
-			return nil, fmt.Errorf("cannot read share file '%s': %w", path, err)
+// combineKey combines the shares' data into the key bytes, opening the wrapped key of envelope shares.
+func combineKey(shares []*Share) ([]byte, error) {
+	data, err := combineShareData(shares)
+	if err != nil || len(shares) == 0 || !shares[0].Envelope {
+		return data, err
+	}
+	defer secmem.Wipe(data)
+	return openEnvelope(shares, data)
+}
+
+// combineShareData runs the Shamir combine, or the VSS interpolation, on the shares' data.
+func combineShareData(shares []*Share) ([]byte, error) {
+	if len(shares) > 0 && shares[0].Commitments != nil {
+		points := make(map[int][]byte)
+		for _, s := range shares {
+			if s.Commitments == nil {
+				return nil, fmt.Errorf("'%s' is not a VSS share like '%s'", s.Path, shares[0].Path)
+			}
+			points[s.Index] = s.Data
 		}
-		decoded, err := base64.StdEncoding.DecodeString(string(raw))
+		key, err := vss.Combine(shares[0].Commitments.Curve, points)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode base64 from '%s': %w", path, err)
+			return nil, fmt.Errorf("VSS combine error: %w", err)
 		}
-		shares = append(shares, decoded)
+		defer secmem.WipeKey(key)
+		keyBytes, err := x509.MarshalECPrivateKey(key)
+		secmem.Lock(keyBytes)
+		return keyBytes, err
 	}
-	keyBytes, err := shamir.Combine(shares)
+	var parts [][]byte
+	for _, s := range shares {
+		parts = append(parts, s.Data)
+	}
+	keyBytes, err := shamir.Combine(parts)
 	if err != nil {
 		return nil, fmt.Errorf("shamir combine error: %w", err)
 	}
+	secmem.Lock(keyBytes)
 	return keyBytes, nil
 }
 
-// SplitKeyAndWriteShares splits a private key into N shares with threshold T, writes each share to disk
-func SplitKeyAndWriteShares(privKey *ecdsa.PrivateKey, n, t int, sharePaths []string) error {
+// combinedKeyID returns the key identifier of combined key bytes, or "" if they are not a valid key.
+func combinedKeyID(keyBytes []byte) string {
+	key, err := ParsePrivateKeyDER(keyBytes)
+	if err != nil {
+		return ""
+	}
+	defer secmem.WipeKey(key)
+	id, err := KeyID(key)
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+// SplitOption customises how SplitKeyAndWriteShares shares a key.
+type SplitOption func(*splitConfig)
+
+type splitConfig struct {
+	commitmentsOut string
+	recipients     []*age.Recipient
+	tokenSeals     []*TokenSeal
+	wrappedKeyOut  string
+}
+
+// WithVerifiableShares splits the key with Feldman VSS instead of plain Shamir and publishes the
+// commitments at commitmentsOut, so each custodian can verify their share alone.
+func WithVerifiableShares(commitmentsOut string) SplitOption {
+	return func(c *splitConfig) { c.commitmentsOut = commitmentsOut }
+}
+
+// WithRecipients encrypts share i to the age recipient recipients[i], so shares can be handed out
+// over untrusted channels; it replaces passphrases.
+func WithRecipients(recipients []*age.Recipient) SplitOption {
+	return func(c *splitConfig) { c.recipients = recipients }
+}
+
+// WithTokenSeals encrypts share i under the key derived from its custodian's FIDO2 token by
+// EnrollToken; it replaces passphrases.
+func WithTokenSeals(seals []*TokenSeal) SplitOption {
+	return func(c *splitConfig) { c.tokenSeals = seals }
+}
+
+// SplitKeyAndWriteShares splits a private key into N shares with threshold T, writes each share to disk.
+// Custodians is optional; when given it must have N entries and is recorded in every share's metadata.
+// Passphrases is optional too; when given, share i is encrypted with passphrases[i]. Passphrases
+// and token secrets are wiped once the shares are written.
+func SplitKeyAndWriteShares(privKey *ecdsa.PrivateKey, n, t int, sharePaths []string, custodians []Custodian, passphrases [][]byte, opts ...SplitOption) error {
+	var cfg splitConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 	if len(sharePaths) != n {
 		return fmt.Errorf("number of share paths (%d) does not match n=%d", len(sharePaths), n)
 	}
+	if custodians == nil {
+		custodians = make([]Custodian, n)
+	}
+	if len(custodians) != n {
+		return fmt.Errorf("number of custodians (%d) does not match n=%d", len(custodians), n)
+	}
+	if passphrases != nil && len(passphrases) != n {
+		return fmt.Errorf("number of share passphrases (%d) does not match n=%d", len(passphrases), n)
+	}
+	if cfg.recipients != nil && len(cfg.recipients) != n {
+		return fmt.Errorf("number of share recipients (%d) does not match n=%d", len(cfg.recipients), n)
+	}
+	if cfg.tokenSeals != nil && len(cfg.tokenSeals) != n {
+		return fmt.Errorf("number of share tokens (%d) does not match n=%d", len(cfg.tokenSeals), n)
+	}
+	if (passphrases != nil && cfg.recipients != nil) || (passphrases != nil && cfg.tokenSeals != nil) || (cfg.recipients != nil && cfg.tokenSeals != nil) {
+		return errors.New("shares can be encrypted with passphrases, to recipients or to tokens, but only one of them")
+	}
+	if cfg.commitmentsOut != "" && cfg.wrappedKeyOut != "" {
+		return errors.New("VSS shares the key itself and cannot be combined with envelope encryption")
+	}
+	defer func() {
+		for _, seal := range cfg.tokenSeals {
+			seal.Wipe()
+		}
+		secmem.Wipe(passphrases...)
+	}()
 
 	keyBytes, err := x509.MarshalECPrivateKey(privKey)
 	if err != nil {
 		return fmt.Errorf("failed to marshal ECDSA private key: %w", err)
 	}
-
-	shares, err := shamir.Split(keyBytes, n, t)
+	secmem.Lock(keyBytes)
+	defer secmem.Wipe(keyBytes)
+	keyID, err := KeyID(privKey)
 	if err != nil {
-		return fmt.Errorf("shamir split error: %w", err)
+		return err
+	}
+	splitID := make([]byte, 8)
+	if _, err := io.ReadFull(Rand, splitID); err != nil {
+		return fmt.Errorf("failed to generate split identifier: %w", err)
+	}
+
+	var shares [][]byte
+	var commitments *vss.Commitments
+	if cfg.commitmentsOut != "" {
+		if shares, commitments, err = vss.Split(privKey, n, t, Rand); err != nil {
+			return fmt.Errorf("VSS split error: %w", err)
+		}
+		// Catch dealer errors before any share leaves the ceremony
+		for i, s := range shares {
+			if err := commitments.Verify(i+1, s); err != nil {
+				return fmt.Errorf("share %d fails VSS verification: %w", i+1, err)
+			}
+		}
+		if err := vss.WriteFile(cfg.commitmentsOut, commitments, keyID, hex.EncodeToString(splitID)); err != nil {
+			return err
+		}
+	} else {
+		secret := keyBytes
+		if cfg.wrappedKeyOut != "" {
+			if secret, err = writeWrappedKey(cfg.wrappedKeyOut, keyBytes, keyID, hex.EncodeToString(splitID)); err != nil {
+				return err
+			}
+			defer secmem.Wipe(secret)
+		}
+		if shares, err = shamir.Split(secret, n, t); err != nil {
+			return fmt.Errorf("shamir split error: %w", err)
+		}
 	}
+	defer secmem.Wipe(shares...)
 
 	for i, s := range shares {
-		b64 := base64.StdEncoding.EncodeToString(s)
-		err := os.WriteFile(sharePaths[i], []byte(b64), 0600)
+		share := &Share{
+			Data:        s,
+			KeyID:       keyID,
+			SplitID:     hex.EncodeToString(splitID),
+			Index:       i + 1,
+			Total:       n,
+			Threshold:   t,
+			Roster:      custodians,
+			Commitments: commitments,
+			Envelope:    cfg.wrappedKeyOut != "",
+			WrappedKey:  cfg.wrappedKeyOut,
+		}
+		encoded := EncodeShare(share)
+		if passphrases != nil {
+			if encoded, err = EncodeEncryptedShare(share, passphrases[i]); err != nil {
+				return fmt.Errorf("failed to encrypt share %d: %w", i+1, err)
+			}
+		}
+		if cfg.recipients != nil {
+			if encoded, err = EncodeWrappedShare(share, cfg.recipients[i]); err != nil {
+				return fmt.Errorf("failed to wrap share %d: %w", i+1, err)
+			}
+		}
+		if cfg.tokenSeals != nil {
+			if encoded, err = EncodeTokenShare(share, cfg.tokenSeals[i]); err != nil {
+				return fmt.Errorf("failed to seal share %d: %w", i+1, err)
+			}
+		}
+		err := writeOutputFile(sharePaths[i], encoded, Output.Shares, DefaultShareMode)
+		if passphrases == nil && cfg.recipients == nil && cfg.tokenSeals == nil {
+			clear(encoded) // holds the share in the clear
+		}
 		if err != nil {
 			return fmt.Errorf("failed to write share file '%s': %w", sharePaths[i], err)
 		}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"my-pki/internal/vss"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKey is the P-256 key of RFC 6979 appendix A.2.5.
+func testKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	d, _ := new(big.Int).SetString("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721", 16)
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = key.Curve.ScalarBaseMult(d.Bytes())
+	return key
+}
+
+// counter returns the stream 01 02 03 ..., for Rand.
+func counter() *bytes.Reader {
+	b := make([]byte, 256)
+	for i := range b {
+		b[i] = byte(i + 1)
+	}
+	return bytes.NewReader(b)
+}
+
+// splitShares splits key into n shares with threshold t in a temporary directory, drawing from
+// counter, and reads them back.
+func splitShares(t *testing.T, key *ecdsa.PrivateKey, n, threshold int, opts ...SplitOption) []*Share {
+	t.Helper()
+	saved := Rand
+	Rand = counter()
+	t.Cleanup(func() { Rand = saved })
+
+	dir := t.TempDir()
+	var paths []string
+	for i := range n {
+		paths = append(paths, filepath.Join(dir, fmt.Sprintf("share%d", i+1)))
+	}
+	if err := SplitKeyAndWriteShares(key, n, threshold, paths, nil, nil, opts...); err != nil {
+		t.Fatalf("SplitKeyAndWriteShares: %v", err)
+	}
+	shares, err := ReadShareFiles(paths)
+	if err != nil {
+		t.Fatalf("ReadShareFiles: %v", err)
+	}
+	return shares
+}
+
+func TestSplitVerifiableShares(t *testing.T) {
+	// The split identifier takes the first 8 bytes of Rand, the coefficient the next 32
+	shares := splitShares(t, testKey(t), 3, 2, WithVerifiableShares(filepath.Join(t.TempDir(), "vss.pem")))
+	want := []string{
+		"d2b9b4e452c884267c6e346b7cc7edab676adef75406ba329cac854f37358e49",
+		"dbc3bff05fd693368d80477f91de04c38084fa137124d952bdcea8735c5bb571",
+		"e4cdcafc6ce4a2469e925a93a6f41bdb999f152f8e42f872def0cb978181dc99",
+	}
+	for i, s := range shares {
+		if got := hex.EncodeToString(s.Data); got != want[i] {
+			t.Errorf("share %d = %s, want %s", i+1, got, want[i])
+		}
+		if s.SplitID != "0102030405060708" {
+			t.Errorf("share %d: split ID %s, want 0102030405060708", i+1, s.SplitID)
+		}
+		if err := s.Commitments.Verify(s.Index, s.Data); err != nil {
+			t.Errorf("share %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestCombineShares(t *testing.T) {
+	key := testKey(t)
+	keyID, err := KeyID(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifiable := splitShares(t, key, 3, 2, WithVerifiableShares(filepath.Join(t.TempDir(), "vss.pem")))
+	plain := splitShares(t, key, 3, 2)
+
+	// A share that passes VSS verification against its own commitments, but comes from a split of
+	// another key and claims this key's Key-Id
+	other := testKey(t)
+	other.D.Add(other.D, big.NewInt(1))
+	other.X, other.Y = other.Curve.ScalarBaseMult(other.D.Bytes())
+	otherShares, otherCommitments, err := vss.Split(other, 3, 2, counter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := *verifiable[2]
+	forged.Path, forged.Data, forged.Commitments = "forged", otherShares[2], otherCommitments
+
+	tampered := *verifiable[1]
+	tampered.Path, tampered.Data = "tampered", bytes.Clone(tampered.Data)
+	tampered.Data[0] ^= 0x80
+
+	// A Shamir share altered after its checksum was checked
+	altered := *plain[1]
+	altered.Path, altered.Data = "altered", bytes.Clone(altered.Data)
+	altered.Data[3] ^= 0x01
+
+	tests := []struct {
+		name    string
+		shares  []*Share
+		wantErr string
+	}{
+		{"vss quorum", verifiable[:2], ""},
+		{"vss other pair", []*Share{verifiable[2], verifiable[0]}, ""},
+		{"vss all", verifiable, ""},
+		{"shamir quorum", plain[1:], ""},
+		{"below quorum", verifiable[:1], "quorum not reached: 1 of 2"},
+		{"vss tampered", []*Share{verifiable[0], &tampered}, "'tampered' (#2 unlabelled) fails VSS verification"},
+		{"vss forged, spare share", []*Share{verifiable[0], verifiable[1], &forged}, "share 'forged' (#3 unlabelled) is inconsistent with the others"},
+		{"vss forged first", []*Share{&forged, verifiable[0], verifiable[1]}, "share 'forged' (#3 unlabelled) is inconsistent with the others"},
+		{"vss forged, no spare", []*Share{verifiable[0], &forged}, "provide one more share to identify it"},
+		{"shamir altered, spare share", []*Share{plain[0], &altered, plain[2]}, "share 'altered' (#2 unlabelled) is inconsistent with the others"},
+		{"shamir altered, no spare", []*Share{&altered, plain[2]}, "provide one more share to identify it"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyBytes, err := CombineShares(tt.shares)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("CombineShares = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CombineShares: %v", err)
+			}
+			if got := combinedKeyID(keyBytes); got != keyID {
+				t.Errorf("CombineShares reconstructed key %s, want %s", got, keyID)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+// Package vss implements Feldman verifiable secret sharing of ECDSA private keys.
+//
+// The private scalar d is the constant term of a random polynomial f of degree t-1 over the
+// curve order, and share i is f(i). The dealer publishes commitments C_j = a_j·G to the
+// polynomial's coefficients, so C_0 is the key's public point. Any custodian can then check their
+// share alone, s_i·G = Σ C_j·i^j, and that the commitments belong to the CA's certificate,
+// catching dealer or transcription errors long before a ceremony needs the share.
+package vss
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Scheme is the share scheme name recorded in the metadata of Feldman VSS shares.
+const Scheme = "feldman-vss"
+
+const commitmentsPEMType = "GOSEC VSS COMMITMENTS"
+
+// Commitments are the dealer's public commitments to a sharing polynomial.
+type Commitments struct {
+	Curve  elliptic.Curve
+	Points [][]byte // compressed, C_0 first
+}
+
+// PathForCA returns the published commitments location for a CA certificate, e.g. "rootCA.pem" -> "rootCA.vss.pem".
+func PathForCA(caPemPath string) string {
+	return strings.TrimSuffix(caPemPath, filepath.Ext(caPemPath)) + ".vss.pem"
+}
+
+// CurveByName returns the NIST curve with the given name, e.g. "P-256".
+func CurveByName(name string) (elliptic.Curve, error) {
+	for _, c := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		if c.Params().Name == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported curve '%s'", name)
+}
+
+// Split shares key's private scalar between n custodians with threshold t, drawing coefficients from random.
+// Share i (1-based) is returned at index i-1 as a fixed-length big-endian scalar.
+func Split(key *ecdsa.PrivateKey, n, t int, random io.Reader) ([][]byte, *Commitments, error) {
+	if t < 2 || t > n || n > 255 {
+		return nil, nil, fmt.Errorf("invalid threshold t=%d for n=%d (need 2 <= t <= n <= 255)", t, n)
+	}
+	curve := key.Curve
+	order := curve.Params().N
+	coeffs := []*big.Int{new(big.Int).Set(key.D)}
+	for len(coeffs) < t {
+		a, err := rand.Int(random, order)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate coefficient: %w", err)
+		}
+		coeffs = append(coeffs, a)
+	}
+	defer func() {
+		for _, a := range coeffs {
+			clear(a.Bits())
+		}
+	}()
+
+	c := &Commitments{Curve: curve}
+	for _, a := range coeffs {
+		x, y := curve.ScalarBaseMult(a.Bytes())
+		c.Points = append(c.Points, elliptic.MarshalCompressed(curve, x, y))
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		// Horner's rule: f(x) = a_0 + x(a_1 + x(a_2 + ...))
+		x := big.NewInt(int64(i + 1))
+		s := new(big.Int)
+		for j := len(coeffs) - 1; j >= 0; j-- {
+			s.Mul(s, x)
+			s.Add(s, coeffs[j])
+			s.Mod(s, order)
+		}
+		shares[i] = s.FillBytes(make([]byte, scalarSize(curve)))
+		clear(s.Bits())
+	}
+	return shares, c, nil
+}
+
+// Verify checks that share is f(index) for the committed polynomial.
+func (c *Commitments) Verify(index int, share []byte) error {
+	if len(share) != scalarSize(c.Curve) {
+		return fmt.Errorf("share has %d bytes, expected %d", len(share), scalarSize(c.Curve))
+	}
+	order := c.Curve.Params().N
+	s := new(big.Int).SetBytes(share)
+	defer clear(s.Bits())
+	if s.Sign() == 0 || s.Cmp(order) >= 0 {
+		return errors.New("share is out of range")
+	}
+	lx, ly := c.Curve.ScalarBaseMult(share)
+
+	var rx, ry *big.Int
+	power := big.NewInt(1)
+	x := big.NewInt(int64(index))
+	for j, p := range c.Points {
+		cx, cy := elliptic.UnmarshalCompressed(c.Curve, p)
+		if cx == nil {
+			return fmt.Errorf("commitment %d is not a valid point", j)
+		}
+		tx, ty := c.Curve.ScalarMult(cx, cy, power.Bytes())
+		if rx == nil {
+			rx, ry = tx, ty
+		} else {
+			rx, ry = c.Curve.Add(rx, ry, tx, ty)
+		}
+		power.Mul(power, x).Mod(power, order)
+	}
+	if rx == nil || lx.Cmp(rx) != 0 || ly.Cmp(ry) != 0 {
+		return errors.New("share is inconsistent with the commitments")
+	}
+	return nil
+}
+
+// Matches reports whether the commitments share the secret key of pub.
+func (c *Commitments) Matches(pub *ecdsa.PublicKey) bool {
+	return pub.Curve == c.Curve && len(c.Points) > 0 &&
+		bytes.Equal(c.Points[0], elliptic.MarshalCompressed(pub.Curve, pub.X, pub.Y))
+}
+
+// Threshold returns the number of shares needed to reconstruct the key.
+func (c *Commitments) Threshold() int { return len(c.Points) }
+
+// Encode returns the commitments as hex, for share metadata.
+func (c *Commitments) Encode() string { return hex.EncodeToString(bytes.Join(c.Points, nil)) }
+
+// Fingerprint returns a short digest of the commitments that custodians can compare out of band.
+func (c *Commitments) Fingerprint() string {
+	sum := sha256.Sum256(bytes.Join(c.Points, nil))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Parse decodes commitments encoded by Encode.
+func Parse(curveName, encoded string) (*Commitments, error) {
+	curve, err := CurveByName(curveName)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(encoded)
+	pointSize := 1 + (curve.Params().BitSize+7)/8
+	if err != nil || len(raw) == 0 || len(raw)%pointSize != 0 {
+		return nil, errors.New("malformed commitments")
+	}
+	c := &Commitments{Curve: curve}
+	for len(raw) > 0 {
+		c.Points = append(c.Points, raw[:pointSize])
+		raw = raw[pointSize:]
+	}
+	return c, nil
+}
+
+// Combine reconstructs the private key from at least threshold shares, keyed by 1-based index.
+func Combine(curve elliptic.Curve, shares map[int][]byte) (*ecdsa.PrivateKey, error) {
+	order := curve.Params().N
+	d := new(big.Int)
+	for i, si := range shares {
+		// Lagrange coefficient of share i at x = 0: Π x_j / (x_j - x_i)
+		num, den := big.NewInt(1), big.NewInt(1)
+		for j := range shares {
+			if j == i {
+				continue
+			}
+			num.Mul(num, big.NewInt(int64(j))).Mod(num, order)
+			den.Mul(den, big.NewInt(int64(j-i))).Mod(den, order)
+		}
+		if den.ModInverse(den, order) == nil {
+			return nil, errors.New("duplicate share index")
+		}
+		term := new(big.Int).SetBytes(si)
+		term.Mul(term, num).Mul(term, den).Mod(term, order)
+		d.Add(d, term).Mod(d, order)
+		clear(term.Bits())
+	}
+	if d.Sign() == 0 {
+		return nil, errors.New("shares reconstruct an invalid key")
+	}
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(d.FillBytes(make([]byte, scalarSize(curve))))
+	return key, nil
+}
+
+// WriteFile publishes the commitments of a split with its identifying metadata.
+func WriteFile(path string, c *Commitments, keyID, splitID string) error {
+	block := &pem.Block{
+		Type: commitmentsPEMType,
+		Headers: map[string]string{
+			"Curve":     c.Curve.Params().Name,
+			"Key-Id":    keyID,
+			"Split-Id":  splitID,
+			"Threshold": strconv.Itoa(c.Threshold()),
+		},
+		Bytes: bytes.Join(c.Points, nil),
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0644); err != nil {
+		return fmt.Errorf("failed to write commitments to '%s': %w", path, err)
+	}
+	return nil
+}
+
+// ReadFile reads commitments published by WriteFile, with their split identifier.
+func ReadFile(path string) (*Commitments, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != commitmentsPEMType {
+		return nil, "", fmt.Errorf("'%s' does not contain %s", path, commitmentsPEMType)
+	}
+	c, err := Parse(block.Headers["Curve"], hex.EncodeToString(block.Bytes))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid commitments in '%s': %w", path, err)
+	}
+	return c, block.Headers["Split-Id"], nil
+}
+
+// scalarSize returns the byte length of scalars modulo the curve order.
+func scalarSize(curve elliptic.Curve) int {
+	return (curve.Params().N.BitLen() + 7) / 8
+}
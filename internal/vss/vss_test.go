@@ -0,0 +1,175 @@
+package vss
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// testKey is the P-256 key of RFC 6979 appendix A.2.5.
+func testKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	d, _ := new(big.Int).SetString("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721", 16)
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = key.Curve.ScalarBaseMult(d.Bytes())
+	return key
+}
+
+// counter returns the stream 01 02 03 ... from which Split draws its coefficients.
+func counter() *bytes.Reader {
+	b := make([]byte, 256)
+	for i := range b {
+		b[i] = byte(i + 1)
+	}
+	return bytes.NewReader(b)
+}
+
+func TestSplit(t *testing.T) {
+	// The coefficients are 0x0102..20 and 0x2122..40, the next 32-byte blocks of the stream
+	tests := []struct {
+		n, t   int
+		shares []string
+	}{
+		{3, 2, []string{
+			"cab1acdc4ac07c1e74662c6374bfe5a35f62d6ef4bfeb22a94a47d472f2d8641",
+			"cbb3afe04fc683267d70376f81cdf4b37074ea036114c942adbe98634c4ba561",
+			"ccb5b2e454cc8a2e867a427b8edc03c38186fd17762ae05ac6d8b37f6969c481",
+		}},
+		{4, 3, []string{
+			"ebd3d0006fe6a3469d90578fa1ee14d390950a238134e962cddeb8836c6bc581",
+			"503c3c71e45f1fc62218e4203686b1747856bc268ed6079e9eedba9144e17d10",
+			"f6e8ef2aa323ea96f8f5c709257bac757f63cf3fadfb32cfd62afdda9436d870",
+			"dfd9e82cac3503b72227004a6ecd05d72bee4e1390752dec8c22ecd961a58cff",
+		}},
+	}
+	key := testKey(t)
+	for _, tt := range tests {
+		shares, c, err := Split(key, tt.n, tt.t, counter())
+		if err != nil {
+			t.Fatalf("Split(%d, %d): %v", tt.n, tt.t, err)
+		}
+		if c.Threshold() != tt.t {
+			t.Errorf("Split(%d, %d): threshold %d", tt.n, tt.t, c.Threshold())
+		}
+		// C_0 is the public key of RFC 6979, compressed
+		if got, want := hex.EncodeToString(c.Points[0]), "0360fed4ba255a9d31c961eb74c6356d68c049b8923b61fa6ce669622e60f29fb6"; got != want {
+			t.Errorf("Split(%d, %d): C_0 = %s, want %s", tt.n, tt.t, got, want)
+		}
+		if !c.Matches(&key.PublicKey) {
+			t.Errorf("Split(%d, %d): commitments do not match the key", tt.n, tt.t)
+		}
+		for i, want := range tt.shares {
+			if got := hex.EncodeToString(shares[i]); got != want {
+				t.Errorf("Split(%d, %d): share %d = %s, want %s", tt.n, tt.t, i+1, got, want)
+			}
+		}
+	}
+}
+
+func TestSplitInvalidThreshold(t *testing.T) {
+	for _, nt := range [][2]int{{3, 1}, {2, 3}, {256, 2}} {
+		if _, _, err := Split(testKey(t), nt[0], nt[1], counter()); err == nil {
+			t.Errorf("Split(%d, %d) succeeded", nt[0], nt[1])
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	shares, c, err := Split(testKey(t), 3, 2, counter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := bytes.Clone(shares[1])
+	flipped[31] ^= 1
+	badPoint := &Commitments{Curve: c.Curve, Points: [][]byte{c.Points[0], append([]byte{0x02}, bytes.Repeat([]byte{0xff}, 32)...)}}
+	order := c.Curve.Params().N.FillBytes(make([]byte, 32))
+
+	tests := []struct {
+		name    string
+		c       *Commitments
+		index   int
+		share   []byte
+		wantErr string
+	}{
+		{"share 1", c, 1, shares[0], ""},
+		{"share 2", c, 2, shares[1], ""},
+		{"share 3", c, 3, shares[2], ""},
+		{"flipped bit", c, 2, flipped, "inconsistent"},
+		{"wrong index", c, 3, shares[1], "inconsistent"},
+		{"zero", c, 1, make([]byte, 32), "out of range"},
+		{"order", c, 1, order, "out of range"},
+		{"short", c, 1, shares[0][1:], "has 31 bytes"},
+		{"invalid commitment", badPoint, 1, shares[0], "not a valid point"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.c.Verify(tt.index, tt.share)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Verify: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Verify = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCombine(t *testing.T) {
+	key := testKey(t)
+	shares, _, err := Split(key, 4, 3, counter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		indexes []int
+		want    bool
+	}{
+		{"first three", []int{1, 2, 3}, true},
+		{"last three", []int{2, 3, 4}, true},
+		{"all four", []int{1, 2, 3, 4}, true},
+		{"below threshold", []int{1, 4}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := make(map[int][]byte)
+			for _, i := range tt.indexes {
+				points[i] = shares[i-1]
+			}
+			got, err := Combine(key.Curve, points)
+			if err != nil {
+				t.Fatalf("Combine: %v", err)
+			}
+			if ok := got.D.Cmp(key.D) == 0 && got.PublicKey.Equal(&key.PublicKey); ok != tt.want {
+				t.Errorf("Combine reconstructed the key: %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	_, c, err := Split(testKey(t), 3, 2, counter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse("P-256", c.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Fingerprint() != c.Fingerprint() || parsed.Threshold() != 2 {
+		t.Errorf("Parse(Encode()) = %x, want %x", parsed.Points, c.Points)
+	}
+	for _, encoded := range []string{"", "zz", c.Encode()[2:]} {
+		if _, err := Parse("P-256", encoded); err == nil {
+			t.Errorf("Parse(%q) succeeded", encoded)
+		}
+	}
+	if _, err := Parse("P-192", c.Encode()); err == nil {
+		t.Error("Parse accepted an unsupported curve")
+	}
+}
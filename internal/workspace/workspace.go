@@ -0,0 +1,281 @@
+// Package workspace seals a CA workspace directory (inventory, CA configurations, issuance logs,
+// certificates and other metadata) into a single encrypted container, so that a stolen operator
+// laptop leaks no issuance history or CA metadata while the workspace is sealed.
+//
+// The container is a gzip-compressed tar archive encrypted with AES-256-GCM. The key is derived
+// with scrypt from a passphrase, or with SHA-256 from a random key file.
+package workspace
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"my-pki/internal/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// magic starts every container; the byte after it is the key mode.
+const magic = "GOSECWS1"
+
+// Key modes.
+const (
+	modePassphrase = 1
+	modeKeyFile    = 2
+)
+
+// scrypt parameters for passphrase-derived keys. Sealing is rare, so they are stronger than those for key files.
+const (
+	scryptN = 1 << 17
+	scryptR = 8
+	scryptP = 1
+)
+
+// KeyFileSize is the length of a key file created by NewKeyFile.
+const KeyFileSize = 32
+
+// maxFileSize bounds each member read from a container.
+const maxFileSize = 256 << 20
+
+// Key unlocks a container: either a passphrase or the contents of a key file.
+type Key struct {
+	Passphrase []byte
+	KeyFile    []byte
+}
+
+func (k Key) mode() (byte, error) {
+	switch {
+	case len(k.Passphrase) > 0 && len(k.KeyFile) > 0:
+		return 0, errors.New("use either a passphrase or a key file, not both")
+	case len(k.Passphrase) > 0:
+		return modePassphrase, nil
+	case len(k.KeyFile) >= KeyFileSize:
+		return modeKeyFile, nil
+	case len(k.KeyFile) > 0:
+		return 0, fmt.Errorf("key file is too short (%d bytes, need at least %d)", len(k.KeyFile), KeyFileSize)
+	}
+	return 0, errors.New("a passphrase or key file is required")
+}
+
+func (k Key) derive(mode byte, salt []byte) ([]byte, error) {
+	switch mode {
+	case modePassphrase:
+		if len(k.Passphrase) == 0 {
+			return nil, errors.New("this workspace is sealed with a passphrase")
+		}
+		key, err := scrypt.Key(k.Passphrase, salt, scryptN, scryptR, scryptP, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive workspace key: %w", err)
+		}
+		return key, nil
+	case modeKeyFile:
+		if len(k.KeyFile) == 0 {
+			return nil, errors.New("this workspace is sealed with a key file")
+		}
+		h := sha256.New()
+		h.Write([]byte("gosec-workspace-v1\x00"))
+		h.Write(salt)
+		h.Write(k.KeyFile)
+		return h.Sum(nil), nil
+	}
+	return nil, fmt.Errorf("unknown workspace key mode %d", mode)
+}
+
+// NewKeyFile returns fresh random key file contents.
+func NewKeyFile() ([]byte, error) {
+	key := make([]byte, KeyFileSize)
+	if _, err := io.ReadFull(utils.Rand, key); err != nil {
+		return nil, fmt.Errorf("failed to generate key file: %w", err)
+	}
+	return key, nil
+}
+
+// Seal packs every regular file under dir and encrypts the archive with key. It returns the container
+// and the paths of the files it holds. skip, if non-nil, excludes paths (relative to dir,
+// slash-separated) from the container.
+func Seal(dir string, key Key, skip func(rel string) bool) ([]byte, []string, error) {
+	return SealWith(dir, key, skip, nil)
+}
+
+// SealWith is Seal with extra members, by slash-separated relative path, added to the container
+// after the files of dir, e.g. a manifest describing them.
+func SealWith(dir string, key Key, skip func(rel string) bool, extra map[string][]byte) ([]byte, []string, error) {
+	mode, err := key.mode()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	var files []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if skip != nil && skip(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("'%s' is not a regular file", path)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: rel, Mode: int64(info.Mode().Perm()), Size: int64(len(data)), ModTime: info.ModTime()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to pack workspace '%s': %w", dir, err)
+	}
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(extra[name])), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, nil, fmt.Errorf("failed to pack workspace '%s': %w", dir, err)
+		}
+		if _, err := tw.Write(extra[name]); err != nil {
+			return nil, nil, fmt.Errorf("failed to pack workspace '%s': %w", dir, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to pack workspace '%s': %w", dir, err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to pack workspace '%s': %w", dir, err)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(utils.Rand, salt); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := newAEAD(key, mode, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(utils.Rand, nonce); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header := append(append(append([]byte(magic), mode), salt...), nonce...)
+	return aead.Seal(header, nonce, buf.Bytes(), header), files, nil
+}
+
+// Unseal decrypts a container and extracts it into dir, which must not exist or be empty.
+func Unseal(data []byte, key Key, dir string) (int, error) {
+	headerLen := len(magic) + 1 + 16 + 12
+	if len(data) < headerLen || string(data[:len(magic)]) != magic {
+		return 0, errors.New("not a sealed GoSeC workspace")
+	}
+	mode := data[len(magic)]
+	salt := data[len(magic)+1 : len(magic)+17]
+	nonce := data[len(magic)+17 : headerLen]
+	aead, err := newAEAD(key, mode, salt)
+	if err != nil {
+		return 0, err
+	}
+	plain, err := aead.Open(nil, nonce, data[headerLen:], data[:headerLen])
+	if err != nil {
+		return 0, errors.New("failed to decrypt workspace: wrong passphrase or key file, or the container is corrupt")
+	}
+
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return 0, fmt.Errorf("'%s' is not empty; unseal into a new directory", dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return 0, fmt.Errorf("failed to create '%s': %w", dir, err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return 0, fmt.Errorf("corrupt workspace: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	count := 0
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("corrupt workspace: %w", err)
+		}
+		// Members are relative paths inside dir; anything else could escape it
+		if hdr.Typeflag != tar.TypeReg || !filepath.IsLocal(hdr.Name) || strings.Contains(hdr.Name, `\`) {
+			return count, fmt.Errorf("workspace contains unexpected member '%s'", hdr.Name)
+		}
+		if hdr.Size > maxFileSize {
+			return count, fmt.Errorf("workspace member '%s' is too large", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return count, fmt.Errorf("failed to create '%s': %w", filepath.Dir(target), err)
+		}
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return count, fmt.Errorf("failed to create '%s': %w", target, err)
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return count, fmt.Errorf("failed to write '%s': %w", target, err)
+		}
+		if err := f.Close(); err != nil {
+			return count, fmt.Errorf("failed to write '%s': %w", target, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func newAEAD(key Key, mode byte, salt []byte) (cipher.AEAD, error) {
+	k, err := key.derive(mode, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,166 @@
+// Package pki exposes certificate issuance to programs embedding this tool as a library.
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"my-pki/internal/utils"
+	"time"
+)
+
+// CertificateBuilder assembles a certificate step by step and signs it with SignWith.
+// Errors from the With* methods are deferred and returned by SignWith.
+//
+//	certPEM, err := pki.NewCertificateBuilder().
+//		WithSubject(pkix.Name{CommonName: "www.example.com"}).
+//		WithSANs("www.example.com", "10.0.0.1").
+//		WithEKU(x509.ExtKeyUsageServerAuth).
+//		WithValidity(90 * 24 * time.Hour).
+//		WithPublicKey(leafKey.Public()).
+//		WithIssuer(caCert).
+//		SignWith(caKey)
+type CertificateBuilder struct {
+	template  x509.Certificate
+	publicKey crypto.PublicKey
+	issuer    *x509.Certificate
+	validity  time.Duration
+	notBefore time.Time
+	err       error
+}
+
+// NewCertificateBuilder returns a builder for an end-entity certificate valid for 365 days.
+func NewCertificateBuilder() *CertificateBuilder {
+	return &CertificateBuilder{
+		template: x509.Certificate{BasicConstraintsValid: true},
+		validity: 365 * 24 * time.Hour,
+	}
+}
+
+// WithSubject sets the subject distinguished name.
+func (b *CertificateBuilder) WithSubject(subject pkix.Name) *CertificateBuilder {
+	b.template.Subject = subject
+	return b
+}
+
+// WithSANs adds subject alternative names. Each value is classified as an IP address,
+// an e-mail address (contains "@"), a URI (contains "://") or otherwise a DNS name.
+func (b *CertificateBuilder) WithSANs(names ...string) *CertificateBuilder {
+	sans, err := utils.ParseSANs(names)
+	if err != nil {
+		b.setErr(err)
+		return b
+	}
+	b.template.DNSNames = append(b.template.DNSNames, sans.DNSNames...)
+	b.template.IPAddresses = append(b.template.IPAddresses, sans.IPAddresses...)
+	b.template.EmailAddresses = append(b.template.EmailAddresses, sans.EmailAddresses...)
+	b.template.URIs = append(b.template.URIs, sans.URIs...)
+	return b
+}
+
+// WithEKU adds extended key usages.
+func (b *CertificateBuilder) WithEKU(usages ...x509.ExtKeyUsage) *CertificateBuilder {
+	b.template.ExtKeyUsage = append(b.template.ExtKeyUsage, usages...)
+	return b
+}
+
+// WithKeyUsage sets the key usage bits. CertSign and CRLSign are added automatically for CAs.
+func (b *CertificateBuilder) WithKeyUsage(usage x509.KeyUsage) *CertificateBuilder {
+	b.template.KeyUsage = usage
+	return b
+}
+
+// WithValidity sets how long the certificate is valid, counted from the issuance clock.
+func (b *CertificateBuilder) WithValidity(d time.Duration) *CertificateBuilder {
+	if d <= 0 {
+		b.setErr(fmt.Errorf("validity must be positive, got %s", d))
+	}
+	b.validity = d
+	return b
+}
+
+// WithNotBefore overrides the start of the validity period (default: utils.Now()).
+func (b *CertificateBuilder) WithNotBefore(t time.Time) *CertificateBuilder {
+	b.notBefore = t
+	return b
+}
+
+// WithExtension adds a raw extension. It must not duplicate an extension set by another method.
+func (b *CertificateBuilder) WithExtension(oid asn1.ObjectIdentifier, critical bool, value []byte) *CertificateBuilder {
+	b.template.ExtraExtensions = append(b.template.ExtraExtensions, pkix.Extension{Id: oid, Critical: critical, Value: value})
+	return b
+}
+
+// AsCA marks the certificate as a CA limited to maxPathLen intermediate CAs below it.
+func (b *CertificateBuilder) AsCA(maxPathLen int) *CertificateBuilder {
+	b.template.IsCA = true
+	b.template.MaxPathLen = maxPathLen
+	b.template.MaxPathLenZero = maxPathLen == 0
+	return b
+}
+
+// WithPublicKey sets the key being certified. When omitted, the signer's own key is certified.
+func (b *CertificateBuilder) WithPublicKey(pub crypto.PublicKey) *CertificateBuilder {
+	b.publicKey = pub
+	return b
+}
+
+// WithIssuer sets the issuing CA certificate. When omitted, the certificate is self-signed.
+func (b *CertificateBuilder) WithIssuer(issuer *x509.Certificate) *CertificateBuilder {
+	b.issuer = issuer
+	return b
+}
+
+// SignWith signs the certificate and returns it PEM-encoded.
+func (b *CertificateBuilder) SignWith(signer crypto.Signer) ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if signer == nil {
+		return nil, errors.New("a signer is required")
+	}
+
+	template := b.template
+	serialNumber, err := utils.NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serialNumber
+
+	template.NotBefore = b.notBefore
+	if template.NotBefore.IsZero() {
+		if template.NotBefore, err = utils.Now(); err != nil {
+			return nil, err
+		}
+	}
+	template.NotAfter = template.NotBefore.Add(b.validity)
+	if template.IsCA {
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+
+	pub := b.publicKey
+	if pub == nil {
+		pub = signer.Public()
+	}
+	parent := b.issuer
+	if parent == nil {
+		parent = &template
+	}
+
+	certBytes, err := x509.CreateCertificate(utils.Rand, &template, parent, pub, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), nil
+}
+
+// setErr records the first error encountered while building.
+func (b *CertificateBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}